@@ -0,0 +1,479 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/cmd"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+// subcommands are run with `roles <command> [flags]`, anything else is handled by the default scan flags in main.
+var subcommands = map[string]func(ctx *utils.Context, args []string) error{
+	"diff":         diffCommand,
+	"engagement":   engagementCommand,
+	"export":       exportCommand,
+	"harvest":      harvestCommand,
+	"import":       importCommand,
+	"lambda":       lambdaCommand,
+	"list-plugins": listPluginsCommand,
+	"merge":        mergeCommand,
+	"org-cleanup":  orgCleanupCommand,
+	"packs":        packsCommand,
+	"preview":      previewCommand,
+	"prune":        pruneCommand,
+	"report":       reportCommand,
+	"serve":        serveCommand,
+	"stats":        statsCommand,
+	"suggest":      suggestCommand,
+}
+
+// runSubcommand runs the subcommand named by args[0], it returns false if args doesn't start with a subcommand.
+func runSubcommand(ctx *utils.Context, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	command, ok := subcommands[args[0]]
+	if !ok {
+		return false
+	}
+
+	if err := command(ctx, args[1:]); err != nil {
+		ctx.Error.Fatalf("%s: %s", args[0], err)
+	}
+	return true
+}
+
+// envUsage explains how flags are set from the environment, in each usage message.
+var envUsage = "Flags can also be set with " + utils.EnvPrefix + " environment variables, like " +
+	utils.FlagEnv("rate-limit") + "=10 for -rate-limit 10."
+
+// storageFlags are shared by subcommands that work with stored results.
+type storageFlags struct {
+	Debug   bool
+	Profile string
+	Name    string
+	Storage string
+}
+
+// newFlagSet creates the flag set for a subcommand, args describes any positional arguments.
+func newFlagSet(name string, args string, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: roles %s [flags] %s\n\n%s\n\n%s\n\n", name, args, description, envUsage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args after setting flags from their ROLES_ environment variables, see utils.SetFlagsFromEnv.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := utils.SetFlagsFromEnv(fs); err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
+
+func addStorageFlags(fs *flag.FlagSet) *storageFlags {
+	f := &storageFlags{}
+	fs.BoolVar(&f.Debug, "debug", false, "Enable debug logging")
+	fs.StringVar(&f.Profile, "profile", "", "AWS profile to use for remote storage backends")
+	fs.StringVar(&f.Name, "name", "default", "Name of the scan")
+	fs.StringVar(&f.Storage, "storage", "", "Storage backend for scan results (default: ~/.roles)")
+	return f
+}
+
+func (f *storageFlags) apply(ctx *utils.Context) {
+	if f.Debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	}
+}
+
+// assumeRoleFlags are the options for assuming the role in each scanning account and the regions used in them, for the
+// commands that load them.
+type assumeRoleFlags struct {
+	ExternalID string
+	Duration   time.Duration
+	Policy     string
+	Accounts   string
+	Tag        string
+	AccountIds string
+	Role       string
+	Bastion    string
+	Regions    string
+}
+
+func addAssumeRoleFlags(fs *flag.FlagSet) *assumeRoleFlags {
+	f := &assumeRoleFlags{}
+	fs.StringVar(&f.ExternalID, "external-id", "", "External ID to pass when assuming the role in each scanning account")
+	fs.DurationVar(&f.Duration, "session-duration", 0, "How long the credentials of the role in each scanning account last, between 15m and 12h (default: 15m)")
+	fs.StringVar(&f.Policy, "session-policy", "", "Session policy to limit the role in each scanning account to: "+cmd.ScopedSessionPolicy+" for only the APIs roles uses, or the path of a policy document")
+	fs.StringVar(&f.Accounts, "scanning-accounts", "", "YAML or JSON file of the scanning accounts to use instead of the organization's tagged accounts, each with an account_id, role_arn, or both, and optionally an external_id and name")
+	fs.StringVar(&f.Tag, "scanning-account-tag", "", "key=value tag of the organization's accounts to scan from (default: "+utils.AccountTagKey+"="+utils.AccountTagValue+")")
+	fs.StringVar(&f.AccountIds, "scanning-account-ids", "", "Comma separated IDs of the organization's accounts to scan from, instead of the ones with -scanning-account-tag")
+	fs.StringVar(&f.Role, "scanning-account-role", "", "Role to assume in the scanning accounts without a "+utils.AccountRoleTag+" tag or a role_arn, like one trusting a delegated administrator of Organizations (default: "+utils.DefaultAccountRole+")")
+	fs.StringVar(&f.Bastion, "bastion-role", "", "ARN of a role to assume first and assume the role in each scanning account from, for organizations where the accounts' roles only trust it, limits -session-duration to 1h")
+	fs.StringVar(&f.Regions, "regions", "", "Comma separated regions of the scanning accounts to set up and scan from, or "+cmd.DefaultRegions+" for the regions every account has enabled without opting in (default: every enabled region)")
+	return f
+}
+
+// apply sets opts and regions to the options and regions the flags select.
+func (f *assumeRoleFlags) apply(opts *utils.AssumeRoleOptions, regions *utils.RegionSelection) error {
+	assumeRole, err := cmd.NewAssumeRoleOptions(f.ExternalID, f.Duration, f.Policy, f.Accounts, f.Role, f.Bastion)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	if f.AccountIds != "" {
+		ids = strings.Split(f.AccountIds, ",")
+	}
+	assumeRole.Selection, err = cmd.NewAccountSelection(f.Tag, ids)
+	if err != nil {
+		return err
+	}
+	if len(assumeRole.Accounts) != 0 && !assumeRole.Selection.IsDefault() {
+		return fmt.Errorf("cannot use -scanning-account-tag or -scanning-account-ids with -scanning-accounts")
+	}
+	selection, err := cmd.NewRegionSelection(strings.Split(f.Regions, ","))
+	if err != nil {
+		return err
+	}
+	*opts, *regions = assumeRole, selection
+	return nil
+}
+
+// selectsOrgAccounts reports whether the flags select the accounts -setup -org creates in the organization.
+func (f *assumeRoleFlags) selectsOrgAccounts() bool {
+	return f.Accounts == "" && f.Tag == "" && f.AccountIds == ""
+}
+
+func subcommandNames() string {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+func exportCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("export", "", "Export stored results for a scan as JSON, JSON lines, CSV, or a graph for BloodHound or Neo4j.")
+	storage := addStorageFlags(fs)
+	opts := cmd.ExportOpts{}
+	fs.StringVar(&opts.Format, "format", "jsonl", "Output format: json, jsonl, csv, opengraph (BloodHound), or cypher (Neo4j)")
+	fs.StringVar(&opts.Status, "status", "all", "Only export results with this status: all, exists, or not-exists")
+	fs.StringVar(&opts.Account, "account", "", "Only export results for this account ID")
+	fs.StringVar(&opts.Since, "since", "", "Only export results checked since a duration ago (30d, 36h) or a date (2024-01-02)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Export(ctx, opts)
+}
+
+func harvestCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("harvest", "", "Search GitHub code for IAM role and user names and write them as a -principals list. "+
+		"Requires a token in "+strings.Join(cmd.GitHubTokenEnvs, " or ")+".")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	opts := cmd.HarvestOpts{}
+	fs.StringVar(&opts.Org, "org", "", "Only search repositories owned by this GitHub user or organization")
+	fs.StringVar(&opts.Domain, "domain", "", "Search for files mentioning this domain, like the target's email domain")
+	fs.StringVar(&opts.Query, "query", "", "Additional GitHub code search terms")
+	fs.StringVar(&opts.Output, "o", "", "File to write the principals list to (default: stdout)")
+	fs.StringVar(&opts.AccountsOutput, "accounts-output", "", "File to write account IDs from found ARNs to")
+	fs.IntVar(&opts.MaxPages, "max-pages", 10, "Most pages of 100 results to fetch, code search returns at most 10")
+	fs.StringVar(&opts.APIURL, "api-url", "https://api.github.com", "GitHub API URL, for GitHub Enterprise Server")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	}
+
+	return cmd.Harvest(ctx, opts)
+}
+
+func listPluginsCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("list-plugins", "", "List the registered plugins, the regions and partitions they run in, their "+
+		"concurrency, and whether they're set up in the scanning accounts.")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	opts := cmd.ListPluginsOpts{}
+	fs.StringVar(&opts.Profile, "profile", "", "AWS profile plugins were set up with")
+	fs.BoolVar(&opts.Offline, "offline", false, "Only list the registered plugins, without loading the scanning accounts or checking their setup")
+	assumeRole := addAssumeRoleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	}
+	if err := assumeRole.apply(&opts.AssumeRole, &opts.Regions); err != nil {
+		return err
+	}
+
+	return cmd.ListPlugins(ctx, opts)
+}
+
+func orgCleanupCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("org-cleanup", "", "Unwind -setup -org: clean up the plugin resources in the accounts tagged as role scanning "+
+		"accounts and close them. AWS only allows closing 10% of an organization's member accounts in 30 days, run it "+
+		"again later to close the rest.")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	opts := cmd.OrgCleanupOpts{}
+	fs.StringVar(&opts.Profile, "profile", "", "AWS profile of the organization's management account")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "List the accounts that would be closed without changing anything")
+	fs.BoolVar(&opts.Yes, "yes", false, "Close the accounts without asking for confirmation")
+	assumeRole := addAssumeRoleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	}
+	if !assumeRole.selectsOrgAccounts() {
+		return fmt.Errorf("cannot use -scanning-accounts, -scanning-account-tag, or -scanning-account-ids with org-cleanup, it closes the accounts -setup -org created")
+	}
+	if err := assumeRole.apply(&opts.AssumeRole, &opts.Regions); err != nil {
+		return err
+	}
+
+	return cmd.OrgCleanup(ctx, opts)
+}
+
+func packsCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("packs", "", "List the wordlist packs in "+arn.PacksDir+", each is scanned with -pack <name>.")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	}
+
+	return cmd.Packs(ctx)
+}
+
+func previewCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("preview", "", "Print the first candidates the scan flags expand to, how many there are, and about how long "+
+		"scanning them takes, without making any AWS calls.")
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	opts := cmd.PreviewOpts{}
+	addInputFlags(fs, &opts.Opts)
+	fs.Float64Var(&opts.RateLimit, "rate-limit", 5, "Roles scanned per second the estimate is for")
+	fs.IntVar(&opts.Count, "count", 20, "Most candidates to print")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	}
+	if opts.RateLimit <= 0 || opts.RateLimit > 50 {
+		return fmt.Errorf("rate-limit must be more than 0 and at most 50")
+	}
+
+	return cmd.Preview(ctx, opts)
+}
+
+func serveCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("serve", "", "Run a long-lived scanner with a REST API, and optionally a gRPC API, to submit scans, check "+
+		"their status, stream their results, and query stored results.")
+	storage := addStorageFlags(fs)
+	opts := cmd.ServeOpts{}
+	fs.StringVar(&opts.Addr, "addr", "127.0.0.1:8080", "Address to listen on")
+	fs.StringVar(&opts.GRPCAddr, "grpc-addr", "", "Address to serve the gRPC API on, like 127.0.0.1:9090 (default: disabled)")
+	fs.StringVar(&opts.Token, "token", "", "Bearer token required on every request, set it with "+utils.FlagEnv("token")+" to keep it out of process listings")
+	fs.Float64Var(&opts.RateLimit, "rate-limit", 5, "Roles scanned per second, shared by all scans (max: 50)")
+	fs.IntVar(&opts.RateBurst, "rate-burst", 0, "Most roles scanned at once after the server has been idle, before it slows to -rate-limit (default: -rate-limit rounded up, max: 50)")
+	fs.BoolVar(&opts.SkipRootCheck, "skip-root-check", false, "Skip validating account root ARNs and scan principals directly")
+	fs.StringVar(&opts.Schedule, "schedule", "", "YAML or JSON file of scans to submit on cron schedules")
+	fs.StringVar(&opts.SQSQueue, "sqs-queue", "", "URL of an SQS queue to submit scans from, each message is a POST /scans body or candidate ARNs one per line")
+	assumeRole := addAssumeRoleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+	if err := assumeRole.apply(&opts.AssumeRole, &opts.Regions); err != nil {
+		return err
+	}
+	if opts.RateLimit <= 0 || opts.RateLimit > 50 {
+		return fmt.Errorf("rate-limit must be more than 0 and at most 50")
+	}
+	if opts.RateBurst < 0 || opts.RateBurst > 50 {
+		return fmt.Errorf("rate-burst must be between 0 and 50")
+	}
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Serve(ctx, opts)
+}
+
+func lambdaCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("lambda", "", "Run scans as a Lambda function, roles runs this when it's the bootstrap of a provided.al2023 "+
+		"function. Each invocation is a POST /scans request body, given directly, as the detail of an EventBridge event, "+
+		"or as the bodies of SQS messages. Set flags on the function with "+utils.EnvPrefix+" environment variables.")
+	storage := addStorageFlags(fs)
+	opts := cmd.LambdaOpts{}
+	fs.StringVar(&opts.NotifySNS, "notify-sns", "", "ARN of an SNS topic to publish new findings to")
+	fs.BoolVar(&opts.AlertAll, "alert-all", false, "Publish every principal found to -notify-sns, including the ones already stored as existing")
+	fs.Float64Var(&opts.RateLimit, "rate-limit", 5, "Roles scanned per second (max: 50)")
+	fs.IntVar(&opts.RateBurst, "rate-burst", 0, "Most roles scanned at once after the function has been idle, before it slows to -rate-limit (default: -rate-limit rounded up, max: 50)")
+	fs.BoolVar(&opts.SkipRootCheck, "skip-root-check", false, "Skip validating account root ARNs and scan principals directly")
+	assumeRole := addAssumeRoleFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+	if err := assumeRole.apply(&opts.AssumeRole, &opts.Regions); err != nil {
+		return err
+	}
+	if opts.RateLimit <= 0 || opts.RateLimit > 50 {
+		return fmt.Errorf("rate-limit must be more than 0 and at most 50")
+	}
+	if opts.RateBurst < 0 || opts.RateBurst > 50 {
+		return fmt.Errorf("rate-burst must be between 0 and 50")
+	}
+	if opts.AlertAll && opts.NotifySNS == "" {
+		return fmt.Errorf("cannot use -alert-all without -notify-sns")
+	}
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Lambda(ctx, opts)
+}
+
+func suggestCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("suggest", "", "Suggest new role names to scan for, learned from the role names found to exist in a scan. "+
+		"The output can be passed to -roles.")
+	storage := addStorageFlags(fs)
+	opts := cmd.SuggestOpts{}
+	fs.StringVar(&opts.Account, "account", "", "Only skip names already scanned in this account ID (default: skip names scanned in any account)")
+	fs.IntVar(&opts.Count, "count", 100, "Most suggestions to print")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Suggest(ctx, opts)
+}
+
+func importCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("import", "<file>...", "Import results from quiet-riot or an arn,status CSV file so they aren't rescanned.")
+	storage := addStorageFlags(fs)
+	opts := cmd.ImportOpts{}
+	fs.StringVar(&opts.Format, "format", "quiet-riot", "Input format: quiet-riot or csv")
+	fs.BoolVar(&opts.Overwrite, "overwrite", false, "Overwrite results that are already stored")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	opts.Paths = fs.Args()
+	return cmd.Import(ctx, opts)
+}
+
+func mergeCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("merge", "<source-name>...", "Merge the results of other scans into -name, the most recently checked result wins.")
+	storage := addStorageFlags(fs)
+	opts := cmd.MergeOpts{}
+	fs.StringVar(&opts.SourceStorage, "source-storage", "", "Storage backend to read the source scans from (default: -storage)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	opts.Sources = fs.Args()
+	return cmd.Merge(ctx, opts)
+}
+
+func pruneCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("prune", "", "Delete stored results for a scan that match every filter given.")
+	storage := addStorageFlags(fs)
+	opts := cmd.PruneOpts{}
+	fs.StringVar(&opts.Status, "status", "all", "Only prune results with this status: all, exists, or not-exists")
+	fs.StringVar(&opts.Account, "account", "", "Only prune results for this account ID")
+	fs.StringVar(&opts.OlderThan, "older-than", "", "Only prune results last checked before a duration ago (30d, 36h) or a date (2024-01-02)")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Print the ARNs that would be pruned without deleting them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Prune(ctx, opts)
+}
+
+func diffCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("diff", "", "Report principals that were added, removed, or changed status between two scans, or since a point in time.")
+	storage := addStorageFlags(fs)
+	opts := cmd.DiffOpts{}
+	fs.StringVar(&opts.Against, "against", "", "Name of an earlier scan to compare -name to")
+	fs.StringVar(&opts.Since, "since", "", "Compare -name to itself as of a duration ago (30d, 36h) or a date (2024-01-02)")
+	fs.IntVar(&opts.SinceRun, "since-run", 0, "Compare -name to itself as of the start of this run ID (see roles stats)")
+	fs.StringVar(&opts.Format, "format", "text", "Output format: text or jsonl")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Diff(ctx, opts)
+}
+
+func reportCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("report", "", "Write a Markdown or HTML report of the principals found by a scan, grouped by account and by "+
+		"vendor or comment with the date each was first seen, for a pentest report appendix.")
+	storage := addStorageFlags(fs)
+	opts := cmd.ReportOpts{}
+	fs.StringVar(&opts.Format, "format", "markdown", "Output format: markdown or html")
+	fs.StringVar(&opts.Account, "account", "", "Only report principals in this account ID")
+	fs.StringVar(&opts.Since, "since", "", "Only report principals checked since a duration ago (30d, 36h) or a date (2024-01-02)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Report(ctx, opts)
+}
+
+func statsCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("stats", "[name...]", "Report result counts by status, account, and age, and recent runs, for each scan name (default: -name).")
+	storage := addStorageFlags(fs)
+	opts := cmd.StatsOpts{}
+	fs.StringVar(&opts.Format, "format", "text", "Output format: text or json")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Storage = storage.Profile, storage.Storage
+	opts.Names = fs.Args()
+	if len(opts.Names) == 0 {
+		opts.Names = []string{storage.Name}
+	}
+	return cmd.Stats(ctx, opts)
+}
+
+func engagementCommand(ctx *utils.Context, args []string) error {
+	fs := newFlagSet("engagement", "", "Show or update the engagement stored with -name, scans are refused outside of its start and end dates.")
+	storage := addStorageFlags(fs)
+	opts := cmd.EngagementOpts{}
+	fs.StringVar(&opts.Client, "client", "", "Client the engagement is for")
+	fs.StringVar(&opts.Authorization, "authorization", "", "Reference to the document authorizing the testing, like a statement of work")
+	fs.StringVar(&opts.Start, "start", "", "Date (2024-01-02) or RFC 3339 timestamp the engagement starts")
+	fs.StringVar(&opts.End, "end", "", "Date (2024-01-02) or RFC 3339 timestamp the engagement ends, a date includes the whole day")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	storage.apply(ctx)
+
+	opts.Profile, opts.Name, opts.Storage = storage.Profile, storage.Name, storage.Storage
+	return cmd.Engagement(ctx, opts)
+}
@@ -9,44 +9,140 @@ import (
 	"context"
 	_ "embed"
 	"flag"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
 	"github.com/ryanjarv/roles/pkg/cmd"
 	"github.com/ryanjarv/roles/pkg/utils"
 	"os"
+	"strings"
 )
 
 func main() {
+	ctx := utils.NewContext(context.Background())
+
+	args := os.Args[1:]
+	// Lambda runs the bootstrap of a custom runtime without arguments.
+	if len(args) == 0 && os.Getenv(cmd.LambdaRuntimeAPIEnv) != "" {
+		args = []string{"lambda"}
+	}
+	if runSubcommand(ctx, args) {
+		return
+	}
+
 	opts := cmd.Opts{}
 
 	flag.BoolVar(&opts.Debug, "debug", false, "Enable debug logging")
+	flag.StringVar(&opts.DebugErrors, "debug-errors", "", "File to append the AWS error code, message, and request ID of every failed scan to as JSON lines, principals that don't exist aren't failures")
+	flag.StringVar(&opts.LogFormat, "log-format", "text", "Log format: text or json, JSON logs are one object per line on stderr")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "Only log errors and print the ARNs found without their comments")
 	flag.BoolVar(&opts.Clean, "clean", false, "Cleanup")
+	flag.BoolVar(&opts.Discover, "discover", false, "With -clean, find the plugin resources by name in every scanning account and region and delete them, whatever the plugins and concurrency were when they were set up")
 	flag.StringVar(&opts.Profile, "profile", "", "AWS profile to use for scanning")
 	flag.StringVar(&opts.Name, "name", "default", "Name of the scan")
-	flag.StringVar(&opts.RolesPath, "roles", "", "Additional role names")
-	flag.StringVar(&opts.PrincipalsPath, "principals", "", "Additional principal names prefixed with role/ or user/")
-	flag.StringVar(&opts.AccountsPath, "account-list", "", "Path to a file containing account IDs")
-	flag.StringVar(&opts.AccountsStr, "accounts", "", "Path to a file containing account IDs")
+	flag.StringVar(&opts.Storage, "storage", "", "Storage backend for scan results: file:///path/to/dir, dynamodb://table-name, or s3://bucket/prefix (default: ~/.roles)")
+	addInputFlags(flag.CommandLine, &opts)
+	assumeRole := addAssumeRoleFlags(flag.CommandLine)
+	flag.BoolVar(&opts.AccountShuffle, "account-shuffle", false, "Scan account root ARNs in a random order instead of by account ID")
 	flag.BoolVar(&opts.Force, "force", false, "Force rescan")
-	flag.BoolVar(&opts.Setup, "setup", false, "Run optional one-time account optimization setup")
-	flag.IntVar(&opts.RateLimit, "rate-limit", 5, "Roles scanned per second (default: 5, max: 50)")
-	flag.BoolVar(&opts.Json, "json", false, "Output results as JSON lines")
+	addSetupFlags(flag.CommandLine, &opts)
+	flag.Func("plugins", "Comma separated plugins to set up with -setup and scan with, like sns,sqs (default: every plugin in roles list-plugins)", func(value string) error {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.Plugins = append(opts.Plugins, name)
+			}
+		}
+		return nil
+	})
+	flag.Float64Var(&opts.RateLimit, "rate-limit", 5, "Roles scanned per second, under 1 for slower scans like 0.2 for one every 5 seconds (default: 5, max: 50)")
+	flag.IntVar(&opts.RateBurst, "rate-burst", 0, "Most roles scanned at once after the scan has been idle, before it slows to -rate-limit (default: -rate-limit rounded up, max: 50)")
+	flag.IntVar(&opts.AccountRateLimit, "account-rate-limit", 0, "Most roles scanned per second from each scanning account, the rest are scanned from other accounts (default: no limit)")
+	flag.BoolVar(&opts.Json, "json", false, "Output results as JSON lines, the same as -output json")
+	flag.StringVar(&opts.OutputFile, "o", "", "File to write results to instead of stdout, it's only replaced once the scan finishes")
+	flag.StringVar(&opts.ExecOnFound, "exec-on-found", "", "Command to run with sh for each principal found, {} is replaced with its ARN (default: added as the last argument)")
+	flag.StringVar(&opts.NotifySNS, "notify-sns", "", "ARN of an SNS topic in the scanning account to publish new findings to")
+	flag.BoolVar(&opts.AlertAll, "alert-all", false, "Publish every principal found to -notify-sns, including the ones already stored as existing")
+	flag.StringVar(&opts.Elasticsearch, "elasticsearch", "", "URL of an Elasticsearch or OpenSearch index to bulk index findings into, like https://localhost:9200/roles-findings (API key from $"+cmd.ElasticsearchAPIKeyEnv+")")
+	flag.BoolVar(&opts.ElasticsearchTelemetry, "elasticsearch-telemetry", false, "Also index per plugin stats during the scan and a run summary into the -elasticsearch index name with -telemetry appended")
+	flag.StringVar(&opts.OutputS3, "output-s3", "", "s3://bucket/prefix/ to upload the results and a run summary to when the scan finishes, under <name>/<start time>/")
+	flag.DurationVar(&opts.OutputS3Interval, "output-s3-interval", 0, "Also upload the results so far to -output-s3 this often while the scan runs, like 15m")
+	flag.StringVar(&opts.Sinks, "sinks", "", "Comma separated sinks to also send results to: stdout, file://<path>, webhook+<url>, s3://<bucket>/<prefix>, or elasticsearch+<url>, with options like s3://bucket/scans/?format=json&interval=15m")
+	flag.Func("filter", "Only output results matching a filter like comment~datadog, tag=prod, or type!=user, can be repeated and every filter must match", func(value string) error {
+		filter, err := cmd.ParseFilter(value)
+		if err != nil {
+			return err
+		}
+		opts.Filters = append(opts.Filters, filter)
+		return nil
+	})
+	flag.StringVar(&opts.Output, "output", "", "Result output format: text, json, or csv (default: text)")
+	flag.BoolVar(&opts.SkipRootCheck, "skip-root-check", false, "Skip validating account root ARNs and scan principals directly")
+	flag.BoolVar(&opts.LineBuffered, "line-buffered", false, "Guarantee each result is written to stdout as a single line as soon as it's found, for piping to tee or grep during long scans")
+	flag.BoolVar(&opts.TUI, "tui", false, "Show a live terminal UI with per plugin throughput, throttling, and findings while scanning, p pauses and r resumes the scan")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Print what would be scanned and by which plugins, using stored results but without making any other AWS calls")
 
-	flag.Parse()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: roles [flags]\n       roles <command> [flags]\n\nCommands: %s\n\n%s\n\nFlags:\n", subcommandNames(), envUsage)
+		flag.PrintDefaults()
+	}
 
-	ctx := utils.NewContext(context.Background())
+	if err := utils.SetFlagsFromEnv(flag.CommandLine); err != nil {
+		ctx.Error.Fatalf("%s", err)
+	}
+	flag.Parse()
 
-	if opts.Debug {
-		ctx.Debug.SetOutput(os.Stderr)
+	if err := ctx.SetLogFormat(opts.LogFormat); err != nil {
+		ctx.Error.Fatalf("%s", err)
+	}
+	if err := assumeRole.apply(&opts.AssumeRole, &opts.Regions); err != nil {
+		ctx.Error.Fatalf("%s", err)
+	}
+	if opts.Debug && opts.Quiet {
+		ctx.Error.Fatalf("cannot use both -debug and -quiet")
+	} else if opts.Debug {
+		ctx.SetLoggingLevel(utils.DebugLogLevel)
+	} else if opts.Quiet {
+		ctx.SetLoggingLevel(utils.ErrorLogLevel)
 	}
 
 	if opts.Setup && opts.Clean {
 		ctx.Error.Fatalf("cannot use both -setup and -clean")
-	} else if opts.Org && !opts.Setup {
-		ctx.Error.Fatalf("cannot use -org without -setup")
+	} else if opts.DryRun && (opts.Setup || opts.Clean) {
+		ctx.Error.Fatalf("cannot use -dry-run with -setup or -clean")
+	} else if opts.DebugErrors != "" && (opts.Setup || opts.Clean || opts.DryRun) {
+		ctx.Error.Fatalf("cannot use -debug-errors with -setup, -clean, or -dry-run, it records the errors of scans")
+	} else if opts.TUI && (opts.DryRun || opts.Setup || opts.Clean) {
+		ctx.Error.Fatalf("cannot use -tui with -dry-run, -setup, or -clean")
+	} else if opts.LineBuffered && opts.TUI {
+		ctx.Error.Fatalf("cannot use -line-buffered with -tui, results are held until the terminal UI stops")
+	} else if opts.AlertAll && opts.NotifySNS == "" {
+		ctx.Error.Fatalf("cannot use -alert-all without -notify-sns")
+	} else if opts.ElasticsearchTelemetry && opts.Elasticsearch == "" {
+		ctx.Error.Fatalf("cannot use -elasticsearch-telemetry without -elasticsearch")
+	} else if opts.OutputS3Interval != 0 && opts.OutputS3 == "" {
+		ctx.Error.Fatalf("cannot use -output-s3-interval without -output-s3")
+	} else if opts.Discover && !opts.Clean {
+		ctx.Error.Fatalf("cannot use -discover without -clean")
+	} else if len(opts.Plugins) != 0 && opts.Clean {
+		ctx.Error.Fatalf("cannot use -plugins with -clean, it cleans up every plugin")
+	} else if err := checkSetupFlags(opts, assumeRole.selectsOrgAccounts()); err != nil {
+		ctx.Error.Fatalf("%s", err)
 	} else if opts.RateLimit <= 0 || opts.RateLimit > 50 {
-		ctx.Error.Fatalf("rate-limit must be between 1 and 50")
+		ctx.Error.Fatalf("rate-limit must be more than 0 and at most 50")
+	} else if opts.RateBurst < 0 || opts.RateBurst > 50 {
+		ctx.Error.Fatalf("rate-burst must be between 0 and 50")
+	} else if opts.AccountRateLimit < 0 {
+		ctx.Error.Fatalf("account-rate-limit can't be negative")
+	} else if opts.Setup && (opts.EmitCFN != "" || opts.EmitTerraform != "") {
+		if err := cmd.EmitTemplates(opts.EmitCFN, opts.EmitTerraform, opts.Plugins); err != nil {
+			ctx.Error.Fatalf("running: %s", err)
+		}
+	} else if opts.Setup && opts.Plan {
+		if err := cmd.SetupPlan(ctx, setupOpts(opts)); err != nil {
+			ctx.Error.Fatalf("running: %s", err)
+		}
 	} else if opts.Setup {
 		// Run optional one-time account optimizer
-		if err := cmd.Setup(ctx, opts.Profile, opts.Org); err != nil {
+		if err := cmd.Setup(ctx, setupOpts(opts)); err != nil {
 			ctx.Error.Fatalf("running: %s", err)
 		}
 	} else if opts.Clean {
@@ -59,3 +155,135 @@ func main() {
 		}
 	}
 }
+
+// addSetupFlags adds the flags of -setup, check them with checkSetupFlags once they're parsed.
+func addSetupFlags(fs *flag.FlagSet, opts *cmd.Opts) {
+	fs.BoolVar(&opts.Setup, "setup", false, "Run optional one-time account optimization setup")
+	fs.IntVar(&opts.MaxAccounts, "max-accounts", 0, "With -setup -org, the most role scanning accounts to create (default: 99, max: 99)")
+	fs.StringVar(&opts.OrgRole, "org-role", "", "With -setup -org, the role Organizations creates in new accounts for scanning to assume, saved in their "+utils.AccountRoleTag+" tag (default: "+utils.DefaultAccountRole+")")
+	fs.BoolVar(&opts.StackSet, "stackset", false, "With -setup, deploy the plugin resources with the CloudFormation StackSet "+cmd.StackSetName+" instead of creating them directly, with -clean, delete it")
+	fs.StringVar(&opts.EmitCFN, "emit-cfn", "", "With -setup, write a CloudFormation template of the plugin resources to this file, or - for stdout, instead of creating them")
+	fs.StringVar(&opts.EmitTerraform, "emit-terraform", "", "With -setup, write a Terraform configuration of the plugin resources to this file, or - for stdout, instead of creating them")
+	fs.BoolVar(&opts.Org, "org", false, "With -setup, create an organization dedicated to scanning in the account of -profile if there isn't one and create role scanning accounts in it")
+	fs.BoolVar(&opts.SCP, "scp", false, "With -setup -org, move the accounts it creates into the "+cmd.ScanningOUName+" OU and attach a service control policy that denies everything besides the APIs scanning needs")
+	fs.Float64Var(&opts.Budget, "budget", 0, "With -setup, create a monthly cost budget of this many USD in each scanning account that emails -budget-email when it's close to being reached")
+	fs.StringVar(&opts.BudgetEmail, "budget-email", "", "With -setup -budget, the email address budget alerts are sent to")
+	fs.BoolVar(&opts.Validate, "validate", false, "With -setup, scan the root of each account and a role that doesn't exist with every plugin in every account and region once setup is done, and print which passed")
+	fs.BoolVar(&opts.Plan, "plan", false, "With -setup, print the accounts, regions, and plugin resources setup would create and how long it would take, without changing anything")
+}
+
+// checkSetupFlags returns an error if the setup flags in opts can't be used together, selectsOrgAccounts is whether the
+// scanning accounts are the ones -setup -org creates.
+func checkSetupFlags(opts cmd.Opts, selectsOrgAccounts bool) error {
+	if opts.Org && !opts.Setup {
+		return fmt.Errorf("cannot use -org without -setup")
+	}
+	if opts.Org && !selectsOrgAccounts {
+		return fmt.Errorf("cannot use -org with -scanning-accounts, -scanning-account-tag, or -scanning-account-ids, setup would create accounts that aren't selected")
+	}
+	if opts.SCP && !opts.Org {
+		return fmt.Errorf("cannot use -scp without -org")
+	}
+	if opts.MaxAccounts != 0 && !opts.Org {
+		return fmt.Errorf("cannot use -max-accounts without -org")
+	}
+	if opts.OrgRole != "" && !opts.Org {
+		return fmt.Errorf("cannot use -org-role without -org")
+	}
+	if opts.MaxAccounts < 0 || opts.MaxAccounts > cmd.MaxScanningAccounts {
+		return fmt.Errorf("max-accounts must be between 1 and %d", cmd.MaxScanningAccounts)
+	}
+	if opts.StackSet && !opts.Setup && !opts.Clean {
+		return fmt.Errorf("cannot use -stackset without -setup or -clean")
+	}
+	if len(opts.Plugins) != 0 && opts.StackSet {
+		return fmt.Errorf("cannot use -plugins with -stackset, the StackSet has the resources of every plugin")
+	}
+	if opts.Plan && !opts.Setup {
+		return fmt.Errorf("cannot use -plan without -setup")
+	}
+	if opts.Validate && !opts.Setup {
+		return fmt.Errorf("cannot use -validate without -setup")
+	}
+	if opts.Validate && (opts.Plan || opts.EmitCFN != "" || opts.EmitTerraform != "") {
+		return fmt.Errorf("cannot use -validate with -plan, -emit-cfn, or -emit-terraform, nothing is set up to validate")
+	}
+	if (opts.EmitCFN != "" || opts.EmitTerraform != "") && !opts.Setup {
+		return fmt.Errorf("cannot use -emit-cfn or -emit-terraform without -setup")
+	}
+	if (opts.EmitCFN != "" || opts.EmitTerraform != "") && (opts.Plan || opts.StackSet || opts.Org) {
+		return fmt.Errorf("cannot use -emit-cfn or -emit-terraform with -plan, -stackset, or -org")
+	}
+	if opts.Budget < 0 {
+		return fmt.Errorf("budget must be more than 0")
+	}
+	if (opts.Budget != 0 || opts.BudgetEmail != "") && !opts.Setup {
+		return fmt.Errorf("cannot use -budget or -budget-email without -setup")
+	}
+	if (opts.Budget != 0) != (opts.BudgetEmail != "") {
+		return fmt.Errorf("-budget and -budget-email have to be used together")
+	}
+	if opts.Budget != 0 && (opts.EmitCFN != "" || opts.EmitTerraform != "") {
+		return fmt.Errorf("cannot use -budget with -emit-cfn or -emit-terraform")
+	}
+	return nil
+}
+
+// setupOpts returns the options -setup runs with, from the flags addSetupFlags added.
+func setupOpts(opts cmd.Opts) cmd.SetupOpts {
+	return cmd.SetupOpts{
+		Profile:     opts.Profile,
+		AssumeRole:  opts.AssumeRole,
+		Regions:     opts.Regions,
+		Org:         opts.Org,
+		MaxAccounts: opts.MaxAccounts,
+		AccountRole: opts.OrgRole,
+		StackSet:    opts.StackSet,
+		Plugins:     opts.Plugins,
+		Budget:      opts.Budget,
+		BudgetEmail: opts.BudgetEmail,
+		SCP:         opts.SCP,
+		Validate:    opts.Validate,
+	}
+}
+
+// addInputFlags adds the flags that select what is scanned, they're shared by the scan and roles preview.
+func addInputFlags(fs *flag.FlagSet, opts *cmd.Opts) {
+	fs.StringVar(&opts.RolesPath, "roles", "", "Additional role names, a file, directory of .list files, .yaml/.json candidate file, or http(s) URL")
+	fs.StringVar(&opts.PrincipalsPath, "principals", "", "Additional principal names prefixed with role/ or user/, bare names are tried as both")
+	fs.StringVar(&opts.Wordlists, "wordlist", "", "Comma separated built-in role name lists to scan: "+strings.Join(arn.Wordlists(), ", "))
+	fs.StringVar(&opts.Packs, "pack", "", "Comma separated wordlist packs in "+arn.PacksDir+" to scan, see roles packs")
+	fs.StringVar(&opts.FromTerraform, "from-terraform", "", "Comma separated Terraform state files, .tf files, or directories to read aws_iam_role names from")
+	fs.StringVar(&opts.FromCloudFormation, "from-cloudformation", "", "Comma separated CloudFormation templates or directories like cdk.out to read AWS::IAM::Role names from")
+	fs.StringVar(&opts.CDKQualifiers, "cdk-qualifiers", "", "Comma separated CDK bootstrap qualifiers or lists of them to scan the bootstrap roles of, \"default\" is "+arn.DefaultCDKQualifier+" and {{chars \"a-z0-9\" 3}} brute forces part of one")
+	fs.StringVar(&opts.SSOPermissionSets, "sso-permission-sets", "", "Comma separated Identity Center permission set names or lists of them to scan AWSReservedSSO_ roles for (default with -sso-suffixes: "+strings.Join(arn.DefaultPermissionSets, ",")+")")
+	fs.StringVar(&opts.SSOSuffixes, "sso-suffixes", "", "Comma separated AWSReservedSSO_ role suffixes, observed role names, or lists of them")
+	fs.BoolVar(&opts.Permute, "permute", false, "Also try each role name in other naming conventions and with common prefixes, suffixes, and recent years")
+	fs.StringVar(&opts.PermutePrefixes, "permute-prefixes", "", "Comma separated prefixes -permute adds (default: "+strings.Join(arn.PermutationPrefixes, ",")+")")
+	fs.StringVar(&opts.PermuteSuffixes, "permute-suffixes", "", "Comma separated suffixes -permute adds (default: "+strings.Join(arn.PermutationSuffixes, ",")+")")
+	fs.IntVar(&opts.MaxExpansion, "max-expansion", arn.DefaultMaxExpansion, "Most names a single role or principal template can expand to with ranges and {{chars}}")
+	fs.IntVar(&opts.MaxCandidates, "max-candidates", arn.DefaultMaxCandidates, "Most candidates to generate before stopping instead of starting a scan that takes days, 0 is no limit")
+	fs.BoolVar(&opts.YesReally, "yes-really", false, "Generate and scan the candidates even if there are more than -max-candidates")
+	fs.StringVar(&opts.Env, "env", "", "Value of {{.Env}} in role and principal templates")
+	fs.StringVar(&opts.Stage, "stage", "", "Value of {{.Stage}} in role and principal templates")
+	fs.StringVar(&opts.Team, "team", "", "Value of {{.Team}} in role and principal templates")
+	fs.Func("var", "Template variable list like env=dev,staging,prod for {{.Vars.env}}, can be repeated (env, stage, and team are also {{.Env}}, {{.Stage}}, and {{.Team}})", func(value string) error {
+		name, values, err := arn.ParseVar(value)
+		if err != nil {
+			return err
+		}
+		if opts.Vars == nil {
+			opts.Vars = map[string][]string{}
+		}
+		opts.Vars[name] = append(opts.Vars[name], values...)
+		return nil
+	})
+	fs.StringVar(&opts.VarFile, "var-file", "", "File of template variable lists, one name=value,value list per line")
+	fs.StringVar(&opts.TryPaths, "try-paths", "", "Comma separated IAM paths to also try each role without a path under, \"common\" adds "+strings.Join(arn.CommonRolePaths, ", "))
+	fs.StringVar(&opts.AccountsPath, "account-list", "", "Path or http(s) URL of a file containing account IDs")
+	fs.StringVar(&opts.AccountsStr, "accounts", "", "Path to a file containing account IDs")
+	fs.StringVar(&opts.AccountRange, "account-range", "", "Range of account IDs to scan like 123456789000-123456789999, limited by -max-expansion")
+	fs.IntVar(&opts.AccountStride, "account-stride", 1, "Only scan every Nth account ID in -account-range")
+	fs.StringVar(&opts.ExcludeAccounts, "exclude-accounts", "", "Comma separated account IDs or lists of them to never scan, like out of scope accounts")
+	fs.StringVar(&opts.ExcludeRoles, "exclude-roles", "", "Comma separated principal names, globs like role/ci/*, or lists of them to never scan")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/cmd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseSetupFlags parses args with the setup flags and checks them.
+func parseSetupFlags(t *testing.T, args ...string) (cmd.Opts, error) {
+	t.Helper()
+	opts := cmd.Opts{}
+	fs := flag.NewFlagSet("roles", flag.ContinueOnError)
+	addSetupFlags(fs, &opts)
+	require.NoError(t, fs.Parse(args))
+	return opts, checkSetupFlags(opts, true)
+}
+
+func TestSetupFlags_Org(t *testing.T) {
+	opts, err := parseSetupFlags(t, "-setup", "-org", "-max-accounts", "3")
+	require.NoError(t, err)
+	setup := setupOpts(opts)
+	assert.True(t, setup.Org)
+	assert.Equal(t, 3, setup.MaxAccounts)
+
+	_, err = parseSetupFlags(t, "-setup", "-max-accounts", "3", "-plan")
+	assert.EqualError(t, err, "cannot use -max-accounts without -org")
+	_, err = parseSetupFlags(t, "-org")
+	assert.EqualError(t, err, "cannot use -org without -setup")
+	_, err = parseSetupFlags(t, "-setup", "-org", "-max-accounts", "100")
+	assert.EqualError(t, err, "max-accounts must be between 1 and 99")
+
+	opts = cmd.Opts{Setup: true, Org: true}
+	assert.EqualError(t, checkSetupFlags(opts, false), "cannot use -org with -scanning-accounts, -scanning-account-tag, or -scanning-account-ids, setup would create accounts that aren't selected")
+}
+
+func TestSetupFlags_OrgRole(t *testing.T) {
+	opts, err := parseSetupFlags(t, "-setup", "-org", "-org-role", "AWSControlTowerExecution")
+	require.NoError(t, err)
+	assert.Equal(t, "AWSControlTowerExecution", setupOpts(opts).AccountRole)
+
+	_, err = parseSetupFlags(t, "-setup", "-org-role", "AWSControlTowerExecution")
+	assert.EqualError(t, err, "cannot use -org-role without -org")
+}
+
+func TestSetupFlags_SCP(t *testing.T) {
+	opts, err := parseSetupFlags(t, "-setup", "-org", "-scp", "-max-accounts", "5")
+	require.NoError(t, err)
+	assert.True(t, setupOpts(opts).SCP)
+
+	_, err = parseSetupFlags(t, "-setup", "-scp")
+	assert.EqualError(t, err, "cannot use -scp without -org")
+	_, err = parseSetupFlags(t, "-setup", "-org", "-scp", "-emit-cfn", "-")
+	assert.EqualError(t, err, "cannot use -emit-cfn or -emit-terraform with -plan, -stackset, or -org")
+}
+
+func TestAssumeRoleFlags(t *testing.T) {
+	opts := cmd.Opts{}
+	fs := flag.NewFlagSet("roles", flag.ContinueOnError)
+	addSetupFlags(fs, &opts)
+	assumeRole := addAssumeRoleFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-setup", "-external-id", "engagement-1234", "-regions", "us-east-1,us-west-2"}))
+	require.NoError(t, assumeRole.apply(&opts.AssumeRole, &opts.Regions))
+
+	// The options reach setup through SetupOpts, not the logging context.
+	setup := setupOpts(opts)
+	assert.Equal(t, "engagement-1234", setup.AssumeRole.ExternalID)
+	assert.Equal(t, []string{"us-east-1", "us-west-2"}, setup.Regions.Regions)
+
+	require.NoError(t, fs.Parse([]string{"-regions", "us-east-7"}))
+	assert.ErrorContains(t, assumeRole.apply(&opts.AssumeRole, &opts.Regions), "unknown region us-east-7")
+}
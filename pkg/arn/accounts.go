@@ -0,0 +1,79 @@
+package arn
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxAccountID is the largest twelve digit AWS account ID.
+const maxAccountID = 999_999_999_999
+
+// AccountRange is an inclusive range of account IDs, every Stride-th ID from From is included.
+type AccountRange struct {
+	From, To int
+	Stride   int
+}
+
+// ParseAccountRange parses a range like 123456789000-123456789999, a stride of 0 includes every ID in the range.
+func ParseAccountRange(value string, stride int) (*AccountRange, error) {
+	start, end, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid account range %q: expected a range like 123456789000-123456789999", value)
+	}
+
+	from, err := parseAccountID(strings.TrimSpace(start))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseAccountID(strings.TrimSpace(end))
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid account range %q: starts after it ends", value)
+	}
+
+	if stride < 0 {
+		return nil, fmt.Errorf("invalid account stride %d: must be positive", stride)
+	} else if stride == 0 {
+		stride = 1
+	}
+
+	return &AccountRange{From: from, To: to, Stride: stride}, nil
+}
+
+func parseAccountID(value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 || id > maxAccountID {
+		return 0, fmt.Errorf("invalid account ID %q: expected up to twelve digits", value)
+	}
+	return id, nil
+}
+
+// Count is how many account IDs are in the range.
+func (r *AccountRange) Count() int {
+	return (r.To-r.From)/r.Stride + 1
+}
+
+// Accounts returns the account IDs in the range in ascending order, zero-padded to twelve digits.
+//
+// An error is returned without generating anything if there would be more than limit IDs, unless limit is 0.
+func (r *AccountRange) Accounts(limit int) ([]string, error) {
+	if limit > 0 && r.Count() > limit {
+		return nil, fmt.Errorf("account range %012d-%012d expands to %s accounts, more than the limit of %d", r.From, r.To, formatCount(r.Count()), limit)
+	}
+
+	accounts := make([]string, 0, r.Count())
+	for id := r.From; id <= r.To; id += r.Stride {
+		accounts = append(accounts, fmt.Sprintf("%012d", id))
+	}
+	return accounts, nil
+}
+
+func (r *AccountRange) String() string {
+	if r.Stride > 1 {
+		return fmt.Sprintf("%012d-%012d/%d", r.From, r.To, r.Stride)
+	}
+	return fmt.Sprintf("%012d-%012d", r.From, r.To)
+}
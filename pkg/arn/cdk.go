@@ -0,0 +1,51 @@
+package arn
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultCDKQualifier is the qualifier `cdk bootstrap` uses unless --qualifier is given.
+const DefaultCDKQualifier = "hnb659fds"
+
+// cdkBootstrapRoles are the roles `cdk bootstrap` creates, each is named cdk-<qualifier>-<role>-<account>-<region>.
+var cdkBootstrapRoles = []string{
+	"deploy-role",
+	"cfn-exec-role",
+	"file-publishing-role",
+	"image-publishing-role",
+	"lookup-role",
+}
+
+// cdkQualifierPattern matches the qualifiers CDK accepts, up to ten alphanumeric characters.
+var cdkQualifierPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,10}$`)
+
+// getCDKInputs returns the bootstrap roles for each candidate qualifier. Qualifiers are given inline or as lists, like
+// -exclude-accounts, "default" is DefaultCDKQualifier and generators like {{chars "a-z0-9" 3}} brute force part of a
+// qualifier, limited by -max-expansion.
+func getCDKInputs(qualifiers []string) (map[string]utils.Info, error) {
+	values, err := loadValues(qualifiers)
+	if err != nil {
+		return nil, fmt.Errorf("loading CDK qualifiers: %s", err)
+	}
+
+	result := map[string]utils.Info{}
+	for _, qualifier := range slices.Sorted(maps.Keys(values)) {
+		if qualifier == "default" {
+			qualifier = DefaultCDKQualifier
+		}
+		if !generatorPattern.MatchString(qualifier) && !cdkQualifierPattern.MatchString(qualifier) {
+			return nil, fmt.Errorf("invalid CDK qualifier %q: must be up to 10 letters and numbers", qualifier)
+		}
+
+		for _, role := range cdkBootstrapRoles {
+			name := fmt.Sprintf("role/cdk-%s-%s-{{.AccountId}}-{{.Region}}", qualifier, role)
+			result[name] = utils.Info{Comment: fmt.Sprintf(" CDK %s with qualifier %s", strings.ReplaceAll(role, "-", " "), qualifier)}
+		}
+	}
+	return result, nil
+}
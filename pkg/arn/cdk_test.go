@@ -0,0 +1,42 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCDKInputs(t *testing.T) {
+	qualifiersPath := filepath.Join(t.TempDir(), "qualifiers.list")
+	require.NoError(t, os.WriteFile(qualifiersPath, []byte("acme123 # From a leaked cdk.json\n"), 0o600))
+
+	got, err := getCDKInputs([]string{"default", qualifiersPath})
+	require.NoError(t, err)
+	assert.Len(t, got, 2*len(cdkBootstrapRoles))
+	assert.Equal(t, utils.Info{Comment: " CDK deploy role with qualifier hnb659fds"},
+		got["role/cdk-hnb659fds-deploy-role-{{.AccountId}}-{{.Region}}"])
+	assert.Contains(t, got, "role/cdk-acme123-lookup-role-{{.AccountId}}-{{.Region}}")
+
+	_, err = getCDKInputs([]string{"not-a-qualifier"})
+	assert.ErrorContains(t, err, `invalid CDK qualifier "not-a-qualifier"`)
+}
+
+func TestGetArns_CDKQualifierBruteForce(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr:   "123456789012",
+		CDKQualifiers: []string{`app{{chars "ab" 2}}`},
+		Regions:       map[string]utils.Info{"us-east-1": {}, "eu-west-1": {}},
+	})
+	require.NoError(t, err)
+
+	// 4 qualifiers, 5 roles, and 2 regions, plus the root.
+	assert.Len(t, got, 4*5*2+1)
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/cdk-appba-file-publishing-role-123456789012-eu-west-1")
+}
@@ -0,0 +1,343 @@
+package arn
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cfnTemplateExtensions are the files read from a CloudFormation directory, cdk.out uses .template.json.
+var cfnTemplateExtensions = []string{".json", ".yaml", ".yml", ".template"}
+
+// cfnSuffixVar is the template variable tried as the random suffix of generated role names.
+const cfnSuffixVar = "suffix"
+
+// cfnStackVar is the template variable used as the stack name when it isn't known from the file name.
+const cfnStackVar = "stack"
+
+// cfnTemplate is the part of a CloudFormation template needed to find role names.
+type cfnTemplate struct {
+	Parameters map[string]struct {
+		Default       any   `json:"Default" yaml:"Default"`
+		AllowedValues []any `json:"AllowedValues" yaml:"AllowedValues"`
+	} `json:"Parameters" yaml:"Parameters"`
+	Resources map[string]struct {
+		Type       string         `json:"Type" yaml:"Type"`
+		Properties map[string]any `json:"Properties" yaml:"Properties"`
+	} `json:"Resources" yaml:"Resources"`
+}
+
+// getCloudFormationInputs returns the roles declared in CloudFormation templates, including ones synthesized by CDK in
+// cdk.out, a directory is searched recursively.
+//
+// RoleName and Path are resolved like CloudFormation would where the value is known: parameters set with vars become
+// {{.Vars.name}}, otherwise their AllowedValues or Default are used, and AWS::AccountId, AWS::Region, and
+// AWS::Partition become template actions. Ref, Fn::Sub, and Fn::Join are supported, anything else is skipped.
+//
+// Roles without a RoleName are named StackName-LogicalId-SUFFIX with a random suffix, they are only generated when
+// suffixes to try are given with -var suffix=... The stack name comes from CDK's StackName.template.json file names or
+// -var stack=...
+func getCloudFormationInputs(ctx *utils.Context, paths []string, vars map[string][]string) (map[string]utils.Info, error) {
+	result := map[string]utils.Info{}
+
+	for _, path := range paths {
+		path, err := utils.ExpandPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if file != path && (d.Name() == "node_modules" || strings.HasPrefix(d.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			explicit := file == path
+			if !explicit && !hasAnySuffix(file, cfnTemplateExtensions) {
+				return nil
+			}
+
+			tmpl, err := readCloudFormationTemplate(file)
+			if err != nil {
+				if explicit {
+					return err
+				}
+				// Directories are full of JSON and YAML that isn't CloudFormation, like package.json.
+				ctx.Debug.Printf("skipping %s: %s", file, err)
+				return nil
+			}
+
+			for name, info := range tmpl.roles(ctx, file, vars) {
+				result[name] = info
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading cloudformation: %s", err)
+		}
+	}
+
+	return result, nil
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func readCloudFormationTemplate(file string) (*cfnTemplate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var tmpl cfnTemplate
+	if strings.HasSuffix(file, ".json") {
+		err = json.Unmarshal(data, &tmpl)
+	} else {
+		// Short form intrinsics like !Sub are YAML tags, convert them to their long form before decoding.
+		var doc yaml.Node
+		if err = yaml.Unmarshal(data, &doc); err == nil {
+			expandCfnTags(&doc)
+			err = doc.Decode(&tmpl)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", file, err)
+	}
+	if tmpl.Resources == nil {
+		return nil, fmt.Errorf("parsing %s: not a CloudFormation template, no Resources", file)
+	}
+	return &tmpl, nil
+}
+
+// expandCfnTags rewrites short form intrinsic functions like !Sub x to their long form, {"Fn::Sub": x}.
+func expandCfnTags(node *yaml.Node) {
+	for _, child := range node.Content {
+		expandCfnTags(child)
+	}
+
+	if !strings.HasPrefix(node.Tag, "!") || strings.HasPrefix(node.Tag, "!!") {
+		return
+	}
+
+	fn := "Fn::" + strings.TrimPrefix(node.Tag, "!")
+	if node.Tag == "!Ref" {
+		fn = "Ref"
+	}
+
+	value := *node
+	value.Tag = ""
+	if fn == "Fn::GetAtt" && value.Kind == yaml.ScalarNode {
+		// !GetAtt Resource.Attribute is short for [Resource, Attribute].
+		resource, attribute, _ := strings.Cut(value.Value, ".")
+		value = yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: resource},
+			{Kind: yaml.ScalarNode, Value: attribute},
+		}}
+	}
+
+	*node = yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: fn},
+		&value,
+	}}
+}
+
+// cdkTemplateFile matches the templates CDK writes to cdk.out, named after their stack.
+var cdkTemplateFile = regexp.MustCompile(`^(.+)\.template\.json$`)
+
+func (t *cfnTemplate) roles(ctx *utils.Context, file string, vars map[string][]string) map[string]utils.Info {
+	var stacks []string
+	if match := cdkTemplateFile.FindStringSubmatch(filepath.Base(file)); match != nil {
+		stacks = []string{match[1]}
+	} else if _, ok := vars[cfnStackVar]; ok {
+		stacks = []string{"{{.Vars." + cfnStackVar + "}}"}
+	}
+
+	result := map[string]utils.Info{}
+	for logicalID, resource := range t.Resources {
+		if resource.Type != "AWS::IAM::Role" {
+			continue
+		}
+		info := utils.Info{Comment: fmt.Sprintf(" %s in %s", logicalID, file)}
+
+		paths := []string{""}
+		if path, ok := resource.Properties["Path"]; ok {
+			if paths = t.resolve(path, stacks, vars); paths == nil {
+				ctx.Debug.Printf("skipping %s in %s: can't resolve Path", logicalID, file)
+				continue
+			}
+		}
+
+		var names []string
+		if roleName, ok := resource.Properties["RoleName"]; ok {
+			if names = t.resolve(roleName, stacks, vars); names == nil {
+				ctx.Debug.Printf("skipping %s in %s: can't resolve RoleName", logicalID, file)
+				continue
+			}
+		} else {
+			if _, ok := vars[cfnSuffixVar]; !ok || len(stacks) == 0 {
+				ctx.Debug.Printf("skipping %s in %s: generated names end in a random suffix, pass -var %s=... (and -var %s=... outside cdk.out) to try known suffixes", logicalID, file, cfnSuffixVar, cfnStackVar)
+				continue
+			}
+			for _, stack := range stacks {
+				names = append(names, stack+"-"+logicalID+"-{{.Vars."+cfnSuffixVar+"}}")
+			}
+		}
+
+		for _, name := range names {
+			for _, path := range paths {
+				result[rolePrincipal(path, name)] = info
+			}
+		}
+	}
+	return result
+}
+
+// cfnPseudoParameters maps pseudo parameters to the template action with the same value.
+var cfnPseudoParameters = map[string]string{
+	"AWS::AccountId": "{{.AccountId}}",
+	"AWS::Region":    "{{.Region}}",
+	"AWS::Partition": "{{.Partition}}",
+	"AWS::URLSuffix": "amazonaws.com",
+}
+
+// resolve returns every value an intrinsic function can evaluate to, or nil if it can't be resolved.
+func (t *cfnTemplate) resolve(value any, stacks []string, vars map[string][]string) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case float64, int, bool:
+		return []string{fmt.Sprint(v)}
+	case map[string]any:
+		if len(v) != 1 {
+			return nil
+		}
+		for fn, arg := range v {
+			switch fn {
+			case "Ref":
+				name, _ := arg.(string)
+				return t.ref(name, stacks, vars)
+			case "Fn::Sub":
+				return t.sub(arg, stacks, vars)
+			case "Fn::Join":
+				return t.join(arg, stacks, vars)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *cfnTemplate) ref(name string, stacks []string, vars map[string][]string) []string {
+	if action, ok := cfnPseudoParameters[name]; ok {
+		return []string{action}
+	}
+	if name == "AWS::StackName" {
+		return stacks
+	}
+
+	param, ok := t.Parameters[name]
+	if !ok {
+		return nil
+	}
+	if _, ok := vars[name]; ok && varNamePattern.MatchString(name) {
+		return []string{"{{.Vars." + name + "}}"}
+	}
+
+	var values []string
+	for _, allowed := range param.AllowedValues {
+		values = append(values, t.resolve(allowed, stacks, vars)...)
+	}
+	if len(values) > 0 {
+		return values
+	}
+	if param.Default != nil {
+		return t.resolve(param.Default, stacks, vars)
+	}
+	return nil
+}
+
+// subVariablePattern matches ${Name} in Fn::Sub strings, ${!Name} is a literal ${Name}.
+var subVariablePattern = regexp.MustCompile(`\$\{(!?)([^}]*)\}`)
+
+func (t *cfnTemplate) sub(arg any, stacks []string, vars map[string][]string) []string {
+	var tmpl string
+	locals := map[string]any{}
+	switch a := arg.(type) {
+	case string:
+		tmpl = a
+	case []any:
+		if len(a) != 2 {
+			return nil
+		}
+		tmpl, _ = a[0].(string)
+		locals, _ = a[1].(map[string]any)
+	}
+
+	results := []string{""}
+	prev := 0
+	for _, loc := range subVariablePattern.FindAllStringSubmatchIndex(tmpl, -1) {
+		name := tmpl[loc[4]:loc[5]]
+
+		var values []string
+		if loc[3] > loc[2] {
+			values = []string{"${" + name + "}"}
+		} else if local, ok := locals[name]; ok {
+			values = t.resolve(local, stacks, vars)
+		} else {
+			values = t.ref(name, stacks, vars)
+		}
+		if len(values) == 0 {
+			return nil
+		}
+
+		results = cartesianAppend(results, tmpl[prev:loc[0]], values)
+		prev = loc[1]
+	}
+
+	for i := range results {
+		results[i] += tmpl[prev:]
+	}
+	return results
+}
+
+func (t *cfnTemplate) join(arg any, stacks []string, vars map[string][]string) []string {
+	a, ok := arg.([]any)
+	if !ok || len(a) != 2 {
+		return nil
+	}
+	sep, _ := a[0].(string)
+	parts, ok := a[1].([]any)
+	if !ok {
+		return nil
+	}
+
+	results := []string{""}
+	for i, part := range parts {
+		values := t.resolve(part, stacks, vars)
+		if values == nil {
+			return nil
+		}
+		prefix := sep
+		if i == 0 {
+			prefix = ""
+		}
+		results = cartesianAppend(results, prefix, values)
+	}
+	return results
+}
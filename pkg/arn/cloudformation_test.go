@@ -0,0 +1,103 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCloudFormationInputs_YAML(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	path := filepath.Join(t.TempDir(), "template.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+Parameters:
+  Qualifier:
+    Type: String
+    Default: hnb659fds
+  Env:
+    Type: String
+    AllowedValues: [dev, prod]
+Resources:
+  DeployRole:
+    Type: AWS::IAM::Role
+    Properties:
+      RoleName: !Sub cdk-${Qualifier}-deploy-role-${AWS::AccountId}-${AWS::Region}
+  EnvRole:
+    Type: AWS::IAM::Role
+    Properties:
+      Path: /app/
+      RoleName: !Join ["-", [!Ref Env, app]]
+  LocalsRole:
+    Type: AWS::IAM::Role
+    Properties:
+      RoleName: !Sub
+        - ${Name}-role
+        - Name: worker
+  AttributeRole:
+    Type: AWS::IAM::Role
+    Properties:
+      RoleName: !Sub ${Bucket.Arn}-role
+  GeneratedRole:
+    Type: AWS::IAM::Role
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: not-a-role
+`), 0o600))
+
+	got, err := getCloudFormationInputs(ctx, []string{path}, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"role/cdk-hnb659fds-deploy-role-{{.AccountId}}-{{.Region}}",
+		"role/app/dev-app",
+		"role/app/prod-app",
+		"role/worker-role",
+	}, lo.Keys(got))
+	assert.Equal(t, " DeployRole in "+path, got["role/cdk-hnb659fds-deploy-role-{{.AccountId}}-{{.Region}}"].Comment)
+
+	// Parameters set with -var are left to the template, and generated names are tried with the known suffixes.
+	got, err = getCloudFormationInputs(ctx, []string{path}, map[string][]string{
+		"Env":    {"staging"},
+		"stack":  {"Pipeline"},
+		"suffix": {"1A2B3C4D5E6F"},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, got, "role/app/{{.Vars.Env}}-app")
+	assert.Contains(t, got, "role/{{.Vars.stack}}-GeneratedRole-{{.Vars.suffix}}")
+}
+
+func TestGetCloudFormationInputs_CDKOut(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := filepath.Join(t.TempDir(), "cdk.out")
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "AppStack.template.json"), []byte(`{
+  "Resources": {
+    "HandlerServiceRoleFCDC14AE": {"Type": "AWS::IAM::Role", "Properties": {}},
+    "NamedRole": {"Type": "AWS::IAM::Role", "Properties": {"RoleName": {"Fn::Join": ["", ["named-", {"Ref": "AWS::StackName"}]]}}}
+  }
+}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(`{"version": "36.0.0"}`), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tree.json"), []byte(`not json`), 0o600))
+
+	got, err := getCloudFormationInputs(ctx, []string{dir}, map[string][]string{"suffix": {"ABC", "DEF"}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"role/AppStack-HandlerServiceRoleFCDC14AE-{{.Vars.suffix}}",
+		"role/named-AppStack",
+	}, lo.Keys(got))
+}
+
+func TestGetCloudFormationInputs_NotATemplate(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	path := filepath.Join(t.TempDir(), "package.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"name": "app"}`), 0o600))
+
+	_, err := getCloudFormationInputs(ctx, []string{path}, nil)
+	assert.ErrorContains(t, err, "not a CloudFormation template")
+}
@@ -0,0 +1,124 @@
+package arn
+
+import (
+	"fmt"
+	awsarn "github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Excludes are the accounts and principals left out of a scan, like a client's out of scope accounts.
+type Excludes struct {
+	accounts map[string]bool
+	// principals are glob patterns, see Excludes.Principal.
+	principals map[string]bool
+}
+
+// LoadExcludes reads the excluded accounts and principals. Each value is a list file, directory, or URL like -roles
+// takes, anything else is used as a value itself so they can also be given inline.
+func LoadExcludes(accounts []string, principals []string) (*Excludes, error) {
+	e := &Excludes{}
+
+	var err error
+	if e.accounts, err = loadValues(accounts); err != nil {
+		return nil, fmt.Errorf("loading excluded accounts: %s", err)
+	}
+	if e.principals, err = loadValues(principals); err != nil {
+		return nil, fmt.Errorf("loading excluded principals: %s", err)
+	}
+	for pattern := range e.principals {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %s", pattern, err)
+		}
+	}
+	return e, nil
+}
+
+// loadValues returns the values given inline and read from the lists among values, see isListPath.
+func loadValues(values []string) (map[string]bool, error) {
+	result := map[string]bool{}
+	for _, value := range values {
+		if !isListPath(value) {
+			result[value] = true
+			continue
+		}
+
+		list, err := utils.GetInput(value)
+		if err != nil {
+			return nil, err
+		}
+		for entry := range list {
+			result[entry] = true
+		}
+	}
+	return result, nil
+}
+
+// isListPath returns true if value names a list rather than being a value itself.
+func isListPath(value string) bool {
+	if utils.IsURL(value) {
+		return true
+	}
+	path, err := utils.ExpandPath(value)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// Account returns true if account is excluded.
+func (e *Excludes) Account(account string) bool {
+	if e == nil {
+		return false
+	}
+	return e.accounts[account]
+}
+
+// Principal returns true if principalArn matches an excluded principal. Patterns are matched against the principal
+// with its type (role/ci/deployer), without its type (ci/deployer), and its name alone (deployer), and can use
+// filepath.Match globs like role/ci/* or *-prod.
+func (e *Excludes) Principal(principalArn string) bool {
+	if e == nil || len(e.principals) == 0 {
+		return false
+	}
+	parsed, err := awsarn.Parse(principalArn)
+	if err != nil || parsed.Resource == "root" {
+		return false
+	}
+
+	forms := []string{parsed.Resource}
+	if _, rest, ok := strings.Cut(parsed.Resource, "/"); ok {
+		forms = append(forms, rest, rest[strings.LastIndex(rest, "/")+1:])
+	}
+
+	for pattern := range e.principals {
+		for _, form := range forms {
+			if ok, _ := filepath.Match(pattern, form); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exclude removes the candidates for excluded accounts and principals, name describes what candidates are.
+func (e *Excludes) exclude(ctx *utils.Context, name string, candidates map[string]utils.Info, excluded func(string) bool) {
+	if e == nil {
+		return
+	}
+
+	count := 0
+	for candidate := range candidates {
+		if excluded(candidate) {
+			ctx.Debug.Printf("excluding %s", candidate)
+			delete(candidates, candidate)
+			count++
+		}
+	}
+	if count > 0 {
+		ctx.Info.Printf("excluded %d %s", count, name)
+	}
+}
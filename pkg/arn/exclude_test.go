@@ -0,0 +1,59 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExcludes_Principal(t *testing.T) {
+	excludes, err := LoadExcludes(nil, []string{"role/ci/*", "*-prod", "alice", "legacy/admin"})
+	require.NoError(t, err)
+
+	for principalArn, want := range map[string]bool{
+		"arn:aws:iam::123456789012:role/ci/deployer":     true,
+		"arn:aws:iam::123456789012:role/ci/sub/deployer": false,
+		"arn:aws:iam::123456789012:role/deploy-prod":     true,
+		"arn:aws:iam::123456789012:role/team/app-prod":   true,
+		"arn:aws:iam::123456789012:user/alice":           true,
+		"arn:aws:iam::123456789012:role/legacy/admin":    true,
+		"arn:aws:iam::123456789012:role/admin":           false,
+		"arn:aws:iam::123456789012:root":                 false,
+	} {
+		assert.Equal(t, want, excludes.Principal(principalArn), principalArn)
+	}
+
+	_, err = LoadExcludes(nil, []string{"role/["})
+	assert.ErrorContains(t, err, "invalid exclude pattern")
+}
+
+func TestGetArns_Excludes(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(rolesPath, []byte("deployer\nci/deployer\nadmin\n"), 0o600))
+	outOfScope := filepath.Join(dir, "out-of-scope.list")
+	require.NoError(t, os.WriteFile(outOfScope, []byte("222222222222 # Client production\n"), 0o600))
+
+	excludes, err := LoadExcludes([]string{outOfScope, "333333333333"}, []string{"role/ci/*", "admin"})
+	require.NoError(t, err)
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "111111111111,222222222222,333333333333",
+		RolePaths:   []string{rolesPath},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+		Excludes:    excludes,
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"arn:aws:iam::111111111111:root",
+		"arn:aws:iam::111111111111:role/deployer",
+	}, lo.Keys(got))
+}
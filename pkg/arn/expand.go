@@ -0,0 +1,257 @@
+package arn
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxExpansion is how many names a single template can expand to unless -max-expansion says otherwise.
+const DefaultMaxExpansion = 100_000
+
+// generatorPattern matches the parts of a template that expand to several names:
+//
+//   - {{range 0 9}} and [01-20] are inclusive numeric ranges.
+//   - {{chars "a-z0-9" 3}} is every string of that length made of the given characters.
+//
+// None of these can appear in IAM names or conflict with other template actions, so they are expanded before the
+// template is executed.
+var generatorPattern = regexp.MustCompile(`\{\{\s*range\s+(\d+)\s+(\d+)\s*\}\}|\[(\d+)-(\d+)\]|\{\{\s*chars\s+"([^"]*)"\s+(\d+)\s*\}\}`)
+
+// generator is a single expanding part of a template.
+type generator interface {
+	// count is how many values the generator yields, saturating at math.MaxInt.
+	count() int
+	values() []string
+}
+
+// expandTemplate returns every name the generators in tmpl expand to, a template without generators is returned
+// as-is. Several generators in one template expand to every combination of their values.
+//
+// An error is returned without generating anything if there would be more than limit names, unless limit is 0.
+func expandTemplate(tmpl string, limit int) ([]string, error) {
+	locs := generatorPattern.FindAllStringSubmatchIndex(tmpl, -1)
+	if locs == nil {
+		return []string{tmpl}, nil
+	}
+
+	var literals []string
+	var generators []generator
+	prev := 0
+	for _, loc := range locs {
+		gen, err := parseGenerator(tmpl, loc)
+		if err != nil {
+			return nil, fmt.Errorf("expanding %s: %s", tmpl[loc[0]:loc[1]], err)
+		}
+		literals = append(literals, tmpl[prev:loc[0]])
+		generators = append(generators, gen)
+		prev = loc[1]
+	}
+	literals = append(literals, tmpl[prev:])
+
+	total := 1
+	for _, gen := range generators {
+		total = saturatingMul(total, gen.count())
+	}
+	if limit > 0 && total > limit {
+		return nil, fmt.Errorf("%s expands to %s names, more than the limit of %d", tmpl, formatCount(total), limit)
+	}
+
+	names := []string{literals[0]}
+	for i, gen := range generators {
+		values := gen.values()
+		next := make([]string, 0, len(names)*len(values))
+		for _, name := range names {
+			for _, value := range values {
+				next = append(next, name+value+literals[i+1])
+			}
+		}
+		names = next
+	}
+	return names, nil
+}
+
+func parseGenerator(tmpl string, loc []int) (generator, error) {
+	group := func(i int) string {
+		if loc[2*i] == -1 {
+			return ""
+		}
+		return tmpl[loc[2*i]:loc[2*i+1]]
+	}
+
+	switch {
+	case loc[2] != -1:
+		return parseRange(group(1), group(2))
+	case loc[6] != -1:
+		return parseRange(group(3), group(4))
+	default:
+		return parseChars(group(5), group(6))
+	}
+}
+
+// numberRange is an inclusive range, a start with a leading zero pads every number to its width: [01-20] is 01
+// through 20.
+type numberRange struct {
+	from, to, width int
+}
+
+func parseRange(start, end string) (*numberRange, error) {
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("range starts after it ends")
+	}
+
+	r := &numberRange{from: from, to: to}
+	if len(start) > 1 && strings.HasPrefix(start, "0") {
+		r.width = len(start)
+	}
+	return r, nil
+}
+
+// count saturates for ranges like {{range 0 9223372036854775807}} whose size doesn't fit in an int, so they're over
+// the limit rather than wrapping around to a negative count.
+func (r *numberRange) count() int {
+	if r.to-r.from >= math.MaxInt {
+		return math.MaxInt
+	}
+	return r.to - r.from + 1
+}
+
+func (r *numberRange) values() []string {
+	n := r.count()
+	values := make([]string, 0, n)
+	// Counted by offset from r.from, i <= r.to would never be false if r.to is math.MaxInt.
+	for offset := range n {
+		values = append(values, fmt.Sprintf("%0*d", r.width, r.from+offset))
+	}
+	return values
+}
+
+// charClass is every string of a fixed length made from a set of characters.
+type charClass struct {
+	chars  []rune
+	length int
+}
+
+// parseChars parses a character set like a-z0-9_, a - at the start or end of the set is taken literally.
+func parseChars(set string, length string) (*charClass, error) {
+	n, err := strconv.Atoi(length)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("length must be at least 1")
+	}
+
+	var chars []rune
+	seen := map[rune]bool{}
+	add := func(c rune) {
+		if !seen[c] {
+			seen[c] = true
+			chars = append(chars, c)
+		}
+	}
+
+	runes := []rune(set)
+	for i := 0; i < len(runes); i++ {
+		if i+2 < len(runes) && runes[i+1] == '-' {
+			if runes[i] > runes[i+2] {
+				return nil, fmt.Errorf("character range %c-%c starts after it ends", runes[i], runes[i+2])
+			}
+			for c := runes[i]; c <= runes[i+2]; c++ {
+				add(c)
+			}
+			i += 2
+		} else {
+			add(runes[i])
+		}
+	}
+	if len(chars) == 0 {
+		return nil, fmt.Errorf("empty character set")
+	}
+
+	return &charClass{chars: chars, length: n}, nil
+}
+
+func (c *charClass) count() int {
+	total := 1
+	for range c.length {
+		total = saturatingMul(total, len(c.chars))
+	}
+	return total
+}
+
+func (c *charClass) values() []string {
+	values := []string{""}
+	for range c.length {
+		next := make([]string, 0, len(values)*len(c.chars))
+		for _, prefix := range values {
+			for _, char := range c.chars {
+				next = append(next, prefix+string(char))
+			}
+		}
+		values = next
+	}
+	return values
+}
+
+// cartesianAppend appends literal and then each of values to every result.
+func cartesianAppend(results []string, literal string, values []string) []string {
+	next := make([]string, 0, len(results)*len(values))
+	for _, result := range results {
+		for _, value := range values {
+			next = append(next, result+literal+value)
+		}
+	}
+	return next
+}
+
+func saturatingMul(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+// formatCount writes counts too large to read at a glance in scientific notation.
+func formatCount(n int) string {
+	if n == math.MaxInt {
+		return "more than " + strconv.FormatFloat(float64(math.MaxInt), 'e', 1, 64)
+	}
+	if n >= 1_000_000 {
+		return strconv.FormatFloat(float64(n), 'e', 1, 64)
+	}
+	return strconv.Itoa(n)
+}
+
+// expandTemplates expands the generators in each principal template, expanded names keep the template's comment.
+func expandTemplates(ctx *utils.Context, templates map[string]utils.Info, limit int) (map[string]utils.Info, error) {
+	result := map[string]utils.Info{}
+	for tmpl, info := range templates {
+		names, err := expandTemplate(tmpl, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) > 1 {
+			ctx.Debug.Printf("%s expanded to %d names", tmpl, len(names))
+		}
+		for _, name := range names {
+			result[name] = info
+		}
+	}
+
+	if len(result) != len(templates) {
+		ctx.Info.Printf("expanded %d templates into %d names", len(templates), len(result))
+	}
+	return result, nil
+}
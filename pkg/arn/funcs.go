@@ -0,0 +1,103 @@
+package arn
+
+import (
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// templateFuncs are available in principal templates. Functions that take extra arguments take the value last so they
+// can be used in pipelines, like {{.Vars.app | replace "-" "_"}} or {{.Vars.n | zeropad 3}}.
+var templateFuncs = template.FuncMap{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"title":   title,
+	"trim":    strings.TrimSpace,
+	"replace": replace,
+	"zeropad": zeropad,
+	"kebab":   func(s string) string { return strings.ToLower(strings.Join(words(s), "-")) },
+	"snake":   func(s string) string { return strings.ToLower(strings.Join(words(s), "_")) },
+	"camel":   camel,
+	"pascal":  pascal,
+}
+
+func replace(old string, new string, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+// zeropad pads s with leading zeros to width characters.
+func zeropad(width int, s string) string {
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat("0", width-len(s)) + s
+}
+
+// title upper cases the first letter of each word, leaving the separators in place.
+func title(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || isSeparator(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// pascal joins the words in s with each one capitalized, MyRole.
+func pascal(s string) string {
+	var b strings.Builder
+	for _, word := range words(s) {
+		runes := []rune(strings.ToLower(word))
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+// camel is pascal with the first word lower cased, myRole.
+func camel(s string) string {
+	runes := []rune(pascal(s))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToLower(runes[0])
+	}
+	return string(runes)
+}
+
+func isSeparator(r rune) bool {
+	return r == '-' || r == '_' || r == ' ' || r == '.'
+}
+
+// words splits s on separators and case changes, so MyRole, my-role, and my_role are all [My Role] or [my role].
+// Runs of upper case letters are kept together, APIGateway is [API Gateway].
+func words(s string) []string {
+	var result []string
+	var current []rune
+
+	flush := func() {
+		if len(current) > 0 {
+			result = append(result, string(current))
+			current = nil
+		}
+	}
+
+	runes := []rune(s)
+	for i, r := range runes {
+		if isSeparator(r) {
+			flush()
+			continue
+		}
+
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+
+	return result
+}
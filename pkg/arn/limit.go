@@ -0,0 +1,50 @@
+package arn
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// DefaultMaxCandidates is how many candidates a scan can have unless -max-candidates or -yes-really says otherwise, at
+// the default rate limit it takes a couple of days to scan.
+const DefaultMaxCandidates = 1_000_000
+
+// expansion is what a template is executed for in each account.
+type expansion struct {
+	regions      []string
+	combinations []map[string]string
+}
+
+// checkCandidateCount returns an error if the candidates for accounts and expansions would be more than limit. The
+// error names the input dimension that's the largest, since that's usually what blew up, like a -var list or an
+// account range that was meant to be smaller.
+func checkCandidateCount(accounts int, expansions map[string]expansion, limit int) error {
+	perAccount, regions, combinations := 0, 0, 0
+	for _, e := range expansions {
+		perAccount += len(e.regions) * len(e.combinations)
+		regions = max(regions, len(e.regions))
+		combinations = max(combinations, len(e.combinations))
+	}
+
+	// Each account also has its root ARN.
+	total := accounts * (perAccount + 1)
+	if total <= limit {
+		return nil
+	}
+
+	type dimension struct {
+		name string
+		size int
+	}
+	largest := slices.MaxFunc([]dimension{
+		{"accounts", accounts},
+		{"principal names", len(expansions)},
+		{"regions", regions},
+		{"variable combinations", combinations},
+	}, func(a, b dimension) int { return cmp.Compare(a.size, b.size) })
+
+	return fmt.Errorf("%d candidates is more than -max-candidates %d: %d accounts x %d principal names, each in up to "+
+		"%d regions x %d variable combinations, the most are %s (%d); pass -yes-really to scan them anyway",
+		total, limit, accounts, len(expansions), regions, combinations, largest.name, largest.size)
+}
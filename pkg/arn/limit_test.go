@@ -0,0 +1,44 @@
+package arn
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetArns_MaxCandidates(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	input := &GetArnsInput{
+		AccountRange: &AccountRange{From: 123456789000, To: 123456789099, Stride: 1},
+		Wordlists:    []string{"cdk"},
+		Regions:      map[string]utils.Info{"us-east-1": {}, "us-west-2": {}},
+	}
+
+	input.MaxCandidates = 1000
+	_, err := GetArns(ctx, input)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1100 candidates is more than -max-candidates 1000")
+	assert.Contains(t, err.Error(), "the most are accounts (100)")
+
+	input.MaxCandidates = 1100
+	got, err := GetArns(ctx, input)
+	require.NoError(t, err)
+	assert.Len(t, got, 1100)
+}
+
+func TestCheckCandidateCount(t *testing.T) {
+	combinations := make([]map[string]string, 50)
+	err := checkCandidateCount(2, map[string]expansion{
+		"role/a-{{.Vars.env}}": {regions: []string{""}, combinations: combinations},
+		"role/b":               {regions: []string{""}, combinations: []map[string]string{{}}},
+	}, 100)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the most are variable combinations (50)")
+
+	assert.NoError(t, checkCandidateCount(2, map[string]expansion{
+		"role/a-{{.Vars.env}}": {regions: []string{""}, combinations: combinations},
+	}, 102))
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"maps"
 	"strings"
 	"text/template"
 )
@@ -11,10 +13,41 @@ import (
 type GetArnsInput struct {
 	RolePaths      []string
 	PrincipalPaths []string
-	Regions        map[string]utils.Info
-	ForceScan      bool
-	AccountsStr    string
-	AccountsPath   string
+	// Roles and Principals are entries given inline, in the same format as the lines of -roles and -principals lists.
+	Roles      []string
+	Principals []string
+	Wordlists  []string
+	// Packs are the names of wordlist packs in PacksDir to scan, see LoadPack.
+	Packs []string
+	// TerraformPaths are Terraform state files, configuration files, or directories to read role names from.
+	TerraformPaths []string
+	// CloudFormationPaths are CloudFormation templates or directories of them, like cdk.out, to read role names from.
+	CloudFormationPaths []string
+	// CDKQualifiers are the bootstrap qualifiers to generate CDK bootstrap roles for, see getCDKInputs.
+	CDKQualifiers []string
+	// SSOPermissionSets and SSOSuffixes generate Identity Center permission set roles, see getIdentityCenterInputs.
+	SSOPermissionSets []string
+	SSOSuffixes       []string
+	// Permute adds variants of every role name in other naming conventions and with common prefixes and suffixes,
+	// PermutePrefixes and PermuteSuffixes replace PermutationPrefixes and PermutationSuffixes when set.
+	Permute         bool
+	PermutePrefixes []string
+	PermuteSuffixes []string
+	MaxExpansion    int
+	// MaxCandidates is the most candidates GetArns generates before returning an error instead, 0 is no limit.
+	MaxCandidates int
+	Regions       map[string]utils.Info
+	ForceScan     bool
+	AccountsStr   string
+	AccountsPath  string
+	// AccountRange adds every account ID in the range, alongside AccountsStr and AccountsPath.
+	AccountRange *AccountRange
+	// TryPaths are IAM paths every role without a path is also tried under, see ParseRolePaths.
+	TryPaths []string
+	// Vars are template variable lists, templates are executed once for every combination of their values.
+	Vars map[string][]string
+	// Excludes are accounts and principals left out of the candidates, see LoadExcludes.
+	Excludes *Excludes
 }
 
 func GetArns(ctx *utils.Context, input *GetArnsInput) (map[string]utils.Info, error) {
@@ -30,6 +63,22 @@ func GetArns(ctx *utils.Context, input *GetArnsInput) (map[string]utils.Info, er
 		accounts = map[string]utils.Info{}
 	}
 
+	maxExpansion := input.MaxExpansion
+	if maxExpansion == 0 {
+		maxExpansion = DefaultMaxExpansion
+	}
+
+	if input.AccountRange != nil {
+		ids, err := input.AccountRange.Accounts(maxExpansion)
+		if err != nil {
+			return nil, err
+		}
+		ctx.Info.Printf("account range %s adds %d accounts", input.AccountRange, len(ids))
+		for _, id := range ids {
+			accounts[id] = utils.Info{}
+		}
+	}
+
 	for _, value := range strings.Split(input.AccountsStr, ",") {
 		value = strings.TrimSpace(value)
 		if value == "" {
@@ -37,6 +86,7 @@ func GetArns(ctx *utils.Context, input *GetArnsInput) (map[string]utils.Info, er
 		}
 		accounts[value] = utils.Info{}
 	}
+	input.Excludes.exclude(ctx, "accounts", accounts, input.Excludes.Account)
 
 	roles, err := getRoleInputs(input.RolePaths)
 	if err != nil {
@@ -48,49 +98,188 @@ func GetArns(ctx *utils.Context, input *GetArnsInput) (map[string]utils.Info, er
 		return nil, fmt.Errorf("getting allPrincipals: %s", err)
 	}
 
+	for role, info := range utils.GetInputFromPath(strings.Join(input.Roles, "\n")) {
+		roles["role/"+role] = info
+	}
+	addPrincipals(principals, utils.GetInputFromPath(strings.Join(input.Principals, "\n")))
+
 	for name, info := range principals {
 		roles[name] = info
 	}
 
+	wordlists, err := getWordlistInputs(input.Wordlists)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range wordlists {
+		roles[name] = info
+	}
+
+	packs, err := getPackInputs(input.Packs)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range packs {
+		roles[name] = info
+	}
+
+	terraform, err := getTerraformInputs(ctx, input.TerraformPaths, input.Vars)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range terraform {
+		roles[name] = info
+	}
+
+	cloudFormation, err := getCloudFormationInputs(ctx, input.CloudFormationPaths, input.Vars)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range cloudFormation {
+		roles[name] = info
+	}
+
+	cdk, err := getCDKInputs(input.CDKQualifiers)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range cdk {
+		roles[name] = info
+	}
+
+	identityCenter, err := getIdentityCenterInputs(input.SSOPermissionSets, input.SSOSuffixes)
+	if err != nil {
+		return nil, err
+	}
+	for name, info := range identityCenter {
+		roles[name] = info
+	}
+
+	if input.Permute {
+		prefixes, suffixes := PermutationPrefixes, PermutationSuffixes
+		if input.PermutePrefixes != nil {
+			prefixes = input.PermutePrefixes
+		}
+		if input.PermuteSuffixes != nil {
+			suffixes = input.PermuteSuffixes
+		}
+		roles = permuteRoles(ctx, roles, prefixes, suffixes)
+	}
+
+	roles = addRolePaths(roles, input.TryPaths)
+
+	if roles, err = expandTemplates(ctx, roles, maxExpansion); err != nil {
+		return nil, err
+	}
+
+	// Templates are only executed for the regions and variables they reference.
+	expansions := map[string]expansion{}
+	for tmpl, roleInfo := range roles {
+		regions := lo.Keys(input.Regions)
+		if len(roleInfo.Regions) > 0 {
+			regions = roleInfo.Regions
+		}
+
+		vars := input.Vars
+		if len(roleInfo.Vars) > 0 {
+			vars = maps.Clone(roleInfo.Vars)
+			maps.Copy(vars, input.Vars)
+		}
+
+		usage := getTemplateUsage(tmpl)
+		expansions[tmpl] = expansion{
+			regions:      usage.regions(regions),
+			combinations: usage.combinations(vars),
+		}
+	}
+
+	if input.MaxCandidates > 0 {
+		if err := checkCandidateCount(len(accounts), expansions, input.MaxCandidates); err != nil {
+			return nil, err
+		}
+	}
+
 	result := map[string]utils.Info{}
 	for account, accountInfo := range accounts {
 		result[utils.GetRootArn(account)] = accountInfo
 
 		for tmpl, roleInfo := range roles {
-			for region, _ := range input.Regions {
+			for _, region := range expansions[tmpl].regions {
 				ctx.Debug.Printf("template %s - account %s - region %s", tmpl, account, region)
 
-				arn, err := GetArn(tmpl, account, region)
-				if err != nil {
-					return nil, fmt.Errorf("GetArn: %s", err)
-				}
+				for _, vars := range expansions[tmpl].combinations {
+					arn, err := executeTemplate(tmpl, newRoleData(account, region).withVars(vars))
+					if err != nil {
+						return nil, fmt.Errorf("GetArn: %s", err)
+					}
 
-				if accountInfo.Comment == "" {
-					ctx.Debug.Printf("account %s has no comment", account)
-				}
+					if accountInfo.Comment == "" {
+						ctx.Debug.Printf("account %s has no comment", account)
+					}
 
-				result[arn] = utils.Info{
-					Comment: accountInfo.Comment + " - " + roleInfo.Comment,
+					result[arn] = utils.Info{
+						Comment:    accountInfo.Comment + " - " + roleInfo.Comment,
+						Tags:       roleInfo.Tags,
+						Likelihood: roleInfo.Likelihood,
+					}
 				}
 			}
 		}
 	}
 
-	return result, nil
+	input.Excludes.exclude(ctx, "principals", result, input.Excludes.Principal)
+	return validateArns(ctx, result), nil
 }
 
+// roleData are the variables available to principal templates.
 type roleData struct {
+	// Partition is the partition ARNs are generated in, always aws today.
+	Partition string
 	AccountId string
-	Region    string
+	// ShortAccountId is the last four digits of the account ID, a common suffix in names that need to be unique.
+	ShortAccountId string
+	Region         string
+	// Env, Stage, and Team are set by the user so one list can cover conventions like {{.Env}}-{{.Team}}-deployer.
+	Env   string
+	Stage string
+	Team  string
+	// Vars holds every user-defined variable, including env, stage, and team, for {{.Vars.name}}.
+	Vars map[string]string
+}
+
+func newRoleData(account string, region string) roleData {
+	data := roleData{
+		Partition:      "aws",
+		AccountId:      account,
+		ShortAccountId: account,
+		Region:         region,
+	}
+	if len(account) > 4 {
+		data.ShortAccountId = account[len(account)-4:]
+	}
+	return data
 }
 
+// withVars sets the user-defined variables, env, stage, and team are also available as fields.
+func (d roleData) withVars(vars map[string]string) roleData {
+	d.Vars = vars
+	d.Env, d.Stage, d.Team = vars["env"], vars["stage"], vars["team"]
+	return d
+}
+
+// getRoleInputs reads role names from list files and structured input files.
 func getRoleInputs(paths []string) (map[string]utils.Info, error) {
-	roles, err := utils.GetInput(paths...)
+	lists, structured := splitStructuredInputs(paths)
+
+	roles, err := utils.GetInput(lists...)
 	if err != nil {
 		return nil, err
 	}
 
-	result := map[string]utils.Info{}
+	result, err := getStructuredInputs(structured, []string{"role"})
+	if err != nil {
+		return nil, err
+	}
 	for role, info := range roles {
 		result["role/"+role] = info
 	}
@@ -98,19 +287,41 @@ func getRoleInputs(paths []string) (map[string]utils.Info, error) {
 	return result, nil
 }
 
+// principalPrefixes are the principal types that can be given explicitly in a principal list.
+var principalPrefixes = []string{"role/", "user/", "saml-provider/", "oidc-provider/"}
+
+// getPrincipalInputs reads principal names prefixed with role/, user/, saml-provider/, or oidc-provider/.
+//
+// Names without a prefix are ambiguous, so both the role/ and user/ forms are returned and the scan results show
+// which principal type actually exists. Federation providers are only scanned when asked for explicitly, e.g.
+// saml-provider/Okta or oidc-provider/token.actions.githubusercontent.com.
 func getPrincipalInputs(paths []string) (map[string]utils.Info, error) {
-	principals, err := utils.GetInput(paths...)
+	lists, structured := splitStructuredInputs(paths)
+
+	principals, err := utils.GetInput(lists...)
 	if err != nil {
 		return nil, err
 	}
 
-	for principal := range principals {
-		if !strings.HasPrefix(principal, "role/") && !strings.HasPrefix(principal, "user/") {
-			return nil, fmt.Errorf("principal %q must start with role/ or user/", principal)
-		}
+	result, err := getStructuredInputs(structured, []string{"role", "user"})
+	if err != nil {
+		return nil, err
 	}
+	addPrincipals(result, principals)
+
+	return result, nil
+}
 
-	return principals, nil
+// addPrincipals adds principal list entries to result, entries without a prefix are added as both a role and a user.
+func addPrincipals(result map[string]utils.Info, principals map[string]utils.Info) {
+	for principal, info := range principals {
+		if lo.SomeBy(principalPrefixes, func(prefix string) bool { return strings.HasPrefix(principal, prefix) }) {
+			result[principal] = info
+		} else {
+			result["role/"+principal] = info
+			result["user/"+principal] = info
+		}
+	}
 }
 
 // GetArn returns a list of ARNs based on the given template, account, and region
@@ -121,17 +332,16 @@ func getPrincipalInputs(paths []string) (map[string]utils.Info, error) {
 //			"arn:aws:iam::123456789012:role/cdk-hnb659fds-deploy-role-123456789012-us-west-2"
 //	]
 func GetArn(principal string, account string, region string) (string, error) {
-	tmpl, err := template.New(principal).Parse(principal)
+	return executeTemplate(principal, newRoleData(account, region))
+}
+
+func executeTemplate(principal string, data roleData) (string, error) {
+	tmpl, err := template.New(principal).Funcs(templateFuncs).Parse(principal)
 	if err != nil {
 		return "", err
 	}
 
-	data := roleData{
-		AccountId: account,
-		Region:    region,
-	}
-
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, data)
-	return fmt.Sprintf("arn:aws:iam::%s:%s", account, buf.String()), err
+	return fmt.Sprintf("arn:%s:iam::%s:%s", data.Partition, data.AccountId, buf.String()), err
 }
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +22,72 @@ func TestGetArn(t *testing.T) {
 	assert.Equal(t, "arn:aws:iam::123456789012:user/alice-us-east-1", got)
 }
 
+func TestGetArns_TemplateVariables(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(rolesPath, []byte("{{.Env}}-{{.Stage}}-{{.Team}}-deployer-{{.ShortAccountId}}\n{{.Partition}}-role\n"), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		RolePaths:   []string{rolesPath},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+		Vars: map[string][]string{
+			"env":   {"prod"},
+			"stage": {"blue"},
+			"team":  {"infra"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/prod-blue-infra-deployer-9012")
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/aws-role")
+}
+
+func TestGetArns_VarCombinations(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(rolesPath, []byte("{{.Env}}-{{.Vars.app}}-deployer\n"), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		RolePaths:   []string{rolesPath},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+		Vars: map[string][]string{
+			"env": {"dev", "prod"},
+			"app": {"api", "web"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, got, 5)
+	for _, name := range []string{"dev-api", "dev-web", "prod-api", "prod-web"} {
+		assert.Contains(t, got, "arn:aws:iam::123456789012:role/"+name+"-deployer")
+	}
+}
+
+func TestParseVar(t *testing.T) {
+	name, values, err := ParseVar("env= dev, staging ,prod")
+	require.NoError(t, err)
+	assert.Equal(t, "env", name)
+	assert.Equal(t, []string{"dev", "staging", "prod"}, values)
+
+	for _, value := range []string{"env", "1env=dev", "env=", "env=,"} {
+		_, _, err := ParseVar(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestLoadVarFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vars.list")
+	require.NoError(t, os.WriteFile(path, []byte("# environments\nenv=dev,prod\nteam=infra # owners\nteam=data\n"), 0o600))
+
+	got, err := LoadVarFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"env": {"dev", "prod"}, "team": {"infra", "data"}}, got)
+}
+
 func TestGetArns_MergesRolesAndPrincipals(t *testing.T) {
 	ctx := utils.NewContext(context.Background())
 	dir := t.TempDir()
@@ -45,6 +112,27 @@ func TestGetArns_MergesRolesAndPrincipals(t *testing.T) {
 	assert.Equal(t, " -  user comment", got["arn:aws:iam::123456789012:user/alice-us-east-1"].Comment)
 }
 
+func TestGetArns_InlineRolesAndPrincipals(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		Roles:       []string{"Admin # inline", "deploy-{{.Region}}"},
+		Principals:  []string{"user/alice", "ci"},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]utils.Info{
+		"arn:aws:iam::123456789012:root":                  {},
+		"arn:aws:iam::123456789012:role/Admin":            {Comment: " -  inline"},
+		"arn:aws:iam::123456789012:role/deploy-us-east-1": {Comment: " - "},
+		"arn:aws:iam::123456789012:user/alice":            {Comment: " - "},
+		"arn:aws:iam::123456789012:role/ci":               {Comment: " - "},
+		"arn:aws:iam::123456789012:user/ci":               {Comment: " - "},
+	}, got)
+}
+
 func TestGetRoleInputs_AddsRolePrefix(t *testing.T) {
 	dir := t.TempDir()
 	path := dir + "/roles.list"
@@ -56,12 +144,219 @@ func TestGetRoleInputs_AddsRolePrefix(t *testing.T) {
 	assert.Equal(t, " comment", got["role/path/Operator"].Comment)
 }
 
-func TestGetPrincipalInputs_RejectsMissingPrefix(t *testing.T) {
+func TestGetPrincipalInputs_ExpandsMissingPrefix(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "principals.list")
+	require.NoError(t, os.WriteFile(path, []byte("Admin # comment\nuser/alice\n"), 0o600))
+
+	got, err := getPrincipalInputs([]string{path})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]utils.Info{
+		"role/Admin": {Comment: " comment"},
+		"user/Admin": {Comment: " comment"},
+		"user/alice": {},
+	}, got)
+}
+
+func TestGetPrincipalInputs_FederationProviders(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "principals.list")
-	require.NoError(t, os.WriteFile(path, []byte("Admin\n"), 0o600))
+	require.NoError(t, os.WriteFile(path, []byte("saml-provider/Okta\noidc-provider/token.actions.githubusercontent.com\n"), 0o600))
 
-	_, err := getPrincipalInputs([]string{path})
-	require.Error(t, err)
-	assert.Contains(t, err.Error(), "must start with role/ or user/")
+	got, err := getPrincipalInputs([]string{path})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]utils.Info{
+		"saml-provider/Okta": {},
+		"oidc-provider/token.actions.githubusercontent.com": {},
+	}, got)
+
+	principalArn, err := GetArn("oidc-provider/token.actions.githubusercontent.com", "123456789012", "us-east-1")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com", principalArn)
+}
+
+func TestGetWordlistInputs(t *testing.T) {
+	for _, name := range Wordlists() {
+		got, err := getWordlistInputs([]string{name})
+		require.NoError(t, err)
+		assert.NotEmpty(t, got, name)
+
+		for role := range got {
+			principalArn, err := GetArn(role, "123456789012", "us-east-1")
+			assert.NoError(t, err, "%s: %s", name, role)
+			assert.NoError(t, ValidateArn(principalArn), "%s: %s", name, role)
+		}
+	}
+
+	got, err := getWordlistInputs([]string{"cdk"})
+	require.NoError(t, err)
+	assert.Contains(t, got, "role/cdk-hnb659fds-deploy-role-{{.AccountId}}-{{.Region}}")
+
+	got, err = getWordlistInputs([]string{"servicelinked"})
+	require.NoError(t, err)
+	assert.Contains(t, got, "role/aws-service-role/guardduty.amazonaws.com/AWSServiceRoleForAmazonGuardDuty")
+
+	_, err = getWordlistInputs([]string{"missing"})
+	assert.ErrorContains(t, err, `unknown wordlist "missing"`)
+}
+
+func TestVendors(t *testing.T) {
+	vendors := Vendors()
+	assert.Equal(t, "Datadog", vendors["DatadogIntegrationRole"])
+	assert.Equal(t, "CrowdStrike Falcon Horizon", vendors["CrowdStrikeCSPMReader"])
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		want    []string
+		wantErr string
+	}{
+		{tmpl: "role/Admin", want: []string{"role/Admin"}},
+		{tmpl: "role/deploy-{{range 0 2}}", want: []string{"role/deploy-0", "role/deploy-1", "role/deploy-2"}},
+		{tmpl: "role/prod-[08-10]", want: []string{"role/prod-08", "role/prod-09", "role/prod-10"}},
+		{tmpl: "role/prod-[9-10]", want: []string{"role/prod-9", "role/prod-10"}},
+		{tmpl: "role/[1-2]-{{range 0 1}}-{{.Region}}", want: []string{"role/1-0-{{.Region}}", "role/1-1-{{.Region}}", "role/2-0-{{.Region}}", "role/2-1-{{.Region}}"}},
+		{tmpl: "role/prod-[20-01]", wantErr: "expanding [20-01]: range starts after it ends"},
+		{tmpl: `role/cdk-{{chars "ab" 2}}`, want: []string{"role/cdk-aa", "role/cdk-ab", "role/cdk-ba", "role/cdk-bb"}},
+		{tmpl: `role/{{chars "a-c-" 1}}`, want: []string{"role/a", "role/b", "role/c", "role/-"}},
+		{tmpl: `role/{{chars "z-a" 1}}`, wantErr: `expanding {{chars "z-a" 1}}: character range z-a starts after it ends`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := expandTemplate(tt.tmpl, 0)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExpandTemplate_Limit(t *testing.T) {
+	_, err := expandTemplate(`role/cdk-{{chars "a-z0-9" 9}}-deploy-role`, DefaultMaxExpansion)
+	assert.EqualError(t, err, `role/cdk-{{chars "a-z0-9" 9}}-deploy-role expands to 1.0e+14 names, more than the limit of 100000`)
+
+	_, err = expandTemplate(`role/{{chars "a-z" 20}}`, DefaultMaxExpansion)
+	assert.ErrorContains(t, err, "expands to more than 9.2e+18 names")
+
+	// Ranges too wide to count are over the limit instead of wrapping around.
+	for _, tmpl := range []string{`role/{{range 0 9223372036854775807}}`, `role/[0-9223372036854775807]`} {
+		_, err = expandTemplate(tmpl, DefaultMaxExpansion)
+		assert.ErrorContains(t, err, "expands to more than 9.2e+18 names")
+	}
+	got, err := expandTemplate(`role/[9223372036854775806-9223372036854775807]`, DefaultMaxExpansion)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"role/9223372036854775806", "role/9223372036854775807"}, got)
+
+	got, err = expandTemplate(`role/{{chars "0-9" 2}}-[1-3]`, 300)
+	require.NoError(t, err)
+	assert.Len(t, got, 300)
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{tmpl: `{{upper "my-role"}}`, want: "MY-ROLE"},
+		{tmpl: `{{lower "MyRole"}}`, want: "myrole"},
+		{tmpl: `{{title "my-role"}}`, want: "My-Role"},
+		{tmpl: `{{kebab "MyRole"}}`, want: "my-role"},
+		{tmpl: `{{snake "my-role"}}`, want: "my_role"},
+		{tmpl: `{{pascal "my_role"}}`, want: "MyRole"},
+		{tmpl: `{{camel "my-role"}}`, want: "myRole"},
+		{tmpl: `{{kebab "APIGatewayRole2"}}`, want: "api-gateway-role2"},
+		{tmpl: `{{"my-role" | replace "-" "."}}`, want: "my.role"},
+		{tmpl: `{{.ShortAccountId | zeropad 6}}`, want: "009012"},
+		{tmpl: `{{"1234567" | zeropad 3}}`, want: "1234567"},
+		{tmpl: `{{.Region | replace "-" "" | upper}}`, want: "USEAST1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			got, err := GetArn("role/"+tt.tmpl, "123456789012", "us-east-1")
+			require.NoError(t, err)
+			assert.Equal(t, "arn:aws:iam::123456789012:role/"+tt.want, got)
+		})
+	}
+}
+
+func TestParseRolePaths(t *testing.T) {
+	assert.Nil(t, ParseRolePaths(""))
+	assert.Equal(t, []string{"/service-role/", "/a/b/", "/"}, ParseRolePaths("service-role, /a/b,/service-role/"))
+	assert.Equal(t, CommonRolePaths, ParseRolePaths("common,/"))
+}
+
+func TestAddRolePaths(t *testing.T) {
+	got := addRolePaths(map[string]utils.Info{
+		"role/Admin":         {Comment: "admin"},
+		"role/path/Operator": {},
+		"user/alice":         {},
+	}, []string{"/", "/service-role/"})
+
+	assert.Equal(t, map[string]utils.Info{
+		"role/Admin":              {Comment: "admin"},
+		"role/service-role/Admin": {Comment: "admin"},
+		"role/path/Operator":      {},
+		"user/alice":              {},
+	}, got)
+}
+
+func TestParseAccountRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		stride  int
+		want    []string
+		wantErr string
+	}{
+		{value: "123456789000-123456789002", want: []string{"123456789000", "123456789001", "123456789002"}},
+		{value: "000000000001 - 000000000003", want: []string{"000000000001", "000000000002", "000000000003"}},
+		{value: "10-20", stride: 5, want: []string{"000000000010", "000000000015", "000000000020"}},
+		{value: "10-21", stride: 5, want: []string{"000000000010", "000000000015", "000000000020"}},
+		{value: "123456789012", wantErr: "expected a range"},
+		{value: "20-10", wantErr: "starts after it ends"},
+		{value: "1-1000000000000", wantErr: "expected up to twelve digits"},
+		{value: "1-2", stride: -1, wantErr: "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			r, err := ParseAccountRange(tt.value, tt.stride)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			got, err := r.Accounts(0)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, len(tt.want), r.Count())
+		})
+	}
+}
+
+func TestAccountRange_Limit(t *testing.T) {
+	r, err := ParseAccountRange("0-999999999999", 1)
+	require.NoError(t, err)
+
+	_, err = r.Accounts(DefaultMaxExpansion)
+	assert.ErrorContains(t, err, "expands to 1.0e+12 accounts, more than the limit of 100000")
+}
+
+func TestGetArns_AccountRange(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	r, err := ParseAccountRange("123456789010-123456789012", 2)
+	require.NoError(t, err)
+
+	got, err := GetArns(ctx, &GetArnsInput{AccountRange: r, AccountsStr: "111111111111"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"arn:aws:iam::111111111111:root",
+		"arn:aws:iam::123456789010:root",
+		"arn:aws:iam::123456789012:root",
+	}, lo.Keys(got))
 }
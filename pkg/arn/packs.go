@@ -0,0 +1,169 @@
+package arn
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// PacksDir is the directory wordlist packs are read from, each <name>.yaml, .yml, or .json file in it is enabled with
+// -pack <name>.
+var PacksDir = "~/.roles/packs"
+
+// packNamePattern matches the names packs can be enabled by, so a name can't point outside of PacksDir.
+var packNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Pack is a shareable set of candidates, like the roles a vendor's integration creates, for example:
+//
+//	description: Roles created by the Example integration
+//	author: someone@example.com
+//	version: "1.0"
+//	url: https://example.com/docs/aws
+//	vars:
+//	  env: [dev, prod]
+//	candidates:
+//	  - template: ExampleIntegrationRole
+//	    comment: Example read only integration
+//	  - template: example-{{.Env}}-forwarder-{{.Region}}
+//	    likelihood: 0.5
+//
+// Candidates are the same as in structured input files, entries without a type are roles like in -roles files.
+type Pack struct {
+	// Name is the file name without its extension.
+	Name        string `yaml:"-"`
+	Description string `yaml:"description"`
+	Author      string `yaml:"author"`
+	Version     string `yaml:"version"`
+	URL         string `yaml:"url"`
+	// Vars are the default values of variables the pack's templates use, only the pack's templates are executed with
+	// them and -var, -env, -stage, and -team override them.
+	Vars       map[string][]string   `yaml:"vars"`
+	Candidates []structuredCandidate `yaml:"candidates"`
+}
+
+// ListPacks returns the packs in PacksDir sorted by name, it's empty if PacksDir doesn't exist.
+func ListPacks() ([]*Pack, error) {
+	dir, err := utils.ExpandPath(PacksDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading packs: %s", err)
+	}
+
+	var packs []*Pack
+	for _, entry := range entries {
+		if entry.IsDir() || !isStructuredInput(entry.Name()) {
+			continue
+		}
+		pack, err := readPack(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, pack)
+	}
+	slices.SortFunc(packs, func(a, b *Pack) int { return strings.Compare(a.Name, b.Name) })
+	return packs, nil
+}
+
+// LoadPack reads the pack with the given name from PacksDir.
+func LoadPack(name string) (*Pack, error) {
+	if !packNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid pack name %q", name)
+	}
+	dir, err := utils.ExpandPath(PacksDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ext := range structuredExtensions {
+		path := filepath.Join(dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return readPack(path)
+		}
+	}
+
+	packs, err := ListPacks()
+	if err != nil {
+		return nil, err
+	}
+	if len(packs) == 0 {
+		return nil, fmt.Errorf("unknown pack %q: no packs in %s", name, PacksDir)
+	}
+	var names []string
+	for _, pack := range packs {
+		names = append(names, pack.Name)
+	}
+	return nil, fmt.Errorf("unknown pack %q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+func readPack(path string) (*Pack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pack := &Pack{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	// Unknown fields are errors so a typo in a shared pack doesn't silently change what's scanned.
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(pack); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parsing pack %s: %s", path, err)
+	}
+
+	for name, values := range pack.Vars {
+		if !varNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("pack %s: invalid variable name %q", pack.Name, name)
+		} else if len(values) == 0 {
+			return nil, fmt.Errorf("pack %s: variable %s has no values", pack.Name, name)
+		}
+	}
+	return pack, nil
+}
+
+// inputs returns the pack's candidates, they carry the pack's variables and are commented with the pack name unless
+// they have a comment of their own.
+func (p *Pack) inputs() (map[string]utils.Info, error) {
+	candidates := map[string]utils.Info{}
+	if err := addCandidates(candidates, "pack "+p.Name, p.Candidates, []string{"role"}); err != nil {
+		return nil, err
+	}
+
+	for principal, info := range candidates {
+		if info.Comment == "" {
+			info.Comment = fmt.Sprintf(" %s pack", p.Name)
+		}
+		info.Vars = p.Vars
+		candidates[principal] = info
+	}
+	return candidates, nil
+}
+
+// getPackInputs returns the candidates of the named packs.
+func getPackInputs(names []string) (map[string]utils.Info, error) {
+	result := map[string]utils.Info{}
+	for _, name := range names {
+		pack, err := LoadPack(name)
+		if err != nil {
+			return nil, err
+		}
+		candidates, err := pack.inputs()
+		if err != nil {
+			return nil, err
+		}
+		for principal, info := range candidates {
+			result[principal] = info
+		}
+	}
+	return result, nil
+}
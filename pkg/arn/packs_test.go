@@ -0,0 +1,114 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setPacksDir(t *testing.T, packs map[string]string) {
+	dir := t.TempDir()
+	for name, content := range packs {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+
+	orig := PacksDir
+	PacksDir = dir
+	t.Cleanup(func() { PacksDir = orig })
+}
+
+func TestListPacks(t *testing.T) {
+	setPacksDir(t, map[string]string{
+		"vendor.yaml": "description: Vendor roles\nversion: \"2\"\ncandidates:\n  - template: VendorRole\n",
+		"other.json":  `{"candidates": [{"template": "user/other"}]}`,
+		"README.md":   "not a pack",
+	})
+
+	packs, err := ListPacks()
+	require.NoError(t, err)
+	require.Len(t, packs, 2)
+	assert.Equal(t, "other", packs[0].Name)
+	assert.Equal(t, "vendor", packs[1].Name)
+	assert.Equal(t, "Vendor roles", packs[1].Description)
+	assert.Equal(t, "2", packs[1].Version)
+}
+
+func TestListPacks_NoDir(t *testing.T) {
+	orig := PacksDir
+	PacksDir = filepath.Join(t.TempDir(), "missing")
+	t.Cleanup(func() { PacksDir = orig })
+
+	packs, err := ListPacks()
+	require.NoError(t, err)
+	assert.Empty(t, packs)
+}
+
+func TestLoadPack_Invalid(t *testing.T) {
+	setPacksDir(t, map[string]string{
+		"typo.yaml":    "candidate:\n  - template: a\n",
+		"badvar.yaml":  "vars:\n  not-valid: [a]\ncandidates:\n  - template: a\n",
+		"novals.yaml":  "vars:\n  env: []\n",
+		"vendor.yaml":  "candidates:\n  - template: a\n",
+		"missing.yaml": "candidates:\n  - comment: no template\n",
+	})
+
+	for _, name := range []string{"typo", "badvar", "novals", "unknown", "../vendor"} {
+		_, err := LoadPack(name)
+		assert.Error(t, err, name)
+	}
+
+	pack, err := LoadPack("missing")
+	require.NoError(t, err)
+	_, err = pack.inputs()
+	assert.Error(t, err)
+}
+
+func TestGetArns_Packs(t *testing.T) {
+	setPacksDir(t, map[string]string{
+		"vendor.yaml": `
+description: Vendor roles
+vars:
+  env: [dev, prod]
+  tier: [web]
+candidates:
+  - template: VendorRole
+  - template: vendor-{{.Env}}-{{.Vars.tier}}
+    comment: Vendor environment role
+`,
+	})
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(rolesPath, []byte("own-{{.Vars.tier}}\n"), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		RolePaths:   []string{rolesPath},
+		Packs:       []string{"vendor"},
+		Vars:        map[string][]string{"env": {"staging"}, "tier": {"api"}},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+	})
+	require.NoError(t, err)
+
+	// -var overrides the pack's values, and the pack's values aren't used by other templates.
+	assert.Equal(t, map[string]utils.Info{
+		"arn:aws:iam::123456789012:root":                    {},
+		"arn:aws:iam::123456789012:role/own-api":            {Comment: " - "},
+		"arn:aws:iam::123456789012:role/VendorRole":         {Comment: " -  vendor pack"},
+		"arn:aws:iam::123456789012:role/vendor-staging-api": {Comment: " -  Vendor environment role"},
+	}, got)
+
+	got, err = GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		Packs:       []string{"vendor"},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/vendor-dev-web")
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/vendor-prod-web")
+}
@@ -0,0 +1,90 @@
+package arn
+
+import (
+	"github.com/ryanjarv/roles/pkg/utils"
+	"strings"
+)
+
+// CommonRolePaths are the IAM paths roles are most often created under, selected with -try-paths common.
+var CommonRolePaths = []string{
+	"/",
+	"/service-role/",
+	"/aws-reserved/sso.amazonaws.com/",
+	"/cdk/",
+	"/terraform/",
+	"/application/",
+	"/admin/",
+	"/ci/",
+}
+
+// ParseRolePaths parses a comma separated list of IAM paths, "common" is replaced with CommonRolePaths. The root
+// path "/" is added if it isn't in the list so names are also tried without a path.
+func ParseRolePaths(value string) []string {
+	var paths []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		path = normalizeRolePath(path)
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if path == "common" {
+			for _, common := range CommonRolePaths {
+				add(common)
+			}
+		} else {
+			add(path)
+		}
+	}
+
+	if len(paths) > 0 {
+		add("/")
+	}
+	return paths
+}
+
+// normalizeRolePath returns path with a single leading and trailing slash, like IAM writes them.
+func normalizeRolePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "/"
+	}
+	return "/" + path + "/"
+}
+
+// rolePrincipal returns the principal name of a role with the given IAM path, an empty path is the root path.
+func rolePrincipal(path, name string) string {
+	if path == "" {
+		return "role/" + name
+	}
+	return "role" + normalizeRolePath(path) + name
+}
+
+// addRolePaths adds a copy of every role without a path under each of the given paths. Roles that already have a path
+// and users are left alone.
+func addRolePaths(principals map[string]utils.Info, paths []string) map[string]utils.Info {
+	if len(paths) == 0 {
+		return principals
+	}
+
+	result := map[string]utils.Info{}
+	for principal, info := range principals {
+		name, ok := strings.CutPrefix(principal, "role/")
+		if !ok || strings.Contains(name, "/") {
+			result[principal] = info
+			continue
+		}
+
+		for _, path := range paths {
+			result["role"+path+name] = info
+		}
+	}
+	return result
+}
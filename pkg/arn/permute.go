@@ -0,0 +1,137 @@
+package arn
+
+import (
+	"github.com/ryanjarv/roles/pkg/utils"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// PermutationPrefixes and PermutationSuffixes are the words -permute adds before and after each name, the last few
+// years are also tried as suffixes.
+var (
+	PermutationPrefixes = []string{"svc", "app", "aws"}
+	PermutationSuffixes = []string{"role", "prod", "production", "dev", "staging", "test"}
+)
+
+// permutationYears is how many years back from the current one are tried as suffixes.
+const permutationYears = 3
+
+// maxRoleNameLength is the longest name IAM allows for a role.
+const maxRoleNameLength = 64
+
+// permutableName matches names made only of characters IAM allows, names with templates or generators aren't
+// permuted since splitting them into words would break them.
+var permutableName = regexp.MustCompile(`^[\w+=,.@-]+$`)
+
+// nameStyle joins the words of a name in one naming convention.
+type nameStyle func(words []string) string
+
+// nameStyles are the naming conventions -permute rewrites names in: my-role, My-Role, my_role, My_Role, MY_ROLE,
+// MyRole, myRole, and myrole.
+var nameStyles = []nameStyle{
+	joinWords("-", strings.ToLower),
+	joinWords("-", capitalize),
+	joinWords("_", strings.ToLower),
+	joinWords("_", capitalize),
+	joinWords("_", strings.ToUpper),
+	joinWords("", capitalize),
+	func(words []string) string {
+		return strings.ToLower(words[0]) + joinWords("", capitalize)(words[1:])
+	},
+	joinWords("", strings.ToLower),
+}
+
+func joinWords(sep string, format func(string) string) nameStyle {
+	return func(words []string) string {
+		formatted := make([]string, len(words))
+		for i, word := range words {
+			formatted[i] = format(word)
+		}
+		return strings.Join(formatted, sep)
+	}
+}
+
+// capitalize upper cases the first letter of word and lower cases the rest, unless word is all upper case like API.
+func capitalize(word string) string {
+	if word == strings.ToUpper(word) && len(word) > 1 {
+		return word
+	}
+	runes := []rune(strings.ToLower(word))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+// permuteName returns the variants of name in each naming convention, with each prefix, suffix, and recent year
+// added. The name itself is always included.
+func permuteName(name string, prefixes, suffixes []string, now time.Time) []string {
+	if !permutableName.MatchString(name) {
+		return []string{name}
+	}
+
+	base := words(name)
+	if len(base) == 0 {
+		return []string{name}
+	}
+
+	var affixed [][]string
+	affixed = append(affixed, base)
+	for _, prefix := range prefixes {
+		if !strings.EqualFold(prefix, base[0]) {
+			affixed = append(affixed, append([]string{prefix}, base...))
+		}
+	}
+	for _, suffix := range suffixes {
+		if !strings.EqualFold(suffix, base[len(base)-1]) {
+			affixed = append(affixed, append(append([]string{}, base...), suffix))
+		}
+	}
+	for i := range permutationYears {
+		affixed = append(affixed, append(append([]string{}, base...), strconv.Itoa(now.Year()-i)))
+	}
+
+	seen := map[string]bool{name: true}
+	result := []string{name}
+	for _, w := range affixed {
+		for _, style := range nameStyles {
+			variant := style(w)
+			if !seen[variant] && len(variant) <= maxRoleNameLength {
+				seen[variant] = true
+				result = append(result, variant)
+			}
+		}
+	}
+	return result
+}
+
+// permuteRoles adds the permutations of every role name, roles keep their IAM path and users are left alone.
+func permuteRoles(ctx *utils.Context, principals map[string]utils.Info, prefixes, suffixes []string) map[string]utils.Info {
+	now := time.Now()
+
+	result := map[string]utils.Info{}
+	for principal, info := range principals {
+		result[principal] = info
+
+		rest, ok := strings.CutPrefix(principal, "role/")
+		if !ok {
+			continue
+		}
+		path, name := "", rest
+		if i := strings.LastIndex(rest, "/"); i != -1 {
+			path, name = rest[:i+1], rest[i+1:]
+		}
+
+		for _, variant := range permuteName(name, prefixes, suffixes, now) {
+			if _, ok := result["role/"+path+variant]; !ok {
+				result["role/"+path+variant] = info
+			}
+		}
+	}
+
+	ctx.Info.Printf("permuted %d principals into %d", len(principals), len(result))
+	return result
+}
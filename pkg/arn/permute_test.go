@@ -0,0 +1,65 @@
+package arn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermuteName(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got := permuteName("DeployRole", []string{"svc"}, []string{"prod", "role"}, now)
+	assert.Equal(t, "DeployRole", got[0])
+	for _, want := range []string{
+		"deploy-role", "Deploy-Role", "deploy_role", "Deploy_Role", "DEPLOY_ROLE", "deployRole", "deployrole",
+		"svc-deploy-role", "SvcDeployRole",
+		"deploy-role-prod", "DeployRoleProd", "DEPLOY_ROLE_PROD",
+		"deploy-role-2026", "DeployRole2025", "deploy_role_2024",
+	} {
+		assert.Contains(t, got, want)
+	}
+	// The name already ends in role, so it isn't added again.
+	assert.NotContains(t, got, "deploy-role-role")
+	assert.NotContains(t, got, "deploy-role-2023")
+	assert.Len(t, got, len(dedupe(got)))
+
+	// Upper case words keep their case.
+	assert.Subset(t, permuteName("APIGateway", nil, nil, now), []string{"API-Gateway", "api-gateway", "API_GATEWAY"})
+
+	// Templates and generators aren't split into words.
+	assert.Equal(t, []string{"deploy-{{.Region}}"}, permuteName("deploy-{{.Region}}", []string{"svc"}, nil, now))
+	assert.Equal(t, []string{"deploy-[01-20]"}, permuteName("deploy-[01-20]", []string{"svc"}, nil, now))
+}
+
+func TestPermuteName_LengthLimit(t *testing.T) {
+	name := "a-very-long-role-name-that-is-already-close-to-the-iam-limit1"
+	for _, variant := range permuteName(name, PermutationPrefixes, PermutationSuffixes, time.Now()) {
+		assert.LessOrEqual(t, len(variant), maxRoleNameLength, variant)
+	}
+}
+
+func TestPermuteRoles(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	got := permuteRoles(ctx, map[string]utils.Info{
+		"role/ci/Deployer": {Comment: " deployer"},
+		"user/alice":       {},
+	}, nil, []string{"prod"})
+
+	assert.Equal(t, utils.Info{Comment: " deployer"}, got["role/ci/deployer-prod"])
+	assert.Contains(t, got, "role/ci/DEPLOYER")
+	assert.Contains(t, got, "user/alice")
+	assert.NotContains(t, got, "user/alice-prod")
+}
+
+func dedupe(values []string) map[string]bool {
+	seen := map[string]bool{}
+	for _, v := range values {
+		seen[v] = true
+	}
+	return seen
+}
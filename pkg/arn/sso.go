@@ -0,0 +1,95 @@
+package arn
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"regexp"
+	"slices"
+)
+
+// DefaultPermissionSets are the AWS managed job function policies permission sets are most often named after, they
+// are used when suffixes are given without any permission set names.
+var DefaultPermissionSets = []string{
+	"AdministratorAccess",
+	"PowerUserAccess",
+	"ReadOnlyAccess",
+	"ViewOnlyAccess",
+	"SecurityAudit",
+	"Billing",
+	"DatabaseAdministrator",
+	"NetworkAdministrator",
+	"SystemAdministrator",
+	"DataScientist",
+	"SupportUser",
+}
+
+// ssoRolePrefix is the prefix of the roles Identity Center provisions for a permission set.
+const ssoRolePrefix = "AWSReservedSSO_"
+
+// ssoRolePaths are the paths permission set roles are created under, instances in regions other than us-east-1 add
+// the region.
+var ssoRolePaths = []string{"aws-reserved/sso.amazonaws.com/", "aws-reserved/sso.amazonaws.com/{{.Region}}/"}
+
+var (
+	// ssoSuffixPattern matches the suffix of permission set roles, it's the same in every account the permission set is
+	// provisioned to.
+	ssoSuffixPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+	// ssoRolePattern matches an observed permission set role name, with or without its path.
+	ssoRolePattern = regexp.MustCompile(`(?:^|/)AWSReservedSSO_([\w+=,.@-]+)_([0-9a-f]{16})$`)
+	// ssoNamePattern matches permission set names.
+	ssoNamePattern = regexp.MustCompile(`^[\w+=,.@-]{1,32}$`)
+)
+
+// getIdentityCenterInputs returns the AWSReservedSSO_<PermissionSet>_<suffix> roles for every combination of
+// permission set name and suffix, under each path in ssoRolePaths.
+//
+// Suffixes are given inline or as lists like -exclude-accounts. Role names or ARNs of permission set roles seen
+// elsewhere, like in harvested ARNs, are also accepted and add both their suffix and permission set name, since the
+// same permission set has the same suffix in every account it's provisioned to.
+func getIdentityCenterInputs(permissionSets []string, suffixes []string) (map[string]utils.Info, error) {
+	if len(permissionSets) > 0 && len(suffixes) == 0 {
+		return nil, fmt.Errorf("permission set roles end in a random suffix, give suffixes to try with -sso-suffixes")
+	}
+
+	names, err := loadValues(permissionSets)
+	if err != nil {
+		return nil, fmt.Errorf("loading permission sets: %s", err)
+	}
+	values, err := loadValues(suffixes)
+	if err != nil {
+		return nil, fmt.Errorf("loading permission set suffixes: %s", err)
+	}
+
+	observed := map[string]bool{}
+	for value := range values {
+		if m := ssoRolePattern.FindStringSubmatch(value); m != nil {
+			names[m[1]] = true
+			observed[m[2]] = true
+		} else if ssoSuffixPattern.MatchString(value) {
+			observed[value] = true
+		} else {
+			return nil, fmt.Errorf("invalid permission set suffix %q: expected 16 lower case hex characters or a %s role name", value, ssoRolePrefix)
+		}
+	}
+	if len(observed) > 0 && len(permissionSets) == 0 {
+		for _, name := range DefaultPermissionSets {
+			names[name] = true
+		}
+	}
+
+	result := map[string]utils.Info{}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		if !ssoNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid permission set name %q: must be up to 32 letters, numbers, or +=,.@_-", name)
+		}
+		for suffix := range observed {
+			for _, path := range ssoRolePaths {
+				result["role/"+path+ssoRolePrefix+name+"_"+suffix] = utils.Info{
+					Comment: fmt.Sprintf(" Identity Center permission set %s", name),
+				}
+			}
+		}
+	}
+	return result, nil
+}
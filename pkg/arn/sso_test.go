@@ -0,0 +1,49 @@
+package arn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetIdentityCenterInputs(t *testing.T) {
+	suffixesPath := filepath.Join(t.TempDir(), "suffixes.list")
+	require.NoError(t, os.WriteFile(suffixesPath, []byte(
+		"arn:aws:iam::111111111111:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_DevOps_0123456789abcdef # harvested\n",
+	), 0o600))
+
+	got, err := getIdentityCenterInputs([]string{"AdministratorAccess"}, []string{suffixesPath, "fedcba9876543210"})
+	require.NoError(t, err)
+
+	// Both names with both suffixes, under both paths.
+	assert.Len(t, got, 2*2*2)
+	assert.Equal(t, utils.Info{Comment: " Identity Center permission set DevOps"},
+		got["role/aws-reserved/sso.amazonaws.com/{{.Region}}/AWSReservedSSO_DevOps_0123456789abcdef"])
+	assert.Contains(t, got, "role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_AdministratorAccess_fedcba9876543210")
+}
+
+func TestGetIdentityCenterInputs_DefaultPermissionSets(t *testing.T) {
+	got, err := getIdentityCenterInputs(nil, []string{"0123456789abcdef"})
+	require.NoError(t, err)
+	assert.Len(t, got, len(DefaultPermissionSets)*len(ssoRolePaths))
+	assert.Contains(t, got, "role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_ReadOnlyAccess_0123456789abcdef")
+
+	got, err = getIdentityCenterInputs(nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestGetIdentityCenterInputs_Invalid(t *testing.T) {
+	_, err := getIdentityCenterInputs([]string{"AdministratorAccess"}, nil)
+	assert.ErrorContains(t, err, "-sso-suffixes")
+
+	_, err = getIdentityCenterInputs(nil, []string{"abc"})
+	assert.ErrorContains(t, err, `invalid permission set suffix "abc"`)
+
+	_, err = getIdentityCenterInputs([]string{"has space"}, []string{"0123456789abcdef"})
+	assert.ErrorContains(t, err, `invalid permission set name "has space"`)
+}
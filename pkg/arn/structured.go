@@ -0,0 +1,161 @@
+package arn
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// structuredExtensions are the -roles and -principals files read as structured candidates instead of value # comment
+// lines, JSON is read with the YAML parser.
+var structuredExtensions = []string{".yaml", ".yml", ".json"}
+
+// structuredCandidate is an entry of a structured input file, like:
+//
+//	candidates:
+//	  - template: "{{.Env}}-deployer"
+//	    type: role
+//	    comment: CI deploy role
+//	    tags: [ci, high-value]
+//	    likelihood: 0.9
+//	    regions: [us-east-1, eu-west-1]
+type structuredCandidate struct {
+	// Template is the principal name, it can be prefixed with the principal type like role/ci/deployer.
+	Template string `yaml:"template"`
+	// Type is role, user, saml-provider, or oidc-provider. By default -roles entries are roles and -principals entries
+	// without a prefix are tried as both a role and a user, like in list files.
+	Type    string `yaml:"type"`
+	Comment string `yaml:"comment"`
+	// Tags and Likelihood are carried through to the scan results.
+	Tags       []string `yaml:"tags"`
+	Likelihood float64  `yaml:"likelihood"`
+	// Regions limits the regions {{.Region}} is expanded to, every region is used if empty.
+	Regions []string `yaml:"regions"`
+}
+
+// isStructuredInput returns true if path is a structured input file, by its extension.
+func isStructuredInput(path string) bool {
+	if u, err := url.Parse(path); err == nil && utils.IsURL(path) {
+		path = u.Path
+	}
+	return slices.Contains(structuredExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// splitStructuredInputs separates structured input files from list files and directories.
+func splitStructuredInputs(paths []string) (lists []string, structured []string) {
+	for _, path := range paths {
+		if isStructuredInput(path) {
+			structured = append(structured, path)
+		} else {
+			lists = append(lists, path)
+		}
+	}
+	return lists, structured
+}
+
+// getStructuredInputs reads structured input files, either a list of candidates or a mapping with the list under
+// candidates. defaultTypes are the principal types an entry without a type or prefix is tried as.
+func getStructuredInputs(paths []string, defaultTypes []string) (map[string]utils.Info, error) {
+	result := map[string]utils.Info{}
+	for _, path := range paths {
+		candidates, err := readStructuredInput(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := addCandidates(result, path, candidates, defaultTypes); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// addCandidates adds the principals of each candidate to result, source names where they were read from in errors.
+func addCandidates(result map[string]utils.Info, source string, candidates []structuredCandidate, defaultTypes []string) error {
+	for i, c := range candidates {
+		principals, err := c.principals(defaultTypes)
+		if err != nil {
+			return fmt.Errorf("%s entry %d: %s", source, i+1, err)
+		}
+
+		info := utils.Info{Tags: c.Tags, Likelihood: c.Likelihood, Regions: c.Regions}
+		if c.Comment != "" {
+			info.Comment = " " + c.Comment
+		}
+		for _, principal := range principals {
+			result[principal] = info
+		}
+	}
+	return nil
+}
+
+func readStructuredInput(path string) ([]structuredCandidate, error) {
+	if !utils.IsURL(path) {
+		var err error
+		if path, err = utils.ExpandPath(path); err != nil {
+			return nil, err
+		}
+	}
+	data, err := utils.ReadList(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	var candidates []structuredCandidate
+	root := doc.Content[0]
+	if root.Kind == yaml.MappingNode {
+		var wrapped struct {
+			Candidates []structuredCandidate `yaml:"candidates"`
+		}
+		err = root.Decode(&wrapped)
+		candidates = wrapped.Candidates
+	} else {
+		err = root.Decode(&candidates)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	return candidates, nil
+}
+
+// principals returns the principal names the candidate is tried as.
+func (c structuredCandidate) principals(defaultTypes []string) ([]string, error) {
+	template := strings.TrimSpace(c.Template)
+	if template == "" {
+		return nil, fmt.Errorf("missing template")
+	}
+
+	prefixed := slices.ContainsFunc(principalPrefixes, func(prefix string) bool {
+		return strings.HasPrefix(template, prefix)
+	})
+
+	switch {
+	case c.Type == "" && prefixed:
+		return []string{template}, nil
+	case c.Type == "":
+		var result []string
+		for _, principalType := range defaultTypes {
+			result = append(result, principalType+"/"+template)
+		}
+		return result, nil
+	case !slices.Contains(principalPrefixes, c.Type+"/"):
+		return nil, fmt.Errorf("unknown type %q: must be role, user, saml-provider, or oidc-provider", c.Type)
+	case prefixed && !strings.HasPrefix(template, c.Type+"/"):
+		return nil, fmt.Errorf("template %q doesn't match type %s", template, c.Type)
+	case prefixed:
+		return []string{template}, nil
+	default:
+		return []string{c.Type + "/" + template}, nil
+	}
+}
@@ -0,0 +1,88 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStructuredInputs(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "roles.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+- template: deployer
+  comment: CI deploy role
+  tags: [ci]
+  likelihood: 0.9
+- template: alice
+  type: user
+- template: ci/bot
+`), 0o600))
+	jsonPath := filepath.Join(dir, "roles.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"candidates": [
+		{"template": "saml-provider/Okta"},
+		{"template": "regional-{{.Region}}", "regions": ["eu-west-1"]}
+	]}`), 0o600))
+
+	got, err := getStructuredInputs([]string{yamlPath, jsonPath}, []string{"role", "user"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]utils.Info{
+		"role/deployer":             {Comment: " CI deploy role", Tags: []string{"ci"}, Likelihood: 0.9},
+		"user/deployer":             {Comment: " CI deploy role", Tags: []string{"ci"}, Likelihood: 0.9},
+		"user/alice":                {},
+		"role/ci/bot":               {},
+		"user/ci/bot":               {},
+		"saml-provider/Okta":        {},
+		"role/regional-{{.Region}}": {Regions: []string{"eu-west-1"}},
+		"user/regional-{{.Region}}": {Regions: []string{"eu-west-1"}},
+	}, got)
+}
+
+func TestGetStructuredInputs_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"missing.yaml":  "- comment: no template\n",
+		"type.yaml":     "- template: a\n  type: group\n",
+		"mismatch.yaml": "- template: user/a\n  type: role\n",
+		"syntax.json":   "[{",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+		_, err := getStructuredInputs([]string{path}, []string{"role"})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestGetArns_StructuredInput(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.yml")
+	require.NoError(t, os.WriteFile(rolesPath, []byte(`
+- template: deploy-{{.Region}}
+  comment: Regional deployer
+  tags: [deploy]
+  likelihood: 0.5
+  regions: [us-west-2]
+`), 0o600))
+	listPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(listPath, []byte("plain\n"), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		RolePaths:   []string{rolesPath, listPath},
+		Regions:     map[string]utils.Info{"us-east-1": {}, "us-west-2": {}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]utils.Info{
+		"arn:aws:iam::123456789012:root":                  {},
+		"arn:aws:iam::123456789012:role/plain":            {Comment: " - "},
+		"arn:aws:iam::123456789012:role/deploy-us-west-2": {Comment: " -  Regional deployer", Tags: []string{"deploy"}, Likelihood: 0.5},
+	}, got)
+}
@@ -0,0 +1,146 @@
+package arn
+
+import (
+	"container/heap"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// suggestMaxWords is the most words a suggested name has, longer names are rarely new combinations.
+const suggestMaxWords = 6
+
+// suggestMaxExpansions bounds the search for suggestions so a large model can't search forever.
+const suggestMaxExpansions = 1_000_000
+
+// Suggestion is a name the model considers likely, Score is its probability under the model.
+type Suggestion struct {
+	Name  string
+	Score float64
+}
+
+// NameModel is a word bigram model of role names, it suggests new names built from word transitions seen in known
+// names, like ci-deploy-prod from ci-deploy-role and cd-deploy-prod.
+type NameModel struct {
+	// transitions counts how often each word follows another, the empty word starts and ends names.
+	transitions map[string]map[string]int
+	totals      map[string]int
+	// style is the most common naming convention of the training names, suggestions are written in it.
+	style nameStyle
+}
+
+// NewNameModel trains a model on names, paths are ignored.
+func NewNameModel(names []string) *NameModel {
+	m := &NameModel{
+		transitions: map[string]map[string]int{},
+		totals:      map[string]int{},
+		style:       nameStyles[0],
+	}
+
+	styleCounts := make([]int, len(nameStyles))
+	for _, name := range names {
+		name = name[strings.LastIndex(name, "/")+1:]
+		w := words(name)
+		if len(w) == 0 {
+			continue
+		}
+
+		lowered := make([]string, len(w))
+		for i, word := range w {
+			lowered[i] = strings.ToLower(word)
+		}
+		prev := ""
+		for _, word := range append(lowered, "") {
+			if m.transitions[prev] == nil {
+				m.transitions[prev] = map[string]int{}
+			}
+			m.transitions[prev][word]++
+			m.totals[prev]++
+			prev = word
+		}
+
+		for i, style := range nameStyles {
+			if style(w) == name {
+				styleCounts[i]++
+				break
+			}
+		}
+	}
+
+	best := 0
+	for i, count := range styleCounts {
+		if count > styleCounts[best] {
+			best = i
+		}
+	}
+	m.style = nameStyles[best]
+	return m
+}
+
+// Suggest returns up to count of the most likely names, ordered from most to least likely. Names exclude returns true
+// for are skipped, usually every name that was already scanned, training names included.
+func (m *NameModel) Suggest(count int, exclude func(name string) bool) []Suggestion {
+	var result []Suggestion
+	seen := map[string]bool{}
+
+	queue := &candidateQueue{{score: 1}}
+	for expansions := 0; queue.Len() > 0 && len(result) < count && expansions < suggestMaxExpansions; expansions++ {
+		c := heap.Pop(queue).(candidate)
+		if c.done {
+			result = append(result, Suggestion{Name: m.style(c.words), Score: c.score})
+			continue
+		}
+
+		last := ""
+		if len(c.words) > 0 {
+			last = c.words[len(c.words)-1]
+		}
+
+		// Sorted so suggestions with the same score always come out in the same order.
+		for _, next := range slices.Sorted(maps.Keys(m.transitions[last])) {
+			score := c.score * float64(m.transitions[last][next]) / float64(m.totals[last])
+
+			if next == "" {
+				key := strings.Join(c.words, " ")
+				if len(c.words) == 0 || seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				name := m.style(c.words)
+				if len(name) > maxRoleNameLength || (exclude != nil && exclude(name)) {
+					continue
+				}
+				heap.Push(queue, candidate{words: c.words, score: score, done: true})
+				continue
+			}
+
+			if len(c.words) >= suggestMaxWords || slices.Contains(c.words, next) {
+				continue
+			}
+			heap.Push(queue, candidate{words: append(c.words[:len(c.words):len(c.words)], next), score: score})
+		}
+	}
+	return result
+}
+
+// candidate is a partial name in the search, done candidates are complete names waiting to be emitted in order.
+type candidate struct {
+	words []string
+	score float64
+	done  bool
+}
+
+// candidateQueue is a max-heap of candidates by score.
+type candidateQueue []candidate
+
+func (q candidateQueue) Len() int           { return len(q) }
+func (q candidateQueue) Less(i, j int) bool { return q[i].score > q[j].score }
+func (q candidateQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *candidateQueue) Push(x any)        { *q = append(*q, x.(candidate)) }
+func (q *candidateQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
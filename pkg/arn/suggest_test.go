@@ -0,0 +1,34 @@
+package arn
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameModel_Suggest(t *testing.T) {
+	known := []string{"ci-deploy-role", "cd-deploy-prod", "cd-deploy-prod", "ci-build-dev"}
+	model := NewNameModel(append(known, "path/ci-deploy-role"))
+	exclude := func(name string) bool { return lo.Contains(known, name) }
+
+	got := model.Suggest(10, exclude)
+	names := lo.Map(got, func(s Suggestion, _ int) string { return s.Name })
+	assert.ElementsMatch(t, []string{"cd-deploy-role", "ci-deploy-prod"}, names)
+
+	for i := 1; i < len(got); i++ {
+		assert.GreaterOrEqual(t, got[i-1].Score, got[i].Score)
+	}
+	assert.Equal(t, got, model.Suggest(10, exclude), "suggestions should be deterministic")
+	assert.Len(t, model.Suggest(1, exclude), 1)
+}
+
+func TestNameModel_Style(t *testing.T) {
+	model := NewNameModel([]string{"CiDeployRole", "CdDeployProd", "ci-other"})
+	got := model.Suggest(10, func(name string) bool { return name == "CiDeployRole" || name == "CdDeployProd" })
+	assert.Contains(t, lo.Map(got, func(s Suggestion, _ int) string { return s.Name }), "CiDeployProd")
+}
+
+func TestNameModel_Empty(t *testing.T) {
+	assert.Empty(t, NewNameModel(nil).Suggest(10, nil))
+}
@@ -0,0 +1,571 @@
+package arn
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// terraformRoleTypes are the Terraform resource and data source types that name an IAM role.
+var terraformRoleTypes = []string{"aws_iam_role"}
+
+// terraformModule is every file in one Terraform directory, variables and locals are scoped to it.
+type terraformModule struct {
+	// roles are the roles declared in the directory's .tf files.
+	roles []terraformRole
+	// defaults are variable defaults, tfvars are the values variables are set to in .tfvars files.
+	defaults map[string]string
+	tfvars   map[string][]string
+	locals   map[string]string
+}
+
+// terraformRole is a role name and path as written in HCL, they may still contain interpolations.
+type terraformRole struct {
+	address string
+	file    string
+	name    string
+	path    string
+}
+
+// getTerraformInputs returns the roles declared in Terraform state files (.tfstate) and configuration (.tf), a
+// directory is searched recursively.
+//
+// Interpolations in configuration are resolved where the value is known: variables set with vars become
+// {{.Vars.name}} so each of their values is tried, otherwise variable defaults, .tfvars files, and locals are used.
+// The caller identity and region data sources become {{.AccountId}} and {{.Region}}. Names that depend on anything
+// else, such as name_prefix or another resource's attributes, can't be guessed and are skipped.
+func getTerraformInputs(ctx *utils.Context, paths []string, vars map[string][]string) (map[string]utils.Info, error) {
+	modules := map[string]*terraformModule{}
+	result := map[string]utils.Info{}
+
+	for _, path := range paths {
+		path, err := utils.ExpandPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if file != path && (d.Name() == ".terraform" || strings.HasPrefix(d.Name(), ".")) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			switch {
+			case strings.HasSuffix(file, ".tfstate"), strings.HasSuffix(file, ".tfstate.backup"):
+				roles, err := readTerraformState(file)
+				if err != nil {
+					return err
+				}
+				for name, info := range roles {
+					result[name] = info
+				}
+			case strings.HasSuffix(file, ".tf"), strings.HasSuffix(file, ".tfvars"):
+				dir := filepath.Dir(file)
+				if modules[dir] == nil {
+					modules[dir] = &terraformModule{defaults: map[string]string{}, tfvars: map[string][]string{}, locals: map[string]string{}}
+				}
+				if err := modules[dir].read(file); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading terraform: %s", err)
+		}
+	}
+
+	for _, module := range modules {
+		for _, role := range module.roles {
+			names := module.resolve(role.name, vars)
+			if names == nil {
+				ctx.Debug.Printf("skipping %s in %s: can't resolve name %q", role.address, role.file, role.name)
+				continue
+			}
+			paths := module.resolve(role.path, vars)
+			if paths == nil {
+				ctx.Debug.Printf("skipping %s in %s: can't resolve path %q", role.address, role.file, role.path)
+				continue
+			}
+
+			for _, name := range names {
+				for _, path := range paths {
+					result[rolePrincipal(path, name)] = utils.Info{Comment: fmt.Sprintf(" %s in %s", role.address, role.file)}
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// terraformState is the part of the version 4 state format that describes resources.
+type terraformState struct {
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			Attributes struct {
+				Name string `json:"name"`
+				Path string `json:"path"`
+			} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// readTerraformState returns the roles in a state file, their names are already known so no templating is needed.
+func readTerraformState(file string) (map[string]utils.Info, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var state terraformState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", file, err)
+	}
+
+	result := map[string]utils.Info{}
+	for _, resource := range state.Resources {
+		if !slices.Contains(terraformRoleTypes, resource.Type) {
+			continue
+		}
+
+		address := resource.Type + "." + resource.Name
+		if resource.Mode == "data" {
+			address = "data." + address
+		}
+		if resource.Module != "" {
+			address = resource.Module + "." + address
+		}
+
+		for _, instance := range resource.Instances {
+			if instance.Attributes.Name == "" {
+				continue
+			}
+			result[rolePrincipal(instance.Attributes.Path, instance.Attributes.Name)] = utils.Info{
+				Comment: fmt.Sprintf(" %s in %s", address, file),
+			}
+		}
+	}
+	return result, nil
+}
+
+// read adds the roles, variable defaults, locals, and .tfvars values in file to the module.
+func (m *terraformModule) read(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	body, err := parseHCL(string(data))
+	if err != nil {
+		return fmt.Errorf("parsing %s: %s", file, err)
+	}
+
+	if strings.HasSuffix(file, ".tfvars") {
+		for name, value := range body.attributes {
+			if !slices.Contains(m.tfvars[name], value) {
+				m.tfvars[name] = append(m.tfvars[name], value)
+			}
+		}
+		return nil
+	}
+
+	for _, block := range body.blocks {
+		switch {
+		case block.kind == "variable" && len(block.labels) == 1:
+			if value, ok := block.body.attributes["default"]; ok {
+				m.defaults[block.labels[0]] = value
+			}
+		case block.kind == "locals":
+			for name, value := range block.body.attributes {
+				m.locals[name] = value
+			}
+		case (block.kind == "resource" || block.kind == "data") && len(block.labels) == 2 && slices.Contains(terraformRoleTypes, block.labels[0]):
+			name, ok := block.body.attributes["name"]
+			if !ok {
+				// name_prefix gets a random suffix and an unset name is fully random, neither can be guessed.
+				continue
+			}
+
+			address := block.labels[0] + "." + block.labels[1]
+			if block.kind == "data" {
+				address = "data." + address
+			}
+			m.roles = append(m.roles, terraformRole{
+				address: address,
+				file:    file,
+				name:    name,
+				path:    block.body.attributes["path"],
+			})
+		}
+	}
+	return nil
+}
+
+// interpolationPattern matches ${...} interpolations, nested braces aren't supported since they are never simple
+// references.
+var interpolationPattern = regexp.MustCompile(`\$\{([^{}]*)\}`)
+
+// terraformDataValues maps data source attributes to the template variable with the same value.
+var terraformDataValues = map[*regexp.Regexp]string{
+	regexp.MustCompile(`^data\.aws_caller_identity\.[\w-]+\.account_id$`): "{{.AccountId}}",
+	regexp.MustCompile(`^data\.aws_region\.[\w-]+\.(name|region)$`):       "{{.Region}}",
+	regexp.MustCompile(`^data\.aws_partition\.[\w-]+\.partition$`):        "{{.Partition}}",
+}
+
+// resolve returns every name value can be with its interpolations replaced by their known values or template
+// actions, or nil if any can't be resolved. A variable set to different values in several .tfvars files resolves to
+// each of them.
+func (m *terraformModule) resolve(value string, vars map[string][]string) []string {
+	return m.resolveDepth(value, vars, 0)
+}
+
+func (m *terraformModule) resolveDepth(value string, vars map[string][]string, depth int) []string {
+	// Locals referring to each other in a loop would never finish resolving.
+	if depth > 10 || strings.Contains(value, "%{") {
+		return nil
+	}
+
+	results := []string{""}
+	prev := 0
+	for _, loc := range interpolationPattern.FindAllStringSubmatchIndex(value, -1) {
+		ref := strings.TrimSpace(value[loc[2]:loc[3]])
+
+		var values []string
+		for _, known := range m.values(ref, vars) {
+			values = append(values, m.resolveDepth(known, vars, depth+1)...)
+		}
+		if len(values) == 0 {
+			return nil
+		}
+
+		results = cartesianAppend(results, value[prev:loc[0]], values)
+		prev = loc[1]
+	}
+
+	for i := range results {
+		results[i] += value[prev:]
+	}
+	return results
+}
+
+// values returns the known values of a reference, they may contain further interpolations.
+func (m *terraformModule) values(ref string, vars map[string][]string) []string {
+	if name, isVar := strings.CutPrefix(ref, "var."); isVar {
+		if _, set := vars[name]; set {
+			return []string{"{{.Vars." + name + "}}"}
+		}
+		if values := m.tfvars[name]; len(values) > 0 {
+			return values
+		}
+		if value, ok := m.defaults[name]; ok {
+			return []string{value}
+		}
+		return nil
+	}
+	if name, isLocal := strings.CutPrefix(ref, "local."); isLocal {
+		if value, ok := m.locals[name]; ok {
+			return []string{value}
+		}
+		return nil
+	}
+	for pattern, action := range terraformDataValues {
+		if pattern.MatchString(ref) {
+			return []string{action}
+		}
+	}
+	return nil
+}
+
+// hclBody is the part of an HCL body needed to find role names: attributes with a single string or literal value,
+// and nested blocks. Attributes set to any other expression are left out.
+type hclBody struct {
+	attributes map[string]string
+	blocks     []hclBlock
+}
+
+type hclBlock struct {
+	kind   string
+	labels []string
+	body   hclBody
+}
+
+type hclTokenKind int
+
+const (
+	hclIdent hclTokenKind = iota
+	hclString
+	hclOpenBrace
+	hclCloseBrace
+	hclEquals
+	hclNewline
+	hclOther
+)
+
+type hclToken struct {
+	kind  hclTokenKind
+	value string
+}
+
+// parseHCL parses enough of an HCL file to find blocks and their string attributes, it doesn't validate the file.
+func parseHCL(src string) (hclBody, error) {
+	tokens, err := lexHCL(src)
+	if err != nil {
+		return hclBody{}, err
+	}
+
+	p := &hclParser{tokens: tokens}
+	body := p.body()
+	if p.pos < len(p.tokens) {
+		return body, fmt.Errorf("unexpected }")
+	}
+	return body, nil
+}
+
+type hclParser struct {
+	tokens []hclToken
+	pos    int
+}
+
+func (p *hclParser) peek(offset int) hclToken {
+	if p.pos+offset >= len(p.tokens) {
+		return hclToken{kind: hclNewline}
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *hclParser) body() hclBody {
+	body := hclBody{attributes: map[string]string{}}
+
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		switch {
+		case tok.kind == hclCloseBrace:
+			return body
+		case tok.kind == hclIdent && p.peek(1).kind == hclEquals:
+			p.pos += 2
+			if value, ok := p.value(); ok {
+				body.attributes[tok.value] = value
+			}
+		case tok.kind == hclIdent:
+			if block, ok := p.block(); ok {
+				body.blocks = append(body.blocks, block)
+			}
+		default:
+			p.pos++
+		}
+	}
+	return body
+}
+
+// block parses a block header and body, ok is false if the tokens weren't a block.
+func (p *hclParser) block() (hclBlock, bool) {
+	block := hclBlock{kind: p.tokens[p.pos].value}
+	p.pos++
+
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		switch tok.kind {
+		case hclIdent, hclString:
+			block.labels = append(block.labels, tok.value)
+			p.pos++
+		case hclOpenBrace:
+			p.pos++
+			block.body = p.body()
+			p.pos++
+			return block, true
+		default:
+			return block, false
+		}
+	}
+	return block, false
+}
+
+// value consumes an attribute's expression, ok is true if it was a single string or literal.
+func (p *hclParser) value() (string, bool) {
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		if tok.kind == hclNewline && depth == 0 {
+			break
+		}
+		if tok.kind == hclCloseBrace && depth == 0 {
+			// The end of a single line block, leave it for the block's body.
+			break
+		}
+
+		switch {
+		case tok.kind == hclOpenBrace || tok.value == "(" || tok.value == "[":
+			depth++
+		case tok.kind == hclCloseBrace || tok.value == ")" || tok.value == "]":
+			depth--
+		}
+		p.pos++
+	}
+
+	expr := p.tokens[start:p.pos]
+	if len(expr) != 1 {
+		return "", false
+	}
+	switch expr[0].kind {
+	case hclString:
+		return expr[0].value, true
+	case hclIdent:
+		// Bare references like var.name are written as interpolations so they resolve the same way.
+		if strings.HasPrefix(expr[0].value, "var.") || strings.HasPrefix(expr[0].value, "local.") {
+			return "${" + expr[0].value + "}", true
+		}
+		if expr[0].value == "null" {
+			return "", false
+		}
+		return expr[0].value, true
+	}
+	return "", false
+}
+
+func lexHCL(src string) ([]hclToken, error) {
+	var tokens []hclToken
+	emit := func(kind hclTokenKind, value string) {
+		tokens = append(tokens, hclToken{kind: kind, value: value})
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			emit(hclNewline, "")
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment")
+			}
+			i += end + 4
+		case c == '"':
+			value, n, err := lexHCLString(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			emit(hclString, value)
+			i += n
+		case strings.HasPrefix(src[i:], "<<"):
+			n, err := skipHeredoc(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			emit(hclOther, "<<")
+			i += n
+		case c == '{':
+			emit(hclOpenBrace, "{")
+			i++
+		case c == '}':
+			emit(hclCloseBrace, "}")
+			i++
+		case c == '=' && (i+1 >= len(src) || (src[i+1] != '=' && src[i+1] != '>')):
+			emit(hclEquals, "=")
+			i++
+		case isHCLIdentChar(c):
+			start := i
+			for i < len(src) && isHCLIdentChar(src[i]) {
+				i++
+			}
+			emit(hclIdent, src[start:i])
+		default:
+			emit(hclOther, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func isHCLIdentChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c == '*' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// lexHCLString returns the unescaped contents of the quoted string at the start of src and its length, quotes inside
+// interpolations don't end the string.
+func lexHCLString(src string) (string, int, error) {
+	var value strings.Builder
+	depth := 0
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			default:
+				value.WriteByte(src[i])
+			}
+		case c == '"' && depth == 0:
+			return value.String(), i + 1, nil
+		case c == '$' && i+1 < len(src) && src[i+1] == '{', c == '%' && i+1 < len(src) && src[i+1] == '{':
+			depth++
+			value.WriteString(src[i : i+2])
+			i++
+		case c == '}' && depth > 0:
+			depth--
+			value.WriteByte(c)
+		case c == '"' && depth > 0:
+			// A string inside an interpolation, copy it through without ending the outer string.
+			end := strings.IndexByte(src[i+1:], '"')
+			if end == -1 {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			value.WriteString(src[i : i+end+2])
+			i += end + 1
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// skipHeredoc returns the length of the heredoc at the start of src, heredocs are never role names so their contents
+// are discarded.
+func skipHeredoc(src string) (int, error) {
+	line, _, found := strings.Cut(src, "\n")
+	if !found {
+		return 0, fmt.Errorf("unterminated heredoc")
+	}
+	marker := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "<<"), "-"))
+
+	// The newline after the closing marker is left in place since it ends the attribute.
+	offset := len(line) + 1
+	for offset < len(src) {
+		next, _, _ := strings.Cut(src[offset:], "\n")
+		offset += len(next)
+		if strings.TrimSpace(next) == marker {
+			return offset, nil
+		}
+		offset++
+	}
+	return 0, fmt.Errorf("unterminated heredoc %s", marker)
+}
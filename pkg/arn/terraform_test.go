@@ -0,0 +1,132 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTerraformInputs(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+
+	writeFile := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	writeFile("main.tf", `
+variable "env" {
+  default = "dev"
+}
+
+variable "team" {
+  type = string
+}
+
+locals {
+  prefix = "acme-${var.env}" # comment
+}
+
+/* block comment with resource "aws_iam_role" "x" { name = "Commented" } */
+
+resource "aws_iam_role" "deploy" {
+  name = "${local.prefix}-deploy"
+  path = "/ci/"
+  assume_role_policy = <<EOF
+{"Statement": [{"Principal": {"Service": "ec2.amazonaws.com"}}]}
+EOF
+  tags = {
+    Name = "not-a-role"
+  }
+}
+
+resource "aws_iam_role" "regional" {
+  name = "app-${data.aws_region.current.name}-${data.aws_caller_identity.current.account_id}"
+}
+
+resource "aws_iam_role" "team" {
+  name = "${var.team}-admin"
+}
+
+resource "aws_iam_role" "bare" {
+  name = var.env
+}
+
+resource "aws_iam_role" "prefixed" {
+  name_prefix = "random-"
+}
+
+resource "aws_iam_role" "computed" {
+  name = format("%s-role", var.env)
+}
+
+data "aws_iam_role" "existing" { name = "OrganizationAccountAccessRole" }
+`)
+	writeFile("prod.tfvars", `env = "prod"`)
+	writeFile("staging.tfvars", `env = "staging"`)
+	writeFile("state/terraform.tfstate", `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "aws_iam_role", "name": "app", "instances": [
+      {"attributes": {"name": "app-role", "path": "/service-role/"}}
+    ]},
+    {"mode": "managed", "type": "aws_s3_bucket", "name": "logs", "instances": [{"attributes": {"name": "logs"}}]}
+  ]
+}`)
+
+	got, err := getTerraformInputs(ctx, []string{dir}, nil)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"role/ci/acme-prod-deploy",
+		"role/ci/acme-staging-deploy",
+		"role/app-{{.Region}}-{{.AccountId}}",
+		"role/prod",
+		"role/staging",
+		"role/OrganizationAccountAccessRole",
+		"role/service-role/app-role",
+	}, lo.Keys(got))
+	assert.Equal(t, " aws_iam_role.app in "+filepath.Join(dir, "state/terraform.tfstate"), got["role/service-role/app-role"].Comment)
+
+	// Without .tfvars the default is used, and variables set with -var are left to the template.
+	got, err = getTerraformInputs(ctx, []string{filepath.Join(dir, "main.tf")}, map[string][]string{"team": {"infra", "data"}})
+	require.NoError(t, err)
+	assert.Contains(t, got, "role/{{.Vars.team}}-admin")
+	assert.Contains(t, got, "role/ci/acme-dev-deploy")
+}
+
+func TestGetTerraformInputs_InvalidState(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	path := filepath.Join(t.TempDir(), "terraform.tfstate")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+
+	_, err := getTerraformInputs(ctx, []string{path}, nil)
+	assert.ErrorContains(t, err, "parsing "+path)
+}
+
+func TestGetArns_FromTerraform(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(`
+resource "aws_iam_role" "team" {
+  name = "${var.team}-admin-${data.aws_region.current.name}"
+}
+`), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr:    "123456789012",
+		TerraformPaths: []string{dir},
+		Vars:           map[string][]string{"team": {"infra", "data"}},
+		Regions:        map[string]utils.Info{"us-east-1": {}},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/infra-admin-us-east-1")
+	assert.Contains(t, got, "arn:aws:iam::123456789012:role/data-admin-us-east-1")
+}
@@ -0,0 +1,126 @@
+package arn
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateUsage is the roleData a principal template references, GetArns only expands the regions and variables a
+// template uses so one that doesn't reference {{.Region}} isn't executed once per region for the same ARN.
+type templateUsage struct {
+	region bool
+	vars   map[string]bool
+	// all is set when the usage can't be worked out, like {{.}} or a field of a variable bound by with or range, so
+	// every region and variable is expanded as if the template used them.
+	all bool
+}
+
+// varFields are the roleData fields that are set from user variables.
+var varFields = map[string]string{"Env": "env", "Stage": "stage", "Team": "team"}
+
+// fixedFields are the roleData fields that only depend on the account.
+var fixedFields = []string{"Partition", "AccountId", "ShortAccountId"}
+
+// getTemplateUsage returns what principal references, templates that fail to parse are treated as using everything so
+// the error is reported when they are executed.
+func getTemplateUsage(principal string) templateUsage {
+	usage := templateUsage{vars: map[string]bool{}}
+	if !strings.Contains(principal, "{{") {
+		return usage
+	}
+
+	tmpl, err := template.New(principal).Funcs(templateFuncs).Parse(principal)
+	if err != nil || tmpl.Tree == nil {
+		usage.all = true
+		return usage
+	}
+	usage.walk(tmpl.Tree.Root)
+	return usage
+}
+
+func (u *templateUsage) walk(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			u.walk(child)
+		}
+	case *parse.ActionNode:
+		u.walk(n.Pipe)
+	case *parse.IfNode:
+		u.walkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		u.walkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		u.walkBranch(&n.BranchNode)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			u.walk(cmd)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			u.walk(arg)
+		}
+	case *parse.ChainNode:
+		u.walk(n.Node)
+	case *parse.FieldNode:
+		u.field(n.Ident)
+	case *parse.VariableNode:
+		// $ is the top level data, other variables hold the result of a pipeline that was already walked.
+		if n.Ident[0] == "$" {
+			u.field(n.Ident[1:])
+		}
+	case *parse.DotNode, *parse.TemplateNode:
+		u.all = true
+	}
+}
+
+func (u *templateUsage) walkBranch(n *parse.BranchNode) {
+	u.walk(n.Pipe)
+	u.walk(n.List)
+	u.walk(n.ElseList)
+}
+
+// field records a reference to a roleData field, anything else is likely a field of a variable bound by with or range.
+func (u *templateUsage) field(ident []string) {
+	switch {
+	case len(ident) == 0:
+		u.all = true
+	case ident[0] == "Region":
+		u.region = true
+	case ident[0] == "Vars" && len(ident) > 1:
+		u.vars[ident[1]] = true
+	case varFields[ident[0]] != "":
+		u.vars[varFields[ident[0]]] = true
+	case slices.Contains(fixedFields, ident[0]):
+	default:
+		u.all = true
+	}
+}
+
+// regions returns the regions to execute a template with, a template that doesn't reference the region is executed
+// once with an empty one.
+func (u templateUsage) regions(regions []string) []string {
+	if u.region || u.all {
+		return regions
+	}
+	return []string{""}
+}
+
+// combinations returns the combinations of the variables the template uses.
+func (u templateUsage) combinations(vars map[string][]string) []map[string]string {
+	if u.all {
+		return varCombinations(vars)
+	}
+	used := maps.Clone(vars)
+	maps.DeleteFunc(used, func(name string, _ []string) bool { return !u.vars[name] })
+	return varCombinations(used)
+}
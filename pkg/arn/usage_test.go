@@ -0,0 +1,63 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTemplateUsage(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want templateUsage
+	}{
+		{tmpl: "role/deployer", want: templateUsage{vars: map[string]bool{}}},
+		{tmpl: "role/deployer-{{.AccountId}}-{{.ShortAccountId}}", want: templateUsage{vars: map[string]bool{}}},
+		{tmpl: "role/deployer-{{.Region}}", want: templateUsage{region: true, vars: map[string]bool{}}},
+		{tmpl: `role/{{.Region | replace "-" ""}}`, want: templateUsage{region: true, vars: map[string]bool{}}},
+		{tmpl: "role/{{$.Region}}", want: templateUsage{region: true, vars: map[string]bool{}}},
+		{tmpl: "role/{{.Env}}-{{.Vars.app | pascal}}", want: templateUsage{vars: map[string]bool{"env": true, "app": true}}},
+		{tmpl: "role/{{if .Team}}{{.Team}}-{{end}}deployer", want: templateUsage{vars: map[string]bool{"team": true}}},
+		{tmpl: "role/{{with .Vars}}{{.app}}{{end}}", want: templateUsage{vars: map[string]bool{}, all: true}},
+		{tmpl: "role/{{.}}", want: templateUsage{vars: map[string]bool{}, all: true}},
+		{tmpl: "role/{{.Region", want: templateUsage{vars: map[string]bool{}, all: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			assert.Equal(t, tt.want, getTemplateUsage(tt.tmpl))
+		})
+	}
+}
+
+func TestGetArns_OnlyExpandsUsedDimensions(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(rolesPath, []byte("deployer\nregional-{{.Region}}\n{{.Env}}-app\n"), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012",
+		RolePaths:   []string{rolesPath},
+		Regions:     map[string]utils.Info{"us-east-1": {}, "us-west-2": {}},
+		Vars: map[string][]string{
+			"env":  {"dev", "prod"},
+			"team": {"a", "b", "c"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"arn:aws:iam::123456789012:root",
+		"arn:aws:iam::123456789012:role/deployer",
+		"arn:aws:iam::123456789012:role/regional-us-east-1",
+		"arn:aws:iam::123456789012:role/regional-us-west-2",
+		"arn:aws:iam::123456789012:role/dev-app",
+		"arn:aws:iam::123456789012:role/prod-app",
+	}, lo.Keys(got))
+}
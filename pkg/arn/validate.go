@@ -0,0 +1,137 @@
+package arn
+
+import (
+	"errors"
+	"fmt"
+	awsarn "github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+	// iamNamePattern is the characters IAM allows in role and user names.
+	iamNamePattern = regexp.MustCompile(`^[\w+=,.@-]+$`)
+	// iamPathPattern is the characters IAM allows in paths.
+	iamPathPattern  = regexp.MustCompile(`^[\x21-\x7e]*$`)
+	samlNamePattern = regexp.MustCompile(`^[\w.-]+$`)
+	oidcHostPattern = regexp.MustCompile(`^[\w.-]+(:\d+)?(/[\x21-\x7e]*)?$`)
+)
+
+// partitions are the partitions candidates can be generated in.
+var partitions = []string{"aws", "aws-cn", "aws-us-gov"}
+
+// maxIAMPathLength is the longest path IAM allows.
+const maxIAMPathLength = 512
+
+// InvalidArnError describes why a candidate isn't a valid IAM principal ARN, Value is the part of the ARN at fault.
+type InvalidArnError struct {
+	Reason string
+	Value  string
+}
+
+func (e *InvalidArnError) Error() string {
+	if e.Value == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %q", e.Reason, e.Value)
+}
+
+func invalidArn(reason, value string) error {
+	return &InvalidArnError{Reason: reason, Value: value}
+}
+
+// ValidateArn returns an *InvalidArnError if principalArn isn't a valid IAM principal ARN, or nil if it is.
+func ValidateArn(principalArn string) error {
+	parsed, err := awsarn.Parse(principalArn)
+	if err != nil {
+		return invalidArn("not an ARN", "")
+	}
+	if !slices.Contains(partitions, parsed.Partition) {
+		return invalidArn("unknown partition", parsed.Partition)
+	}
+	if parsed.Service != "iam" {
+		return invalidArn("not an IAM service", parsed.Service)
+	}
+	if parsed.Region != "" {
+		return invalidArn("IAM ARNs don't have a region", parsed.Region)
+	}
+	if !accountIDPattern.MatchString(parsed.AccountID) {
+		return invalidArn("account ID isn't twelve digits", parsed.AccountID)
+	}
+	if parsed.Resource == "root" {
+		return nil
+	}
+
+	kind, rest, _ := strings.Cut(parsed.Resource, "/")
+	switch kind {
+	case "role", "user":
+		path, name := "", rest
+		if i := strings.LastIndex(rest, "/"); i != -1 {
+			path, name = rest[:i], rest[i+1:]
+		}
+		if name == "" {
+			return invalidArn("empty name", rest)
+		}
+		if len(name) > maxRoleNameLength {
+			return invalidArn(fmt.Sprintf("name longer than %d characters", maxRoleNameLength), name)
+		}
+		if !iamNamePattern.MatchString(name) {
+			return invalidArn("illegal characters in name", name)
+		}
+		if path != "" {
+			path = "/" + path + "/"
+		}
+		if len(path) > maxIAMPathLength || !iamPathPattern.MatchString(path) || strings.Contains(path, "//") {
+			return invalidArn("invalid path", path)
+		}
+	case "saml-provider":
+		if !samlNamePattern.MatchString(rest) {
+			return invalidArn("invalid SAML provider name", rest)
+		}
+	case "oidc-provider":
+		if !oidcHostPattern.MatchString(rest) {
+			return invalidArn("invalid OIDC provider", rest)
+		}
+	default:
+		return invalidArn("unknown principal type", kind)
+	}
+	return nil
+}
+
+// validateArns removes candidates that aren't valid IAM principal ARNs and logs a report of them. A few examples are
+// logged for each kind of problem, every one is logged with -debug.
+func validateArns(ctx *utils.Context, candidates map[string]utils.Info) map[string]utils.Info {
+	invalid := map[string][]string{}
+	total := 0
+	for principalArn := range candidates {
+		err := ValidateArn(principalArn)
+		if err == nil {
+			continue
+		}
+		reason := err.Error()
+		var invalidErr *InvalidArnError
+		if errors.As(err, &invalidErr) {
+			reason = invalidErr.Reason
+		}
+		invalid[reason] = append(invalid[reason], principalArn)
+		total++
+
+		ctx.Debug.Printf("invalid candidate %s: %s", principalArn, err)
+		delete(candidates, principalArn)
+	}
+	if total == 0 {
+		return candidates
+	}
+
+	ctx.Error.Printf("skipping %d malformed candidates:", total)
+	for _, reason := range slices.Sorted(maps.Keys(invalid)) {
+		arns := invalid[reason]
+		slices.Sort(arns)
+		ctx.Error.Printf("  %d with %s, like %s", len(arns), reason, strings.Join(arns[:min(len(arns), 3)], ", "))
+	}
+	return candidates
+}
@@ -0,0 +1,71 @@
+package arn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateArn(t *testing.T) {
+	tests := []struct {
+		arn    string
+		reason string
+	}{
+		{arn: "arn:aws:iam::123456789012:root"},
+		{arn: "arn:aws:iam::123456789012:role/a"},
+		{arn: "arn:aws:iam::123456789012:role/ci/deploy/Deploy+Role=,.@-_1"},
+		{arn: "arn:aws-us-gov:iam::123456789012:user/alice"},
+		{arn: "arn:aws:iam::123456789012:saml-provider/okta"},
+		{arn: "arn:aws:iam::123456789012:oidc-provider/token.actions.githubusercontent.com"},
+		{arn: "arn:aws:iam::12345678901:role/a", reason: "account ID isn't twelve digits"},
+		{arn: "arn:aws:iam::12345678901a:role/a", reason: "account ID isn't twelve digits"},
+		{arn: "arn:aws:iam::123456789012:role/<no value>", reason: "illegal characters in name"},
+		{arn: "arn:aws:iam::123456789012:role/a b", reason: "illegal characters in name"},
+		{arn: "arn:aws:iam::123456789012:role/a//b", reason: "invalid path"},
+		{arn: "arn:aws:iam::123456789012:role/", reason: "empty name"},
+		{arn: "arn:aws:iam::123456789012:role/" + strings.Repeat("a", 65), reason: "name longer than 64 characters"},
+		{arn: "arn:aws:iam::123456789012:group/admins", reason: "unknown principal type"},
+		{arn: "arn:aws:s3::123456789012:role/a", reason: "not an IAM service"},
+		{arn: "arn:aws:iam:us-east-1:123456789012:role/a", reason: "IAM ARNs don't have a region"},
+		{arn: "arn:foo:iam::123456789012:role/a", reason: "unknown partition"},
+		{arn: "role/a", reason: "not an ARN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			err := ValidateArn(tt.arn)
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var invalidErr *InvalidArnError
+			require.ErrorAs(t, err, &invalidErr)
+			assert.Equal(t, tt.reason, invalidErr.Reason)
+		})
+	}
+}
+
+func TestGetArns_SkipsMalformed(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	rolesPath := filepath.Join(dir, "roles.list")
+	require.NoError(t, os.WriteFile(rolesPath, []byte("deployer\n{{.Vars.missing}}\n"), 0o600))
+
+	got, err := GetArns(ctx, &GetArnsInput{
+		AccountsStr: "123456789012,12345",
+		RolePaths:   []string{rolesPath},
+		Regions:     map[string]utils.Info{"us-east-1": {}},
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"arn:aws:iam::123456789012:role/deployer",
+		"arn:aws:iam::123456789012:root",
+	}, lo.Keys(got))
+}
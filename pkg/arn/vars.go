@@ -0,0 +1,79 @@
+package arn
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseVar parses a template variable list like env=dev,staging,prod.
+func ParseVar(value string) (string, []string, error) {
+	name, list, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !varNamePattern.MatchString(name) {
+		return "", nil, fmt.Errorf("invalid variable %q: expected name=value,value", value)
+	}
+
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return "", nil, fmt.Errorf("invalid variable %q: no values", value)
+	}
+	return name, values, nil
+}
+
+// LoadVarFile reads template variable lists from a file with one name=value,value list per line, comments start with
+// # like in other lists.
+func LoadVarFile(path string) (map[string][]string, error) {
+	path, err := utils.ExpandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := map[string][]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		name, values, err := ParseVar(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", path, i+1, err)
+		}
+		vars[name] = append(vars[name], values...)
+	}
+	return vars, nil
+}
+
+// varCombinations returns every combination of one value for each variable. With no variables there is a single
+// empty combination, so templates are still executed once.
+func varCombinations(vars map[string][]string) []map[string]string {
+	combinations := []map[string]string{{}}
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range vars[name] {
+				c := maps.Clone(combination)
+				c[name] = value
+				next = append(next, c)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
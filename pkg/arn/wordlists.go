@@ -0,0 +1,62 @@
+package arn
+
+import (
+	"embed"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"path"
+	"slices"
+	"strings"
+)
+
+// wordlistFS holds the built-in role name lists, each wordlists/<name>.list is selected with -wordlist <name>.
+//
+//go:embed wordlists/*.list
+var wordlistFS embed.FS
+
+// Wordlists returns the names of the built-in wordlists.
+func Wordlists() []string {
+	entries, err := wordlistFS.ReadDir("wordlists")
+	if err != nil {
+		panic(fmt.Sprintf("reading embedded wordlists: %s", err))
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".list"))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// getWordlistInputs reads the named built-in wordlists, they contain role names in the same format as -roles lists.
+func getWordlistInputs(names []string) (map[string]utils.Info, error) {
+	result := map[string]utils.Info{}
+	for _, name := range names {
+		data, err := wordlistFS.ReadFile(path.Join("wordlists", name+".list"))
+		if err != nil {
+			return nil, fmt.Errorf("unknown wordlist %q: must be one of %s", name, strings.Join(Wordlists(), ", "))
+		}
+
+		for role, info := range utils.GetInputFromPath(string(data)) {
+			result["role/"+role] = info
+		}
+	}
+	return result, nil
+}
+
+// Vendors returns the vendor of each role in the built-in vendors wordlist by role name, from the list's comments.
+func Vendors() map[string]string {
+	data, err := wordlistFS.ReadFile("wordlists/vendors.list")
+	if err != nil {
+		panic(fmt.Sprintf("reading embedded wordlists: %s", err))
+	}
+
+	result := map[string]string{}
+	for role, info := range utils.GetInputFromPath(string(data)) {
+		if vendor := strings.TrimSpace(info.Comment); vendor != "" {
+			result[role] = vendor
+		}
+	}
+	return result
+}
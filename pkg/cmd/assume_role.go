@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ScopedSessionPolicy is the -session-policy value that limits the role in each scanning account to the APIs setup,
+// scans, list-plugins, and cleanup call.
+const ScopedSessionPolicy = "scoped"
+
+// scopedSessionActions are the actions the plugins and enabling regions need, every other action is denied to a
+// session using the scoped session policy.
+var scopedSessionActions = []string{
+	"account:EnableRegion",
+	"account:ListRegions",
+	"ecr-public:CreateRepository",
+	"ecr-public:DeleteRepository",
+	"ecr-public:DescribeRepositories",
+	"ecr-public:SetRepositoryPolicy",
+	"s3:CreateAccessPoint",
+	"s3:CreateBucket",
+	"s3:DeleteAccessPoint",
+	"s3:DeleteBucket",
+	"s3:DeleteBucketPolicy",
+	"s3:GetAccessPoint",
+	"s3:ListAccessPoints",
+	"s3:ListAllMyBuckets",
+	"s3:ListBucket",
+	"s3:PutAccessPointPolicy",
+	"s3:PutBucketPolicy",
+	"sns:CreateTopic",
+	"sns:DeleteTopic",
+	"sns:GetTopicAttributes",
+	"sns:ListTopics",
+	"sns:SetTopicAttributes",
+	"sqs:CreateQueue",
+	"sqs:DeleteQueue",
+	"sqs:GetQueueUrl",
+	"sqs:ListQueues",
+	"sqs:SetQueueAttributes",
+}
+
+// Session durations STS allows, the most is further limited by the role's maximum session duration.
+const (
+	minSessionDuration = 15 * time.Minute
+	maxSessionDuration = 12 * time.Hour
+	// maxChainedSessionDuration is the most STS allows for a role assumed from another role's session.
+	maxChainedSessionDuration = time.Hour
+)
+
+// scopedSessionPolicy returns the session policy document for ScopedSessionPolicy.
+func scopedSessionPolicy() string {
+	doc, err := json.Marshal(map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{{
+			"Sid":      "RoleScanning",
+			"Effect":   "Allow",
+			"Action":   scopedSessionActions,
+			"Resource": "*",
+		}},
+	})
+	if err != nil {
+		panic(fmt.Errorf("marshalling scoped session policy: %w", err))
+	}
+	return string(doc)
+}
+
+// NewAssumeRoleOptions returns the options for assuming the role in each scanning account. policy is either
+// ScopedSessionPolicy, the path of a session policy document, or empty for no session policy. accountsPath is the path
+// of a scanning accounts file to use instead of the tagged accounts of the organization, if it isn't empty. roleName is
+// the role to assume in accounts that don't name one, utils.DefaultAccountRole if it's empty. bastionRoleArn is a role
+// to assume first and assume each account's role from, if it isn't empty.
+func NewAssumeRoleOptions(externalID string, duration time.Duration, policy string, accountsPath string, roleName string, bastionRoleArn string) (utils.AssumeRoleOptions, error) {
+	opts := utils.AssumeRoleOptions{ExternalID: externalID, Duration: duration, RoleName: roleName, BastionRoleArn: bastionRoleArn}
+	if roleName != "" && !utils.IsValidRoleName(roleName) {
+		return opts, fmt.Errorf("scanning-account-role %s isn't a valid role name", roleName)
+	}
+	if bastionRoleArn != "" {
+		if !isRoleArn(bastionRoleArn) {
+			return opts, fmt.Errorf("bastion-role %s isn't the ARN of a role", bastionRoleArn)
+		}
+		// AWS limits the sessions of roles assumed from another role's session to an hour.
+		if duration > maxChainedSessionDuration {
+			return opts, fmt.Errorf("session-duration can't be over %s with -bastion-role, AWS limits chained role sessions to it", maxChainedSessionDuration)
+		}
+	}
+	if accountsPath != "" {
+		accounts, err := loadStaticAccounts(accountsPath, opts.AccountRole(nil))
+		if err != nil {
+			return opts, err
+		}
+		opts.Accounts = accounts
+	}
+	if duration != 0 && (duration < minSessionDuration || duration > maxSessionDuration) {
+		return opts, fmt.Errorf("session-duration must be between %s and %s", minSessionDuration, maxSessionDuration)
+	}
+
+	switch policy {
+	case "":
+	case ScopedSessionPolicy:
+		opts.Policy = scopedSessionPolicy()
+	default:
+		path, err := utils.ExpandPath(policy)
+		if err != nil {
+			return opts, fmt.Errorf("expanding path: %s", err)
+		}
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			return opts, fmt.Errorf("reading session policy: %s", err)
+		}
+		if !json.Valid(doc) {
+			return opts, fmt.Errorf("session policy %s isn't valid JSON", policy)
+		}
+		opts.Policy = string(doc)
+	}
+	return opts, nil
+}
+
+var accountIdPattern = regexp.MustCompile(`^\d{12}$`)
+
+// loadStaticAccounts loads the scanning accounts of the YAML or JSON file at path, it has a list of accounts with an
+// account_id, role_arn, or both, and optionally an external_id and name. The role is roleName in the account without a
+// role_arn, and the account is the role's without an account_id.
+func loadStaticAccounts(path string, roleName string) ([]utils.StaticAccount, error) {
+	path, err := utils.ExpandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("expanding path: %s", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading scanning accounts: %s", err)
+	}
+
+	var file struct {
+		Accounts []utils.StaticAccount `yaml:"accounts"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parsing scanning accounts in %s: %s", path, err)
+	}
+	if len(file.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts in %s", path)
+	}
+
+	seen := map[string]bool{}
+	for i := range file.Accounts {
+		account := &file.Accounts[i]
+		if err := resolveStaticAccount(account, roleName); err != nil {
+			return nil, fmt.Errorf("scanning account %d in %s: %s", i+1, path, err)
+		}
+		if seen[account.AccountId] {
+			return nil, fmt.Errorf("scanning account %s is in %s more than once", account.AccountId, path)
+		}
+		seen[account.AccountId] = true
+	}
+	return file.Accounts, nil
+}
+
+// resolveStaticAccount fills in the account ID or role ARN of account from the other and checks they match, the role
+// is roleName if it has no role ARN.
+func resolveStaticAccount(account *utils.StaticAccount, roleName string) error {
+	if account.RoleArn == "" {
+		if !accountIdPattern.MatchString(account.AccountId) {
+			return fmt.Errorf("needs a 12 digit account_id or a role_arn")
+		}
+		account.RoleArn = fmt.Sprintf("arn:aws:iam::%s:role/%s", account.AccountId, roleName)
+		return nil
+	}
+
+	if !isRoleArn(account.RoleArn) {
+		return fmt.Errorf("role_arn %s isn't the ARN of a role", account.RoleArn)
+	}
+	parsed, _ := arn.Parse(account.RoleArn)
+	if account.AccountId == "" {
+		account.AccountId = parsed.AccountID
+	} else if account.AccountId != parsed.AccountID {
+		return fmt.Errorf("role_arn %s isn't in account %s", account.RoleArn, account.AccountId)
+	}
+	return nil
+}
+
+// isRoleArn reports whether roleArn is the ARN of an IAM role.
+func isRoleArn(roleArn string) bool {
+	parsed, err := arn.Parse(roleArn)
+	return err == nil && parsed.Service == "iam" && strings.HasPrefix(parsed.Resource, "role/")
+}
+
+// NewAccountSelection returns the selection of the role scanning accounts of the organization. tag is a key=value
+// tag, and ids are account IDs to select instead of tagged accounts, the accounts -setup -org tags are selected if
+// both are empty.
+func NewAccountSelection(tag string, ids []string) (utils.AccountSelection, error) {
+	var selection utils.AccountSelection
+	if tag != "" && len(ids) != 0 {
+		return selection, fmt.Errorf("cannot use both -scanning-account-tag and -scanning-account-ids")
+	}
+	if tag != "" {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" {
+			return selection, fmt.Errorf("scanning-account-tag %s isn't in the format key=value", tag)
+		}
+		selection.TagKey, selection.TagValue = key, value
+	}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if !accountIdPattern.MatchString(id) {
+			return selection, fmt.Errorf("scanning account ID %s isn't a 12 digit account ID", id)
+		}
+		selection.AccountIds = append(selection.AccountIds, id)
+	}
+	return selection, nil
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAssumeRoleOptions(t *testing.T) {
+	opts, err := NewAssumeRoleOptions("", 0, "", "", "", "")
+	require.NoError(t, err)
+	assert.Empty(t, opts.Policy, "no session policy by default")
+
+	opts, err = NewAssumeRoleOptions("engagement-1234", time.Hour, ScopedSessionPolicy, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "engagement-1234", opts.ExternalID)
+	assert.Equal(t, time.Hour, opts.Duration)
+	// Session policies are limited to 2048 characters.
+	assert.Less(t, len(opts.Policy), 2048)
+	var doc struct {
+		Statement []struct {
+			Effect string
+			Action []string
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(opts.Policy), &doc))
+	require.Len(t, doc.Statement, 1)
+	assert.Equal(t, "Allow", doc.Statement[0].Effect)
+	assert.Contains(t, doc.Statement[0].Action, "sns:SetTopicAttributes")
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"Version": "2012-10-17", "Statement": []}`), 0600))
+	opts, err = NewAssumeRoleOptions("", 0, path, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, `{"Version": "2012-10-17", "Statement": []}`, opts.Policy)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"Version":`), 0600))
+	_, err = NewAssumeRoleOptions("", 0, path, "", "", "")
+	assert.ErrorContains(t, err, "isn't valid JSON")
+
+	_, err = NewAssumeRoleOptions("", 0, filepath.Join(t.TempDir(), "missing.json"), "", "", "")
+	assert.Error(t, err)
+
+	_, err = NewAssumeRoleOptions("", time.Minute, "", "", "", "")
+	assert.ErrorContains(t, err, "session-duration must be between 15m0s and 12h0m0s")
+	_, err = NewAssumeRoleOptions("", 13*time.Hour, "", "", "", "")
+	assert.Error(t, err)
+
+	opts, err = NewAssumeRoleOptions("", 0, "", "", "RoleScanning", "")
+	require.NoError(t, err)
+	assert.Equal(t, "RoleScanning", opts.AccountRole(nil))
+	_, err = NewAssumeRoleOptions("", 0, "", "", "role/RoleScanning", "")
+	assert.ErrorContains(t, err, "isn't a valid role name")
+
+	bastion := "arn:aws:iam::111111111111:role/RoleScanningBastion"
+	opts, err = NewAssumeRoleOptions("", time.Hour, "", "", "", bastion)
+	require.NoError(t, err)
+	assert.Equal(t, bastion, opts.BastionRoleArn)
+	_, err = NewAssumeRoleOptions("", 0, "", "", "", "RoleScanningBastion")
+	assert.ErrorContains(t, err, "bastion-role RoleScanningBastion isn't the ARN of a role")
+	_, err = NewAssumeRoleOptions("", 2*time.Hour, "", "", "", bastion)
+	assert.ErrorContains(t, err, "session-duration can't be over 1h0m0s with -bastion-role")
+}
+
+func TestLoadStaticAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`accounts:
+  - account_id: "111111111111"
+  - role_arn: arn:aws:iam::222222222222:role/scanning
+    external_id: engagement-1234
+    name: scanning-2
+  - account_id: "333333333333"
+    role_arn: arn:aws:iam::333333333333:role/scanning
+`), 0600))
+
+	opts, err := NewAssumeRoleOptions("default-id", 0, "", path, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "default-id", opts.ExternalID)
+	assert.Equal(t, []utils.StaticAccount{
+		{AccountId: "111111111111", RoleArn: "arn:aws:iam::111111111111:role/" + utils.DefaultAccountRole},
+		{AccountId: "222222222222", RoleArn: "arn:aws:iam::222222222222:role/scanning", ExternalID: "engagement-1234", Name: "scanning-2"},
+		{AccountId: "333333333333", RoleArn: "arn:aws:iam::333333333333:role/scanning"},
+	}, opts.Accounts)
+
+	// Entries without a role_arn use -scanning-account-role.
+	opts, err = NewAssumeRoleOptions("", 0, "", path, "RoleScanning", "")
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::111111111111:role/RoleScanning", opts.Accounts[0].RoleArn)
+	assert.Equal(t, "arn:aws:iam::222222222222:role/scanning", opts.Accounts[1].RoleArn)
+
+	// JSON works too.
+	require.NoError(t, os.WriteFile(path, []byte(`{"accounts": [{"account_id": "111111111111"}]}`), 0600))
+	accounts, err := loadStaticAccounts(path, utils.DefaultAccountRole)
+	require.NoError(t, err)
+	assert.Len(t, accounts, 1)
+
+	for contents, expected := range map[string]string{
+		`accounts: []`:                     "no accounts in",
+		`accounts: [{account_id: "1111"}]`: "scanning account 1 in " + path + ": needs a 12 digit account_id or a role_arn",
+		`accounts: [{role_arn: "arn:aws:iam::111111111111:user/scanning"}]`:                               "isn't the ARN of a role",
+		`accounts: [{account_id: "222222222222", role_arn: "arn:aws:iam::111111111111:role/scanning"}]`:   "isn't in account 222222222222",
+		`accounts: [{account_id: "111111111111"}, {role_arn: "arn:aws:iam::111111111111:role/scanning"}]`: "scanning account 111111111111 is in " + path + " more than once",
+		`accounts: [{account: "111111111111"}]`:                                                           "field account not found",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+		_, err := loadStaticAccounts(path, utils.DefaultAccountRole)
+		assert.ErrorContains(t, err, expected, contents)
+	}
+}
+
+func TestNewAccountSelection(t *testing.T) {
+	selection, err := NewAccountSelection("", nil)
+	require.NoError(t, err)
+	assert.True(t, selection.IsDefault())
+
+	selection, err = NewAccountSelection("environment=sandbox", nil)
+	require.NoError(t, err)
+	assert.Equal(t, utils.AccountSelection{TagKey: "environment", TagValue: "sandbox"}, selection)
+
+	selection, err = NewAccountSelection("", []string{"111111111111", " 222222222222", ""})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"111111111111", "222222222222"}, selection.AccountIds)
+
+	_, err = NewAccountSelection("environment", nil)
+	assert.ErrorContains(t, err, "isn't in the format key=value")
+	_, err = NewAccountSelection("", []string{"1111"})
+	assert.ErrorContains(t, err, "1111 isn't a 12 digit account ID")
+	_, err = NewAccountSelection("environment=sandbox", []string{"111111111111"})
+	assert.ErrorContains(t, err, "cannot use both")
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/budgets"
+	"github.com/aws/aws-sdk-go-v2/service/budgets/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"strconv"
+	"sync"
+)
+
+// BudgetName is the name of the budget -setup -budget creates in each scanning account.
+const BudgetName = "role-scanning-account"
+
+// budgetAlerts are the percentages of the budget limit that email the -budget-email address, once the actual cost
+// passes the first or the forecasted cost passes the second.
+var budgetAlerts = []types.Notification{
+	{NotificationType: types.NotificationTypeActual, ComparisonOperator: types.ComparisonOperatorGreaterThan, Threshold: 80, ThresholdType: types.ThresholdTypePercentage},
+	{NotificationType: types.NotificationTypeForecasted, ComparisonOperator: types.ComparisonOperatorGreaterThan, Threshold: 100, ThresholdType: types.ThresholdTypePercentage},
+}
+
+type IBudgets interface {
+	CreateBudget(ctx context.Context, params *budgets.CreateBudgetInput, optFns ...func(*budgets.Options)) (*budgets.CreateBudgetOutput, error)
+	UpdateBudget(ctx context.Context, params *budgets.UpdateBudgetInput, optFns ...func(*budgets.Options)) (*budgets.UpdateBudgetOutput, error)
+	DeleteBudget(ctx context.Context, params *budgets.DeleteBudgetInput, optFns ...func(*budgets.Options)) (*budgets.DeleteBudgetOutput, error)
+}
+
+// budgeter creates and deletes the budgets of the scanning accounts, client returns the Budgets client of an account.
+type budgeter struct {
+	client func(account utils.Account) IBudgets
+}
+
+func newBudgeter() *budgeter {
+	return &budgeter{client: func(account utils.Account) IBudgets {
+		// The Budgets API is only in us-east-1.
+		return budgets.NewFromConfig(account.Config, func(o *budgets.Options) {
+			o.Region = "us-east-1"
+		})
+	}}
+}
+
+// budgetLimit returns limit in USD the way Budgets returns it.
+func budgetLimit(limit float64) string {
+	return strconv.FormatFloat(limit, 'f', 2, 64)
+}
+
+// SetupBudgets creates a monthly cost budget limited to opts.Budget USD in each account that emails opts.BudgetEmail
+// when it's close to being reached, so resources left behind in a scanning account don't go unnoticed. The budget of
+// an account the state has with a different limit is updated to the new one. In an organization's management account
+// the budget covers the costs of every account in the organization.
+func SetupBudgets(ctx *utils.Context, accounts map[string]utils.Account, opts SetupOpts, state *setupState) error {
+	return newBudgeter().setup(ctx, accounts, opts, state)
+}
+
+func (b *budgeter) setup(ctx *utils.Context, accounts map[string]utils.Account, opts SetupOpts, state *setupState) error {
+	limit := budgetLimit(opts.Budget)
+
+	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	var errs []error
+
+	for _, account := range accounts {
+		if state.budget(account.AccountId) == limit {
+			ctx.Debug.Printf("budget already set up in %s", account.AccountId)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := setupBudget(ctx, b.client(account), account.AccountId, limit, opts.BudgetEmail)
+			if err == nil {
+				ctx.Info.Printf("%s: budget of %s USD a month set up", account.AccountId, limit)
+				err = state.setBudget(account.AccountId, limit)
+			}
+			if err != nil {
+				m.Lock()
+				errs = append(errs, fmt.Errorf("%s: %s", account.AccountId, err))
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d budgets failed, run -setup again to retry them: %w", len(errs), errors.Join(errs...))
+	}
+	return nil
+}
+
+// setupBudget creates the budget in accountId, or updates its limit if it exists. The alerts of an existing budget
+// aren't changed.
+func setupBudget(ctx *utils.Context, client IBudgets, accountId string, limit string, email string) error {
+	budget := &types.Budget{
+		BudgetName:  aws.String(BudgetName),
+		BudgetType:  types.BudgetTypeCost,
+		TimeUnit:    types.TimeUnitMonthly,
+		BudgetLimit: &types.Spend{Amount: aws.String(limit), Unit: aws.String("USD")},
+	}
+
+	var notifications []types.NotificationWithSubscribers
+	for _, alert := range budgetAlerts {
+		notifications = append(notifications, types.NotificationWithSubscribers{
+			Notification: &alert,
+			Subscribers:  []types.Subscriber{{SubscriptionType: types.SubscriptionTypeEmail, Address: aws.String(email)}},
+		})
+	}
+
+	var exists *types.DuplicateRecordException
+	_, err := client.CreateBudget(ctx, &budgets.CreateBudgetInput{
+		AccountId:                    aws.String(accountId),
+		Budget:                       budget,
+		NotificationsWithSubscribers: notifications,
+	})
+	if errors.As(err, &exists) {
+		ctx.Debug.Printf("budget %s already exists in %s, updating its limit", BudgetName, accountId)
+		if _, err := client.UpdateBudget(ctx, &budgets.UpdateBudgetInput{AccountId: aws.String(accountId), NewBudget: budget}); err != nil {
+			return fmt.Errorf("updating budget: %s", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("creating budget: %s", err)
+	}
+	return nil
+}
+
+// RemoveBudgets deletes the budgets setup created in accounts and removes them from the state, the budgets of accounts
+// that aren't loaded are left in the state for the next -clean.
+func RemoveBudgets(ctx *utils.Context, accounts map[string]utils.Account, state *setupState) error {
+	return newBudgeter().remove(ctx, accounts, state)
+}
+
+func (b *budgeter) remove(ctx *utils.Context, accounts map[string]utils.Account, state *setupState) error {
+	for _, account := range accounts {
+		if state.budget(account.AccountId) == "" {
+			continue
+		}
+
+		var notFound *types.NotFoundException
+		_, err := b.client(account).DeleteBudget(ctx, &budgets.DeleteBudgetInput{
+			AccountId:  aws.String(account.AccountId),
+			BudgetName: aws.String(BudgetName),
+		})
+		if err != nil && !errors.As(err, &notFound) {
+			return fmt.Errorf("%s: deleting budget: %s", account.AccountId, err)
+		}
+		ctx.Info.Printf("%s: deleted budget %s", account.AccountId, BudgetName)
+		if err := state.setBudget(account.AccountId, ""); err != nil {
+			return fmt.Errorf("saving setup state: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/budgets"
+	"github.com/aws/aws-sdk-go-v2/service/budgets/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockBudgets is the Budgets API of every account, with the budget limits by account ID.
+type mockBudgets struct {
+	mu      sync.Mutex
+	limits  map[string]string
+	created []*budgets.CreateBudgetInput
+	updated []*budgets.UpdateBudgetInput
+}
+
+func (m *mockBudgets) CreateBudget(ctx context.Context, params *budgets.CreateBudgetInput, optFns ...func(*budgets.Options)) (*budgets.CreateBudgetOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.limits[aws.ToString(params.AccountId)]; ok {
+		return nil, &types.DuplicateRecordException{}
+	}
+	m.created = append(m.created, params)
+	m.limits[aws.ToString(params.AccountId)] = aws.ToString(params.Budget.BudgetLimit.Amount)
+	return &budgets.CreateBudgetOutput{}, nil
+}
+
+func (m *mockBudgets) UpdateBudget(ctx context.Context, params *budgets.UpdateBudgetInput, optFns ...func(*budgets.Options)) (*budgets.UpdateBudgetOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updated = append(m.updated, params)
+	m.limits[aws.ToString(params.AccountId)] = aws.ToString(params.NewBudget.BudgetLimit.Amount)
+	return &budgets.UpdateBudgetOutput{}, nil
+}
+
+func (m *mockBudgets) DeleteBudget(ctx context.Context, params *budgets.DeleteBudgetInput, optFns ...func(*budgets.Options)) (*budgets.DeleteBudgetOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.limits[aws.ToString(params.AccountId)]; !ok {
+		return nil, &types.NotFoundException{}
+	}
+	delete(m.limits, aws.ToString(params.AccountId))
+	return &budgets.DeleteBudgetOutput{}, nil
+}
+
+func TestBudgeter(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-111111111111.json"))
+	require.NoError(t, err)
+
+	client := &mockBudgets{limits: map[string]string{"222222222222": "10.00"}}
+	b := &budgeter{client: func(utils.Account) IBudgets { return client }}
+	accounts := map[string]utils.Account{
+		"default":      {AccountId: "111111111111"},
+		"222222222222": {AccountId: "222222222222"},
+	}
+
+	require.NoError(t, b.setup(ctx, accounts, SetupOpts{Budget: 25.5, BudgetEmail: "admin@example.com"}, state))
+	assert.Equal(t, map[string]string{"111111111111": "25.50", "222222222222": "25.50"}, client.limits)
+	require.Len(t, client.created, 1)
+	assert.Equal(t, BudgetName, aws.ToString(client.created[0].Budget.BudgetName))
+	assert.Equal(t, types.TimeUnitMonthly, client.created[0].Budget.TimeUnit)
+	require.Len(t, client.created[0].NotificationsWithSubscribers, len(budgetAlerts))
+	assert.Equal(t, "admin@example.com", aws.ToString(client.created[0].NotificationsWithSubscribers[0].Subscribers[0].Address))
+	assert.Len(t, client.updated, 1, "an existing budget gets the new limit")
+
+	// Budgets with the same limit in the state are skipped.
+	require.NoError(t, b.setup(ctx, accounts, SetupOpts{Budget: 25.5, BudgetEmail: "admin@example.com"}, state))
+	assert.Len(t, client.created, 1)
+	assert.Len(t, client.updated, 1)
+
+	// A budget deleted outside of -clean is forgotten too.
+	delete(client.limits, "222222222222")
+	require.NoError(t, b.remove(ctx, accounts, state))
+	assert.Empty(t, client.limits)
+	assert.Empty(t, state.budget("111111111111"))
+	assert.Empty(t, state.budget("222222222222"))
+}
@@ -1,40 +1,180 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/ryanjarv/roles/pkg/plugins"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 )
 
 func CleanUp(ctx *utils.Context, opts Opts) error {
-	cfg, err := config.LoadDefaultConfig(ctx.Context, config.WithRegion("us-east-1"), config.WithSharedConfigProfile(opts.Profile))
+	cfg, err := utils.LoadConfig(ctx, opts.Profile, config.WithRegion("us-east-1"))
 	if err != nil {
 		return fmt.Errorf("loading config: %s", err)
 	}
 
-	accounts, err := utils.LoadAccounts(ctx, cfg)
+	accounts, err := utils.LoadAccounts(ctx, cfg, opts.AssumeRole)
 	if err != nil {
 		return fmt.Errorf("loading accounts: %s", err)
 	}
 
-	cfgs, err := utils.LoadConfigs(ctx, accounts)
+	state, err := loadCallerSetupState(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("loading configs: %s", err)
+		return fmt.Errorf("loading setup state: %s", err)
 	}
 
-	if err := cleanUp(ctx, cfgs); err != nil {
-		return fmt.Errorf("cleaning up: %s", err)
+	// Resources deployed with -setup -stackset are deleted with their stacks, the plugins then clean up anything
+	// created without it.
+	if opts.StackSet || state.usedStackSet() {
+		if err := RemoveStackSet(ctx, cfg, state); err != nil {
+			return fmt.Errorf("removing StackSet: %s", err)
+		}
 	}
 
-	return nil
+	if err := RemoveBudgets(ctx, accounts, state); err != nil {
+		return fmt.Errorf("removing budgets: %s", err)
+	}
+
+	var cfgs map[string]utils.ThreadConfig
+	if opts.Discover {
+		if cfgs, err = utils.LoadConfigs(ctx, accounts, opts.Regions); err != nil {
+			return fmt.Errorf("loading configs: %s", err)
+		}
+		if err := discoverCleanUp(ctx, registeredPlugins, cfgs); err != nil {
+			return err
+		}
+		if err := state.forgetPlugins(cfgs); err != nil {
+			return fmt.Errorf("saving setup state: %s", err)
+		}
+	} else if len(state.inventory()) > 0 {
+		if err := cleanUpInventory(ctx, registeredPlugins, accounts, opts.Regions, state); err != nil {
+			return err
+		}
+	} else {
+		// Setup ran before it kept an inventory, so the plugins are cleaned up in every region enabled now.
+		ctx.Info.Printf("no setup inventory, cleaning up the plugins of every scanning account and region")
+		if cfgs, err = utils.LoadConfigs(ctx, accounts, opts.Regions); err != nil {
+			return fmt.Errorf("loading configs: %s", err)
+		}
+
+		if err := cleanUp(ctx, cfgs); err != nil {
+			return fmt.Errorf("cleaning up: %s", err)
+		}
+
+		// The plugins need to be set up again now, so -setup shouldn't skip them.
+		if err := state.forgetPlugins(cfgs); err != nil {
+			return fmt.Errorf("saving setup state: %s", err)
+		}
+	}
+
+	if cfgs == nil {
+		if cfgs, err = utils.LoadConfigs(ctx, accounts, opts.Regions); err != nil {
+			return fmt.Errorf("loading configs: %s", err)
+		}
+	}
+	return sweepLeftovers(ctx, registeredPlugins, cfgs)
 }
 
 func cleanUp(ctx *utils.Context, cfgs map[string]utils.ThreadConfig) (err error) {
+	cleanUpPlugins(ctx, utils.FlattenList(LoadAllPlugins(cfgs)))
+	return nil
+}
+
+// cleanUpInventory cleans up the instances of the registered plugins in the setup inventory, in the account and region each one was set up
+// in whether or not the region is enabled now. A region list in selection limits it to those regions. The instances
+// cleaned up are removed from the inventory, the ones that failed or are in accounts that aren't loaded anymore stay
+// for the next -clean and the resources they left behind are logged.
+func cleanUpInventory(ctx *utils.Context, registered []pluginInfo, accounts map[string]utils.Account, selection utils.RegionSelection, state *setupState) error {
+	loaded := map[string]utils.Account{}
+	for _, account := range accounts {
+		loaded[account.AccountId] = account
+	}
+
+	var left []string
+	inventory := map[string]setupResources{}
+	cfgs := map[string]utils.ThreadConfig{}
+	for key, resources := range state.inventory() {
+		if len(selection.Regions) > 0 && !slices.Contains(selection.Regions, resources.Region) {
+			continue
+		}
+		account, ok := loaded[resources.AccountId]
+		if !ok {
+			ctx.Error.Printf("%s: account %s isn't a scanning account anymore, leaving %s", key, resources.AccountId, strings.Join(resources.Arns, ", "))
+			left = append(left, resources.Arns...)
+			continue
+		}
+
+		cfgKey, _, _ := strings.Cut(key, "/")
+		newCfg := account.Config.Copy()
+		newCfg.Region = resources.Region
+		cfgs[cfgKey] = utils.ThreadConfig{AccountId: resources.AccountId, Config: newCfg, Region: resources.Region}
+		inventory[key] = resources
+	}
+
+	ps := inventoryPlugins(registered, cfgs, inventory)
+	cleaned := cleanUpPlugins(ctx, slices.Collect(maps.Values(ps)))
+
+	for key, plugin := range ps {
+		if !cleaned[plugin] {
+			left = append(left, inventory[key].Arns...)
+			continue
+		}
+		if err := state.forgetPlugin(key); err != nil {
+			return fmt.Errorf("saving setup state: %s", err)
+		}
+	}
+	// Instances of plugins that aren't registered anymore, or whose names changed.
+	for key, resources := range inventory {
+		if _, ok := ps[key]; !ok {
+			ctx.Error.Printf("%s: no registered plugin has this instance, leaving %s", key, strings.Join(resources.Arns, ", "))
+			left = append(left, resources.Arns...)
+		}
+	}
+
+	if len(left) > 0 {
+		slices.Sort(left)
+		return fmt.Errorf("%d resources are left, run -clean again to retry them: %s", len(left), strings.Join(left, ", "))
+	}
+	return nil
+}
+
+// inventoryPlugins returns the instances of the registered plugins in inventory, by pluginStateKey. Enough instances
+// are loaded in each of cfgs to have every one in the inventory, whatever the concurrency was when they were set up.
+func inventoryPlugins(registered []pluginInfo, cfgs map[string]utils.ThreadConfig, inventory map[string]setupResources) map[string]plugins.Plugin {
+	instances := map[string]int{}
+	for key := range inventory {
+		cfgKey, _, _ := strings.Cut(key, "/")
+		instances[cfgKey]++
+	}
+
+	ps := map[string]plugins.Plugin{}
+	for key, cfg := range cfgs {
+		for _, p := range registered {
+			for _, plugin := range p.new(map[string]utils.ThreadConfig{key: cfg}, max(p.concurrency, instances[key])) {
+				stateKey := pluginStateKey(key, plugin.Name())
+				if _, ok := inventory[stateKey]; ok {
+					ps[stateKey] = plugin
+				}
+			}
+		}
+	}
+	return ps
+}
+
+// cleanUpPlugins cleans up ps concurrently, returning the plugins that were cleaned up, failures are logged.
+func cleanUpPlugins(ctx *utils.Context, ps []plugins.Plugin) map[plugins.Plugin]bool {
 	concurrency := make(chan int, 20)
 	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	cleaned := map[plugins.Plugin]bool{}
 
-	for _, p := range utils.FlattenList(LoadAllPlugins(cfgs)) {
+	for _, p := range ps {
 		wg.Add(1)
 		concurrency <- 1
 
@@ -47,10 +187,139 @@ func cleanUp(ctx *utils.Context, cfgs map[string]utils.ThreadConfig) (err error)
 			ctx.Info.Printf("cleaning up %s", p.Name())
 			if err := p.CleanUp(ctx); err != nil {
 				ctx.Error.Printf("%s: cleaning up: %s", p.Name(), err)
+				return
 			}
+			m.Lock()
+			cleaned[p] = true
+			m.Unlock()
 		}()
 	}
 
 	wg.Wait()
+	return cleaned
+}
+
+// leftovers are the ARNs of the resources named like the ones of plugin in the account and region of cfgKey.
+type leftovers struct {
+	cfgKey string
+	plugin pluginInfo
+	arns   []string
+}
+
+// listLeftovers lists the resources named like the ones of the registered plugins in each of cfgs, returning an error
+// for each account, region, and plugin whose resources couldn't be listed.
+func listLeftovers(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig) ([]leftovers, []error) {
+	concurrency := make(chan int, 20)
+	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	var found []leftovers
+	var errs []error
+
+	for key, cfg := range cfgs {
+		for _, p := range registered {
+			if p.leftovers == nil || len(p.regions) > 0 && !slices.Contains(p.regions, cfg.Region) {
+				continue
+			}
+			wg.Add(1)
+			concurrency <- 1
+
+			go func() {
+				defer func() {
+					<-concurrency
+					wg.Done()
+				}()
+
+				arns, err := p.leftovers(ctx, cfg)
+				m.Lock()
+				defer m.Unlock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: listing %s resources: %s", key, p.name, err))
+				} else if len(arns) > 0 {
+					found = append(found, leftovers{cfgKey: key, plugin: p, arns: arns})
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	return found, errs
+}
+
+// sweepLeftovers checks nothing named like the resources of the registered plugins is left in cfgs after cleaning up,
+// since the plugins only log the resources they fail to delete. The resources left and the regions that couldn't be
+// checked are returned as an error.
+func sweepLeftovers(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig) error {
+	found, errs := listLeftovers(ctx, registered, cfgs)
+
+	var left []string
+	for _, f := range found {
+		left = append(left, f.arns...)
+	}
+	if len(left) > 0 {
+		slices.Sort(left)
+		errs = append(errs, fmt.Errorf("%d resources are left after cleaning up, run -clean again to retry them: %s", len(left), strings.Join(left, ", ")))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	ctx.Info.Printf("checked no resources are left in %d scanning account regions", len(cfgs))
 	return nil
 }
+
+// maxDiscoveredConcurrency is the most instances of a plugin -clean -discover loads in an account and region, a
+// resource numbered past it is left for the sweep to report.
+const maxDiscoveredConcurrency = 100
+
+// discoverCleanUp finds the resources of the registered plugins in cfgs by name and cleans up the plugin instances
+// they belong to, for resources the setup state doesn't know about or that were set up with a different plugin
+// concurrency. The regions whose resources couldn't be listed are returned as an error, the resources that failed to
+// be deleted are left for sweepLeftovers to report.
+func discoverCleanUp(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig) error {
+	found, errs := listLeftovers(ctx, registered, cfgs)
+	ps, unknown := discoveredPlugins(cfgs, found)
+	for _, arn := range unknown {
+		ctx.Error.Printf("no plugin instance has %s, it has to be deleted by hand", arn)
+	}
+
+	ctx.Info.Printf("discovered %d plugin instances with resources to clean up", len(ps))
+	cleanUpPlugins(ctx, ps)
+	return errors.Join(errs...)
+}
+
+// discoveredPlugins returns the plugin instances the resources in found belong to, with as many instances of each
+// plugin as the highest numbered resource needs whatever the plugin's concurrency is now, and the ARNs of the resources
+// no instance has.
+func discoveredPlugins(cfgs map[string]utils.ThreadConfig, found []leftovers) ([]plugins.Plugin, []string) {
+	var ps []plugins.Plugin
+	var unknown []string
+	for _, f := range found {
+		concurrency := f.plugin.concurrency
+		remaining := map[string]bool{}
+		for _, arn := range f.arns {
+			remaining[arn] = true
+			if thread, ok := resourceThread(arn); ok && thread < maxDiscoveredConcurrency {
+				concurrency = max(concurrency, thread+1)
+			}
+		}
+
+		for _, plugin := range f.plugin.new(map[string]utils.ThreadConfig{f.cfgKey: cfgs[f.cfgKey]}, concurrency) {
+			matched := false
+			for _, arn := range plugins.ResourceArns(plugin) {
+				if remaining[arn] {
+					delete(remaining, arn)
+					matched = true
+				}
+			}
+			if matched {
+				ps = append(ps, plugin)
+			}
+		}
+		unknown = append(unknown, slices.Sorted(maps.Keys(remaining))...)
+	}
+	return ps, unknown
+}
+
+// resourceThread returns the thread number a plugin resource's name ends with.
+func resourceThread(arn string) (int, bool) {
+	thread, err := strconv.Atoi(arn[strings.LastIndex(arn, "-")+1:])
+	return thread, err == nil && thread >= 0
+}
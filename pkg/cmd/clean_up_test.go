@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventoryPlugins(t *testing.T) {
+	cfgs := map[string]utils.ThreadConfig{
+		"111111111111-us-east-1": {AccountId: "111111111111", Region: "us-east-1"},
+		"111111111111-ap-east-1": {AccountId: "111111111111", Region: "ap-east-1"},
+	}
+	inventory := map[string]setupResources{
+		pluginStateKey("111111111111-us-east-1", "ecr-public-us-east-1-0"):       {},
+		pluginStateKey("111111111111-ap-east-1", "sns-111111111111-ap-east-1-1"): {},
+		// Set up with more instances than are registered now.
+		pluginStateKey("111111111111-ap-east-1", "sns-111111111111-ap-east-1-2"): {},
+		pluginStateKey("111111111111-ap-east-1", "sqs-ap-east-1-2"):              {},
+	}
+
+	ps := inventoryPlugins(registeredPlugins, cfgs, inventory)
+	assert.Len(t, ps, len(inventory), "only the instances in the inventory are loaded")
+	for key, plugin := range ps {
+		assert.True(t, strings.HasSuffix(key, "/"+plugin.Name()))
+	}
+}
+
+func TestCleanUpInventory(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-123456789012.json"))
+	require.NoError(t, err)
+
+	ok := &fakeSetupPlugin{name: "sns-0", arns: []string{"arn:aws:sns:us-east-1:111111111111:topic"}}
+	failing := &fakeSetupPlugin{name: "sqs-0", arns: []string{"arn:aws:sqs:us-east-1:111111111111:queue"}}
+	registered := []pluginInfo{{concurrency: 1, new: func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+		return []plugins.Plugin{ok, failing}
+	}}}
+	require.NoError(t, setupPlugins(ctx, map[string]plugins.Plugin{
+		pluginStateKey("111111111111-us-east-1", "sns-0"): ok,
+		pluginStateKey("111111111111-us-east-1", "sqs-0"): failing,
+	}, state))
+	require.NoError(t, state.setPluginCreated(pluginStateKey("222222222222-us-east-1", "sns-0"), setupResources{
+		AccountId: "222222222222", Region: "us-east-1", Arns: []string{"arn:aws:sns:us-east-1:222222222222:topic"},
+	}))
+	accounts := map[string]utils.Account{"111111111111": {AccountId: "111111111111"}}
+
+	failing.fail = true
+	err = cleanUpInventory(ctx, registered, accounts, utils.RegionSelection{}, state)
+	assert.ErrorContains(t, err, "2 resources are left")
+	assert.ErrorContains(t, err, "arn:aws:sqs:us-east-1:111111111111:queue", "failed to clean up")
+	assert.ErrorContains(t, err, "arn:aws:sns:us-east-1:222222222222:topic", "the account isn't loaded")
+	assert.Equal(t, 1, ok.cleanups)
+
+	// The ones cleaned up are removed, so -setup creates them again.
+	assert.False(t, state.pluginSetup(pluginStateKey("111111111111-us-east-1", "sns-0")))
+	assert.True(t, state.pluginSetup(pluginStateKey("111111111111-us-east-1", "sqs-0")))
+	assert.Len(t, state.inventory(), 2)
+
+	failing.fail = false
+	require.NoError(t, state.forgetPlugin(pluginStateKey("222222222222-us-east-1", "sns-0")))
+	require.NoError(t, cleanUpInventory(ctx, registered, accounts, utils.RegionSelection{}, state))
+	assert.Equal(t, 1, ok.cleanups, "only what's left is cleaned up again")
+	assert.Equal(t, 2, failing.cleanups)
+	assert.Empty(t, state.inventory())
+}
+
+func TestSweepLeftovers(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	cfgs := map[string]utils.ThreadConfig{
+		"111111111111-us-east-1": {AccountId: "111111111111", Region: "us-east-1"},
+		"111111111111-ap-east-1": {AccountId: "111111111111", Region: "ap-east-1"},
+	}
+	left := map[string][]string{}
+	registered := []pluginInfo{
+		{name: "sns", leftovers: func(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+			return left[cfg.Region], nil
+		}},
+		{name: "ecr-public", regions: []string{"us-east-1"}, leftovers: func(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+			if cfg.Region != "us-east-1" {
+				return nil, errors.New("called outside of its regions")
+			}
+			return nil, nil
+		}},
+	}
+
+	require.NoError(t, sweepLeftovers(ctx, registered, cfgs))
+
+	left["ap-east-1"] = []string{"arn:aws:sns:ap-east-1:111111111111:role-fh9283f-sns-ap-east-1-111111111111-1"}
+	left["us-east-1"] = []string{"arn:aws:sns:us-east-1:111111111111:role-fh9283f-sns-us-east-1-111111111111-0"}
+	err := sweepLeftovers(ctx, registered, cfgs)
+	assert.ErrorContains(t, err, "2 resources are left after cleaning up, run -clean again to retry them: arn:aws:sns:ap-east-1")
+
+	// Regions that couldn't be checked are reported too.
+	registered[0].leftovers = func(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+		return nil, errors.New("AccessDenied")
+	}
+	err = sweepLeftovers(ctx, registered, cfgs)
+	assert.ErrorContains(t, err, "111111111111-ap-east-1: listing sns resources: AccessDenied")
+}
+
+func TestDiscoveredPlugins(t *testing.T) {
+	cfgs := map[string]utils.ThreadConfig{
+		"111111111111-us-east-1": {AccountId: "111111111111", Region: "us-east-1"},
+	}
+	var sns pluginInfo
+	for _, p := range registeredPlugins {
+		if p.name == "sns" {
+			sns = p
+		}
+	}
+	topic := "arn:aws:sns:us-east-1:111111111111:role-fh9283f-sns-us-east-1-111111111111-"
+	found := []leftovers{{cfgKey: "111111111111-us-east-1", plugin: sns, arns: []string{
+		topic + "0",
+		// Set up with a higher concurrency than sns has now.
+		topic + "7",
+		topic + "500",
+		"arn:aws:sns:us-east-1:111111111111:role-fh9283f-sns-renamed",
+	}}}
+
+	ps, unknown := discoveredPlugins(cfgs, found)
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name())
+	}
+	assert.Equal(t, []string{"sns-111111111111-us-east-1-0", "sns-111111111111-us-east-1-7"}, names)
+	assert.Equal(t, []string{"arn:aws:sns:us-east-1:111111111111:role-fh9283f-sns-renamed", topic + "500"}, unknown)
+
+	thread, ok := resourceThread("arn:aws:s3:::role-fh9283f-s3-bucket-us-east-1-111111111111-3")
+	assert.True(t, ok)
+	assert.Equal(t, 3, thread)
+	_, ok = resourceThread("arn:aws:s3:::role-fh9283f-s3-bucket")
+	assert.False(t, ok)
+}
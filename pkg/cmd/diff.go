@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type DiffOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Against is the name of an earlier scan to compare Name to.
+	Against string
+	// Since compares Name to itself as of this time, as a duration (30d, 36h) or date (2024-01-02).
+	Since string
+	// SinceRun compares Name to itself as of the start of the run with this ID.
+	SinceRun int
+	// Format is text or jsonl.
+	Format string
+}
+
+// diffEntry is a single difference between two scans, Before and After are nil when the principal wasn't scanned.
+type diffEntry struct {
+	Arn    string `json:"arn"`
+	Change string `json:"change"`
+	Before *bool  `json:"before"`
+	After  *bool  `json:"after"`
+}
+
+const (
+	// changeAdded is a principal that exists and wasn't scanned before.
+	changeAdded = "added"
+	// changeRemoved is a principal that existed and wasn't scanned after.
+	changeRemoved = "removed"
+	// changeFlipped is a principal that was scanned in both with a different verdict.
+	changeFlipped = "changed"
+)
+
+// Diff reports principals that appeared, disappeared, or changed status between two scans, or between two points in
+// time of the same scan.
+func Diff(ctx *utils.Context, opts DiffOpts) error {
+	if n := countSet(opts.Against != "", opts.Since != "", opts.SinceRun != 0); n != 1 {
+		return fmt.Errorf("exactly one of -against, -since, or -since-run is required")
+	}
+	if opts.Format != "text" && opts.Format != "jsonl" {
+		return fmt.Errorf("unknown format %q: must be text or jsonl", opts.Format)
+	}
+
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	after := map[string]bool{}
+	for key, info := range storage.All() {
+		after[key.Arn] = info.Exists
+	}
+
+	before := map[string]bool{}
+	if opts.Since != "" || opts.SinceRun != 0 {
+		since, err := diffSince(storage, opts)
+		if err != nil {
+			return err
+		}
+		for key, info := range storage.All() {
+			if exists, known := info.At(since); known {
+				before[key.Arn] = exists
+			}
+		}
+	} else {
+		against, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Against)
+		if err != nil {
+			return fmt.Errorf("opening %s: %s", opts.Against, err)
+		}
+		for key, info := range against.All() {
+			before[key.Arn] = info.Exists
+		}
+		against.Close()
+	}
+
+	entries := diffResults(before, after)
+	ctx.Info.Printf("%d differences found", len(entries))
+	return writeDiff(os.Stdout, opts.Format, entries)
+}
+
+// diffSince returns the point in time to compare Name to, either parsed from Since or the start of SinceRun.
+func diffSince(storage scanner.Storage, opts DiffOpts) (time.Time, error) {
+	if opts.Since != "" {
+		since, err := utils.ParseSince(opts.Since)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("parsing since: %s", err)
+		}
+		return since, nil
+	}
+
+	md, err := storage.Metadata()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("getting metadata: %s", err)
+	}
+	run := md.Run(opts.SinceRun)
+	if run == nil {
+		return time.Time{}, fmt.Errorf("%s has no run %d", opts.Name, opts.SinceRun)
+	}
+	return run.Start, nil
+}
+
+func countSet(values ...bool) int {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return n
+}
+
+// diffResults compares two sets of scan verdicts keyed by ARN. Principals that weren't found in either set aren't
+// reported, so scanning new candidates that don't exist doesn't show up as a difference.
+func diffResults(before, after map[string]bool) []diffEntry {
+	var entries []diffEntry
+
+	for principalArn, exists := range after {
+		was, scanned := before[principalArn]
+		if !scanned && exists {
+			entries = append(entries, diffEntry{Arn: principalArn, Change: changeAdded, After: &exists})
+		} else if scanned && was != exists {
+			entries = append(entries, diffEntry{Arn: principalArn, Change: changeFlipped, Before: &was, After: &exists})
+		}
+	}
+	for principalArn, was := range before {
+		if _, scanned := after[principalArn]; !scanned && was {
+			entries = append(entries, diffEntry{Arn: principalArn, Change: changeRemoved, Before: &was})
+		}
+	}
+
+	slices.SortFunc(entries, func(a, b diffEntry) int {
+		return strings.Compare(a.Arn, b.Arn)
+	})
+	return entries
+}
+
+// writeDiff writes entries as JSON lines, or as text with one +, - or ~ prefixed ARN per line.
+func writeDiff(w io.Writer, format string, entries []diffEntry) error {
+	if format == "jsonl" {
+		enc := json.NewEncoder(w)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return fmt.Errorf("encoding %s: %w", entry.Arn, err)
+			}
+		}
+		return nil
+	}
+
+	for _, entry := range entries {
+		var err error
+		switch entry.Change {
+		case changeAdded:
+			_, err = fmt.Fprintf(w, "+ %s\n", entry.Arn)
+		case changeRemoved:
+			_, err = fmt.Fprintf(w, "- %s\n", entry.Arn)
+		case changeFlipped:
+			_, err = fmt.Fprintf(w, "~ %s # %s -> %s\n", entry.Arn, existsLabel(*entry.Before), existsLabel(*entry.After))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func existsLabel(exists bool) string {
+	if exists {
+		return "exists"
+	}
+	return "not-exists"
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffResults(t *testing.T) {
+	before := map[string]bool{
+		"arn:aws:iam::123456789012:role/deleted":   true,
+		"arn:aws:iam::123456789012:role/created":   false,
+		"arn:aws:iam::123456789012:role/unscanned": true,
+		"arn:aws:iam::123456789012:role/same":      true,
+		"arn:aws:iam::123456789012:role/missing":   false,
+	}
+	after := map[string]bool{
+		"arn:aws:iam::123456789012:role/deleted": false,
+		"arn:aws:iam::123456789012:role/created": true,
+		"arn:aws:iam::123456789012:role/same":    true,
+		"arn:aws:iam::123456789012:role/new":     true,
+		"arn:aws:iam::123456789012:role/absent":  false,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeDiff(&buf, "text", diffResults(before, after)))
+	assert.Equal(t, "~ arn:aws:iam::123456789012:role/created # not-exists -> exists\n"+
+		"~ arn:aws:iam::123456789012:role/deleted # exists -> not-exists\n"+
+		"+ arn:aws:iam::123456789012:role/new\n"+
+		"- arn:aws:iam::123456789012:role/unscanned\n",
+		buf.String())
+}
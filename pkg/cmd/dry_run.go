@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// dryRunPlugin routes principals like the plugin it wraps without making any AWS calls, it records which principals
+// it was given instead of scanning them.
+type dryRunPlugin struct {
+	plugin plugins.Plugin
+	name   string
+	// routed maps each principal ARN given to a dry run plugin to the plugin's name.
+	routed *sync.Map
+}
+
+func (p *dryRunPlugin) Name() string                     { return p.name }
+func (p *dryRunPlugin) Setup(ctx *utils.Context) error   { return nil }
+func (p *dryRunPlugin) CleanUp(ctx *utils.Context) error { return nil }
+
+func (p *dryRunPlugin) PrincipalTypes() []string {
+	return plugins.PrincipalTypes(p.plugin)
+}
+
+// ScanArn reports account roots as existing and everything else as not, so the principals in every account not
+// already known to be missing are routed too.
+func (p *dryRunPlugin) ScanArn(ctx *utils.Context, principalArn string) (bool, error) {
+	p.routed.Store(principalArn, p.name)
+	return isRootArn(principalArn), nil
+}
+
+// dryRunPlugins returns a dry run plugin for each instance of the registered plugins in a single placeholder account,
+// named after the plugin they wrap.
+func dryRunPlugins(registered []pluginInfo, routed *sync.Map) [][]plugins.Plugin {
+	var result [][]plugins.Plugin
+	for _, info := range registered {
+		var group []plugins.Plugin
+		for _, plugin := range info.new(placeholderConfigs(info), info.concurrency) {
+			group = append(group, &dryRunPlugin{plugin: plugin, name: info.name, routed: routed})
+		}
+		result = append(result, group)
+	}
+	return result
+}
+
+// dryRunEntry is what a scan would do with a candidate.
+type dryRunEntry struct {
+	arn string
+	// action is scan, cached, or skip.
+	action string
+	// detail is the plugin the candidate would be scanned with, the cached verdict, or why it would be skipped.
+	detail string
+}
+
+// DryRun goes through everything a scan does except the AWS calls: the candidates are generated, stored results are
+// checked, and the principals left are routed to the plugins that support them, but nothing is scanned or stored.
+// What would happen to each candidate is written to stdout.
+func DryRun(ctx *utils.Context, opts Opts) error {
+	// Loading the config doesn't make any calls, it's only used by remote storage backends to read stored results.
+	cfg, err := utils.LoadConfig(ctx, opts.Profile, config.WithRegion("us-east-1"))
+	if err != nil {
+		return fmt.Errorf("loading config: %s", err)
+	}
+
+	storage, err := scanner.NewStorage(ctx, cfg, opts.Storage, opts.Name)
+	if err != nil {
+		return fmt.Errorf("new storage: %s", err)
+	}
+	defer storage.Close()
+
+	md, err := storage.Metadata()
+	if err != nil {
+		return fmt.Errorf("getting metadata: %s", err)
+	}
+	if md.Engagement != nil {
+		if err := md.Engagement.Active(time.Now()); err != nil {
+			ctx.Error.Printf("a scan of %s would be refused: %s", opts.Name, err)
+		}
+	}
+
+	registered, err := selectPlugins(opts.Plugins)
+	if err != nil {
+		return err
+	}
+	input, _, err := getArnsInput(opts)
+	if err != nil {
+		return err
+	}
+	candidates, err := arn.GetArns(ctx, input)
+	if err != nil {
+		return fmt.Errorf("getting scanData: %s", err)
+	}
+
+	routed := &sync.Map{}
+	scan := scanner.NewScanner(&scanner.NewScannerInput{
+		Storage:       storage,
+		Force:         opts.Force,
+		Plugins:       dryRunPlugins(registered, routed),
+		SkipRootCheck: opts.SkipRootCheck,
+		ShuffleRoots:  opts.AccountShuffle,
+		DryRun:        true,
+	})
+
+	entries := dryRunEntries(candidates, scan.ScanArns(ctx, candidates), routed, opts.SkipRootCheck)
+	return writeDryRun(os.Stdout, entries, opts.RateLimit)
+}
+
+// dryRunEntries returns what a scan would do with each candidate, from the results of a dry run scan.
+func dryRunEntries(candidates map[string]utils.Info, results iter.Seq2[string, utils.Info], routed *sync.Map, skipRootCheck bool) []dryRunEntry {
+	missingAccounts := map[string]bool{}
+	seen := map[string]bool{}
+	var entries []dryRunEntry
+
+	for principalArn, info := range results {
+		seen[principalArn] = true
+		if plugin, ok := routed.Load(principalArn); ok {
+			entries = append(entries, dryRunEntry{arn: principalArn, action: "scan", detail: plugin.(string)})
+			continue
+		}
+
+		detail := "not found"
+		if info.Exists {
+			detail = "exists"
+		}
+		if isRootArn(principalArn) && !info.Exists {
+			missingAccounts[principalArn] = true
+		}
+		entries = append(entries, dryRunEntry{arn: principalArn, action: "cached", detail: detail})
+	}
+
+	// Candidates the scan never returned are roots with -skip-root-check, in accounts stored as missing, or have no
+	// plugin supporting their type.
+	for principalArn := range candidates {
+		if seen[principalArn] {
+			continue
+		}
+		detail := fmt.Sprintf("no plugin supports %s principals", plugins.PrincipalType(principalArn))
+		if isRootArn(principalArn) && skipRootCheck {
+			detail = "root check skipped"
+		} else if root, err := rootArnOf(principalArn); err == nil && missingAccounts[root] {
+			detail = "account not found (cached)"
+		}
+		entries = append(entries, dryRunEntry{arn: principalArn, action: "skip", detail: detail})
+	}
+
+	slices.SortFunc(entries, func(a, b dryRunEntry) int { return strings.Compare(a.arn, b.arn) })
+	return entries
+}
+
+func rootArnOf(principalArn string) (string, error) {
+	key, err := scanner.NewKey(principalArn)
+	if err != nil {
+		return "", err
+	}
+	return utils.GetRootArn(key.AccountID), nil
+}
+
+func writeDryRun(w io.Writer, entries []dryRunEntry, rateLimit float64) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tARN\tDETAIL")
+	counts := map[string]int{}
+	byPlugin := map[string]int{}
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", entry.action, entry.arn, entry.detail)
+		counts[entry.action]++
+		if entry.action == "scan" {
+			byPlugin[entry.detail]++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var perPlugin []string
+	for _, name := range slices.Sorted(maps.Keys(byPlugin)) {
+		perPlugin = append(perPlugin, fmt.Sprintf("%s %d", name, byPlugin[name]))
+	}
+	summary := fmt.Sprintf("\ndry run: %d candidates, %d would be scanned", len(entries), counts["scan"])
+	if len(perPlugin) > 0 {
+		summary += fmt.Sprintf(" (%s)", strings.Join(perPlugin, ", "))
+	}
+	summary += fmt.Sprintf(", %d cached, %d skipped", counts["cached"], counts["skip"])
+	if rateLimit > 0 {
+		summary += fmt.Sprintf(", about %s at %g per second", formatDuration(scanDuration(counts["scan"], rateLimit)), rateLimit)
+	}
+	_, err := fmt.Fprintln(w, summary)
+	return err
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunEntries(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	storage, err := scanner.NewFileStorage(ctx, t.TempDir(), "test", scanner.StorageOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	for principalArn, exists := range map[string]bool{
+		"arn:aws:iam::111111111111:root":       true,
+		"arn:aws:iam::111111111111:role/known": true,
+		"arn:aws:iam::222222222222:root":       false,
+	} {
+		key, err := scanner.NewKey(principalArn)
+		require.NoError(t, err)
+		storage.Set(key, utils.Info{Exists: exists})
+	}
+
+	candidates := map[string]utils.Info{}
+	for _, principalArn := range []string{
+		"arn:aws:iam::111111111111:root",
+		"arn:aws:iam::111111111111:role/known",
+		"arn:aws:iam::111111111111:role/new",
+		"arn:aws:iam::222222222222:root",
+		"arn:aws:iam::222222222222:role/new",
+		"arn:aws:iam::333333333333:root",
+		"arn:aws:iam::333333333333:user/new",
+		"arn:aws:iam::333333333333:saml-provider/okta",
+	} {
+		candidates[principalArn] = utils.Info{}
+	}
+
+	routed := &sync.Map{}
+	scan := scanner.NewScanner(&scanner.NewScannerInput{
+		Storage: storage,
+		Plugins: dryRunPlugins(registeredPlugins, routed),
+		DryRun:  true,
+	})
+	entries := dryRunEntries(candidates, scan.ScanArns(ctx, candidates), routed, false)
+
+	got := map[string]string{}
+	for _, entry := range entries {
+		got[entry.arn] = entry.action
+	}
+	assert.Equal(t, map[string]string{
+		"arn:aws:iam::111111111111:root":               "cached",
+		"arn:aws:iam::111111111111:role/known":         "cached",
+		"arn:aws:iam::111111111111:role/new":           "scan",
+		"arn:aws:iam::222222222222:root":               "cached",
+		"arn:aws:iam::222222222222:role/new":           "skip",
+		"arn:aws:iam::333333333333:root":               "scan",
+		"arn:aws:iam::333333333333:user/new":           "scan",
+		"arn:aws:iam::333333333333:saml-provider/okta": "skip",
+	}, got)
+
+	// Nothing is stored by a dry run.
+	_, status, err := storage.Get(scanner.Key{AccountID: "333333333333", Arn: "arn:aws:iam::333333333333:root"})
+	require.NoError(t, err)
+	assert.Equal(t, scanner.PrincipalUnknown, status)
+}
+
+func TestWriteDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeDryRun(&buf, []dryRunEntry{
+		{arn: "arn:aws:iam::111111111111:role/a", action: "scan", detail: "sns"},
+		{arn: "arn:aws:iam::111111111111:role/b", action: "scan", detail: "sqs"},
+		{arn: "arn:aws:iam::111111111111:role/c", action: "cached", detail: "exists"},
+		{arn: "arn:aws:iam::222222222222:role/d", action: "skip", detail: "account not found (cached)"},
+	}, 1))
+
+	assert.Equal(t, `ACTION  ARN                               DETAIL
+scan    arn:aws:iam::111111111111:role/a  sns
+scan    arn:aws:iam::111111111111:role/b  sqs
+cached  arn:aws:iam::111111111111:role/c  exists
+skip    arn:aws:iam::222222222222:role/d  account not found (cached)
+
+dry run: 4 candidates, 2 would be scanned (sns 1, sqs 1), 1 cached, 1 skipped, about 2s at 1 per second
+`, buf.String())
+}
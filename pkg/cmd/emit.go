@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// terraformResource is how a StackResource type is written as a Terraform resource, properties are the Terraform
+// arguments of the top level template properties, nested properties are converted to snake case.
+type terraformResource struct {
+	resourceType string
+	properties   map[string]string
+}
+
+// terraformResources are the Terraform resources of the template resource types plugins use.
+//
+// Add new resource types here.
+var terraformResources = map[string]terraformResource{
+	"AWS::SNS::Topic":            {resourceType: "aws_sns_topic", properties: map[string]string{"TopicName": "name"}},
+	"AWS::SQS::Queue":            {resourceType: "aws_sqs_queue", properties: map[string]string{"QueueName": "name"}},
+	"AWS::S3::Bucket":            {resourceType: "aws_s3_bucket", properties: map[string]string{"BucketName": "bucket"}},
+	"AWS::ECR::PublicRepository": {resourceType: "aws_ecrpublic_repository", properties: map[string]string{"RepositoryName": "repository_name"}},
+	"AWS::S3::AccessPoint": {resourceType: "aws_s3_access_point", properties: map[string]string{
+		"Bucket":                         "bucket",
+		"Name":                           "name",
+		"PublicAccessBlockConfiguration": "public_access_block_configuration",
+	}},
+}
+
+// terraformConditions are the Terraform expressions of plugins.StackConditions.
+var terraformConditions = map[string]string{
+	plugins.StackConditionUSEast1: `data.aws_region.current.name == "us-east-1"`,
+}
+
+// terraformSub replaces the pseudo parameters of Fn::Sub with the data sources the Terraform template has.
+var terraformSub = strings.NewReplacer(
+	"${AWS::Region}", "${data.aws_region.current.name}",
+	"${AWS::AccountId}", "${data.aws_caller_identity.current.account_id}",
+)
+
+// EmitTemplates writes a CloudFormation template to cfnPath and a Terraform configuration to terraformPath describing
+// the resources of every registered plugin, for teams that provision infrastructure through their own pipelines
+// instead of with -setup. Either path can be empty to skip it or - for stdout. Both are applied once in every account
+// and region scanning runs in, the resource names include the account ID and region the same way -setup names them.
+// Only the resources of pluginNames are included if it isn't empty.
+func EmitTemplates(cfnPath string, terraformPath string, pluginNames []string) error {
+	registered, err := selectPlugins(pluginNames)
+	if err != nil {
+		return err
+	}
+
+	if cfnPath != "" {
+		template, err := stackSetTemplate(registered)
+		if err != nil {
+			return err
+		}
+		if err := writeTemplate(cfnPath, func(w io.Writer) error {
+			_, err := fmt.Fprintln(w, template)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if terraformPath != "" {
+		if err := writeTemplate(terraformPath, func(w io.Writer) error {
+			return writeTerraform(w, registered)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTemplate calls write with the file at path, or stdout if path is -.
+func writeTemplate(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating %s: %s", path, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %s", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing %s: %s", path, err)
+	}
+	return nil
+}
+
+// writeTerraform writes the resources of each registered plugin as a Terraform configuration, using the same logical
+// IDs as the CloudFormation template for the resource names.
+func writeTerraform(w io.Writer, registered []pluginInfo) error {
+	resources := map[string]plugins.StackResource{}
+	for _, p := range registered {
+		maps.Copy(resources, p.stackResources(p.concurrency))
+	}
+
+	var b strings.Builder
+	b.WriteString("# Resources the role scanner updates the policy of to check whether principals exist, apply this in every\n")
+	b.WriteString("# account and region scanning runs in.\n\n")
+	b.WriteString("data \"aws_caller_identity\" \"current\" {}\n\n")
+	b.WriteString("data \"aws_region\" \"current\" {}\n")
+
+	for _, id := range slices.Sorted(maps.Keys(resources)) {
+		resource := resources[id]
+		tf, ok := terraformResources[resource.Type]
+		if !ok {
+			return fmt.Errorf("%s: no Terraform resource for %s", id, resource.Type)
+		}
+
+		fmt.Fprintf(&b, "\nresource %q %q {\n", tf.resourceType, id)
+		if resource.Condition != "" {
+			condition, ok := terraformConditions[resource.Condition]
+			if !ok {
+				return fmt.Errorf("%s: no Terraform expression for condition %s", id, resource.Condition)
+			}
+			fmt.Fprintf(&b, "  count = %s ? 1 : 0\n\n", condition)
+		}
+		arguments := map[string]any{}
+		for property, value := range resource.Properties {
+			name, ok := tf.properties[property]
+			if !ok {
+				return fmt.Errorf("%s: no Terraform argument for %s", id, property)
+			}
+			value, err := terraformValue(resources, value)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %s", id, property, err)
+			}
+			arguments[name] = value
+		}
+		writeTerraformArguments(&b, "  ", arguments)
+		b.WriteString("}\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// terraformValue returns value as a Terraform expression, or a map of nested arguments for a block. Ref and Fn::Sub are
+// the only intrinsic functions plugins use.
+func terraformValue(resources map[string]plugins.StackResource, value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case map[string]any:
+		if sub, ok := v["Fn::Sub"].(string); ok {
+			return strconv.Quote(terraformSub.Replace(sub)), nil
+		}
+		if ref, ok := v["Ref"].(string); ok {
+			target, ok := resources[ref]
+			if !ok {
+				return nil, fmt.Errorf("Ref to unknown resource %s", ref)
+			}
+			tf, ok := terraformResources[target.Type]
+			if !ok {
+				return nil, fmt.Errorf("no Terraform resource for %s", target.Type)
+			}
+			return fmt.Sprintf("%s.%s.id", tf.resourceType, ref), nil
+		}
+
+		block := map[string]any{}
+		for key, nested := range v {
+			value, err := terraformValue(resources, nested)
+			if err != nil {
+				return nil, err
+			}
+			block[snakeCase(key)] = value
+		}
+		return block, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// writeTerraformArguments writes arguments, and blocks for the ones that are maps, sorted by name at indent. The equals
+// signs of consecutive arguments are aligned like terraform fmt does.
+func writeTerraformArguments(b *strings.Builder, indent string, arguments map[string]any) {
+	names := slices.Sorted(maps.Keys(arguments))
+	for i, name := range names {
+		if block, ok := arguments[name].(map[string]any); ok {
+			fmt.Fprintf(b, "%s%s {\n", indent, name)
+			writeTerraformArguments(b, indent+"  ", block)
+			fmt.Fprintf(b, "%s}\n", indent)
+			continue
+		}
+
+		width := 0
+		for j := i - 1; j >= 0 && !isTerraformBlock(arguments[names[j]]); j-- {
+			width = max(width, len(names[j]))
+		}
+		for j := i; j < len(names) && !isTerraformBlock(arguments[names[j]]); j++ {
+			width = max(width, len(names[j]))
+		}
+		fmt.Fprintf(b, "%s%-*s = %s\n", indent, width, name, arguments[name])
+	}
+}
+
+func isTerraformBlock(value any) bool {
+	_, ok := value.(map[string]any)
+	return ok
+}
+
+var snakeCaseBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// snakeCase converts a template property name like BlockPublicAcls to a Terraform argument like block_public_acls.
+func snakeCase(name string) string {
+	return strings.ToLower(snakeCaseBoundary.ReplaceAllString(name, "${1}_${2}"))
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmitTemplates(t *testing.T) {
+	dir := t.TempDir()
+	cfnPath := filepath.Join(dir, "roles.template.json")
+	terraformPath := filepath.Join(dir, "roles.tf")
+	require.NoError(t, EmitTemplates(cfnPath, terraformPath, nil))
+
+	// The CloudFormation template is the StackSet's.
+	expected, err := stackSetTemplate(registeredPlugins)
+	require.NoError(t, err)
+	cfn, err := os.ReadFile(cfnPath)
+	require.NoError(t, err)
+	assert.JSONEq(t, expected, string(cfn))
+
+	// Every template resource is in the Terraform configuration.
+	var template struct{ Resources map[string]json.RawMessage }
+	require.NoError(t, json.Unmarshal(cfn, &template))
+	terraform, err := os.ReadFile(terraformPath)
+	require.NoError(t, err)
+	for id := range template.Resources {
+		assert.Contains(t, string(terraform), `"`+id+`" {`)
+	}
+
+	// Skipped without a path.
+	require.NoError(t, os.Remove(terraformPath))
+	require.NoError(t, EmitTemplates("", "", nil))
+	assert.NoFileExists(t, terraformPath)
+
+	assert.ErrorContains(t, EmitTemplates(filepath.Join(dir, "missing", "roles.tf"), "", nil), "creating")
+
+	// Only the resources of the chosen plugins.
+	require.NoError(t, EmitTemplates("", terraformPath, []string{"sns"}))
+	terraform, err = os.ReadFile(terraformPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(terraform), `"SNSTopic0"`)
+	assert.NotContains(t, string(terraform), `"SQSQueue0"`)
+	assert.ErrorContains(t, EmitTemplates("", terraformPath, []string{"dynamodb"}), "unknown plugin dynamodb")
+}
+
+func TestWriteTerraform(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, writeTerraform(&b, []pluginInfo{
+		{concurrency: 1, stackResources: plugins.AccessPointStackResources},
+		{concurrency: 1, stackResources: plugins.ECRPublicRepositoryStackResources},
+	}))
+
+	assert.Equal(t, `# Resources the role scanner updates the policy of to check whether principals exist, apply this in every
+# account and region scanning runs in.
+
+data "aws_caller_identity" "current" {}
+
+data "aws_region" "current" {}
+
+resource "aws_s3_access_point" "AccessPoint0" {
+  bucket = aws_s3_bucket.AccessPointBucket0.id
+  name   = "role-${data.aws_region.current.name}-0"
+  public_access_block_configuration {
+    block_public_acls       = true
+    block_public_policy     = true
+    ignore_public_acls      = true
+    restrict_public_buckets = true
+  }
+}
+
+resource "aws_s3_bucket" "AccessPointBucket0" {
+  bucket = "role-fh9283f-s3-access-points-${data.aws_region.current.name}-${data.aws_caller_identity.current.account_id}-0"
+}
+
+resource "aws_ecrpublic_repository" "ECRPublicRepository0" {
+  count = data.aws_region.current.name == "us-east-1" ? 1 : 0
+
+  repository_name = "role-fh9283f-ecr-public-${data.aws_region.current.name}-${data.aws_caller_identity.current.account_id}-0"
+}
+`, b.String())
+
+	unknown := func(int) map[string]plugins.StackResource {
+		return map[string]plugins.StackResource{"Table0": {Type: "AWS::DynamoDB::Table"}}
+	}
+	assert.ErrorContains(t, writeTerraform(&b, []pluginInfo{{concurrency: 1, stackResources: unknown}}), "Table0: no Terraform resource for AWS::DynamoDB::Table")
+}
+
+func TestSnakeCase(t *testing.T) {
+	assert.Equal(t, "block_public_acls", snakeCase("BlockPublicAcls"))
+	assert.Equal(t, "name", snakeCase("Name"))
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"os"
+	"time"
+)
+
+type EngagementOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Client, Authorization, Start, and End update the engagement when set, if none are set it is printed instead.
+	Client        string
+	Authorization string
+	// Start and End are RFC 3339 timestamps or dates (2024-01-02), an End date includes the whole day.
+	Start string
+	End   string
+}
+
+// Engagement shows or updates the engagement stored with a scan.
+func Engagement(ctx *utils.Context, opts EngagementOpts) error {
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if opts.Client == "" && opts.Authorization == "" && opts.Start == "" && opts.End == "" {
+		md, err := storage.Metadata()
+		if err != nil {
+			return fmt.Errorf("getting metadata: %s", err)
+		}
+		return printEngagement(md.Engagement)
+	}
+
+	var engagement *scanner.Engagement
+	err = storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		if md.Engagement == nil {
+			md.Engagement = &scanner.Engagement{}
+		}
+		if err := updateEngagement(md.Engagement, opts); err != nil {
+			return err
+		}
+		engagement = md.Engagement
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("updating metadata: %s", err)
+	}
+
+	ctx.Info.Printf("updated engagement for %s", opts.Name)
+	return printEngagement(engagement)
+}
+
+// updateEngagement applies the fields set in opts to engagement.
+func updateEngagement(engagement *scanner.Engagement, opts EngagementOpts) error {
+	if opts.Client != "" {
+		engagement.Client = opts.Client
+	}
+	if opts.Authorization != "" {
+		engagement.Authorization = opts.Authorization
+	}
+	if opts.Start != "" {
+		start, err := parseEngagementTime(opts.Start, false)
+		if err != nil {
+			return fmt.Errorf("parsing start: %s", err)
+		}
+		engagement.Start = start
+	}
+	if opts.End != "" {
+		end, err := parseEngagementTime(opts.End, true)
+		if err != nil {
+			return fmt.Errorf("parsing end: %s", err)
+		}
+		engagement.End = end
+	}
+
+	if !engagement.Start.IsZero() && !engagement.End.IsZero() && engagement.End.Before(engagement.Start) {
+		return fmt.Errorf("engagement ends before it starts")
+	}
+	return nil
+}
+
+// parseEngagementTime parses an RFC 3339 timestamp or a date in local time, endOfDay moves a date to the last second
+// of that day.
+func parseEngagementTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: expected a date like 2024-01-02 or an RFC 3339 timestamp", value)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t, nil
+}
+
+func printEngagement(engagement *scanner.Engagement) error {
+	if engagement == nil {
+		engagement = &scanner.Engagement{}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(engagement)
+}
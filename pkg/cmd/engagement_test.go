@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateEngagement(t *testing.T) {
+	engagement := &scanner.Engagement{Client: "acme", Authorization: "SOW-1"}
+
+	require.NoError(t, updateEngagement(engagement, EngagementOpts{
+		Authorization: "SOW-2",
+		Start:         "2024-01-01",
+		End:           "2024-01-31",
+	}))
+	assert.Equal(t, "acme", engagement.Client)
+	assert.Equal(t, "SOW-2", engagement.Authorization)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), engagement.Start)
+	assert.Equal(t, time.Date(2024, 1, 31, 23, 59, 59, 0, time.Local), engagement.End)
+
+	require.NoError(t, updateEngagement(engagement, EngagementOpts{End: "2024-02-01T12:00:00Z"}))
+	assert.Equal(t, time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC), engagement.End)
+
+	assert.EqualError(t, updateEngagement(engagement, EngagementOpts{End: "2023-12-31"}), "engagement ends before it starts")
+	assert.Error(t, updateEngagement(engagement, EngagementOpts{Start: "soon"}))
+}
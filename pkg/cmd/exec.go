@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// execHook runs a user command for each principal found, like -exec-on-found 'aws-enum-role {}'.
+type execHook struct {
+	// script is the command with {} replaced by "$1", so the ARN is passed as an argument instead of being parsed by
+	// the shell.
+	script string
+	// output is where the command's stdout and stderr go.
+	output io.Writer
+}
+
+func newExecHook(command string) (*execHook, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("empty -exec-on-found command")
+	}
+
+	script := strings.ReplaceAll(command, "{}", `"$1"`)
+	if script == command {
+		// Without a {} the ARN is added as the last argument.
+		script += ` "$1"`
+	}
+	return &execHook{script: script, output: os.Stderr}, nil
+}
+
+// command returns the command for principalArn, it's run with sh so pipes and redirects work.
+func (h *execHook) command(ctx *utils.Context, principalArn string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", h.script, "roles", principalArn)
+}
+
+// Run runs the command for principalArn and waits for it to finish. Its output goes to stderr by default so it isn't
+// mixed with the results on stdout.
+func (h *execHook) Run(ctx *utils.Context, principalArn string) error {
+	cmd := h.command(ctx, principalArn)
+	cmd.Stdout = h.output
+	cmd.Stderr = h.output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running -exec-on-found for %s: %s", principalArn, err)
+	}
+	return nil
+}
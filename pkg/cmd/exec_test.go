@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecHook(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	out := filepath.Join(t.TempDir(), "found.list")
+
+	hook, err := newExecHook("echo found {} >> " + out)
+	require.NoError(t, err)
+	require.NoError(t, hook.Run(ctx, "arn:aws:iam::123456789012:role/a"))
+	require.NoError(t, hook.Run(ctx, "arn:aws:iam::123456789012:role/$(false)"))
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "found arn:aws:iam::123456789012:role/a\nfound arn:aws:iam::123456789012:role/$(false)\n", string(data))
+}
+
+func TestExecHook_Command(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	hook, err := newExecHook("enum-role")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", `enum-role "$1"`, "roles", "arn:aws:iam::123456789012:role/a"},
+		hook.command(ctx, "arn:aws:iam::123456789012:role/a").Args)
+
+	hook, err = newExecHook("exit 1")
+	require.NoError(t, err)
+	assert.Error(t, hook.Run(ctx, "arn:aws:iam::123456789012:role/a"))
+
+	_, err = newExecHook(" ")
+	assert.Error(t, err)
+}
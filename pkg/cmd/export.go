@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ExportOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Format is one of json, jsonl, csv, or the graph formats opengraph and cypher.
+	Format string
+	// Status is one of all, exists, or not-exists.
+	Status string
+	// Account limits the export to a single account ID.
+	Account string
+	// Since limits the export to results checked after this time, as a duration (720h) or date (2024-01-02).
+	Since string
+}
+
+// Export writes the stored results for a scan to stdout.
+func Export(ctx *utils.Context, opts ExportOpts) error {
+	filter, err := newRecordFilter(opts.Status, opts.Account, opts.Since)
+	if err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var records []scanRecord
+	for key, info := range storage.All() {
+		if rec := newScanRecord(key.Arn, info); filter.Match(rec) {
+			records = append(records, rec)
+		}
+	}
+	slices.SortFunc(records, func(a, b scanRecord) int {
+		return strings.Compare(a.Arn, b.Arn)
+	})
+
+	ctx.Info.Printf("exporting %d results from %s", len(records), opts.Name)
+	return writeRecords(os.Stdout, opts.Format, records)
+}
+
+// recordFilter selects stored results by status, account and age.
+type recordFilter struct {
+	status  string
+	account string
+	since   time.Time
+}
+
+func newRecordFilter(status, account, since string) (recordFilter, error) {
+	filter := recordFilter{status: status, account: account}
+
+	switch status {
+	case "", "all", "exists", "not-exists":
+	default:
+		return filter, fmt.Errorf("unknown status %q: must be all, exists, or not-exists", status)
+	}
+
+	if since != "" {
+		t, err := utils.ParseSince(since)
+		if err != nil {
+			return filter, fmt.Errorf("parsing since: %s", err)
+		}
+		filter.since = t
+	}
+
+	return filter, nil
+}
+
+func (f recordFilter) Match(rec scanRecord) bool {
+	if f.status == "exists" && !rec.Exists {
+		return false
+	} else if f.status == "not-exists" && rec.Exists {
+		return false
+	}
+	if f.account != "" && rec.AccountID != f.account {
+		return false
+	}
+	if !f.since.IsZero() && rec.LastChecked.Before(f.since) {
+		return false
+	}
+	return true
+}
+
+var csvHeader = []string{"arn", "account_id", "principal_type", "principal_name", "exists", "comment", "plugin", "first_seen", "last_checked", "tags", "likelihood"}
+
+// writeRecords writes records to w as a JSON array, JSON lines, CSV, or a graph of the principals found in them.
+func writeRecords(w io.Writer, format string, records []scanRecord) error {
+	switch format {
+	case "json":
+		if records == nil {
+			records = []scanRecord{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return fmt.Errorf("encoding %s: %w", rec.Arn, err)
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if err := cw.Write(csvRow(rec)); err != nil {
+				return fmt.Errorf("writing %s: %w", rec.Arn, err)
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "opengraph":
+		return writeOpenGraph(w, newGraph(records))
+	case "cypher":
+		return writeCypher(w, newGraph(records))
+	default:
+		return fmt.Errorf("unknown format %q: must be json, jsonl, csv, opengraph, or cypher", format)
+	}
+}
+
+func csvRow(rec scanRecord) []string {
+	return []string{
+		rec.Arn,
+		rec.AccountID,
+		rec.PrincipalType,
+		rec.PrincipalName,
+		strconv.FormatBool(rec.Exists),
+		rec.Comment,
+		rec.Plugin,
+		formatTime(rec.FirstSeen),
+		formatTime(rec.LastChecked),
+		strings.Join(rec.Tags, ";"),
+		formatLikelihood(rec.Likelihood),
+	}
+}
+
+// formatLikelihood formats a likelihood, or an empty string if it wasn't set.
+func formatLikelihood(likelihood float64) string {
+	if likelihood == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(likelihood, 'g', -1, 64)
+}
+
+// formatTime formats t as RFC 3339, or an empty string for results stored before timestamps were recorded.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordFilter(t *testing.T) {
+	checked := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	exists := newScanRecord("arn:aws:iam::123456789012:role/a", utils.Info{Exists: true, LastChecked: checked})
+	missing := newScanRecord("arn:aws:iam::210987654321:role/b", utils.Info{Exists: false, LastChecked: checked})
+
+	tests := []struct {
+		name    string
+		status  string
+		account string
+		since   string
+		want    []bool
+		wantErr bool
+	}{
+		{name: "all", status: "all", want: []bool{true, true}},
+		{name: "exists", status: "exists", want: []bool{true, false}},
+		{name: "not-exists", status: "not-exists", want: []bool{false, true}},
+		{name: "account", account: "210987654321", want: []bool{false, true}},
+		{name: "since before", since: "2024-01-01", want: []bool{true, true}},
+		{name: "since after", since: "2024-03-01", want: []bool{false, false}},
+		{name: "bad status", status: "maybe", wantErr: true},
+		{name: "bad since", since: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newRecordFilter(tt.status, tt.account, tt.since)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, []bool{filter.Match(exists), filter.Match(missing)})
+		})
+	}
+}
+
+func TestWriteRecords_CSV(t *testing.T) {
+	records := []scanRecord{
+		newScanRecord("arn:aws:iam::123456789012:role/a", utils.Info{
+			Exists:      true,
+			Comment:     " - vendor, inc",
+			Plugin:      "sns-123456789012-us-east-1-0",
+			LastChecked: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			Tags:        []string{"ci", "vendor"},
+			Likelihood:  0.75,
+		}),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeRecords(&buf, "csv", records))
+	assert.Equal(t, "arn,account_id,principal_type,principal_name,exists,comment,plugin,first_seen,last_checked,tags,likelihood\n"+
+		"arn:aws:iam::123456789012:role/a,123456789012,role,a,true,\" - vendor, inc\",sns-123456789012-us-east-1-0,,2024-02-01T00:00:00Z,ci;vendor,0.75\n",
+		buf.String())
+
+	require.Error(t, writeRecords(&buf, "xml", records))
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// filterFields are the parts of a result -filter can match on, by name.
+var filterFields = map[string]func(rec scanRecord) []string{
+	"arn":     func(rec scanRecord) []string { return []string{rec.Arn} },
+	"account": func(rec scanRecord) []string { return []string{rec.AccountID} },
+	"type":    func(rec scanRecord) []string { return []string{rec.PrincipalType} },
+	"name":    func(rec scanRecord) []string { return []string{rec.PrincipalName} },
+	"comment": func(rec scanRecord) []string { return []string{strings.TrimSpace(rec.Comment)} },
+	"plugin":  func(rec scanRecord) []string { return []string{rec.Plugin} },
+	"tag":     func(rec scanRecord) []string { return rec.Tags },
+}
+
+// ResultFilter selects which results are output, like comment~datadog.
+type ResultFilter struct {
+	field  string
+	negate bool
+	// Either value is compared to the field ignoring case, or pattern is matched against it.
+	value   string
+	pattern *regexp.Regexp
+}
+
+// ParseFilter parses a filter of the form <field><op><value>, where op is = or != to compare the value ignoring case,
+// or ~ or !~ to match a regular expression ignoring case. Fields are arn, account, type, name, comment, plugin, and
+// tag, which matches if any of the result's tags do.
+func ParseFilter(s string) (ResultFilter, error) {
+	i := strings.IndexAny(s, "=~")
+	if i <= 0 {
+		return ResultFilter{}, fmt.Errorf("invalid filter %q: must be like comment~datadog or type=role", s)
+	}
+
+	f := ResultFilter{field: strings.TrimSpace(s[:i])}
+	if strings.HasSuffix(f.field, "!") {
+		f.field, f.negate = strings.TrimSpace(strings.TrimSuffix(f.field, "!")), true
+	}
+	if _, ok := filterFields[f.field]; !ok {
+		return ResultFilter{}, fmt.Errorf("invalid filter %q: unknown field %q, must be one of arn, account, type, name, comment, plugin, or tag", s, f.field)
+	}
+
+	value := s[i+1:]
+	if s[i] == '=' {
+		f.value = value
+		return f, nil
+	}
+	pattern, err := regexp.Compile("(?i)" + value)
+	if err != nil {
+		return ResultFilter{}, fmt.Errorf("invalid filter %q: %s", s, err)
+	}
+	f.pattern = pattern
+	return f, nil
+}
+
+// Match returns whether rec passes the filter.
+func (f ResultFilter) Match(rec scanRecord) bool {
+	matched := false
+	for _, value := range filterFields[f.field](rec) {
+		if f.pattern != nil && f.pattern.MatchString(value) || f.pattern == nil && strings.EqualFold(value, f.value) {
+			matched = true
+			break
+		}
+	}
+	return matched != f.negate
+}
+
+// matchFilters returns whether rec passes every filter.
+func matchFilters(filters []ResultFilter, rec scanRecord) bool {
+	for _, f := range filters {
+		if !f.Match(rec) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	datadog := newScanRecord("arn:aws:iam::123456789012:role/DatadogIntegrationRole", utils.Info{Exists: true, Comment: " prod -  Datadog", Tags: []string{"vendor", "monitoring"}})
+	alice := newScanRecord("arn:aws:iam::123456789012:user/alice", utils.Info{Exists: true, Comment: " prod -  admins", Plugin: "sns-1"})
+
+	tests := []struct {
+		filter  string
+		datadog bool
+		alice   bool
+	}{
+		{"comment~datadog", true, false},
+		{"comment!~datadog", false, true},
+		{"comment~^prod - +(datadog|admins)$", true, true},
+		{"type=role", true, false},
+		{"type!=role", false, true},
+		{"name=ALICE", false, true},
+		{"account=123456789012", true, true},
+		{"tag=monitoring", true, false},
+		{"tag!=monitoring", false, true},
+		{"tag~", true, false},
+		{"plugin=sns-1", false, true},
+		{"arn~:user/", false, true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.filter)
+		require.NoError(t, err, tt.filter)
+		assert.Equal(t, tt.datadog, f.Match(datadog), tt.filter)
+		assert.Equal(t, tt.alice, f.Match(alice), tt.filter)
+	}
+
+	for _, bad := range []string{"", "datadog", "=datadog", "vendor~datadog", "comment~(", "!=x"} {
+		_, err := ParseFilter(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestMatchFilters(t *testing.T) {
+	rec := newScanRecord("arn:aws:iam::123456789012:role/DatadogIntegrationRole", utils.Info{Comment: " Datadog"})
+	role, err := ParseFilter("type=role")
+	require.NoError(t, err)
+	wiz, err := ParseFilter("comment~wiz")
+	require.NoError(t, err)
+
+	assert.True(t, matchFilters(nil, rec))
+	assert.True(t, matchFilters([]ResultFilter{role}, rec))
+	assert.False(t, matchFilters([]ResultFilter{role, wiz}, rec))
+}
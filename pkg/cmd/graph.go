@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+)
+
+// graphSourceKind is added to every node of an OpenGraph export, so BloodHound can tell which nodes came from roles.
+const graphSourceKind = "Roles"
+
+const (
+	kindAccount      = "AWSAccount"
+	kindVendor       = "Vendor"
+	edgeContains     = "AWSContains"
+	edgeTrustsVendor = "TrustsVendor"
+)
+
+// principalKinds are the node kinds of principal types, other principals are AWSPrincipal nodes.
+var principalKinds = map[string]string{
+	"role":          "AWSRole",
+	"user":          "AWSUser",
+	"saml-provider": "AWSSAMLProvider",
+	"oidc-provider": "AWSOIDCProvider",
+}
+
+// graphNode and graphEdge are in the BloodHound OpenGraph format, nodes are matched by their IDs, which are ARNs for
+// accounts and principals.
+type graphNode struct {
+	ID         string         `json:"id"`
+	Kinds      []string       `json:"kinds"`
+	Properties map[string]any `json:"properties"`
+}
+
+type graphEdge struct {
+	Kind       string         `json:"kind"`
+	Start      graphEndpoint  `json:"start"`
+	End        graphEndpoint  `json:"end"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+type graphEndpoint struct {
+	Value   string `json:"value"`
+	MatchBy string `json:"match_by"`
+}
+
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+// newGraph returns the graph of the principals found in records: a node for each account and principal, an
+// AWSContains edge from each account to its principals, and a TrustsVendor edge from each role for a third party
+// integration to a node for the vendor. Records of principals that don't exist are left out.
+func newGraph(records []scanRecord) graph {
+	vendorRoles := arn.Vendors()
+	vendorNames := map[string]string{}
+	for _, vendor := range vendorRoles {
+		vendorNames[strings.ToLower(vendor)] = vendor
+	}
+
+	nodes := map[string]graphNode{}
+	var edges []graphEdge
+	addEdge := func(kind, start, end string, properties map[string]any) {
+		edges = append(edges, graphEdge{
+			Kind:       kind,
+			Start:      graphEndpoint{Value: start, MatchBy: "id"},
+			End:        graphEndpoint{Value: end, MatchBy: "id"},
+			Properties: properties,
+		})
+	}
+
+	for _, rec := range records {
+		if !rec.Exists || rec.AccountID == "" {
+			continue
+		}
+
+		rootArn := utils.GetRootArn(rec.AccountID)
+		account, ok := nodes[rootArn]
+		if !ok {
+			account = graphNode{ID: rootArn, Kinds: []string{kindAccount}, Properties: map[string]any{
+				"name":       rec.AccountID,
+				"account_id": rec.AccountID,
+			}}
+			nodes[rootArn] = account
+		}
+		if rec.Arn == rootArn {
+			setGraphProperties(account.Properties, rec)
+			continue
+		}
+
+		kind, ok := principalKinds[rec.PrincipalType]
+		if !ok {
+			kind = "AWSPrincipal"
+		}
+		node := graphNode{ID: rec.Arn, Kinds: []string{kind}, Properties: map[string]any{
+			"name":       rec.PrincipalName,
+			"arn":        rec.Arn,
+			"account_id": rec.AccountID,
+		}}
+		setGraphProperties(node.Properties, rec)
+		nodes[rec.Arn] = node
+		addEdge(edgeContains, rootArn, rec.Arn, nil)
+
+		if rec.PrincipalType != "role" {
+			continue
+		}
+		if vendor, source := graphVendor(rec, vendorRoles, vendorNames); vendor != "" {
+			vendorID := "vendor:" + strings.ToLower(vendor)
+			nodes[vendorID] = graphNode{ID: vendorID, Kinds: []string{kindVendor}, Properties: map[string]any{"name": vendor}}
+			addEdge(edgeTrustsVendor, rec.Arn, vendorID, map[string]any{"inferred_from": source})
+		}
+	}
+
+	g := graph{Nodes: []graphNode{}, Edges: []graphEdge{}}
+	for _, id := range slices.Sorted(maps.Keys(nodes)) {
+		g.Nodes = append(g.Nodes, nodes[id])
+	}
+	slices.SortFunc(edges, func(a, b graphEdge) int {
+		return strings.Compare(a.Start.Value+" "+a.Kind+" "+a.End.Value, b.Start.Value+" "+b.Kind+" "+b.End.Value)
+	})
+	g.Edges = append(g.Edges, edges...)
+	return g
+}
+
+// setGraphProperties adds the scan details of rec to a node's properties, leaving out the ones that aren't set.
+func setGraphProperties(properties map[string]any, rec scanRecord) {
+	if comment := strings.TrimSpace(rec.Comment); comment != "" {
+		properties["comment"] = comment
+	}
+	if rec.Plugin != "" {
+		properties["plugin"] = rec.Plugin
+	}
+	if firstSeen := formatTime(rec.FirstSeen); firstSeen != "" {
+		properties["first_seen"] = firstSeen
+	}
+	if lastChecked := formatTime(rec.LastChecked); lastChecked != "" {
+		properties["last_checked"] = lastChecked
+	}
+	if len(rec.Tags) > 0 {
+		properties["tags"] = rec.Tags
+	}
+	if rec.Likelihood != 0 {
+		properties["likelihood"] = rec.Likelihood
+	}
+}
+
+// graphVendor returns the vendor a role was created for and what it was inferred from. Principal comments are the
+// account's comment and the role's joined with " - ", so the role's part of the comment is used if it's the name of a
+// vendor in the built-in vendors wordlist. Otherwise the role name is looked up in the wordlist, for results that were
+// imported or scanned without their comments.
+func graphVendor(rec scanRecord, vendorRoles, vendorNames map[string]string) (vendor, source string) {
+	if vendor, ok := vendorNames[strings.ToLower(roleComment(rec.Comment))]; ok {
+		return vendor, "comment"
+	}
+	if vendor, ok := vendorRoles[path.Base(rec.PrincipalName)]; ok {
+		return vendor, "role name"
+	}
+	return "", ""
+}
+
+// writeOpenGraph writes g as a BloodHound OpenGraph file, which can be uploaded to BloodHound CE.
+func writeOpenGraph(w io.Writer, g graph) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{
+		"metadata": map[string]string{"source_kind": graphSourceKind},
+		"graph":    g,
+	})
+}
+
+// writeCypher writes g as Cypher statements for Neo4j, like cypher-shell < graph.cypher. Nodes are merged on an
+// objectid property holding their ID, so loading an export again updates the graph rather than duplicating it.
+func writeCypher(w io.Writer, g graph) error {
+	kinds := map[string]string{}
+	for _, node := range g.Nodes {
+		kinds[node.ID] = node.Kinds[0]
+		_, err := fmt.Fprintf(w, "MERGE (n:%s {objectid: %s}) SET n += %s;\n", node.Kinds[0], cypherValue(node.ID), cypherMap(node.Properties))
+		if err != nil {
+			return err
+		}
+	}
+	for _, edge := range g.Edges {
+		_, err := fmt.Fprintf(w, "MATCH (a:%s {objectid: %s}), (b:%s {objectid: %s}) MERGE (a)-[r:%s]->(b) SET r += %s;\n",
+			kinds[edge.Start.Value], cypherValue(edge.Start.Value), kinds[edge.End.Value], cypherValue(edge.End.Value),
+			edge.Kind, cypherMap(edge.Properties))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cypherMap returns properties as a Cypher map literal with its keys sorted.
+func cypherMap(properties map[string]any) string {
+	var entries []string
+	for _, key := range slices.Sorted(maps.Keys(properties)) {
+		entries = append(entries, key+": "+cypherValue(properties[key]))
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// cypherValue returns v as a Cypher literal. The JSON encodings of strings, numbers, booleans, and lists of them are
+// also valid Cypher.
+func cypherValue(v any) string {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		panic(fmt.Sprintf("encoding %v: %s", v, err))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
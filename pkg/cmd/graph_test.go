@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func graphTestRecords() []scanRecord {
+	checked := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []scanRecord{
+		newScanRecord("arn:aws:iam::111111111111:root", utils.Info{Exists: true, Comment: " prod", Plugin: "sns-1", LastChecked: checked}),
+		newScanRecord("arn:aws:iam::111111111111:role/DatadogIntegrationRole", utils.Info{Exists: true, Comment: " prod -  Datadog", Plugin: "sns-1", LastChecked: checked}),
+		newScanRecord("arn:aws:iam::111111111111:role/integrations/WizAccess-Role", utils.Info{Exists: true, Tags: []string{"cspm"}}),
+		newScanRecord("arn:aws:iam::111111111111:role/metrics", utils.Info{Exists: true, Comment: " prod -  Datadog"}),
+		newScanRecord("arn:aws:iam::111111111111:role/Admin", utils.Info{Exists: true, Comment: " prod -  Admin role"}),
+		newScanRecord("arn:aws:iam::111111111111:role/missing", utils.Info{Comment: " prod -  Datadog"}),
+		newScanRecord("arn:aws:iam::222222222222:user/alice", utils.Info{Exists: true, Likelihood: 0.5}),
+		newScanRecord("arn:aws:iam::333333333333:root", utils.Info{}),
+	}
+}
+
+func TestNewGraph(t *testing.T) {
+	g := newGraph(graphTestRecords())
+
+	var ids []string
+	kinds := map[string]string{}
+	for _, node := range g.Nodes {
+		ids = append(ids, node.ID)
+		kinds[node.ID] = node.Kinds[0]
+	}
+	assert.Equal(t, []string{
+		"arn:aws:iam::111111111111:role/Admin",
+		"arn:aws:iam::111111111111:role/DatadogIntegrationRole",
+		"arn:aws:iam::111111111111:role/integrations/WizAccess-Role",
+		"arn:aws:iam::111111111111:role/metrics",
+		"arn:aws:iam::111111111111:root",
+		"arn:aws:iam::222222222222:root",
+		"arn:aws:iam::222222222222:user/alice",
+		"vendor:datadog",
+		"vendor:wiz",
+	}, ids)
+	assert.Equal(t, "AWSAccount", kinds["arn:aws:iam::222222222222:root"])
+	assert.Equal(t, "AWSRole", kinds["arn:aws:iam::111111111111:role/Admin"])
+	assert.Equal(t, "AWSUser", kinds["arn:aws:iam::222222222222:user/alice"])
+	assert.Equal(t, "Vendor", kinds["vendor:wiz"])
+
+	assert.Equal(t, map[string]any{
+		"name":         "111111111111",
+		"account_id":   "111111111111",
+		"comment":      "prod",
+		"plugin":       "sns-1",
+		"last_checked": "2024-01-02T03:04:05Z",
+	}, g.Nodes[4].Properties)
+
+	var edges []string
+	for _, edge := range g.Edges {
+		line := edge.Start.Value + " " + edge.Kind + " " + edge.End.Value
+		if source, ok := edge.Properties["inferred_from"]; ok {
+			line += " (" + source.(string) + ")"
+		}
+		edges = append(edges, line)
+	}
+	assert.Equal(t, []string{
+		"arn:aws:iam::111111111111:role/DatadogIntegrationRole TrustsVendor vendor:datadog (comment)",
+		"arn:aws:iam::111111111111:role/integrations/WizAccess-Role TrustsVendor vendor:wiz (role name)",
+		"arn:aws:iam::111111111111:role/metrics TrustsVendor vendor:datadog (comment)",
+		"arn:aws:iam::111111111111:root AWSContains arn:aws:iam::111111111111:role/Admin",
+		"arn:aws:iam::111111111111:root AWSContains arn:aws:iam::111111111111:role/DatadogIntegrationRole",
+		"arn:aws:iam::111111111111:root AWSContains arn:aws:iam::111111111111:role/integrations/WizAccess-Role",
+		"arn:aws:iam::111111111111:root AWSContains arn:aws:iam::111111111111:role/metrics",
+		"arn:aws:iam::222222222222:root AWSContains arn:aws:iam::222222222222:user/alice",
+	}, edges)
+}
+
+func TestWriteRecords_OpenGraph(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, writeRecords(buf, "opengraph", graphTestRecords()[:2]))
+
+	var got struct {
+		Metadata struct {
+			SourceKind string `json:"source_kind"`
+		} `json:"metadata"`
+		Graph graph `json:"graph"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	assert.Equal(t, "Roles", got.Metadata.SourceKind)
+	assert.Len(t, got.Graph.Nodes, 3)
+	require.Len(t, got.Graph.Edges, 2)
+	assert.Equal(t, graphEndpoint{Value: "arn:aws:iam::111111111111:role/DatadogIntegrationRole", MatchBy: "id"}, got.Graph.Edges[0].Start)
+
+	// An empty export is still a valid graph.
+	buf.Reset()
+	require.NoError(t, writeRecords(buf, "opengraph", nil))
+	assert.Contains(t, buf.String(), `"nodes": []`)
+}
+
+func TestWriteRecords_Cypher(t *testing.T) {
+	buf := &bytes.Buffer{}
+	records := []scanRecord{
+		newScanRecord("arn:aws:iam::111111111111:role/DatadogIntegrationRole", utils.Info{Exists: true, Comment: ` "quoted" <prod>`, Tags: []string{"a", "b"}}),
+	}
+	require.NoError(t, writeRecords(buf, "cypher", records))
+
+	assert.Equal(t, []string{
+		`MERGE (n:AWSRole {objectid: "arn:aws:iam::111111111111:role/DatadogIntegrationRole"}) SET n += {account_id: "111111111111", arn: "arn:aws:iam::111111111111:role/DatadogIntegrationRole", comment: "\"quoted\" <prod>", name: "DatadogIntegrationRole", tags: ["a","b"]};`,
+		`MERGE (n:AWSAccount {objectid: "arn:aws:iam::111111111111:root"}) SET n += {account_id: "111111111111", name: "111111111111"};`,
+		`MERGE (n:Vendor {objectid: "vendor:datadog"}) SET n += {name: "Datadog"};`,
+		`MATCH (a:AWSRole {objectid: "arn:aws:iam::111111111111:role/DatadogIntegrationRole"}), (b:Vendor {objectid: "vendor:datadog"}) MERGE (a)-[r:TrustsVendor]->(b) SET r += {inferred_from: "role name"};`,
+		`MATCH (a:AWSAccount {objectid: "arn:aws:iam::111111111111:root"}), (b:AWSRole {objectid: "arn:aws:iam::111111111111:role/DatadogIntegrationRole"}) MERGE (a)-[r:AWSContains]->(b) SET r += {};`,
+	}, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+}
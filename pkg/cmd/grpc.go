@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/ryanjarv/roles/pkg/rolespb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"time"
+)
+
+// grpcServer is the gRPC API of roles serve (proto/roles/v1/roles.proto), it shares the REST API's jobs and storage.
+type grpcServer struct {
+	rolespb.UnimplementedScanServiceServer
+	s *server
+}
+
+// newGRPCServer returns a gRPC server for s, every call needs s.token as a bearer token in the authorization metadata
+// if it's set.
+func newGRPCServer(s *server) *grpc.Server {
+	g := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	rolespb.RegisterScanServiceServer(g, &grpcServer{s: s})
+	return g
+}
+
+func (s *server) authorize(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+func (g *grpcServer) SubmitScan(ctx context.Context, req *rolespb.SubmitScanRequest) (*rolespb.Scan, error) {
+	var vars map[string][]string
+	for name, values := range req.GetVars() {
+		if vars == nil {
+			vars = map[string][]string{}
+		}
+		vars[name] = values.GetValues()
+	}
+
+	job, err := g.s.submit(ctx, scanRequest{
+		Accounts:   req.GetAccounts(),
+		Roles:      req.GetRoles(),
+		Principals: req.GetPrincipals(),
+		Wordlists:  req.GetWordlists(),
+		Vars:       vars,
+		Force:      req.GetForce(),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoScan(job), nil
+}
+
+func (g *grpcServer) GetScan(ctx context.Context, req *rolespb.GetScanRequest) (*rolespb.Scan, error) {
+	job := g.s.lookup(int(req.GetId()))
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "no scan %d", req.GetId())
+	}
+	return toProtoScan(g.s.snapshot(job)), nil
+}
+
+func (g *grpcServer) ListScans(ctx context.Context, req *rolespb.ListScansRequest) (*rolespb.ListScansResponse, error) {
+	resp := &rolespb.ListScansResponse{}
+	for _, job := range g.s.list() {
+		resp.Scans = append(resp.Scans, toProtoScan(job))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) StreamResults(req *rolespb.StreamResultsRequest, stream grpc.ServerStreamingServer[rolespb.Result]) error {
+	job := g.s.lookup(int(req.GetId()))
+	if job == nil {
+		return status.Errorf(codes.NotFound, "no scan %d", req.GetId())
+	}
+	filter, err := newRecordFilter(req.GetStatus(), req.GetAccount(), "")
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = g.s.results(stream.Context(), job, filter, func(records []scanRecord) error {
+		for _, rec := range records {
+			if err := stream.Send(toProtoResult(rec)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	return err
+}
+
+func (g *grpcServer) QueryResults(req *rolespb.QueryResultsRequest, stream grpc.ServerStreamingServer[rolespb.Result]) error {
+	filter, err := newRecordFilter(req.GetStatus(), req.GetAccount(), req.GetSince())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, rec := range g.s.query(filter) {
+		if err := stream.Send(toProtoResult(rec)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcError converts an apiError to the gRPC status matching its HTTP status.
+func grpcError(err error) error {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.Unknown
+	switch apiErr.status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusForbidden:
+		code = codes.FailedPrecondition
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusServiceUnavailable:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, apiErr.message)
+}
+
+var protoStatuses = map[string]rolespb.ScanStatus{
+	jobQueued:  rolespb.ScanStatus_SCAN_STATUS_QUEUED,
+	jobRunning: rolespb.ScanStatus_SCAN_STATUS_RUNNING,
+	jobDone:    rolespb.ScanStatus_SCAN_STATUS_DONE,
+	jobFailed:  rolespb.ScanStatus_SCAN_STATUS_FAILED,
+}
+
+func toProtoScan(job scanJob) *rolespb.Scan {
+	return &rolespb.Scan{
+		Id:         int64(job.ID),
+		Status:     protoStatuses[job.Status],
+		Error:      job.Error,
+		Candidates: int64(job.Candidates),
+		Scanned:    int64(job.Scanned),
+		Found:      int64(job.Found),
+		Created:    toTimestamp(job.Created),
+		Started:    toTimestamp(job.Started),
+		Finished:   toTimestamp(job.Finished),
+	}
+}
+
+func toProtoResult(rec scanRecord) *rolespb.Result {
+	return &rolespb.Result{
+		Arn:           rec.Arn,
+		AccountId:     rec.AccountID,
+		PrincipalType: rec.PrincipalType,
+		PrincipalName: rec.PrincipalName,
+		Exists:        rec.Exists,
+		Comment:       rec.Comment,
+		Plugin:        rec.Plugin,
+		FirstSeen:     toTimestamp(rec.FirstSeen),
+		LastChecked:   toTimestamp(rec.LastChecked),
+		Tags:          rec.Tags,
+		Likelihood:    rec.Likelihood,
+	}
+}
+
+// toTimestamp returns nil for the zero time, so unset times are unset rather than year 1.
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/rolespb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, token string, scan *fakeScanner) rolespb.ScanServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	g := newGRPCServer(newTestAPI(t, token, scan))
+	go g.Serve(listener)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return rolespb.NewScanServiceClient(conn)
+}
+
+func receiveAll(t *testing.T, stream grpc.ServerStreamingClient[rolespb.Result]) []string {
+	var arns []string
+	for {
+		result, err := stream.Recv()
+		if err == io.EOF {
+			return arns
+		}
+		require.NoError(t, err)
+		arns = append(arns, result.GetArn())
+	}
+}
+
+func TestGRPCServer_Scan(t *testing.T) {
+	client := newTestGRPCClient(t, "", &fakeScanner{})
+	ctx := context.Background()
+
+	scan, err := client.SubmitScan(ctx, &rolespb.SubmitScanRequest{
+		Accounts: []string{"123456789012"},
+		Roles:    []string{"Admin", "Other-{{.Env}}"},
+		Vars:     map[string]*rolespb.VarValues{"env": {Values: []string{"dev"}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), scan.GetId())
+	assert.Equal(t, int64(3), scan.GetCandidates())
+	assert.NotNil(t, scan.GetCreated())
+	assert.Nil(t, scan.GetFinished())
+
+	// Streaming waits for the scan to finish.
+	stream, err := client.StreamResults(ctx, &rolespb.StreamResultsRequest{Id: 1, Status: "exists"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"arn:aws:iam::123456789012:root", "arn:aws:iam::123456789012:role/Admin"}, receiveAll(t, stream))
+
+	scan, err = client.GetScan(ctx, &rolespb.GetScanRequest{Id: 1})
+	require.NoError(t, err)
+	assert.Equal(t, rolespb.ScanStatus_SCAN_STATUS_DONE, scan.GetStatus())
+	assert.Equal(t, int64(2), scan.GetFound())
+
+	scans, err := client.ListScans(ctx, &rolespb.ListScansRequest{})
+	require.NoError(t, err)
+	assert.Len(t, scans.GetScans(), 1)
+
+	stream, err = client.QueryResults(ctx, &rolespb.QueryResultsRequest{Status: "not-exists"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"arn:aws:iam::123456789012:role/Other-dev"}, receiveAll(t, stream))
+}
+
+func TestGRPCServer_Errors(t *testing.T) {
+	client := newTestGRPCClient(t, "secret", &fakeScanner{})
+	ctx := context.Background()
+
+	_, err := client.ListScans(ctx, &rolespb.ListScansRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	stream, err := client.QueryResults(ctx, &rolespb.QueryResultsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	_, err = client.ListScans(ctx, &rolespb.ListScansRequest{})
+	assert.NoError(t, err)
+
+	_, err = client.GetScan(ctx, &rolespb.GetScanRequest{Id: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.SubmitScan(ctx, &rolespb.SubmitScanRequest{Wordlists: []string{"missing"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = client.QueryResults(ctx, &rolespb.QueryResultsRequest{Status: "maybe"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
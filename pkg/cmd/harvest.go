@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHubTokenEnvs are read in order for the token used to search GitHub, code search requires authentication.
+var GitHubTokenEnvs = []string{"GITHUB_TOKEN", "GH_TOKEN"}
+
+// githubMaxPages is how many pages of 100 results code search returns at most.
+const githubMaxPages = 10
+
+// githubMaxRateLimitWait is the longest harvest waits for the code search rate limit to reset before giving up.
+const githubMaxRateLimitWait = 2 * time.Minute
+
+type HarvestOpts struct {
+	// Org limits the search to repositories owned by a GitHub user or organization.
+	Org string
+	// Domain is searched for alongside IAM ARNs, like the target's email or website domain.
+	Domain string
+	// Query is added to the search as-is, in GitHub code search syntax.
+	Query string
+	// Output is the file principal names are written to, stdout if empty.
+	Output string
+	// AccountsOutput is the file account IDs from the found ARNs are written to, they aren't saved if empty.
+	AccountsOutput string
+	// MaxPages limits how many pages of results are fetched, each is one request against the search rate limit.
+	MaxPages int
+	// APIURL is the GitHub API to search, for GitHub Enterprise Server.
+	APIURL string
+
+	token  string
+	client *http.Client
+	sleep  func(time.Duration)
+}
+
+// Harvest searches GitHub code for IAM role and user names and writes them as a list for -principals, with the
+// repository and file each was found in as the comment.
+//
+// Only the matched fragments returned by the search are read, files aren't downloaded.
+func Harvest(ctx *utils.Context, opts HarvestOpts) error {
+	if opts.Org == "" && opts.Domain == "" && opts.Query == "" {
+		return fmt.Errorf("set -org, -domain, or -query to limit the search")
+	}
+	query := harvestQuery(opts)
+
+	if opts.token == "" {
+		for _, env := range GitHubTokenEnvs {
+			if opts.token = os.Getenv(env); opts.token != "" {
+				break
+			}
+		}
+		if opts.token == "" {
+			return fmt.Errorf("code search requires a token: set %s", strings.Join(GitHubTokenEnvs, " or "))
+		}
+	}
+	if opts.client == nil {
+		opts.client = http.DefaultClient
+	}
+	if opts.sleep == nil {
+		opts.sleep = time.Sleep
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = "https://api.github.com"
+	}
+	if opts.MaxPages <= 0 || opts.MaxPages > githubMaxPages {
+		opts.MaxPages = githubMaxPages
+	}
+
+	ctx.Info.Printf("searching GitHub code for: %s", query)
+
+	found := harvested{principals: map[string]string{}, accounts: map[string]string{}}
+	for page := 1; page <= opts.MaxPages; page++ {
+		results, err := searchGitHubCode(ctx, opts, query, page)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range results.Items {
+			source := item.Repository.FullName + "/" + item.Path
+			for _, match := range item.TextMatches {
+				found.add(match.Fragment, source)
+			}
+		}
+
+		if page*100 >= results.TotalCount || len(results.Items) == 0 {
+			break
+		}
+	}
+
+	if err := writeHarvested(opts.Output, found.principals); err != nil {
+		return err
+	}
+	if opts.AccountsOutput != "" {
+		if err := writeHarvested(opts.AccountsOutput, found.accounts); err != nil {
+			return err
+		}
+	}
+
+	ctx.Info.Printf("harvested %d principals and %d accounts", len(found.principals), len(found.accounts))
+	return nil
+}
+
+// harvestQuery builds the code search query, results are limited to fragments mentioning IAM ARNs or role names.
+func harvestQuery(opts HarvestOpts) string {
+	terms := []string{`"arn:aws:iam::"`}
+	if opts.Org != "" {
+		terms = append(terms, "org:"+opts.Org)
+	}
+	if opts.Domain != "" {
+		terms = append(terms, strconv.Quote(opts.Domain))
+	}
+	if opts.Query != "" {
+		terms = append(terms, opts.Query)
+	}
+	return strings.Join(terms, " ")
+}
+
+type githubCodeResults struct {
+	TotalCount int `json:"total_count"`
+	Items      []struct {
+		Path       string `json:"path"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		TextMatches []struct {
+			Fragment string `json:"fragment"`
+		} `json:"text_matches"`
+	} `json:"items"`
+}
+
+// searchGitHubCode fetches one page of search results, waiting for the rate limit to reset if it is hit.
+func searchGitHubCode(ctx *utils.Context, opts HarvestOpts, query string, page int) (*githubCodeResults, error) {
+	u := strings.TrimSuffix(opts.APIURL, "/") + "/search/code?" + url.Values{
+		"q":        {query},
+		"per_page": {"100"},
+		"page":     {strconv.Itoa(page)},
+	}.Encode()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		// text-match returns the fragments that matched the query with each result.
+		req.Header.Set("Accept", "application/vnd.github.text-match+json")
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+		resp, err := opts.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("searching GitHub: %s", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("searching GitHub: %s", err)
+		}
+
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests {
+			wait, ok := githubRateLimitWait(resp.Header, time.Now())
+			if !ok {
+				return nil, fmt.Errorf("searching GitHub: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+			}
+			ctx.Info.Printf("GitHub rate limit hit, waiting %s", wait.Round(time.Second))
+			opts.sleep(wait)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("searching GitHub: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+
+		var results githubCodeResults
+		if err := json.Unmarshal(body, &results); err != nil {
+			return nil, fmt.Errorf("parsing GitHub results: %s", err)
+		}
+		return &results, nil
+	}
+}
+
+// githubRateLimitWait returns how long to wait before retrying a rate limited request, ok is false if the response
+// wasn't rate limited or the wait is too long.
+func githubRateLimitWait(header http.Header, now time.Time) (time.Duration, bool) {
+	var wait time.Duration
+	if retryAfter, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		wait = time.Duration(retryAfter) * time.Second
+	} else if header.Get("X-RateLimit-Remaining") == "0" {
+		reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		wait = time.Unix(reset, 0).Sub(now) + time.Second
+	} else {
+		return 0, false
+	}
+
+	if wait > githubMaxRateLimitWait {
+		return 0, false
+	}
+	return max(wait, time.Second), true
+}
+
+// harvestedArnPattern matches IAM role and user ARNs in any partition.
+var harvestedArnPattern = regexp.MustCompile(`arn:aws[a-z-]*:iam::(\d{12}):((?:role|user)/[\w+=,.@/-]+)`)
+
+// harvestedRoleNamePattern matches role names assigned to common IaC properties like RoleName and role_name.
+var harvestedRoleNamePattern = regexp.MustCompile(`(?i)\brole_?name["']?\s*[:=]\s*["']([\w+=,.@-]{1,64})["']`)
+
+// harvested collects principal names and account IDs, keyed by name with the first source seen as the value.
+type harvested struct {
+	principals map[string]string
+	accounts   map[string]string
+}
+
+func (h *harvested) add(fragment, source string) {
+	addFirst := func(m map[string]string, key string) {
+		if _, ok := m[key]; !ok {
+			m[key] = source
+		}
+	}
+
+	for _, match := range harvestedArnPattern.FindAllStringSubmatch(fragment, -1) {
+		addFirst(h.accounts, match[1])
+		// Fragments are cut off mid-line, so a name ending in a separator was likely truncated.
+		principal := strings.TrimRight(match[2], "/.,-")
+		if strings.Contains(principal, "/") {
+			addFirst(h.principals, principal)
+		}
+	}
+	for _, match := range harvestedRoleNamePattern.FindAllStringSubmatch(fragment, -1) {
+		addFirst(h.principals, "role/"+match[1])
+	}
+}
+
+// writeHarvested writes a list with one entry per line and its source as the comment, to stdout if path is empty.
+func writeHarvested(path string, entries map[string]string) error {
+	var b strings.Builder
+	for _, entry := range slices.Sorted(maps.Keys(entries)) {
+		fmt.Fprintf(&b, "%s # %s\n", entry, entries[entry])
+	}
+
+	if path == "" {
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("writing %s: %s", path, err)
+	}
+	return nil
+}
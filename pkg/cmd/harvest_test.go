@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarvest(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/search/code", r.URL.Path)
+		assert.Equal(t, `"arn:aws:iam::" org:acme`, r.URL.Query().Get("q"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		if requests == 1 {
+			// The first request is rate limited and retried.
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		fmt.Fprintf(w, `{"total_count": 2, "items": [
+			{"path": "infra/main.tf", "repository": {"full_name": "acme/infra"}, "text_matches": [
+				{"fragment": "role_arn = \"arn:aws:iam::123456789012:role/ci/Deployer\"\n  role_name = \"BuildRole\""}
+			]},
+			{"path": "README.md", "repository": {"full_name": "acme/docs"}, "text_matches": [
+				{"fragment": "arn:aws:iam::210987654321:user/alice and arn:aws:iam::123456789012:role/ci/Deployer, arn:aws:iam::123456789012:role/"}
+			]}
+		]}`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var slept []time.Duration
+	err := Harvest(ctx, HarvestOpts{
+		Org:            "acme",
+		Output:         filepath.Join(dir, "principals.list"),
+		AccountsOutput: filepath.Join(dir, "accounts.list"),
+		APIURL:         server.URL,
+		token:          "token",
+		sleep:          func(d time.Duration) { slept = append(slept, d) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second}, slept)
+	assert.Equal(t, 2, requests)
+
+	principals, err := os.ReadFile(filepath.Join(dir, "principals.list"))
+	require.NoError(t, err)
+	assert.Equal(t, "role/BuildRole # acme/infra/infra/main.tf\n"+
+		"role/ci/Deployer # acme/infra/infra/main.tf\n"+
+		"user/alice # acme/docs/README.md\n", string(principals))
+
+	accounts, err := os.ReadFile(filepath.Join(dir, "accounts.list"))
+	require.NoError(t, err)
+	assert.Equal(t, "123456789012 # acme/infra/infra/main.tf\n210987654321 # acme/docs/README.md\n", string(accounts))
+}
+
+func TestHarvest_RequiresFilter(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	err := Harvest(ctx, HarvestOpts{token: "token"})
+	assert.ErrorContains(t, err, "set -org, -domain, or -query")
+}
+
+func TestGithubRateLimitWait(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "retry after", header: http.Header{"Retry-After": {"30"}}, want: 30 * time.Second, wantOk: true},
+		{name: "reset", header: http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {strconv.FormatInt(now.Unix()+10, 10)},
+		}, want: 11 * time.Second, wantOk: true},
+		{name: "too long", header: http.Header{"Retry-After": {"3600"}}},
+		{name: "not rate limited", header: http.Header{"X-Ratelimit-Remaining": {"5"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := githubRateLimitWait(tt.header, now)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
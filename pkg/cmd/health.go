@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"sort"
+	"sync"
+)
+
+// accountHealthCheck returns the configs of account's enabled regions, or why the account can't be scanned with.
+type accountHealthCheck func(ctx *utils.Context, account utils.Account) (map[string]utils.ThreadConfig, error)
+
+// checkPoolHealth returns the configs of the accounts that pass check. Accounts that fail it are logged and dropped
+// from the pool, so a broken account is reported once up front instead of failing mid-scan with errors from each of
+// its plugins.
+func checkPoolHealth(ctx *utils.Context, accounts map[string]utils.Account, check accountHealthCheck) (map[string]utils.ThreadConfig, error) {
+	cfgs := map[string]utils.ThreadConfig{}
+	var unhealthy []string
+	m := sync.Mutex{}
+
+	wg := sync.WaitGroup{}
+	for _, account := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			accountCfgs, err := check(ctx, account)
+
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				unhealthy = append(unhealthy, fmt.Sprintf("%s (%s): %s", account.AccountId, account.AccountName, err))
+				return
+			}
+			for k, cfg := range accountCfgs {
+				cfgs[k] = cfg
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(unhealthy)
+	for _, msg := range unhealthy {
+		ctx.Error.Printf("skipping unhealthy scanning account %s", msg)
+	}
+	if len(accounts) > 0 && len(unhealthy) == len(accounts) {
+		return nil, fmt.Errorf("none of the %d scanning accounts are healthy", len(accounts))
+	}
+	return cfgs, nil
+}
+
+// checkAccountHealth checks that account's role can be assumed, that it has enabled regions selection selects, and that
+// the resource of one of the registered plugins exists.
+func checkAccountHealth(ctx *utils.Context, account utils.Account, selection utils.RegionSelection, registered []pluginInfo) (map[string]utils.ThreadConfig, error) {
+	if _, err := utils.GetCallerInfo(ctx, account.Config); err != nil {
+		return nil, fmt.Errorf("assuming %s: %s", account.RoleArn, err)
+	}
+
+	cfgs, err := utils.LoadConfigs(ctx, map[string]utils.Account{account.AccountId: account}, selection)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no regions are enabled")
+	}
+
+	if err := checkPluginHealth(ctx, utils.FlattenList(loadPlugins(registered, cfgs))); err != nil {
+		return nil, err
+	}
+	return cfgs, nil
+}
+
+// checkPluginHealth checks that the resource of the first plugin that can be checked exists, without changing
+// anything. Accounts where setup didn't finish usually fail this, run -setup again to finish it.
+func checkPluginHealth(ctx *utils.Context, instances []plugins.Plugin) error {
+	for _, p := range instances {
+		checker, ok := p.(plugins.SetupChecker)
+		if !ok {
+			continue
+		}
+		if ok, err := checker.IsSetup(ctx); err != nil {
+			return fmt.Errorf("checking %s: %s", p.Name(), err)
+		} else if !ok {
+			return fmt.Errorf("%s isn't set up, run -setup to finish setting up the account", p.Name())
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPoolHealth(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	var logs bytes.Buffer
+	ctx.SetLoggingLevel(utils.ErrorLogLevel)
+	ctx.SetLogOutput(&logs)
+
+	accounts := map[string]utils.Account{
+		"111111111111": {AccountId: "111111111111", AccountName: "role-scanning-1"},
+		"222222222222": {AccountId: "222222222222", AccountName: "role-scanning-2"},
+	}
+	check := func(ctx *utils.Context, account utils.Account) (map[string]utils.ThreadConfig, error) {
+		if account.AccountId == "222222222222" {
+			return nil, errors.New("assuming role: AccessDenied")
+		}
+		return map[string]utils.ThreadConfig{
+			account.AccountId + "-us-east-1": {AccountId: account.AccountId, Region: "us-east-1"},
+		}, nil
+	}
+
+	cfgs, err := checkPoolHealth(ctx, accounts, check)
+	require.NoError(t, err)
+	assert.Len(t, cfgs, 1)
+	assert.Contains(t, cfgs, "111111111111-us-east-1")
+	assert.Equal(t, 1, strings.Count(logs.String(), "\n"), "one warning per unhealthy account")
+	assert.Contains(t, logs.String(), "skipping unhealthy scanning account 222222222222 (role-scanning-2): assuming role: AccessDenied")
+
+	delete(accounts, "111111111111")
+	_, err = checkPoolHealth(ctx, accounts, check)
+	assert.ErrorContains(t, err, "none of the 1 scanning accounts are healthy")
+
+	cfgs, err = checkPoolHealth(ctx, map[string]utils.Account{}, check)
+	require.NoError(t, err)
+	assert.Empty(t, cfgs)
+}
+
+func TestCheckPluginHealth(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	// Only the first plugin that can be checked is.
+	assert.NoError(t, checkPluginHealth(ctx, []plugins.Plugin{&setupPlugin{setup: true}, &setupPlugin{setup: false}}))
+	assert.NoError(t, checkPluginHealth(ctx, nil))
+
+	assert.ErrorContains(t, checkPluginHealth(ctx, []plugins.Plugin{&setupPlugin{setup: false}}), "fake isn't set up, run -setup")
+	assert.ErrorContains(t, checkPluginHealth(ctx, []plugins.Plugin{&setupPlugin{err: errors.New("AccessDenied")}}), "checking fake: AccessDenied")
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	awsarn "github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type ImportOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Format is quiet-riot or csv.
+	Format string
+	Paths  []string
+	// Overwrite replaces results that are already stored, by default they are kept.
+	Overwrite bool
+}
+
+// Import seeds storage with results from other enumeration tools so they aren't rescanned.
+func Import(ctx *utils.Context, opts ImportOpts) error {
+	var parse func(io.Reader) (map[string]bool, error)
+	switch opts.Format {
+	case "quiet-riot":
+		parse = parseQuietRiot
+	case "csv":
+		parse = parseResultsCSV
+	default:
+		return fmt.Errorf("unknown format %q: must be quiet-riot or csv", opts.Format)
+	}
+
+	if len(opts.Paths) == 0 {
+		return fmt.Errorf("no files to import")
+	}
+
+	results := map[string]bool{}
+	for _, path := range opts.Paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("opening %s: %s", path, err)
+		}
+		parsed, err := parse(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("parsing %s: %s", path, err)
+		}
+		for principalArn, exists := range parsed {
+			results[principalArn] = exists
+		}
+	}
+
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	now := time.Now().UTC()
+	imported, skipped := 0, 0
+	for principalArn, exists := range results {
+		key, err := scanner.NewKey(principalArn)
+		if err != nil {
+			return err
+		}
+
+		if _, status, err := storage.Get(key); err != nil {
+			return fmt.Errorf("getting %s: %s", principalArn, err)
+		} else if status != scanner.PrincipalUnknown && !opts.Overwrite {
+			skipped++
+			continue
+		}
+
+		storage.Set(key, utils.Info{
+			Exists:      exists,
+			Plugin:      "import:" + opts.Format,
+			FirstSeen:   now,
+			LastChecked: now,
+		})
+		imported++
+	}
+
+	if err := storage.Save(); err != nil {
+		return fmt.Errorf("saving storage: %s", err)
+	}
+
+	ctx.Info.Printf("imported %d results into %s, skipped %d already stored", imported, opts.Name, skipped)
+	return nil
+}
+
+var principalArnRegex = regexp.MustCompile(`arn:aws[a-z-]*:iam::\d{12}:(?:root|(?:role|user)/[\w+=,.@/-]+)`)
+
+// parseQuietRiot reads quiet-riot results, these only list principals that were found so every ARN in the file is
+// imported as existing.
+func parseQuietRiot(r io.Reader) (map[string]bool, error) {
+	results := map[string]bool{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		for _, principalArn := range principalArnRegex.FindAllString(s.Text(), -1) {
+			results[principalArn] = true
+		}
+	}
+
+	return results, s.Err()
+}
+
+// parseResultsCSV reads rows of arn,status where status is exists/not-exists, true/false, or 1/0. A header row is
+// skipped if present.
+func parseResultsCSV(r io.Reader) (map[string]bool, error) {
+	results := map[string]bool{}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(row) < 2 {
+			return nil, fmt.Errorf("line %d: expected arn,status", line)
+		}
+		principalArn, status := strings.TrimSpace(row[0]), strings.ToLower(strings.TrimSpace(row[1]))
+
+		if line == 1 && principalArn == "arn" {
+			continue
+		}
+		if _, err := awsarn.Parse(principalArn); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		switch status {
+		case "exists", "true", "1":
+			results[principalArn] = true
+		case "not-exists", "false", "0":
+			results[principalArn] = false
+		default:
+			return nil, fmt.Errorf("line %d: unknown status %q", line, status)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuietRiot(t *testing.T) {
+	got, err := parseQuietRiot(strings.NewReader("arn:aws:iam::123456789012:role/Admin\n" +
+		"Valid principal: arn:aws:iam::123456789012:user/alice.smith@example.com\n" +
+		"not an arn\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"arn:aws:iam::123456789012:role/Admin":                   true,
+		"arn:aws:iam::123456789012:user/alice.smith@example.com": true,
+	}, got)
+}
+
+func TestParseResultsCSV(t *testing.T) {
+	got, err := parseResultsCSV(strings.NewReader("arn,status\n" +
+		"arn:aws:iam::123456789012:role/a,exists\n" +
+		"arn:aws:iam::123456789012:role/b,false\n" +
+		"# comment\n" +
+		"arn:aws:iam::123456789012:root,1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"arn:aws:iam::123456789012:role/a": true,
+		"arn:aws:iam::123456789012:role/b": false,
+		"arn:aws:iam::123456789012:root":   true,
+	}, got)
+
+	_, err = parseResultsCSV(strings.NewReader("arn:aws:iam::123456789012:role/a,maybe\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown status")
+
+	_, err = parseResultsCSV(strings.NewReader("not-an-arn,true\n"))
+	require.Error(t, err)
+}
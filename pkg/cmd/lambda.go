@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LambdaRuntimeAPIEnv is set by Lambda to the address of the runtime API, roles runs as a Lambda function when it's
+// set and roles is run without any arguments.
+const LambdaRuntimeAPIEnv = "AWS_LAMBDA_RUNTIME_API"
+
+// lambdaTimeoutMargin is how long before an invocation times out its scan is stopped, to leave time for saving the
+// results scanned so far.
+const lambdaTimeoutMargin = 30 * time.Second
+
+type LambdaOpts struct {
+	Profile   string
+	Name      string
+	Storage   string
+	NotifySNS string
+	AlertAll  bool
+	RateLimit float64
+	// RateBurst is the most principals scanned at once after the function has been idle, 0 for RateLimit rounded
+	// up.
+	RateBurst     int
+	SkipRootCheck bool
+	// AssumeRole are the options the role in each scanning account is assumed with, see utils.LoadAccounts.
+	AssumeRole utils.AssumeRoleOptions
+	// Regions are the regions of the scanning accounts that are used, see utils.LoadConfigs.
+	Regions utils.RegionSelection
+}
+
+// Lambda runs scans as a Lambda function until ctx is done. Each invocation is a scan request, the same as the body of
+// POST /scans for roles serve, given directly, as the detail of an EventBridge event, or as the bodies of a batch of
+// SQS messages.
+func Lambda(ctx *utils.Context, opts LambdaOpts) error {
+	api := os.Getenv(LambdaRuntimeAPIEnv)
+	if api == "" {
+		return fmt.Errorf("%s isn't set, roles lambda only runs in a Lambda function", LambdaRuntimeAPIEnv)
+	}
+	// Nothing written to the function's file system outlives the execution environment.
+	if scheme, _, _ := strings.Cut(opts.Storage, "://"); scheme != "dynamodb" && scheme != "s3" {
+		return fmt.Errorf("storage must be dynamodb://table-name or s3://bucket/prefix in Lambda, got %q", opts.Storage)
+	}
+
+	cfg, cfgs, err := LoadScanConfigs(ctx, opts.Profile, opts.AssumeRole, opts.Regions)
+	if err != nil {
+		return err
+	}
+
+	storage, err := scanner.NewStorage(ctx, cfg, opts.Storage, opts.Name)
+	if err != nil {
+		return fmt.Errorf("new storage: %s", err)
+	}
+	defer storage.Close()
+
+	plugins := LoadAllPlugins(cfgs)
+	s := newServer(storage, opts.Name, "", opts.RateLimit, opts.RateBurst, func(force bool) resultScanner {
+		return scanner.NewScanner(&scanner.NewScannerInput{
+			Storage:       storage,
+			Force:         force,
+			Plugins:       plugins,
+			RateLimit:     opts.RateLimit,
+			RateBurst:     opts.RateBurst,
+			SkipRootCheck: opts.SkipRootCheck,
+		})
+	})
+	if opts.NotifySNS != "" {
+		if s.notifier, err = newSNSNotifier(cfg, opts.NotifySNS, time.Now().UTC()); err != nil {
+			return err
+		}
+		s.notifier.all = opts.AlertAll
+	}
+
+	ctx.Info.Printf("running %s as a Lambda function", opts.Name)
+	return s.serveLambda(ctx, newLambdaRuntime(api))
+}
+
+// serveLambda handles invocations from runtime one at a time until ctx is done.
+func (s *server) serveLambda(ctx *utils.Context, runtime *lambdaRuntime) error {
+	for {
+		inv, err := runtime.next(ctx)
+		if ctx.IsDone() {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// The scan is stopped a little before the invocation times out, so what it scanned is saved and the next
+		// invocation doesn't rescan it.
+		invCtx, cancel := ctx.WithCancel()
+		timer := time.AfterFunc(time.Until(inv.deadline.Add(-lambdaTimeoutMargin)), cancel)
+		resp, err := s.invoke(invCtx, inv.payload)
+		timer.Stop()
+		cancel()
+
+		if err != nil {
+			ctx.Error.Printf("invocation %s: %s", inv.id, err)
+			err = runtime.fail(ctx, inv.id, err)
+		} else {
+			err = runtime.respond(ctx, inv.id, resp)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// lambdaEvent is the part of an invocation's event needed to tell the events roles handles apart.
+type lambdaEvent struct {
+	Records []struct {
+		MessageID   string `json:"messageId"`
+		EventSource string `json:"eventSource"`
+		Body        string `json:"body"`
+	} `json:"Records"`
+	DetailType string          `json:"detail-type"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// lambdaBatchFailure is an SQS message that failed, only failed messages go back to the queue when the event source
+// mapping reports batch item failures.
+type lambdaBatchFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// invoke runs the scans in an event and returns the invocation's response: the finished scan, or the messages that
+// failed for a batch of SQS messages.
+func (s *server) invoke(ctx *utils.Context, payload []byte) (any, error) {
+	var event lambdaEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("parsing event: %s", err)
+	}
+
+	switch {
+	case len(event.Records) > 0:
+		failures := []lambdaBatchFailure{}
+		for _, record := range event.Records {
+			if record.EventSource != "aws:sqs" {
+				return nil, fmt.Errorf("unsupported event source %q", record.EventSource)
+			}
+			// Messages left when the invocation runs out of time are retried rather than scanned without time to.
+			if ctx.IsDone() {
+				failures = append(failures, lambdaBatchFailure{ItemIdentifier: record.MessageID})
+				continue
+			}
+			if _, err := s.scanNow(ctx, []byte(record.Body)); err != nil {
+				ctx.Error.Printf("message %s: %s", record.MessageID, err)
+				failures = append(failures, lambdaBatchFailure{ItemIdentifier: record.MessageID})
+			}
+		}
+		return map[string][]lambdaBatchFailure{"batchItemFailures": failures}, nil
+	case event.DetailType != "":
+		return s.scanNow(ctx, event.Detail)
+	default:
+		return s.scanNow(ctx, payload)
+	}
+}
+
+// scanNow runs the scan request in body right away and returns it once it's finished, rather than queuing it for
+// work like a POST /scans request.
+func (s *server) scanNow(ctx *utils.Context, body []byte) (scanJob, error) {
+	req, err := decodeScanRequest(body)
+	if err != nil {
+		return scanJob{}, err
+	}
+
+	submitted, err := s.submit(ctx, req)
+	if err != nil {
+		return scanJob{}, err
+	}
+	job := <-s.queue
+	s.run(ctx, job)
+
+	// Nothing streams the results of a function's scans, so they aren't kept around between invocations.
+	s.update(job, func() {
+		job.results = nil
+	})
+	finished := s.snapshot(job)
+	if finished.Status == jobFailed {
+		return scanJob{}, fmt.Errorf("scan %d: %s", submitted.ID, finished.Error)
+	}
+	return finished, nil
+}
+
+// lambdaRuntime is a client for the Lambda runtime API, which custom runtimes poll for invocations.
+type lambdaRuntime struct {
+	client   *http.Client
+	endpoint string
+}
+
+// lambdaInvocation is an event to handle, it has to be responded to by deadline.
+type lambdaInvocation struct {
+	id       string
+	deadline time.Time
+	payload  []byte
+}
+
+func newLambdaRuntime(api string) *lambdaRuntime {
+	return &lambdaRuntime{client: &http.Client{}, endpoint: "http://" + api + "/2018-06-01/runtime"}
+}
+
+// next waits for the next invocation.
+func (r *lambdaRuntime) next(ctx *utils.Context) (lambdaInvocation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.endpoint+"/invocation/next", nil)
+	if err != nil {
+		return lambdaInvocation{}, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return lambdaInvocation{}, fmt.Errorf("getting next invocation: %s", err)
+	}
+	defer resp.Body.Close()
+
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return lambdaInvocation{}, fmt.Errorf("reading next invocation: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return lambdaInvocation{}, fmt.Errorf("getting next invocation: %s: %s", resp.Status, strings.TrimSpace(string(payload)))
+	}
+
+	deadline := time.Now().Add(15 * time.Minute)
+	if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		deadline = time.UnixMilli(ms)
+	}
+	return lambdaInvocation{
+		id:       resp.Header.Get("Lambda-Runtime-Aws-Request-Id"),
+		deadline: deadline,
+		payload:  payload,
+	}, nil
+}
+
+func (r *lambdaRuntime) respond(ctx *utils.Context, id string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling response: %w", err)
+	}
+	return r.post(ctx, "/invocation/"+id+"/response", body, "")
+}
+
+// fail reports that the invocation failed with err, which is what async and SQS invocations retry on.
+func (r *lambdaRuntime) fail(ctx *utils.Context, id string, err error) error {
+	body, marshalErr := json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "RolesError"})
+	if marshalErr != nil {
+		return fmt.Errorf("marshaling error: %w", marshalErr)
+	}
+	return r.post(ctx, "/invocation/"+id+"/error", body, "RolesError")
+}
+
+func (r *lambdaRuntime) post(ctx *utils.Context, path string, body []byte, errorType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if errorType != "" {
+		req.Header.Set("Lambda-Runtime-Function-Error-Type", errorType)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("posting %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
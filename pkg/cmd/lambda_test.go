@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLambdaRuntime serves events as invocations in order, then closes done and waits for the client to go away.
+type fakeLambdaRuntime struct {
+	events []string
+
+	mux       sync.Mutex
+	responses map[string]string
+	errors    map[string]string
+	done      chan struct{}
+}
+
+func (f *fakeLambdaRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/2018-06-01/runtime/invocation/")
+	if path == "next" {
+		if len(f.events) == 0 {
+			close(f.done)
+			f.mux.Unlock()
+			<-r.Context().Done()
+			f.mux.Lock()
+			return
+		}
+		id := strconv.Itoa(len(f.responses) + len(f.errors) + 1)
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", id)
+		w.Header().Set("Lambda-Runtime-Deadline-Ms", strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10))
+		io.WriteString(w, f.events[0])
+		f.events = f.events[1:]
+		return
+	}
+
+	id, kind, _ := strings.Cut(path, "/")
+	body, _ := io.ReadAll(r.Body)
+	if kind == "response" {
+		f.responses[id] = string(body)
+	} else {
+		f.errors[id] = string(body)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestServer_ServeLambda(t *testing.T) {
+	runtime := &fakeLambdaRuntime{
+		events: []string{
+			`{"accounts": ["123456789012"], "roles": ["Admin"]}`,
+			`{"source": "aws.events", "detail-type": "Scheduled Event", "detail": {"accounts": ["123456789012"], "roles": ["Other"]}}`,
+			`{"Records": [{"messageId": "a", "eventSource": "aws:sqs", "body": "{\"accounts\": [\"123456789012\"], \"roles\": [\"Admin\"]}"}, {"messageId": "b", "eventSource": "aws:sqs", "body": "{\"roless\": []}"}]}`,
+			`{"roless": []}`,
+		},
+		responses: map[string]string{},
+		errors:    map[string]string{},
+		done:      make(chan struct{}),
+	}
+	ts := httptest.NewServer(runtime)
+	t.Cleanup(ts.Close)
+
+	s := newIdleTestAPI(t, "", &fakeScanner{})
+	publisher := &mockSNSPublisher{}
+	s.notifier = &snsNotifier{client: publisher, topicArn: "arn:aws:sns:us-east-1:111111111111:findings", all: true}
+	ctx, cancel := utils.NewContext(context.Background()).WithCancel()
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.serveLambda(ctx, newLambdaRuntime(strings.TrimPrefix(ts.URL, "http://")))
+	}()
+
+	select {
+	case <-runtime.done:
+	case err := <-errs:
+		t.Fatalf("serveLambda returned early: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for invocations")
+	}
+	cancel()
+	require.NoError(t, <-errs)
+
+	runtime.mux.Lock()
+	defer runtime.mux.Unlock()
+
+	var job scanJob
+	require.NoError(t, json.Unmarshal([]byte(runtime.responses["1"]), &job))
+	assert.Equal(t, jobDone, job.Status)
+	assert.Equal(t, 2, job.Candidates)
+	assert.Equal(t, 2, job.Found)
+
+	require.NoError(t, json.Unmarshal([]byte(runtime.responses["2"]), &job))
+	assert.Equal(t, 1, job.Found)
+
+	assert.JSONEq(t, `{"batchItemFailures": [{"itemIdentifier": "b"}]}`, runtime.responses["3"])
+
+	assert.Contains(t, runtime.errors["4"], "RolesError")
+	// Requests that can't be parsed never become scans.
+	assert.Len(t, s.list(), 3)
+	// Account roots are found in every scan but aren't published.
+	assert.Len(t, publisher.inputs, 2)
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+)
+
+type ListPluginsOpts struct {
+	Profile string
+	// AssumeRole are the options the role in each scanning account is assumed with, see utils.LoadAccounts.
+	AssumeRole utils.AssumeRoleOptions
+	// Regions are the regions of the scanning accounts that are used, see utils.LoadConfigs.
+	Regions utils.RegionSelection
+	// Offline only lists the registered plugins, without loading the scanning accounts or checking their setup.
+	Offline bool
+}
+
+// pluginStatus is a registered plugin and the state of its instances in the scanning accounts.
+type pluginStatus struct {
+	pluginInfo
+	principalTypes []string
+	// checked is false when the scanning accounts weren't loaded, instances and setup are unknown then.
+	checked   bool
+	instances int
+	setup     int
+}
+
+// ListPlugins lists the registered plugins, and with the scanning accounts loaded how many of their instances are set
+// up.
+func ListPlugins(ctx *utils.Context, opts ListPluginsOpts) error {
+	var cfgs map[string]utils.ThreadConfig
+	if !opts.Offline {
+		// Accounts aren't health checked, unhealthy ones show up as instances that aren't set up.
+		_, accounts, err := loadScanAccounts(ctx, opts.Profile, opts.AssumeRole)
+		if err != nil {
+			return err
+		}
+		if cfgs, err = utils.LoadConfigs(ctx, accounts, opts.Regions); err != nil {
+			return fmt.Errorf("loading configs: %s", err)
+		}
+	}
+	return writePlugins(os.Stdout, pluginStatuses(ctx, registeredPlugins, cfgs, !opts.Offline))
+}
+
+func pluginStatuses(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig, check bool) []pluginStatus {
+	var statuses []pluginStatus
+	for _, p := range registered {
+		status := pluginStatus{pluginInfo: p, principalTypes: samplePrincipalTypes(p)}
+		if check {
+			instances := p.new(cfgs, p.concurrency)
+			status.checked = true
+			status.instances = len(instances)
+			status.setup = countSetup(ctx, instances)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// samplePrincipalTypes returns the principal types p validates, from an instance created with placeholderConfigs
+// since the scanning accounts may not be loaded.
+func samplePrincipalTypes(p pluginInfo) []string {
+	if instances := p.new(placeholderConfigs(p), 1); len(instances) > 0 {
+		return plugins.PrincipalTypes(instances[0])
+	}
+	return plugins.DefaultPrincipalTypes
+}
+
+// placeholderConfigs returns an empty config for a placeholder account in a region p runs in, for creating instances
+// without loading the scanning accounts. Creating an instance doesn't make any AWS calls.
+func placeholderConfigs(p pluginInfo) map[string]utils.ThreadConfig {
+	region := "us-east-1"
+	if len(p.regions) > 0 {
+		region = p.regions[0]
+	}
+	return map[string]utils.ThreadConfig{
+		region: {AccountId: "000000000000", Region: region, Config: aws.Config{Region: region}},
+	}
+}
+
+// countSetup returns the number of instances whose resources exist, instances that can't be checked are logged and
+// not counted.
+func countSetup(ctx *utils.Context, instances []plugins.Plugin) int {
+	concurrency := make(chan int, 20)
+	wg := sync.WaitGroup{}
+	var setup atomic.Int64
+
+	for _, p := range instances {
+		checker, ok := p.(plugins.SetupChecker)
+		if !ok {
+			ctx.Debug.Printf("%s: can't check setup", p.Name())
+			continue
+		}
+
+		wg.Add(1)
+		concurrency <- 1
+		go func() {
+			defer func() {
+				<-concurrency
+				wg.Done()
+			}()
+
+			if ok, err := checker.IsSetup(ctx); err != nil {
+				ctx.Error.Printf("%s: checking setup: %s", p.Name(), err)
+			} else if ok {
+				setup.Add(1)
+			} else {
+				ctx.Debug.Printf("%s: not set up", p.Name())
+			}
+		}()
+	}
+
+	wg.Wait()
+	return int(setup.Load())
+}
+
+func writePlugins(w io.Writer, statuses []pluginStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRESOURCE\tREGIONS\tPARTITIONS\tCONCURRENCY\tPRINCIPALS\tSETUP\tENABLED")
+	for _, status := range statuses {
+		regions := "all enabled"
+		if len(status.regions) > 0 {
+			regions = strings.Join(status.regions, ",")
+		}
+
+		// A plugin is only used by scans if it has instances in the scanning accounts and they're all set up.
+		setup, enabled := "-", "-"
+		if status.checked {
+			setup = fmt.Sprintf("%d/%d", status.setup, status.instances)
+			switch {
+			case status.instances == 0:
+				enabled = "no (no supported regions)"
+			case status.setup < status.instances:
+				enabled = "no (run -setup)"
+			default:
+				enabled = "yes"
+			}
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", status.name, status.resource, regions,
+			strings.Join(status.partitions, ","), status.concurrency, strings.Join(status.principalTypes, ","), setup, enabled)
+	}
+	return tw.Flush()
+}
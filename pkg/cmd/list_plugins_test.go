@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupPlugin is a plugin that reports whether it's set up.
+type setupPlugin struct {
+	plugins.Plugin
+	setup bool
+	err   error
+}
+
+func (p *setupPlugin) Name() string                         { return "fake" }
+func (p *setupPlugin) IsSetup(*utils.Context) (bool, error) { return p.setup, p.err }
+
+func TestCountSetup(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	ctx.SetLogOutput(io.Discard)
+
+	assert.Equal(t, 2, countSetup(ctx, []plugins.Plugin{
+		&setupPlugin{setup: true},
+		&setupPlugin{setup: true},
+		&setupPlugin{setup: false},
+		&setupPlugin{err: errors.New("access denied")},
+	}))
+}
+
+func TestPluginStatuses(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	cfgs := map[string]utils.ThreadConfig{
+		"123456789012-us-east-1": {AccountId: "123456789012", Region: "us-east-1"},
+		"123456789012-us-west-2": {AccountId: "123456789012", Region: "us-west-2"},
+	}
+	registered := []pluginInfo{
+		{name: "all", resource: "Fake", partitions: []string{"aws"}, concurrency: 2, new: func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+			var result []plugins.Plugin
+			for range len(cfgs) * concurrency {
+				result = append(result, &setupPlugin{setup: true})
+			}
+			return result
+		}},
+		{name: "none", resource: "Fake", regions: []string{"eu-west-1"}, partitions: []string{"aws"}, concurrency: 1, new: func(map[string]utils.ThreadConfig, int) []plugins.Plugin {
+			return nil
+		}},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writePlugins(&buf, pluginStatuses(ctx, registered, cfgs, true)))
+	assert.Equal(t, `NAME  RESOURCE  REGIONS      PARTITIONS  CONCURRENCY  PRINCIPALS      SETUP  ENABLED
+all   Fake      all enabled  aws         2            root,role,user  4/4    yes
+none  Fake      eu-west-1    aws         1            root,role,user  0/0    no (no supported regions)
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(t, writePlugins(&buf, pluginStatuses(ctx, registered, nil, false)))
+	assert.Contains(t, buf.String(), "all   Fake      all enabled  aws         2            root,role,user  -      -")
+}
+
+func TestRegisteredPlugins(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	for _, status := range pluginStatuses(ctx, registeredPlugins, nil, false) {
+		assert.NotEmpty(t, status.principalTypes, status.name)
+	}
+}
@@ -2,38 +2,189 @@ package cmd
 
 import (
 	_ "embed"
+	"fmt"
 	"github.com/ryanjarv/roles/pkg/plugins"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"slices"
+	"strings"
+	"time"
 )
 
 //go:embed data/regions.list
 var regionsList string
 
 type Opts struct {
-	Debug          bool
-	Setup          bool
-	Org            bool
-	Profile        string
-	Name           string
-	RolesPath      string
-	PrincipalsPath string
-	AccountsPath   string
-	AccountsStr    string
-	Force          bool
-	Clean          bool
-	RateLimit      int
-	Json           bool
+	Debug         bool
+	DebugErrors   string
+	Quiet         bool
+	LogFormat     string
+	Setup         bool
+	Org           bool
+	Plan          bool
+	MaxAccounts   int
+	OrgRole       string
+	StackSet      bool
+	EmitCFN       string
+	EmitTerraform string
+	// Plugins are the names of the plugins -setup sets up and scans use, every registered plugin if empty.
+	Plugins                []string
+	Budget                 float64
+	BudgetEmail            string
+	SCP                    bool
+	Profile                string
+	AssumeRole             utils.AssumeRoleOptions
+	Regions                utils.RegionSelection
+	Name                   string
+	Storage                string
+	RolesPath              string
+	PrincipalsPath         string
+	Wordlists              string
+	Packs                  string
+	FromTerraform          string
+	FromCloudFormation     string
+	CDKQualifiers          string
+	SSOPermissionSets      string
+	SSOSuffixes            string
+	Permute                bool
+	PermutePrefixes        string
+	PermuteSuffixes        string
+	MaxExpansion           int
+	MaxCandidates          int
+	YesReally              bool
+	TryPaths               string
+	Env                    string
+	Stage                  string
+	Team                   string
+	Vars                   map[string][]string
+	VarFile                string
+	AccountsPath           string
+	AccountsStr            string
+	AccountRange           string
+	AccountStride          int
+	AccountShuffle         bool
+	ExcludeRoles           string
+	ExcludeAccounts        string
+	Force                  bool
+	Clean                  bool
+	Discover               bool
+	Validate               bool
+	RateLimit              float64
+	RateBurst              int
+	AccountRateLimit       int
+	Json                   bool
+	Output                 string
+	OutputFile             string
+	NotifySNS              string
+	AlertAll               bool
+	Elasticsearch          string
+	ElasticsearchTelemetry bool
+	OutputS3               string
+	OutputS3Interval       time.Duration
+	Sinks                  string
+	ExecOnFound            string
+	SkipRootCheck          bool
+	DryRun                 bool
+	TUI                    bool
+	LineBuffered           bool
+	Filters                []ResultFilter
 }
 
-// LoadAllPlugins loads all enabled plugins.
+// Regions returns the regions candidates using {{.Region}} are generated for.
+func Regions() map[string]utils.Info {
+	return utils.GetInputFromPath(regionsList)
+}
+
+// DefaultRegions is the -regions value for only using the regions enabled by default in every account.
+const DefaultRegions = "default"
+
+// NewRegionSelection returns the selection of regions, either DefaultRegions or region names, every enabled region is
+// used if regions is empty.
+func NewRegionSelection(regions []string) (utils.RegionSelection, error) {
+	var selection utils.RegionSelection
+	known := Regions()
+	for _, region := range regions {
+		if region = strings.TrimSpace(region); region == "" {
+			continue
+		} else if region == DefaultRegions {
+			selection.DefaultOnly = true
+		} else if _, ok := known[region]; !ok {
+			return selection, fmt.Errorf("unknown region %s", region)
+		} else {
+			selection.Regions = append(selection.Regions, region)
+		}
+	}
+	if selection.DefaultOnly && len(selection.Regions) != 0 {
+		return selection, fmt.Errorf("cannot use %s with other regions", DefaultRegions)
+	}
+	return selection, nil
+}
+
+// pluginInfo describes a registered plugin, it's listed by roles list-plugins.
+type pluginInfo struct {
+	// name is the prefix of the plugin's instance names.
+	name string
+	// resource is what the plugin updates the policy of to check a principal.
+	resource string
+	// regions are the only regions the plugin has instances in, it has one in every enabled region if empty.
+	regions []string
+	// partitions are the partitions the plugin's resource ARNs are built for.
+	partitions []string
+	// concurrency is the number of instances in each account and region.
+	concurrency int
+	// actions are the IAM actions the plugin's instances call, the -scp policy denies every other action besides
+	// scanningAccountActions.
+	actions []string
+	new     func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin
+	// stackResources returns the template resources of the instances new creates in an account and region, for
+	// -setup -stackset.
+	stackResources func(concurrency int) map[string]plugins.StackResource
+	// leftovers returns the ARNs of the resources named like the plugin's in an account and region, -clean reports
+	// any that are left after cleaning up.
+	leftovers func(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error)
+}
+
+// registeredPlugins are the plugins scans use, in the order they're loaded.
 //
 // Add new plugins here.
+var registeredPlugins = []pluginInfo{
+	{name: "ecr-public", resource: "ECR Public repository", regions: []string{"us-east-1"}, partitions: []string{"aws"}, concurrency: 1, actions: []string{"ecr-public:CreateRepository", "ecr-public:DeleteRepository", "ecr-public:DescribeRepositories", "ecr-public:SetRepositoryPolicy"}, new: plugins.NewECRPublicRepositories, stackResources: plugins.ECRPublicRepositoryStackResources, leftovers: plugins.ECRPublicRepositoryLeftovers},
+	{name: "access-point", resource: "S3 access point", partitions: []string{"aws"}, concurrency: 1, actions: []string{"s3:CreateAccessPoint", "s3:CreateBucket", "s3:DeleteAccessPoint", "s3:DeleteBucket", "s3:DeleteBucketPolicy", "s3:GetAccessPoint", "s3:ListAccessPoints", "s3:ListAllMyBuckets", "s3:ListBucket", "s3:PutAccessPointPolicy"}, new: plugins.NewAccessPoints, stackResources: plugins.AccessPointStackResources, leftovers: plugins.AccessPointLeftovers},
+	{name: "s3", resource: "S3 bucket", partitions: []string{"aws"}, concurrency: 1, actions: []string{"s3:CreateBucket", "s3:DeleteBucket", "s3:DeleteBucketPolicy", "s3:ListAllMyBuckets", "s3:ListBucket", "s3:PutBucketPolicy"}, new: plugins.NewS3Buckets, stackResources: plugins.S3BucketStackResources, leftovers: plugins.S3BucketLeftovers},
+	{name: "sns", resource: "SNS topic", partitions: []string{"aws"}, concurrency: 2, actions: []string{"sns:CreateTopic", "sns:DeleteTopic", "sns:GetTopicAttributes", "sns:ListTopics", "sns:SetTopicAttributes"}, new: plugins.NewSNSTopics, stackResources: plugins.SNSTopicStackResources, leftovers: plugins.SNSTopicLeftovers},
+	{name: "sqs", resource: "SQS queue", partitions: []string{"aws"}, concurrency: 2, actions: []string{"sqs:CreateQueue", "sqs:DeleteQueue", "sqs:GetQueueUrl", "sqs:ListQueues", "sqs:SetQueueAttributes"}, new: plugins.NewSQSQueues, stackResources: plugins.SQSQueueStackResources, leftovers: plugins.SQSQueueLeftovers},
+}
+
+// LoadAllPlugins loads all enabled plugins.
 func LoadAllPlugins(cfgs map[string]utils.ThreadConfig) [][]plugins.Plugin {
-	return [][]plugins.Plugin{
-		plugins.NewECRPublicRepositories(cfgs, 1),
-		plugins.NewAccessPoints(cfgs, 1),
-		plugins.NewS3Buckets(cfgs, 1),
-		plugins.NewSNSTopics(cfgs, 2),
-		plugins.NewSQSQueues(cfgs, 2),
+	return loadPlugins(registeredPlugins, cfgs)
+}
+
+// loadPlugins loads the instances of each plugin in registered.
+func loadPlugins(registered []pluginInfo, cfgs map[string]utils.ThreadConfig) [][]plugins.Plugin {
+	var result [][]plugins.Plugin
+	for _, p := range registered {
+		result = append(result, p.new(cfgs, p.concurrency))
+	}
+	return result
+}
+
+// selectPlugins returns the registered plugins with names in the order they're registered, or every registered plugin
+// if names is empty.
+func selectPlugins(names []string) ([]pluginInfo, error) {
+	if len(names) == 0 {
+		return registeredPlugins, nil
+	}
+
+	var selected []pluginInfo
+	for _, name := range names {
+		if !slices.ContainsFunc(registeredPlugins, func(p pluginInfo) bool { return p.name == name }) {
+			return nil, fmt.Errorf("unknown plugin %s, roles list-plugins lists the registered plugins", name)
+		}
+	}
+	for _, p := range registeredPlugins {
+		if slices.Contains(names, p.name) {
+			selected = append(selected, p)
+		}
 	}
+	return selected, nil
 }
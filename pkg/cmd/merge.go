@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
+)
+
+type MergeOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Sources are the scan names to merge into Name.
+	Sources []string
+	// SourceStorage is the backend the sources are read from, it defaults to Storage.
+	SourceStorage string
+}
+
+// Merge combines the results of several scans into one, when a principal was scanned more than once the most recently
+// checked result wins.
+func Merge(ctx *utils.Context, opts MergeOpts) error {
+	if len(opts.Sources) == 0 {
+		return fmt.Errorf("no scans to merge")
+	}
+
+	sourceStorage := opts.SourceStorage
+	if sourceStorage == "" {
+		sourceStorage = opts.Storage
+	}
+
+	for _, name := range opts.Sources {
+		if name == opts.Name && sourceStorage == opts.Storage {
+			return fmt.Errorf("cannot merge %s into itself", name)
+		}
+	}
+
+	dst, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	for _, name := range opts.Sources {
+		src, err := openStorage(ctx, opts.Profile, sourceStorage, name)
+		if err != nil {
+			return fmt.Errorf("opening %s: %s", name, err)
+		}
+
+		updated, err := mergeResults(dst, src.All())
+		src.Close()
+		if err != nil {
+			return fmt.Errorf("merging %s: %s", name, err)
+		}
+
+		ctx.Info.Printf("merged %s: %d results updated", name, updated)
+	}
+
+	if err := dst.Save(); err != nil {
+		return fmt.Errorf("saving storage: %s", err)
+	}
+
+	return nil
+}
+
+// mergeResults stores each result in dst unless dst already has a result that was checked more recently, it returns
+// the number of results stored.
+func mergeResults(dst scanner.Storage, results iter.Seq2[scanner.Key, utils.Info]) (int, error) {
+	updated := 0
+
+	for key, info := range results {
+		current, status, err := dst.Get(key)
+		if err != nil {
+			return updated, fmt.Errorf("getting %s: %s", key, err)
+		}
+
+		if status != scanner.PrincipalUnknown && !info.LastChecked.After(current.LastChecked) {
+			continue
+		}
+
+		dst.Set(key, info)
+		updated++
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeResults_NewestWins(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	dst, err := scanner.NewFileStorage(ctx, t.TempDir(), "dst", scanner.StorageOptions{})
+	require.NoError(t, err)
+	defer dst.Close()
+
+	stale := scanner.Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/stale"}
+	fresh := scanner.Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/fresh"}
+	added := scanner.Key{AccountID: "210987654321", Arn: "arn:aws:iam::210987654321:role/new"}
+
+	dst.Set(stale, utils.Info{Exists: false, LastChecked: older})
+	dst.Set(fresh, utils.Info{Exists: true, LastChecked: newer})
+
+	updated, err := mergeResults(dst, maps.All(map[scanner.Key]utils.Info{
+		stale: {Exists: true, LastChecked: newer},
+		fresh: {Exists: false, LastChecked: older},
+		added: {Exists: true, LastChecked: older},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated)
+
+	assert.Equal(t, map[scanner.Key]utils.Info{
+		stale: {Exists: true, LastChecked: newer},
+		fresh: {Exists: true, LastChecked: newer},
+		added: {Exists: true, LastChecked: older},
+	}, maps.Collect(dst.All()))
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsarn "github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"strconv"
+	"time"
+)
+
+// snsSubjectLimit is the longest subject SNS accepts, email subscriptions use it as the subject line.
+const snsSubjectLimit = 100
+
+type ISNSPublisher interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+// snsNotifier publishes new findings to an SNS topic in the scanning account, so they can be fanned out to email,
+// Lambda, or chat by subscribing to the topic.
+type snsNotifier struct {
+	client   ISNSPublisher
+	topicArn string
+	// since is when the run started, only principals first seen to exist since then are published.
+	since time.Time
+	// all publishes every principal found, including the ones stored as existing before the run.
+	all bool
+}
+
+// newSNSNotifier returns a notifier for topicArn, the client is created in the topic's region.
+func newSNSNotifier(cfg aws.Config, topicArn string, since time.Time) (*snsNotifier, error) {
+	parsed, err := awsarn.Parse(topicArn)
+	if err != nil || parsed.Service != "sns" {
+		return nil, fmt.Errorf("invalid SNS topic ARN %q", topicArn)
+	}
+
+	cfg = cfg.Copy()
+	cfg.Region = parsed.Region
+	return &snsNotifier{client: sns.NewFromConfig(cfg), topicArn: topicArn, since: since}, nil
+}
+
+// Notify publishes the result as a JSON record if it's a new finding. Results that were already known to exist before
+// the run started aren't published again unless all is set. Account roots aren't findings, they're skipped like they
+// are by -exec-on-found.
+//
+// Whether a finding is new comes from storage: FirstSeen is carried over from the stored result as long as the
+// principal still exists, so it's only since the run started for principals that weren't stored as existing.
+func (n *snsNotifier) Notify(ctx *utils.Context, principalArn string, info utils.Info) error {
+	isNew := !info.FirstSeen.Before(n.since)
+	if !info.Exists || isRootArn(principalArn) || (!isNew && !n.all) {
+		return nil
+	}
+
+	rec := newScanRecord(principalArn, info)
+	message, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshaling record for %s: %w", principalArn, err)
+	}
+
+	subject := "roles found " + principalArn
+	if len(subject) > snsSubjectLimit {
+		subject = subject[:snsSubjectLimit-3] + "..."
+	}
+
+	_, err = n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(n.topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(message)),
+		// Subscriptions can filter on the account or principal type without parsing the message.
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"account_id":     {DataType: aws.String("String"), StringValue: aws.String(rec.AccountID)},
+			"principal_type": {DataType: aws.String("String"), StringValue: aws.String(principalType(rec))},
+			"new":            {DataType: aws.String("String"), StringValue: aws.String(strconv.FormatBool(isNew))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("publishing %s to %s: %s", principalArn, n.topicArn, err)
+	}
+	return nil
+}
+
+// principalType returns the principal type of rec, root for account root ARNs.
+func principalType(rec scanRecord) string {
+	if rec.PrincipalType == "" {
+		return rec.PrincipalName
+	}
+	return rec.PrincipalType
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSNSPublisher struct {
+	inputs []*sns.PublishInput
+}
+
+func (m *mockSNSPublisher) Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	m.inputs = append(m.inputs, params)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSNSNotifier(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	client := &mockSNSPublisher{}
+	n := &snsNotifier{client: client, topicArn: "arn:aws:sns:us-west-2:111111111111:findings", since: start}
+
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:role/new", utils.Info{Exists: true, Comment: " - new", FirstSeen: start.Add(time.Minute)}))
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:role/known", utils.Info{Exists: true, FirstSeen: start.Add(-time.Hour)}))
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:role/missing", utils.Info{FirstSeen: start.Add(time.Minute)}))
+	// The account existing isn't a finding.
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:root", utils.Info{Exists: true, FirstSeen: start.Add(time.Minute)}))
+
+	require.Len(t, client.inputs, 1)
+	input := client.inputs[0]
+	assert.Equal(t, "arn:aws:sns:us-west-2:111111111111:findings", aws.ToString(input.TopicArn))
+	assert.Equal(t, "roles found arn:aws:iam::123456789012:role/new", aws.ToString(input.Subject))
+	assert.Equal(t, "123456789012", aws.ToString(input.MessageAttributes["account_id"].StringValue))
+	assert.Equal(t, "role", aws.ToString(input.MessageAttributes["principal_type"].StringValue))
+	assert.Equal(t, "true", aws.ToString(input.MessageAttributes["new"].StringValue))
+
+	var rec scanRecord
+	require.NoError(t, json.Unmarshal([]byte(aws.ToString(input.Message)), &rec))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/new", rec.Arn)
+	assert.Equal(t, " - new", rec.Comment)
+}
+
+func TestSNSNotifier_All(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	client := &mockSNSPublisher{}
+	n := &snsNotifier{client: client, topicArn: "arn:aws:sns:us-west-2:111111111111:findings", since: start, all: true}
+
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:role/new", utils.Info{Exists: true, FirstSeen: start.Add(time.Minute)}))
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:role/known", utils.Info{Exists: true, FirstSeen: start.Add(-time.Hour)}))
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:role/missing", utils.Info{FirstSeen: start.Add(time.Minute)}))
+	require.NoError(t, n.Notify(ctx, "arn:aws:iam::123456789012:root", utils.Info{Exists: true, FirstSeen: start.Add(-time.Hour)}))
+
+	require.Len(t, client.inputs, 2)
+	assert.Equal(t, "roles found arn:aws:iam::123456789012:role/new", aws.ToString(client.inputs[0].Subject))
+	assert.Equal(t, "true", aws.ToString(client.inputs[0].MessageAttributes["new"].StringValue))
+	assert.Equal(t, "roles found arn:aws:iam::123456789012:role/known", aws.ToString(client.inputs[1].Subject))
+	assert.Equal(t, "false", aws.ToString(client.inputs[1].MessageAttributes["new"].StringValue))
+}
+
+func TestSNSNotifier_LongSubject(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockSNSPublisher{}
+	n := &snsNotifier{client: client, topicArn: "arn:aws:sns:us-east-1:111111111111:findings"}
+
+	long := "arn:aws:iam::123456789012:role/" + strings.Repeat("a", 100)
+	require.NoError(t, n.Notify(ctx, long, utils.Info{Exists: true}))
+	assert.Len(t, aws.ToString(client.inputs[0].Subject), snsSubjectLimit)
+}
+
+func TestNewSNSNotifier(t *testing.T) {
+	n, err := newSNSNotifier(aws.Config{Region: "us-east-1"}, "arn:aws:sns:eu-west-1:111111111111:findings", time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:sns:eu-west-1:111111111111:findings", n.topicArn)
+
+	_, err = newSNSNotifier(aws.Config{}, "arn:aws:sqs:eu-west-1:111111111111:findings", time.Time{})
+	assert.Error(t, err)
+	_, err = newSNSNotifier(aws.Config{}, "findings", time.Time{})
+	assert.Error(t, err)
+}
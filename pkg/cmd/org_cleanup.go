@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// orgCloseRetryDelay is how long to wait before closing another account when Organizations is still busy closing the
+// last ones.
+const orgCloseRetryDelay = 30 * time.Second
+
+type OrgCleanupOpts struct {
+	Profile string
+	// AssumeRole are the options the role in each scanning account is assumed with, see utils.LoadAccounts.
+	AssumeRole utils.AssumeRoleOptions
+	// Regions are the regions of the scanning accounts that are used, see utils.LoadConfigs.
+	Regions utils.RegionSelection
+	// DryRun lists the accounts that would be closed without changing anything.
+	DryRun bool
+	// Yes closes the accounts without asking for confirmation first.
+	Yes bool
+}
+
+type IOrgAccountCloser interface {
+	ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error)
+	ListTagsForResource(ctx context.Context, params *organizations.ListTagsForResourceInput, optFns ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error)
+	CloseAccount(ctx context.Context, params *organizations.CloseAccountInput, optFns ...func(*organizations.Options)) (*organizations.CloseAccountOutput, error)
+}
+
+// OrgCleanup unwinds -setup -org: the plugin resources in each active account tagged as a role scanning account are
+// cleaned up, then the accounts are closed. Closed accounts are suspended for 90 days, during which they can be
+// reopened from the AWS console, and removed from the organization after that.
+func OrgCleanup(ctx *utils.Context, opts OrgCleanupOpts) error {
+	cfg, err := utils.LoadConfig(ctx, opts.Profile,
+		config.WithRegion("us-east-1"),
+		config.WithRetryMode(aws.RetryModeAdaptive),
+		config.WithRetryMaxAttempts(10),
+	)
+	if err != nil {
+		return fmt.Errorf("loading config: %s", err)
+	}
+
+	closer := &orgCloser{client: organizations.NewFromConfig(cfg), retryDelay: orgCloseRetryDelay}
+	members, err := closer.scanningAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		ctx.Info.Printf("no active role scanning accounts to close")
+		return nil
+	}
+	for _, member := range members {
+		ctx.Info.Printf("found role scanning account %s (%s)", aws.ToString(member.Name), aws.ToString(member.Id))
+	}
+	if opts.DryRun {
+		ctx.Info.Printf("dry run, would close %d accounts", len(members))
+		return nil
+	}
+	if !opts.Yes {
+		ok, err := confirmClose(os.Stdin, os.Stderr, len(members))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not closing the accounts, answer y or use -yes to close them")
+		}
+	}
+
+	// The plugin resources are removed first, so nothing set up for scanning is left behind if the accounts can't all
+	// be closed yet.
+	accounts, err := utils.LoadAccounts(ctx, cfg, opts.AssumeRole)
+	if err != nil {
+		return fmt.Errorf("loading accounts: %s", err)
+	}
+	closing := map[string]bool{}
+	for _, member := range members {
+		closing[aws.ToString(member.Id)] = true
+	}
+	for name, account := range accounts {
+		if !closing[account.AccountId] || name == "default" {
+			delete(accounts, name)
+		}
+	}
+	cfgs, err := utils.LoadConfigs(ctx, accounts, opts.Regions)
+	if err != nil {
+		return fmt.Errorf("loading configs: %s", err)
+	}
+	state, err := loadCallerSetupState(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("loading setup state: %s", err)
+	}
+	if state.usedStackSet() {
+		if err := RemoveStackSet(ctx, cfg, state); err != nil {
+			return fmt.Errorf("removing StackSet: %s", err)
+		}
+	}
+	if err := cleanUp(ctx, cfgs); err != nil {
+		return fmt.Errorf("cleaning up: %s", err)
+	}
+	if err := state.forgetPlugins(cfgs); err != nil {
+		return fmt.Errorf("saving setup state: %s", err)
+	}
+
+	if err := closer.closeAll(ctx, members); err != nil {
+		return err
+	}
+	// With every account closed, -setup -org needs to start over.
+	if err := state.remove(); err != nil {
+		return fmt.Errorf("removing setup state: %s", err)
+	}
+	return nil
+}
+
+// confirmClose asks whether to close the n accounts listed, only y or yes is a yes. Nothing to read, like when stdin
+// isn't a terminal, is a no.
+func confirmClose(r io.Reader, w io.Writer, n int) (bool, error) {
+	fmt.Fprintf(w, "Clean up and close these %d accounts? They stay suspended for 90 days before they're removed [y/N]: ", n)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("reading confirmation: %s", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// orgCloser closes the role scanning accounts in an organization.
+type orgCloser struct {
+	client     IOrgAccountCloser
+	retryDelay time.Duration
+}
+
+// scanningAccounts returns the active accounts in the organization that -setup -org created, which are tagged
+// role-scanning-account=true.
+func (c *orgCloser) scanningAccounts(ctx *utils.Context) ([]types.Account, error) {
+	var members []types.Account
+	paginator := organizations.NewListAccountsPaginator(c.client, &organizations.ListAccountsInput{})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing accounts: %s", err)
+		}
+		for _, account := range resp.Accounts {
+			if account.Status != types.AccountStatusActive {
+				continue
+			}
+			tags, err := c.client.ListTagsForResource(ctx, &organizations.ListTagsForResourceInput{ResourceId: account.Id})
+			if err != nil {
+				return nil, fmt.Errorf("listing tags of %s: %s", aws.ToString(account.Id), err)
+			}
+			if utils.HasTag(tags.Tags, utils.AccountTagKey, utils.AccountTagValue) {
+				members = append(members, account)
+			}
+		}
+	}
+	return members, nil
+}
+
+// closeAll closes members one at a time. Organizations only closes a few accounts at once, so closing is retried after
+// retryDelay while it's busy. It also only allows closing 10% of the member accounts in 30 days, once that's reached
+// the accounts left have to be closed by running org-cleanup again later.
+func (c *orgCloser) closeAll(ctx *utils.Context, members []types.Account) error {
+	closed := 0
+	for _, member := range members {
+		id, name := aws.ToString(member.Id), aws.ToString(member.Name)
+		for {
+			_, err := c.client.CloseAccount(ctx, &organizations.CloseAccountInput{AccountId: member.Id})
+
+			var alreadyClosed *types.AccountAlreadyClosedException
+			var throttled *types.TooManyRequestsException
+			var concurrent *types.ConcurrentModificationException
+			var constraint *types.ConstraintViolationException
+			switch {
+			case err == nil:
+				ctx.Info.Printf("closing account %s (%s)", name, id)
+				closed++
+			case errors.As(err, &alreadyClosed):
+				ctx.Info.Printf("account %s (%s) is already closed", name, id)
+			case errors.As(err, &constraint) && constraint.Reason == types.ConstraintViolationExceptionReasonCloseAccountQuotaExceeded:
+				return fmt.Errorf("closed %d of %d accounts, AWS only allows closing 10%% of an organization's member accounts in 30 days, run org-cleanup again later to close the rest", closed, len(members))
+			case errors.As(err, &throttled), errors.As(err, &concurrent),
+				errors.As(err, &constraint) && constraint.Reason == types.ConstraintViolationExceptionReasonCloseAccountRequestsLimitExceeded:
+				ctx.Info.Printf("too many accounts closing at once, waiting %s to close %s", c.retryDelay, name)
+				ctx.Sleep(c.retryDelay)
+				if ctx.IsDone() {
+					return fmt.Errorf("closed %d of %d accounts: %s", closed, len(members), ctx.Err())
+				}
+				continue
+			default:
+				return fmt.Errorf("closing account %s (%s): %s", name, id, err)
+			}
+			break
+		}
+	}
+	ctx.Info.Printf("closed %d role scanning accounts, they're removed from the organization after 90 days", closed)
+	return nil
+}
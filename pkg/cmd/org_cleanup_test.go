@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockOrgAccountCloser is an organization of accounts, closing them fails with the errors queued for each one first.
+type mockOrgAccountCloser struct {
+	accounts []types.Account
+	tagged   map[string]bool
+	errs     map[string][]error
+	closed   []string
+}
+
+func (m *mockOrgAccountCloser) ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	return &organizations.ListAccountsOutput{Accounts: m.accounts}, nil
+}
+
+func (m *mockOrgAccountCloser) ListTagsForResource(ctx context.Context, params *organizations.ListTagsForResourceInput, optFns ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error) {
+	out := &organizations.ListTagsForResourceOutput{}
+	if m.tagged[aws.ToString(params.ResourceId)] {
+		out.Tags = []types.Tag{{Key: aws.String("role-scanning-account"), Value: aws.String("true")}}
+	}
+	return out, nil
+}
+
+func (m *mockOrgAccountCloser) CloseAccount(ctx context.Context, params *organizations.CloseAccountInput, optFns ...func(*organizations.Options)) (*organizations.CloseAccountOutput, error) {
+	id := aws.ToString(params.AccountId)
+	if errs := m.errs[id]; len(errs) > 0 {
+		m.errs[id] = errs[1:]
+		return nil, errs[0]
+	}
+	m.closed = append(m.closed, id)
+	return &organizations.CloseAccountOutput{}, nil
+}
+
+func orgAccount(id string, status types.AccountStatus) types.Account {
+	return types.Account{Id: aws.String(id), Name: aws.String("role-scanning-sub-account-" + id), Status: status}
+}
+
+func TestOrgCloser_ScanningAccounts(t *testing.T) {
+	client := &mockOrgAccountCloser{
+		accounts: []types.Account{
+			orgAccount("111111111111", types.AccountStatusActive),
+			orgAccount("222222222222", types.AccountStatusActive),
+			orgAccount("333333333333", types.AccountStatusSuspended),
+		},
+		tagged: map[string]bool{"111111111111": true, "333333333333": true},
+	}
+	c := &orgCloser{client: client}
+
+	members, err := c.scanningAccounts(utils.NewContext(context.Background()))
+	require.NoError(t, err)
+	require.Len(t, members, 1, "untagged and already closed accounts are left alone")
+	assert.Equal(t, "111111111111", aws.ToString(members[0].Id))
+}
+
+func TestOrgCloser_CloseAll(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	members := []types.Account{
+		orgAccount("111111111111", types.AccountStatusActive),
+		orgAccount("222222222222", types.AccountStatusActive),
+		orgAccount("333333333333", types.AccountStatusActive),
+		orgAccount("444444444444", types.AccountStatusActive),
+	}
+	client := &mockOrgAccountCloser{errs: map[string][]error{
+		"222222222222": {
+			&types.ConstraintViolationException{Reason: types.ConstraintViolationExceptionReasonCloseAccountRequestsLimitExceeded},
+			&types.TooManyRequestsException{},
+		},
+		"333333333333": {&types.AccountAlreadyClosedException{}},
+		"444444444444": {&types.ConstraintViolationException{Reason: types.ConstraintViolationExceptionReasonCloseAccountQuotaExceeded}},
+	}}
+	c := &orgCloser{client: client}
+
+	err := c.closeAll(ctx, members)
+	assert.ErrorContains(t, err, "closed 2 of 4 accounts")
+	// Closing is retried while Organizations is busy.
+	assert.Equal(t, []string{"111111111111", "222222222222"}, client.closed)
+
+	// Running it again later closes the rest.
+	require.NoError(t, c.closeAll(ctx, members[3:]))
+	assert.Equal(t, []string{"111111111111", "222222222222", "444444444444"}, client.closed)
+}
+
+func TestConfirmClose(t *testing.T) {
+	for input, want := range map[string]bool{
+		"y\n":    true,
+		"Yes\n":  true,
+		"n\n":    false,
+		"\n":     false,
+		"":       false,
+		"yess\n": false,
+	} {
+		var out strings.Builder
+		ok, err := confirmClose(strings.NewReader(input), &out, 3)
+		require.NoError(t, err)
+		assert.Equal(t, want, ok, "%q", input)
+		assert.Contains(t, out.String(), "close these 3 accounts")
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"strconv"
+)
+
+// scanCSVHeader are the columns of -output csv. Spreadsheets and reporting templates refer to them by position, so
+// they don't change and new columns are only ever added at the end.
+var scanCSVHeader = []string{"account", "arn", "exists", "comment", "plugin", "last_checked"}
+
+// resultWriter writes the results of a scan as they're found.
+type resultWriter struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+	// arnsOnly leaves the comment out of text output, so each line is an ARN that can be piped to other tools.
+	arnsOnly bool
+}
+
+// newResultWriter returns a writer for format: text prints the ARNs that exist with their comment, json prints every
+// result as a JSON line, and csv prints every result as a scanCSVHeader row.
+func newResultWriter(w io.Writer, format string) (*resultWriter, error) {
+	rw := &resultWriter{w: w, format: format}
+	switch format {
+	case "text", "json":
+	case "csv":
+		rw.csv = csv.NewWriter(w)
+		if err := rw.csv.Write(scanCSVHeader); err != nil {
+			return nil, err
+		}
+		rw.csv.Flush()
+	default:
+		return nil, fmt.Errorf("unknown output %q: must be text, json, or csv", format)
+	}
+	return rw, nil
+}
+
+func (rw *resultWriter) Write(principalArn string, info utils.Info) error {
+	switch rw.format {
+	case "json":
+		line, err := json.Marshal(newScanRecord(principalArn, info))
+		if err != nil {
+			return fmt.Errorf("marshaling record for %s: %w", principalArn, err)
+		}
+		_, err = fmt.Fprintln(rw.w, string(line))
+		return err
+	case "csv":
+		rec := newScanRecord(principalArn, info)
+		err := rw.csv.Write([]string{
+			rec.AccountID,
+			rec.Arn,
+			strconv.FormatBool(rec.Exists),
+			rec.Comment,
+			rec.Plugin,
+			formatTime(rec.LastChecked),
+		})
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", principalArn, err)
+		}
+		// Rows are flushed as they're found so an interrupted scan still has its results.
+		rw.csv.Flush()
+		return rw.csv.Error()
+	default:
+		if !info.Exists {
+			return nil
+		} else if rw.arnsOnly {
+			_, err := fmt.Fprintln(rw.w, principalArn)
+			return err
+		}
+		_, err := fmt.Fprintln(rw.w, principalArn, "#", info.Comment)
+		return err
+	}
+}
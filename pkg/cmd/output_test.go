@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResultWriter(t *testing.T) {
+	checked := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	results := []struct {
+		arn  string
+		info utils.Info
+	}{
+		{"arn:aws:iam::123456789012:role/a", utils.Info{Exists: true, Comment: " - a, b", Plugin: "s3-0", LastChecked: checked}},
+		{"arn:aws:iam::123456789012:role/b", utils.Info{Comment: " - b"}},
+	}
+
+	for format, want := range map[string]string{
+		"text": "arn:aws:iam::123456789012:role/a #  - a, b\n",
+		"csv": `account,arn,exists,comment,plugin,last_checked
+123456789012,arn:aws:iam::123456789012:role/a,true," - a, b",s3-0,2024-01-02T03:04:05Z
+123456789012,arn:aws:iam::123456789012:role/b,false," - b",,
+`,
+	} {
+		var buf bytes.Buffer
+		rw, err := newResultWriter(&buf, format)
+		require.NoError(t, err)
+		for _, r := range results {
+			require.NoError(t, rw.Write(r.arn, r.info))
+		}
+		assert.Equal(t, want, buf.String(), format)
+	}
+
+	var buf bytes.Buffer
+	rw, err := newResultWriter(&buf, "text")
+	require.NoError(t, err)
+	rw.arnsOnly = true
+	for _, r := range results {
+		require.NoError(t, rw.Write(r.arn, r.info))
+	}
+	assert.Equal(t, "arn:aws:iam::123456789012:role/a\n", buf.String())
+
+	_, err = newResultWriter(&bytes.Buffer{}, "xml")
+	assert.Error(t, err)
+}
+
+// writeRecorder records each write, so tests can check how output is split into writes.
+type writeRecorder struct {
+	writes []string
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestResultWriter_LineBuffered(t *testing.T) {
+	for _, format := range []string{"text", "json", "csv"} {
+		w := &writeRecorder{}
+		rw, err := newResultWriter(w, format)
+		require.NoError(t, err)
+		header := len(w.writes)
+
+		// Each result is a single write of a whole line as soon as it's found, so lines piped to tee or grep are
+		// never split or held back.
+		require.NoError(t, rw.Write("arn:aws:iam::123456789012:role/a", utils.Info{Exists: true, Comment: " - a"}))
+		require.Len(t, w.writes, header+1, format)
+		assert.Regexp(t, `^[^\n]+\n$`, w.writes[header], format)
+	}
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// Packs lists the wordlist packs installed in arn.PacksDir.
+func Packs(ctx *utils.Context) error {
+	packs, err := arn.ListPacks()
+	if err != nil {
+		return err
+	}
+	if len(packs) == 0 {
+		ctx.Info.Printf("no packs in %s", arn.PacksDir)
+		return nil
+	}
+	return writePacks(os.Stdout, packs)
+}
+
+func writePacks(w io.Writer, packs []*arn.Pack) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVERSION\tCANDIDATES\tDESCRIPTION")
+	for _, pack := range packs {
+		version := pack.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", pack.Name, version, len(pack.Candidates), pack.Description)
+	}
+	return tw.Flush()
+}
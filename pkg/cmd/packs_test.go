@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePacks(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writePacks(&buf, []*arn.Pack{
+		{Name: "other"},
+		{Name: "vendor", Version: "1.2", Description: "Vendor integration roles"},
+	}))
+	assert.Equal(t, `NAME    VERSION  CANDIDATES  DESCRIPTION
+other   -        0           
+vendor  1.2      0           Vendor integration roles
+`, buf.String())
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type PreviewOpts struct {
+	Opts
+	// Count is how many candidates are printed.
+	Count int
+}
+
+// Preview expands the scan inputs in opts and prints the first candidates, how many there are in total, and about how
+// long scanning them takes at the rate limit. It doesn't make any AWS calls.
+func Preview(ctx *utils.Context, opts PreviewOpts) error {
+	if opts.Count < 0 {
+		return fmt.Errorf("count must not be negative")
+	}
+
+	input, _, err := getArnsInput(opts.Opts)
+	if err != nil {
+		return err
+	}
+	candidates, err := arn.GetArns(ctx, input)
+	if err != nil {
+		return fmt.Errorf("getting candidates: %s", err)
+	}
+
+	return writePreview(os.Stdout, candidates, opts.Count, opts.RateLimit)
+}
+
+func writePreview(w io.Writer, candidates map[string]utils.Info, count int, rateLimit float64) error {
+	// Candidates are listed in roughly the order they're scanned, account roots first and then the most likely.
+	arns := slices.SortedFunc(maps.Keys(candidates), func(a, b string) int {
+		return cmp.Or(
+			-cmp.Compare(rootRank(a), rootRank(b)),
+			-cmp.Compare(candidates[a].Likelihood, candidates[b].Likelihood),
+			strings.Compare(a, b),
+		)
+	})
+
+	accounts := 0
+	for _, principalArn := range arns {
+		if isRootArn(principalArn) {
+			accounts++
+		}
+	}
+
+	for _, principalArn := range arns[:min(count, len(arns))] {
+		if comment := candidates[principalArn].Comment; comment != "" {
+			fmt.Fprintln(w, principalArn, "#", comment)
+		} else {
+			fmt.Fprintln(w, principalArn)
+		}
+	}
+	if len(arns) > count {
+		fmt.Fprintf(w, "... and %d more\n", len(arns)-count)
+	}
+
+	_, err := fmt.Fprintf(w, "%d candidates (%d accounts, %d principals), about %s at %g per second\n",
+		len(arns), accounts, len(arns)-accounts, formatDuration(scanDuration(len(arns), rateLimit)), rateLimit)
+	return err
+}
+
+func isRootArn(principalArn string) bool {
+	return strings.HasSuffix(principalArn, ":root")
+}
+
+// rootRank is 1 for account root ARNs, for sorting them first.
+func rootRank(principalArn string) int {
+	if isRootArn(principalArn) {
+		return 1
+	}
+	return 0
+}
+
+// scanDuration is about how long scanning n principals takes at rate principals per second.
+func scanDuration(n int, rate float64) time.Duration {
+	return time.Duration(float64(n) / rate * float64(time.Second))
+}
+
+// formatDuration rounds d to a precision that's useful for an estimate and shows days for long scans.
+func formatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d >= day:
+		return fmt.Sprintf("%dd%s", d/day, (d % day).Round(time.Hour))
+	case d >= time.Hour:
+		return d.Round(time.Minute).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
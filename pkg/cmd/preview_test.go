@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePreview(t *testing.T) {
+	candidates := map[string]utils.Info{
+		"arn:aws:iam::123456789012:root":            {},
+		"arn:aws:iam::210987654321:root":            {},
+		"arn:aws:iam::123456789012:role/a":          {Comment: " - a"},
+		"arn:aws:iam::123456789012:role/b":          {},
+		"arn:aws:iam::123456789012:role/deployer":   {Comment: " - likely", Likelihood: 0.9},
+		"arn:aws:iam::210987654321:role/deployer":   {Likelihood: 0.9},
+		"arn:aws:iam::210987654321:role/unexpected": {},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePreview(&buf, candidates, 4, 2))
+	assert.Equal(t, `arn:aws:iam::123456789012:root
+arn:aws:iam::210987654321:root
+arn:aws:iam::123456789012:role/deployer #  - likely
+arn:aws:iam::210987654321:role/deployer
+... and 3 more
+7 candidates (2 accounts, 5 principals), about 4s at 2 per second
+`, buf.String())
+
+	// Rates under one a second are estimated without rounding.
+	buf.Reset()
+	require.NoError(t, writePreview(&buf, candidates, 0, 0.2))
+	assert.Equal(t, "... and 7 more\n7 candidates (2 accounts, 5 principals), about 35s at 0.2 per second\n", buf.String())
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		1500 * time.Millisecond:       "2s",
+		90*time.Minute + time.Second:  "1h30m0s",
+		50*time.Hour + 20*time.Minute: "2d2h0m0s",
+	} {
+		assert.Equal(t, want, formatDuration(d), d)
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
+	"time"
+)
+
+type PruneOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Status is one of all, exists, or not-exists.
+	Status string
+	// Account limits pruning to a single account ID.
+	Account string
+	// OlderThan limits pruning to results last checked before this time, as a duration (30d, 36h) or date
+	// (2024-01-02).
+	OlderThan string
+	// DryRun reports what would be deleted without deleting it.
+	DryRun bool
+}
+
+// Prune deletes stored results matching every filter given.
+func Prune(ctx *utils.Context, opts PruneOpts) error {
+	if (opts.Status == "" || opts.Status == "all") && opts.Account == "" && opts.OlderThan == "" {
+		return fmt.Errorf("refusing to prune every result: set -status, -account, or -older-than")
+	}
+
+	filter, err := newPruneFilter(opts.Status, opts.Account, opts.OlderThan)
+	if err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	keys := pruneKeys(storage.All(), filter)
+
+	if opts.DryRun {
+		for _, key := range keys {
+			fmt.Println(key.Arn)
+		}
+		ctx.Info.Printf("would prune %d results from %s", len(keys), opts.Name)
+		return nil
+	}
+
+	for _, key := range keys {
+		if err := storage.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	if err := storage.Save(); err != nil {
+		return fmt.Errorf("saving storage: %s", err)
+	}
+
+	ctx.Info.Printf("pruned %d results from %s", len(keys), opts.Name)
+	return nil
+}
+
+// pruneFilter selects results to delete, olderThan matches results last checked before it.
+type pruneFilter struct {
+	recordFilter
+	olderThan time.Time
+}
+
+func newPruneFilter(status, account, olderThan string) (pruneFilter, error) {
+	records, err := newRecordFilter(status, account, "")
+	if err != nil {
+		return pruneFilter{}, err
+	}
+	filter := pruneFilter{recordFilter: records}
+
+	if olderThan != "" {
+		t, err := utils.ParseSince(olderThan)
+		if err != nil {
+			return filter, fmt.Errorf("parsing older than: %s", err)
+		}
+		filter.olderThan = t
+	}
+
+	return filter, nil
+}
+
+func (f pruneFilter) Match(rec scanRecord) bool {
+	if !f.recordFilter.Match(rec) {
+		return false
+	}
+	if !f.olderThan.IsZero() && !rec.LastChecked.Before(f.olderThan) {
+		return false
+	}
+	return true
+}
+
+// pruneKeys returns the keys of the results matching filter, they are collected first so storage isn't modified while
+// it is being iterated.
+func pruneKeys(results iter.Seq2[scanner.Key, utils.Info], filter pruneFilter) []scanner.Key {
+	var keys []scanner.Key
+	for key, info := range results {
+		if filter.Match(newScanRecord(key.Arn, info)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
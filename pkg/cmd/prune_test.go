@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneKeys(t *testing.T) {
+	now := time.Now()
+	recentNegative := scanner.Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/recent"}
+	oldNegative := scanner.Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/old"}
+	oldPositive := scanner.Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/found"}
+	otherAccount := scanner.Key{AccountID: "210987654321", Arn: "arn:aws:iam::210987654321:role/old"}
+
+	stored := map[scanner.Key]utils.Info{
+		recentNegative: {Exists: false, LastChecked: now.Add(-time.Hour)},
+		oldNegative:    {Exists: false, LastChecked: now.AddDate(0, 0, -60)},
+		oldPositive:    {Exists: true, LastChecked: now.AddDate(0, 0, -60)},
+		otherAccount:   {Exists: false, LastChecked: now.AddDate(0, 0, -60)},
+	}
+
+	tests := []struct {
+		name      string
+		status    string
+		account   string
+		olderThan string
+		want      []scanner.Key
+	}{
+		{name: "old negatives", status: "not-exists", olderThan: "30d", want: []scanner.Key{oldNegative, otherAccount}},
+		{name: "account", status: "all", account: "210987654321", want: []scanner.Key{otherAccount}},
+		{name: "positives", status: "exists", want: []scanner.Key{oldPositive}},
+		{name: "account and age", account: "123456789012", olderThan: "30d", want: []scanner.Key{oldNegative, oldPositive}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newPruneFilter(tt.status, tt.account, tt.olderThan)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, pruneKeys(maps.All(stored), filter))
+		})
+	}
+}
+
+func TestPrune_RequiresFilter(t *testing.T) {
+	err := Prune(utils.NewContext(context.Background()), PruneOpts{Name: "default", Status: "all"})
+	assert.ErrorContains(t, err, "refusing to prune every result")
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	htmltemplate "html/template"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type ReportOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Format is markdown or html.
+	Format string
+	// Account limits the report to a single account ID.
+	Account string
+	// Since limits the report to principals checked after this time, as a duration (720h) or date (2024-01-02).
+	Since string
+}
+
+// otherGroup is the group of principals that weren't created for a known vendor and don't have a comment.
+const otherGroup = "Other"
+
+// report is the principals found by a scan, grouped by account and then by vendor or comment.
+type report struct {
+	Name       string
+	Generated  time.Time
+	Engagement *scanner.Engagement
+	Runs       int
+	// FirstRun and LastRun are when the first and last runs of the scan started.
+	FirstRun time.Time
+	LastRun  time.Time
+	Checked  int
+	Found    int
+	Accounts []reportAccount
+}
+
+type reportAccount struct {
+	AccountID string
+	// Comment is the account's comment from the accounts list, stored with its root ARN.
+	Comment string
+	Found   int
+	Groups  []reportGroup
+}
+
+type reportGroup struct {
+	Name       string
+	Principals []scanRecord
+}
+
+// Report writes the principals found by a scan to stdout as a Markdown or HTML report, for a pentest report appendix.
+func Report(ctx *utils.Context, opts ReportOpts) error {
+	if opts.Format != "markdown" && opts.Format != "html" {
+		return fmt.Errorf("unknown format %q: must be markdown or html", opts.Format)
+	}
+	filter, err := newRecordFilter("all", opts.Account, opts.Since)
+	if err != nil {
+		return err
+	}
+
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	md, err := storage.Metadata()
+	if err != nil {
+		return fmt.Errorf("getting metadata: %s", err)
+	}
+
+	r := newReport(opts.Name, md, storage.All(), filter, time.Now().UTC())
+	ctx.Info.Printf("reporting %d principals found in %d accounts by %s", r.Found, len(r.Accounts), opts.Name)
+	return writeReport(os.Stdout, opts.Format, r)
+}
+
+// newReport groups the existing principals in results that match filter by account, then by the vendor each one was
+// created for, or its comment if it isn't for a known vendor.
+func newReport(name string, md scanner.Metadata, results iter.Seq2[scanner.Key, utils.Info], filter recordFilter, now time.Time) report {
+	r := report{Name: name, Generated: now, Engagement: md.Engagement, Runs: len(md.Runs)}
+	if len(md.Runs) > 0 {
+		r.FirstRun, r.LastRun = md.Runs[0].Start, md.Runs[len(md.Runs)-1].Start
+	}
+
+	vendorRoles := arn.Vendors()
+	vendorNames := map[string]string{}
+	for _, vendor := range vendorRoles {
+		vendorNames[strings.ToLower(vendor)] = vendor
+	}
+
+	accounts := map[string]*reportAccount{}
+	groups := map[string]map[string][]scanRecord{}
+	for key, info := range results {
+		rec := newScanRecord(key.Arn, info)
+		if !filter.Match(rec) {
+			continue
+		}
+		r.Checked++
+		if !rec.Exists || rec.AccountID == "" {
+			continue
+		}
+
+		account, ok := accounts[rec.AccountID]
+		if !ok {
+			account = &reportAccount{AccountID: rec.AccountID}
+			accounts[rec.AccountID] = account
+			groups[rec.AccountID] = map[string][]scanRecord{}
+		}
+		if rec.Arn == utils.GetRootArn(rec.AccountID) {
+			account.Comment = strings.TrimSpace(rec.Comment)
+			continue
+		}
+
+		r.Found++
+		account.Found++
+		group, _ := graphVendor(rec, vendorRoles, vendorNames)
+		if group == "" {
+			group = roleComment(rec.Comment)
+		}
+		if group == "" {
+			group = otherGroup
+		}
+		groups[rec.AccountID][group] = append(groups[rec.AccountID][group], rec)
+	}
+
+	for _, id := range slices.Sorted(maps.Keys(accounts)) {
+		// Accounts are left out when only their root ARN was found.
+		account := accounts[id]
+		if account.Found == 0 {
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(groups[id])) {
+			principals := groups[id][name]
+			slices.SortFunc(principals, func(a, b scanRecord) int {
+				return strings.Compare(a.Arn, b.Arn)
+			})
+			account.Groups = append(account.Groups, reportGroup{Name: name, Principals: principals})
+		}
+		// Other goes last, after the named groups.
+		slices.SortStableFunc(account.Groups, func(a, b reportGroup) int {
+			switch {
+			case a.Name == otherGroup && b.Name != otherGroup:
+				return 1
+			case a.Name != otherGroup && b.Name == otherGroup:
+				return -1
+			}
+			return 0
+		})
+		r.Accounts = append(r.Accounts, *account)
+	}
+	return r
+}
+
+// roleComment returns the role's part of a principal's comment, which is the account's comment and the role's joined
+// with " - ".
+func roleComment(comment string) string {
+	if i := strings.LastIndex(comment, " - "); i >= 0 {
+		comment = comment[i+len(" - "):]
+	}
+	return strings.TrimSpace(comment)
+}
+
+var reportFuncs = map[string]any{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Format(time.DateOnly)
+	},
+	"cell": func(s string) string {
+		// Pipes end a Markdown table cell and newlines end the row.
+		return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(s)
+	},
+	"comment": func(rec scanRecord) string {
+		return strings.TrimSpace(rec.Comment)
+	},
+}
+
+var markdownReport = template.Must(template.New("report").Funcs(reportFuncs).Parse(`# IAM principals found by {{ cell .Name }}
+
+Generated {{ date .Generated }}.
+{{- with .Engagement }}{{ if .Client }} Prepared for {{ cell .Client }}.{{ end }}{{ if .Authorization }} Authorized by {{ cell .Authorization }}.{{ end }}{{ end }}
+
+| | |
+|---|---|
+| Scan | {{ cell .Name }} |
+| Runs | {{ .Runs }}{{ if .Runs }} ({{ date .FirstRun }} to {{ date .LastRun }}){{ end }} |
+| Principals checked | {{ .Checked }} |
+| Principals found | {{ .Found }} |
+| Accounts with findings | {{ len .Accounts }} |
+{{ range .Accounts }}
+## {{ .AccountID }}{{ if .Comment }} ({{ cell .Comment }}){{ end }}
+
+Principals found: {{ .Found }}.
+{{ range .Groups }}
+### {{ cell .Name }}
+
+| Principal | Type | Comment | First seen |
+|---|---|---|---|
+{{- range .Principals }}
+| ` + "`{{ cell .Arn }}`" + ` | {{ .PrincipalType }} | {{ cell (comment .) }} | {{ date .FirstSeen }} |
+{{- end }}
+{{ end }}{{ end }}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>IAM principals found by {{ .Name }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #f4f4f4; }
+code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>IAM principals found by {{ .Name }}</h1>
+<p>Generated {{ date .Generated }}.
+{{- with .Engagement }}{{ if .Client }} Prepared for {{ .Client }}.{{ end }}{{ if .Authorization }} Authorized by {{ .Authorization }}.{{ end }}{{ end }}</p>
+<table>
+<tr><th>Scan</th><td>{{ .Name }}</td></tr>
+<tr><th>Runs</th><td>{{ .Runs }}{{ if .Runs }} ({{ date .FirstRun }} to {{ date .LastRun }}){{ end }}</td></tr>
+<tr><th>Principals checked</th><td>{{ .Checked }}</td></tr>
+<tr><th>Principals found</th><td>{{ .Found }}</td></tr>
+<tr><th>Accounts with findings</th><td>{{ len .Accounts }}</td></tr>
+</table>
+{{- range .Accounts }}
+<h2>{{ .AccountID }}{{ if .Comment }} ({{ .Comment }}){{ end }}</h2>
+<p>Principals found: {{ .Found }}.</p>
+{{- range .Groups }}
+<h3>{{ .Name }}</h3>
+<table>
+<tr><th>Principal</th><th>Type</th><th>Comment</th><th>First seen</th></tr>
+{{- range .Principals }}
+<tr><td><code>{{ .Arn }}</code></td><td>{{ .PrincipalType }}</td><td>{{ comment . }}</td><td>{{ date .FirstSeen }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
+
+// writeReport writes r to w as Markdown or HTML, text in the HTML report is escaped.
+func writeReport(w io.Writer, format string, r report) error {
+	switch format {
+	case "markdown":
+		return markdownReport.Execute(w, r)
+	case "html":
+		return htmlReport.Execute(w, r)
+	default:
+		return fmt.Errorf("unknown format %q: must be markdown or html", format)
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"bytes"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reportTestResults(t *testing.T) iter.Seq2[scanner.Key, utils.Info] {
+	firstSeen := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	results := map[string]utils.Info{
+		"arn:aws:iam::111111111111:root":                             {Exists: true, Comment: " prod"},
+		"arn:aws:iam::111111111111:role/DatadogIntegrationRole":      {Exists: true, Comment: " prod -  Datadog", FirstSeen: firstSeen},
+		"arn:aws:iam::111111111111:role/integrations/WizAccess-Role": {Exists: true},
+		"arn:aws:iam::111111111111:role/Admin":                       {Exists: true, Comment: " prod -  Admin | break glass"},
+		"arn:aws:iam::111111111111:role/deploy":                      {Exists: true},
+		"arn:aws:iam::111111111111:role/missing":                     {Comment: " prod -  Datadog"},
+		"arn:aws:iam::222222222222:user/<alice>":                     {Exists: true},
+		"arn:aws:iam::333333333333:root":                             {Exists: true},
+	}
+	return func(yield func(scanner.Key, utils.Info) bool) {
+		for principalArn, info := range results {
+			key, err := scanner.NewKey(principalArn)
+			require.NoError(t, err)
+			if !yield(key, info) {
+				return
+			}
+		}
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	md := scanner.Metadata{
+		Engagement: &scanner.Engagement{Client: "Example Corp"},
+		Runs: []scanner.Run{
+			{ID: 1, Start: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{ID: 2, Start: time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	r := newReport("engagement", md, reportTestResults(t), recordFilter{}, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, 8, r.Checked)
+	assert.Equal(t, 5, r.Found)
+	assert.Equal(t, 2, r.Runs)
+	// Accounts where only the root was found aren't reported.
+	require.Len(t, r.Accounts, 2)
+	assert.Equal(t, "prod", r.Accounts[0].Comment)
+
+	var groups []string
+	for _, group := range r.Accounts[0].Groups {
+		groups = append(groups, group.Name)
+	}
+	assert.Equal(t, []string{"Admin | break glass", "Datadog", "Wiz", "Other"}, groups)
+	assert.Equal(t, "arn:aws:iam::111111111111:role/deploy", r.Accounts[0].Groups[3].Principals[0].Arn)
+
+	filtered := newReport("engagement", md, reportTestResults(t), recordFilter{account: "222222222222"}, time.Now())
+	require.Len(t, filtered.Accounts, 1)
+	assert.Equal(t, 1, filtered.Found)
+}
+
+func TestWriteReport(t *testing.T) {
+	md := scanner.Metadata{Engagement: &scanner.Engagement{Client: "Example Corp"}}
+	r := newReport("engagement", md, reportTestResults(t), recordFilter{}, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC))
+
+	var buf bytes.Buffer
+	require.NoError(t, writeReport(&buf, "markdown", r))
+	markdown := buf.String()
+	assert.True(t, strings.HasPrefix(markdown, "# IAM principals found by engagement\n\nGenerated 2024-03-04. Prepared for Example Corp.\n"), markdown)
+	assert.Contains(t, markdown, "## 111111111111 (prod)\n\nPrincipals found: 4.\n")
+	assert.Contains(t, markdown, "### Datadog\n\n| Principal | Type | Comment | First seen |\n|---|---|---|---|\n"+
+		"| `arn:aws:iam::111111111111:role/DatadogIntegrationRole` | role | prod -  Datadog | 2024-01-02 |\n")
+	// Pipes in comments would otherwise split the cell.
+	assert.Contains(t, markdown, `| prod -  Admin \| break glass | unknown |`)
+
+	buf.Reset()
+	require.NoError(t, writeReport(&buf, "html", r))
+	html := buf.String()
+	assert.Contains(t, html, "<h2>111111111111 (prod)</h2>")
+	assert.Contains(t, html, "<code>arn:aws:iam::222222222222:user/&lt;alice&gt;</code>")
+
+	assert.Error(t, writeReport(&buf, "pdf", r))
+}
@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsarn "github.com/aws/aws-sdk-go-v2/aws/arn"
@@ -9,98 +8,422 @@ import (
 	"github.com/ryanjarv/roles/pkg/arn"
 	"github.com/ryanjarv/roles/pkg/scanner"
 	"github.com/ryanjarv/roles/pkg/utils"
-	"github.com/samber/lo"
 	"strings"
+	"time"
 )
 
 type scanRecord struct {
-	Arn           string `json:"arn"`
-	AccountID     string `json:"account_id"`
-	RoleName      string `json:"role_name"`
-	PrincipalName string `json:"principal_name"`
-	PrincipalType string `json:"principal_type"`
-	Exists        bool   `json:"exists"`
-	Comment       string `json:"comment"`
+	Arn           string    `json:"arn"`
+	AccountID     string    `json:"account_id"`
+	RoleName      string    `json:"role_name"`
+	PrincipalName string    `json:"principal_name"`
+	PrincipalType string    `json:"principal_type"`
+	Exists        bool      `json:"exists"`
+	Comment       string    `json:"comment"`
+	Plugin        string    `json:"plugin"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastChecked   time.Time `json:"last_checked"`
+	Tags          []string  `json:"tags,omitempty"`
+	Likelihood    float64   `json:"likelihood,omitempty"`
+}
+
+func newScanRecord(principalArn string, info utils.Info) scanRecord {
+	rec := scanRecord{
+		Arn:         principalArn,
+		Exists:      info.Exists,
+		Comment:     info.Comment,
+		Plugin:      info.Plugin,
+		FirstSeen:   info.FirstSeen,
+		LastChecked: info.LastChecked,
+		Tags:        info.Tags,
+		Likelihood:  info.Likelihood,
+	}
+	if parsed, err := awsarn.Parse(principalArn); err == nil {
+		rec.AccountID = parsed.AccountID
+		if kind, name, ok := strings.Cut(parsed.Resource, "/"); ok {
+			rec.PrincipalType = kind
+			rec.PrincipalName = name
+			rec.RoleName = name
+		} else {
+			rec.PrincipalName = parsed.Resource
+			rec.RoleName = parsed.Resource
+		}
+	}
+	return rec
 }
 
 func Run(ctx *utils.Context, opts Opts) error {
-	cfg, err := config.LoadDefaultConfig(ctx.Context,
-		config.WithRegion("us-east-1"),
-		config.WithSharedConfigProfile(opts.Profile),
-		config.WithRetryMode(aws.RetryModeAdaptive),
-	)
-	if err != nil {
-		return fmt.Errorf("loading config: %s", err)
+	if opts.DryRun {
+		return DryRun(ctx, opts)
 	}
 
-	accounts, err := utils.LoadAccounts(ctx, cfg)
-	if err != nil {
-		return fmt.Errorf("loading accounts: %s", err)
+	output := opts.Output
+	if opts.Json {
+		if output != "" && output != "json" {
+			return fmt.Errorf("cannot use -json with -output %s", output)
+		}
+		output = "json"
+	} else if output == "" {
+		output = "text"
 	}
 
-	cfgs, err := utils.LoadConfigs(ctx, accounts)
+	registered, err := selectPlugins(opts.Plugins)
 	if err != nil {
-		return fmt.Errorf("loading configs: %s", err)
+		return err
+	}
+	cfg, cfgs, err := loadScanConfigs(ctx, opts.Profile, opts.AssumeRole, opts.Regions, registered)
+	if err != nil {
+		return err
 	}
 
-	storage, err := scanner.NewStorage(ctx, opts.Name)
+	storage, err := scanner.NewStorage(ctx, cfg, opts.Storage, opts.Name)
 	if err != nil {
 		return fmt.Errorf("new storage: %s", err)
 	}
 	defer storage.Close()
 
+	md, err := storage.Metadata()
+	if err != nil {
+		return fmt.Errorf("getting metadata: %s", err)
+	}
+	if md.Engagement != nil {
+		if err := md.Engagement.Active(time.Now()); err != nil {
+			return fmt.Errorf("refusing to scan %s: %s", opts.Name, err)
+		}
+	}
+
+	var errorLog *scanner.ErrorLog
+	if opts.DebugErrors != "" {
+		if errorLog, err = scanner.OpenErrorLog(opts.DebugErrors); err != nil {
+			return fmt.Errorf("debug errors: %s", err)
+		}
+		defer errorLog.Close()
+	}
+
+	monitor := scanner.NewMonitor()
 	scan := scanner.NewScanner(&scanner.NewScannerInput{
-		Storage:   storage,
-		Force:     opts.Force,
-		Plugins:   LoadAllPlugins(cfgs),
-		RateLimit: opts.RateLimit,
+		Storage:          storage,
+		Force:            opts.Force,
+		Plugins:          loadPlugins(registered, cfgs),
+		RateLimit:        opts.RateLimit,
+		RateBurst:        opts.RateBurst,
+		AccountRateLimit: opts.AccountRateLimit,
+		SkipRootCheck:    opts.SkipRootCheck,
+		ShuffleRoots:     opts.AccountShuffle,
+		Monitor:          monitor,
+		Errors:           errorLog,
 	})
 
-	scanData, err := arn.GetArns(ctx, &arn.GetArnsInput{
-		AccountsStr:    opts.AccountsStr,
-		AccountsPath:   opts.AccountsPath,
-		RolePaths:      splitPaths(opts.RolesPath),
-		PrincipalPaths: splitPaths(opts.PrincipalsPath),
-		Regions:        utils.GetInputFromPath(regionsList),
-	})
+	input, vars, err := getArnsInput(opts)
+	if err != nil {
+		return err
+	}
+	scanData, err := arn.GetArns(ctx, input)
 	if err != nil {
 		return fmt.Errorf("getting scanData: %s", err)
 	}
 
-	for principalArn, exists := range scan.ScanArns(ctx, lo.Keys(scanData)) {
-		if opts.Json {
-			rec := scanRecord{Arn: principalArn, Exists: exists}
-			if parsed, err := awsarn.Parse(principalArn); err == nil {
-				rec.AccountID = parsed.AccountID
-				if kind, name, ok := strings.Cut(parsed.Resource, "/"); ok {
-					rec.PrincipalType = kind
-					rec.PrincipalName = name
-					rec.RoleName = name
-				} else {
-					rec.PrincipalName = parsed.Resource
-					rec.RoleName = parsed.Resource
-				}
+	var hook *execHook
+	if opts.ExecOnFound != "" {
+		if hook, err = newExecHook(opts.ExecOnFound); err != nil {
+			return err
+		}
+	}
+
+	start := time.Now().UTC()
+	var notifier *snsNotifier
+	if opts.NotifySNS != "" {
+		if notifier, err = newSNSNotifier(cfg, opts.NotifySNS, start); err != nil {
+			return err
+		}
+		notifier.all = opts.AlertAll
+	}
+
+	run := scanner.Run{
+		Start:      start,
+		Options:    runOptions(opts, vars),
+		Candidates: len(scanData),
+	}
+	err = storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		run.ID = md.StartRun(run)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("recording run: %s", err)
+	}
+
+	sinks, err := newRunSinks(ctx, opts, sinkOptions{
+		cfg:       cfg,
+		name:      opts.Name,
+		run:       run,
+		format:    output,
+		arnsOnly:  opts.Quiet,
+		hold:      opts.TUI,
+		interval:  opts.OutputS3Interval,
+		telemetry: opts.ElasticsearchTelemetry,
+		monitor:   monitor,
+	})
+	if err != nil {
+		return err
+	}
+	defer sinks.Abort()
+	stopFlushing := sinks.FlushEvery(ctx, sinkFlushInterval)
+	defer stopFlushing()
+
+	scanCtx, cancel := ctx.WithCancel()
+	defer cancel()
+
+	var ui *tui
+	stopUI := func() {}
+	if opts.TUI {
+		ui = newTUI(monitor, opts.Name, len(scanData), scan.Plugins)
+		if stopUI, err = ui.Start(scanCtx, cancel); err != nil {
+			return err
+		}
+		defer stopUI()
+		if hook != nil {
+			hook.output = ui
+		}
+	}
+
+	findings := 0
+	for principalArn, info := range scan.ScanArns(scanCtx, scanData) {
+		// Stopping from the terminal UI ends the scan early, what was scanned so far is still saved.
+		if scanCtx.IsDone() {
+			break
+		}
+		if info.Exists {
+			findings++
+		}
+		if ui != nil {
+			ui.Result(principalArn, info)
+		}
+		// Filters only change what's output, every result is still stored.
+		if matchFilters(opts.Filters, newScanRecord(principalArn, info)) {
+			if err := sinks.Write(ctx, Finding{Arn: principalArn, Info: info}); err != nil {
+				return err
 			}
-			if info, ok := scanData[principalArn]; ok {
-				rec.Comment = info.Comment
+		}
+		if hook != nil && info.Exists && !isRootArn(principalArn) {
+			if err := hook.Run(ctx, principalArn); err != nil {
+				ctx.Error.Printf("%s", err)
 			}
-			line, err := json.Marshal(rec)
-			if err != nil {
-				return fmt.Errorf("marshaling record for %s: %w", principalArn, err)
+		}
+		if notifier != nil {
+			// A notification failing shouldn't stop the scan, the finding is still stored and printed.
+			if err := notifier.Notify(ctx, principalArn, info); err != nil {
+				ctx.Error.Printf("notifying: %s", err)
 			}
-			fmt.Println(string(line))
-		} else if exists {
-			fmt.Println(principalArn, "#", scanData[principalArn].Comment)
 		}
 	}
 
+	stopUI()
+	stopFlushing()
+
 	if err := storage.Save(); err != nil {
 		return fmt.Errorf("saving storage: %s", err)
 	}
 
+	err = storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		if run := md.Run(run.ID); run != nil {
+			run.End = time.Now().UTC()
+			run.Findings = findings
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("recording run: %s", err)
+	}
+
+	// The results are stored by now, a sink failing to finish only makes the scan exit with an error.
+	if err := sinks.Close(ctx); err != nil {
+		return err
+	}
+
+	ctx.Info.Printf("run %d of %s finished, %d of %d candidates found", run.ID, opts.Name, findings, len(scanData))
 	return nil
 }
 
+// newRunSinks returns the sinks for opts: the -sinks, -output-s3, and -elasticsearch sinks, and results are printed to
+// stdout or written to -o unless a stdout or file sink is given.
+func newRunSinks(ctx *utils.Context, opts Opts, sinkOpts sinkOptions) (sinkSet, error) {
+	uris := splitPaths(opts.Sinks)
+	local, stdout := false, false
+	for _, uri := range uris {
+		if uri == "stdout" || strings.HasPrefix(uri, "stdout?") {
+			local, stdout = true, true
+		} else if strings.HasPrefix(uri, "file://") {
+			local = true
+		}
+	}
+	if opts.OutputFile != "" {
+		uris = append(uris, "file://"+opts.OutputFile)
+	} else if !local {
+		uris = append(uris, "stdout")
+		stdout = true
+	}
+	if opts.OutputS3 != "" {
+		uris = append(uris, opts.OutputS3)
+	}
+	if opts.Elasticsearch != "" {
+		uris = append(uris, "elasticsearch+"+opts.Elasticsearch)
+	}
+	if opts.LineBuffered && !stdout {
+		return nil, fmt.Errorf("-line-buffered needs results on stdout, add stdout to -sinks")
+	}
+
+	var sinks sinkSet
+	for _, uri := range uris {
+		sink, err := newSink(ctx, uri, sinkOpts)
+		if err != nil {
+			sinks.Abort()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// LoadScanConfigs loads the config for profile and a config for each account and region the plugins run in, the role
+// of each account is assumed with assumeRole and only the regions in regions are used. Accounts that fail the pool
+// health check are left out.
+func LoadScanConfigs(ctx *utils.Context, profile string, assumeRole utils.AssumeRoleOptions, regions utils.RegionSelection) (aws.Config, map[string]utils.ThreadConfig, error) {
+	return loadScanConfigs(ctx, profile, assumeRole, regions, registeredPlugins)
+}
+
+// loadScanConfigs is LoadScanConfigs with the pool health checked with the registered plugins.
+func loadScanConfigs(ctx *utils.Context, profile string, assumeRole utils.AssumeRoleOptions, regions utils.RegionSelection, registered []pluginInfo) (aws.Config, map[string]utils.ThreadConfig, error) {
+	cfg, accounts, err := loadScanAccounts(ctx, profile, assumeRole)
+	if err != nil {
+		return aws.Config{}, nil, err
+	}
+
+	cfgs, err := checkPoolHealth(ctx, accounts, func(ctx *utils.Context, account utils.Account) (map[string]utils.ThreadConfig, error) {
+		return checkAccountHealth(ctx, account, regions, registered)
+	})
+	if err != nil {
+		return aws.Config{}, nil, fmt.Errorf("checking account pool: %s", err)
+	}
+	return cfg, cfgs, nil
+}
+
+// loadScanAccounts loads the config for profile and the scanning accounts, with their roles assumed with assumeRole.
+func loadScanAccounts(ctx *utils.Context, profile string, assumeRole utils.AssumeRoleOptions) (aws.Config, map[string]utils.Account, error) {
+	cfg, err := utils.LoadConfig(ctx, profile,
+		config.WithRegion("us-east-1"),
+		config.WithRetryMode(aws.RetryModeAdaptive),
+	)
+	if err != nil {
+		return aws.Config{}, nil, fmt.Errorf("loading config: %s", err)
+	}
+
+	accounts, err := utils.LoadAccounts(ctx, cfg, assumeRole)
+	if err != nil {
+		return aws.Config{}, nil, fmt.Errorf("loading accounts: %s", err)
+	}
+	return cfg, accounts, nil
+}
+
+// getArnsInput returns the candidate inputs opts selects, along with the template variables used.
+func getArnsInput(opts Opts) (*arn.GetArnsInput, map[string][]string, error) {
+	var accountRange *arn.AccountRange
+	if opts.AccountRange != "" {
+		var err error
+		if accountRange, err = arn.ParseAccountRange(opts.AccountRange, opts.AccountStride); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	vars, err := templateVars(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading template variables: %s", err)
+	}
+
+	excludes, err := arn.LoadExcludes(splitPaths(opts.ExcludeAccounts), splitPaths(opts.ExcludeRoles))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	maxCandidates := opts.MaxCandidates
+	if opts.YesReally {
+		maxCandidates = 0
+	}
+
+	return &arn.GetArnsInput{
+		AccountsStr:         opts.AccountsStr,
+		AccountsPath:        opts.AccountsPath,
+		AccountRange:        accountRange,
+		RolePaths:           splitPaths(opts.RolesPath),
+		PrincipalPaths:      splitPaths(opts.PrincipalsPath),
+		Wordlists:           splitPaths(opts.Wordlists),
+		Packs:               splitPaths(opts.Packs),
+		TerraformPaths:      splitPaths(opts.FromTerraform),
+		CloudFormationPaths: splitPaths(opts.FromCloudFormation),
+		CDKQualifiers:       splitPaths(opts.CDKQualifiers),
+		SSOPermissionSets:   splitPaths(opts.SSOPermissionSets),
+		SSOSuffixes:         splitPaths(opts.SSOSuffixes),
+		Permute:             opts.Permute,
+		PermutePrefixes:     splitPaths(opts.PermutePrefixes),
+		PermuteSuffixes:     splitPaths(opts.PermuteSuffixes),
+		MaxExpansion:        opts.MaxExpansion,
+		MaxCandidates:       maxCandidates,
+		TryPaths:            arn.ParseRolePaths(opts.TryPaths),
+		Vars:                vars,
+		Excludes:            excludes,
+		Regions:             Regions(),
+	}, vars, nil
+}
+
+// templateVars combines -var-file, -var, and the -env, -stage, and -team shortcuts.
+func templateVars(opts Opts) (map[string][]string, error) {
+	vars := map[string][]string{}
+	if opts.VarFile != "" {
+		loaded, err := arn.LoadVarFile(opts.VarFile)
+		if err != nil {
+			return nil, err
+		}
+		vars = loaded
+	}
+
+	for name, values := range opts.Vars {
+		vars[name] = append(vars[name], values...)
+	}
+	for name, value := range map[string]string{"env": opts.Env, "stage": opts.Stage, "team": opts.Team} {
+		if value != "" {
+			vars[name] = append(vars[name], value)
+		}
+	}
+	return vars, nil
+}
+
+func runOptions(opts Opts, vars map[string][]string) scanner.RunOptions {
+	return scanner.RunOptions{
+		Accounts:           opts.AccountsStr,
+		AccountsPath:       opts.AccountsPath,
+		AccountRange:       opts.AccountRange,
+		AccountStride:      opts.AccountStride,
+		RolesPath:          opts.RolesPath,
+		PrincipalPath:      opts.PrincipalsPath,
+		Wordlists:          opts.Wordlists,
+		Packs:              opts.Packs,
+		FromTerraform:      opts.FromTerraform,
+		FromCloudFormation: opts.FromCloudFormation,
+		CDKQualifiers:      opts.CDKQualifiers,
+		SSOPermissionSets:  opts.SSOPermissionSets,
+		SSOSuffixes:        opts.SSOSuffixes,
+		Permute:            opts.Permute,
+		ExcludeRoles:       opts.ExcludeRoles,
+		ExcludeAccounts:    opts.ExcludeAccounts,
+		TryPaths:           opts.TryPaths,
+		Vars:               vars,
+		VarFile:            opts.VarFile,
+		Force:              opts.Force,
+		SkipRootCheck:      opts.SkipRootCheck,
+		RateLimit:          opts.RateLimit,
+		RateBurst:          opts.RateBurst,
+	}
+}
+
 func splitPaths(value string) []string {
 	var result []string
 	for _, path := range strings.Split(value, ",") {
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// scheduledScan is a scan roles serve submits on a cron schedule.
+type scheduledScan struct {
+	Name string `yaml:"name"`
+	Cron string `yaml:"cron"`
+	// Timezone is the IANA time zone the cron expression is in, the server's local time zone if it's empty.
+	Timezone    string `yaml:"timezone"`
+	scanRequest `yaml:",inline"`
+
+	cron     *utils.Cron
+	location *time.Location
+}
+
+// loadSchedules reads the scheduled scans in a YAML or JSON file, for example:
+//
+//	scans:
+//	  - name: vendors
+//	    cron: "0 6 * * 1"
+//	    accounts: [123456789012, 210987654321]
+//	    wordlists: [vendors]
+//	  - name: own-accounts
+//	    cron: "@daily"
+//	    timezone: America/New_York
+//	    accounts: [111111111111]
+//	    roles: [Admin, OrganizationAccountAccessRole]
+//
+// The lists are the same as in a POST /scans request.
+func loadSchedules(path string) ([]scheduledScan, error) {
+	path, err := utils.ExpandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schedules: %s", err)
+	}
+
+	var file struct {
+		Scans []scheduledScan `yaml:"scans"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parsing schedules in %s: %s", path, err)
+	}
+	if len(file.Scans) == 0 {
+		return nil, fmt.Errorf("no scans in %s", path)
+	}
+
+	names := map[string]bool{}
+	for i := range file.Scans {
+		sched := &file.Scans[i]
+		if sched.Name == "" {
+			return nil, fmt.Errorf("scheduled scan %d in %s has no name", i+1, path)
+		}
+		if names[sched.Name] {
+			return nil, fmt.Errorf("more than one scheduled scan is named %s in %s", sched.Name, path)
+		}
+		names[sched.Name] = true
+
+		if sched.cron, err = utils.ParseCron(sched.Cron); err != nil {
+			return nil, fmt.Errorf("scheduled scan %s: %s", sched.Name, err)
+		}
+		sched.location = time.Local
+		if sched.Timezone != "" {
+			if sched.location, err = time.LoadLocation(sched.Timezone); err != nil {
+				return nil, fmt.Errorf("scheduled scan %s: invalid timezone %q: %s", sched.Name, sched.Timezone, err)
+			}
+		}
+		sched.schedule = sched.Name
+	}
+	return file.Scans, nil
+}
+
+// schedule submits sched each time its cron expression matches until ctx is done.
+func (s *server) schedule(ctx *utils.Context, sched scheduledScan) {
+	for {
+		next := sched.cron.Next(time.Now().In(sched.location))
+		if next.IsZero() {
+			ctx.Error.Printf("scheduled scan %s: %q never matches", sched.Name, sched.Cron)
+			return
+		}
+		ctx.Info.Printf("scheduled scan %s runs next at %s", sched.Name, next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.submitScheduled(ctx, sched)
+	}
+}
+
+// submitScheduled queues a scan for sched, unless the last one it submitted hasn't finished yet.
+func (s *server) submitScheduled(ctx *utils.Context, sched scheduledScan) {
+	s.mux.Lock()
+	var pending *scanJob
+	for _, job := range s.jobs {
+		if job.Schedule == sched.Name && (job.Status == jobQueued || job.Status == jobRunning) {
+			pending = job
+		}
+	}
+	s.mux.Unlock()
+	if pending != nil {
+		ctx.Info.Printf("skipping scheduled scan %s, scan %d from its last run hasn't finished", sched.Name, pending.ID)
+		return
+	}
+
+	job, err := s.submit(ctx, sched.scanRequest)
+	if err != nil {
+		ctx.Error.Printf("scheduled scan %s: %s", sched.Name, err)
+		return
+	}
+	ctx.Info.Printf("submitted scheduled scan %s as scan %d with %d candidates", sched.Name, job.ID, job.Candidates)
+}
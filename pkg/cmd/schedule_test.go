@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSchedules(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "schedules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadSchedules(t *testing.T) {
+	path := writeSchedules(t, `
+scans:
+  - name: vendors
+    cron: "0 6 * * 1"
+    accounts: [123456789012]
+    wordlists: [vendors]
+  - name: own-accounts
+    cron: "@daily"
+    timezone: America/New_York
+    accounts: ["111111111111"]
+    roles: [Admin]
+    force: true
+`)
+	schedules, err := loadSchedules(path)
+	require.NoError(t, err)
+	require.Len(t, schedules, 2)
+
+	assert.Equal(t, "vendors", schedules[0].Name)
+	assert.Equal(t, []string{"123456789012"}, schedules[0].Accounts)
+	assert.Equal(t, []string{"vendors"}, schedules[0].Wordlists)
+	assert.Equal(t, "vendors", schedules[0].schedule)
+	assert.Equal(t, time.Local, schedules[0].location)
+
+	assert.Equal(t, []string{"Admin"}, schedules[1].Roles)
+	assert.True(t, schedules[1].Force)
+	assert.Equal(t, "America/New_York", schedules[1].location.String())
+	from := time.Date(2024, 1, 3, 12, 0, 0, 0, schedules[1].location)
+	assert.Equal(t, time.Date(2024, 1, 4, 0, 0, 0, 0, schedules[1].location), schedules[1].cron.Next(from))
+}
+
+func TestLoadSchedules_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no scans":       `scans: []`,
+		"no name":        `scans: [{cron: "@daily", roles: [Admin]}]`,
+		"duplicate name": `scans: [{name: a, cron: "@daily"}, {name: a, cron: "@hourly"}]`,
+		"bad cron":       `scans: [{name: a, cron: "every day"}]`,
+		"bad timezone":   `scans: [{name: a, cron: "@daily", timezone: Mars/Olympus}]`,
+		"unknown field":  `scans: [{name: a, cron: "@daily", roless: [Admin]}]`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadSchedules(writeSchedules(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestServer_SubmitScheduled(t *testing.T) {
+	release := make(chan struct{})
+	s := newTestAPI(t, "", &fakeScanner{release: release})
+	ctx := utils.NewContext(context.Background())
+	sched := scheduledScan{Name: "vendors", scanRequest: scanRequest{Accounts: []string{"123456789012"}, Roles: []string{"Admin"}, schedule: "vendors"}}
+
+	s.submitScheduled(ctx, sched)
+	jobs := s.list()
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "vendors", jobs[0].Schedule)
+
+	// The first scan is still running, so it isn't submitted again.
+	s.submitScheduled(ctx, sched)
+	assert.Len(t, s.list(), 1)
+
+	release <- struct{}{}
+	release <- struct{}{}
+	assert.Eventually(t, func() bool { return s.list()[0].Status == jobDone }, time.Second, 5*time.Millisecond)
+	s.submitScheduled(ctx, sched)
+	require.Len(t, s.list(), 2)
+	release <- struct{}{}
+	release <- struct{}{}
+	assert.Eventually(t, func() bool { return s.list()[1].Status == jobDone }, time.Second, 5*time.Millisecond)
+}
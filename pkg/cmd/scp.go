@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"slices"
+)
+
+// ScanningOUName is the organizational unit -setup -org -scp moves the accounts it created into, and SCPName the
+// service control policy attached to it.
+const (
+	ScanningOUName = "role-scanning-accounts"
+	SCPName        = "role-scanning-accounts"
+)
+
+// scanningAccountActions are the IAM actions besides the plugins' that setup, scans, and -clean call in a scanning
+// account: listing and enabling regions, the -budget budget, and CloudFormation for the StackSet's stack instances.
+var scanningAccountActions = []string{
+	"account:EnableRegion",
+	"account:ListRegions",
+	"budgets:ModifyBudget",
+	"budgets:ViewBudget",
+	"cloudformation:*",
+	"sts:GetCallerIdentity",
+}
+
+type IOrgGuardrail interface {
+	ListRoots(ctx context.Context, params *organizations.ListRootsInput, optFns ...func(*organizations.Options)) (*organizations.ListRootsOutput, error)
+	EnablePolicyType(ctx context.Context, params *organizations.EnablePolicyTypeInput, optFns ...func(*organizations.Options)) (*organizations.EnablePolicyTypeOutput, error)
+	ListOrganizationalUnitsForParent(ctx context.Context, params *organizations.ListOrganizationalUnitsForParentInput, optFns ...func(*organizations.Options)) (*organizations.ListOrganizationalUnitsForParentOutput, error)
+	CreateOrganizationalUnit(ctx context.Context, params *organizations.CreateOrganizationalUnitInput, optFns ...func(*organizations.Options)) (*organizations.CreateOrganizationalUnitOutput, error)
+	ListParents(ctx context.Context, params *organizations.ListParentsInput, optFns ...func(*organizations.Options)) (*organizations.ListParentsOutput, error)
+	MoveAccount(ctx context.Context, params *organizations.MoveAccountInput, optFns ...func(*organizations.Options)) (*organizations.MoveAccountOutput, error)
+	ListPolicies(ctx context.Context, params *organizations.ListPoliciesInput, optFns ...func(*organizations.Options)) (*organizations.ListPoliciesOutput, error)
+	CreatePolicy(ctx context.Context, params *organizations.CreatePolicyInput, optFns ...func(*organizations.Options)) (*organizations.CreatePolicyOutput, error)
+	UpdatePolicy(ctx context.Context, params *organizations.UpdatePolicyInput, optFns ...func(*organizations.Options)) (*organizations.UpdatePolicyOutput, error)
+	AttachPolicy(ctx context.Context, params *organizations.AttachPolicyInput, optFns ...func(*organizations.Options)) (*organizations.AttachPolicyOutput, error)
+}
+
+// scpDocument is a service control policy, only the statement fields the guardrail uses are included.
+type scpDocument struct {
+	Version   string         `json:"Version"`
+	Statement []scpStatement `json:"Statement"`
+}
+
+type scpStatement struct {
+	Sid       string   `json:"Sid"`
+	Effect    string   `json:"Effect"`
+	NotAction []string `json:"NotAction"`
+	Resource  string   `json:"Resource"`
+}
+
+// scpPolicy returns the service control policy that denies every action in a scanning account besides the ones the
+// registered plugins and scanningAccountActions call, sorted so the policy only changes when the actions do.
+func scpPolicy(registered []pluginInfo) (string, error) {
+	actions := slices.Clone(scanningAccountActions)
+	for _, p := range registered {
+		actions = append(actions, p.actions...)
+	}
+	slices.Sort(actions)
+
+	doc, err := json.Marshal(scpDocument{
+		Version: "2012-10-17",
+		Statement: []scpStatement{{
+			Sid:       "RoleScanningOnly",
+			Effect:    "Deny",
+			NotAction: slices.Compact(actions),
+			Resource:  "*",
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshalling policy: %w", err)
+	}
+	return string(doc), nil
+}
+
+// SetupSCP moves the accounts -org created into the ScanningOUName organizational unit and attaches SCPName to it, so
+// credentials from a scanning account can only call the APIs scanning needs. The policy has the actions of every
+// registered plugin, not only the ones -plugins sets up, so later setups with other plugins aren't denied. Running it
+// again updates the policy and moves accounts created since.
+func SetupSCP(ctx *utils.Context, cfg aws.Config, state *setupState) error {
+	policy, err := scpPolicy(registeredPlugins)
+	if err != nil {
+		return err
+	}
+	g := &orgGuardrail{client: organizations.NewFromConfig(cfg)}
+	return g.apply(ctx, policy, state.createdAccounts())
+}
+
+// orgGuardrail applies the SCP to the role scanning accounts in an organization.
+type orgGuardrail struct {
+	client IOrgGuardrail
+}
+
+func (g *orgGuardrail) apply(ctx *utils.Context, policy string, accountIds []string) error {
+	rootId, err := g.enableSCPs(ctx)
+	if err != nil {
+		return err
+	}
+	ouId, err := g.organizationalUnit(ctx, rootId)
+	if err != nil {
+		return err
+	}
+	for _, accountId := range accountIds {
+		if err := g.moveAccount(ctx, accountId, ouId); err != nil {
+			return err
+		}
+	}
+	policyId, err := g.putPolicy(ctx, policy)
+	if err != nil {
+		return err
+	}
+
+	var attached *types.DuplicatePolicyAttachmentException
+	if _, err := g.client.AttachPolicy(ctx, &organizations.AttachPolicyInput{PolicyId: aws.String(policyId), TargetId: aws.String(ouId)}); errors.As(err, &attached) {
+		ctx.Debug.Printf("%s already attached to %s", SCPName, ScanningOUName)
+	} else if err != nil {
+		return fmt.Errorf("attaching %s: %s", SCPName, err)
+	}
+	ctx.Info.Printf("%s is attached to %s with %d accounts", SCPName, ScanningOUName, len(accountIds))
+	return nil
+}
+
+// enableSCPs enables service control policies in the organization if they aren't already, returning its root ID.
+func (g *orgGuardrail) enableSCPs(ctx *utils.Context) (string, error) {
+	roots, err := g.client.ListRoots(ctx, &organizations.ListRootsInput{})
+	if err != nil {
+		return "", fmt.Errorf("listing roots: %s", err)
+	}
+	if len(roots.Roots) == 0 {
+		return "", fmt.Errorf("organization has no root")
+	}
+	root := roots.Roots[0]
+
+	for _, policyType := range root.PolicyTypes {
+		if policyType.Type == types.PolicyTypeServiceControlPolicy && policyType.Status == types.PolicyTypeStatusEnabled {
+			return aws.ToString(root.Id), nil
+		}
+	}
+	ctx.Info.Printf("Enabling service control policies")
+	if _, err := g.client.EnablePolicyType(ctx, &organizations.EnablePolicyTypeInput{
+		RootId:     root.Id,
+		PolicyType: types.PolicyTypeServiceControlPolicy,
+	}); err != nil {
+		return "", fmt.Errorf("enabling service control policies: %s", err)
+	}
+	return aws.ToString(root.Id), nil
+}
+
+// organizationalUnit returns the ID of the ScanningOUName organizational unit under rootId, creating it if it doesn't
+// exist.
+func (g *orgGuardrail) organizationalUnit(ctx *utils.Context, rootId string) (string, error) {
+	paginator := organizations.NewListOrganizationalUnitsForParentPaginator(g.client, &organizations.ListOrganizationalUnitsForParentInput{
+		ParentId: aws.String(rootId),
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("listing organizational units: %s", err)
+		}
+		for _, ou := range resp.OrganizationalUnits {
+			if aws.ToString(ou.Name) == ScanningOUName {
+				return aws.ToString(ou.Id), nil
+			}
+		}
+	}
+
+	ctx.Info.Printf("Creating organizational unit %s", ScanningOUName)
+	resp, err := g.client.CreateOrganizationalUnit(ctx, &organizations.CreateOrganizationalUnitInput{
+		Name:     aws.String(ScanningOUName),
+		ParentId: aws.String(rootId),
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating organizational unit: %s", err)
+	}
+	return aws.ToString(resp.OrganizationalUnit.Id), nil
+}
+
+// moveAccount moves accountId into ouId if it isn't in it already.
+func (g *orgGuardrail) moveAccount(ctx *utils.Context, accountId string, ouId string) error {
+	parents, err := g.client.ListParents(ctx, &organizations.ListParentsInput{ChildId: aws.String(accountId)})
+	if err != nil {
+		return fmt.Errorf("listing parents of %s: %s", accountId, err)
+	}
+	if len(parents.Parents) == 0 {
+		return fmt.Errorf("%s has no parent", accountId)
+	}
+	parentId := aws.ToString(parents.Parents[0].Id)
+	if parentId == ouId {
+		return nil
+	}
+
+	ctx.Info.Printf("Moving %s into %s", accountId, ScanningOUName)
+	if _, err := g.client.MoveAccount(ctx, &organizations.MoveAccountInput{
+		AccountId:           aws.String(accountId),
+		SourceParentId:      aws.String(parentId),
+		DestinationParentId: aws.String(ouId),
+	}); err != nil {
+		return fmt.Errorf("moving %s: %s", accountId, err)
+	}
+	return nil
+}
+
+// putPolicy creates SCPName with policy, or updates it if it exists, returning its ID.
+func (g *orgGuardrail) putPolicy(ctx *utils.Context, policy string) (string, error) {
+	paginator := organizations.NewListPoliciesPaginator(g.client, &organizations.ListPoliciesInput{
+		Filter: types.PolicyTypeServiceControlPolicy,
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("listing policies: %s", err)
+		}
+		for _, summary := range resp.Policies {
+			if aws.ToString(summary.Name) != SCPName {
+				continue
+			}
+			if _, err := g.client.UpdatePolicy(ctx, &organizations.UpdatePolicyInput{
+				PolicyId: summary.Id,
+				Content:  aws.String(policy),
+			}); err != nil {
+				return "", fmt.Errorf("updating %s: %s", SCPName, err)
+			}
+			return aws.ToString(summary.Id), nil
+		}
+	}
+
+	ctx.Info.Printf("Creating service control policy %s", SCPName)
+	resp, err := g.client.CreatePolicy(ctx, &organizations.CreatePolicyInput{
+		Name:        aws.String(SCPName),
+		Description: aws.String("Denies everything in the role scanning accounts besides the APIs scanning needs"),
+		Type:        types.PolicyTypeServiceControlPolicy,
+		Content:     aws.String(policy),
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating %s: %s", SCPName, err)
+	}
+	return aws.ToString(resp.Policy.PolicySummary.Id), nil
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockGuardrail is an organization with the root r-abcd, parents are the parent of each account and policies the
+// content of each SCP by name.
+type mockGuardrail struct {
+	IOrgGuardrail
+	scpsEnabled bool
+	ous         map[string]string
+	parents     map[string]string
+	policies    map[string]string
+	attached    map[string]string
+
+	enabled bool
+	moved   []*organizations.MoveAccountInput
+}
+
+func (m *mockGuardrail) ListRoots(ctx context.Context, params *organizations.ListRootsInput, optFns ...func(*organizations.Options)) (*organizations.ListRootsOutput, error) {
+	root := types.Root{Id: aws.String("r-abcd")}
+	if m.scpsEnabled {
+		root.PolicyTypes = []types.PolicyTypeSummary{{Type: types.PolicyTypeServiceControlPolicy, Status: types.PolicyTypeStatusEnabled}}
+	}
+	return &organizations.ListRootsOutput{Roots: []types.Root{root}}, nil
+}
+
+func (m *mockGuardrail) EnablePolicyType(ctx context.Context, params *organizations.EnablePolicyTypeInput, optFns ...func(*organizations.Options)) (*organizations.EnablePolicyTypeOutput, error) {
+	m.enabled, m.scpsEnabled = true, true
+	return &organizations.EnablePolicyTypeOutput{}, nil
+}
+
+func (m *mockGuardrail) ListOrganizationalUnitsForParent(ctx context.Context, params *organizations.ListOrganizationalUnitsForParentInput, optFns ...func(*organizations.Options)) (*organizations.ListOrganizationalUnitsForParentOutput, error) {
+	var ous []types.OrganizationalUnit
+	for name, id := range m.ous {
+		ous = append(ous, types.OrganizationalUnit{Id: aws.String(id), Name: aws.String(name)})
+	}
+	return &organizations.ListOrganizationalUnitsForParentOutput{OrganizationalUnits: ous}, nil
+}
+
+func (m *mockGuardrail) CreateOrganizationalUnit(ctx context.Context, params *organizations.CreateOrganizationalUnitInput, optFns ...func(*organizations.Options)) (*organizations.CreateOrganizationalUnitOutput, error) {
+	m.ous[aws.ToString(params.Name)] = "ou-abcd-scanning"
+	return &organizations.CreateOrganizationalUnitOutput{OrganizationalUnit: &types.OrganizationalUnit{Id: aws.String("ou-abcd-scanning")}}, nil
+}
+
+func (m *mockGuardrail) ListParents(ctx context.Context, params *organizations.ListParentsInput, optFns ...func(*organizations.Options)) (*organizations.ListParentsOutput, error) {
+	return &organizations.ListParentsOutput{Parents: []types.Parent{{Id: aws.String(m.parents[aws.ToString(params.ChildId)])}}}, nil
+}
+
+func (m *mockGuardrail) MoveAccount(ctx context.Context, params *organizations.MoveAccountInput, optFns ...func(*organizations.Options)) (*organizations.MoveAccountOutput, error) {
+	m.moved = append(m.moved, params)
+	m.parents[aws.ToString(params.AccountId)] = aws.ToString(params.DestinationParentId)
+	return &organizations.MoveAccountOutput{}, nil
+}
+
+func (m *mockGuardrail) ListPolicies(ctx context.Context, params *organizations.ListPoliciesInput, optFns ...func(*organizations.Options)) (*organizations.ListPoliciesOutput, error) {
+	var policies []types.PolicySummary
+	for name := range m.policies {
+		policies = append(policies, types.PolicySummary{Id: aws.String("p-" + name), Name: aws.String(name)})
+	}
+	return &organizations.ListPoliciesOutput{Policies: policies}, nil
+}
+
+func (m *mockGuardrail) CreatePolicy(ctx context.Context, params *organizations.CreatePolicyInput, optFns ...func(*organizations.Options)) (*organizations.CreatePolicyOutput, error) {
+	name := aws.ToString(params.Name)
+	m.policies[name] = aws.ToString(params.Content)
+	return &organizations.CreatePolicyOutput{Policy: &types.Policy{PolicySummary: &types.PolicySummary{Id: aws.String("p-" + name)}}}, nil
+}
+
+func (m *mockGuardrail) UpdatePolicy(ctx context.Context, params *organizations.UpdatePolicyInput, optFns ...func(*organizations.Options)) (*organizations.UpdatePolicyOutput, error) {
+	m.policies[aws.ToString(params.PolicyId)[len("p-"):]] = aws.ToString(params.Content)
+	return &organizations.UpdatePolicyOutput{}, nil
+}
+
+func (m *mockGuardrail) AttachPolicy(ctx context.Context, params *organizations.AttachPolicyInput, optFns ...func(*organizations.Options)) (*organizations.AttachPolicyOutput, error) {
+	if m.attached[aws.ToString(params.PolicyId)] == aws.ToString(params.TargetId) {
+		return nil, &types.DuplicatePolicyAttachmentException{}
+	}
+	m.attached[aws.ToString(params.PolicyId)] = aws.ToString(params.TargetId)
+	return &organizations.AttachPolicyOutput{}, nil
+}
+
+func TestSCPPolicy(t *testing.T) {
+	policy, err := scpPolicy(registeredPlugins)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(policy), 5120, "the most characters an SCP can have")
+
+	var doc scpDocument
+	require.NoError(t, json.Unmarshal([]byte(policy), &doc))
+	require.Len(t, doc.Statement, 1)
+	assert.Equal(t, "Deny", doc.Statement[0].Effect)
+	actions := doc.Statement[0].NotAction
+	assert.Contains(t, actions, "sns:SetTopicAttributes")
+	assert.Contains(t, actions, "account:EnableRegion")
+	assert.IsIncreasing(t, actions, "sorted without duplicates")
+
+	for _, p := range registeredPlugins {
+		assert.NotEmpty(t, p.actions, "%s needs its actions for the SCP", p.name)
+	}
+}
+
+func TestOrgGuardrail_Apply(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockGuardrail{
+		ous:      map[string]string{"workloads": "ou-abcd-workloads"},
+		parents:  map[string]string{"111111111111": "r-abcd", "222222222222": "ou-abcd-workloads"},
+		policies: map[string]string{"FullAWSAccess": "{}"},
+		attached: map[string]string{},
+	}
+	g := &orgGuardrail{client: client}
+
+	require.NoError(t, g.apply(ctx, "policy", []string{"111111111111", "222222222222"}))
+	assert.True(t, client.enabled)
+	assert.Equal(t, "ou-abcd-scanning", client.ous[ScanningOUName])
+	require.Len(t, client.moved, 2)
+	assert.Equal(t, "ou-abcd-workloads", aws.ToString(client.moved[1].SourceParentId))
+	assert.Equal(t, "policy", client.policies[SCPName])
+	assert.Equal(t, "{}", client.policies["FullAWSAccess"])
+	assert.Equal(t, "ou-abcd-scanning", client.attached["p-"+SCPName])
+
+	// Running it again updates the policy and only moves new accounts.
+	client.enabled = false
+	client.parents["333333333333"] = "r-abcd"
+	require.NoError(t, g.apply(ctx, "new policy", []string{"111111111111", "222222222222", "333333333333"}))
+	assert.False(t, client.enabled)
+	require.Len(t, client.moved, 3)
+	assert.Equal(t, "333333333333", aws.ToString(client.moved[2].AccountId))
+	assert.Equal(t, "new policy", client.policies[SCPName])
+}
@@ -0,0 +1,585 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ServeOpts struct {
+	Profile string
+	Name    string
+	Storage string
+	// AssumeRole are the options the role in each scanning account is assumed with, see utils.LoadAccounts.
+	AssumeRole utils.AssumeRoleOptions
+	// Regions are the regions of the scanning accounts that are used, see utils.LoadConfigs.
+	Regions utils.RegionSelection
+
+	// Addr is the address to listen on, like 127.0.0.1:8080.
+	Addr string
+	// GRPCAddr is the address to serve the gRPC API on, it's disabled if empty.
+	GRPCAddr string
+	// Token is required as a bearer token on every request if set.
+	Token     string
+	RateLimit float64
+	// RateBurst is the most principals scanned at once after the server has been idle, 0 for RateLimit rounded up.
+	RateBurst     int
+	SkipRootCheck bool
+	// Schedule is a file of scans to submit on cron schedules, see loadSchedules.
+	Schedule string
+	// SQSQueue is the URL of an SQS queue to submit scans from, see parseCandidateMessage.
+	SQSQueue string
+}
+
+// scanRequest is the body of POST /scans, the lists are in the same format as the lines of -accounts, -roles, and
+// -principals lists. Only inline lists and built-in wordlists are accepted, so clients can't read files on the server.
+type scanRequest struct {
+	Accounts   []string            `json:"accounts"`
+	Roles      []string            `json:"roles"`
+	Principals []string            `json:"principals"`
+	Wordlists  []string            `json:"wordlists"`
+	Vars       map[string][]string `json:"vars"`
+	Force      bool                `json:"force"`
+
+	// schedule is the name of the scheduled scan that submitted the request, it's empty for requests from clients.
+	schedule string
+	// arns are candidates to scan as they are instead of the ones expanded from the lists, for the principal ARNs in
+	// SQS messages.
+	arns map[string]utils.Info
+}
+
+// Scan job statuses.
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// scanJob is a submitted scan, jobs are run one at a time so they share the rate limit.
+type scanJob struct {
+	ID         int       `json:"id"`
+	Schedule   string    `json:"schedule,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Candidates int       `json:"candidates"`
+	Scanned    int       `json:"scanned"`
+	Found      int       `json:"found"`
+	Created    time.Time `json:"created"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+
+	request    scanRequest
+	candidates map[string]utils.Info
+	results    []scanRecord
+	// changed is closed and replaced whenever results or the status change, to wake up streaming requests.
+	changed chan struct{}
+}
+
+// resultScanner is the part of scanner.Scanner the server uses.
+type resultScanner interface {
+	ScanArns(ctx *utils.Context, candidates map[string]utils.Info) iter.Seq2[string, utils.Info]
+}
+
+// server is the REST API of roles serve, one long-lived scanner sharing a single storage between all clients.
+type server struct {
+	storage scanner.Storage
+	name    string
+	token   string
+	regions map[string]utils.Info
+	// newScanner returns the scanner for a job, force rescans stored results.
+	newScanner func(force bool) resultScanner
+	rateLimit  float64
+	rateBurst  int
+	// notifier publishes each job's new findings when it's set.
+	notifier *snsNotifier
+
+	mux   sync.Mutex
+	jobs  []*scanJob
+	queue chan *scanJob
+}
+
+// Serve runs the REST API, and the gRPC API if opts.GRPCAddr is set, until ctx is done.
+func Serve(ctx *utils.Context, opts ServeOpts) error {
+	cfg, cfgs, err := LoadScanConfigs(ctx, opts.Profile, opts.AssumeRole, opts.Regions)
+	if err != nil {
+		return err
+	}
+
+	storage, err := scanner.NewStorage(ctx, cfg, opts.Storage, opts.Name)
+	if err != nil {
+		return fmt.Errorf("new storage: %s", err)
+	}
+	defer storage.Close()
+
+	var schedules []scheduledScan
+	if opts.Schedule != "" {
+		if schedules, err = loadSchedules(opts.Schedule); err != nil {
+			return err
+		}
+	}
+
+	var consumer *sqsConsumer
+	if opts.SQSQueue != "" {
+		if consumer, err = newSQSConsumer(cfg, opts.SQSQueue); err != nil {
+			return err
+		}
+	}
+
+	plugins := LoadAllPlugins(cfgs)
+	s := newServer(storage, opts.Name, opts.Token, opts.RateLimit, opts.RateBurst, func(force bool) resultScanner {
+		return scanner.NewScanner(&scanner.NewScannerInput{
+			Storage:       storage,
+			Force:         force,
+			Plugins:       plugins,
+			RateLimit:     opts.RateLimit,
+			RateBurst:     opts.RateBurst,
+			SkipRootCheck: opts.SkipRootCheck,
+		})
+	})
+	go s.work(ctx)
+	for _, sched := range schedules {
+		go s.schedule(ctx, sched)
+	}
+	if consumer != nil {
+		go s.consume(ctx, consumer)
+	}
+
+	httpServer := &http.Server{Addr: opts.Addr, Handler: s.handler()}
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+
+	if opts.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", opts.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("listening on %s: %s", opts.GRPCAddr, err)
+		}
+		grpcServer := newGRPCServer(s)
+		defer grpcServer.Stop()
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				ctx.Error.Printf("serving gRPC: %s", err)
+				httpServer.Close()
+			}
+		}()
+		ctx.Info.Printf("serving %s gRPC API on %s", opts.Name, opts.GRPCAddr)
+	}
+
+	ctx.Info.Printf("serving %s on %s", opts.Name, opts.Addr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func newServer(storage scanner.Storage, name string, token string, rateLimit float64, rateBurst int, newScanner func(force bool) resultScanner) *server {
+	return &server{
+		storage:    storage,
+		name:       name,
+		token:      token,
+		regions:    Regions(),
+		newScanner: newScanner,
+		rateLimit:  rateLimit,
+		rateBurst:  rateBurst,
+		queue:      make(chan *scanJob, 100),
+	}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scans", s.submitScan)
+	mux.HandleFunc("GET /scans", s.listScans)
+	mux.HandleFunc("GET /scans/{id}", s.getScan)
+	mux.HandleFunc("GET /scans/{id}/results", s.streamResults)
+	mux.HandleFunc("GET /results", s.queryResults)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// submitScan queues a scan for the request body.
+func (s *server) submitScan(w http.ResponseWriter, r *http.Request) {
+	var req scanRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("parsing request: %s", err))
+		return
+	}
+
+	job, err := s.submit(r.Context(), req)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// decodeScanRequest parses a scan request, unknown fields are rejected so misspelled lists aren't silently ignored.
+func decodeScanRequest(body []byte) (scanRequest, error) {
+	var req scanRequest
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return scanRequest{}, fmt.Errorf("parsing scan request: %s", err)
+	}
+	return req, nil
+}
+
+// apiError is an error caused by the client or the server's state rather than a failure, it's returned with its HTTP
+// status by the REST API and the matching code by the gRPC API.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func newAPIError(status int, format string, args ...any) error {
+	return &apiError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// submit expands req into candidates and queues a job for them, input errors are returned right away rather than
+// failing the job later.
+func (s *server) submit(ctx context.Context, req scanRequest) (scanJob, error) {
+	md, err := s.storage.Metadata()
+	if err != nil {
+		return scanJob{}, fmt.Errorf("getting metadata: %s", err)
+	}
+	if md.Engagement != nil {
+		if err := md.Engagement.Active(time.Now()); err != nil {
+			return scanJob{}, newAPIError(http.StatusForbidden, "refusing to scan %s: %s", s.name, err)
+		}
+	}
+
+	candidates := req.arns
+	if candidates == nil {
+		candidates, err = arn.GetArns(utils.NewContext(ctx), &arn.GetArnsInput{
+			AccountsStr:   strings.Join(req.Accounts, ","),
+			Roles:         req.Roles,
+			Principals:    req.Principals,
+			Wordlists:     req.Wordlists,
+			Vars:          req.Vars,
+			MaxCandidates: arn.DefaultMaxCandidates,
+			Regions:       s.regions,
+		})
+		if err != nil {
+			return scanJob{}, newAPIError(http.StatusBadRequest, "getting candidates: %s", err)
+		}
+	}
+
+	s.mux.Lock()
+	job := &scanJob{
+		ID:         len(s.jobs) + 1,
+		Schedule:   req.schedule,
+		Status:     jobQueued,
+		Candidates: len(candidates),
+		Created:    time.Now().UTC(),
+		request:    req,
+		candidates: candidates,
+		changed:    make(chan struct{}),
+	}
+	s.jobs = append(s.jobs, job)
+	s.mux.Unlock()
+
+	select {
+	case s.queue <- job:
+	default:
+		s.update(job, func() {
+			job.Status, job.Error = jobFailed, "too many queued scans"
+		})
+		return scanJob{}, newAPIError(http.StatusServiceUnavailable, "too many queued scans")
+	}
+	return s.snapshot(job), nil
+}
+
+func (s *server) listScans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.list())
+}
+
+func (s *server) list() []scanJob {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	jobs := []scanJob{}
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+func (s *server) getScan(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if ok {
+		writeJSON(w, http.StatusOK, s.snapshot(job))
+	}
+}
+
+// streamResults writes the job's results as JSON lines as they're found, until the job finishes or the client goes
+// away. ?status=exists only streams the principals found.
+func (s *server) streamResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	filter, err := newRecordFilter(r.URL.Query().Get("status"), r.URL.Query().Get("account"), "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	s.results(r.Context(), job, filter, func(records []scanRecord) error {
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
+
+// results calls send with the job's results matching filter as they're found, until the job finishes, ctx is done, or
+// send returns an error.
+func (s *server) results(ctx context.Context, job *scanJob, filter recordFilter, send func([]scanRecord) error) error {
+	sent := 0
+	for {
+		s.mux.Lock()
+		records := slices.Clone(job.results[sent:])
+		finished := job.Status == jobDone || job.Status == jobFailed
+		changed := job.changed
+		s.mux.Unlock()
+
+		sent += len(records)
+		records = slices.DeleteFunc(records, func(rec scanRecord) bool { return !filter.Match(rec) })
+		if err := send(records); err != nil {
+			return err
+		}
+
+		if finished {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// queryResults returns the stored results matching ?status=, ?account=, and ?since=, like roles export. ?format= is
+// json, jsonl, or csv.
+func (s *server) queryResults(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := newRecordFilter(query.Get("status"), query.Get("account"), query.Get("since"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := map[string]string{"json": "application/json", "jsonl": "application/x-ndjson", "csv": "text/csv"}[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q: must be json, jsonl, or csv", format))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	writeRecords(w, format, s.query(filter))
+}
+
+// query returns the stored results matching filter sorted by ARN.
+func (s *server) query(filter recordFilter) []scanRecord {
+	var records []scanRecord
+	for key, info := range s.storage.All() {
+		if rec := newScanRecord(key.Arn, info); filter.Match(rec) {
+			records = append(records, rec)
+		}
+	}
+	slices.SortFunc(records, func(a, b scanRecord) int { return strings.Compare(a.Arn, b.Arn) })
+	return records
+}
+
+// work runs queued jobs one at a time until ctx is done.
+func (s *server) work(ctx *utils.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			s.run(ctx, job)
+		}
+	}
+}
+
+func (s *server) run(ctx *utils.Context, job *scanJob) {
+	start := time.Now().UTC()
+	s.update(job, func() {
+		job.Status, job.Started = jobRunning, start
+	})
+
+	var runID int
+	err := s.storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		runID = md.StartRun(scanner.Run{
+			Start: start,
+			Options: scanner.RunOptions{
+				Accounts:  strings.Join(job.request.Accounts, ","),
+				Wordlists: strings.Join(job.request.Wordlists, ","),
+				Vars:      job.request.Vars,
+				Force:     job.request.Force,
+				RateLimit: s.rateLimit,
+				RateBurst: s.rateBurst,
+			},
+			Candidates: job.Candidates,
+		})
+		return nil
+	})
+	if err != nil {
+		s.fail(ctx, job, fmt.Errorf("recording run: %s", err))
+		return
+	}
+
+	var notifier *snsNotifier
+	if s.notifier != nil {
+		// Findings are new if they're first seen since this job started rather than since the server did.
+		n := *s.notifier
+		n.since = start
+		notifier = &n
+	}
+
+	for principalArn, info := range s.newScanner(job.request.Force).ScanArns(ctx, job.candidates) {
+		s.update(job, func() {
+			job.results = append(job.results, newScanRecord(principalArn, info))
+			job.Scanned++
+			if info.Exists {
+				job.Found++
+			}
+		})
+		if notifier != nil {
+			if err := notifier.Notify(ctx, principalArn, info); err != nil {
+				ctx.Error.Printf("scan %d: notifying: %s", job.ID, err)
+			}
+		}
+	}
+
+	if err := s.storage.Save(); err != nil {
+		s.fail(ctx, job, fmt.Errorf("saving storage: %s", err))
+		return
+	}
+
+	err = s.storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		if run := md.Run(runID); run != nil {
+			run.End = time.Now().UTC()
+			run.Findings = job.Found
+		}
+		return nil
+	})
+	if err != nil {
+		s.fail(ctx, job, fmt.Errorf("recording run: %s", err))
+		return
+	}
+
+	s.update(job, func() {
+		job.Status, job.Finished = jobDone, time.Now().UTC()
+		job.candidates = nil
+	})
+	ctx.Info.Printf("scan %d finished, %d of %d candidates found", job.ID, job.Found, job.Candidates)
+}
+
+func (s *server) fail(ctx *utils.Context, job *scanJob, err error) {
+	ctx.Error.Printf("scan %d: %s", job.ID, err)
+	s.update(job, func() {
+		job.Status, job.Error, job.Finished = jobFailed, err.Error(), time.Now().UTC()
+		job.candidates = nil
+	})
+}
+
+// update applies change to job and wakes up anything waiting for it to change.
+func (s *server) update(job *scanJob, change func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	change()
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+func (s *server) snapshot(job *scanJob) scanJob {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return *job
+}
+
+// job returns the job named by the {id} path value, or writes a not found error.
+func (s *server) job(w http.ResponseWriter, r *http.Request) (*scanJob, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		if job := s.lookup(id); job != nil {
+			return job, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("no scan %q", r.PathValue("id")))
+	return nil, false
+}
+
+// lookup returns the job with the given ID, or nil if there isn't one.
+func (s *server) lookup(id int) *scanJob {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if id < 1 || id > len(s.jobs) {
+		return nil
+	}
+	return s.jobs[id-1]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeAPIError writes err with its status if it's an apiError, or as an internal server error.
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, apiErr.status, apiErr.message)
+	} else {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
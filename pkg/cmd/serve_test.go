@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScanner reports the candidates named Admin as existing and stores every result.
+type fakeScanner struct {
+	storage scanner.Storage
+	// release is waited on before each result when set.
+	release chan struct{}
+}
+
+func (f *fakeScanner) ScanArns(ctx *utils.Context, candidates map[string]utils.Info) iter.Seq2[string, utils.Info] {
+	return func(yield func(string, utils.Info) bool) {
+		for principalArn, candidate := range candidates {
+			if f.release != nil {
+				<-f.release
+			}
+			info := utils.Info{
+				Comment:     candidate.Comment,
+				Exists:      strings.HasSuffix(principalArn, ":root") || strings.HasSuffix(principalArn, "/Admin"),
+				LastChecked: time.Now().UTC(),
+			}
+			if key, err := scanner.NewKey(principalArn); err == nil {
+				f.storage.Set(key, info)
+			}
+			if !yield(principalArn, info) {
+				return
+			}
+		}
+	}
+}
+
+// newTestAPI returns a server scanning with scan and running its jobs until the test ends.
+func newTestAPI(t *testing.T, token string, scan *fakeScanner) *server {
+	s := newIdleTestAPI(t, token, scan)
+	workCtx, cancel := utils.NewContext(context.Background()).WithCancel()
+	t.Cleanup(cancel)
+	go s.work(workCtx)
+	return s
+}
+
+// newIdleTestAPI returns a server scanning with scan that doesn't run queued jobs.
+func newIdleTestAPI(t *testing.T, token string, scan *fakeScanner) *server {
+	ctx := utils.NewContext(context.Background())
+	storage, err := scanner.NewFileStorage(ctx, t.TempDir(), "test", scanner.StorageOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	scan.storage = storage
+
+	s := newServer(storage, "test", token, 5, 0, func(force bool) resultScanner { return scan })
+	s.regions = map[string]utils.Info{"us-east-1": {}}
+	return s
+}
+
+func newTestServer(t *testing.T, token string, scan *fakeScanner) *httptest.Server {
+	ts := httptest.NewServer(newTestAPI(t, token, scan).handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method string, url string, body string, token string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decode[T any](t *testing.T, resp *http.Response) T {
+	var v T
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&v))
+	return v
+}
+
+func TestServer_Scan(t *testing.T) {
+	ts := newTestServer(t, "", &fakeScanner{})
+
+	resp := do(t, "POST", ts.URL+"/scans", `{"accounts": ["123456789012"], "roles": ["Admin", "Other"]}`, "")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	job := decode[scanJob](t, resp)
+	assert.Equal(t, 1, job.ID)
+	assert.Equal(t, 3, job.Candidates)
+
+	// Streaming waits for the scan to finish.
+	resp = do(t, "GET", ts.URL+"/scans/1/results?status=exists", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var arns []string
+	for lines := bufio.NewScanner(resp.Body); lines.Scan(); {
+		var rec scanRecord
+		require.NoError(t, json.Unmarshal(lines.Bytes(), &rec))
+		arns = append(arns, rec.Arn)
+	}
+	assert.ElementsMatch(t, []string{"arn:aws:iam::123456789012:root", "arn:aws:iam::123456789012:role/Admin"}, arns)
+
+	job = decode[scanJob](t, do(t, "GET", ts.URL+"/scans/1", "", ""))
+	assert.Equal(t, jobDone, job.Status)
+	assert.Equal(t, 3, job.Scanned)
+	assert.Equal(t, 2, job.Found)
+
+	jobs := decode[[]scanJob](t, do(t, "GET", ts.URL+"/scans", "", ""))
+	assert.Len(t, jobs, 1)
+
+	records := decode[[]scanRecord](t, do(t, "GET", ts.URL+"/results?status=not-exists", "", ""))
+	require.Len(t, records, 1)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Other", records[0].Arn)
+}
+
+func TestServer_StreamsWhileScanning(t *testing.T) {
+	release := make(chan struct{})
+	ts := newTestServer(t, "", &fakeScanner{release: release})
+
+	resp := do(t, "POST", ts.URL+"/scans", `{"accounts": ["123456789012"], "roles": ["Admin"]}`, "")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp = do(t, "GET", ts.URL+"/scans/1/results", "", "")
+	lines := bufio.NewScanner(resp.Body)
+	for range 2 {
+		release <- struct{}{}
+		require.True(t, lines.Scan())
+	}
+	assert.False(t, lines.Scan())
+}
+
+func TestServer_Errors(t *testing.T) {
+	ts := newTestServer(t, "secret", &fakeScanner{})
+
+	assert.Equal(t, http.StatusUnauthorized, do(t, "GET", ts.URL+"/scans", "", "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, do(t, "GET", ts.URL+"/scans", "", "wrong").StatusCode)
+	assert.Equal(t, http.StatusOK, do(t, "GET", ts.URL+"/scans", "", "secret").StatusCode)
+
+	assert.Equal(t, http.StatusNotFound, do(t, "GET", ts.URL+"/scans/1", "", "secret").StatusCode)
+	assert.Equal(t, http.StatusNotFound, do(t, "GET", ts.URL+"/scans/x/results", "", "secret").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do(t, "POST", ts.URL+"/scans", `{"role_paths": ["/etc/passwd"]}`, "secret").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do(t, "POST", ts.URL+"/scans", `{"wordlists": ["missing"]}`, "secret").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do(t, "GET", ts.URL+"/results?format=xml", "", "secret").StatusCode)
+}
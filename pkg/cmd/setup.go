@@ -1,25 +1,86 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/organizations"
 	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/plugins"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"math/rand/v2"
+	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Setup runs a one-time account optimization
-func Setup(ctx *utils.Context, profile string, org bool) error {
+type SetupOpts struct {
+	Profile string
+	// AssumeRole are the options the role in each scanning account is assumed with, see utils.LoadAccounts.
+	AssumeRole utils.AssumeRoleOptions
+	// Regions are the regions of the scanning accounts that are used, see utils.LoadConfigs.
+	Regions utils.RegionSelection
+	// Org sets up an organization dedicated to scanning, see SetupOrg.
+	Org bool
+	// MaxAccounts is the most role scanning accounts Org creates, MaxScanningAccounts if zero.
+	MaxAccounts int
+	// AccountRole is the role Organizations creates in the accounts Org creates, utils.DefaultAccountRole if empty.
+	AccountRole string
+	// StackSet deploys the plugin resources with a StackSet instead of creating them with the plugins, see
+	// SetupStackSet.
+	StackSet bool
+	// Plugins are the names of the plugins to set up, every registered plugin if empty.
+	Plugins []string
+	// Budget is the monthly limit in USD of the budget created in each account, see SetupBudgets. No budget is
+	// created if it's zero.
+	Budget float64
+	// BudgetEmail is the address the budget alerts are sent to.
+	BudgetEmail string
+	// SCP attaches a service control policy to the accounts Org creates, see SetupSCP.
+	SCP bool
+	// Validate runs a canary scan through every plugin in every account and region once setup is done, see
+	// validateSetup.
+	Validate bool
+}
+
+// accountLimit returns the most role scanning accounts to create.
+func (o SetupOpts) accountLimit() int {
+	if o.MaxAccounts > 0 {
+		return o.MaxAccounts
+	}
+	return MaxScanningAccounts
+}
+
+// accountRole returns the role to create in new role scanning accounts.
+func (o SetupOpts) accountRole() string {
+	if o.AccountRole != "" {
+		return o.AccountRole
+	}
+	return utils.DefaultAccountRole
+}
+
+// Setup runs a one-time account optimization, progress is saved after each step so running it again after a failure
+// only retries what isn't done yet.
+func Setup(ctx *utils.Context, opts SetupOpts) error {
 	ctx.Info.Printf("Running one-time account optimization")
 
-	cfg, err := config.LoadDefaultConfig(ctx.Context,
+	if !utils.IsValidRoleName(opts.accountRole()) {
+		return fmt.Errorf("invalid account role name %q", opts.accountRole())
+	}
+	registered, err := selectPlugins(opts.Plugins)
+	if err != nil {
+		return err
+	}
+	if opts.StackSet && len(registered) != len(registeredPlugins) {
+		return fmt.Errorf("cannot set up only some plugins with a StackSet, it has the resources of every plugin")
+	}
+
+	cfg, err := utils.LoadConfig(ctx, opts.Profile,
 		config.WithRegion("us-east-1"),
-		config.WithSharedConfigProfile(profile),
 		config.WithRetryMode(aws.RetryModeAdaptive),
 		config.WithRetryMaxAttempts(10),
 	)
@@ -27,65 +88,152 @@ func Setup(ctx *utils.Context, profile string, org bool) error {
 		return fmt.Errorf("loading config: %s", err)
 	}
 
-	if org {
-		err = SetupOrg(ctx, cfg)
+	state, err := loadCallerSetupState(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("loading setup state: %s", err)
+	}
+
+	if opts.Org {
+		err = SetupOrg(ctx, cfg, opts, state)
 		if err != nil {
 			return fmt.Errorf("setting up org: %s", err)
 		}
 	}
+	if opts.SCP {
+		if err := SetupSCP(ctx, cfg, state); err != nil {
+			return fmt.Errorf("setting up SCP: %s", err)
+		}
+	}
 
-	accounts, err := utils.LoadAccounts(ctx, cfg)
+	accounts, err := utils.LoadAccounts(ctx, cfg, opts.AssumeRole)
 	if err != nil {
 		return fmt.Errorf("loading accounts: %s", err)
 	}
 
-	if err := SetupAccounts(ctx, accounts, cfg, err); err != nil {
+	if err := SetupAccounts(ctx, cfg, accounts, registered, opts, state); err != nil {
 		return fmt.Errorf("setting up accounts: %s", err)
 	}
 
 	return nil
 }
 
-func SetupAccounts(ctx *utils.Context, accounts map[string]utils.Account, cfg aws.Config, err error) error {
+// loadCallerSetupState loads the setup state of the account cfg is for.
+func loadCallerSetupState(ctx *utils.Context, cfg aws.Config) (*setupState, error) {
+	info, err := utils.GetCallerInfo(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("getting caller info: %s", err)
+	}
+	return loadSetupState(setupStatePath(aws.ToString(info.Account)))
+}
+
+// SetupAccounts creates the budgets if opts.Budget is set and enables all regions in each account that doesn't have
+// them enabled yet, then sets up the registered plugins, or deploys the resources of every plugin with the StackSet if
+// opts.StackSet is set. Only the regions opts.Regions selects are enabled and set up, and none are enabled if it only
+// selects the default regions. With opts.Validate, the canary scan's matrix is printed to stdout once it's done.
+func SetupAccounts(ctx *utils.Context, cfg aws.Config, accounts map[string]utils.Account, registered []pluginInfo, opts SetupOpts, state *setupState) error {
+	// Budgets are set up first so they're in place before anything is created, a failure doesn't stop the rest.
+	var budgetErr error
+	if opts.Budget > 0 {
+		if budgetErr = SetupBudgets(ctx, accounts, opts, state); budgetErr != nil {
+			ctx.Error.Printf("setting up budgets: %s", budgetErr)
+		}
+	}
+
 	wg := sync.WaitGroup{}
 	for _, v := range accounts {
+		if opts.Regions.DefaultOnly {
+			ctx.Debug.Printf("only using the default regions, not enabling regions in %s", v.AccountId)
+			break
+		}
+		if state.regionsEnabled(v.AccountId) {
+			ctx.Debug.Printf("all regions already enabled in %s", v.AccountId)
+			continue
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := utils.EnableAllRegions(ctx, v.Svc.Account); err != nil {
+			if err := utils.EnableAllRegions(ctx, v.Svc.Account, opts.Regions); err != nil {
 				ctx.Error.Printf("enabling all regions: %s", err)
+			} else if opts.Regions.All() {
+				// Only saved once every region is enabled, -setup -regions leaves the others for a later -setup.
+				if err := state.setRegionsEnabled(v.AccountId); err != nil {
+					ctx.Error.Printf("saving setup state: %s", err)
+				}
 			}
 		}()
 	}
 	ctx.Info.Printf("Enabling all regions, this can take a while...")
 	wg.Wait()
 
-	cfgs, err := utils.LoadConfigs(ctx, accounts)
+	cfgs, err := utils.LoadConfigs(ctx, accounts, opts.Regions)
 	if err != nil {
 		return fmt.Errorf("loading configs: %s", err)
 	}
 
-	if err := SetupPlugins(ctx, cfgs); err != nil {
+	if opts.StackSet {
+		if err := SetupStackSet(ctx, cfg, cfgs, state); err != nil {
+			return fmt.Errorf("setting up StackSet: %s", err)
+		}
+	} else if err := setupRegistered(ctx, registered, cfgs, state); err != nil {
 		return fmt.Errorf("setting up plugins: %s", err)
 	}
 
+	if opts.Validate {
+		if err := validateSetup(ctx, registered, cfgs, os.Stdout); err != nil {
+			return fmt.Errorf("validating setup: %s", err)
+		}
+	}
+
+	if budgetErr != nil {
+		return fmt.Errorf("setting up budgets: %s", budgetErr)
+	}
 	return nil
 }
 
-// SetupPlugins calls Setup on each plugin for each thread config.
-func SetupPlugins(ctx *utils.Context, cfgs map[string]utils.ThreadConfig) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("panic: %s", r)
+// setupConcurrency is how many plugins are set up at once, 40 seems to error occasionally.
+const setupConcurrency = 20
+
+// SetupPlugins calls Setup on each plugin for each thread config that isn't already set up.
+func SetupPlugins(ctx *utils.Context, cfgs map[string]utils.ThreadConfig, state *setupState) error {
+	return setupRegistered(ctx, registeredPlugins, cfgs, state)
+}
+
+// setupRegistered calls Setup on each instance of the registered plugins for each thread config that isn't already set
+// up.
+func setupRegistered(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig, state *setupState) error {
+	ps := map[string]plugins.Plugin{}
+	for key, cfg := range cfgs {
+		// Plugins are loaded one thread config at a time since their names are only unique within one.
+		for _, plugin := range utils.FlattenList(loadPlugins(registered, map[string]utils.ThreadConfig{key: cfg})) {
+			ps[pluginStateKey(key, plugin.Name())] = plugin
 		}
-	}()
+	}
+	return setupPlugins(ctx, ps, state)
+}
 
+// setupPlugins sets up each plugin in ps that the state doesn't have as set up, by pluginStateKey. A plugin failing
+// doesn't stop the others, the ones that failed are retried the next time setup is run.
+func setupPlugins(ctx *utils.Context, ps map[string]plugins.Plugin, state *setupState) error {
 	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	var errs []error
+
+	concurrent := make(chan int, setupConcurrency)
+
+	for key, plugin := range ps {
+		if state.pluginSetup(key) {
+			ctx.Debug.Printf("%s: already set up", plugin.Name())
+			// Set up before setup kept an inventory, or by the StackSet which deletes its own resources.
+			if !state.inventoried(key) && !state.usedStackSet() {
+				if err := state.setPluginCreated(key, newSetupResources(plugin)); err != nil {
+					m.Lock()
+					errs = append(errs, fmt.Errorf("%s: %s", plugin.Name(), err))
+					m.Unlock()
+				}
+			}
+			continue
+		}
 
-	// Setup 20 regions/accounts concurrently, 40 seems to error occasionally.
-	concurrent := make(chan int, 20)
-
-	for _, plugin := range utils.FlattenList(LoadAllPlugins(cfgs)) {
 		wg.Add(1)
 		concurrent <- 1
 
@@ -96,18 +244,25 @@ func SetupPlugins(ctx *utils.Context, cfgs map[string]utils.ThreadConfig) (err e
 			}()
 			ctx.Info.Printf("%s: setting up", plugin.Name())
 
-			if err := plugin.Setup(ctx); err != nil {
-				panic(fmt.Errorf("%s: %s", plugin.Name(), err))
-			} else {
+			err := plugin.Setup(ctx)
+			if err == nil {
 				ctx.Info.Printf("%s: setup complete", plugin.Name())
+				err = state.setPluginCreated(key, newSetupResources(plugin))
+			}
+			if err != nil {
+				m.Lock()
+				errs = append(errs, fmt.Errorf("%s: %s", plugin.Name(), err))
+				m.Unlock()
 			}
 		}()
 	}
 
 	// Wait for all plugins to finish setting up.
 	wg.Wait()
-	ctx.Info.Printf("Setting up plugins")
 
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d plugins failed, run -setup again to retry them: %w", len(errs), len(ps), errors.Join(errs...))
+	}
 	return nil
 }
 
@@ -115,7 +270,7 @@ func SetupPlugins(ctx *utils.Context, cfgs map[string]utils.ThreadConfig) (err e
 //
 // This organization shouldn't be used for anything else. During setup, we create as many accounts as possible
 // and enable all regions in each account. The org info is saved to disk so that it can use each account for scanning.
-func SetupOrg(ctx *utils.Context, cfg aws.Config) error {
+func SetupOrg(ctx *utils.Context, cfg aws.Config, opts SetupOpts, state *setupState) error {
 	ctx.Info.Printf("Setting up organization")
 
 	// Create the organization
@@ -125,69 +280,214 @@ func SetupOrg(ctx *utils.Context, cfg aws.Config) error {
 	}
 
 	// Create accounts
-	return CreateAccounts(ctx, cfg, email)
+	return CreateAccounts(ctx, cfg, email, opts, state)
 }
 
-func CreateAccounts(ctx *utils.Context, cfg aws.Config, email string) error {
-	svc := organizations.NewFromConfig(cfg)
+// MaxScanningAccounts is the most role scanning accounts -max-accounts allows, they're numbered from 1.
+const MaxScanningAccounts = 99
+
+// createAccountConcurrency is how many accounts are created at once, Organizations throttles account creation so more
+// than a few at once only get rate limited.
+const createAccountConcurrency = 5
+
+// Throttled account creation calls are retried with exponential backoff from createAccountRetryDelay up to
+// createAccountMaxRetryDelay, the status of an account being created is checked every createAccountPollDelay.
+const (
+	createAccountRetryDelay    = 5 * time.Second
+	createAccountMaxRetryDelay = time.Minute
+	createAccountPollDelay     = 3 * time.Second
+)
 
-	for i := 1; i < 100; i++ {
-		postfix := utils.RandStringRunes(8)
-
-		createResp, err := svc.CreateAccount(ctx, &organizations.CreateAccountInput{
-			AccountName: aws.String(fmt.Sprintf("role-scanning-sub-account-%s", postfix)),
-			Email:       aws.String(utils.GenerateSubAccountEmail(email, postfix)),
-			RoleName:    aws.String("OrganizationAccountAccessRole"),
-			Tags: []types.Tag{
-				{
-					Key:   aws.String("role-scanning-account"),
-					Value: aws.String("true"),
-				},
-				{
-					Key:   aws.String("role-scanning-account-number"),
-					Value: aws.String(strconv.Itoa(i)),
-				},
+type IOrgAccountCreator interface {
+	CreateAccount(ctx context.Context, params *organizations.CreateAccountInput, optFns ...func(*organizations.Options)) (*organizations.CreateAccountOutput, error)
+	DescribeCreateAccountStatus(ctx context.Context, params *organizations.DescribeCreateAccountStatusInput, optFns ...func(*organizations.Options)) (*organizations.DescribeCreateAccountStatusOutput, error)
+}
+
+// CreateAccounts creates role scanning accounts numbered up to the -max-accounts limit, stopping early if the
+// organization's account limit is reached. The account numbers the state has as already created are skipped. Each
+// account is tagged with the role Organizations creates in it, for LoadAccounts to assume.
+func CreateAccounts(ctx *utils.Context, cfg aws.Config, email string, opts SetupOpts, state *setupState) error {
+	creator := &accountCreator{
+		client:        organizations.NewFromConfig(cfg),
+		concurrency:   createAccountConcurrency,
+		retryDelay:    createAccountRetryDelay,
+		maxRetryDelay: createAccountMaxRetryDelay,
+		pollDelay:     createAccountPollDelay,
+	}
+	return creator.createAll(ctx, email, opts, state)
+}
+
+// accountCreator creates role scanning accounts in an organization.
+type accountCreator struct {
+	client        IOrgAccountCreator
+	concurrency   int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	pollDelay     time.Duration
+}
+
+// createAll creates the accounts numbered up to the -max-accounts limit that state doesn't have, concurrency at a
+// time, logging the progress as each one is created. No more are started once the organization's account limit is
+// reached. An account failing doesn't stop the others, the ones that failed are retried the next time setup is run.
+func (c *accountCreator) createAll(ctx *utils.Context, email string, opts SetupOpts, state *setupState) error {
+	var numbers []int
+	for i := 1; i <= opts.accountLimit(); i++ {
+		if id, ok := state.createdAccount(i); ok {
+			ctx.Debug.Printf("Account %d already created (%s)", i, id)
+			continue
+		}
+		numbers = append(numbers, i)
+	}
+	if len(numbers) == 0 {
+		return nil
+	}
+	ctx.Info.Printf("Creating up to %d accounts, %d at a time", len(numbers), c.concurrency)
+
+	start := time.Now()
+	queue := make(chan int)
+	var limitReached atomic.Bool
+	var created atomic.Int32
+
+	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	var errs []error
+
+	for range min(c.concurrency, len(numbers)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range queue {
+				// Numbers queued before the limit was reached are drained without being created.
+				if limitReached.Load() || ctx.IsDone() {
+					continue
+				}
+				name, id, full, err := c.create(ctx, email, number, opts.accountRole())
+				if full {
+					limitReached.Store(true)
+					continue
+				}
+				if err == nil {
+					if err = state.addAccount(number, id); err != nil {
+						err = fmt.Errorf("saving setup state: %s", err)
+					}
+				}
+				if err != nil {
+					m.Lock()
+					errs = append(errs, fmt.Errorf("account %d: %s", number, err))
+					m.Unlock()
+					continue
+				}
+				ctx.Info.Printf("Created account %s (%d of %d after %s)", name, created.Add(1), len(numbers), time.Since(start).Round(time.Second))
+			}
+		}()
+	}
+	for _, number := range numbers {
+		if limitReached.Load() || ctx.IsDone() {
+			break
+		}
+		queue <- number
+	}
+	close(queue)
+	wg.Wait()
+
+	if limitReached.Load() {
+		ctx.Info.Printf("Max accounts reached, created %d accounts", created.Load())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d accounts failed, run -setup again to retry them: %w", len(errs), errors.Join(errs...))
+	}
+	return ctx.Err()
+}
+
+// create creates the role scanning account numbered number and waits until it's ready, returning its name and ID. full
+// is true if the organization's account limit is reached instead.
+func (c *accountCreator) create(ctx *utils.Context, email string, number int, role string) (name string, accountId string, full bool, err error) {
+	postfix := utils.RandStringRunes(8)
+	input := &organizations.CreateAccountInput{
+		AccountName: aws.String(fmt.Sprintf("role-scanning-sub-account-%s", postfix)),
+		Email:       aws.String(utils.GenerateSubAccountEmail(email, postfix)),
+		RoleName:    aws.String(role),
+		Tags: []types.Tag{
+			{
+				Key:   aws.String(utils.AccountTagKey),
+				Value: aws.String(utils.AccountTagValue),
 			},
-		})
+			{
+				Key:   aws.String("role-scanning-account-number"),
+				Value: aws.String(strconv.Itoa(number)),
+			},
+			{
+				Key:   aws.String(utils.AccountRoleTag),
+				Value: aws.String(role),
+			},
+		},
+	}
 
-		var throttled *types.TooManyRequestsException
-		var maxAccounts *types.ConstraintViolationException
+	var throttled *types.TooManyRequestsException
+	var maxAccounts *types.ConstraintViolationException
 
+	var createResp *organizations.CreateAccountOutput
+	for attempt := 0; ; attempt++ {
+		createResp, err = c.client.CreateAccount(ctx, input)
 		if errors.As(err, &throttled) {
-			ctx.Info.Printf("Rate limited, waiting 5 seconds")
-			time.Sleep(5 * time.Second)
+			if err := c.backoff(ctx, attempt); err != nil {
+				return "", "", false, err
+			}
+			continue
 		} else if errors.As(err, &maxAccounts) {
-			ctx.Info.Printf("Max accounts reached")
-			break
+			return "", "", true, nil
 		} else if err != nil {
-			return fmt.Errorf("creating account: %s", err)
+			return "", "", false, fmt.Errorf("creating account: %s", err)
 		}
+		break
+	}
 
-		resp, err := svc.DescribeCreateAccountStatus(ctx, &organizations.DescribeCreateAccountStatusInput{
+	throttles := 0
+	for {
+		resp, err := c.client.DescribeCreateAccountStatus(ctx, &organizations.DescribeCreateAccountStatusInput{
 			CreateAccountRequestId: createResp.CreateAccountStatus.Id,
 		})
-		if err != nil {
-			return fmt.Errorf("describing account: %s", err)
-		}
-
-		for resp.CreateAccountStatus.State == types.CreateAccountStateInProgress {
-			time.Sleep(3 * time.Second)
-
-			resp, err = svc.DescribeCreateAccountStatus(ctx, &organizations.DescribeCreateAccountStatusInput{
-				CreateAccountRequestId: createResp.CreateAccountStatus.Id,
-			})
-			if err != nil {
-				return fmt.Errorf("describing account: %s", err)
+		if errors.As(err, &throttled) {
+			if err := c.backoff(ctx, throttles); err != nil {
+				return "", "", false, err
 			}
+			throttles++
+			continue
+		} else if err != nil {
+			return "", "", false, fmt.Errorf("describing account: %s", err)
 		}
+		throttles = 0
 
-		if resp.CreateAccountStatus.State == types.CreateAccountStateFailed {
-			return fmt.Errorf("account creation failed: %s", string(resp.CreateAccountStatus.FailureReason))
+		switch resp.CreateAccountStatus.State {
+		case types.CreateAccountStateInProgress:
+			ctx.Sleep(c.pollDelay)
+			if ctx.IsDone() {
+				return "", "", false, ctx.Err()
+			}
+		case types.CreateAccountStateFailed:
+			return "", "", false, fmt.Errorf("account creation failed: %s", string(resp.CreateAccountStatus.FailureReason))
+		default:
+			return aws.ToString(input.AccountName), aws.ToString(resp.CreateAccountStatus.AccountId), false, nil
 		}
+	}
+}
 
-		ctx.Info.Printf("Created account %s", *createResp.CreateAccountStatus.AccountName)
+// backoff waits before retrying a throttled call for the attempt'th time, starting at retryDelay and doubling each
+// attempt up to maxRetryDelay. Up to half of the delay is jitter, so the accounts being created at once don't all retry
+// together.
+func (c *accountCreator) backoff(ctx *utils.Context, attempt int) error {
+	delay := c.retryDelay
+	for i := 0; i < attempt && delay < c.maxRetryDelay; i++ {
+		delay *= 2
 	}
+	delay = min(delay, c.maxRetryDelay)
+	delay = delay/2 + rand.N(delay/2+1)
 
+	ctx.Info.Printf("Rate limited, waiting %s", delay.Round(time.Second))
+	ctx.Sleep(delay)
+	if ctx.IsDone() {
+		return ctx.Err()
+	}
 	return nil
 }
 
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Rough durations of each setup step, for estimating how long the plan takes to run. Accounts are created
+// createAccountConcurrency at a time, regions are enabled in every account at once, and plugins are set up
+// setupConcurrency at a time.
+const (
+	planCreateAccountTime = 2 * time.Minute
+	planEnableRegionsTime = 15 * time.Minute
+	planPluginSetupTime   = 3 * time.Second
+)
+
+// accountRegions are the regions of an account -setup would set up.
+type accountRegions struct {
+	accountId string
+	enabled   []string
+	disabled  []string
+}
+
+// setupPlan is what -setup would do, the steps already done in the setup state aren't included.
+type setupPlan struct {
+	accountId          string
+	createOrganization bool
+	// newAccounts are the role-scanning-account-numbers of the accounts -org would try to create.
+	newAccounts []int
+	// enableRegions are the disabled regions that would be enabled, by account ID.
+	enableRegions map[string][]string
+	// budgets are the IDs of the accounts the budget would be created or updated in, with budgetLimit in USD.
+	budgets     []string
+	budgetLimit string
+	budgetEmail string
+	// scp is whether the accounts -org created would be moved into ScanningOUName with SCPName attached.
+	scp       bool
+	resources []plannedResource
+}
+
+// plannedResource is the number of resources a plugin would create in an account and region.
+type plannedResource struct {
+	accountId string
+	region    string
+	plugin    string
+	resource  string
+	count     int
+}
+
+// SetupPlan prints what Setup would do without changing anything, only the read only calls needed to find the
+// accounts and their regions are made.
+func SetupPlan(ctx *utils.Context, opts SetupOpts) error {
+	registered, err := selectPlugins(opts.Plugins)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := utils.LoadConfig(ctx, opts.Profile,
+		config.WithRegion("us-east-1"),
+		config.WithRetryMode(aws.RetryModeAdaptive),
+		config.WithRetryMaxAttempts(10),
+	)
+	if err != nil {
+		return fmt.Errorf("loading config: %s", err)
+	}
+
+	info, err := utils.GetCallerInfo(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("getting caller info: %s", err)
+	}
+	state, err := loadSetupState(setupStatePath(aws.ToString(info.Account)))
+	if err != nil {
+		return fmt.Errorf("loading setup state: %s", err)
+	}
+
+	orgExists := true
+	if opts.Org {
+		var notInUse *types.AWSOrganizationsNotInUseException
+		if _, err := organizations.NewFromConfig(cfg).DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{}); errors.As(err, &notInUse) {
+			orgExists = false
+		} else if err != nil {
+			return fmt.Errorf("describing organization: %s", err)
+		}
+	}
+
+	accounts, err := utils.LoadAccounts(ctx, cfg, opts.AssumeRole)
+	if err != nil {
+		return fmt.Errorf("loading accounts: %s", err)
+	}
+	regions, err := loadAccountRegions(ctx, accounts, opts.Regions)
+	if err != nil {
+		return fmt.Errorf("loading regions: %s", err)
+	}
+
+	plan := newSetupPlan(state, opts, orgExists, regions, registered)
+	plan.accountId = aws.ToString(info.Account)
+	return writeSetupPlan(os.Stdout, plan)
+}
+
+// loadAccountRegions returns the enabled and disabled regions selection selects of each account, sorted by account
+// ID. No regions are disabled if it only selects the default regions, since setup doesn't enable any then.
+func loadAccountRegions(ctx *utils.Context, accounts map[string]utils.Account, selection utils.RegionSelection) ([]accountRegions, error) {
+	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	var result []accountRegions
+	var errs []error
+
+	for _, v := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			regions := accountRegions{accountId: v.AccountId}
+
+			enabled, err := utils.GetAllEnabledRegions(ctx, v.Svc.Account)
+			for _, region := range enabled {
+				if selection.Selects(region) {
+					regions.enabled = append(regions.enabled, aws.ToString(region.RegionName))
+				}
+			}
+			if err == nil {
+				disabled, disabledErr := utils.GetDisabledRegions(ctx, v.Svc.Account)
+				for _, region := range disabled {
+					if selection.Selects(region) {
+						regions.disabled = append(regions.disabled, aws.ToString(region.RegionName))
+					}
+				}
+				err = disabledErr
+			}
+
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s", v.AccountId, err))
+				return
+			}
+			result = append(result, regions)
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].accountId < result[j].accountId })
+	return result, nil
+}
+
+// newSetupPlan returns what setup would do with the regions of each account and the registered plugins, skipping what
+// state has as done.
+func newSetupPlan(state *setupState, opts SetupOpts, orgExists bool, accounts []accountRegions, registered []pluginInfo) setupPlan {
+	plan := setupPlan{createOrganization: opts.Org && !orgExists, scp: opts.SCP, enableRegions: map[string][]string{}}
+	if opts.Org {
+		for i := 1; i <= opts.accountLimit(); i++ {
+			if _, ok := state.createdAccount(i); !ok {
+				plan.newAccounts = append(plan.newAccounts, i)
+			}
+		}
+	}
+
+	if opts.Budget > 0 {
+		plan.budgetLimit, plan.budgetEmail = budgetLimit(opts.Budget), opts.BudgetEmail
+		for _, account := range accounts {
+			if state.budget(account.accountId) != plan.budgetLimit {
+				plan.budgets = append(plan.budgets, account.accountId)
+			}
+		}
+	}
+
+	for _, account := range accounts {
+		regions := slices.Clone(account.enabled)
+		if !state.regionsEnabled(account.accountId) && len(account.disabled) > 0 {
+			plan.enableRegions[account.accountId] = slices.Sorted(slices.Values(account.disabled))
+			regions = append(regions, account.disabled...)
+		}
+		slices.Sort(regions)
+
+		for _, region := range regions {
+			cfgKey := fmt.Sprintf("%s-%s", account.accountId, region)
+			cfg := utils.ThreadConfig{AccountId: account.accountId, Region: region, Config: aws.Config{Region: region}}
+			for _, p := range registered {
+				count := 0
+				for _, instance := range p.new(map[string]utils.ThreadConfig{cfgKey: cfg}, p.concurrency) {
+					if !state.pluginSetup(pluginStateKey(cfgKey, instance.Name())) {
+						count++
+					}
+				}
+				if count > 0 {
+					plan.resources = append(plan.resources, plannedResource{
+						accountId: account.accountId,
+						region:    region,
+						plugin:    p.name,
+						resource:  p.resource,
+						count:     count,
+					})
+				}
+			}
+		}
+	}
+	return plan
+}
+
+// resourceCount returns the total number of resources the plan creates.
+func (p setupPlan) resourceCount() int {
+	total := 0
+	for _, r := range p.resources {
+		total += r.count
+	}
+	return total
+}
+
+// estimate returns roughly how long the plan takes to run, without setting up the accounts it creates.
+func (p setupPlan) estimate() time.Duration {
+	accountBatches := (len(p.newAccounts) + createAccountConcurrency - 1) / createAccountConcurrency
+	d := time.Duration(accountBatches) * planCreateAccountTime
+	if len(p.enableRegions) > 0 {
+		d += planEnableRegionsTime
+	}
+	batches := (p.resourceCount() + setupConcurrency - 1) / setupConcurrency
+	return d + time.Duration(batches)*planPluginSetupTime
+}
+
+func writeSetupPlan(w io.Writer, plan setupPlan) error {
+	fmt.Fprintf(w, "Setup plan for %s, nothing is changed until -setup is run without -plan.\n\n", plan.accountId)
+	if plan.createOrganization {
+		fmt.Fprintf(w, "Create an organization with all features enabled.\n")
+	}
+	if len(plan.newAccounts) > 0 {
+		fmt.Fprintf(w, "Create up to %d accounts tagged role-scanning-account=true, until the organization's account limit is reached. Their regions are enabled and plugins set up in the same run, which isn't included below.\n",
+			len(plan.newAccounts))
+	}
+	if plan.scp {
+		fmt.Fprintf(w, "Move the accounts -org created into the %s OU and attach the %s service control policy.\n", ScanningOUName, SCPName)
+	}
+	if len(plan.budgets) > 0 {
+		fmt.Fprintf(w, "Create a %s USD monthly budget alerting %s in %d accounts: %s\n",
+			plan.budgetLimit, plan.budgetEmail, len(plan.budgets), strings.Join(plan.budgets, ", "))
+	}
+
+	accountIds := make([]string, 0, len(plan.enableRegions))
+	for accountId := range plan.enableRegions {
+		accountIds = append(accountIds, accountId)
+	}
+	slices.Sort(accountIds)
+	for _, accountId := range accountIds {
+		regions := plan.enableRegions[accountId]
+		fmt.Fprintf(w, "Enable %d regions in %s: %s\n", len(regions), accountId, strings.Join(regions, ", "))
+	}
+
+	if len(plan.resources) > 0 {
+		fmt.Fprintf(w, "Create %d plugin resources:\n\n", plan.resourceCount())
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ACCOUNT\tREGION\tPLUGIN\tCOUNT\tRESOURCE")
+		for _, r := range plan.resources {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", r.accountId, r.region, r.plugin, r.count, r.resource)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !plan.createOrganization && len(plan.newAccounts) == 0 && !plan.scp && len(plan.budgets) == 0 && len(plan.enableRegions) == 0 && len(plan.resources) == 0 {
+		_, err := fmt.Fprintf(w, "Nothing to do, setup is complete.\n")
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nEstimated time: %s\n", plan.estimate().Round(time.Minute))
+	return err
+}
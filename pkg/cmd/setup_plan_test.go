@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planTestPlugin is registered like sns, with concurrency instances in every region.
+var planTestPlugin = pluginInfo{name: "sns", resource: "SNS topic", concurrency: 2, new: func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+	var results []plugins.Plugin
+	for _, cfg := range cfgs {
+		for i := 0; i < concurrency; i++ {
+			results = append(results, &fakeSetupPlugin{name: fmt.Sprintf("sns-%s-%s-%d", cfg.AccountId, cfg.Region, i)})
+		}
+	}
+	return results
+}}
+
+func TestNewSetupPlan(t *testing.T) {
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-111111111111.json"))
+	require.NoError(t, err)
+	require.NoError(t, state.addAccount(1, "222222222222"))
+	require.NoError(t, state.setRegionsEnabled("222222222222"))
+	require.NoError(t, state.setPluginSetup(pluginStateKey("111111111111-us-east-1", "sns-111111111111-us-east-1-0")))
+
+	accounts := []accountRegions{
+		{accountId: "111111111111", enabled: []string{"us-east-1"}, disabled: []string{"me-south-1", "af-south-1"}},
+		// Regions disabled after setup enabled them are left alone.
+		{accountId: "222222222222", enabled: []string{"us-east-1"}, disabled: []string{"af-south-1"}},
+	}
+	plan := newSetupPlan(state, SetupOpts{Org: true}, false, accounts, []pluginInfo{planTestPlugin})
+
+	assert.True(t, plan.createOrganization)
+	require.Len(t, plan.newAccounts, MaxScanningAccounts-1, "accounts already created are skipped")
+	assert.Equal(t, 2, plan.newAccounts[0])
+	assert.Equal(t, map[string][]string{"111111111111": {"af-south-1", "me-south-1"}}, plan.enableRegions)
+	assert.Equal(t, []plannedResource{
+		{accountId: "111111111111", region: "af-south-1", plugin: "sns", resource: "SNS topic", count: 2},
+		{accountId: "111111111111", region: "me-south-1", plugin: "sns", resource: "SNS topic", count: 2},
+		{accountId: "111111111111", region: "us-east-1", plugin: "sns", resource: "SNS topic", count: 1},
+		{accountId: "222222222222", region: "us-east-1", plugin: "sns", resource: "SNS topic", count: 2},
+	}, plan.resources)
+	assert.Equal(t, 7, plan.resourceCount())
+	assert.Equal(t, 20*planCreateAccountTime+planEnableRegionsTime+planPluginSetupTime, plan.estimate())
+
+	limited := newSetupPlan(state, SetupOpts{Org: true, MaxAccounts: 3}, true, accounts, []pluginInfo{planTestPlugin})
+	assert.False(t, limited.createOrganization)
+	assert.Equal(t, []int{2, 3}, limited.newAccounts)
+
+	withoutOrg := newSetupPlan(state, SetupOpts{MaxAccounts: 3}, true, accounts, []pluginInfo{planTestPlugin})
+	assert.Empty(t, withoutOrg.newAccounts)
+}
+
+func TestNewSetupPlan_Budget(t *testing.T) {
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-111111111111.json"))
+	require.NoError(t, err)
+	require.NoError(t, state.setBudget("111111111111", "50.00"))
+
+	accounts := []accountRegions{{accountId: "111111111111"}, {accountId: "222222222222"}}
+	plan := newSetupPlan(state, SetupOpts{Budget: 50, BudgetEmail: "admin@example.com"}, true, accounts, nil)
+	assert.Equal(t, []string{"222222222222"}, plan.budgets, "budgets with the same limit are skipped")
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSetupPlan(&buf, plan))
+	assert.Contains(t, buf.String(), "Create a 50.00 USD monthly budget alerting admin@example.com in 1 accounts: 222222222222\n")
+
+	raised := newSetupPlan(state, SetupOpts{Budget: 75, BudgetEmail: "admin@example.com"}, true, accounts, nil)
+	assert.Equal(t, []string{"111111111111", "222222222222"}, raised.budgets)
+}
+
+func TestWriteSetupPlan(t *testing.T) {
+	plan := setupPlan{
+		accountId:     "111111111111",
+		enableRegions: map[string][]string{"111111111111": {"af-south-1", "me-south-1"}},
+		resources: []plannedResource{
+			{accountId: "111111111111", region: "af-south-1", plugin: "sns", resource: "SNS topic", count: 2},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSetupPlan(&buf, plan))
+	assert.Equal(t, "Setup plan for 111111111111, nothing is changed until -setup is run without -plan.\n\n"+
+		"Enable 2 regions in 111111111111: af-south-1, me-south-1\n"+
+		"Create 2 plugin resources:\n\n"+
+		"ACCOUNT       REGION      PLUGIN  COUNT  RESOURCE\n"+
+		"111111111111  af-south-1  sns     2      SNS topic\n"+
+		"\nEstimated time: "+(planEnableRegionsTime+planPluginSetupTime).Round(time.Minute).String()+"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeSetupPlan(&buf, setupPlan{accountId: "111111111111"}))
+	assert.Contains(t, buf.String(), "Nothing to do, setup is complete.\n")
+}
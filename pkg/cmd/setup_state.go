@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SetupStateDir is where the progress of -setup is saved, one file for each account it's run from.
+var SetupStateDir = "~/.roles"
+
+// setupState is the progress of -setup, saved after each step so running it again after a failure resumes where it
+// left off instead of creating accounts, enabling regions, and setting up plugins that are already done.
+type setupState struct {
+	mu   sync.Mutex
+	path string
+
+	// Accounts are the IDs of the accounts created by CreateAccounts, by role-scanning-account-number.
+	Accounts map[string]string `json:"accounts"`
+	// Regions are the IDs of the accounts that have all regions enabled.
+	Regions map[string]bool `json:"regions"`
+	// Plugins are the plugins that are set up, by pluginStateKey.
+	Plugins map[string]bool `json:"plugins"`
+	// Inventory is what the plugins created during setup, by pluginStateKey. -clean cleans these up instead of
+	// guessing which plugin instances the accounts and regions it loads have.
+	Inventory map[string]setupResources `json:"inventory"`
+	// Budgets are the limits of the budgets -budget created, by account ID.
+	Budgets map[string]string `json:"budgets,omitempty"`
+	// StackSet is whether the plugin resources were deployed with the StackSet, for -clean to delete it.
+	StackSet bool `json:"stackSet,omitempty"`
+}
+
+// setupResources are the resources a plugin instance created during setup, the account and region are where the plugin
+// makes its calls.
+type setupResources struct {
+	AccountId string   `json:"accountId"`
+	Region    string   `json:"region"`
+	Arns      []string `json:"arns,omitempty"`
+}
+
+// newSetupResources returns the inventory entry of plugin.
+func newSetupResources(plugin plugins.Plugin) setupResources {
+	accountId, region := plugins.Location(plugin)
+	return setupResources{AccountId: accountId, Region: region, Arns: plugins.ResourceArns(plugin)}
+}
+
+// setupStatePath returns the path of the setup state for the account -setup is run from.
+func setupStatePath(accountId string) string {
+	return filepath.Join(SetupStateDir, fmt.Sprintf("setup-%s.json", accountId))
+}
+
+// loadSetupState loads the setup state saved at path, there's nothing done yet if it doesn't exist.
+func loadSetupState(path string) (*setupState, error) {
+	state := &setupState{
+		path:      path,
+		Accounts:  map[string]string{},
+		Regions:   map[string]bool{},
+		Plugins:   map[string]bool{},
+		Inventory: map[string]setupResources{},
+		Budgets:   map[string]string{},
+	}
+
+	expanded, err := utils.ExpandPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("expanding path: %s", err)
+	}
+	data, err := os.ReadFile(expanded)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", path, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	// Saved before setup kept an inventory.
+	if state.Inventory == nil {
+		state.Inventory = map[string]setupResources{}
+	}
+	if state.Budgets == nil {
+		state.Budgets = map[string]string{}
+	}
+	return state, nil
+}
+
+// pluginStateKey identifies a plugin in the setup state, plugin names are only unique within a thread config.
+func pluginStateKey(cfgKey, name string) string {
+	return cfgKey + "/" + name
+}
+
+// createdAccount returns the ID of the account created with the given role-scanning-account-number.
+func (s *setupState) createdAccount(number int) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.Accounts[strconv.Itoa(number)]
+	return id, ok
+}
+
+// createdAccounts returns the IDs of the accounts created by CreateAccounts, sorted.
+func (s *setupState) createdAccounts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Values(s.Accounts))
+}
+
+func (s *setupState) addAccount(number int, accountId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Accounts[strconv.Itoa(number)] = accountId
+	return s.save()
+}
+
+func (s *setupState) regionsEnabled(accountId string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Regions[accountId]
+}
+
+func (s *setupState) setRegionsEnabled(accountId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Regions[accountId] = true
+	return s.save()
+}
+
+func (s *setupState) pluginSetup(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Plugins[key]
+}
+
+func (s *setupState) setPluginSetup(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Plugins[key] = true
+	return s.save()
+}
+
+// setPluginCreated marks the plugin at key as set up and adds the resources it created to the inventory.
+func (s *setupState) setPluginCreated(key string, resources setupResources) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Plugins[key] = true
+	s.Inventory[key] = resources
+	return s.save()
+}
+
+func (s *setupState) inventoried(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Inventory[key]
+	return ok
+}
+
+// inventory returns a copy of the inventory, by pluginStateKey.
+func (s *setupState) inventory() map[string]setupResources {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.Inventory)
+}
+
+// forgetPlugin removes the plugin at key from the state and the inventory after it's cleaned up.
+func (s *setupState) forgetPlugin(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Plugins, key)
+	delete(s.Inventory, key)
+	return s.save()
+}
+
+// forgetUninventoried removes the plugins that are set up without resources in the inventory, the ones the StackSet
+// created, after the StackSet is deleted.
+func (s *setupState) forgetUninventoried() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range slices.Collect(maps.Keys(s.Plugins)) {
+		if _, ok := s.Inventory[key]; !ok {
+			delete(s.Plugins, key)
+		}
+	}
+	return s.save()
+}
+
+// budget returns the limit of the budget created in accountId, or an empty string if there isn't one.
+func (s *setupState) budget(accountId string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Budgets[accountId]
+}
+
+// setBudget saves the limit of the budget in accountId, an empty limit removes it.
+func (s *setupState) setBudget(accountId string, limit string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limit == "" {
+		delete(s.Budgets, accountId)
+	} else {
+		s.Budgets[accountId] = limit
+	}
+	return s.save()
+}
+
+func (s *setupState) usedStackSet() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.StackSet
+}
+
+func (s *setupState) setStackSet(used bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.StackSet = used
+	return s.save()
+}
+
+// forgetPlugins removes the plugins of cfgs from the state after they're cleaned up, so -setup creates them again.
+func (s *setupState) forgetPlugins(cfgs map[string]utils.ThreadConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.Plugins {
+		cfgKey, _, _ := strings.Cut(key, "/")
+		if _, ok := cfgs[cfgKey]; ok {
+			delete(s.Plugins, key)
+			delete(s.Inventory, key)
+		}
+	}
+	return s.save()
+}
+
+// remove deletes the saved state, after the accounts it has are closed.
+func (s *setupState) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, err := utils.ExpandPath(s.path)
+	if err != nil {
+		return fmt.Errorf("expanding path: %s", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.Accounts, s.Regions, s.Plugins = map[string]string{}, map[string]bool{}, map[string]bool{}
+	s.Inventory = map[string]setupResources{}
+	s.Budgets = map[string]string{}
+	s.StackSet = false
+	return nil
+}
+
+// save writes the state to its path, the caller must hold mu.
+func (s *setupState) save() error {
+	dir, err := utils.ExpandPath(filepath.Dir(s.path))
+	if err != nil {
+		return fmt.Errorf("expanding path: %s", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating %s: %s", dir, err)
+	}
+
+	f, err := utils.CreateAtomic(s.path)
+	if err != nil {
+		return fmt.Errorf("creating %s: %s", s.path, err)
+	}
+	defer f.Abort()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return fmt.Errorf("writing %s: %w", s.path, err)
+	}
+	if err := f.Commit(); err != nil {
+		return fmt.Errorf("saving %s: %s", s.path, err)
+	}
+	return nil
+}
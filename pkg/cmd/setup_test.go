@@ -1,2 +1,338 @@
 package cmd
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSetupPlugin is a plugin that counts calls to Setup and CleanUp, failing while fail is set.
+type fakeSetupPlugin struct {
+	plugins.Plugin
+	name string
+	arns []string
+
+	mu       sync.Mutex
+	fail     bool
+	setups   int
+	cleanups int
+}
+
+func (p *fakeSetupPlugin) Name() string { return p.name }
+
+func (p *fakeSetupPlugin) Setup(ctx *utils.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setups++
+	if p.fail {
+		return errors.New("access denied")
+	}
+	return nil
+}
+
+func (p *fakeSetupPlugin) CleanUp(ctx *utils.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cleanups++
+	if p.fail {
+		return errors.New("access denied")
+	}
+	return nil
+}
+
+func (p *fakeSetupPlugin) ResourceArns() []string { return p.arns }
+
+func (p *fakeSetupPlugin) Location() (string, string) { return "111111111111", "us-east-1" }
+
+func TestSetupState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "setup-123456789012.json")
+
+	state, err := loadSetupState(path)
+	require.NoError(t, err, "there's nothing done yet when no state is saved")
+	_, ok := state.createdAccount(1)
+	assert.False(t, ok)
+
+	require.NoError(t, state.addAccount(1, "111111111111"))
+	require.NoError(t, state.setRegionsEnabled("111111111111"))
+	require.NoError(t, state.setPluginSetup(pluginStateKey("111111111111-us-east-1", "sns-111111111111-us-east-1-0")))
+	require.NoError(t, state.setPluginSetup(pluginStateKey("111111111111-us-west-2", "sns-111111111111-us-west-2-0")))
+
+	loaded, err := loadSetupState(path)
+	require.NoError(t, err)
+	id, ok := loaded.createdAccount(1)
+	assert.True(t, ok)
+	assert.Equal(t, "111111111111", id)
+	assert.True(t, loaded.regionsEnabled("111111111111"))
+	assert.False(t, loaded.regionsEnabled("222222222222"))
+	assert.True(t, loaded.pluginSetup(pluginStateKey("111111111111-us-east-1", "sns-111111111111-us-east-1-0")))
+
+	// Cleaning up one region only forgets the plugins set up in it.
+	require.NoError(t, loaded.forgetPlugins(map[string]utils.ThreadConfig{"111111111111-us-east-1": {}}))
+	loaded, err = loadSetupState(path)
+	require.NoError(t, err)
+	assert.False(t, loaded.pluginSetup(pluginStateKey("111111111111-us-east-1", "sns-111111111111-us-east-1-0")))
+	assert.True(t, loaded.pluginSetup(pluginStateKey("111111111111-us-west-2", "sns-111111111111-us-west-2-0")))
+
+	require.NoError(t, loaded.remove())
+	loaded, err = loadSetupState(path)
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Accounts)
+}
+
+func TestSetupState_Inventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "setup-123456789012.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"plugins": {"a/sns-0": true, "a/sqs-0": true}}`), 0600))
+
+	state, err := loadSetupState(path)
+	require.NoError(t, err, "state saved before setup kept an inventory")
+	assert.Empty(t, state.inventory())
+
+	resources := setupResources{AccountId: "111111111111", Region: "us-east-1", Arns: []string{"arn:aws:sns:us-east-1:111111111111:topic"}}
+	require.NoError(t, state.setPluginCreated("a/sns-0", resources))
+	loaded, err := loadSetupState(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]setupResources{"a/sns-0": resources}, loaded.inventory())
+
+	// Deleting the StackSet forgets the plugins it set up, the ones in the inventory are still there to clean up.
+	require.NoError(t, loaded.forgetUninventoried())
+	assert.Equal(t, map[string]bool{"a/sns-0": true}, loaded.Plugins)
+
+	require.NoError(t, loaded.forgetPlugin("a/sns-0"))
+	loaded, err = loadSetupState(path)
+	require.NoError(t, err)
+	assert.Empty(t, loaded.Plugins)
+	assert.Empty(t, loaded.inventory())
+}
+
+func TestSetupPlugins_Inventory(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-123456789012.json"))
+	require.NoError(t, err)
+	require.NoError(t, state.setPluginSetup("a/sqs-0"))
+
+	ps := map[string]plugins.Plugin{
+		"a/sns-0": &fakeSetupPlugin{name: "sns-0", arns: []string{"arn:aws:sns:us-east-1:111111111111:topic"}},
+		"a/sqs-0": &fakeSetupPlugin{name: "sqs-0", arns: []string{"arn:aws:sqs:us-east-1:111111111111:queue"}},
+	}
+	require.NoError(t, setupPlugins(ctx, ps, state))
+	assert.Equal(t, map[string]setupResources{
+		"a/sns-0": {AccountId: "111111111111", Region: "us-east-1", Arns: []string{"arn:aws:sns:us-east-1:111111111111:topic"}},
+		// Set up before there was an inventory, it's added without setting it up again.
+		"a/sqs-0": {AccountId: "111111111111", Region: "us-east-1", Arns: []string{"arn:aws:sqs:us-east-1:111111111111:queue"}},
+	}, state.inventory())
+	assert.Equal(t, 0, ps["a/sqs-0"].(*fakeSetupPlugin).setups)
+}
+
+func TestSetupPlugins_Resume(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-123456789012.json"))
+	require.NoError(t, err)
+
+	ok := &fakeSetupPlugin{name: "sns-1"}
+	failing := &fakeSetupPlugin{name: "sqs-1", fail: true}
+	ps := map[string]plugins.Plugin{"a/sns-1": ok, "a/sqs-1": failing}
+
+	err = setupPlugins(ctx, ps, state)
+	assert.ErrorContains(t, err, "1 of 2 plugins failed")
+	assert.ErrorContains(t, err, "sqs-1: access denied")
+	assert.Equal(t, 1, ok.setups, "one plugin failing doesn't stop the others")
+
+	// Running setup again only retries the plugin that failed.
+	failing.fail = false
+	require.NoError(t, setupPlugins(ctx, ps, state))
+	assert.Equal(t, 1, ok.setups)
+	assert.Equal(t, 2, failing.setups)
+
+	require.NoError(t, setupPlugins(ctx, ps, state))
+	assert.Equal(t, 2, failing.setups)
+}
+
+func TestSelectPlugins(t *testing.T) {
+	selected, err := selectPlugins(nil)
+	require.NoError(t, err)
+	assert.Equal(t, len(registeredPlugins), len(selected))
+
+	// In the order they're registered.
+	selected, err = selectPlugins([]string{"sqs", "sns"})
+	require.NoError(t, err)
+	require.Len(t, selected, 2)
+	assert.Equal(t, "sns", selected[0].name)
+	assert.Equal(t, "sqs", selected[1].name)
+
+	_, err = selectPlugins([]string{"sns", "dynamodb"})
+	assert.ErrorContains(t, err, "unknown plugin dynamodb")
+}
+
+func TestSetupRegistered(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup.json"))
+	require.NoError(t, err)
+
+	sns, err := selectPlugins([]string{"sns"})
+	require.NoError(t, err)
+	sns[0].new = func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+		return []plugins.Plugin{&fakeSetupPlugin{name: "sns-0"}}
+	}
+	cfgs := map[string]utils.ThreadConfig{"111111111111-us-east-1": {AccountId: "111111111111", Region: "us-east-1"}}
+	require.NoError(t, setupRegistered(ctx, sns, cfgs, state))
+
+	// Only the chosen plugins are set up.
+	assert.Equal(t, map[string]bool{pluginStateKey("111111111111-us-east-1", "sns-0"): true}, state.Plugins)
+}
+
+func TestNewRegionSelection(t *testing.T) {
+	selection, err := NewRegionSelection(nil)
+	require.NoError(t, err)
+	assert.True(t, selection.All())
+
+	selection, err = NewRegionSelection([]string{""})
+	require.NoError(t, err)
+	assert.True(t, selection.All())
+
+	selection, err = NewRegionSelection([]string{"us-east-1", " us-west-2"})
+	require.NoError(t, err)
+	assert.Equal(t, utils.RegionSelection{Regions: []string{"us-east-1", "us-west-2"}}, selection)
+
+	selection, err = NewRegionSelection([]string{DefaultRegions})
+	require.NoError(t, err)
+	assert.Equal(t, utils.RegionSelection{DefaultOnly: true}, selection)
+
+	_, err = NewRegionSelection([]string{"us-east-7"})
+	assert.ErrorContains(t, err, "unknown region us-east-7")
+	_, err = NewRegionSelection([]string{DefaultRegions, "us-east-1"})
+	assert.ErrorContains(t, err, "cannot use default with other regions")
+}
+
+// mockAccountCreator creates accounts until limit are created, throttling the first throttles calls. Accounts named in
+// fail fail to be created, creation is described as in progress once before it's done. If holdFull is set, the calls
+// over the limit are held until holdFull of them were made, so that many are in flight when the limit is reached.
+type mockAccountCreator struct {
+	mu        sync.Mutex
+	limit     int
+	throttles int
+	fail      map[string]bool
+	holdFull  int
+	fullCalls int
+	released  chan struct{}
+
+	calls     int
+	created   int
+	requests  map[string]*organizations.CreateAccountInput
+	described map[string]int
+}
+
+func (m *mockAccountCreator) CreateAccount(ctx context.Context, params *organizations.CreateAccountInput, optFns ...func(*organizations.Options)) (*organizations.CreateAccountOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls++
+	if m.calls <= m.throttles {
+		return nil, &orgtypes.TooManyRequestsException{}
+	}
+	if m.created >= m.limit {
+		m.fullCalls++
+		if m.fullCalls == m.holdFull {
+			close(m.released)
+		}
+		if m.holdFull > 0 {
+			m.mu.Unlock()
+			select {
+			case <-m.released:
+			case <-time.After(5 * time.Second):
+			}
+			m.mu.Lock()
+		}
+		return nil, &orgtypes.ConstraintViolationException{Reason: orgtypes.ConstraintViolationExceptionReasonAccountNumberLimitExceeded}
+	}
+	m.created++
+	id := fmt.Sprintf("request-%d", m.created)
+	m.requests[id] = params
+	return &organizations.CreateAccountOutput{CreateAccountStatus: &orgtypes.CreateAccountStatus{Id: aws.String(id), AccountName: params.AccountName}}, nil
+}
+
+func (m *mockAccountCreator) DescribeCreateAccountStatus(ctx context.Context, params *organizations.DescribeCreateAccountStatusInput, optFns ...func(*organizations.Options)) (*organizations.DescribeCreateAccountStatusOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := aws.ToString(params.CreateAccountRequestId)
+	m.described[id]++
+
+	status := &orgtypes.CreateAccountStatus{State: orgtypes.CreateAccountStateInProgress}
+	number := aws.ToString(m.requests[id].Tags[1].Value)
+	if m.fail[number] {
+		status = &orgtypes.CreateAccountStatus{State: orgtypes.CreateAccountStateFailed, FailureReason: orgtypes.CreateAccountFailureReasonEmailAlreadyExists}
+	} else if m.described[id] > 1 {
+		status = &orgtypes.CreateAccountStatus{State: orgtypes.CreateAccountStateSucceeded, AccountId: aws.String(fmt.Sprintf("%012s", number))}
+	}
+	return &organizations.DescribeCreateAccountStatusOutput{CreateAccountStatus: status}, nil
+}
+
+func newMockAccountCreator(limit int) *mockAccountCreator {
+	return &mockAccountCreator{limit: limit, released: make(chan struct{}), requests: map[string]*organizations.CreateAccountInput{}, described: map[string]int{}}
+}
+
+func TestAccountCreator_CreateAll(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-123456789012.json"))
+	require.NoError(t, err)
+	require.NoError(t, state.addAccount(2, "000000000002"))
+
+	client := newMockAccountCreator(10)
+	client.throttles = 3
+	c := &accountCreator{client: client, concurrency: 3}
+	require.NoError(t, c.createAll(ctx, "admin@example.com", SetupOpts{MaxAccounts: 6}, state))
+
+	// Throttled accounts are retried, the ones already created are skipped.
+	assert.Equal(t, 5, client.created)
+	for i := 1; i <= 6; i++ {
+		id, ok := state.createdAccount(i)
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprintf("%012d", i), id)
+	}
+	for id, n := range client.described {
+		assert.Equal(t, 2, n, "%s is waited for until it's created", id)
+	}
+}
+
+func TestAccountCreator_CreateAllLimit(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	state, err := loadSetupState(filepath.Join(t.TempDir(), "setup-123456789012.json"))
+	require.NoError(t, err)
+
+	client := newMockAccountCreator(4)
+	client.fail = map[string]bool{"1": true}
+	client.holdFull = 2
+	c := &accountCreator{client: client, concurrency: 2}
+	err = c.createAll(ctx, "admin@example.com", SetupOpts{MaxAccounts: 20}, state)
+	assert.ErrorContains(t, err, "1 accounts failed")
+	assert.ErrorContains(t, err, "account 1: account creation failed: EMAIL_ALREADY_EXISTS")
+
+	// One account failing doesn't stop the others, no more are created once the organization is full. Only the
+	// workers already creating an account when the limit is reached make another call.
+	assert.Equal(t, 4, client.created)
+	assert.Len(t, state.Accounts, 3)
+	assert.Equal(t, 4+c.concurrency, client.calls)
+}
+
+func TestAccountCreator_Backoff(t *testing.T) {
+	ctx, cancel := utils.NewContext(context.Background()).WithCancel()
+	c := &accountCreator{retryDelay: time.Millisecond, maxRetryDelay: 4 * time.Millisecond}
+
+	start := time.Now()
+	require.NoError(t, c.backoff(ctx, 10))
+	assert.GreaterOrEqual(t, time.Since(start), 2*time.Millisecond, "at least half the capped delay")
+
+	cancel()
+	assert.Error(t, c.backoff(ctx, 0))
+}
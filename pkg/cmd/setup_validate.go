@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+)
+
+// canaryResult is the canary scan of the instances of a plugin in an account and region, err is the first instance
+// that failed it.
+type canaryResult struct {
+	accountId string
+	region    string
+	plugin    string
+	err       error
+}
+
+// canaryArns returns the ARNs the canary scan of plugin in accountId expects to exist and not to exist, the account's
+// own root and a randomly named principal of a type plugin validates. missing is empty if plugin doesn't validate
+// roles or users.
+func canaryArns(plugin plugins.Plugin, accountId string) (exists string, missing string) {
+	name := plugins.ResourcePrefix + "canary-" + utils.RandStringRunes(16)
+	types := plugins.PrincipalTypes(plugin)
+	if slices.Contains(types, plugins.PrincipalRole) {
+		missing = fmt.Sprintf("arn:aws:iam::%s:role/%s", accountId, name)
+	} else if slices.Contains(types, plugins.PrincipalUser) {
+		missing = fmt.Sprintf("arn:aws:iam::%s:user/%s", accountId, name)
+	}
+	return utils.GetRootArn(accountId), missing
+}
+
+// scanCanary checks that plugin correctly reports the canaryArns of accountId as existing and not existing.
+func scanCanary(ctx *utils.Context, plugin plugins.Plugin, accountId string) error {
+	exists, missing := canaryArns(plugin, accountId)
+	if found, err := plugin.ScanArn(ctx, exists); err != nil {
+		return fmt.Errorf("%s: scanning %s: %s", plugin.Name(), exists, err)
+	} else if !found {
+		return fmt.Errorf("%s: reported %s doesn't exist", plugin.Name(), exists)
+	}
+	if missing == "" {
+		return nil
+	}
+	if found, err := plugin.ScanArn(ctx, missing); err != nil {
+		return fmt.Errorf("%s: scanning %s: %s", plugin.Name(), missing, err)
+	} else if found {
+		return fmt.Errorf("%s: reported %s exists", plugin.Name(), missing)
+	}
+	return nil
+}
+
+// runCanaries runs the canary scan through every instance of the registered plugins in each thread config, the
+// instances of a plugin in an account and region are scanned one after another and setupConcurrency plugins at a time.
+// It returns the result of each plugin in each account and region, ordered by account and region.
+func runCanaries(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig) []canaryResult {
+	var results []canaryResult
+	m := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	concurrent := make(chan int, setupConcurrency)
+
+	for key, cfg := range cfgs {
+		// Plugins are loaded one thread config at a time so each instance's account and region is known.
+		for i, instances := range loadPlugins(registered, map[string]utils.ThreadConfig{key: cfg}) {
+			if len(instances) == 0 {
+				continue
+			}
+
+			wg.Add(1)
+			concurrent <- 1
+			go func() {
+				defer func() {
+					wg.Done()
+					<-concurrent
+				}()
+				result := canaryResult{accountId: cfg.AccountId, region: cfg.Region, plugin: registered[i].name}
+				for _, instance := range instances {
+					if result.err = scanCanary(ctx, instance, cfg.AccountId); result.err != nil {
+						break
+					}
+				}
+				m.Lock()
+				results = append(results, result)
+				m.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	slices.SortFunc(results, func(a, b canaryResult) int {
+		return cmp.Or(cmp.Compare(a.accountId, b.accountId), cmp.Compare(a.region, b.region), cmp.Compare(a.plugin, b.plugin))
+	})
+	return results
+}
+
+// writeCanaryMatrix writes the canary results as a matrix of accounts and regions by the registered plugins, each
+// plugin is ok, FAIL, or - if it isn't in that region. The errors of the failed plugins are listed below it.
+func writeCanaryMatrix(w io.Writer, registered []pluginInfo, results []canaryResult) error {
+	type row struct{ accountId, region string }
+	var rows []row
+	cells := map[row]map[string]canaryResult{}
+	for _, r := range results {
+		key := row{r.accountId, r.region}
+		if cells[key] == nil {
+			rows = append(rows, key)
+			cells[key] = map[string]canaryResult{}
+		}
+		cells[key][r.plugin] = r
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "ACCOUNT\tREGION")
+	for _, p := range registered {
+		fmt.Fprintf(tw, "\t%s", p.name)
+	}
+	fmt.Fprintln(tw)
+
+	var failed []canaryResult
+	for _, key := range rows {
+		fmt.Fprintf(tw, "%s\t%s", key.accountId, key.region)
+		for _, p := range registered {
+			r, ok := cells[key][p.name]
+			if !ok {
+				fmt.Fprint(tw, "\t-")
+			} else if r.err != nil {
+				fmt.Fprint(tw, "\tFAIL")
+				failed = append(failed, r)
+			} else {
+				fmt.Fprint(tw, "\tok")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, r := range failed {
+		if _, err := fmt.Fprintf(w, "%s %s: %s\n", r.accountId, r.region, r.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSetup runs a canary scan through every instance of the registered plugins in each thread config, checking
+// that the account's root is reported to exist and a randomly named role doesn't, and writes a matrix of the results to
+// w. Regions where setup didn't finish or a plugin doesn't work are caught before a real scan relies on them, running
+// -setup again retries the plugins that failed to set up.
+func validateSetup(ctx *utils.Context, registered []pluginInfo, cfgs map[string]utils.ThreadConfig, w io.Writer) error {
+	ctx.Info.Printf("Running the canary scan in %d regions", len(cfgs))
+	results := runCanaries(ctx, registered, cfgs)
+	if err := writeCanaryMatrix(w, registered, results); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %s", r.accountId, r.region, r.err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d plugins failed the canary scan: %w", len(errs), len(results), errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// canaryPlugin reports root ARNs as existing, or every ARN if broken is set, failing with err if it's set.
+type canaryPlugin struct {
+	plugins.Plugin
+	name   string
+	broken bool
+	err    error
+}
+
+func (p *canaryPlugin) Name() string { return p.name }
+
+func (p *canaryPlugin) ScanArn(ctx *utils.Context, arn string) (bool, error) {
+	return p.broken || strings.HasSuffix(arn, ":root"), p.err
+}
+
+func TestValidateSetup(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	cfgs := map[string]utils.ThreadConfig{
+		"111111111111-us-east-1": {AccountId: "111111111111", Region: "us-east-1"},
+		"111111111111-us-west-2": {AccountId: "111111111111", Region: "us-west-2"},
+	}
+	registered := []pluginInfo{
+		{name: "sns", new: func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+			var result []plugins.Plugin
+			for _, cfg := range cfgs {
+				// The second instance in us-west-2 reports every ARN as existing.
+				result = append(result, &canaryPlugin{name: "sns-0"}, &canaryPlugin{name: "sns-1", broken: cfg.Region == "us-west-2"})
+			}
+			return result
+		}},
+		{name: "ecr-public", new: func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+			var result []plugins.Plugin
+			for _, cfg := range cfgs {
+				if cfg.Region == "us-east-1" {
+					result = append(result, &canaryPlugin{name: "ecr-public-0", err: errors.New("access denied")})
+				}
+			}
+			return result
+		}},
+	}
+
+	var b strings.Builder
+	err := validateSetup(ctx, registered, cfgs, &b)
+	assert.ErrorContains(t, err, "2 of 3 plugins failed the canary scan")
+	assert.Equal(t, `ACCOUNT       REGION     sns   ecr-public
+111111111111  us-east-1  ok    FAIL
+111111111111  us-west-2  FAIL  -
+
+111111111111 us-east-1: ecr-public-0: scanning arn:aws:iam::111111111111:root: access denied
+`, b.String()[:strings.LastIndex(b.String(), "111111111111 us-west-2")])
+	assert.Regexp(t, `111111111111 us-west-2: sns-1: reported arn:aws:iam::111111111111:role/role-fh9283f-canary-\w+ exists\n$`, b.String())
+
+	registered = registered[:1]
+	registered[0].new = func(cfgs map[string]utils.ThreadConfig, concurrency int) []plugins.Plugin {
+		return []plugins.Plugin{&canaryPlugin{name: "sns-0"}}
+	}
+	b.Reset()
+	require.NoError(t, validateSetup(ctx, registered, cfgs, &b))
+	assert.NotContains(t, b.String(), "FAIL")
+}
+
+func TestCanaryArns(t *testing.T) {
+	exists, missing := canaryArns(&canaryPlugin{}, "111111111111")
+	assert.Equal(t, "arn:aws:iam::111111111111:root", exists)
+	assert.Regexp(t, `^arn:aws:iam::111111111111:role/role-fh9283f-canary-\w{16}$`, missing)
+}
@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookTokenEnv is the environment variable webhook sinks read a bearer token from.
+const WebhookTokenEnv = "WEBHOOK_TOKEN"
+
+// sinkFlushInterval is how often sinks are flushed while a scan runs.
+const sinkFlushInterval = 5 * time.Second
+
+// Finding is a scan result sent to sinks, the principal may or may not exist.
+type Finding struct {
+	Arn  string
+	Info utils.Info
+}
+
+// Sink is somewhere the results of a scan go. Write is called with each result as it's found, Flush every few seconds
+// while the scan runs so sinks that batch results can send them, and Close once the scan is finished and its results
+// are stored. Flush can be called while Write is.
+type Sink interface {
+	Write(ctx *utils.Context, finding Finding) error
+	Flush(ctx *utils.Context) error
+	Close(ctx *utils.Context) error
+}
+
+// sinkOptions are what sinks are created with, format, interval, and telemetry can be overridden by the sink's query
+// string.
+type sinkOptions struct {
+	cfg aws.Config
+	// name is the name of the scan, and run is the run the results are from without its end and findings, which sinks
+	// count themselves.
+	name string
+	run  scanner.Run
+	// format is the -output format of sinks that write result files.
+	format   string
+	arnsOnly bool
+	// hold keeps stdout output until the sink is closed, for while the terminal UI has the screen.
+	hold bool
+	// interval is how often s3 sinks upload partial results, they're only uploaded when the scan finishes if it's 0.
+	interval  time.Duration
+	telemetry bool
+	monitor   *scanner.Monitor
+}
+
+type sinkInfo struct {
+	scheme string
+	// remote sinks only log failures to write or flush results rather than stopping the scan, results are still stored
+	// and written to the local sinks.
+	remote bool
+	// options are the query string options the sink accepts.
+	options []string
+	// new creates a sink for target, which is the sink's URI without the scheme for local sinks and the URL to send
+	// results to for remote ones.
+	new func(ctx *utils.Context, target string, opts sinkOptions) (Sink, error)
+}
+
+// registeredSinks are the sinks that can be given with -sinks, by the scheme of their URI.
+//
+// Add new sinks here.
+var registeredSinks = []sinkInfo{
+	{scheme: "stdout", options: []string{"format"}, new: newStdoutSink},
+	{scheme: "file", options: []string{"format"}, new: newFileSink},
+	{scheme: "webhook", remote: true, new: newWebhookSink},
+	{scheme: "s3", remote: true, options: []string{"format", "interval"}, new: newS3Sink},
+	{scheme: "elasticsearch", remote: true, options: []string{"telemetry"}, new: newElasticsearchSink},
+}
+
+// sinkSchemes returns the schemes of registeredSinks for usage and error messages.
+func sinkSchemes() string {
+	var schemes []string
+	for _, info := range registeredSinks {
+		schemes = append(schemes, info.scheme)
+	}
+	return strings.Join(schemes, ", ")
+}
+
+// namedSink is a sink with the registered sink it was created from.
+type namedSink struct {
+	Sink
+	info sinkInfo
+}
+
+// newSink returns the sink for uri, which is stdout, file://<path>, webhook+<url>, s3://<bucket>/<prefix>, or
+// elasticsearch+<url>.
+func newSink(ctx *utils.Context, uri string, opts sinkOptions) (namedSink, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		// Like stdout?format=csv.
+		var query string
+		scheme, query, _ = strings.Cut(uri, "?")
+		rest = "?" + query
+	}
+	scheme, inner, wrapped := strings.Cut(scheme, "+")
+
+	var info *sinkInfo
+	for i := range registeredSinks {
+		if registeredSinks[i].scheme == scheme {
+			info = &registeredSinks[i]
+		}
+	}
+	if info == nil {
+		return namedSink{}, fmt.Errorf("unknown sink %q: must be one of %s", uri, sinkSchemes())
+	}
+
+	// Remote sinks wrap the URL results are sent to, which keeps its own query string.
+	target := rest
+	switch {
+	case wrapped:
+		target = inner + "://" + rest
+	case scheme == "s3":
+		target = uri
+	}
+	if len(info.options) > 0 {
+		var query string
+		target, query, _ = strings.Cut(target, "?")
+		if err := applySinkQuery(&opts, *info, query); err != nil {
+			return namedSink{}, fmt.Errorf("invalid sink %q: %s", uri, err)
+		}
+	}
+
+	sink, err := info.new(ctx, target, opts)
+	if err != nil {
+		return namedSink{}, err
+	}
+	return namedSink{Sink: sink, info: *info}, nil
+}
+
+func applySinkQuery(opts *sinkOptions, info sinkInfo, query string) error {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return err
+	}
+	for key := range values {
+		if !slices.Contains(info.options, key) {
+			return fmt.Errorf("unknown option %q, %s sinks accept %s", key, info.scheme, strings.Join(info.options, ", "))
+		}
+	}
+	if values.Has("format") {
+		opts.format = values.Get("format")
+	}
+	if values.Has("interval") {
+		if opts.interval, err = time.ParseDuration(values.Get("interval")); err != nil {
+			return fmt.Errorf("invalid interval: %s", err)
+		}
+	}
+	if values.Has("telemetry") {
+		if opts.telemetry, err = strconv.ParseBool(values.Get("telemetry")); err != nil {
+			return fmt.Errorf("invalid telemetry: %s", err)
+		}
+	}
+	return nil
+}
+
+// sinkSet sends the results of a run to each of its sinks.
+type sinkSet []namedSink
+
+func (s sinkSet) Write(ctx *utils.Context, finding Finding) error {
+	for _, sink := range s {
+		if err := sink.Write(ctx, finding); err != nil {
+			if !sink.info.remote {
+				return err
+			}
+			ctx.Error.Printf("%s sink: %s", sink.info.scheme, err)
+		}
+	}
+	return nil
+}
+
+func (s sinkSet) Flush(ctx *utils.Context) error {
+	for _, sink := range s {
+		if err := sink.Flush(ctx); err != nil {
+			if !sink.info.remote {
+				return err
+			}
+			ctx.Error.Printf("%s sink: %s", sink.info.scheme, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every sink, even when some of them fail.
+func (s sinkSet) Close(ctx *utils.Context) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %s", sink.info.scheme, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Abort discards what sinks that only write once closed have so far, it's deferred so a failed scan doesn't replace
+// its output file and does nothing once the sinks are closed.
+func (s sinkSet) Abort() {
+	for _, sink := range s {
+		if a, ok := sink.Sink.(interface{ Abort() }); ok {
+			a.Abort()
+		}
+	}
+}
+
+// FlushEvery flushes the sinks every interval until the returned func is called, failures are logged.
+func (s sinkSet) FlushEvery(ctx *utils.Context, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.Flush(ctx); err != nil {
+					ctx.Error.Printf("flushing results: %s", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// writerSink writes results in an -output format to stdout or a file.
+type writerSink struct {
+	results *resultWriter
+	// held is the output kept for stdout until the sink is closed.
+	held *bytes.Buffer
+	file *utils.AtomicFile
+	path string
+}
+
+func newStdoutSink(ctx *utils.Context, target string, opts sinkOptions) (Sink, error) {
+	s := &writerSink{}
+	var w io.Writer = os.Stdout
+	if opts.hold {
+		s.held = &bytes.Buffer{}
+		w = s.held
+	}
+	results, err := newResultWriter(w, opts.format)
+	if err != nil {
+		return nil, err
+	}
+	results.arnsOnly = opts.arnsOnly
+	s.results = results
+	return s, nil
+}
+
+// newFileSink returns a sink for the file at target, results written to it only replace it once the scan finishes so
+// an interrupted scan leaves it as it was.
+func newFileSink(ctx *utils.Context, target string, opts sinkOptions) (Sink, error) {
+	if target == "" {
+		return nil, fmt.Errorf("file sinks need a path: file:///path/to/results")
+	}
+	file, err := utils.CreateAtomic(target)
+	if err != nil {
+		return nil, fmt.Errorf("creating output file: %s", err)
+	}
+	results, err := newResultWriter(file, opts.format)
+	if err != nil {
+		file.Abort()
+		return nil, err
+	}
+	results.arnsOnly = opts.arnsOnly
+	return &writerSink{results: results, file: file, path: target}, nil
+}
+
+func (s *writerSink) Write(ctx *utils.Context, finding Finding) error {
+	return s.results.Write(finding.Arn, finding.Info)
+}
+
+// Flush does nothing, results are written as they're found.
+func (s *writerSink) Flush(ctx *utils.Context) error {
+	return nil
+}
+
+func (s *writerSink) Close(ctx *utils.Context) error {
+	if s.held != nil {
+		_, err := io.Copy(os.Stdout, s.held)
+		return err
+	}
+	if s.file != nil {
+		if err := s.file.Commit(); err != nil {
+			return fmt.Errorf("writing output file: %s", err)
+		}
+		ctx.Info.Printf("wrote results to %s", s.path)
+	}
+	return nil
+}
+
+func (s *writerSink) Abort() {
+	if s.file != nil {
+		s.file.Abort()
+	}
+}
+
+// webhookFinding is the body of a webhook sink's request.
+type webhookFinding struct {
+	scanRecord
+	Scan string `json:"scan"`
+	Run  int    `json:"run"`
+}
+
+// webhookSink POSTs each finding to a URL as it's found, for chat integrations or other services.
+type webhookSink struct {
+	client *http.Client
+	url    string
+	token  string
+	scan   string
+	run    int
+}
+
+func newWebhookSink(ctx *utils.Context, target string, opts sinkOptions) (Sink, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q: must be like webhook+https://example.com/hook", target)
+	}
+	return &webhookSink{
+		client: &http.Client{Timeout: 10 * time.Second},
+		url:    target,
+		token:  os.Getenv(WebhookTokenEnv),
+		scan:   opts.name,
+		run:    opts.run.ID,
+	}, nil
+}
+
+// Write POSTs the result as JSON if it's a finding, with the same fields as a -json line along with the scan name and
+// run.
+func (s *webhookSink) Write(ctx *utils.Context, finding Finding) error {
+	if !finding.Info.Exists {
+		return nil
+	}
+	body, err := json.Marshal(webhookFinding{scanRecord: newScanRecord(finding.Arn, finding.Info), Scan: s.scan, Run: s.run})
+	if err != nil {
+		return fmt.Errorf("marshaling record for %s: %w", finding.Arn, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting %s: %s", finding.Arn, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("posting %s: %s: %s", finding.Arn, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Flush does nothing, findings are sent as they're found.
+func (s *webhookSink) Flush(ctx *utils.Context) error {
+	return nil
+}
+
+func (s *webhookSink) Close(ctx *utils.Context) error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSink(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	opts := sinkOptions{name: "default", format: "text", run: scanner.Run{ID: 2}}
+
+	sink, err := newSink(ctx, "stdout?format=csv", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "stdout", sink.info.scheme)
+	assert.Equal(t, "csv", sink.Sink.(*writerSink).results.format)
+
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	sink, err = newSink(ctx, "file://"+path+"?format=json", opts)
+	require.NoError(t, err)
+	defer sinkSet{sink}.Abort()
+	assert.Equal(t, path, sink.Sink.(*writerSink).path)
+	assert.Equal(t, "json", sink.Sink.(*writerSink).results.format)
+
+	// The query string of a webhook URL is part of the URL.
+	sink, err = newSink(ctx, "webhook+https://example.com/hook?token=abc", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook?token=abc", sink.Sink.(*webhookSink).url)
+	assert.Equal(t, 2, sink.Sink.(*webhookSink).run)
+
+	sink, err = newSink(ctx, "s3://bucket/scans/?format=csv&interval=15m", opts)
+	require.NoError(t, err)
+	assert.True(t, sink.info.remote)
+	s3 := sink.Sink.(*s3Sink)
+	assert.Equal(t, [2]string{"bucket", "scans"}, [2]string{s3.upload.bucket, s3.upload.prefix})
+	assert.Equal(t, "csv", s3.format)
+	assert.Equal(t, 15*time.Minute, s3.interval)
+
+	sink, err = newSink(ctx, "elasticsearch+https://localhost:9200/roles-findings", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "roles-findings", sink.Sink.(*esSink).index)
+
+	for _, bad := range []string{"kafka://localhost", "stdout?format=xml", "stdout?interval=1m", "file://", "s3://bucket?interval=soon", "webhook+ftp://example.com", "elasticsearch+https://localhost:9200/roles?telemetry=maybe"} {
+		_, err := newSink(ctx, bad, opts)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewRunSinks(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	schemes := func(opts Opts) []string {
+		sinks, err := newRunSinks(ctx, opts, sinkOptions{format: "text"})
+		require.NoError(t, err)
+		defer sinks.Abort()
+
+		var schemes []string
+		for _, sink := range sinks {
+			schemes = append(schemes, sink.info.scheme)
+		}
+		return schemes
+	}
+
+	assert.Equal(t, []string{"stdout"}, schemes(Opts{}))
+	assert.Equal(t, []string{"file"}, schemes(Opts{OutputFile: filepath.Join(dir, "out.txt")}))
+	assert.Equal(t, []string{"webhook", "stdout"}, schemes(Opts{Sinks: "webhook+https://example.com/hook"}))
+	// A local sink replaces stdout.
+	assert.Equal(t, []string{"file", "webhook"}, schemes(Opts{Sinks: "file://" + filepath.Join(dir, "a.txt") + ",webhook+https://example.com/hook"}))
+	assert.Equal(t, []string{"stdout", "s3", "elasticsearch"}, schemes(Opts{OutputS3: "s3://bucket/prefix/", Elasticsearch: "https://localhost:9200/roles"}))
+	assert.Equal(t, []string{"stdout"}, schemes(Opts{LineBuffered: true}))
+
+	_, err := newRunSinks(ctx, Opts{LineBuffered: true, OutputFile: filepath.Join(dir, "out.txt")}, sinkOptions{format: "text"})
+	assert.ErrorContains(t, err, "needs results on stdout")
+}
+
+// failingSink fails every call with err.
+type failingSink struct {
+	err     error
+	aborted bool
+}
+
+func (s *failingSink) Write(ctx *utils.Context, finding Finding) error { return s.err }
+func (s *failingSink) Flush(ctx *utils.Context) error                  { return s.err }
+func (s *failingSink) Close(ctx *utils.Context) error                  { return s.err }
+func (s *failingSink) Abort()                                          { s.aborted = true }
+
+func TestSinkSet(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	remote := &failingSink{err: errors.New("unreachable")}
+	local := &failingSink{err: errors.New("disk full")}
+
+	// Remote sinks failing doesn't stop the scan.
+	sinks := sinkSet{{Sink: remote, info: sinkInfo{scheme: "webhook", remote: true}}}
+	assert.NoError(t, sinks.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Admin"}))
+	assert.NoError(t, sinks.Flush(ctx))
+
+	sinks = append(sinks, namedSink{Sink: local, info: sinkInfo{scheme: "file"}})
+	assert.EqualError(t, sinks.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Admin"}), "disk full")
+	assert.EqualError(t, sinks.Close(ctx), "webhook sink: unreachable\nfile sink: disk full")
+
+	sinks.Abort()
+	assert.True(t, remote.aborted)
+	assert.True(t, local.aborted)
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	path := filepath.Join(t.TempDir(), "results.txt")
+	require.NoError(t, os.WriteFile(path, []byte("previous\n"), 0600))
+
+	sink, err := newFileSink(ctx, path, sinkOptions{format: "text"})
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Admin", Info: utils.Info{Exists: true, Comment: "admin"}}))
+
+	// The file is only replaced once the sink is closed.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "previous\n", string(data))
+
+	require.NoError(t, sink.Close(ctx))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Admin # admin\n", string(data))
+}
+
+func TestWebhookSink(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	t.Setenv(WebhookTokenEnv, "secret")
+
+	var got []webhookFinding
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		var finding webhookFinding
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&finding))
+		got = append(got, finding)
+		if finding.PrincipalName == "Broken" {
+			http.Error(w, "rejected", http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	sink, err := newWebhookSink(ctx, server.URL+"/hook", sinkOptions{name: "engagement", run: scanner.Run{ID: 3}})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Admin", Info: utils.Info{Exists: true}}))
+	require.NoError(t, sink.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Missing"}))
+	require.Len(t, got, 1, "only findings are sent")
+	assert.Equal(t, "Bearer secret", auth)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Admin", got[0].Arn)
+	assert.Equal(t, "engagement", got[0].Scan)
+	assert.Equal(t, 3, got[0].Run)
+
+	err = sink.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Broken", Info: utils.Info{Exists: true}})
+	assert.ErrorContains(t, err, "400 Bad Request: rejected")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sqsRetryDelay is how long consuming a candidate queue waits after a failed receive, or while the server's queue of
+// scans is full.
+const sqsRetryDelay = 10 * time.Second
+
+type ISQSConsumer interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+}
+
+// sqsConsumer receives candidates from an SQS queue owned by the operator, so other systems can keep feeding new
+// candidates to a long-running scanner.
+type sqsConsumer struct {
+	client   ISQSConsumer
+	queueURL string
+}
+
+// newSQSConsumer returns a consumer for queueURL, the client is created in the queue's region.
+func newSQSConsumer(cfg aws.Config, queueURL string) (*sqsConsumer, error) {
+	parsed, err := url.Parse(queueURL)
+	if err != nil || parsed.Scheme != "https" || !strings.HasPrefix(parsed.Host, "sqs.") || strings.Count(parsed.Host, ".") < 3 || strings.Count(parsed.Path, "/") != 2 {
+		return nil, fmt.Errorf("invalid SQS queue URL %q: must be like https://sqs.us-east-1.amazonaws.com/123456789012/candidates", queueURL)
+	}
+
+	cfg = cfg.Copy()
+	cfg.Region = strings.Split(parsed.Host, ".")[1]
+	return &sqsConsumer{client: sqs.NewFromConfig(cfg), queueURL: queueURL}, nil
+}
+
+// parseCandidateMessage returns the scan request in the body of a message, which is either a POST /scans request body
+// or candidate principal ARNs one per line, with optional # comments like list files.
+func parseCandidateMessage(body string) (scanRequest, error) {
+	if strings.HasPrefix(strings.TrimSpace(body), "{") {
+		return decodeScanRequest([]byte(body))
+	}
+
+	arns := utils.GetInputFromPath(body)
+	if len(arns) == 0 {
+		return scanRequest{}, fmt.Errorf("message has no ARNs")
+	}
+	for principalArn := range arns {
+		if err := arn.ValidateArn(principalArn); err != nil {
+			return scanRequest{}, fmt.Errorf("invalid ARN %q: %s", principalArn, err)
+		}
+	}
+	return scanRequest{arns: arns}, nil
+}
+
+// consume submits a scan for each message received from c until ctx is done. Messages are deleted once their scan is
+// queued, the ones that can't be parsed or submitted are left on the queue to be received again or moved to its
+// dead-letter queue by its redrive policy.
+func (s *server) consume(ctx *utils.Context, c *sqsConsumer) {
+	ctx.Info.Printf("consuming candidates from %s", c.queueURL)
+	for ctx.IsRunning() {
+		// Messages are only received while there's room to queue their scans, so the rest wait on the SQS queue.
+		if len(s.queue) == cap(s.queue) {
+			ctx.Sleep(sqsRetryDelay)
+			continue
+		}
+
+		out, err := c.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: int32(min(10, cap(s.queue)-len(s.queue))),
+			WaitTimeSeconds:     20,
+		})
+		if ctx.IsDone() {
+			return
+		} else if err != nil {
+			ctx.Error.Printf("receiving from %s: %s", c.queueURL, err)
+			ctx.Sleep(sqsRetryDelay)
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			s.submitMessage(ctx, c, msg)
+		}
+	}
+}
+
+// submitMessage queues a scan for msg and deletes it from the queue.
+func (s *server) submitMessage(ctx *utils.Context, c *sqsConsumer, msg types.Message) {
+	id := aws.ToString(msg.MessageId)
+	req, err := parseCandidateMessage(aws.ToString(msg.Body))
+	if err != nil {
+		ctx.Error.Printf("message %s: %s", id, err)
+		return
+	}
+	job, err := s.submit(ctx, req)
+	if err != nil {
+		ctx.Error.Printf("message %s: %s", id, err)
+		return
+	}
+	ctx.Info.Printf("submitted message %s as scan %d with %d candidates", id, job.ID, job.Candidates)
+
+	_, err = c.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		ctx.Error.Printf("deleting message %s from %s: %s", id, c.queueURL, err)
+	}
+}
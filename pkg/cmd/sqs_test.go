@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSQSConsumer returns messages from the first receive, and blocks later ones until the context is done.
+type mockSQSConsumer struct {
+	mux      sync.Mutex
+	messages []types.Message
+	deleted  []string
+}
+
+func (m *mockSQSConsumer) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	m.mux.Lock()
+	messages := m.messages
+	m.messages = nil
+	m.mux.Unlock()
+	if len(messages) == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (m *mockSQSConsumer) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.deleted = append(m.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestParseCandidateMessage(t *testing.T) {
+	req, err := parseCandidateMessage(`{"accounts": ["123456789012"], "roles": ["Admin"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Admin"}, req.Roles)
+	assert.Nil(t, req.arns)
+
+	req, err = parseCandidateMessage("arn:aws:iam::123456789012:role/deploy # from ci logs\n\narn:aws:iam::123456789012:user/ci\n")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/deploy": {Comment: " from ci logs"},
+		"arn:aws:iam::123456789012:user/ci":     {},
+	}, req.arns)
+
+	for _, bad := range []string{"", "# nothing\n", "role/deploy", "arn:aws:s3:::bucket", `{"roless": []}`} {
+		_, err := parseCandidateMessage(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewSQSConsumer(t *testing.T) {
+	c, err := newSQSConsumer(aws.Config{Region: "us-west-2"}, "https://sqs.eu-west-1.amazonaws.com/111111111111/candidates")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1", c.client.(*sqs.Client).Options().Region)
+
+	for _, bad := range []string{"candidates", "http://sqs.eu-west-1.amazonaws.com/111111111111/candidates", "https://sqs.eu-west-1.amazonaws.com/candidates"} {
+		_, err := newSQSConsumer(aws.Config{}, bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestServer_Consume(t *testing.T) {
+	client := &mockSQSConsumer{messages: []types.Message{
+		{MessageId: aws.String("a"), ReceiptHandle: aws.String("ra"), Body: aws.String(`{"accounts": ["123456789012"], "roles": ["Admin"]}`)},
+		{MessageId: aws.String("b"), ReceiptHandle: aws.String("rb"), Body: aws.String("arn:aws:iam::123456789012:role/deploy\narn:aws:iam::123456789012:user/ci")},
+		{MessageId: aws.String("c"), ReceiptHandle: aws.String("rc"), Body: aws.String("arn:aws:iam::1234:role/deploy")},
+		{MessageId: aws.String("d"), ReceiptHandle: aws.String("rd"), Body: aws.String(`{"accounts": `)},
+	}}
+	s := newIdleTestAPI(t, "", &fakeScanner{})
+	ctx, cancel := utils.NewContext(context.Background()).WithCancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.consume(ctx, &sqsConsumer{client: client, queueURL: "https://sqs.us-east-1.amazonaws.com/111111111111/candidates"})
+	}()
+
+	assert.Eventually(t, func() bool {
+		client.mux.Lock()
+		defer client.mux.Unlock()
+		return len(client.messages) == 0
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+
+	// Messages that can't be scanned are left for the queue's redrive policy.
+	assert.Equal(t, []string{"ra", "rb"}, client.deleted)
+	jobs := s.list()
+	require.Len(t, jobs, 2)
+	assert.Equal(t, jobQueued, jobs[1].Status)
+	assert.Equal(t, 2, jobs[1].Candidates)
+}
@@ -0,0 +1,402 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// StackSetName is the name of the StackSet -setup -stackset deploys the plugin resources with.
+const StackSetName = "role-scanning-resources"
+
+// stackSetTags are the tags of the StackSet's stacks, CloudFormation propagates them to the resources that support
+// tags.
+var stackSetTags = []types.Tag{{Key: aws.String("role-scanning-resource"), Value: aws.String("true")}}
+
+// stackSetPollInterval is how often a StackSet operation is checked on until it's done.
+const stackSetPollInterval = 15 * time.Second
+
+type ICloudFormationStackSets interface {
+	DescribeOrganizationsAccess(ctx context.Context, params *cloudformation.DescribeOrganizationsAccessInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeOrganizationsAccessOutput, error)
+	ActivateOrganizationsAccess(ctx context.Context, params *cloudformation.ActivateOrganizationsAccessInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ActivateOrganizationsAccessOutput, error)
+	DescribeStackSet(ctx context.Context, params *cloudformation.DescribeStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackSetOutput, error)
+	CreateStackSet(ctx context.Context, params *cloudformation.CreateStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackSetOutput, error)
+	UpdateStackSet(ctx context.Context, params *cloudformation.UpdateStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.UpdateStackSetOutput, error)
+	DeleteStackSet(ctx context.Context, params *cloudformation.DeleteStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackSetOutput, error)
+	ListStackInstances(ctx context.Context, params *cloudformation.ListStackInstancesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackInstancesOutput, error)
+	CreateStackInstances(ctx context.Context, params *cloudformation.CreateStackInstancesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackInstancesOutput, error)
+	DeleteStackInstances(ctx context.Context, params *cloudformation.DeleteStackInstancesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackInstancesOutput, error)
+	DescribeStackSetOperation(ctx context.Context, params *cloudformation.DescribeStackSetOperationInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackSetOperationOutput, error)
+	ListStackSetOperationResults(ctx context.Context, params *cloudformation.ListStackSetOperationResultsInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackSetOperationResultsOutput, error)
+}
+
+type IOrgRootLister interface {
+	ListRoots(ctx context.Context, params *organizations.ListRootsInput, optFns ...func(*organizations.Options)) (*organizations.ListRootsOutput, error)
+}
+
+// stackSetTemplate returns the template of the StackSet, it has the resources of each registered plugin in every
+// region it's deployed to.
+func stackSetTemplate(registered []pluginInfo) (string, error) {
+	resources := map[string]plugins.StackResource{}
+	for _, p := range registered {
+		maps.Copy(resources, p.stackResources(p.concurrency))
+	}
+	doc, err := json.MarshalIndent(map[string]any{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Description":              "Resources the role scanner updates the policy of to check whether principals exist.",
+		"Conditions":               plugins.StackConditions,
+		"Resources":                resources,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshalling template: %w", err)
+	}
+	return string(doc), nil
+}
+
+// SetupStackSet deploys the plugin resources of cfgs with a service managed StackSet instead of creating them with the
+// plugins, so they're reviewable in CloudFormation, tagged the same way, and deleted along with the StackSet. Stack
+// instances that already exist are updated to the current template.
+func SetupStackSet(ctx *utils.Context, cfg aws.Config, cfgs map[string]utils.ThreadConfig, state *setupState) error {
+	template, err := stackSetTemplate(registeredPlugins)
+	if err != nil {
+		return err
+	}
+
+	deployer := newStackSetDeployer(cfg)
+	// Saved first, so -clean deletes the StackSet even if deploying it fails part way.
+	if err := state.setStackSet(true); err != nil {
+		return fmt.Errorf("saving setup state: %s", err)
+	}
+	if err := deployer.deploy(ctx, template, stackSetRegions(cfgs)); err != nil {
+		return err
+	}
+
+	// The plugin resources exist now, so -setup without -stackset doesn't create them again.
+	for key, cfg := range cfgs {
+		for _, plugin := range utils.FlattenList(LoadAllPlugins(map[string]utils.ThreadConfig{key: cfg})) {
+			if err := state.setPluginSetup(pluginStateKey(key, plugin.Name())); err != nil {
+				return fmt.Errorf("saving setup state: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveStackSet deletes the StackSet and the plugin resources it deployed, if it exists.
+func RemoveStackSet(ctx *utils.Context, cfg aws.Config, state *setupState) error {
+	deployer := newStackSetDeployer(cfg)
+	if err := deployer.remove(ctx); err != nil {
+		return err
+	}
+	if err := state.setStackSet(false); err != nil {
+		return fmt.Errorf("saving setup state: %s", err)
+	}
+	// The plugins the StackSet set up need to be set up again, the ones in the inventory are cleaned up by -clean.
+	if err := state.forgetUninventoried(); err != nil {
+		return fmt.Errorf("saving setup state: %s", err)
+	}
+	return nil
+}
+
+// stackSetRegions returns the regions of cfgs by account ID, sorted.
+func stackSetRegions(cfgs map[string]utils.ThreadConfig) map[string][]string {
+	regions := map[string][]string{}
+	for _, cfg := range cfgs {
+		regions[cfg.AccountId] = append(regions[cfg.AccountId], cfg.Region)
+	}
+	for _, r := range regions {
+		slices.Sort(r)
+	}
+	return regions
+}
+
+// stackInstanceGroup is accounts that stack instances are created in or deleted from in the same regions, a StackSet
+// operation applies to every region of every account it's given.
+type stackInstanceGroup struct {
+	accounts []string
+	regions  []string
+}
+
+// groupStackInstances groups the accounts of regions by the regions they have, the groups are sorted by their
+// regions.
+func groupStackInstances(regions map[string][]string) []stackInstanceGroup {
+	byRegions := map[string]*stackInstanceGroup{}
+	for _, accountId := range slices.Sorted(maps.Keys(regions)) {
+		if len(regions[accountId]) == 0 {
+			continue
+		}
+		key := strings.Join(regions[accountId], ",")
+		if byRegions[key] == nil {
+			byRegions[key] = &stackInstanceGroup{regions: regions[accountId]}
+		}
+		byRegions[key].accounts = append(byRegions[key].accounts, accountId)
+	}
+
+	var groups []stackInstanceGroup
+	for _, key := range slices.Sorted(maps.Keys(byRegions)) {
+		groups = append(groups, *byRegions[key])
+	}
+	return groups
+}
+
+// stackSetDeployer creates, updates, and deletes the StackSet and its stack instances from the management account.
+type stackSetDeployer struct {
+	client       ICloudFormationStackSets
+	org          IOrgRootLister
+	pollInterval time.Duration
+}
+
+func newStackSetDeployer(cfg aws.Config) *stackSetDeployer {
+	return &stackSetDeployer{
+		client:       cloudformation.NewFromConfig(cfg),
+		org:          organizations.NewFromConfig(cfg),
+		pollInterval: stackSetPollInterval,
+	}
+}
+
+// deploy creates or updates the StackSet with template, then creates the stack instances in the regions of each
+// account that don't have one yet.
+func (d *stackSetDeployer) deploy(ctx *utils.Context, template string, regions map[string][]string) error {
+	if err := d.activateOrganizationsAccess(ctx); err != nil {
+		return err
+	}
+
+	resp, err := d.client.DescribeStackSet(ctx, &cloudformation.DescribeStackSetInput{StackSetName: aws.String(StackSetName)})
+	var notFound *types.StackSetNotFoundException
+	if errors.As(err, &notFound) {
+		ctx.Info.Printf("creating StackSet %s", StackSetName)
+		_, err = d.client.CreateStackSet(ctx, &cloudformation.CreateStackSetInput{
+			StackSetName:    aws.String(StackSetName),
+			TemplateBody:    aws.String(template),
+			PermissionModel: types.PermissionModelsServiceManaged,
+			AutoDeployment:  &types.AutoDeployment{Enabled: aws.Bool(false)},
+			Tags:            stackSetTags,
+		})
+		if err != nil {
+			return fmt.Errorf("creating StackSet: %s", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("describing StackSet: %s", err)
+	} else if aws.ToString(resp.StackSet.TemplateBody) != template {
+		ctx.Info.Printf("updating StackSet %s", StackSetName)
+		update, err := d.client.UpdateStackSet(ctx, &cloudformation.UpdateStackSetInput{
+			StackSetName:         aws.String(StackSetName),
+			TemplateBody:         aws.String(template),
+			Tags:                 stackSetTags,
+			OperationPreferences: stackSetOperationPreferences(),
+		})
+		if err != nil {
+			return fmt.Errorf("updating StackSet: %s", err)
+		}
+		if err := d.wait(ctx, aws.ToString(update.OperationId)); err != nil {
+			return fmt.Errorf("updating StackSet: %s", err)
+		}
+	}
+
+	existing, err := d.instances(ctx)
+	if err != nil {
+		return err
+	}
+	missing := map[string][]string{}
+	for accountId, accountRegions := range regions {
+		for _, region := range accountRegions {
+			if !slices.Contains(existing[accountId], region) {
+				missing[accountId] = append(missing[accountId], region)
+			}
+		}
+	}
+
+	rootId, err := d.rootId(ctx)
+	if err != nil {
+		return err
+	}
+	for _, group := range groupStackInstances(missing) {
+		ctx.Info.Printf("creating stack instances in %d regions of %d accounts, this can take a while...", len(group.regions), len(group.accounts))
+		resp, err := d.client.CreateStackInstances(ctx, &cloudformation.CreateStackInstancesInput{
+			StackSetName:         aws.String(StackSetName),
+			DeploymentTargets:    stackSetTargets(rootId, group.accounts),
+			Regions:              group.regions,
+			OperationPreferences: stackSetOperationPreferences(),
+		})
+		if err != nil {
+			return fmt.Errorf("creating stack instances: %s", err)
+		}
+		if err := d.wait(ctx, aws.ToString(resp.OperationId)); err != nil {
+			return fmt.Errorf("creating stack instances: %s", err)
+		}
+	}
+	return nil
+}
+
+// remove deletes the stack instances, along with the resources they created, and then the StackSet. It does nothing
+// if the StackSet doesn't exist.
+func (d *stackSetDeployer) remove(ctx *utils.Context) error {
+	_, err := d.client.DescribeStackSet(ctx, &cloudformation.DescribeStackSetInput{StackSetName: aws.String(StackSetName)})
+	var notFound *types.StackSetNotFoundException
+	if errors.As(err, &notFound) {
+		ctx.Debug.Printf("StackSet %s doesn't exist", StackSetName)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("describing StackSet: %s", err)
+	}
+
+	existing, err := d.instances(ctx)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		rootId, err := d.rootId(ctx)
+		if err != nil {
+			return err
+		}
+		for _, group := range groupStackInstances(existing) {
+			ctx.Info.Printf("deleting stack instances in %d regions of %d accounts, this can take a while...", len(group.regions), len(group.accounts))
+			resp, err := d.client.DeleteStackInstances(ctx, &cloudformation.DeleteStackInstancesInput{
+				StackSetName:         aws.String(StackSetName),
+				DeploymentTargets:    stackSetTargets(rootId, group.accounts),
+				Regions:              group.regions,
+				RetainStacks:         aws.Bool(false),
+				OperationPreferences: stackSetOperationPreferences(),
+			})
+			if err != nil {
+				return fmt.Errorf("deleting stack instances: %s", err)
+			}
+			if err := d.wait(ctx, aws.ToString(resp.OperationId)); err != nil {
+				return fmt.Errorf("deleting stack instances: %s", err)
+			}
+		}
+	}
+
+	ctx.Info.Printf("deleting StackSet %s", StackSetName)
+	if _, err := d.client.DeleteStackSet(ctx, &cloudformation.DeleteStackSetInput{StackSetName: aws.String(StackSetName)}); err != nil {
+		return fmt.Errorf("deleting StackSet: %s", err)
+	}
+	return nil
+}
+
+// activateOrganizationsAccess turns on trusted access with Organizations, which service managed StackSets need.
+func (d *stackSetDeployer) activateOrganizationsAccess(ctx *utils.Context) error {
+	resp, err := d.client.DescribeOrganizationsAccess(ctx, &cloudformation.DescribeOrganizationsAccessInput{})
+	if err != nil {
+		return fmt.Errorf("describing organizations access: %s", err)
+	}
+	if resp.Status == types.OrganizationStatusEnabled {
+		return nil
+	}
+	ctx.Info.Printf("activating trusted access between CloudFormation StackSets and Organizations")
+	if _, err := d.client.ActivateOrganizationsAccess(ctx, &cloudformation.ActivateOrganizationsAccessInput{}); err != nil {
+		return fmt.Errorf("activating organizations access: %s", err)
+	}
+	return nil
+}
+
+// instances returns the regions of the StackSet's stack instances by account ID, sorted.
+func (d *stackSetDeployer) instances(ctx *utils.Context) (map[string][]string, error) {
+	regions := map[string][]string{}
+	paginator := cloudformation.NewListStackInstancesPaginator(d.client, &cloudformation.ListStackInstancesInput{
+		StackSetName: aws.String(StackSetName),
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing stack instances: %s", err)
+		}
+		for _, instance := range resp.Summaries {
+			accountId := aws.ToString(instance.Account)
+			regions[accountId] = append(regions[accountId], aws.ToString(instance.Region))
+		}
+	}
+	for _, r := range regions {
+		slices.Sort(r)
+	}
+	return regions, nil
+}
+
+// rootId returns the ID of the organization's root, service managed StackSets are deployed to an organizational unit.
+func (d *stackSetDeployer) rootId(ctx *utils.Context) (string, error) {
+	resp, err := d.org.ListRoots(ctx, &organizations.ListRootsInput{})
+	if err != nil {
+		return "", fmt.Errorf("listing roots: %s", err)
+	}
+	if len(resp.Roots) == 0 {
+		return "", fmt.Errorf("organization has no root")
+	}
+	return aws.ToString(resp.Roots[0].Id), nil
+}
+
+// wait waits for the StackSet operation to finish, it returns the reasons the stack instances that failed did if it
+// doesn't succeed.
+func (d *stackSetDeployer) wait(ctx *utils.Context, operationId string) error {
+	for {
+		resp, err := d.client.DescribeStackSetOperation(ctx, &cloudformation.DescribeStackSetOperationInput{
+			StackSetName: aws.String(StackSetName),
+			OperationId:  aws.String(operationId),
+		})
+		if err != nil {
+			return fmt.Errorf("describing operation %s: %s", operationId, err)
+		}
+
+		switch resp.StackSetOperation.Status {
+		case types.StackSetOperationStatusSucceeded:
+			return nil
+		case types.StackSetOperationStatusFailed, types.StackSetOperationStatusStopped:
+			return fmt.Errorf("operation %s %s: %s", operationId, strings.ToLower(string(resp.StackSetOperation.Status)), d.failures(ctx, operationId))
+		}
+
+		ctx.Debug.Printf("operation %s is %s", operationId, resp.StackSetOperation.Status)
+		ctx.Sleep(d.pollInterval)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// failures returns why the stack instances of the operation failed.
+func (d *stackSetDeployer) failures(ctx *utils.Context, operationId string) string {
+	resp, err := d.client.ListStackSetOperationResults(ctx, &cloudformation.ListStackSetOperationResultsInput{
+		StackSetName: aws.String(StackSetName),
+		OperationId:  aws.String(operationId),
+	})
+	if err != nil {
+		return fmt.Sprintf("listing operation results: %s", err)
+	}
+
+	var reasons []string
+	for _, result := range resp.Summaries {
+		if result.Status == types.StackSetOperationResultStatusFailed {
+			reasons = append(reasons, fmt.Sprintf("%s %s: %s", aws.ToString(result.Account), aws.ToString(result.Region), aws.ToString(result.StatusReason)))
+		}
+	}
+	if len(reasons) == 0 {
+		return "no stack instances failed"
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// stackSetTargets returns the deployment targets of accounts in the organization with root rootId.
+func stackSetTargets(rootId string, accounts []string) *types.DeploymentTargets {
+	return &types.DeploymentTargets{
+		OrganizationalUnitIds: []string{rootId},
+		Accounts:              accounts,
+		AccountFilterType:     types.AccountFilterTypeIntersection,
+	}
+}
+
+// stackSetOperationPreferences deploys to every account and region at once, like -setup does without -stackset.
+func stackSetOperationPreferences() *types.StackSetOperationPreferences {
+	return &types.StackSetOperationPreferences{
+		RegionConcurrencyType:   types.RegionConcurrencyTypeParallel,
+		MaxConcurrentPercentage: aws.Int32(100),
+	}
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockStackSets is a StackSet API with a StackSet when template isn't empty, operations finish after being described
+// once as running and end with status.
+type mockStackSets struct {
+	ICloudFormationStackSets
+	orgAccess bool
+	template  string
+	instances []types.StackInstanceSummary
+	status    types.StackSetOperationStatus
+
+	activated  bool
+	created    *cloudformation.CreateStackSetInput
+	updated    *cloudformation.UpdateStackSetInput
+	deleted    bool
+	createdIn  []*cloudformation.CreateStackInstancesInput
+	deletedIn  []*cloudformation.DeleteStackInstancesInput
+	described  map[string]int
+	operations int
+}
+
+func (m *mockStackSets) DescribeOrganizationsAccess(ctx context.Context, params *cloudformation.DescribeOrganizationsAccessInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeOrganizationsAccessOutput, error) {
+	if m.orgAccess {
+		return &cloudformation.DescribeOrganizationsAccessOutput{Status: types.OrganizationStatusEnabled}, nil
+	}
+	return &cloudformation.DescribeOrganizationsAccessOutput{Status: types.OrganizationStatusDisabled}, nil
+}
+
+func (m *mockStackSets) ActivateOrganizationsAccess(ctx context.Context, params *cloudformation.ActivateOrganizationsAccessInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ActivateOrganizationsAccessOutput, error) {
+	m.activated = true
+	return &cloudformation.ActivateOrganizationsAccessOutput{}, nil
+}
+
+func (m *mockStackSets) DescribeStackSet(ctx context.Context, params *cloudformation.DescribeStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackSetOutput, error) {
+	if m.template == "" {
+		return nil, &types.StackSetNotFoundException{}
+	}
+	return &cloudformation.DescribeStackSetOutput{StackSet: &types.StackSet{TemplateBody: aws.String(m.template)}}, nil
+}
+
+func (m *mockStackSets) CreateStackSet(ctx context.Context, params *cloudformation.CreateStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackSetOutput, error) {
+	m.created = params
+	m.template = aws.ToString(params.TemplateBody)
+	return &cloudformation.CreateStackSetOutput{}, nil
+}
+
+func (m *mockStackSets) UpdateStackSet(ctx context.Context, params *cloudformation.UpdateStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.UpdateStackSetOutput, error) {
+	m.updated = params
+	return &cloudformation.UpdateStackSetOutput{OperationId: m.operation()}, nil
+}
+
+func (m *mockStackSets) DeleteStackSet(ctx context.Context, params *cloudformation.DeleteStackSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackSetOutput, error) {
+	m.deleted = true
+	return &cloudformation.DeleteStackSetOutput{}, nil
+}
+
+func (m *mockStackSets) ListStackInstances(ctx context.Context, params *cloudformation.ListStackInstancesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackInstancesOutput, error) {
+	return &cloudformation.ListStackInstancesOutput{Summaries: m.instances}, nil
+}
+
+func (m *mockStackSets) CreateStackInstances(ctx context.Context, params *cloudformation.CreateStackInstancesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackInstancesOutput, error) {
+	m.createdIn = append(m.createdIn, params)
+	return &cloudformation.CreateStackInstancesOutput{OperationId: m.operation()}, nil
+}
+
+func (m *mockStackSets) DeleteStackInstances(ctx context.Context, params *cloudformation.DeleteStackInstancesInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackInstancesOutput, error) {
+	m.deletedIn = append(m.deletedIn, params)
+	return &cloudformation.DeleteStackInstancesOutput{OperationId: m.operation()}, nil
+}
+
+func (m *mockStackSets) DescribeStackSetOperation(ctx context.Context, params *cloudformation.DescribeStackSetOperationInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackSetOperationOutput, error) {
+	id := aws.ToString(params.OperationId)
+	m.described[id]++
+	status := types.StackSetOperationStatusRunning
+	if m.described[id] > 1 {
+		status = m.status
+	}
+	return &cloudformation.DescribeStackSetOperationOutput{StackSetOperation: &types.StackSetOperation{Status: status}}, nil
+}
+
+func (m *mockStackSets) ListStackSetOperationResults(ctx context.Context, params *cloudformation.ListStackSetOperationResultsInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ListStackSetOperationResultsOutput, error) {
+	return &cloudformation.ListStackSetOperationResultsOutput{Summaries: []types.StackSetOperationResultSummary{
+		{Account: aws.String("111111111111"), Region: aws.String("us-east-1"), Status: types.StackSetOperationResultStatusSucceeded},
+		{Account: aws.String("222222222222"), Region: aws.String("us-east-1"), Status: types.StackSetOperationResultStatusFailed, StatusReason: aws.String("bucket name already taken")},
+	}}, nil
+}
+
+func (m *mockStackSets) operation() *string {
+	m.operations++
+	if m.described == nil {
+		m.described = map[string]int{}
+	}
+	return aws.String(string(rune('a' + m.operations)))
+}
+
+type mockRootLister struct{}
+
+func (mockRootLister) ListRoots(ctx context.Context, params *organizations.ListRootsInput, optFns ...func(*organizations.Options)) (*organizations.ListRootsOutput, error) {
+	return &organizations.ListRootsOutput{Roots: []orgtypes.Root{{Id: aws.String("r-abcd")}}}, nil
+}
+
+func TestStackSetTemplate(t *testing.T) {
+	template, err := stackSetTemplate(registeredPlugins)
+	require.NoError(t, err)
+
+	var doc struct {
+		Conditions map[string]any
+		Resources  map[string]struct {
+			Type      string
+			Condition string
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(template), &doc))
+	assert.Equal(t, "AWS::SNS::Topic", doc.Resources["SNSTopic1"].Type)
+	assert.Equal(t, "AWS::SQS::Queue", doc.Resources["SQSQueue1"].Type)
+	assert.Equal(t, "AWS::S3::Bucket", doc.Resources["S3Bucket0"].Type)
+	assert.Equal(t, "AWS::S3::AccessPoint", doc.Resources["AccessPoint0"].Type)
+	assert.Equal(t, "IsUSEast1", doc.Resources["ECRPublicRepository0"].Condition)
+	assert.Contains(t, doc.Conditions, "IsUSEast1")
+
+	// Each plugin instance in an account and region has a resource.
+	instances := 0
+	for _, p := range registeredPlugins {
+		instances += p.concurrency
+	}
+	assert.Len(t, doc.Resources, instances+1, "access points also need a bucket")
+}
+
+func TestStackSetDeployer_Deploy(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockStackSets{status: types.StackSetOperationStatusSucceeded}
+	d := &stackSetDeployer{client: client, org: mockRootLister{}}
+
+	client.instances = []types.StackInstanceSummary{
+		{Account: aws.String("111111111111"), Region: aws.String("us-east-1")},
+	}
+	require.NoError(t, d.deploy(ctx, "template", map[string][]string{
+		"111111111111": {"us-east-1", "us-west-2"},
+		"222222222222": {"us-east-1", "us-west-2"},
+		"333333333333": {"us-east-1"},
+	}))
+	assert.True(t, client.activated)
+	require.NotNil(t, client.created)
+	assert.Equal(t, types.PermissionModelsServiceManaged, client.created.PermissionModel)
+	assert.Nil(t, client.updated)
+
+	// Accounts missing the same regions are deployed to together, instances that exist are skipped.
+	require.Len(t, client.createdIn, 3)
+	assert.Equal(t, []string{"333333333333"}, client.createdIn[0].DeploymentTargets.Accounts)
+	assert.Equal(t, []string{"us-east-1"}, client.createdIn[0].Regions)
+	assert.Equal(t, []string{"222222222222"}, client.createdIn[1].DeploymentTargets.Accounts)
+	assert.Equal(t, []string{"us-east-1", "us-west-2"}, client.createdIn[1].Regions)
+	assert.Equal(t, []string{"111111111111"}, client.createdIn[2].DeploymentTargets.Accounts)
+	assert.Equal(t, []string{"us-west-2"}, client.createdIn[2].Regions)
+	assert.Equal(t, []string{"r-abcd"}, client.createdIn[1].DeploymentTargets.OrganizationalUnitIds)
+	assert.Equal(t, types.AccountFilterTypeIntersection, client.createdIn[1].DeploymentTargets.AccountFilterType)
+	for id, n := range client.described {
+		assert.Equal(t, 2, n, "operation %s is waited for until it's done", id)
+	}
+
+	// A changed template updates the StackSet, an unchanged one doesn't.
+	client.orgAccess = true
+	client.activated = false
+	require.NoError(t, d.deploy(ctx, "template", nil))
+	assert.Nil(t, client.updated)
+	require.NoError(t, d.deploy(ctx, "new template", nil))
+	require.NotNil(t, client.updated)
+	assert.Equal(t, "new template", aws.ToString(client.updated.TemplateBody))
+	assert.False(t, client.activated)
+}
+
+func TestStackSetDeployer_DeployFailure(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockStackSets{orgAccess: true, status: types.StackSetOperationStatusFailed}
+	d := &stackSetDeployer{client: client, org: mockRootLister{}}
+
+	err := d.deploy(ctx, "template", map[string][]string{"222222222222": {"us-east-1"}})
+	assert.ErrorContains(t, err, "failed: 222222222222 us-east-1: bucket name already taken")
+	assert.NotContains(t, err.Error(), "111111111111")
+}
+
+func TestStackSetDeployer_Remove(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockStackSets{orgAccess: true, status: types.StackSetOperationStatusSucceeded}
+	d := &stackSetDeployer{client: client, org: mockRootLister{}}
+
+	// Nothing to do without a StackSet.
+	require.NoError(t, d.remove(ctx))
+	assert.False(t, client.deleted)
+
+	client.template = "template"
+	client.instances = []types.StackInstanceSummary{
+		{Account: aws.String("111111111111"), Region: aws.String("us-west-2")},
+		{Account: aws.String("111111111111"), Region: aws.String("us-east-1")},
+		{Account: aws.String("222222222222"), Region: aws.String("us-east-1")},
+	}
+	require.NoError(t, d.remove(ctx))
+	require.Len(t, client.deletedIn, 2)
+	assert.Equal(t, []string{"222222222222"}, client.deletedIn[0].DeploymentTargets.Accounts)
+	assert.Equal(t, []string{"us-east-1"}, client.deletedIn[0].Regions)
+	assert.Equal(t, []string{"111111111111"}, client.deletedIn[1].DeploymentTargets.Accounts)
+	assert.Equal(t, []string{"us-east-1", "us-west-2"}, client.deletedIn[1].Regions)
+	assert.False(t, aws.ToBool(client.deletedIn[1].RetainStacks), "the resources are deleted with the stacks")
+	assert.True(t, client.deleted)
+}
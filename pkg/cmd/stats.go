@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"iter"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type StatsOpts struct {
+	Profile string
+	Storage string
+
+	// Names are the scans to report on.
+	Names []string
+	// Format is text or json.
+	Format string
+}
+
+// ageBuckets group results by how long ago they were last checked, results without a timestamp were stored by older
+// versions and are counted separately.
+var ageBuckets = []struct {
+	Label string
+	Max   time.Duration
+}{
+	{"< 1 day", 24 * time.Hour},
+	{"< 7 days", 7 * 24 * time.Hour},
+	{"< 30 days", 30 * 24 * time.Hour},
+	{"< 90 days", 90 * 24 * time.Hour},
+	{">= 90 days", 0},
+}
+
+const unknownAge = "unknown"
+
+type accountStats struct {
+	AccountID string `json:"account_id"`
+	Total     int    `json:"total"`
+	Exists    int    `json:"exists"`
+	NotExists int    `json:"not_exists"`
+}
+
+type ageStats struct {
+	LastChecked string `json:"last_checked"`
+	Total       int    `json:"total"`
+}
+
+type scanStats struct {
+	Name      string         `json:"name"`
+	Total     int            `json:"total"`
+	Exists    int            `json:"exists"`
+	NotExists int            `json:"not_exists"`
+	Accounts  []accountStats `json:"accounts"`
+	Ages      []ageStats     `json:"ages"`
+	Runs      []scanner.Run  `json:"runs"`
+}
+
+// statsRuns is how many of the most recent runs are listed in text output, JSON output includes every run.
+const statsRuns = 10
+
+// Stats reports how many results each scan has, how many of them exist, broken down by account and by age.
+func Stats(ctx *utils.Context, opts StatsOpts) error {
+	if opts.Format != "text" && opts.Format != "json" {
+		return fmt.Errorf("unknown format %q: must be text or json", opts.Format)
+	}
+
+	now := time.Now()
+	var stats []scanStats
+	for _, name := range opts.Names {
+		storage, err := openStorage(ctx, opts.Profile, opts.Storage, name)
+		if err != nil {
+			return fmt.Errorf("opening %s: %s", name, err)
+		}
+		md, err := storage.Metadata()
+		if err != nil {
+			storage.Close()
+			return fmt.Errorf("getting metadata for %s: %s", name, err)
+		}
+
+		s := computeStats(name, storage.All(), now)
+		s.Runs = md.Runs
+		stats = append(stats, s)
+		storage.Close()
+	}
+
+	return writeStats(os.Stdout, opts.Format, stats)
+}
+
+func computeStats(name string, results iter.Seq2[scanner.Key, utils.Info], now time.Time) scanStats {
+	stats := scanStats{Name: name}
+	accounts := map[string]*accountStats{}
+	ages := map[string]int{}
+
+	for key, info := range results {
+		account, ok := accounts[key.AccountID]
+		if !ok {
+			account = &accountStats{AccountID: key.AccountID}
+			accounts[key.AccountID] = account
+		}
+
+		stats.Total++
+		account.Total++
+		if info.Exists {
+			stats.Exists++
+			account.Exists++
+		} else {
+			stats.NotExists++
+			account.NotExists++
+		}
+
+		ages[ageLabel(info.LastChecked, now)]++
+	}
+
+	for _, account := range accounts {
+		stats.Accounts = append(stats.Accounts, *account)
+	}
+	slices.SortFunc(stats.Accounts, func(a, b accountStats) int {
+		return strings.Compare(a.AccountID, b.AccountID)
+	})
+
+	for _, bucket := range ageBuckets {
+		stats.Ages = append(stats.Ages, ageStats{LastChecked: bucket.Label, Total: ages[bucket.Label]})
+	}
+	if ages[unknownAge] > 0 {
+		stats.Ages = append(stats.Ages, ageStats{LastChecked: unknownAge, Total: ages[unknownAge]})
+	}
+
+	return stats
+}
+
+func ageLabel(lastChecked time.Time, now time.Time) string {
+	if lastChecked.IsZero() {
+		return unknownAge
+	}
+
+	age := now.Sub(lastChecked)
+	for _, bucket := range ageBuckets {
+		if bucket.Max == 0 || age < bucket.Max {
+			return bucket.Label
+		}
+	}
+	return unknownAge
+}
+
+func writeStats(w io.Writer, format string, stats []scanStats) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, s := range stats {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s: %d results, %d exist, %d don't exist\n\n", s.Name, s.Total, s.Exists, s.NotExists)
+
+		fmt.Fprintln(tw, "ACCOUNT\tTOTAL\tEXISTS\tNOT EXISTS")
+		for _, account := range s.Accounts {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", account.AccountID, account.Total, account.Exists, account.NotExists)
+		}
+		fmt.Fprintln(tw)
+
+		fmt.Fprintln(tw, "LAST CHECKED\tTOTAL")
+		for _, age := range s.Ages {
+			fmt.Fprintf(tw, "%s\t%d\n", age.LastChecked, age.Total)
+		}
+
+		if len(s.Runs) == 0 {
+			continue
+		}
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "RUN\tSTARTED\tFINISHED\tCANDIDATES\tFOUND")
+		for _, run := range s.Runs[max(0, len(s.Runs)-statsRuns):] {
+			finished := "-"
+			if !run.End.IsZero() {
+				finished = run.End.Format(time.DateTime)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\n", run.ID, run.Start.Format(time.DateTime), finished, run.Candidates, run.Findings)
+		}
+	}
+	return tw.Flush()
+}
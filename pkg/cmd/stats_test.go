@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeStats(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	stats := computeStats("default", maps.All(map[scanner.Key]utils.Info{
+		{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:root"}:   {Exists: true, LastChecked: now.Add(-time.Hour)},
+		{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/a"}: {Exists: false, LastChecked: now.AddDate(0, 0, -10)},
+		{AccountID: "210987654321", Arn: "arn:aws:iam::210987654321:root"}:   {Exists: false, LastChecked: now.AddDate(-1, 0, 0)},
+		{AccountID: "210987654321", Arn: "arn:aws:iam::210987654321:role/a"}: {Exists: false},
+	}), now)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeStats(&buf, "text", []scanStats{stats}))
+	assert.Equal(t, `default: 4 results, 1 exist, 3 don't exist
+
+ACCOUNT       TOTAL  EXISTS  NOT EXISTS
+123456789012  2      1       1
+210987654321  2      0       2
+
+LAST CHECKED  TOTAL
+< 1 day       1
+< 7 days      0
+< 30 days     1
+< 90 days     0
+>= 90 days    1
+unknown       1
+`, buf.String())
+}
+
+func TestWriteStats_Runs(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeStats(&buf, "text", []scanStats{{
+		Name: "default",
+		Runs: []scanner.Run{
+			{ID: 1, Start: start, End: start.Add(time.Hour), Candidates: 100, Findings: 2},
+			{ID: 2, Start: start.AddDate(0, 0, 1), Candidates: 50},
+		},
+	}}))
+	assert.Equal(t, `default: 0 results, 0 exist, 0 don't exist
+
+ACCOUNT  TOTAL  EXISTS  NOT EXISTS
+
+LAST CHECKED  TOTAL
+
+RUN  STARTED              FINISHED             CANDIDATES  FOUND
+1    2024-03-01 12:00:00  2024-03-01 13:00:00  100         2
+2    2024-03-02 12:00:00  -                    50          0
+`, buf.String())
+}
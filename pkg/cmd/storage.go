@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+)
+
+// openStorage opens the storage for commands that work with stored results without scanning.
+func openStorage(ctx *utils.Context, profile string, backend string, name string) (scanner.Storage, error) {
+	cfg, err := utils.LoadConfig(ctx, profile,
+		config.WithRegion("us-east-1"),
+		config.WithRetryMode(aws.RetryModeAdaptive),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %s", err)
+	}
+
+	storage, err := scanner.NewStorage(ctx, cfg, backend, name)
+	if err != nil {
+		return nil, fmt.Errorf("new storage: %s", err)
+	}
+
+	return storage, nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"iter"
+	"os"
+	"strings"
+)
+
+type SuggestOpts struct {
+	Profile string
+	Name    string
+	Storage string
+
+	// Account skips suggestions already scanned in this account, by default names scanned in any account are skipped.
+	Account string
+	// Count is how many suggestions to make at most.
+	Count int
+}
+
+// Suggest trains a model on the role names found to exist in a scan and prints likely new role names as a -roles
+// list, with each name's score as the comment.
+func Suggest(ctx *utils.Context, opts SuggestOpts) error {
+	storage, err := openStorage(ctx, opts.Profile, opts.Storage, opts.Name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	suggestions, trained := suggestRoles(storage.All(), opts.Account, opts.Count)
+	if trained == 0 {
+		return fmt.Errorf("no roles found to exist in %s to learn from", opts.Name)
+	}
+
+	ctx.Info.Printf("learned from %d role names, %d suggestions", trained, len(suggestions))
+	return writeSuggestions(os.Stdout, suggestions)
+}
+
+// suggestRoles returns suggestions from the existing roles in results and how many it learned from. A name found in
+// several accounts is learned from once per account, so common conventions weigh more.
+func suggestRoles(results iter.Seq2[scanner.Key, utils.Info], account string, count int) ([]arn.Suggestion, int) {
+	var found []string
+	scanned := map[string]bool{}
+
+	for key, info := range results {
+		rec := newScanRecord(key.Arn, info)
+		if rec.PrincipalType != "role" {
+			continue
+		}
+		name := roleBaseName(rec.PrincipalName)
+
+		if account == "" || rec.AccountID == account {
+			scanned[strings.ToLower(name)] = true
+		}
+		if info.Exists {
+			found = append(found, name)
+		}
+	}
+
+	model := arn.NewNameModel(found)
+	return model.Suggest(count, func(name string) bool {
+		return scanned[strings.ToLower(name)]
+	}), len(found)
+}
+
+// roleBaseName returns the role name without its path.
+func roleBaseName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+func writeSuggestions(w io.Writer, suggestions []arn.Suggestion) error {
+	for _, s := range suggestions {
+		if _, err := fmt.Fprintf(w, "%s # score %.4g\n", s.Name, s.Score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggestRoles(t *testing.T) {
+	results := map[scanner.Key]utils.Info{
+		{AccountID: "111111111111", Arn: "arn:aws:iam::111111111111:role/ci/ci-deploy-role"}: {Exists: true},
+		{AccountID: "222222222222", Arn: "arn:aws:iam::222222222222:role/cd-deploy-prod"}:    {Exists: true},
+		{AccountID: "222222222222", Arn: "arn:aws:iam::222222222222:role/ci-deploy-prod"}:    {Exists: false},
+		{AccountID: "222222222222", Arn: "arn:aws:iam::222222222222:user/cd-deploy-dev"}:     {Exists: true},
+	}
+	seq := func(yield func(scanner.Key, utils.Info) bool) {
+		for key, info := range results {
+			if !yield(key, info) {
+				return
+			}
+		}
+	}
+
+	names := func(suggestions []arn.Suggestion) []string {
+		return lo.Map(suggestions, func(s arn.Suggestion, _ int) string { return s.Name })
+	}
+
+	// Every scanned name is skipped, ci-deploy-prod was already found not to exist. Users aren't learned from.
+	got, trained := suggestRoles(seq, "", 10)
+	assert.Equal(t, 2, trained)
+	assert.Equal(t, []string{"cd-deploy-role"}, names(got))
+
+	// Only names scanned in the account are skipped, ci-deploy-role exists in another account.
+	got, _ = suggestRoles(seq, "222222222222", 10)
+	assert.ElementsMatch(t, []string{"ci-deploy-role", "cd-deploy-role"}, names(got))
+
+	got, _ = suggestRoles(seq, "111111111111", 10)
+	assert.ElementsMatch(t, []string{"cd-deploy-prod", "ci-deploy-prod", "cd-deploy-role"}, names(got))
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSuggestions(&buf, []arn.Suggestion{{Name: "build-prod", Score: 1.0 / 3}}))
+	assert.Equal(t, "build-prod # score 0.3333\n", buf.String())
+}
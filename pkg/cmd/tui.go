@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"golang.org/x/term"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// tuiHistory is the number of findings and log lines the terminal UI keeps for its panes.
+const tuiHistory = 200
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// tui is the -tui terminal UI. It redraws every second with the throughput and throttling of each plugin, the latest
+// findings, and the latest log lines, and reads keys to pause, resume, or stop the scan.
+type tui struct {
+	monitor    *scanner.Monitor
+	name       string
+	candidates int
+	start      time.Time
+	// instances is the number of instances of each plugin, by registered plugin name.
+	instances map[string]int
+
+	mux      sync.Mutex
+	results  int
+	found    int
+	findings []string
+	logs     []string
+	partial  string
+
+	// prevScanned and prevAt are the totals and time of the last redraw, for the per second rates.
+	prevScanned map[string]int64
+	prevAt      time.Time
+}
+
+func newTUI(monitor *scanner.Monitor, name string, candidates int, scanPlugins []plugins.Plugin) *tui {
+	instances := map[string]int{}
+	for _, plugin := range scanPlugins {
+		instances[pluginGroup(plugin.Name())]++
+	}
+	return &tui{
+		monitor:     monitor,
+		name:        name,
+		candidates:  candidates,
+		start:       time.Now(),
+		instances:   instances,
+		prevScanned: map[string]int64{},
+	}
+}
+
+// pluginGroup returns the registered plugin an instance name belongs to, instance names start with the plugin name.
+func pluginGroup(instance string) string {
+	group, matched := instance, 0
+	for _, info := range registeredPlugins {
+		if len(info.name) > matched && strings.HasPrefix(instance, info.name+"-") {
+			group, matched = info.name, len(info.name)
+		}
+	}
+	return group
+}
+
+// groupStats sums the stats of plugin instances by the registered plugin they belong to.
+func groupStats(instances map[string]scanner.PluginStats) map[string]scanner.PluginStats {
+	byGroup := map[string]scanner.PluginStats{}
+	for instance, stats := range instances {
+		group := pluginGroup(instance)
+		sum := byGroup[group]
+		sum.Scanned += stats.Scanned
+		sum.Found += stats.Found
+		sum.Errors += stats.Errors
+		sum.Throttled += stats.Throttled
+		byGroup[group] = sum
+	}
+	return byGroup
+}
+
+// Result adds a scan result to the counts, and to the findings pane if the principal exists.
+func (t *tui) Result(principalArn string, info utils.Info) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	t.results++
+	if info.Exists {
+		t.found++
+		t.findings = appendHistory(t.findings, principalArn)
+	}
+}
+
+// Write adds log lines to the log pane, it's the output of the scan's loggers while the UI is running.
+func (t *tui) Write(p []byte) (int, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	lines := strings.Split(t.partial+ansiEscape.ReplaceAllString(string(p), ""), "\n")
+	t.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		t.logs = appendHistory(t.logs, line)
+	}
+	return len(p), nil
+}
+
+func appendHistory(lines []string, line string) []string {
+	lines = append(lines, line)
+	if len(lines) > tuiHistory {
+		lines = slices.Delete(lines, 0, len(lines)-tuiHistory)
+	}
+	return lines
+}
+
+// render returns the screen as lines no wider than width, the findings and log panes share what's left of height
+// after the header and plugin table.
+func (t *tui) render(width, height int, now time.Time) []string {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	state := "RUNNING"
+	if t.monitor.Paused() {
+		state = "PAUSED"
+	}
+	elapsed := now.Sub(t.start)
+	lines := []string{
+		fmt.Sprintf("roles: %s  %s  %s elapsed  %d/%d candidates  %d found", t.name, state, formatDuration(elapsed.Truncate(time.Second)), t.results, t.candidates, t.found),
+		"",
+	}
+
+	// Plugins without any instances called yet still get a row.
+	byGroup := groupStats(t.monitor.Stats())
+	groups := slices.Collect(maps.Keys(t.instances))
+	for group := range byGroup {
+		if _, ok := t.instances[group]; !ok {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+
+	interval := now.Sub(t.prevAt).Seconds()
+	table := &strings.Builder{}
+	tw := tabwriter.NewWriter(table, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLUGIN\tINSTANCES\tSCANNED\tPER SECOND\tFOUND\tERRORS\tTHROTTLED")
+	for _, group := range groups {
+		stats := byGroup[group]
+		rate := 0.0
+		if !t.prevAt.IsZero() && interval > 0 {
+			rate = float64(stats.Scanned-t.prevScanned[group]) / interval
+		}
+		throttled := "0"
+		if calls := stats.Scanned + stats.Errors; stats.Throttled > 0 {
+			throttled = fmt.Sprintf("%d (%.1f%%)", stats.Throttled, 100*float64(stats.Throttled)/float64(calls))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%d\t%d\t%s\n", group, t.instances[group], stats.Scanned, rate, stats.Found, stats.Errors, throttled)
+		t.prevScanned[group] = stats.Scanned
+	}
+	tw.Flush()
+	t.prevAt = now
+	lines = append(lines, strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")...)
+
+	footer := "p pause  r resume  q stop the scan"
+	// Each pane has a blank line and a title before it, and the footer has a blank line before it.
+	space := height - len(lines) - 6
+	findingLines := space / 2
+	lines = append(lines, "", fmt.Sprintf("FINDINGS (%d)", len(t.findings)))
+	lines = append(lines, lastLines(t.findings, findingLines)...)
+	lines = append(lines, "", "LOG")
+	lines = append(lines, lastLines(t.logs, space-findingLines)...)
+	lines = append(lines, "", footer)
+
+	for i, line := range lines {
+		if width > 0 && len(line) > width {
+			lines[i] = line[:width]
+		}
+	}
+	return lines
+}
+
+func lastLines(lines []string, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Start takes over the terminal until the returned func is called: the screen is redrawn every second on the
+// alternate screen, the loggers of ctx write to the log pane, and keys are read from stdin. q or ctrl-c calls stop.
+// The lines in the log pane are printed to stderr when the UI stops.
+func (t *tui) Start(ctx *utils.Context, stop func()) (func(), error) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return nil, fmt.Errorf("-tui needs a terminal")
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return nil, fmt.Errorf("setting up terminal: %s", err)
+	}
+
+	logOutput := ctx.SetLogOutput(t)
+
+	done := make(chan struct{})
+	stopped := false
+	drawMux := sync.Mutex{}
+	draw := func() {
+		drawMux.Lock()
+		defer drawMux.Unlock()
+		if stopped {
+			return
+		}
+
+		width, height, err := term.GetSize(out)
+		if err != nil {
+			width, height = 80, 24
+		}
+		lines := t.render(width, height, time.Now())
+		if len(lines) > height {
+			lines = lines[:height]
+		}
+		os.Stdout.WriteString("\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[K\x1b[J")
+	}
+
+	// Switch to the alternate screen and hide the cursor, the terminal is left as it was when the UI stops.
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	draw()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				draw()
+			}
+		}
+	}()
+
+	// The key reader blocks on stdin and can't be interrupted, it's left running once the UI stops and ignores any
+	// keys read after.
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, key := range buf[:n] {
+				t.key(key, stop)
+			}
+			draw()
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			drawMux.Lock()
+			stopped = true
+			drawMux.Unlock()
+
+			os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+			term.Restore(in, state)
+			ctx.SetLogOutput(logOutput)
+
+			// The log pane is gone with the alternate screen, so what it had is printed where the logs normally go.
+			t.mux.Lock()
+			defer t.mux.Unlock()
+			for _, line := range t.logs {
+				fmt.Fprintln(os.Stderr, line)
+			}
+		})
+	}, nil
+}
+
+// key handles a key press: p pauses, r resumes, space toggles between them, and q or ctrl-c calls stop.
+func (t *tui) key(key byte, stop func()) {
+	switch key {
+	case 'p':
+		t.monitor.Pause()
+	case 'r':
+		t.monitor.Resume()
+	case ' ':
+		if t.monitor.Paused() {
+			t.monitor.Resume()
+		} else {
+			t.monitor.Pause()
+		}
+	case 'q', 3:
+		// Resume so paused plugins see the scan ending instead of waiting.
+		t.monitor.Resume()
+		stop()
+	}
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginGroup(t *testing.T) {
+	assert.Equal(t, "sns", pluginGroup("sns-123456789012-us-east-1-0"))
+	assert.Equal(t, "ecr-public", pluginGroup("ecr-public-us-east-1-0"))
+	assert.Equal(t, "access-point", pluginGroup("access-point-123456789012-us-west-2-1"))
+	assert.Equal(t, "custom", pluginGroup("custom"))
+}
+
+func TestTUIWrite(t *testing.T) {
+	ui := newTUI(scanner.NewMonitor(), "test", 0, nil)
+
+	_, err := ui.Write([]byte("\x1b[31m[ERROR] \x1b[0mfirst\nsec"))
+	assert.NoError(t, err)
+	_, err = ui.Write([]byte("ond\n"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"[ERROR] first", "second"}, ui.logs)
+}
+
+// tuiTestScanner returns a scanner reporting to monitor with a dry run plugin named like an sns instance, and the
+// placeholder sns and sqs instances the UI counts.
+func tuiTestScanner(monitor *scanner.Monitor) (*scanner.Scanner, []plugins.Plugin) {
+	var instances []plugins.Plugin
+	for _, info := range registeredPlugins {
+		if info.name == "sns" || info.name == "sqs" {
+			instances = append(instances, info.new(placeholderConfigs(info), 1)...)
+		}
+	}
+	plugin := &dryRunPlugin{plugin: instances[0], name: instances[0].Name(), routed: &sync.Map{}}
+	return scanner.NewScanner(&scanner.NewScannerInput{Monitor: monitor, Plugins: [][]plugins.Plugin{{plugin}}}), instances
+}
+
+func TestTUIRender(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	monitor := scanner.NewMonitor()
+	scan, instances := tuiTestScanner(monitor)
+	ui := newTUI(monitor, "test", 10, instances)
+
+	ui.Result("arn:aws:iam::111111111111:root", utils.Info{Exists: true})
+	ui.Result("arn:aws:iam::111111111111:role/Admin", utils.Info{Exists: true})
+	ui.Result("arn:aws:iam::111111111111:role/Missing", utils.Info{})
+	ui.Write([]byte("[ERROR] scanning failed\n"))
+	for _, principalArn := range []string{"arn:aws:iam::111111111111:root", "arn:aws:iam::111111111111:role/Admin"} {
+		_, err := scan.Plugins[0].ScanArn(ctx, principalArn)
+		require.NoError(t, err)
+	}
+	monitor.Pause()
+
+	lines := ui.render(120, 30, ui.start.Add(2*time.Second))
+	assert.Equal(t, "roles: test  PAUSED  2s elapsed  3/10 candidates  2 found", lines[0])
+	assert.Regexp(t, `^PLUGIN\s+INSTANCES\s+SCANNED\s+PER SECOND\s+FOUND\s+ERRORS\s+THROTTLED$`, lines[2])
+	assert.Regexp(t, `^sns\s+1\s+2\s+0\.0\s+1\s+0\s+0$`, lines[3])
+	assert.Regexp(t, `^sqs\s+1\s+0\s+0\.0\s+0\s+0\s+0$`, lines[4])
+	assert.Contains(t, lines, "FINDINGS (2)")
+	assert.Contains(t, lines, "arn:aws:iam::111111111111:role/Admin")
+	assert.Contains(t, lines, "[ERROR] scanning failed")
+	assert.Equal(t, "p pause  r resume  q stop the scan", lines[len(lines)-1])
+
+	for _, line := range ui.render(20, 30, ui.start.Add(3*time.Second)) {
+		assert.LessOrEqual(t, len(line), 20)
+	}
+}
+
+func TestTUIRender_Rates(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	monitor := scanner.NewMonitor()
+	scan, instances := tuiTestScanner(monitor)
+	ui := newTUI(monitor, "test", 10, instances)
+
+	ui.render(120, 30, ui.start)
+	for i := range 4 {
+		_, err := scan.Plugins[0].ScanArn(ctx, fmt.Sprintf("arn:aws:iam::111111111111:role/role-%d", i))
+		require.NoError(t, err)
+	}
+	lines := ui.render(120, 30, ui.start.Add(2*time.Second))
+	assert.Regexp(t, `^sns\s+1\s+4\s+2\.0\s+0\s+0\s+0$`, lines[3])
+
+	// Without any new scans the rate drops back to zero.
+	lines = ui.render(120, 30, ui.start.Add(3*time.Second))
+	assert.Regexp(t, `^sns\s+1\s+4\s+0\.0\s+0\s+0\s+0$`, lines[3])
+}
+
+func TestTUIRender_SmallTerminal(t *testing.T) {
+	ui := newTUI(scanner.NewMonitor(), "test", 0, nil)
+	for i := range 10 {
+		ui.Result(fmt.Sprintf("arn:aws:iam::111111111111:role/role-%d", i), utils.Info{Exists: true})
+	}
+
+	// The panes are left empty when there isn't room for any of their lines.
+	lines := ui.render(80, 9, ui.start)
+	assert.Equal(t, []string{
+		"roles: test  RUNNING  0s elapsed  10/0 candidates  10 found",
+		"",
+		"PLUGIN  INSTANCES  SCANNED  PER SECOND  FOUND  ERRORS  THROTTLED",
+		"",
+		"FINDINGS (10)",
+		"",
+		"LOG",
+		"",
+		"p pause  r resume  q stop the scan",
+	}, lines)
+}
+
+func TestTUIKey(t *testing.T) {
+	monitor := scanner.NewMonitor()
+	ui := newTUI(monitor, "test", 0, nil)
+	stopped := false
+	stop := func() { stopped = true }
+
+	ui.key('p', stop)
+	assert.True(t, monitor.Paused())
+	ui.key('r', stop)
+	assert.False(t, monitor.Paused())
+	ui.key(' ', stop)
+	assert.True(t, monitor.Paused())
+	ui.key(' ', stop)
+	assert.False(t, monitor.Paused())
+	assert.False(t, stopped)
+
+	monitor.Pause()
+	ui.key('q', stop)
+	assert.True(t, stopped)
+	assert.False(t, monitor.Paused(), "stopping resumes so paused plugins see the scan end")
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputFiles are the file extension and content type of the results uploaded by -output-s3 for each -output format.
+var outputFiles = map[string]struct{ extension, contentType string }{
+	"text": {"txt", "text/plain"},
+	"json": {"jsonl", "application/x-ndjson"},
+	"csv":  {"csv", "text/csv"},
+}
+
+type IS3Putter interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// s3Upload uploads the results and summary of each run to s3://<bucket>/<prefix>/<name>/<start>/, so scheduled scans
+// leave their output somewhere other than the host they ran on.
+type s3Upload struct {
+	client IS3Putter
+	bucket string
+	prefix string
+}
+
+// newS3Upload returns an upload to uri, like s3://bucket/prefix/.
+func newS3Upload(cfg aws.Config, uri string) (*s3Upload, error) {
+	bucket, prefix, ok := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if !strings.HasPrefix(uri, "s3://") || bucket == "" {
+		return nil, fmt.Errorf("invalid -output-s3 %q: must be like s3://bucket/prefix/", uri)
+	}
+	if !ok {
+		prefix = ""
+	}
+	return &s3Upload{client: s3.NewFromConfig(cfg), bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+// Upload writes the results of run in format, and the run as summary.json next to them.
+func (u *s3Upload) Upload(ctx *utils.Context, name string, run scanner.Run, format string, results []byte) error {
+	dir := path.Join(u.prefix, name, run.Start.UTC().Format("20060102T150405Z"))
+
+	summary, err := json.MarshalIndent(struct {
+		Name string `json:"name"`
+		scanner.Run
+	}{Name: name, Run: run}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling run summary: %w", err)
+	}
+
+	for _, object := range []struct {
+		key         string
+		body        []byte
+		contentType string
+	}{
+		{path.Join(dir, "results."+outputFiles[format].extension), results, outputFiles[format].contentType},
+		{path.Join(dir, "summary.json"), summary, "application/json"},
+	} {
+		_, err := u.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(u.bucket),
+			Key:         aws.String(object.key),
+			Body:        bytes.NewReader(object.body),
+			ContentType: aws.String(object.contentType),
+		})
+		if err != nil {
+			return fmt.Errorf("putting s3://%s/%s: %s", u.bucket, object.key, err)
+		}
+		ctx.Info.Printf("uploaded s3://%s/%s", u.bucket, object.key)
+	}
+	return nil
+}
+
+// uploadBuffer holds the results written so far for -output-s3, it's safe to read while the scan writes to it.
+type uploadBuffer struct {
+	mux sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *uploadBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *uploadBuffer) Bytes() []byte {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// s3Sink uploads the results of a run in an -output format along with the run's summary when the scan finishes, and
+// the results so far every interval while it runs if interval is set, so a long scan has its partial results in S3.
+// The summary of a partial upload has no end time.
+type s3Sink struct {
+	upload   *s3Upload
+	name     string
+	format   string
+	interval time.Duration
+	results  *resultWriter
+	buf      *uploadBuffer
+
+	mux        sync.Mutex
+	run        scanner.Run
+	lastUpload time.Time
+}
+
+func newS3Sink(ctx *utils.Context, target string, opts sinkOptions) (Sink, error) {
+	upload, err := newS3Upload(opts.cfg, target)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := outputFiles[opts.format]; !ok {
+		return nil, fmt.Errorf("unknown output %q: must be text, json, or csv", opts.format)
+	}
+
+	buf := &uploadBuffer{}
+	results, err := newResultWriter(buf, opts.format)
+	if err != nil {
+		return nil, err
+	}
+	results.arnsOnly = opts.arnsOnly
+	return &s3Sink{
+		upload:     upload,
+		name:       opts.name,
+		format:     opts.format,
+		interval:   opts.interval,
+		results:    results,
+		buf:        buf,
+		run:        opts.run,
+		lastUpload: time.Now(),
+	}, nil
+}
+
+func (s *s3Sink) Write(ctx *utils.Context, finding Finding) error {
+	if err := s.results.Write(finding.Arn, finding.Info); err != nil {
+		return err
+	}
+	if finding.Info.Exists {
+		s.mux.Lock()
+		s.run.Findings++
+		s.mux.Unlock()
+	}
+	return nil
+}
+
+// Flush uploads the results so far if it's been interval since the last upload.
+func (s *s3Sink) Flush(ctx *utils.Context) error {
+	s.mux.Lock()
+	due := s.interval > 0 && time.Since(s.lastUpload) >= s.interval
+	if due {
+		s.lastUpload = time.Now()
+	}
+	run := s.run
+	s.mux.Unlock()
+
+	if !due {
+		return nil
+	}
+	if err := s.upload.Upload(ctx, s.name, run, s.format, s.buf.Bytes()); err != nil {
+		return fmt.Errorf("uploading partial results: %s", err)
+	}
+	return nil
+}
+
+func (s *s3Sink) Close(ctx *utils.Context) error {
+	s.mux.Lock()
+	run := s.run
+	s.mux.Unlock()
+
+	run.End = time.Now().UTC()
+	if err := s.upload.Upload(ctx, s.name, run, s.format, s.buf.Bytes()); err != nil {
+		return fmt.Errorf("uploading results: %s", err)
+	}
+	return nil
+}
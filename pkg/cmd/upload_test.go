@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockS3Putter struct {
+	objects      map[string]string
+	contentTypes map[string]string
+}
+
+func (m *mockS3Putter) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	key := "s3://" + aws.ToString(params.Bucket) + "/" + aws.ToString(params.Key)
+	m.objects[key] = string(body)
+	m.contentTypes[key] = aws.ToString(params.ContentType)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestNewS3Upload(t *testing.T) {
+	for uri, want := range map[string][2]string{
+		"s3://bucket/prefix/": {"bucket", "prefix"},
+		"s3://bucket/a/b":     {"bucket", "a/b"},
+		"s3://bucket":         {"bucket", ""},
+		"s3://bucket/":        {"bucket", ""},
+	} {
+		u, err := newS3Upload(aws.Config{}, uri)
+		require.NoError(t, err, uri)
+		assert.Equal(t, want, [2]string{u.bucket, u.prefix}, uri)
+	}
+
+	for _, uri := range []string{"bucket/prefix", "s3:///prefix", "https://bucket.s3.amazonaws.com/prefix"} {
+		_, err := newS3Upload(aws.Config{}, uri)
+		assert.Error(t, err, uri)
+	}
+}
+
+func TestS3Upload(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockS3Putter{objects: map[string]string{}, contentTypes: map[string]string{}}
+	u := &s3Upload{client: client, bucket: "bucket", prefix: "scans"}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	run := scanner.Run{ID: 4, Start: start, End: start.Add(time.Hour), Candidates: 10, Findings: 2}
+	require.NoError(t, u.Upload(ctx, "client/engagement", run, "csv", []byte("account,arn\n")))
+
+	assert.Equal(t, "account,arn\n", client.objects["s3://bucket/scans/client/engagement/20240102T030405Z/results.csv"])
+	assert.Equal(t, "text/csv", client.contentTypes["s3://bucket/scans/client/engagement/20240102T030405Z/results.csv"])
+
+	var summary map[string]any
+	require.NoError(t, json.Unmarshal([]byte(client.objects["s3://bucket/scans/client/engagement/20240102T030405Z/summary.json"]), &summary))
+	assert.Equal(t, "client/engagement", summary["name"])
+	assert.Equal(t, float64(4), summary["id"])
+	assert.Equal(t, float64(2), summary["findings"])
+	assert.Equal(t, "2024-01-02T04:04:05Z", summary["end"])
+
+	require.NoError(t, u.Upload(ctx, "default", run, "text", nil))
+	assert.Contains(t, client.objects, "s3://bucket/scans/default/20240102T030405Z/results.txt")
+}
+
+func TestS3Sink(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &lockedS3Putter{mockS3Putter: mockS3Putter{objects: map[string]string{}, contentTypes: map[string]string{}}}
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := &s3Sink{
+		upload:     &s3Upload{client: client, bucket: "bucket"},
+		name:       "default",
+		format:     "text",
+		interval:   time.Hour,
+		buf:        &uploadBuffer{},
+		run:        scanner.Run{ID: 1, Start: start, Candidates: 2},
+		lastUpload: time.Now(),
+	}
+	var err error
+	s.results, err = newResultWriter(s.buf, "text")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Admin", Info: utils.Info{Exists: true, Comment: "admin"}}))
+	require.NoError(t, s.Write(ctx, Finding{Arn: "arn:aws:iam::123456789012:role/Missing"}))
+
+	// Nothing is uploaded until the interval has passed since the last upload.
+	require.NoError(t, s.Flush(ctx))
+	assert.Empty(t, client.objects)
+
+	s.lastUpload = time.Now().Add(-time.Hour)
+	require.NoError(t, s.Flush(ctx))
+	var summary scanner.Run
+	require.NoError(t, json.Unmarshal([]byte(client.object("s3://bucket/default/20240102T030405Z/summary.json")), &summary))
+	assert.True(t, summary.End.IsZero(), "partial uploads have no end")
+	assert.Equal(t, 1, summary.Findings)
+
+	require.NoError(t, s.Close(ctx))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/Admin # admin\n", client.object("s3://bucket/default/20240102T030405Z/results.txt"))
+	require.NoError(t, json.Unmarshal([]byte(client.object("s3://bucket/default/20240102T030405Z/summary.json")), &summary))
+	assert.False(t, summary.End.IsZero())
+}
+
+// lockedS3Putter is a mockS3Putter that can be used from the upload goroutine.
+type lockedS3Putter struct {
+	mu sync.Mutex
+	mockS3Putter
+}
+
+func (m *lockedS3Putter) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mockS3Putter.PutObject(ctx, params, optFns...)
+}
+
+func (m *lockedS3Putter) object(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.objects[key]
+}
@@ -11,6 +11,8 @@ import (
 	s3controlTypes "github.com/aws/aws-sdk-go-v2/service/s3control/types"
 	"github.com/aws/smithy-go"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
 	"strings"
 )
 
@@ -18,18 +20,19 @@ import (
 func NewAccessPoints(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin {
 	results := []Plugin{}
 
-	for _, cfg := range cfgs {
+	for _, key := range slices.Sorted(maps.Keys(cfgs)) {
+		cfg := cfgs[key]
 		for i := 0; i < concurrency; i++ {
-			accessPointName := fmt.Sprintf("role-%s-%d", cfg.Region, i)
+			name := accessPointName(cfg.Region, i)
 			results = append(results, &AccessPoint{
 				ThreadConfig: cfg,
 				// Make sure each thread has its own unique bucket and access point name.
-				accessPointName: accessPointName,
-				bucketName:      fmt.Sprintf("role-fh9283f-s3-access-points-%s-%s-%d", cfg.Region, cfg.AccountId, i),
+				accessPointName: name,
+				bucketName:      accessPointBucketName(cfg.Region, cfg.AccountId, i),
 				thread:          i,
 				s3:              s3.NewFromConfig(cfg.Config),
 				s3control:       s3control.NewFromConfig(cfg.Config),
-				accesspointArn:  fmt.Sprintf("arn:aws:s3:%s:%s:accesspoint/%s", cfg.Region, cfg.AccountId, accessPointName),
+				accesspointArn:  fmt.Sprintf("arn:aws:s3:%s:%s:accesspoint/%s", cfg.Region, cfg.AccountId, name),
 			})
 		}
 	}
@@ -37,6 +40,80 @@ func NewAccessPoints(cfgs map[string]utils.ThreadConfig, concurrency int) []Plug
 	return results
 }
 
+// accessPointName returns the name of the access point of thread in the region.
+func accessPointName(region string, thread int) string {
+	return fmt.Sprintf("role-%s-%d", region, thread)
+}
+
+// accessPointBucketPrefix is the prefix of the names of the buckets of the access points NewAccessPoints creates.
+const accessPointBucketPrefix = ResourcePrefix + "s3-access-points-"
+
+// accessPointBucketName returns the name of the bucket of the access point of thread in the account and region.
+func accessPointBucketName(region, accountId string, thread int) string {
+	return fmt.Sprintf("%s%s-%s-%d", accessPointBucketPrefix, region, accountId, thread)
+}
+
+// AccessPointLeftovers returns the ARNs of the buckets named like the ones NewAccessPoints creates in the account and
+// region of cfg and of the access points of those buckets, for checking -clean deleted them.
+func AccessPointLeftovers(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+	return accessPointLeftovers(ctx, s3.NewFromConfig(cfg.Config), s3control.NewFromConfig(cfg.Config), cfg.AccountId, cfg.Region)
+}
+
+func accessPointLeftovers(ctx *utils.Context, s3Client s3.ListBucketsAPIClient, s3controlClient s3control.ListAccessPointsAPIClient, accountId string, region string) ([]string, error) {
+	buckets, err := s3BucketLeftovers(ctx, s3Client, region, accessPointBucketPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var arns []string
+	for _, bucket := range buckets {
+		arns = append(arns, "arn:aws:s3:::"+bucket)
+		paginator := s3control.NewListAccessPointsPaginator(s3controlClient, &s3control.ListAccessPointsInput{
+			AccountId: aws.String(accountId),
+			Bucket:    aws.String(bucket),
+		})
+		for paginator.HasMorePages() {
+			resp, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("listing access points of %s: %s", bucket, err)
+			}
+			for _, accessPoint := range resp.AccessPointList {
+				arns = append(arns, aws.ToString(accessPoint.AccessPointArn))
+			}
+		}
+	}
+	return arns, nil
+}
+
+// AccessPointStackResources returns the buckets and access points NewAccessPoints uses as resources of the StackSet
+// template.
+func AccessPointStackResources(concurrency int) map[string]StackResource {
+	resources := map[string]StackResource{}
+	for i := 0; i < concurrency; i++ {
+		bucket := fmt.Sprintf("AccessPointBucket%d", i)
+		resources[bucket] = StackResource{
+			Type: "AWS::S3::Bucket",
+			Properties: map[string]any{
+				"BucketName": stackSub(func(region, accountId string) string { return accessPointBucketName(region, accountId, i) }),
+			},
+		}
+		resources[fmt.Sprintf("AccessPoint%d", i)] = StackResource{
+			Type: "AWS::S3::AccessPoint",
+			Properties: map[string]any{
+				"Name":   stackSub(func(region, _ string) string { return accessPointName(region, i) }),
+				"Bucket": map[string]any{"Ref": bucket},
+				"PublicAccessBlockConfiguration": map[string]any{
+					"BlockPublicAcls":       true,
+					"BlockPublicPolicy":     true,
+					"IgnorePublicAcls":      true,
+					"RestrictPublicBuckets": true,
+				},
+			},
+		}
+	}
+	return resources
+}
+
 type AccessPoint struct {
 	utils.ThreadConfig
 	thread          int
@@ -51,6 +128,11 @@ func (s *AccessPoint) Name() string {
 	return fmt.Sprintf("access-point-%s-%s-%d", s.AccountId, s.Region, s.thread)
 }
 
+// ResourceArns returns the ARNs of the bucket and access point Setup creates.
+func (s *AccessPoint) ResourceArns() []string {
+	return []string{"arn:aws:s3:::" + s.bucketName, s.accesspointArn}
+}
+
 // Setup creates the bucket for this region if it doesn't exist.
 func (s *AccessPoint) Setup(ctx *utils.Context) error {
 	var conf *s3Types.CreateBucketConfiguration
@@ -117,6 +199,26 @@ func setupAccessPoint(ctx *utils.Context, api *s3control.Client, name, bucket, a
 	return *accessPoint.AccessPointArn, nil
 }
 
+// IsSetup reports whether the bucket and the access point exist.
+func (s *AccessPoint) IsSetup(ctx *utils.Context) (bool, error) {
+	if ok, err := bucketExists(ctx, s.s3, s.bucketName); !ok || err != nil {
+		return ok, err
+	}
+
+	_, err := s.s3control.GetAccessPoint(ctx, &s3control.GetAccessPointInput{
+		Name:      &s.accessPointName,
+		AccountId: &s.AccountId,
+	})
+	oe := &smithy.GenericAPIError{}
+	if errors.As(err, &oe) && oe.ErrorCode() == "NoSuchAccessPoint" {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("get accesspoint: %w", err)
+	}
+
+	return true, nil
+}
+
 func (s *AccessPoint) ScanArn(ctx *utils.Context, arn string) (bool, error) {
 	policy, err := json.Marshal(utils.GenerateTrustPolicy(s.accesspointArn, "*", arn))
 	if err != nil {
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -26,7 +28,8 @@ func NewECRPublicRepositories(cfgs map[string]utils.ThreadConfig, concurrency in
 	// ECR public only supports us-east-1, there is an us-west-2 endpoint, but it doesn't support the CreateRepository
 	// and SetRepositoryPolicy operations.
 
-	for _, cfg := range cfgs {
+	for _, key := range slices.Sorted(maps.Keys(cfgs)) {
+		cfg := cfgs[key]
 		// Skip regions that don't support ECR Public
 		if cfg.Region != "us-east-1" {
 			continue
@@ -34,7 +37,7 @@ func NewECRPublicRepositories(cfgs map[string]utils.ThreadConfig, concurrency in
 		ecrPublicClient := ecrpublic.NewFromConfig(cfg.Config)
 
 		for i := 0; i < concurrency; i++ {
-			repositoryName := fmt.Sprintf("role-fh9283f-ecr-public-%s-%s-%d", cfg.Region, cfg.AccountId, i)
+			repositoryName := ecrPublicRepositoryName(cfg.Region, cfg.AccountId, i)
 			// Construct the ARN deterministically
 			results = append(results, &ECRPublicRepository{
 				ThreadConfig:   cfg,
@@ -49,6 +52,53 @@ func NewECRPublicRepositories(cfgs map[string]utils.ThreadConfig, concurrency in
 	return results
 }
 
+// ecrPublicRepositoryPrefix is the prefix of the names of the repositories NewECRPublicRepositories creates.
+const ecrPublicRepositoryPrefix = ResourcePrefix + "ecr-public-"
+
+// ecrPublicRepositoryName returns the name of the repository of thread in the account and region.
+func ecrPublicRepositoryName(region, accountId string, thread int) string {
+	return fmt.Sprintf("%s%s-%s-%d", ecrPublicRepositoryPrefix, region, accountId, thread)
+}
+
+// ECRPublicRepositoryLeftovers returns the ARNs of the repositories named like the ones NewECRPublicRepositories
+// creates in the account of cfg, for checking -clean deleted them. They're only created in us-east-1.
+func ECRPublicRepositoryLeftovers(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+	return ecrPublicRepositoryLeftovers(ctx, ecrpublic.NewFromConfig(cfg.Config))
+}
+
+func ecrPublicRepositoryLeftovers(ctx *utils.Context, client ecrpublic.DescribeRepositoriesAPIClient) ([]string, error) {
+	var arns []string
+	paginator := ecrpublic.NewDescribeRepositoriesPaginator(client, &ecrpublic.DescribeRepositoriesInput{})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing repositories: %s", err)
+		}
+		for _, repository := range resp.Repositories {
+			if strings.HasPrefix(aws.ToString(repository.RepositoryName), ecrPublicRepositoryPrefix) {
+				arns = append(arns, aws.ToString(repository.RepositoryArn))
+			}
+		}
+	}
+	return arns, nil
+}
+
+// ECRPublicRepositoryStackResources returns the repositories NewECRPublicRepositories uses as resources of the
+// StackSet template, they're only created in us-east-1.
+func ECRPublicRepositoryStackResources(concurrency int) map[string]StackResource {
+	resources := map[string]StackResource{}
+	for i := 0; i < concurrency; i++ {
+		resources[fmt.Sprintf("ECRPublicRepository%d", i)] = StackResource{
+			Type:      "AWS::ECR::PublicRepository",
+			Condition: StackConditionUSEast1,
+			Properties: map[string]any{
+				"RepositoryName": stackSub(func(region, accountId string) string { return ecrPublicRepositoryName(region, accountId, i) }),
+			},
+		}
+	}
+	return resources
+}
+
 // ECRPublicRepository implements the Plugin interface for ECR Public.
 type ECRPublicRepository struct {
 	utils.ThreadConfig
@@ -64,6 +114,11 @@ func (r *ECRPublicRepository) Name() string {
 	return fmt.Sprintf("ecr-public-%s-%d", r.Region, r.thread)
 }
 
+// ResourceArns returns the ARN of the repository Setup creates.
+func (r *ECRPublicRepository) ResourceArns() []string {
+	return []string{r.repositoryArn}
+}
+
 // Setup creates the ECR Public repository if it doesn't already exist.
 func (r *ECRPublicRepository) Setup(ctx *utils.Context) error {
 	// Attempt to create the repository if it doesn't already exist.
@@ -78,6 +133,21 @@ func (r *ECRPublicRepository) Setup(ctx *utils.Context) error {
 	return nil
 }
 
+// IsSetup reports whether the ECR Public repository exists.
+func (r *ECRPublicRepository) IsSetup(ctx *utils.Context) (bool, error) {
+	_, err := r.client.DescribeRepositories(ctx, &ecrpublic.DescribeRepositoriesInput{
+		RepositoryNames: []string{r.repositoryName},
+	})
+	var notFoundErr *types.RepositoryNotFoundException
+	if errors.As(err, &notFoundErr) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("describing repository: %w", err)
+	}
+
+	return true, nil
+}
+
 // ScanArn attempts to set a policy referencing the provided principal ARN and returns
 // true if the principal is valid/existing, false if not.
 func (r *ECRPublicRepository) ScanArn(ctx *utils.Context, arn string) (bool, error) {
@@ -16,14 +16,19 @@ import (
 // mockECRPublicClient implements the methods used by ECRPublicRepository via the ecrpublic.Client.
 type mockECRPublicClient struct {
 	// Track calls for assertions.
-	CreateRepoCalls          int
-	SetRepositoryPolicyCalls int
-	DeleteRepositoryCalls    int
+	CreateRepoCalls           int
+	SetRepositoryPolicyCalls  int
+	DeleteRepositoryCalls     int
+	DescribeRepositoriesCalls int
 
 	// Control whether calls return an error.
-	CreateRepoError          error
-	SetRepositoryPolicyError error
-	DeleteRepositoryError    error
+	CreateRepoError           error
+	SetRepositoryPolicyError  error
+	DeleteRepositoryError     error
+	DescribeRepositoriesError error
+
+	// Repositories are returned by DescribeRepositories.
+	Repositories []types.Repository
 }
 
 // CreateRepository mock.
@@ -56,6 +61,16 @@ func (m *mockECRPublicClient) DeleteRepository(
 	return &ecrpublic.DeleteRepositoryOutput{}, m.DeleteRepositoryError
 }
 
+// DescribeRepositories mock.
+func (m *mockECRPublicClient) DescribeRepositories(
+	_ context.Context,
+	_ *ecrpublic.DescribeRepositoriesInput,
+	_ ...func(*ecrpublic.Options),
+) (*ecrpublic.DescribeRepositoriesOutput, error) {
+	m.DescribeRepositoriesCalls++
+	return &ecrpublic.DescribeRepositoriesOutput{Repositories: m.Repositories}, m.DescribeRepositoriesError
+}
+
 // TestNewECRPublicRepositories tests the creation of plugins, skipping of unsupported regions,
 // concurrency, and so on.
 func TestNewECRPublicRepositories(t *testing.T) {
@@ -201,3 +216,44 @@ func TestNewECRPublicRepositories_UnsupportedRegion(t *testing.T) {
 	require.Len(t, plugs, 1, "should only create plugin for us-east-1")
 	assert.Equal(t, "ecr-public-us-east-1-0", plugs[0].Name())
 }
+
+// TestECRPublicIsSetup tests that a missing repository isn't an error.
+func TestECRPublicIsSetup(t *testing.T) {
+	mockClient := &mockECRPublicClient{}
+	repo := &ECRPublicRepository{
+		repositoryName: "role-fh9283f-ecr-public-us-east-1-123456789012-0",
+		client:         mockClient,
+	}
+
+	ctx := utils.NewContext(context.Background())
+	ok, err := repo.IsSetup(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	mockClient.DescribeRepositoriesError = &types.RepositoryNotFoundException{}
+	ok, err = repo.IsSetup(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mockClient.DescribeRepositoriesError = errors.New("access denied")
+	_, err = repo.IsSetup(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 3, mockClient.DescribeRepositoriesCalls)
+}
+
+// TestECRPublicRepositoryLeftovers tests that only the repositories named like the plugin's are returned.
+func TestECRPublicRepositoryLeftovers(t *testing.T) {
+	mockClient := &mockECRPublicClient{Repositories: []types.Repository{
+		{RepositoryName: aws.String("role-fh9283f-ecr-public-us-east-1-123456789012-0"), RepositoryArn: aws.String("arn:aws:ecr-public::123456789012:repository/role-fh9283f-ecr-public-us-east-1-123456789012-0")},
+		{RepositoryName: aws.String("app"), RepositoryArn: aws.String("arn:aws:ecr-public::123456789012:repository/app")},
+	}}
+
+	ctx := utils.NewContext(context.Background())
+	arns, err := ecrPublicRepositoryLeftovers(ctx, mockClient)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"arn:aws:ecr-public::123456789012:repository/role-fh9283f-ecr-public-us-east-1-123456789012-0"}, arns)
+
+	mockClient.DescribeRepositoriesError = errors.New("access denied")
+	_, err = ecrPublicRepositoryLeftovers(ctx, mockClient)
+	assert.ErrorContains(t, err, "listing repositories")
+}
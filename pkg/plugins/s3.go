@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,12 +23,13 @@ type NewS3BucketInput struct {
 func NewS3Buckets(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin {
 	results := []Plugin{}
 
-	for _, cfg := range cfgs {
+	for _, key := range slices.Sorted(maps.Keys(cfgs)) {
+		cfg := cfgs[key]
 		for i := 0; i < concurrency; i++ {
 			results = append(results, &S3Bucket{
 				ThreadConfig: cfg,
 				thread:       i,
-				bucketName:   fmt.Sprintf("role-fh9283f-s3-bucket-%s-%s-%d", cfg.Region, cfg.AccountId, i),
+				bucketName:   s3BucketName(cfg.Region, cfg.AccountId, i),
 				s3Client:     s3.NewFromConfig(cfg.Config),
 			})
 		}
@@ -35,6 +38,58 @@ func NewS3Buckets(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin
 	return results
 }
 
+// s3BucketPrefix is the prefix of the names of the buckets NewS3Buckets creates.
+const s3BucketPrefix = ResourcePrefix + "s3-bucket-"
+
+// s3BucketName returns the name of the bucket of thread in the account and region.
+func s3BucketName(region, accountId string, thread int) string {
+	return fmt.Sprintf("%s%s-%s-%d", s3BucketPrefix, region, accountId, thread)
+}
+
+// S3BucketLeftovers returns the ARNs of the buckets named like the ones NewS3Buckets creates in the account and region
+// of cfg, for checking -clean deleted them.
+func S3BucketLeftovers(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+	names, err := s3BucketLeftovers(ctx, s3.NewFromConfig(cfg.Config), cfg.Region, s3BucketPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var arns []string
+	for _, name := range names {
+		arns = append(arns, "arn:aws:s3:::"+name)
+	}
+	return arns, nil
+}
+
+// s3BucketLeftovers returns the names of the buckets in region starting with prefix.
+func s3BucketLeftovers(ctx *utils.Context, client s3.ListBucketsAPIClient, region string, prefix string) ([]string, error) {
+	var names []string
+	paginator := s3.NewListBucketsPaginator(client, &s3.ListBucketsInput{BucketRegion: aws.String(region), Prefix: aws.String(prefix)})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing buckets: %s", err)
+		}
+		for _, bucket := range resp.Buckets {
+			names = append(names, aws.ToString(bucket.Name))
+		}
+	}
+	return names, nil
+}
+
+// S3BucketStackResources returns the buckets NewS3Buckets uses as resources of the StackSet template.
+func S3BucketStackResources(concurrency int) map[string]StackResource {
+	resources := map[string]StackResource{}
+	for i := 0; i < concurrency; i++ {
+		resources[fmt.Sprintf("S3Bucket%d", i)] = StackResource{
+			Type: "AWS::S3::Bucket",
+			Properties: map[string]any{
+				"BucketName": stackSub(func(region, accountId string) string { return s3BucketName(region, accountId, i) }),
+			},
+		}
+	}
+	return resources
+}
+
 type S3Bucket struct {
 	utils.ThreadConfig
 	bucketName string
@@ -46,6 +101,11 @@ func (s *S3Bucket) Name() string {
 	return fmt.Sprintf("s3-%s-%s-%d", s.AccountId, s.Region, s.thread)
 }
 
+// ResourceArns returns the ARN of the bucket Setup creates.
+func (s *S3Bucket) ResourceArns() []string {
+	return []string{"arn:aws:s3:::" + s.bucketName}
+}
+
 // Setup creates the S3 bucket if it doesn't already exist.
 func (s *S3Bucket) Setup(ctx *utils.Context) error {
 	var conf *s3Types.CreateBucketConfiguration
@@ -73,6 +133,26 @@ func (s *S3Bucket) Setup(ctx *utils.Context) error {
 	return nil
 }
 
+// IsSetup reports whether the S3 bucket exists.
+func (s *S3Bucket) IsSetup(ctx *utils.Context) (bool, error) {
+	return bucketExists(ctx, s.s3Client, s.bucketName)
+}
+
+// bucketExists reports whether bucket exists and is owned by us.
+func bucketExists(ctx *utils.Context, api *s3.Client, bucket string) (bool, error) {
+	_, err := api.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: &bucket,
+	})
+	var notFound *s3Types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("head bucket %s: %w", bucket, err)
+	}
+
+	return true, nil
+}
+
 // ScanArn attempts to update the bucket policy using the given ARN.
 // If the ARN is invalid (non-existent role), a "MalformedPolicy" error
 // containing "invalid principal" is returned by AWS.
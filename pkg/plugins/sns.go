@@ -8,6 +8,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sns/types"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
 	"strings"
 )
 
@@ -15,12 +17,13 @@ import (
 func NewSNSTopics(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin {
 	var results []Plugin
 
-	for _, cfg := range cfgs {
+	for _, key := range slices.Sorted(maps.Keys(cfgs)) {
+		cfg := cfgs[key]
 		// Create a single sns.Client per region
 		snsClient := sns.NewFromConfig(cfg.Config)
 
 		for i := 0; i < concurrency; i++ {
-			topicName := fmt.Sprintf("role-fh9283f-sns-%s-%s-%d", cfg.Region, cfg.AccountId, i)
+			topicName := snsTopicName(cfg.Region, cfg.AccountId, i)
 
 			results = append(results, &SNSTopic{
 				ThreadConfig: cfg,
@@ -35,6 +38,52 @@ func NewSNSTopics(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin
 	return results
 }
 
+// snsTopicPrefix is the prefix of the names of the topics NewSNSTopics creates.
+const snsTopicPrefix = ResourcePrefix + "sns-"
+
+// snsTopicName returns the name of the topic of thread in the account and region.
+func snsTopicName(region, accountId string, thread int) string {
+	return fmt.Sprintf("%s%s-%s-%d", snsTopicPrefix, region, accountId, thread)
+}
+
+// SNSTopicLeftovers returns the ARNs of the topics named like the ones NewSNSTopics creates in the account and region
+// of cfg, for checking -clean deleted them.
+func SNSTopicLeftovers(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+	return snsTopicLeftovers(ctx, sns.NewFromConfig(cfg.Config))
+}
+
+func snsTopicLeftovers(ctx *utils.Context, client sns.ListTopicsAPIClient) ([]string, error) {
+	var arns []string
+	paginator := sns.NewListTopicsPaginator(client, &sns.ListTopicsInput{})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing topics: %s", err)
+		}
+		for _, topic := range resp.Topics {
+			topicArn := aws.ToString(topic.TopicArn)
+			if strings.HasPrefix(topicArn[strings.LastIndex(topicArn, ":")+1:], snsTopicPrefix) {
+				arns = append(arns, topicArn)
+			}
+		}
+	}
+	return arns, nil
+}
+
+// SNSTopicStackResources returns the topics NewSNSTopics uses as resources of the StackSet template.
+func SNSTopicStackResources(concurrency int) map[string]StackResource {
+	resources := map[string]StackResource{}
+	for i := 0; i < concurrency; i++ {
+		resources[fmt.Sprintf("SNSTopic%d", i)] = StackResource{
+			Type: "AWS::SNS::Topic",
+			Properties: map[string]any{
+				"TopicName": stackSub(func(region, accountId string) string { return snsTopicName(region, accountId, i) }),
+			},
+		}
+	}
+	return resources
+}
+
 type SNSTopic struct {
 	utils.ThreadConfig
 	thread    int
@@ -48,6 +97,11 @@ func (t *SNSTopic) Name() string {
 	return fmt.Sprintf("sns-%s-%s-%d", t.AccountId, t.Region, t.thread)
 }
 
+// ResourceArns returns the ARN of the topic Setup creates.
+func (t *SNSTopic) ResourceArns() []string {
+	return []string{t.topicArn}
+}
+
 // Setup creates the SNS topic if it doesn't already exist.
 func (t *SNSTopic) Setup(ctx *utils.Context) error {
 	ctx.Debug.Printf("creating SNS topic %s", t.topicName)
@@ -61,6 +115,21 @@ func (t *SNSTopic) Setup(ctx *utils.Context) error {
 	return nil
 }
 
+// IsSetup reports whether the SNS topic exists.
+func (t *SNSTopic) IsSetup(ctx *utils.Context) (bool, error) {
+	_, err := t.snsClient.GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{
+		TopicArn: &t.topicArn,
+	})
+	var notFound *types.NotFoundException
+	if errors.As(err, &notFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("getting topic attributes: %w", err)
+	}
+
+	return true, nil
+}
+
 // ScanArn updates the SNS topic policy referencing the provided ARN and returns true if the principal is valid.
 func (t *SNSTopic) ScanArn(ctx *utils.Context, arn string) (bool, error) {
 	// Generate a trust policy referencing the topic ARN and the target role ARN
@@ -18,11 +18,25 @@ type mockSNSClient struct {
 	CreateTopicCount        int
 	SetTopicAttributesCount int
 	DeleteTopicCount        int
+	GetTopicAttributesCount int
 
 	// Control errors for each method to simulate real scenarios.
 	CreateTopicError        error
 	SetTopicAttributesError error
 	DeleteTopicError        error
+	GetTopicAttributesError error
+
+	// Topics are returned by ListTopics.
+	Topics []types.Topic
+}
+
+// ListTopics mock
+func (m *mockSNSClient) ListTopics(
+	_ context.Context,
+	_ *sns.ListTopicsInput,
+	_ ...func(*sns.Options),
+) (*sns.ListTopicsOutput, error) {
+	return &sns.ListTopicsOutput{Topics: m.Topics}, nil
 }
 
 // CreateTopic mock
@@ -55,6 +69,16 @@ func (m *mockSNSClient) DeleteTopic(
 	return &sns.DeleteTopicOutput{}, m.DeleteTopicError
 }
 
+// GetTopicAttributes mock
+func (m *mockSNSClient) GetTopicAttributes(
+	_ context.Context,
+	_ *sns.GetTopicAttributesInput,
+	_ ...func(*sns.Options),
+) (*sns.GetTopicAttributesOutput, error) {
+	m.GetTopicAttributesCount++
+	return &sns.GetTopicAttributesOutput{}, m.GetTopicAttributesError
+}
+
 // TestNewSNSTopics tests the plugin creation logic (for each region/thread).
 func TestNewSNSTopics(t *testing.T) {
 	// Suppose we have two regions. For concurrency=2, that means we expect 2 threads per region -> 4 total plugins.
@@ -176,6 +200,29 @@ func TestSNSTopicCleanUp(t *testing.T) {
 	assert.Equal(t, 2, mockClient.DeleteTopicCount)
 }
 
+// TestSNSTopicIsSetup tests that a missing topic isn't an error.
+func TestSNSTopicIsSetup(t *testing.T) {
+	mockClient := &mockSNSClient{}
+	topic := &SNSTopic{
+		topicArn:  "arn:aws:sns:us-east-1:123456789012:role-fh9283f-sns-us-east-1-123456789012-0",
+		snsClient: mockClient,
+	}
+
+	ctx := utils.NewContext(context.Background())
+	ok, err := topic.IsSetup(ctx)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	mockClient.GetTopicAttributesError = &types.NotFoundException{}
+	ok, err = topic.IsSetup(ctx)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	mockClient.GetTopicAttributesError = errors.New("access denied")
+	_, err = topic.IsSetup(ctx)
+	assert.Error(t, err)
+}
+
 // Example test that verifies the Name() method produces the expected string.
 func TestSNSTopicName(t *testing.T) {
 	topic := &SNSTopic{
@@ -187,3 +234,15 @@ func TestSNSTopicName(t *testing.T) {
 	}
 	assert.Equal(t, "sns-111111111111-us-west-2-3", topic.Name())
 }
+
+// Test that only the topics named like the plugin's are left over.
+func TestSNSTopicLeftovers(t *testing.T) {
+	mockClient := &mockSNSClient{Topics: []types.Topic{
+		{TopicArn: aws.String("arn:aws:sns:us-west-2:111111111111:role-fh9283f-sns-us-west-2-111111111111-0")},
+		{TopicArn: aws.String("arn:aws:sns:us-west-2:111111111111:alerts")},
+	}}
+
+	arns, err := snsTopicLeftovers(utils.NewContext(context.Background()), mockClient)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"arn:aws:sns:us-west-2:111111111111:role-fh9283f-sns-us-west-2-111111111111-0"}, arns)
+}
@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
 	"strings"
 )
 
@@ -18,19 +21,20 @@ type NewSQSInput struct {
 func NewSQSQueues(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin {
 	var results []Plugin
 
-	for region, cfg := range cfgs {
+	for _, key := range slices.Sorted(maps.Keys(cfgs)) {
+		cfg := cfgs[key]
 		sqsClient := sqs.NewFromConfig(cfg.Config)
 
 		for i := 0; i < concurrency; i++ {
-			queueName := fmt.Sprintf("role-fh9283f-sqs-%s-%s-%d", region, cfg.AccountId, i)
+			queueName := sqsQueueName(cfg.Region, cfg.AccountId, i)
 
 			results = append(results, &SQSQueue{
 				ThreadConfig: cfg,
 				thread:       i,
 				queueName:    queueName,
 				sqsClient:    sqsClient,
-				queueUrl:     fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", region, cfg.AccountId, queueName),
-				queueArn:     fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, cfg.AccountId, queueName),
+				queueUrl:     fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", cfg.Region, cfg.AccountId, queueName),
+				queueArn:     fmt.Sprintf("arn:aws:sqs:%s:%s:%s", cfg.Region, cfg.AccountId, queueName),
 			})
 		}
 	}
@@ -38,6 +42,49 @@ func NewSQSQueues(cfgs map[string]utils.ThreadConfig, concurrency int) []Plugin
 	return results
 }
 
+// sqsQueuePrefix is the prefix of the names of the queues NewSQSQueues creates.
+const sqsQueuePrefix = ResourcePrefix + "sqs-"
+
+// sqsQueueName returns the name of the queue of thread in the account and region.
+func sqsQueueName(region, accountId string, thread int) string {
+	return fmt.Sprintf("%s%s-%s-%d", sqsQueuePrefix, region, accountId, thread)
+}
+
+// SQSQueueLeftovers returns the ARNs of the queues named like the ones NewSQSQueues creates in the account and region
+// of cfg, for checking -clean deleted them.
+func SQSQueueLeftovers(ctx *utils.Context, cfg utils.ThreadConfig) ([]string, error) {
+	return sqsQueueLeftovers(ctx, sqs.NewFromConfig(cfg.Config), cfg.AccountId, cfg.Region)
+}
+
+func sqsQueueLeftovers(ctx *utils.Context, client sqs.ListQueuesAPIClient, accountId string, region string) ([]string, error) {
+	var arns []string
+	paginator := sqs.NewListQueuesPaginator(client, &sqs.ListQueuesInput{QueueNamePrefix: aws.String(sqsQueuePrefix)})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing queues: %s", err)
+		}
+		for _, queueUrl := range resp.QueueUrls {
+			arns = append(arns, fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, accountId, queueUrl[strings.LastIndex(queueUrl, "/")+1:]))
+		}
+	}
+	return arns, nil
+}
+
+// SQSQueueStackResources returns the queues NewSQSQueues uses as resources of the StackSet template.
+func SQSQueueStackResources(concurrency int) map[string]StackResource {
+	resources := map[string]StackResource{}
+	for i := 0; i < concurrency; i++ {
+		resources[fmt.Sprintf("SQSQueue%d", i)] = StackResource{
+			Type: "AWS::SQS::Queue",
+			Properties: map[string]any{
+				"QueueName": stackSub(func(region, accountId string) string { return sqsQueueName(region, accountId, i) }),
+			},
+		}
+	}
+	return resources
+}
+
 type SQSQueue struct {
 	utils.ThreadConfig
 
@@ -53,6 +100,11 @@ func (s *SQSQueue) Name() string {
 	return fmt.Sprintf("sqs-%s-%d", s.Region, s.thread)
 }
 
+// ResourceArns returns the ARN of the queue Setup creates.
+func (s *SQSQueue) ResourceArns() []string {
+	return []string{s.queueArn}
+}
+
 // Setup creates the queue and retrieves its URL and ARN.
 // This method is now responsible for actually provisioning the SQS resource.
 func (s *SQSQueue) Setup(ctx *utils.Context) error {
@@ -66,6 +118,21 @@ func (s *SQSQueue) Setup(ctx *utils.Context) error {
 	return nil
 }
 
+// IsSetup reports whether the SQS queue exists.
+func (s *SQSQueue) IsSetup(ctx *utils.Context) (bool, error) {
+	_, err := s.sqsClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: &s.queueName,
+	})
+	var notFound *types.QueueDoesNotExist
+	if errors.As(err, &notFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("getting queue url: %w", err)
+	}
+
+	return true, nil
+}
+
 // ScanArn attempts to update the SQS queue policy referencing the provided ARN.
 // If the role ARN doesn't exist, SQS will typically return an error referencing "invalid principal" or "PrincipalNotFound".
 func (s *SQSQueue) ScanArn(ctx *utils.Context, arn string) (bool, error) {
@@ -0,0 +1,24 @@
+package plugins
+
+// StackResource is a CloudFormation resource of the template that deploys the plugin resources with a StackSet, it's
+// marshalled as is into the template's Resources.
+type StackResource struct {
+	Type       string         `json:"Type"`
+	Condition  string         `json:"Condition,omitempty"`
+	Properties map[string]any `json:"Properties"`
+}
+
+// StackConditionUSEast1 is the template condition of resources that only exist in us-east-1.
+const StackConditionUSEast1 = "IsUSEast1"
+
+// StackConditions are the conditions StackResource.Condition can refer to.
+var StackConditions = map[string]any{
+	StackConditionUSEast1: map[string]any{"Fn::Equals": []any{map[string]any{"Ref": "AWS::Region"}, "us-east-1"}},
+}
+
+// stackSub returns name with the region and account ID of the stack instance the resource is deployed in, name is
+// called with the placeholders Fn::Sub replaces instead of a real region and account ID. The same functions name the
+// plugin instances, so the resources the StackSet creates are the ones the plugins use.
+func stackSub(name func(region string, accountId string) string) map[string]any {
+	return map[string]any{"Fn::Sub": name("${AWS::Region}", "${AWS::AccountId}")}
+}
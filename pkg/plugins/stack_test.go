@@ -0,0 +1,61 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stackNames returns the names resources would get in the account and region, by logical ID.
+func stackNames(t *testing.T, resources map[string]StackResource, property, region, accountId string) map[string]string {
+	names := map[string]string{}
+	for id, resource := range resources {
+		sub, ok := resource.Properties[property].(map[string]any)
+		require.True(t, ok, "%s has no %s", id, property)
+		names[id] = strings.NewReplacer("${AWS::Region}", region, "${AWS::AccountId}", accountId).Replace(sub["Fn::Sub"].(string))
+	}
+	return names
+}
+
+// TestStackResources checks that the StackSet template creates the resources the plugin instances use.
+func TestStackResources(t *testing.T) {
+	cfgs := map[string]utils.ThreadConfig{
+		"111111111111-us-east-1": {AccountId: "111111111111", Region: "us-east-1"},
+	}
+
+	sns := stackNames(t, SNSTopicStackResources(2), "TopicName", "us-east-1", "111111111111")
+	for i, p := range NewSNSTopics(cfgs, 2) {
+		assert.Equal(t, p.(*SNSTopic).topicName, sns[fmt.Sprintf("SNSTopic%d", i)])
+	}
+
+	sqs := stackNames(t, SQSQueueStackResources(2), "QueueName", "us-east-1", "111111111111")
+	for i, p := range NewSQSQueues(cfgs, 2) {
+		queue := p.(*SQSQueue)
+		assert.Equal(t, queue.queueName, sqs[fmt.Sprintf("SQSQueue%d", i)])
+		assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/111111111111/"+queue.queueName, queue.queueUrl)
+	}
+
+	buckets := stackNames(t, S3BucketStackResources(1), "BucketName", "us-east-1", "111111111111")
+	assert.Equal(t, NewS3Buckets(cfgs, 1)[0].(*S3Bucket).bucketName, buckets["S3Bucket0"])
+
+	accessPoints := AccessPointStackResources(1)
+	accessPoint := NewAccessPoints(cfgs, 1)[0].(*AccessPoint)
+	assert.Equal(t, accessPoint.bucketName, stackNames(t, map[string]StackResource{"AccessPointBucket0": accessPoints["AccessPointBucket0"]}, "BucketName", "us-east-1", "111111111111")["AccessPointBucket0"])
+	assert.Equal(t, accessPoint.accessPointName, stackNames(t, map[string]StackResource{"AccessPoint0": accessPoints["AccessPoint0"]}, "Name", "us-east-1", "111111111111")["AccessPoint0"])
+	assert.Equal(t, map[string]any{"Ref": "AccessPointBucket0"}, accessPoints["AccessPoint0"].Properties["Bucket"])
+
+	repositories := ECRPublicRepositoryStackResources(1)
+	assert.Equal(t, NewECRPublicRepositories(cfgs, 1)[0].(*ECRPublicRepository).repositoryName, stackNames(t, repositories, "RepositoryName", "us-east-1", "111111111111")["ECRPublicRepository0"])
+	assert.Equal(t, StackConditionUSEast1, repositories["ECRPublicRepository0"].Condition)
+	assert.Contains(t, StackConditions, StackConditionUSEast1)
+
+	// Resources without a condition don't have one in the template.
+	doc, err := json.Marshal(S3BucketStackResources(1))
+	require.NoError(t, err)
+	assert.NotContains(t, string(doc), "Condition")
+}
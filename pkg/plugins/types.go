@@ -2,9 +2,12 @@ package plugins
 
 import (
 	"context"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"slices"
+	"strings"
 )
 
 type Plugin interface {
@@ -14,14 +17,97 @@ type Plugin interface {
 	CleanUp(ctx *utils.Context) error
 }
 
+// SetupChecker is implemented by plugins that can check whether Setup created their resources, without changing
+// anything.
+type SetupChecker interface {
+	IsSetup(ctx *utils.Context) (bool, error)
+}
+
+// Inventoried is implemented by plugins that know the ARNs of the resources Setup creates, they're saved in the setup
+// inventory -clean works from.
+type Inventoried interface {
+	ResourceArns() []string
+}
+
+// ResourcePrefix is the prefix of the names of the resources plugins create, besides access points whose names are too
+// short for it. -clean checks nothing named with it is left after cleaning up.
+const ResourcePrefix = "role-fh9283f-"
+
+// ResourceArns returns the ARNs of the resources plugin's Setup creates, or nil if it doesn't implement Inventoried.
+func ResourceArns(plugin Plugin) []string {
+	if i, ok := plugin.(Inventoried); ok {
+		return i.ResourceArns()
+	}
+	return nil
+}
+
+// Located is implemented by plugins that make their calls in a scanning account and region, every plugin embedding a
+// utils.ThreadConfig does.
+type Located interface {
+	Location() (accountId string, region string)
+}
+
+// Location returns the scanning account and region of plugin, or empty strings if it doesn't implement Located.
+func Location(plugin Plugin) (accountId string, region string) {
+	if l, ok := plugin.(Located); ok {
+		return l.Location()
+	}
+	return "", ""
+}
+
+// Principal types are the resource type of an IAM principal ARN, the part of the resource before the first /.
+const (
+	PrincipalRoot         = "root"
+	PrincipalRole         = "role"
+	PrincipalUser         = "user"
+	PrincipalSAMLProvider = "saml-provider"
+	PrincipalOIDCProvider = "oidc-provider"
+)
+
+// DefaultPrincipalTypes are the principal types a plugin is assumed to validate when it doesn't implement
+// Capabilities.
+var DefaultPrincipalTypes = []string{PrincipalRoot, PrincipalRole, PrincipalUser}
+
+// Capabilities is implemented by plugins that validate principal types other than DefaultPrincipalTypes, such as
+// SAML or OIDC provider ARNs.
+type Capabilities interface {
+	// PrincipalTypes returns every principal type ScanArn can validate, including the default ones it supports.
+	PrincipalTypes() []string
+}
+
+// PrincipalTypes returns the principal types plugin can validate.
+func PrincipalTypes(plugin Plugin) []string {
+	if c, ok := plugin.(Capabilities); ok {
+		return c.PrincipalTypes()
+	}
+	return DefaultPrincipalTypes
+}
+
+// PrincipalType returns the principal type of principalArn, or an empty string if it isn't an IAM ARN.
+func PrincipalType(principalArn string) string {
+	parsed, err := arn.Parse(principalArn)
+	if err != nil || parsed.Service != "iam" {
+		return ""
+	}
+	principalType, _, _ := strings.Cut(parsed.Resource, "/")
+	return principalType
+}
+
+// Supports reports whether plugin can validate principalArn.
+func Supports(plugin Plugin, principalArn string) bool {
+	return slices.Contains(PrincipalTypes(plugin), PrincipalType(principalArn))
+}
+
 type IECRPublicClient interface {
 	CreateRepository(ctx context.Context, params *ecrpublic.CreateRepositoryInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.CreateRepositoryOutput, error)
 	SetRepositoryPolicy(ctx context.Context, params *ecrpublic.SetRepositoryPolicyInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.SetRepositoryPolicyOutput, error)
+	DescribeRepositories(ctx context.Context, params *ecrpublic.DescribeRepositoriesInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DescribeRepositoriesOutput, error)
 	DeleteRepository(ctx context.Context, params *ecrpublic.DeleteRepositoryInput, optFns ...func(*ecrpublic.Options)) (*ecrpublic.DeleteRepositoryOutput, error)
 }
 
 type ISNSClient interface {
 	CreateTopic(ctx context.Context, params *sns.CreateTopicInput, optFns ...func(*sns.Options)) (*sns.CreateTopicOutput, error)
 	SetTopicAttributes(ctx context.Context, params *sns.SetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.SetTopicAttributesOutput, error)
+	GetTopicAttributes(ctx context.Context, params *sns.GetTopicAttributesInput, optFns ...func(*sns.Options)) (*sns.GetTopicAttributesOutput, error)
 	DeleteTopic(ctx context.Context, params *sns.DeleteTopicInput, optFns ...func(*sns.Options)) (*sns.DeleteTopicOutput, error)
 }
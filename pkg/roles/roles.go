@@ -0,0 +1,331 @@
+// Package roles is the stable Go API for embedding roles in other tools, it scans for IAM principals in other accounts
+// the same way the roles command does, using plugins set up beforehand with roles -setup.
+//
+// This package follows semantic versioning: exported identifiers in it won't change incompatibly or be removed within
+// a major version. The other packages in this module, like pkg/cmd and pkg/scanner, are implementation details that
+// may change in any release.
+//
+//	client, err := roles.NewClient(ctx, roles.Options{Profile: "scanner"})
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//
+//	results, err := client.Scan(ctx, roles.ScanInput{Accounts: []string{"123456789012"}, Roles: []string{"Admin"}})
+package roles
+
+import (
+	"context"
+	"fmt"
+	awsarn "github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/ryanjarv/roles/pkg/arn"
+	"github.com/ryanjarv/roles/pkg/cmd"
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
+	"strings"
+	"time"
+)
+
+// DefaultRateLimit is the number of principals scanned per second when Options.RateLimit isn't set.
+const DefaultRateLimit = 5
+
+// Options configure a Client.
+type Options struct {
+	// Profile is the AWS profile plugins were set up with, the default credential chain is used if it's empty.
+	Profile string
+	// Name is the scan name results are stored under, like -name (default: default).
+	Name string
+	// Storage is where results are stored, like -storage (default: ~/.roles).
+	Storage string
+	// RateLimit is the number of principals scanned per second, up to 50 and under 1 for slower scans (default:
+	// DefaultRateLimit).
+	RateLimit float64
+	// RateBurst is the most principals scanned at once after the scan has been idle, like -rate-burst, up to 50
+	// (default: RateLimit rounded up).
+	RateBurst int
+	// AccountRateLimit is the most principals scanned per second from each scanning account, like
+	// -account-rate-limit, 0 for no limit.
+	AccountRateLimit int
+	// SkipRootCheck skips validating account root ARNs and scans principals directly.
+	SkipRootCheck bool
+	// Verbose logs progress to stdout, only errors are logged to stderr otherwise.
+	Verbose bool
+	// ExternalID is passed when assuming the role in each scanning account, like -external-id.
+	ExternalID string
+	// SessionDuration is how long the credentials of the role in each scanning account last, like -session-duration.
+	SessionDuration time.Duration
+	// SessionPolicy limits the role in each scanning account like -session-policy, either "scoped" for only the APIs
+	// roles uses or the path of a policy document.
+	SessionPolicy string
+	// ScanningAccounts is the path of a file of the scanning accounts to use instead of the organization's tagged
+	// accounts, like -scanning-accounts.
+	ScanningAccounts string
+	// ScanningAccountTag is the key=value tag of the organization's accounts to scan from, like -scanning-account-tag.
+	ScanningAccountTag string
+	// ScanningAccountIds are the organization's accounts to scan from instead of tagged accounts, like
+	// -scanning-account-ids.
+	ScanningAccountIds []string
+	// ScanningAccountRole is the role to assume in scanning accounts that don't name one, like -scanning-account-role.
+	ScanningAccountRole string
+	// BastionRole is the ARN of a role to assume first and assume the role in each scanning account from, like
+	// -bastion-role.
+	BastionRole string
+	// Regions are the regions of the scanning accounts to scan from, like -regions, every enabled region if empty.
+	Regions []string
+}
+
+// ScanInput selects the principals to scan for. Lists are in the same format as the lines of -accounts, -roles, and
+// -principals lists, and may use the same templates.
+type ScanInput struct {
+	Accounts []string
+	// Roles are role names, with an optional path.
+	Roles []string
+	// Principals are prefixed with role/ or user/, bare names are tried as both.
+	Principals []string
+	// Wordlists are the names of built-in wordlists, like -wordlist.
+	Wordlists []string
+	// Vars are template variable lists, like -var.
+	Vars map[string][]string
+	// Force rescans principals with stored results.
+	Force bool
+	// MaxCandidates limits the number of principals a scan can generate, 0 uses the same default as the roles command
+	// and -1 disables the limit.
+	MaxCandidates int
+	// OnResult is called with each result as it's found, if set.
+	OnResult func(Result)
+}
+
+// Result is a scanned principal.
+type Result struct {
+	ARN       string
+	AccountID string
+	// PrincipalType is role or user, and empty for account root ARNs.
+	PrincipalType string
+	PrincipalName string
+	Exists        bool
+	Comment       string
+	// Plugin is the name of the plugin that checked the principal.
+	Plugin string
+	// FirstSeen is when the current verdict was first recorded.
+	FirstSeen   time.Time
+	LastChecked time.Time
+	Tags        []string
+	Likelihood  float64
+}
+
+// principalScanner is the part of scanner.Scanner the client uses.
+type principalScanner interface {
+	ScanArns(ctx *utils.Context, candidates map[string]utils.Info) iter.Seq2[string, utils.Info]
+}
+
+// Client scans for principals and looks up stored results, it's safe to use from multiple goroutines but scans share
+// the rate limit only within a single call to Scan.
+type Client struct {
+	opts       Options
+	assumeRole utils.AssumeRoleOptions
+	// scanRegions are the regions of the scanning accounts the client scans from.
+	scanRegions utils.RegionSelection
+	storage     scanner.Storage
+	regions     map[string]utils.Info
+	// newScanner returns the scanner for a scan, force rescans stored results.
+	newScanner func(force bool) principalScanner
+}
+
+// NewClient loads the AWS configs of the accounts plugins were set up in and opens the storage backend, the client
+// should be closed when it's no longer needed.
+func NewClient(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	if opts.RateLimit == 0 {
+		opts.RateLimit = DefaultRateLimit
+	}
+	if opts.RateLimit < 0 || opts.RateLimit > 50 {
+		return nil, fmt.Errorf("rate limit must be more than 0 and at most 50")
+	}
+	if opts.RateBurst < 0 || opts.RateBurst > 50 {
+		return nil, fmt.Errorf("rate burst must be between 0 and 50")
+	}
+	if opts.AccountRateLimit < 0 {
+		return nil, fmt.Errorf("account rate limit can't be negative")
+	}
+
+	assumeRole, err := cmd.NewAssumeRoleOptions(opts.ExternalID, opts.SessionDuration, opts.SessionPolicy, opts.ScanningAccounts, opts.ScanningAccountRole, opts.BastionRole)
+	if err != nil {
+		return nil, err
+	}
+	if assumeRole.Selection, err = cmd.NewAccountSelection(opts.ScanningAccountTag, opts.ScanningAccountIds); err != nil {
+		return nil, err
+	}
+
+	regions, err := cmd.NewRegionSelection(opts.Regions)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{opts: opts, assumeRole: assumeRole, scanRegions: regions, regions: cmd.Regions()}
+	rctx := c.context(ctx)
+	cfg, cfgs, err := cmd.LoadScanConfigs(rctx, opts.Profile, assumeRole, regions)
+	if err != nil {
+		return nil, err
+	}
+	if c.storage, err = scanner.NewStorage(rctx, cfg, opts.Storage, opts.Name); err != nil {
+		return nil, fmt.Errorf("new storage: %s", err)
+	}
+
+	plugins := cmd.LoadAllPlugins(cfgs)
+	c.newScanner = func(force bool) principalScanner {
+		return scanner.NewScanner(&scanner.NewScannerInput{
+			Storage:          c.storage,
+			Force:            force,
+			Plugins:          plugins,
+			RateLimit:        opts.RateLimit,
+			RateBurst:        opts.RateBurst,
+			AccountRateLimit: opts.AccountRateLimit,
+			SkipRootCheck:    opts.SkipRootCheck,
+		})
+	}
+	return c, nil
+}
+
+// Close releases the storage backend.
+func (c *Client) Close() error {
+	return c.storage.Close()
+}
+
+// Scan scans for the principals input selects and returns their results, stored results are used for principals that
+// were already scanned unless input.Force is set. Scans are refused outside of the engagement stored for the scan
+// name, and each one is recorded as a run in roles stats.
+func (c *Client) Scan(ctx context.Context, input ScanInput) ([]Result, error) {
+	rctx := c.context(ctx)
+	md, err := c.storage.Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("getting metadata: %s", err)
+	}
+	if md.Engagement != nil {
+		if err := md.Engagement.Active(time.Now()); err != nil {
+			return nil, fmt.Errorf("refusing to scan %s: %s", c.opts.Name, err)
+		}
+	}
+
+	maxCandidates := input.MaxCandidates
+	if maxCandidates == 0 {
+		maxCandidates = arn.DefaultMaxCandidates
+	} else if maxCandidates < 0 {
+		maxCandidates = 0
+	}
+	candidates, err := arn.GetArns(rctx, &arn.GetArnsInput{
+		AccountsStr:   strings.Join(input.Accounts, ","),
+		Roles:         input.Roles,
+		Principals:    input.Principals,
+		Wordlists:     input.Wordlists,
+		Vars:          input.Vars,
+		MaxCandidates: maxCandidates,
+		Regions:       c.regions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting candidates: %s", err)
+	}
+
+	start := time.Now().UTC()
+	var runID int
+	err = c.storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		runID = md.StartRun(scanner.Run{
+			Start: start,
+			Options: scanner.RunOptions{
+				Accounts:  strings.Join(input.Accounts, ","),
+				Wordlists: strings.Join(input.Wordlists, ","),
+				Vars:      input.Vars,
+				Force:     input.Force,
+				RateLimit: c.opts.RateLimit,
+				RateBurst: c.opts.RateBurst,
+			},
+			Candidates: len(candidates),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("recording run: %s", err)
+	}
+
+	var results []Result
+	findings := 0
+	for principalArn, info := range c.newScanner(input.Force).ScanArns(rctx, candidates) {
+		result := newResult(principalArn, info)
+		results = append(results, result)
+		if result.Exists {
+			findings++
+		}
+		if input.OnResult != nil {
+			input.OnResult(result)
+		}
+	}
+
+	if err := c.storage.Save(); err != nil {
+		return nil, fmt.Errorf("saving storage: %s", err)
+	}
+	err = c.storage.UpdateMetadata(func(md *scanner.Metadata) error {
+		if run := md.Run(runID); run != nil {
+			run.End = time.Now().UTC()
+			run.Findings = findings
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("recording run: %s", err)
+	}
+	return results, ctx.Err()
+}
+
+// Lookup returns the stored result for principalArn without scanning it, ok is false if it hasn't been scanned.
+func (c *Client) Lookup(ctx context.Context, principalArn string) (result Result, ok bool, err error) {
+	key, err := scanner.NewKey(principalArn)
+	if err != nil {
+		return Result{}, false, err
+	}
+	info, status, err := c.storage.Get(key)
+	if err != nil {
+		return Result{}, false, fmt.Errorf("getting %s: %s", principalArn, err)
+	}
+	if status == scanner.PrincipalUnknown {
+		return Result{}, false, nil
+	}
+	return newResult(principalArn, info), true, nil
+}
+
+// Cleanup removes the resources roles -setup created for the plugins, like roles -cleanup.
+func (c *Client) Cleanup(ctx context.Context) error {
+	return cmd.CleanUp(c.context(ctx), cmd.Opts{Profile: c.opts.Profile, AssumeRole: c.assumeRole, Regions: c.scanRegions})
+}
+
+// context returns ctx with the client's logging.
+func (c *Client) context(ctx context.Context) *utils.Context {
+	rctx := utils.NewContext(ctx)
+	if c.opts.Verbose {
+		rctx.SetLoggingLevel(utils.InfoLogLevel)
+	} else {
+		rctx.SetLoggingLevel(utils.ErrorLogLevel)
+	}
+	return rctx
+}
+
+func newResult(principalArn string, info utils.Info) Result {
+	result := Result{
+		ARN:         principalArn,
+		Exists:      info.Exists,
+		Comment:     info.Comment,
+		Plugin:      info.Plugin,
+		FirstSeen:   info.FirstSeen,
+		LastChecked: info.LastChecked,
+		Tags:        info.Tags,
+		Likelihood:  info.Likelihood,
+	}
+	if parsed, err := awsarn.Parse(principalArn); err == nil {
+		result.AccountID = parsed.AccountID
+		if kind, name, ok := strings.Cut(parsed.Resource, "/"); ok {
+			result.PrincipalType, result.PrincipalName = kind, name
+		}
+	}
+	return result
+}
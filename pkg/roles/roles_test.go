@@ -0,0 +1,115 @@
+package roles
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/scanner"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScanner reports roots and the candidates named Admin as existing and stores every result.
+type fakeScanner struct {
+	storage scanner.Storage
+}
+
+func (f *fakeScanner) ScanArns(ctx *utils.Context, candidates map[string]utils.Info) iter.Seq2[string, utils.Info] {
+	return func(yield func(string, utils.Info) bool) {
+		for principalArn, candidate := range candidates {
+			info := utils.Info{
+				Comment:     candidate.Comment,
+				Exists:      strings.HasSuffix(principalArn, ":root") || strings.HasSuffix(principalArn, "/Admin"),
+				Plugin:      "fake",
+				LastChecked: time.Now().UTC(),
+			}
+			if key, err := scanner.NewKey(principalArn); err == nil {
+				f.storage.Set(key, info)
+			}
+			if !yield(principalArn, info) {
+				return
+			}
+		}
+	}
+}
+
+func newTestClient(t *testing.T) *Client {
+	ctx := utils.NewContext(context.Background())
+	storage, err := scanner.NewFileStorage(ctx, t.TempDir(), "test", scanner.StorageOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	scan := &fakeScanner{storage: storage}
+	return &Client{
+		opts:       Options{Name: "test", RateLimit: DefaultRateLimit},
+		storage:    storage,
+		regions:    map[string]utils.Info{"us-east-1": {}},
+		newScanner: func(force bool) principalScanner { return scan },
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	var streamed []string
+	results, err := client.Scan(ctx, ScanInput{
+		Accounts:   []string{"123456789012"},
+		Roles:      []string{"Admin"},
+		Principals: []string{"user/alice"},
+		OnResult:   func(r Result) { streamed = append(streamed, r.ARN) },
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Len(t, streamed, 3)
+
+	found := map[string]Result{}
+	for _, result := range results {
+		found[result.ARN] = result
+	}
+	admin := found["arn:aws:iam::123456789012:role/Admin"]
+	assert.True(t, admin.Exists)
+	assert.Equal(t, "123456789012", admin.AccountID)
+	assert.Equal(t, "role", admin.PrincipalType)
+	assert.Equal(t, "Admin", admin.PrincipalName)
+	assert.Equal(t, "fake", admin.Plugin)
+	assert.False(t, found["arn:aws:iam::123456789012:user/alice"].Exists)
+
+	md, err := client.storage.Metadata()
+	require.NoError(t, err)
+	require.Len(t, md.Runs, 1)
+	assert.Equal(t, 2, md.Runs[0].Findings)
+}
+
+func TestClient_Lookup(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	_, ok, err := client.Lookup(ctx, "arn:aws:iam::123456789012:role/Admin")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = client.Scan(ctx, ScanInput{Accounts: []string{"123456789012"}, Roles: []string{"Admin"}})
+	require.NoError(t, err)
+
+	result, ok, err := client.Lookup(ctx, "arn:aws:iam::123456789012:role/Admin")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, result.Exists)
+
+	_, _, err = client.Lookup(ctx, "not an arn")
+	assert.Error(t, err)
+}
+
+func TestClient_ScanInvalidInput(t *testing.T) {
+	client := newTestClient(t)
+
+	_, err := client.Scan(context.Background(), ScanInput{Accounts: []string{"123456789012"}, Wordlists: []string{"missing"}})
+	assert.Error(t, err)
+	_, err = client.Scan(context.Background(), ScanInput{Accounts: []string{"123456789012"}, Roles: []string{"a[01-99]"}, MaxCandidates: 10})
+	assert.Error(t, err)
+}
@@ -0,0 +1,296 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: roles/v1/roles.proto
+
+// Package roles.v1 is the gRPC API of roles serve, for embedding the scanner as a backend service. It mirrors the REST
+// API: scans are submitted with inline candidate lists, run one at a time against a shared storage backend, and their
+// results are streamed as they're found.
+
+package rolespb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ScanService_SubmitScan_FullMethodName    = "/roles.v1.ScanService/SubmitScan"
+	ScanService_GetScan_FullMethodName       = "/roles.v1.ScanService/GetScan"
+	ScanService_ListScans_FullMethodName     = "/roles.v1.ScanService/ListScans"
+	ScanService_StreamResults_FullMethodName = "/roles.v1.ScanService/StreamResults"
+	ScanService_QueryResults_FullMethodName  = "/roles.v1.ScanService/QueryResults"
+)
+
+// ScanServiceClient is the client API for ScanService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ScanServiceClient interface {
+	// SubmitScan expands the request into candidates and queues a scan for them, invalid input is returned as
+	// INVALID_ARGUMENT right away.
+	SubmitScan(ctx context.Context, in *SubmitScanRequest, opts ...grpc.CallOption) (*Scan, error)
+	// GetScan returns the status and progress of a scan.
+	GetScan(ctx context.Context, in *GetScanRequest, opts ...grpc.CallOption) (*Scan, error)
+	// ListScans returns every submitted scan.
+	ListScans(ctx context.Context, in *ListScansRequest, opts ...grpc.CallOption) (*ListScansResponse, error)
+	// StreamResults streams the results of a scan as they're found, it ends when the scan finishes.
+	StreamResults(ctx context.Context, in *StreamResultsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Result], error)
+	// QueryResults streams the stored results matching the request, like roles export.
+	QueryResults(ctx context.Context, in *QueryResultsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Result], error)
+}
+
+type scanServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScanServiceClient(cc grpc.ClientConnInterface) ScanServiceClient {
+	return &scanServiceClient{cc}
+}
+
+func (c *scanServiceClient) SubmitScan(ctx context.Context, in *SubmitScanRequest, opts ...grpc.CallOption) (*Scan, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Scan)
+	err := c.cc.Invoke(ctx, ScanService_SubmitScan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scanServiceClient) GetScan(ctx context.Context, in *GetScanRequest, opts ...grpc.CallOption) (*Scan, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Scan)
+	err := c.cc.Invoke(ctx, ScanService_GetScan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scanServiceClient) ListScans(ctx context.Context, in *ListScansRequest, opts ...grpc.CallOption) (*ListScansResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListScansResponse)
+	err := c.cc.Invoke(ctx, ScanService_ListScans_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scanServiceClient) StreamResults(ctx context.Context, in *StreamResultsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Result], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ScanService_ServiceDesc.Streams[0], ScanService_StreamResults_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamResultsRequest, Result]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ScanService_StreamResultsClient = grpc.ServerStreamingClient[Result]
+
+func (c *scanServiceClient) QueryResults(ctx context.Context, in *QueryResultsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Result], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ScanService_ServiceDesc.Streams[1], ScanService_QueryResults_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryResultsRequest, Result]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ScanService_QueryResultsClient = grpc.ServerStreamingClient[Result]
+
+// ScanServiceServer is the server API for ScanService service.
+// All implementations must embed UnimplementedScanServiceServer
+// for forward compatibility.
+type ScanServiceServer interface {
+	// SubmitScan expands the request into candidates and queues a scan for them, invalid input is returned as
+	// INVALID_ARGUMENT right away.
+	SubmitScan(context.Context, *SubmitScanRequest) (*Scan, error)
+	// GetScan returns the status and progress of a scan.
+	GetScan(context.Context, *GetScanRequest) (*Scan, error)
+	// ListScans returns every submitted scan.
+	ListScans(context.Context, *ListScansRequest) (*ListScansResponse, error)
+	// StreamResults streams the results of a scan as they're found, it ends when the scan finishes.
+	StreamResults(*StreamResultsRequest, grpc.ServerStreamingServer[Result]) error
+	// QueryResults streams the stored results matching the request, like roles export.
+	QueryResults(*QueryResultsRequest, grpc.ServerStreamingServer[Result]) error
+	mustEmbedUnimplementedScanServiceServer()
+}
+
+// UnimplementedScanServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedScanServiceServer struct{}
+
+func (UnimplementedScanServiceServer) SubmitScan(context.Context, *SubmitScanRequest) (*Scan, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitScan not implemented")
+}
+func (UnimplementedScanServiceServer) GetScan(context.Context, *GetScanRequest) (*Scan, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetScan not implemented")
+}
+func (UnimplementedScanServiceServer) ListScans(context.Context, *ListScansRequest) (*ListScansResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListScans not implemented")
+}
+func (UnimplementedScanServiceServer) StreamResults(*StreamResultsRequest, grpc.ServerStreamingServer[Result]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamResults not implemented")
+}
+func (UnimplementedScanServiceServer) QueryResults(*QueryResultsRequest, grpc.ServerStreamingServer[Result]) error {
+	return status.Errorf(codes.Unimplemented, "method QueryResults not implemented")
+}
+func (UnimplementedScanServiceServer) mustEmbedUnimplementedScanServiceServer() {}
+func (UnimplementedScanServiceServer) testEmbeddedByValue()                     {}
+
+// UnsafeScanServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScanServiceServer will
+// result in compilation errors.
+type UnsafeScanServiceServer interface {
+	mustEmbedUnimplementedScanServiceServer()
+}
+
+func RegisterScanServiceServer(s grpc.ServiceRegistrar, srv ScanServiceServer) {
+	// If the following call pancis, it indicates UnimplementedScanServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ScanService_ServiceDesc, srv)
+}
+
+func _ScanService_SubmitScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScanServiceServer).SubmitScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScanService_SubmitScan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScanServiceServer).SubmitScan(ctx, req.(*SubmitScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScanService_GetScan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScanServiceServer).GetScan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScanService_GetScan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScanServiceServer).GetScan(ctx, req.(*GetScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScanService_ListScans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListScansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScanServiceServer).ListScans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ScanService_ListScans_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScanServiceServer).ListScans(ctx, req.(*ListScansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ScanService_StreamResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamResultsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScanServiceServer).StreamResults(m, &grpc.GenericServerStream[StreamResultsRequest, Result]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ScanService_StreamResultsServer = grpc.ServerStreamingServer[Result]
+
+func _ScanService_QueryResults_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryResultsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScanServiceServer).QueryResults(m, &grpc.GenericServerStream[QueryResultsRequest, Result]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ScanService_QueryResultsServer = grpc.ServerStreamingServer[Result]
+
+// ScanService_ServiceDesc is the grpc.ServiceDesc for ScanService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ScanService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "roles.v1.ScanService",
+	HandlerType: (*ScanServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitScan",
+			Handler:    _ScanService_SubmitScan_Handler,
+		},
+		{
+			MethodName: "GetScan",
+			Handler:    _ScanService_GetScan_Handler,
+		},
+		{
+			MethodName: "ListScans",
+			Handler:    _ScanService_ListScans_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResults",
+			Handler:       _ScanService_StreamResults_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "QueryResults",
+			Handler:       _ScanService_QueryResults_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "roles/v1/roles.proto",
+}
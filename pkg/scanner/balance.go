@@ -0,0 +1,239 @@
+package scanner
+
+import (
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// balanceSlack is how many more requests a scanning account can be issued than the least used account that's still
+// scanning, before its plugins wait for the others to catch up.
+const balanceSlack = 10
+
+// balanceMaxWait is the longest a plugin waits for the other accounts to catch up, so an account whose plugins are
+// stuck, like on refreshing credentials, slows the rest of the scan down instead of stopping it.
+const balanceMaxWait = time.Second
+
+// balancer spreads the requests of a scan evenly across the scanning accounts, so one account doesn't make most of
+// them, and the CloudTrail events that come with them, because its plugins happen to respond faster. It counts the
+// requests issued in each account and region. With a perAccount limit, the plugins of an account that issued that many
+// requests in the last second stop taking ARNs until the next second, so the other accounts scan them instead. A nil
+// balancer doesn't balance, limit, or count anything.
+type balancer struct {
+	mux sync.Mutex
+	// requests are the requests issued in each account and region, by account ID and then region.
+	requests map[string]map[string]int64
+	// issued is the total of requests of each account.
+	issued map[string]int64
+	// active is the number of running plugins in each account, only accounts with running plugins are balanced.
+	active map[string]int
+	// changed is closed and replaced whenever issued or active changes, for waiting plugins to check again.
+	changed chan struct{}
+	// perAccount is the most requests issued in an account each second, 0 for no limit.
+	perAccount int
+	// window is the number of requests reserved in each account since windowStart.
+	window      map[string]int
+	windowStart time.Time
+}
+
+func newBalancer(perAccount int) *balancer {
+	return &balancer{
+		requests:    map[string]map[string]int64{},
+		issued:      map[string]int64{},
+		active:      map[string]int{},
+		changed:     make(chan struct{}),
+		perAccount:  perAccount,
+		window:      map[string]int{},
+		windowStart: time.Now(),
+	}
+}
+
+// start registers a running plugin, call stop once it's done scanning.
+func (b *balancer) start(plugin plugins.Plugin) {
+	accountId, _ := plugins.Location(plugin)
+	if b == nil || accountId == "" {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.active[accountId]++
+	b.notify()
+}
+
+func (b *balancer) stop(plugin plugins.Plugin) {
+	accountId, _ := plugins.Location(plugin)
+	if b == nil || accountId == "" {
+		return
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.active[accountId]--; b.active[accountId] <= 0 {
+		delete(b.active, accountId)
+	}
+	b.notify()
+}
+
+// reserve waits until plugin's account has issued fewer than perAccount requests this second and counts one more, call
+// it before taking an ARN so the plugins of an account at its limit leave the ARNs to the other accounts. It stops
+// waiting once done is closed. The second the request was counted in is returned for claim.
+func (b *balancer) reserve(ctx *utils.Context, plugin plugins.Plugin, done <-chan struct{}) time.Time {
+	accountId, _ := plugins.Location(plugin)
+	if b == nil || accountId == "" || b.perAccount <= 0 {
+		return time.Time{}
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.count(ctx, accountId, done)
+}
+
+// claim uses the request reserve counted in reserved, call it once the ARN is taken. Waiting for the ARN can outlast
+// the second the request was counted in, the request is counted again in the current second then, waiting for the
+// account to be under its limit, since the next second's count started without it.
+func (b *balancer) claim(ctx *utils.Context, plugin plugins.Plugin, reserved time.Time) {
+	accountId, _ := plugins.Location(plugin)
+	if b == nil || accountId == "" || b.perAccount <= 0 {
+		return
+	}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.advance()
+	if !b.windowStart.Equal(reserved) {
+		b.count(ctx, accountId, nil)
+	}
+}
+
+// count waits until accountId is under its limit this second and counts one more request, returning the second it was
+// counted in. If done is closed or ctx is done first nothing is counted. b.mux must be held.
+func (b *balancer) count(ctx *utils.Context, accountId string, done <-chan struct{}) time.Time {
+	for b.limited(accountId) {
+		changed := b.changed
+		next := time.Until(b.windowStart.Add(time.Second))
+		b.mux.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(next):
+		case <-done:
+			b.mux.Lock()
+			return time.Time{}
+		case <-ctx.Done():
+			b.mux.Lock()
+			return time.Time{}
+		}
+		b.mux.Lock()
+	}
+	b.window[accountId]++
+	return b.windowStart
+}
+
+// limited reports whether accountId reserved perAccount requests this second, b.mux must be held.
+func (b *balancer) limited(accountId string) bool {
+	if b.perAccount <= 0 {
+		return false
+	}
+	b.advance()
+	return b.window[accountId] >= b.perAccount
+}
+
+// advance starts counting a new second once the current one is over, b.mux must be held.
+func (b *balancer) advance() {
+	if time.Since(b.windowStart) >= time.Second {
+		b.windowStart = time.Now()
+		clear(b.window)
+	}
+}
+
+// acquire waits until plugin's account isn't ahead of the other running accounts, or balanceMaxWait, and counts the
+// request plugin is about to make.
+func (b *balancer) acquire(ctx *utils.Context, plugin plugins.Plugin) {
+	accountId, region := plugins.Location(plugin)
+	if b == nil || accountId == "" {
+		return
+	}
+
+	deadline := time.After(balanceMaxWait)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for waiting := true; waiting && b.issued[accountId] >= b.least()+balanceSlack; {
+		changed := b.changed
+		b.mux.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			waiting = false
+		case <-ctx.Done():
+			waiting = false
+		}
+		b.mux.Lock()
+	}
+
+	if b.requests[accountId] == nil {
+		b.requests[accountId] = map[string]int64{}
+	}
+	b.requests[accountId][region]++
+	b.issued[accountId]++
+	b.notify()
+}
+
+// least returns the fewest requests issued in an account with running plugins, b.mux must be held. Accounts at their
+// perAccount limit are left out, they can't catch up until the next second.
+func (b *balancer) least() int64 {
+	least := int64(-1)
+	for accountId := range b.active {
+		if b.limited(accountId) {
+			continue
+		}
+		if issued := b.issued[accountId]; least == -1 || issued < least {
+			least = issued
+		}
+	}
+	return max(least, 0)
+}
+
+// notify wakes up waiting plugins, b.mux must be held.
+func (b *balancer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// counts returns the number of requests issued in each account and region, by account ID and then region.
+func (b *balancer) counts() map[string]map[string]int64 {
+	result := map[string]map[string]int64{}
+	if b == nil {
+		return result
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for accountId, regions := range b.requests {
+		result[accountId] = maps.Clone(regions)
+	}
+	return result
+}
+
+// log logs the fewest and most requests issued in an account, and the requests of each account and region at debug
+// level.
+func (b *balancer) log(ctx *utils.Context) {
+	requests := b.counts()
+	if len(requests) == 0 {
+		return
+	}
+
+	totals := map[string]int64{}
+	for _, accountId := range slices.Sorted(maps.Keys(requests)) {
+		var parts []string
+		for _, region := range slices.Sorted(maps.Keys(requests[accountId])) {
+			totals[accountId] += requests[accountId][region]
+			parts = append(parts, fmt.Sprintf("%s=%d", region, requests[accountId][region]))
+		}
+		ctx.Debug.Log("requests in account", "account", accountId, "regions", strings.Join(parts, " "))
+	}
+
+	counts := slices.Collect(maps.Values(totals))
+	ctx.Info.Log("requests in each scanning account", "accounts", len(counts), "fewest", slices.Min(counts), "most", slices.Max(counts))
+}
@@ -0,0 +1,147 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// locatedPlugin is a mockPlugin in a scanning account and region.
+type locatedPlugin struct {
+	mockPlugin
+	utils.ThreadConfig
+}
+
+func TestScanWithPlugins_BalancesAccounts(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	arns := make([]string, 200)
+	for i := range arns {
+		arns[i] = fmt.Sprintf("arn:aws:iam::333333333333:role/Role%d", i)
+	}
+
+	fast := &locatedPlugin{
+		mockPlugin:   mockPlugin{name: "fast"},
+		ThreadConfig: utils.ThreadConfig{AccountId: "111111111111", Region: "us-east-1"},
+	}
+	slow := &locatedPlugin{
+		mockPlugin: mockPlugin{name: "slow", scanFunc: func(string) (bool, error) {
+			time.Sleep(time.Millisecond)
+			return true, nil
+		}},
+		ThreadConfig: utils.ThreadConfig{AccountId: "222222222222", Region: "us-west-2"},
+	}
+
+	balance := newBalancer(0)
+	for range scanWithPlugins(ctx, []plugins.Plugin{fast, slow}, arns, unlimitedBucket(), balance) {
+	}
+
+	counts := balance.counts()
+	assert.Equal(t, int64(200), counts["111111111111"]["us-east-1"]+counts["222222222222"]["us-west-2"])
+	assert.InDelta(t, counts["111111111111"]["us-east-1"], counts["222222222222"]["us-west-2"], balanceSlack+1,
+		"the fast account shouldn't make most of the requests: %v", counts)
+}
+
+func TestBalancer_IgnoresStoppedAccounts(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	a := &locatedPlugin{ThreadConfig: utils.ThreadConfig{AccountId: "111111111111", Region: "us-east-1"}}
+	b := &locatedPlugin{ThreadConfig: utils.ThreadConfig{AccountId: "222222222222", Region: "us-east-1"}}
+
+	balance := newBalancer(0)
+	balance.start(a)
+	balance.start(b)
+	balance.stop(b)
+
+	// With b done, a isn't held back by b's count.
+	start := time.Now()
+	for range 3 * balanceSlack {
+		balance.acquire(ctx, a)
+	}
+	assert.Less(t, time.Since(start), balanceMaxWait)
+	assert.Equal(t, int64(3*balanceSlack), balance.counts()["111111111111"]["us-east-1"])
+
+	// Plugins without a location and nil balancers aren't balanced.
+	balance.acquire(ctx, &mockPlugin{})
+	var none *balancer
+	none.acquire(ctx, a)
+	assert.Empty(t, none.counts())
+}
+
+func TestScanWithPlugins_AccountRateLimit(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	arns := make([]string, 10)
+	for i := range arns {
+		arns[i] = fmt.Sprintf("arn:aws:iam::333333333333:role/Role%d", i)
+	}
+
+	fast := &locatedPlugin{
+		mockPlugin:   mockPlugin{name: "fast"},
+		ThreadConfig: utils.ThreadConfig{AccountId: "111111111111", Region: "us-east-1"},
+	}
+	slow := &locatedPlugin{
+		mockPlugin: mockPlugin{name: "slow", scanFunc: func(string) (bool, error) {
+			time.Sleep(10 * time.Millisecond)
+			return true, nil
+		}},
+		ThreadConfig: utils.ThreadConfig{AccountId: "222222222222", Region: "us-west-2"},
+	}
+
+	// The fast account stops at its limit and leaves the rest to the slow one instead of waiting for the next second.
+	start := time.Now()
+	balance := newBalancer(5)
+	for range scanWithPlugins(ctx, []plugins.Plugin{fast, slow}, arns, unlimitedBucket(), balance) {
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	counts := balance.counts()
+	assert.Equal(t, int64(5), counts["111111111111"]["us-east-1"])
+	assert.Equal(t, int64(5), counts["222222222222"]["us-west-2"])
+}
+
+func TestBalancer_Reserve(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	a := &locatedPlugin{ThreadConfig: utils.ThreadConfig{AccountId: "111111111111", Region: "us-east-1"}}
+
+	balance := newBalancer(2)
+	balance.reserve(ctx, a, nil)
+	balance.reserve(ctx, a, nil)
+	assert.True(t, balance.limited("111111111111"))
+
+	// The third waits for the next second.
+	start := balance.windowStart
+	balance.reserve(ctx, a, nil)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.False(t, balance.limited("111111111111"))
+
+	// Or until done is closed.
+	balance.reserve(ctx, a, nil)
+	done := make(chan struct{})
+	close(done)
+	balance.reserve(ctx, a, done)
+	assert.Less(t, time.Since(balance.windowStart), time.Second)
+
+	// A reservation from a second that's over is counted again when it's claimed.
+	limited := newBalancer(1)
+	reserved := limited.reserve(ctx, a, nil)
+	limited.claim(ctx, a, reserved)
+	assert.True(t, limited.limited("111111111111"), "claimed in the second it was reserved in")
+	limited.windowStart = limited.windowStart.Add(-time.Second)
+	reserved = limited.reserve(ctx, a, nil)
+	limited.windowStart = limited.windowStart.Add(-time.Second)
+	limited.claim(ctx, a, reserved)
+	assert.NotEqual(t, reserved, limited.windowStart)
+	assert.Equal(t, 1, limited.window["111111111111"])
+
+	// Without a limit nothing waits.
+	unlimited := newBalancer(0)
+	for range 10 {
+		unlimited.reserve(ctx, a, nil)
+	}
+	assert.False(t, unlimited.limited("111111111111"))
+}
@@ -0,0 +1,230 @@
+package scanner
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"net/url"
+	"strconv"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// StorageOptions are set with query parameters on the storage URI, for example file:///path/to/dir?compression=zstd.
+type StorageOptions struct {
+	// Compression is none, gzip, or zstd. When empty the compression the results were loaded with is kept.
+	Compression string
+	// Encryption is none, passphrase, or kms. When empty the encryption the results were loaded with is kept.
+	Encryption string
+	// KMSKeyID is the KMS key used to generate data keys when Encryption is kms.
+	KMSKeyID string
+	// Hash stores keyed hashes of account IDs and ARNs instead of the values, see HashedStorage. Storage that is
+	// already hashed stays hashed when this isn't set.
+	Hash bool
+
+	kms        IKMSClient
+	passphrase func() (string, error)
+	hashKey    func() (string, error)
+}
+
+func parseStorageOptions(query string) (StorageOptions, error) {
+	opts := StorageOptions{}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return opts, fmt.Errorf("parsing storage options: %s", err)
+	}
+
+	for key := range values {
+		switch key {
+		case "compression":
+			opts.Compression = values.Get(key)
+			switch opts.Compression {
+			case CompressionNone, CompressionGzip, CompressionZstd:
+			default:
+				return opts, fmt.Errorf("unknown compression %q: must be none, gzip, or zstd", opts.Compression)
+			}
+		case "encryption":
+			opts.Encryption = values.Get(key)
+			switch opts.Encryption {
+			case EncryptionNone, EncryptionPassphrase, EncryptionKMS:
+			default:
+				return opts, fmt.Errorf("unknown encryption %q: must be none, passphrase, or kms", opts.Encryption)
+			}
+		case "hash":
+			if opts.Hash, err = strconv.ParseBool(values.Get(key)); err != nil {
+				return opts, fmt.Errorf("invalid hash %q: must be true or false", values.Get(key))
+			}
+		case "kms-key-id":
+			opts.KMSKeyID = values.Get(key)
+			if opts.Encryption == "" {
+				opts.Encryption = EncryptionKMS
+			}
+		default:
+			return opts, fmt.Errorf("unknown storage option: %s", key)
+		}
+	}
+
+	return opts, nil
+}
+
+// codec encodes results for the file and S3 backends, they are compressed and then encrypted as configured.
+//
+// Both are detected when decoding, so results keep the compression and encryption they were loaded with unless the
+// options override them.
+type codec struct {
+	ctx         *utils.Context
+	compression string
+	encryption  string
+	kmsKeyID    string
+	kms         IKMSClient
+	passphrase  func() (string, error)
+
+	// key is the data key results are encrypted with once it has been derived, generated or decrypted.
+	key *dataKey
+	// keys are all the data keys seen so far by their key info, other processes may have encrypted with their own.
+	keys            map[string]*dataKey
+	passphraseValue string
+}
+
+func newCodec(ctx *utils.Context, opts StorageOptions) *codec {
+	passphrase := opts.passphrase
+	if passphrase == nil {
+		passphrase = readPassphrase
+	}
+
+	return &codec{
+		ctx:         ctx,
+		compression: opts.Compression,
+		encryption:  opts.Encryption,
+		kmsKeyID:    opts.KMSKeyID,
+		kms:         opts.kms,
+		passphrase:  passphrase,
+	}
+}
+
+// detectCompression identifies compressed results by their magic bytes, anything else is assumed to be plain JSON.
+func detectCompression(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(data, zstdMagic):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// Unmarshal decrypts and decompresses stored results.
+func (c *codec) Unmarshal(data []byte) (results, error) {
+	if bytes.HasPrefix(data, encryptionMagic) {
+		plaintext, mode, err := c.decrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting data: %s", err)
+		}
+		if c.encryption == "" {
+			c.encryption = mode
+		}
+		data = plaintext
+	}
+
+	compression := detectCompression(data)
+	if c.compression == "" {
+		c.compression = compression
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	switch compression {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading gzip: %s", err)
+		}
+		defer gr.Close()
+		r = gr
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading zstd: %s", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	loaded := results{}
+	if err := json.NewDecoder(r).Decode(&loaded); err != nil {
+		return nil, fmt.Errorf("unmarshalling data: %s", err)
+	}
+
+	return loaded, nil
+}
+
+// Marshal compresses and encrypts results. Plain JSON is indented so it stays easy to read, compressed results are
+// written compactly.
+func (c *codec) Marshal(data results) ([]byte, error) {
+	encoded, err := c.compress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.encrypted() {
+		return encoded, nil
+	}
+
+	encoded, err = c.encrypt(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting data: %s", err)
+	}
+	return encoded, nil
+}
+
+func (c *codec) compress(data results) ([]byte, error) {
+	if c.compression == "" || c.compression == CompressionNone {
+		data, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("marshalling data: %s", err)
+		}
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch c.compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd writer: %s", err)
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("unknown compression: %s", c.compression)
+	}
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		return nil, fmt.Errorf("marshalling data: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("compressing data: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *codec) encrypted() bool {
+	return c.encryption == EncryptionPassphrase || c.encryption == EncryptionKMS
+}
@@ -0,0 +1,364 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type IDynamoDBClient interface {
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// metadataSortKey is the sort key of the item holding a scan's metadata, it can't collide with a principal ARN.
+const metadataSortKey = "#metadata"
+
+// NewDynamoDBStorage stores results in a DynamoDB table shared by every host scanning with the same name.
+//
+// The table must have a string partition key "name" and a string sort key "arn". Existing results for the scan name
+// are loaded once up front, after that every result is written through to the table as it is set. The scan's metadata
+// is kept in the same partition under the sort key "#metadata".
+func NewDynamoDBStorage(ctx *utils.Context, cfg aws.Config, table string, name string) (*DynamoDBStorage, error) {
+	storage := &DynamoDBStorage{
+		ctx:    ctx,
+		client: dynamodb.NewFromConfig(cfg),
+		table:  table,
+		name:   name,
+		data:   results{},
+	}
+
+	if err := storage.Load(ctx); err != nil {
+		return nil, fmt.Errorf("loading storage: %s", err)
+	}
+
+	return storage, nil
+}
+
+type DynamoDBStorage struct {
+	ctx    *utils.Context
+	client IDynamoDBClient
+	table  string
+	name   string
+
+	mux  sync.Mutex
+	data results
+}
+
+// Load reads all stored results for this scan name into memory.
+func (s *DynamoDBStorage) Load(ctx *utils.Context) error {
+	paginator := dynamodb.NewQueryPaginator(s.client, &dynamodb.QueryInput{
+		TableName:              &s.table,
+		KeyConditionExpression: aws.String("#name = :name"),
+		ExpressionAttributeNames: map[string]string{
+			"#name": "name",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":name": &types.AttributeValueMemberS{Value: s.name},
+		},
+	})
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("querying %s: %w", s.table, err)
+		}
+
+		for _, item := range resp.Items {
+			principalArn, ok := item["arn"].(*types.AttributeValueMemberS)
+			if !ok || principalArn.Value == metadataSortKey {
+				continue
+			}
+			key, err := NewKey(principalArn.Value)
+			if err != nil {
+				ctx.Debug.Printf("skipping item in %s: %s", s.table, err)
+				continue
+			}
+			s.data.set(key, dynamoDBInfo(item))
+		}
+	}
+
+	ctx.Debug.Printf("loaded %d results from %s", s.data.len(), s.table)
+	return nil
+}
+
+func (s *DynamoDBStorage) Get(key Key) (utils.Info, PrincipalStatus, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	info, status := s.data.get(key)
+	return info, status, nil
+}
+
+func (s *DynamoDBStorage) All() iter.Seq2[Key, utils.Info] {
+	return snapshot(&s.mux, s.data)
+}
+
+// Set writes the result to the table.
+//
+// The write is conditional on the stored item being checked before this result, so when several hosts scan the same
+// principal the most recent check wins regardless of the order the writes arrive in.
+func (s *DynamoDBStorage) Set(key Key, info utils.Info) {
+	s.mux.Lock()
+	s.data.set(key, info)
+	s.mux.Unlock()
+
+	item := dynamoDBItem(s.name, key, info)
+
+	_, err := s.client.PutItem(s.ctx, &dynamodb.PutItemInput{
+		TableName:           &s.table,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#arn) OR attribute_not_exists(#last_checked) OR #last_checked <= :last_checked"),
+		ExpressionAttributeNames: map[string]string{
+			"#arn":          "arn",
+			"#last_checked": "last_checked",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":last_checked": item["last_checked"],
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		s.ctx.Debug.Printf("newer result for %s already stored, skipping", key)
+	} else if err != nil {
+		s.ctx.Error.Printf("storing %s: %s", key, err)
+	}
+}
+
+// Delete removes the result from the table, the delete is conditional on no other host having checked the principal
+// again since it was loaded.
+func (s *DynamoDBStorage) Delete(key Key) error {
+	s.mux.Lock()
+	info, status := s.data.get(key)
+	s.data.delete(key)
+	s.mux.Unlock()
+
+	if status == PrincipalUnknown {
+		return nil
+	}
+
+	_, err := s.client.DeleteItem(s.ctx, &dynamodb.DeleteItemInput{
+		TableName: &s.table,
+		Key: map[string]types.AttributeValue{
+			"name": &types.AttributeValueMemberS{Value: s.name},
+			"arn":  &types.AttributeValueMemberS{Value: key.Arn},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#last_checked) OR #last_checked <= :last_checked"),
+		ExpressionAttributeNames: map[string]string{
+			"#last_checked": "last_checked",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":last_checked": &types.AttributeValueMemberN{Value: strconv.FormatInt(info.LastChecked.Unix(), 10)},
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		s.ctx.Debug.Printf("newer result for %s stored since loading, not deleting", key)
+	} else if err != nil {
+		return fmt.Errorf("deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *DynamoDBStorage) Metadata() (Metadata, error) {
+	md, _, err := s.getMetadata()
+	return md, err
+}
+
+// UpdateMetadata updates the metadata item, the write is conditional on its version so concurrent updates are retried
+// instead of overwriting each other.
+func (s *DynamoDBStorage) UpdateMetadata(update func(md *Metadata) error) error {
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		md, version, err := s.getMetadata()
+		if err != nil {
+			return err
+		}
+		if err := update(&md); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(md)
+		if err != nil {
+			return fmt.Errorf("marshalling metadata: %s", err)
+		}
+
+		_, err = s.client.PutItem(s.ctx, &dynamodb.PutItemInput{
+			TableName: &s.table,
+			Item: map[string]types.AttributeValue{
+				"name":     &types.AttributeValueMemberS{Value: s.name},
+				"arn":      &types.AttributeValueMemberS{Value: metadataSortKey},
+				"metadata": &types.AttributeValueMemberS{Value: string(data)},
+				"version":  &types.AttributeValueMemberN{Value: strconv.Itoa(version + 1)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(#version) OR #version = :version"),
+			ExpressionAttributeNames: map[string]string{
+				"#version": "version",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":version": &types.AttributeValueMemberN{Value: strconv.Itoa(version)},
+			},
+		})
+
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("storing metadata: %w", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("storing metadata: gave up after %d conflicting writes", maxSaveAttempts)
+}
+
+// getMetadata reads the metadata item and its version, a missing item is empty metadata at version 0.
+func (s *DynamoDBStorage) getMetadata() (Metadata, int, error) {
+	resp, err := s.client.GetItem(s.ctx, &dynamodb.GetItemInput{
+		TableName: &s.table,
+		Key: map[string]types.AttributeValue{
+			"name": &types.AttributeValueMemberS{Value: s.name},
+			"arn":  &types.AttributeValueMemberS{Value: metadataSortKey},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Metadata{}, 0, fmt.Errorf("getting metadata: %w", err)
+	}
+
+	md := Metadata{}
+	if v, ok := resp.Item["metadata"].(*types.AttributeValueMemberS); ok {
+		if err := json.Unmarshal([]byte(v.Value), &md); err != nil {
+			return md, 0, fmt.Errorf("unmarshalling metadata: %s", err)
+		}
+	}
+
+	version := 0
+	if v, ok := resp.Item["version"].(*types.AttributeValueMemberN); ok {
+		if version, err = strconv.Atoi(v.Value); err != nil {
+			return md, 0, fmt.Errorf("parsing metadata version: %s", err)
+		}
+	}
+
+	return md, version, nil
+}
+
+// Save is a no-op, results are written to the table as they are set.
+func (s *DynamoDBStorage) Save() error {
+	return nil
+}
+
+func (s *DynamoDBStorage) Close() error {
+	return nil
+}
+
+// dynamoDBItem converts a result to a table item, timestamps are stored as unix seconds so they can be compared in
+// condition expressions.
+func dynamoDBItem(name string, key Key, info utils.Info) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"name":         &types.AttributeValueMemberS{Value: name},
+		"arn":          &types.AttributeValueMemberS{Value: key.Arn},
+		"account_id":   &types.AttributeValueMemberS{Value: key.AccountID},
+		"exists":       &types.AttributeValueMemberBOOL{Value: info.Exists},
+		"first_seen":   &types.AttributeValueMemberN{Value: strconv.FormatInt(info.FirstSeen.Unix(), 10)},
+		"last_checked": &types.AttributeValueMemberN{Value: strconv.FormatInt(info.LastChecked.Unix(), 10)},
+	}
+	if info.Comment != "" {
+		item["comment"] = &types.AttributeValueMemberS{Value: info.Comment}
+	}
+	if info.Plugin != "" {
+		item["plugin"] = &types.AttributeValueMemberS{Value: info.Plugin}
+	}
+	if len(info.Tags) > 0 {
+		tags := &types.AttributeValueMemberL{}
+		for _, tag := range info.Tags {
+			tags.Value = append(tags.Value, &types.AttributeValueMemberS{Value: tag})
+		}
+		item["tags"] = tags
+	}
+	if info.Likelihood != 0 {
+		item["likelihood"] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(info.Likelihood, 'g', -1, 64)}
+	}
+	if len(info.History) > 0 {
+		history := &types.AttributeValueMemberL{}
+		for _, change := range info.History {
+			history.Value = append(history.Value, &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"exists":       &types.AttributeValueMemberBOOL{Value: change.Exists},
+				"first_seen":   &types.AttributeValueMemberN{Value: strconv.FormatInt(change.FirstSeen.Unix(), 10)},
+				"last_checked": &types.AttributeValueMemberN{Value: strconv.FormatInt(change.LastChecked.Unix(), 10)},
+			}})
+		}
+		item["history"] = history
+	}
+	return item
+}
+
+// dynamoDBInfo converts a table item back to a result, missing attributes are left as zero values.
+func dynamoDBInfo(item map[string]types.AttributeValue) utils.Info {
+	var info utils.Info
+
+	if v, ok := item["exists"].(*types.AttributeValueMemberBOOL); ok {
+		info.Exists = v.Value
+	}
+	if v, ok := item["comment"].(*types.AttributeValueMemberS); ok {
+		info.Comment = v.Value
+	}
+	if v, ok := item["plugin"].(*types.AttributeValueMemberS); ok {
+		info.Plugin = v.Value
+	}
+	if v, ok := item["tags"].(*types.AttributeValueMemberL); ok {
+		for _, tag := range v.Value {
+			if s, ok := tag.(*types.AttributeValueMemberS); ok {
+				info.Tags = append(info.Tags, s.Value)
+			}
+		}
+	}
+	if v, ok := item["likelihood"].(*types.AttributeValueMemberN); ok {
+		info.Likelihood, _ = strconv.ParseFloat(v.Value, 64)
+	}
+	info.FirstSeen = dynamoDBTime(item["first_seen"])
+	info.LastChecked = dynamoDBTime(item["last_checked"])
+	if v, ok := item["history"].(*types.AttributeValueMemberL); ok {
+		for _, entry := range v.Value {
+			m, ok := entry.(*types.AttributeValueMemberM)
+			if !ok {
+				continue
+			}
+			change := utils.StatusChange{
+				FirstSeen:   dynamoDBTime(m.Value["first_seen"]),
+				LastChecked: dynamoDBTime(m.Value["last_checked"]),
+			}
+			if exists, ok := m.Value["exists"].(*types.AttributeValueMemberBOOL); ok {
+				change.Exists = exists.Value
+			}
+			info.History = append(info.History, change)
+		}
+	}
+
+	return info
+}
+
+// dynamoDBTime converts a unix seconds attribute to a time, anything else is the zero time.
+func dynamoDBTime(v types.AttributeValue) time.Time {
+	if n, ok := v.(*types.AttributeValueMemberN); ok {
+		if sec, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,363 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+	"os"
+	"strings"
+)
+
+const (
+	EncryptionNone       = "none"
+	EncryptionPassphrase = "passphrase"
+	EncryptionKMS        = "kms"
+)
+
+// PassphraseEnv is read for the storage passphrase before prompting for it.
+const PassphraseEnv = "ROLES_STORAGE_PASSPHRASE"
+
+// Encrypted results are laid out as:
+//
+//	magic | mode (1 byte) | key info length (2 bytes) | key info | nonce | AES-GCM ciphertext
+//
+// The key info is the scrypt salt for passphrase mode or the KMS encrypted data key for kms mode. Everything before
+// the nonce is authenticated along with the ciphertext.
+var encryptionMagic = []byte("ROLESENC")
+
+const (
+	modePassphrase byte = 1
+	modeKMS        byte = 2
+
+	saltSize = 16
+)
+
+// journalAAD separates encrypted journal lines from the main storage file.
+var journalAAD = []byte("journal")
+
+type IKMSClient interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// dataKey is the AES key results are encrypted with, along with what is needed to recover it.
+type dataKey struct {
+	mode string
+	info []byte
+	aead cipher.AEAD
+}
+
+// readPassphrase reads the passphrase from the environment, or prompts for it if stdin is a terminal.
+func readPassphrase() (string, error) {
+	return readSecret(PassphraseEnv, "Storage passphrase", "storage is encrypted with a passphrase")
+}
+
+// readSecret reads a secret from env, or prompts for it if stdin is a terminal. reason explains why it is needed.
+func readSecret(env string, prompt string, reason string) (string, error) {
+	if secret := os.Getenv(env); secret != "" {
+		return secret, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("%s, set %s", reason, env)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %s", strings.ToLower(prompt), err)
+	}
+	if len(secret) == 0 {
+		return "", fmt.Errorf("empty %s", strings.ToLower(prompt))
+	}
+	return string(secret), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// cachedPassphrase reads the passphrase once, it's needed again whenever a file or journal encrypted with a different
+// salt is read.
+func (c *codec) cachedPassphrase() (string, error) {
+	if c.passphraseValue != "" {
+		return c.passphraseValue, nil
+	}
+
+	passphrase, err := c.passphrase()
+	if err != nil {
+		return "", err
+	}
+	c.passphraseValue = passphrase
+	return passphrase, nil
+}
+
+func (c *codec) passphraseKey(salt []byte) (*dataKey, error) {
+	passphrase, err := c.cachedPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %s", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &dataKey{mode: EncryptionPassphrase, info: salt, aead: aead}, nil
+}
+
+// addKey remembers key so data encrypted with it can be decrypted without deriving or decrypting it again.
+func (c *codec) addKey(key *dataKey) {
+	if c.keys == nil {
+		c.keys = map[string]*dataKey{}
+	}
+	c.keys[string(key.info)] = key
+}
+
+// resolveKey recovers the data key described by a header's mode and key info.
+func (c *codec) resolveKey(mode byte, info []byte) (*dataKey, error) {
+	if key, ok := c.keys[string(info)]; ok {
+		return key, nil
+	}
+
+	var key *dataKey
+	switch mode {
+	case modePassphrase:
+		var err error
+		if key, err = c.passphraseKey(bytes.Clone(info)); err != nil {
+			return nil, err
+		}
+	case modeKMS:
+		if c.kms == nil {
+			return nil, fmt.Errorf("kms encryption is not supported by this storage backend")
+		}
+
+		resp, err := c.kms.Decrypt(c.ctx, &kms.DecryptInput{CiphertextBlob: info})
+		if err != nil {
+			return nil, fmt.Errorf("decrypting data key: %w", err)
+		}
+		if c.kmsKeyID == "" && resp.KeyId != nil {
+			c.kmsKeyID = *resp.KeyId
+		}
+
+		aead, err := newAEAD(resp.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		key = &dataKey{mode: EncryptionKMS, info: bytes.Clone(info), aead: aead}
+	default:
+		return nil, fmt.Errorf("unknown encryption mode: %d", mode)
+	}
+
+	c.addKey(key)
+	return key, nil
+}
+
+// ensureKey creates a data key for the configured encryption mode if one hasn't been loaded already.
+func (c *codec) ensureKey() error {
+	if c.key != nil && c.key.mode == c.encryption {
+		return nil
+	}
+
+	switch c.encryption {
+	case EncryptionPassphrase:
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("generating salt: %s", err)
+		}
+
+		key, err := c.passphraseKey(salt)
+		if err != nil {
+			return err
+		}
+		c.key = key
+	case EncryptionKMS:
+		if c.kms == nil {
+			return fmt.Errorf("kms encryption is not supported by this storage backend")
+		}
+		if c.kmsKeyID == "" {
+			return fmt.Errorf("kms encryption requires a key: ?kms-key-id=alias/name")
+		}
+
+		resp, err := c.kms.GenerateDataKey(c.ctx, &kms.GenerateDataKeyInput{
+			KeyId:   &c.kmsKeyID,
+			KeySpec: kmsTypes.DataKeySpecAes256,
+		})
+		if err != nil {
+			return fmt.Errorf("generating data key: %w", err)
+		}
+
+		aead, err := newAEAD(resp.Plaintext)
+		if err != nil {
+			return err
+		}
+		c.key = &dataKey{mode: EncryptionKMS, info: resp.CiphertextBlob, aead: aead}
+	default:
+		return fmt.Errorf("unknown encryption: %s", c.encryption)
+	}
+
+	c.addKey(c.key)
+	return nil
+}
+
+// keyHeader is the header for data encrypted with the current key.
+func (c *codec) keyHeader() []byte {
+	var mode byte
+	switch c.key.mode {
+	case EncryptionPassphrase:
+		mode = modePassphrase
+	case EncryptionKMS:
+		mode = modeKMS
+	}
+
+	header := append(bytes.Clone(encryptionMagic), mode)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(c.key.info)))
+	return append(header, c.key.info...)
+}
+
+// parseKeyHeader splits the header from data and recovers the key it describes.
+func (c *codec) parseKeyHeader(data []byte) (*dataKey, []byte, error) {
+	if !bytes.HasPrefix(data, encryptionMagic) {
+		return nil, nil, fmt.Errorf("missing encryption header")
+	}
+
+	rest := data[len(encryptionMagic):]
+	if len(rest) < 3 {
+		return nil, nil, fmt.Errorf("truncated header")
+	}
+
+	mode := rest[0]
+	infoLen := int(binary.BigEndian.Uint16(rest[1:3]))
+	if len(rest) < 3+infoLen {
+		return nil, nil, fmt.Errorf("truncated header")
+	}
+
+	key, err := c.resolveKey(mode, rest[3:3+infoLen])
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, data[:len(encryptionMagic)+3+infoLen], nil
+}
+
+func (c *codec) encrypt(plaintext []byte) ([]byte, error) {
+	if err := c.ensureKey(); err != nil {
+		return nil, err
+	}
+	return seal(c.key, c.keyHeader(), plaintext)
+}
+
+// decrypt decrypts data and returns the encryption mode it used. The data's key becomes the current key if one
+// hasn't been loaded yet, so processes sharing a file converge on the same key.
+func (c *codec) decrypt(data []byte) ([]byte, string, error) {
+	key, header, err := c.parseKeyHeader(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext, err := open(key, header, data[len(header):])
+	if err != nil {
+		// A different passphrase is the usual cause, the integrity check can't tell them apart.
+		return nil, "", fmt.Errorf("wrong passphrase or corrupted data")
+	}
+
+	if c.key == nil {
+		c.key = key
+	}
+	return plaintext, key.mode, nil
+}
+
+// seal appends a random nonce and the ciphertext of plaintext to header, header is authenticated but not encrypted.
+func seal(key *dataKey, header []byte, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %s", err)
+	}
+
+	out := append(bytes.Clone(header), nonce...)
+	return key.aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// open authenticates and decrypts data produced by seal, without the header.
+func open(key *dataKey, header []byte, data []byte) ([]byte, error) {
+	nonceSize := key.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	return key.aead.Open(nil, data[:nonceSize], data[nonceSize:], header)
+}
+
+// JournalHeader is written as the first line of encrypted journals so the key the lines are encrypted with can be
+// recovered by any process, it is nil when results aren't encrypted.
+func (c *codec) JournalHeader() ([]byte, error) {
+	if !c.encrypted() {
+		return nil, nil
+	}
+	if err := c.ensureKey(); err != nil {
+		return nil, err
+	}
+	return []byte("#" + base64.StdEncoding.EncodeToString(c.keyHeader())), nil
+}
+
+// OpenJournalHeader recovers the key from a line written by JournalHeader.
+func (c *codec) OpenJournalHeader(line []byte) (*dataKey, error) {
+	header, err := base64.StdEncoding.DecodeString(string(bytes.TrimPrefix(line, []byte("#"))))
+	if err != nil {
+		return nil, fmt.Errorf("decoding journal header: %s", err)
+	}
+
+	key, _, err := c.parseKeyHeader(header)
+	return key, err
+}
+
+// SealLine encrypts a single journal line with the current key, lines are returned as-is when results aren't
+// encrypted.
+func (c *codec) SealLine(line []byte) ([]byte, error) {
+	if !c.encrypted() {
+		return line, nil
+	}
+	if err := c.ensureKey(); err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(c.key, journalAAD, line)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sealed[len(journalAAD):])), nil
+}
+
+// OpenLine decrypts a journal line written by SealLine with key, plain JSON lines are returned as-is.
+func (c *codec) OpenLine(key *dataKey, line []byte) ([]byte, error) {
+	if bytes.HasPrefix(line, []byte("{")) {
+		return line, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("encrypted journal line without a header")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return nil, err
+	}
+	return open(key, journalAAD, sealed)
+}
@@ -0,0 +1,124 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrorLog records every failed scan as a JSON line, with the AWS error code, message, and request ID when the error
+// came from an AWS API. Principals that don't exist aren't failures and aren't recorded. It's for diagnosing false
+// negatives, which otherwise needs a rerun with -debug and reading the errors out of interleaved logs. It's safe for
+// concurrent use.
+type ErrorLog struct {
+	mux sync.Mutex
+	w   io.Writer
+}
+
+// errorLogEntry is a line of the ErrorLog, the AWS fields are empty for errors that didn't come from an AWS API.
+type errorLogEntry struct {
+	Time       time.Time `json:"time"`
+	Plugin     string    `json:"plugin"`
+	AccountId  string    `json:"account_id,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	Arn        string    `json:"arn"`
+	Code       string    `json:"code,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	RequestId  string    `json:"request_id,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error"`
+}
+
+// OpenErrorLog opens the ErrorLog at path, appending to it if it exists so the errors of resumed scans are kept. Close
+// it once the scan is done.
+func OpenErrorLog(path string) (*ErrorLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %s", path, err)
+	}
+	return NewErrorLog(f), nil
+}
+
+func NewErrorLog(w io.Writer) *ErrorLog {
+	return &ErrorLog{w: w}
+}
+
+// Close closes the writer of the ErrorLog if it's an io.Closer.
+func (l *ErrorLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Record writes err from scanning principalArn with plugin, a nil ErrorLog doesn't record anything.
+func (l *ErrorLog) Record(plugin plugins.Plugin, principalArn string, err error) error {
+	if l == nil || err == nil {
+		return nil
+	}
+	entry := newErrorLogEntry(plugin, principalArn, err)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshalling error log entry: %w", err)
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing error log: %s", err)
+	}
+	return nil
+}
+
+// newErrorLogEntry returns the entry of err, with the code and message of the AWS API error and the request ID and
+// HTTP status code of its response if it has them.
+func newErrorLogEntry(plugin plugins.Plugin, principalArn string, err error) errorLogEntry {
+	accountId, region := plugins.Location(plugin)
+	entry := errorLogEntry{
+		Time:      time.Now().UTC(),
+		Plugin:    plugin.Name(),
+		AccountId: accountId,
+		Region:    region,
+		Arn:       principalArn,
+		Error:     err.Error(),
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		entry.Code = apiErr.ErrorCode()
+		entry.Message = apiErr.ErrorMessage()
+	}
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		entry.RequestId = respErr.ServiceRequestID()
+		entry.StatusCode = respErr.HTTPStatusCode()
+	}
+	return entry
+}
+
+// errorLoggedPlugin records the failed scans of the plugin it wraps in an ErrorLog.
+type errorLoggedPlugin struct {
+	wrappedPlugin
+	errors *ErrorLog
+}
+
+func (p *errorLoggedPlugin) ScanArn(ctx *utils.Context, principalArn string) (bool, error) {
+	exists, err := p.Plugin.ScanArn(ctx, principalArn)
+	if logErr := p.errors.Record(p.Plugin, principalArn, err); logErr != nil {
+		ctx.Error.Printf("%s: %s", p.Name(), logErr)
+	}
+	return exists, err
+}
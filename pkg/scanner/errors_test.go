@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorLoggedPlugin(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	var buf bytes.Buffer
+	errorLog := NewErrorLog(&buf)
+
+	denied := fmt.Errorf("setting topic policy: %w", &smithy.OperationError{
+		ServiceID:     "SNS",
+		OperationName: "SetTopicAttributes",
+		Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusForbidden}},
+				Err:      &smithy.GenericAPIError{Code: "AuthorizationError", Message: "not authorized to SetTopicAttributes"},
+			},
+			RequestID: "d3b07384-d9a0-4c9b-8d1e-1b2f3a4b5c6d",
+		},
+	})
+	plugin := &errorLoggedPlugin{
+		wrappedPlugin: wrappedPlugin{&locatedPlugin{
+			mockPlugin: mockPlugin{name: "sns-0", scanFunc: func(arn string) (bool, error) {
+				switch {
+				case strings.HasSuffix(arn, "Denied"):
+					return false, denied
+				case strings.HasSuffix(arn, "Timeout"):
+					return false, errors.New("request timed out")
+				}
+				return strings.HasSuffix(arn, "Admin"), nil
+			}},
+			ThreadConfig: utils.ThreadConfig{AccountId: "111111111111", Region: "us-east-1"},
+		}},
+		errors: errorLog,
+	}
+
+	for _, name := range []string{"Admin", "Missing", "Denied", "Timeout"} {
+		_, _ = plugin.ScanArn(ctx, "arn:aws:iam::333333333333:role/"+name)
+	}
+	accountId, region := plugin.Location()
+	assert.Equal(t, "111111111111", accountId)
+	assert.Equal(t, "us-east-1", region)
+
+	// Only the failures are recorded, not the principals that exist or don't.
+	var entries []errorLogEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry errorLogEntry
+		require.NoError(t, dec.Decode(&entry))
+		assert.False(t, entry.Time.IsZero())
+		entry.Time = time.Time{}
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, errorLogEntry{
+		Plugin:     "sns-0",
+		AccountId:  "111111111111",
+		Region:     "us-east-1",
+		Arn:        "arn:aws:iam::333333333333:role/Denied",
+		Code:       "AuthorizationError",
+		Message:    "not authorized to SetTopicAttributes",
+		RequestId:  "d3b07384-d9a0-4c9b-8d1e-1b2f3a4b5c6d",
+		StatusCode: http.StatusForbidden,
+		Error:      denied.Error(),
+	}, entries[0])
+	assert.Equal(t, errorLogEntry{
+		Plugin:    "sns-0",
+		AccountId: "111111111111",
+		Region:    "us-east-1",
+		Arn:       "arn:aws:iam::333333333333:role/Timeout",
+		Error:     "request timed out",
+	}, entries[1])
+
+	// A nil ErrorLog doesn't record anything.
+	var none *ErrorLog
+	assert.NoError(t, none.Record(plugin, "arn:aws:iam::333333333333:role/Denied", denied))
+	assert.NoError(t, none.Close())
+}
+
+func TestOpenErrorLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	for range 2 {
+		errorLog, err := OpenErrorLog(path)
+		require.NoError(t, err)
+		require.NoError(t, errorLog.Record(&mockPlugin{name: "sqs-0"}, "arn:aws:iam::333333333333:role/Admin", errors.New("throttled")))
+		require.NoError(t, errorLog.Close())
+	}
+
+	// The errors of earlier scans are kept.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), `"error":"throttled"`))
+
+	_, err = OpenErrorLog(filepath.Join(t.TempDir(), "missing", "errors.jsonl"))
+	assert.ErrorContains(t, err, "opening")
+}
@@ -0,0 +1,132 @@
+package scanner
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+)
+
+// HashKeyEnv is read for the hashed storage key before prompting for it.
+const HashKeyEnv = "ROLES_STORAGE_HASH_KEY"
+
+// hashedArnPrefix starts every hashed ARN, hashed keys are still valid ARNs so every backend can store them as-is.
+const hashedArnPrefix = "arn:roles:hashed::"
+
+// hashKeySalt is fixed so every scan name hashed with the same key can be merged and diffed against each other.
+var hashKeySalt = []byte("roles hashed storage")
+
+// HashedStorage stores keyed HMACs of account IDs and ARNs instead of the values themselves. Lookups still work since
+// each principal always hashes to the same key, but the stored results don't reveal which accounts and principals
+// were scanned without the hash key.
+//
+// All yields the hashed keys, the original ARNs can't be recovered from them. Comments and tags are dropped from stored
+// results, they come from the input lists and often name the target. Run history doesn't record the options naming
+// accounts, principals, or the files and variables listing them for the same reason, like -accounts or -var.
+type HashedStorage struct {
+	Storage
+	mac []byte
+}
+
+// NewHashedStorage wraps storage, the key is read from the environment or prompted for when key is nil.
+func NewHashedStorage(storage Storage, key func() (string, error)) (*HashedStorage, error) {
+	if key == nil {
+		key = readHashKey
+	}
+
+	secret, err := key()
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := scrypt.Key([]byte(secret), hashKeySalt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving hash key: %s", err)
+	}
+
+	return &HashedStorage{Storage: storage, mac: mac}, nil
+}
+
+func readHashKey() (string, error) {
+	return readSecret(HashKeyEnv, "Storage hash key", "storage is hashed")
+}
+
+// isHashed reports whether storage holds hashed keys, so hashing is kept on when the option isn't given again.
+func isHashed(storage Storage) bool {
+	for key := range storage.All() {
+		return IsHashedKey(key)
+	}
+	return false
+}
+
+// IsHashedKey reports whether key was hashed by HashedStorage.
+func IsHashedKey(key Key) bool {
+	return strings.HasPrefix(key.Arn, hashedArnPrefix)
+}
+
+// hash returns the stored key for key, keys that are already hashed are returned as-is so hashed results can be
+// merged into hashed storage.
+func (s *HashedStorage) hash(key Key) Key {
+	if IsHashedKey(key) {
+		return key
+	}
+
+	accountID := s.sum("account:" + key.AccountID)[:32]
+	return Key{
+		AccountID: accountID,
+		Arn:       hashedArnPrefix + accountID + ":" + s.sum("arn:"+key.Arn),
+	}
+}
+
+func (s *HashedStorage) sum(value string) string {
+	h := hmac.New(sha256.New, s.mac)
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *HashedStorage) Get(key Key) (utils.Info, PrincipalStatus, error) {
+	return s.Storage.Get(s.hash(key))
+}
+
+func (s *HashedStorage) Set(key Key, info utils.Info) {
+	info.Comment = ""
+	info.Tags = nil
+	s.Storage.Set(s.hash(key), info)
+}
+
+func (s *HashedStorage) Delete(key Key) error {
+	return s.Storage.Delete(s.hash(key))
+}
+
+func (s *HashedStorage) UpdateMetadata(update func(md *Metadata) error) error {
+	return s.Storage.UpdateMetadata(func(md *Metadata) error {
+		if err := update(md); err != nil {
+			return err
+		}
+		for i := range md.Runs {
+			redactRunOptions(&md.Runs[i].Options)
+		}
+		return nil
+	})
+}
+
+// redactRunOptions blanks the run options that name the target's accounts, principals or the files listing them.
+func redactRunOptions(opts *RunOptions) {
+	opts.Accounts = ""
+	opts.AccountsPath = ""
+	opts.AccountRange = ""
+	opts.ExcludeAccounts = ""
+	opts.RolesPath = ""
+	opts.PrincipalPath = ""
+	opts.ExcludeRoles = ""
+	opts.FromTerraform = ""
+	opts.FromCloudFormation = ""
+	opts.CDKQualifiers = ""
+	opts.SSOPermissionSets = ""
+	opts.TryPaths = ""
+	opts.Vars = nil
+	opts.VarFile = ""
+}
@@ -0,0 +1,108 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashedStorage(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	t.Setenv(HashKeyEnv, "hunter2")
+
+	storage, err := NewStorage(ctx, aws.Config{}, "file://"+dir+"?hash=true", "test")
+	require.NoError(t, err)
+
+	key := mustKey("arn:aws:iam::123456789012:role/acme-admin")
+	storage.Set(key, utils.Info{Exists: true, Comment: "acme corp", Plugin: "sns"})
+	require.NoError(t, storage.Save())
+	require.NoError(t, storage.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, "test.json"))
+	require.NoError(t, err)
+	for _, leaked := range []string{"123456789012", "acme"} {
+		assert.NotContains(t, string(data), leaked)
+	}
+
+	// Hashing is detected when the option isn't given again.
+	reopened, err := NewStorage(ctx, aws.Config{}, "file://"+dir, "test")
+	require.NoError(t, err)
+	defer reopened.Close()
+	require.IsType(t, &HashedStorage{}, reopened)
+
+	info, status, err := reopened.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalExists, status)
+	assert.Equal(t, utils.Info{Exists: true, Plugin: "sns"}, info)
+
+	for stored := range reopened.All() {
+		assert.True(t, IsHashedKey(stored), stored.Arn)
+
+		// Already hashed keys aren't hashed again, so they can be merged into other hashed storage.
+		_, status, err := reopened.Get(stored)
+		require.NoError(t, err)
+		assert.Equal(t, PrincipalExists, status)
+	}
+}
+
+func TestHashedStorage_WrongKey(t *testing.T) {
+	backend := &FileStorage{data: results{}}
+
+	a, err := NewHashedStorage(backend, func() (string, error) { return "hunter2", nil })
+	require.NoError(t, err)
+	b, err := NewHashedStorage(backend, func() (string, error) { return "letmein", nil })
+	require.NoError(t, err)
+
+	key := mustKey("arn:aws:iam::123456789012:role/a")
+	a.Set(key, utils.Info{Exists: true})
+
+	_, status, err := b.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalUnknown, status)
+
+	hashed := a.hash(key)
+	parsed, err := NewKey(hashed.Arn)
+	require.NoError(t, err)
+	assert.Equal(t, hashed, parsed, "hashed keys should parse as ARNs so every backend can store them")
+}
+
+func TestHashedStorage_UpdateMetadata(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	t.Setenv(HashKeyEnv, "hunter2")
+
+	storage, err := NewStorage(ctx, aws.Config{}, "file://"+dir+"?hash=true", "test")
+	require.NoError(t, err)
+	defer storage.Close()
+
+	require.NoError(t, storage.UpdateMetadata(func(md *Metadata) error {
+		md.StartRun(Run{Options: RunOptions{
+			Accounts:     "123456789012",
+			AccountsPath: "acme-accounts.txt",
+			RolesPath:    "acme-roles.list",
+			ExcludeRoles: "role/acme-breakglass",
+			Vars:         map[string][]string{"team": {"acme"}},
+			Packs:        "cicd",
+			RateLimit:    10,
+		}})
+		return nil
+	}))
+
+	data, err := os.ReadFile(filepath.Join(dir, "test.meta.json"))
+	require.NoError(t, err)
+	for _, leaked := range []string{"123456789012", "acme"} {
+		assert.NotContains(t, string(data), leaked)
+	}
+
+	md, err := storage.Metadata()
+	require.NoError(t, err)
+	require.Len(t, md.Runs, 1)
+	assert.Equal(t, RunOptions{Packs: "cicd", RateLimit: 10}, md.Runs[0].Options)
+}
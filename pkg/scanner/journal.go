@@ -0,0 +1,164 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// journalEntry is a single line in the journal.
+type journalEntry struct {
+	Arn  string     `json:"arn"`
+	Info utils.Info `json:"info"`
+}
+
+// journal is an append-only log of results written as they are produced, so results set between saves survive a
+// crash. It is truncated once the results have been saved into the main storage file.
+//
+// Each process writes its own journal and holds a lock on it while running, a journal nobody holds the lock on was
+// left behind by a process that exited without saving and is replayed by the next process to load the scan. Lines are
+// encrypted when the storage file is, an encrypted journal starts with a header describing its key.
+type journal struct {
+	path  string
+	file  *os.File
+	codec *codec
+}
+
+// openJournal creates a new journal for this process with a name starting with prefix.
+func openJournal(prefix string, c *codec) (*journal, error) {
+	file, err := os.CreateTemp(filepath.Dir(prefix), filepath.Base(prefix)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("creating journal: %w", err)
+	}
+
+	if locked, err := lockFile(file, false); err != nil || !locked {
+		file.Close()
+		return nil, fmt.Errorf("locking journal %s: %v", file.Name(), err)
+	}
+
+	j := &journal{path: file.Name(), file: file, codec: c}
+	if err := j.writeHeader(); err != nil {
+		j.Remove()
+		return nil, err
+	}
+	return j, nil
+}
+
+// journalPaths lists the journals for a scan, this includes the single journal older versions wrote to prefix.
+func journalPaths(prefix string) ([]string, error) {
+	paths, err := filepath.Glob(prefix + ".*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(prefix); err == nil {
+		paths = append(paths, prefix)
+	}
+	return paths, nil
+}
+
+// readJournal reads the results in the journal at path, orphaned is true if no running process holds its lock.
+//
+// A journal that disappears before it can be read is empty. A torn final line from a crash mid-write is skipped.
+func readJournal(path string, c *codec) (entries results, orphaned bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return results{}, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("opening journal: %w", err)
+	}
+	defer file.Close()
+
+	if orphaned, err = lockFile(file, false); err != nil {
+		return nil, false, fmt.Errorf("locking journal: %w", err)
+	}
+
+	entries = results{}
+	var key *dataKey
+
+	s := bufio.NewScanner(file)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		if bytes.HasPrefix(s.Bytes(), []byte("#")) {
+			if key, err = c.OpenJournalHeader(s.Bytes()); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+
+		line, err := c.OpenLine(key, s.Bytes())
+		if err != nil {
+			continue
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		entryKey, err := NewKey(entry.Arn)
+		if err != nil {
+			continue
+		}
+		entries.set(entryKey, entry.Info)
+	}
+	if err := s.Err(); err != nil {
+		return nil, false, fmt.Errorf("reading journal: %w", err)
+	}
+
+	return entries, orphaned, nil
+}
+
+func (j *journal) writeHeader() error {
+	header, err := j.codec.JournalHeader()
+	if err != nil || header == nil {
+		return err
+	}
+
+	if _, err := j.file.Write(append(header, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	return nil
+}
+
+// Append writes a single result to the journal.
+func (j *journal) Append(principalArn string, info utils.Info) error {
+	line, err := json.Marshal(journalEntry{Arn: principalArn, Info: info})
+	if err != nil {
+		return fmt.Errorf("marshalling journal entry: %w", err)
+	}
+	if line, err = j.codec.SealLine(line); err != nil {
+		return fmt.Errorf("encrypting journal entry: %w", err)
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+	return nil
+}
+
+// Truncate discards all entries, it should only be called after they have been saved elsewhere.
+func (j *journal) Truncate() error {
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return j.writeHeader()
+}
+
+func (j *journal) Close() error {
+	return j.file.Close()
+}
+
+// Remove closes and deletes the journal, it should only be called once its entries have been saved.
+func (j *journal) Remove() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
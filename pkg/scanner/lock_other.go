@@ -0,0 +1,12 @@
+//go:build !unix
+
+package scanner
+
+import (
+	"fmt"
+	"os"
+)
+
+func lockFile(_ *os.File, _ bool) (bool, error) {
+	return false, fmt.Errorf("file storage locking is not supported on this platform")
+}
@@ -1,6 +1,7 @@
 package scanner
 
 import (
+	"cmp"
 	"context"
 	"sync/atomic"
 
@@ -9,6 +10,10 @@ import (
 	"github.com/ryanjarv/roles/pkg/utils"
 	"github.com/samber/lo"
 	"iter"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,54 +21,108 @@ import (
 const maxScanAttempts = 3
 
 type NewScannerInput struct {
-	Storage   *Storage
-	Plugins   [][]plugins.Plugin
-	Force     bool
-	RateLimit int
+	Storage Storage
+	Plugins [][]plugins.Plugin
+	Force   bool
+	// RateLimit is the number of principals scanned per second, it can be under 1 for scans slower than one a second.
+	RateLimit float64
+	// RateBurst is the most principals scanned at once after the scan has been idle, 0 for the rate limit rounded up.
+	RateBurst int
+	// AccountRateLimit is the most principals scanned per second from each scanning account, 0 for no limit. ARNs
+	// the plugins of an account at its limit would have scanned go to the other accounts.
+	AccountRateLimit int
+
+	// SkipRootCheck assumes every account already exists and goes straight to scanning principal ARNs.
+	SkipRootCheck bool
+	// ShuffleRoots scans root ARNs in a random order instead of by account ID, so an interrupted scan of an account
+	// range has sampled all of it rather than only the start.
+	ShuffleRoots bool
+	// DryRun is for plugins that don't make any AWS calls, they aren't rate limited and their results aren't stored.
+	DryRun bool
+	// Monitor collects per plugin stats during the scan and can pause it, if set.
+	Monitor *Monitor
+	// Errors records every failed scan with the AWS error's code, message, and request ID, if set.
+	Errors *ErrorLog
 }
 
 func NewScanner(input *NewScannerInput) *Scanner {
+	scanPlugins := utils.FlattenList(input.Plugins)
+	if input.Errors != nil {
+		for i, plugin := range scanPlugins {
+			scanPlugins[i] = &errorLoggedPlugin{wrappedPlugin: wrappedPlugin{plugin}, errors: input.Errors}
+		}
+	}
+	if input.Monitor != nil {
+		for i, plugin := range scanPlugins {
+			scanPlugins[i] = &monitoredPlugin{wrappedPlugin: wrappedPlugin{plugin}, monitor: input.Monitor}
+		}
+	}
+
 	return &Scanner{
-		rateLimit: input.RateLimit,
-		storage:   input.Storage,
-		force:     input.Force,
-		Plugins:   utils.FlattenList(input.Plugins),
+		rateLimit:     input.RateLimit,
+		rateBurst:     input.RateBurst,
+		accountLimit:  input.AccountRateLimit,
+		storage:       input.Storage,
+		force:         input.Force,
+		skipRootCheck: input.SkipRootCheck,
+		shuffleRoots:  input.ShuffleRoots,
+		dryRun:        input.DryRun,
+		Plugins:       scanPlugins,
 	}
 }
 
 type Scanner struct {
-	storage   *Storage
-	force     bool
-	input     chan string
-	results   chan Result
-	Plugins   []plugins.Plugin
-	rateLimit int
+	storage       Storage
+	force         bool
+	skipRootCheck bool
+	shuffleRoots  bool
+	dryRun        bool
+	input         chan string
+	results       chan Result
+	Plugins       []plugins.Plugin
+	rateLimit     float64
+	rateBurst     int
+	accountLimit  int
 }
 
-// ScanArns scans the given ARN for access points
-func (s *Scanner) ScanArns(ctx *utils.Context, principalArns []string) iter.Seq2[string, bool] {
-	return func(yield func(string, bool) bool) {
-		rootArnMap := RootArnMap(ctx, principalArns)
+// ScanArns scans the given candidate ARNs and yields the result for each one, cached results are yielded without
+// being rescanned unless force is set.
+func (s *Scanner) ScanArns(ctx *utils.Context, candidates map[string]utils.Info) iter.Seq2[string, utils.Info] {
+	return func(yield func(string, utils.Info) bool) {
+		ctx := ctx.Module("scanner")
+		rootArnMap := RootArnMap(ctx, lo.Keys(candidates))
 
 		var rootArnsToScan []string
 		var allAccountArns []string
 
-		rateLimitBucket, cancel := rateLimiter(ctx, s.rateLimit)
-		defer cancel()
+		var rateLimitBucket chan int
+		var balance *balancer
+		if !s.dryRun {
+			var cancel context.CancelFunc
+			rateLimitBucket, cancel = rateLimiter(ctx, s.rateLimit, s.rateBurst)
+			defer cancel()
+			balance = newBalancer(s.accountLimit)
+			defer balance.log(ctx)
+		}
 
-		if s.force {
+		if s.skipRootCheck {
+			// The operator already knows these accounts exist, so don't spend any of the rate limit confirming it.
+			for _, accountArns := range rootArnMap {
+				allAccountArns = append(allAccountArns, accountArns...)
+			}
+		} else if s.force {
 			rootArnsToScan = lo.Keys(rootArnMap)
 		} else {
 			for rootArn, accountArns := range rootArnMap {
-				if status, err := s.storage.GetStatus(rootArn); err != nil {
-					ctx.Error.Fatalf("GetStatus: %s", err)
+				if info, status, err := s.cached(rootArn, candidates[rootArn]); err != nil {
+					ctx.Error.Fatalf("Get: %s", err)
 				} else if status == PrincipalDoesNotExist {
-					if !yield(rootArn, false) {
+					if !yield(rootArn, info) {
 						return
 					}
 				} else if status == PrincipalExists {
 					allAccountArns = append(allAccountArns, accountArns...)
-					if !yield(rootArn, true) {
+					if !yield(rootArn, info) {
 						return
 					}
 				} else if status == PrincipalUnknown {
@@ -77,13 +136,20 @@ func (s *Scanner) ScanArns(ctx *utils.Context, principalArns []string) iter.Seq2
 		if len(rootArnsToScan) > 0 {
 			ctx.Info.Printf("Scanning %d root ARNs", len(rootArnsToScan))
 
-			for root := range scanWithPlugins(ctx, s.Plugins, rootArnsToScan, rateLimitBucket) {
+			if s.shuffleRoots {
+				rand.Shuffle(len(rootArnsToScan), func(i, j int) {
+					rootArnsToScan[i], rootArnsToScan[j] = rootArnsToScan[j], rootArnsToScan[i]
+				})
+			} else {
+				slices.Sort(rootArnsToScan)
+			}
+
+			for root := range scanByPrincipalType(ctx, s.Plugins, rootArnsToScan, rateLimitBucket, balance) {
 				if root.Exists {
 					allAccountArns = append(allAccountArns, rootArnMap[root.Arn]...)
 				}
-				s.storage.Set(root.Arn, root.Exists)
 
-				if !yield(root.Arn, root.Exists) {
+				if !yield(root.Arn, s.record(ctx, root, candidates[root.Arn])) {
 					return
 				}
 			}
@@ -94,12 +160,12 @@ func (s *Scanner) ScanArns(ctx *utils.Context, principalArns []string) iter.Seq2
 			accountArnsToScan = allAccountArns
 		} else {
 			for _, principalArn := range allAccountArns {
-				if status, err := s.storage.GetStatus(principalArn); err != nil {
-					ctx.Error.Fatalf("GetStatus: %s", err)
+				if info, status, err := s.cached(principalArn, candidates[principalArn]); err != nil {
+					ctx.Error.Fatalf("Get: %s", err)
 				} else if status == PrincipalUnknown {
 					accountArnsToScan = append(accountArnsToScan, principalArn)
 				} else {
-					if !yield(principalArn, status == PrincipalExists) {
+					if !yield(principalArn, info) {
 						return
 					}
 				}
@@ -108,11 +174,10 @@ func (s *Scanner) ScanArns(ctx *utils.Context, principalArns []string) iter.Seq2
 
 		if len(accountArnsToScan) > 0 {
 			ctx.Info.Printf("Scanning %d account ARNs", len(accountArnsToScan))
+			sortByLikelihood(accountArnsToScan, candidates)
 
-			for result := range scanWithPlugins(ctx, s.Plugins, accountArnsToScan, rateLimitBucket) {
-				s.storage.Set(result.Arn, result.Exists)
-
-				if !yield(result.Arn, result.Exists) {
+			for result := range scanByPrincipalType(ctx, s.Plugins, accountArnsToScan, rateLimitBucket, balance) {
+				if !yield(result.Arn, s.record(ctx, result, candidates[result.Arn])) {
 					return
 				}
 			}
@@ -120,36 +185,168 @@ func (s *Scanner) ScanArns(ctx *utils.Context, principalArns []string) iter.Seq2
 	}
 }
 
-func rateLimiter(ctx *utils.Context, rateLimit int) (chan int, context.CancelFunc) {
-	rateLimitContext, cancelFunc := ctx.WithCancel()
+// sortByLikelihood orders principalArns so the candidates most likely to exist are scanned first and an interrupted
+// scan has already tried them, candidates with the same likelihood are ordered by ARN.
+func sortByLikelihood(principalArns []string, candidates map[string]utils.Info) {
+	slices.SortFunc(principalArns, func(a, b string) int {
+		if c := cmp.Compare(candidates[b].Likelihood, candidates[a].Likelihood); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+}
 
-	rateLimitBucket := make(chan int, rateLimit)
-	go func() {
-		for rateLimitContext.IsRunning() {
-			refillRateLimitBucket(rateLimitBucket, rateLimit)
-			time.Sleep(1 * time.Second)
+// cached returns the stored result for principalArn, using the candidate's comment, tags, and likelihood since the
+// input is the source of truth for them.
+func (s *Scanner) cached(principalArn string, candidate utils.Info) (utils.Info, PrincipalStatus, error) {
+	key, err := NewKey(principalArn)
+	if err != nil {
+		return utils.Info{}, PrincipalUnknown, err
+	}
+
+	info, status, err := s.storage.Get(key)
+	if candidate.Comment != "" {
+		info.Comment = candidate.Comment
+	}
+	if candidate.Tags != nil {
+		info.Tags = candidate.Tags
+	}
+	if candidate.Likelihood != 0 {
+		info.Likelihood = candidate.Likelihood
+	}
+	return info, status, err
+}
+
+// record stores the result of a scan and returns the stored entry.
+//
+// FirstSeen is carried over from the previously stored entry as long as the verdict hasn't changed, otherwise the
+// previous verdict is added to the entry's history.
+func (s *Scanner) record(ctx *utils.Context, result Result, candidate utils.Info) utils.Info {
+	now := time.Now().UTC()
+
+	info := utils.Info{
+		Comment:     candidate.Comment,
+		Exists:      result.Exists,
+		Plugin:      result.Plugin,
+		FirstSeen:   now,
+		LastChecked: now,
+		Tags:        candidate.Tags,
+		Likelihood:  candidate.Likelihood,
+	}
+
+	if s.dryRun {
+		return info
+	}
+
+	key, err := NewKey(result.Arn)
+	if err != nil {
+		ctx.Error.Printf("not storing result: %s", err)
+		return info
+	}
+
+	if prev, status, err := s.storage.Get(key); err == nil && status != PrincipalUnknown {
+		info.History = prev.History
+		if prev.Exists != info.Exists {
+			info.History = append(slices.Clip(prev.History), utils.StatusChange{
+				Exists:      prev.Exists,
+				FirstSeen:   prev.FirstSeen,
+				LastChecked: prev.LastChecked,
+			})
+		} else if !prev.FirstSeen.IsZero() {
+			info.FirstSeen = prev.FirstSeen
 		}
-	}()
-	return rateLimitBucket, cancelFunc
+		if info.Comment == "" {
+			info.Comment = prev.Comment
+		}
+		if info.Tags == nil {
+			info.Tags = prev.Tags
+		}
+		if info.Likelihood == 0 {
+			info.Likelihood = prev.Likelihood
+		}
+	}
+
+	s.storage.Set(key, info)
+	return info
 }
 
-func refillRateLimitBucket(rateLimitBucket chan int, tokens int) {
+// rateLimiter returns a token bucket that holds up to burst tokens and gets one every 1/rate seconds, so rates under
+// one a second are spread out rather than rounded, a burst of 0 is the rate rounded up. The bucket starts full.
+func rateLimiter(ctx *utils.Context, rate float64, burst int) (chan int, context.CancelFunc) {
+	rateLimitContext, cancelFunc := ctx.WithCancel()
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	rateLimitBucket := make(chan int, burst)
+	for i := 0; i < burst; i++ {
+		rateLimitBucket <- i
+	}
 	go func() {
-		for i := 0; i < tokens; i++ {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-rateLimitContext.Done():
+				return
+			case <-ticker.C:
+			}
+			// The tick and the cancellation can be ready at once, select picks either.
+			if rateLimitContext.IsDone() {
+				return
+			}
 			select {
 			case rateLimitBucket <- i:
 			default:
-				continue
 			}
 		}
 	}()
+	return rateLimitBucket, cancelFunc
 }
 
 func (s *Scanner) CleanUp(ctx *utils.Context) error {
 	return nil
 }
 
-func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principalArns []string, rateLimitBucket chan int) chan Result {
+// scanByPrincipalType scans each principal type with only the plugins that can validate it, a plugin that can't
+// validate a principal type would report every ARN of that type as not existing. ARNs no plugin supports are skipped.
+func scanByPrincipalType(ctx *utils.Context, scanPlugins []plugins.Plugin, principalArns []string, rateLimitBucket chan int, balance *balancer) chan Result {
+	var types []string
+	byType := map[string][]string{}
+	for _, principalArn := range principalArns {
+		principalType := plugins.PrincipalType(principalArn)
+		if _, ok := byType[principalType]; !ok {
+			types = append(types, principalType)
+		}
+		byType[principalType] = append(byType[principalType], principalArn)
+	}
+
+	results := make(chan Result)
+	go func() {
+		defer close(results)
+
+		for _, principalType := range types {
+			arns := byType[principalType]
+
+			supported := lo.Filter(scanPlugins, func(plugin plugins.Plugin, _ int) bool {
+				return slices.Contains(plugins.PrincipalTypes(plugin), principalType)
+			})
+			if len(supported) == 0 {
+				ctx.Error.Printf("skipping %d %s ARNs: no enabled plugin supports %s principals", len(arns), principalType, principalType)
+				continue
+			}
+
+			for result := range scanWithPlugins(ctx, supported, arns, rateLimitBucket, balance) {
+				results <- result
+			}
+		}
+	}()
+	return results
+}
+
+// scanWithPlugins scans principalArns with scanPlugins, rate limited by rateLimitBucket unless it's nil and with their
+// requests spread across the scanning accounts by balance unless it's nil.
+func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principalArns []string, rateLimitBucket chan int, balance *balancer) chan Result {
 	queueSize := 10 * len(scanPlugins)
 	if queueSize == 0 {
 		queueSize = len(principalArns)
@@ -161,6 +358,8 @@ func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principal
 	ctx.Debug.Printf("queue size: %d", queueSize)
 
 	input := make(chan string, queueSize)
+	// done is closed with input, so plugins waiting for their account's limit don't hold up the end of the scan.
+	done := make(chan struct{})
 	results := make(chan Result, queueSize)
 
 	var processed int64
@@ -174,8 +373,22 @@ func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principal
 		workerWg.Add(1)
 
 		go func(plugin plugins.Plugin) {
-			for principalArn := range input {
-				<-rateLimitBucket
+			// Once the plugin's credentials stop working every scan fails the same way, only the first of those
+			// errors is logged until a scan succeeds again.
+			credentialsFailing := false
+			balance.start(plugin)
+			for {
+				reserved := balance.reserve(ctx, plugin, done)
+				principalArn, ok := <-input
+				if !ok {
+					break
+				}
+				balance.claim(ctx, plugin, reserved)
+				balance.acquire(ctx, plugin)
+				// A nil bucket is a dry run, which isn't rate limited.
+				if rateLimitBucket != nil {
+					<-rateLimitBucket
+				}
 				exists, err := plugin.ScanArn(ctx, principalArn)
 				if err != nil {
 					attemptsMux.Lock()
@@ -183,19 +396,32 @@ func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principal
 					attempt := attempts[principalArn]
 					attemptsMux.Unlock()
 
+					logErr := ctx.Error
+					if utils.IsCredentialsError(err) {
+						if !credentialsFailing {
+							ctx.Error.Printf("%s: credentials stopped working, credentials errors are logged at debug level until a scan succeeds: %s", plugin.Name(), err)
+						}
+						credentialsFailing = true
+						logErr = ctx.Debug
+					}
+
 					if attempt < maxScanAttempts {
-						ctx.Error.Printf("%s: scanning %s: %s (retrying %d/%d)", plugin.Name(), principalArn, err, attempt+1, maxScanAttempts)
+						logErr.Printf("%s: scanning %s: %s (retrying %d/%d)", plugin.Name(), principalArn, err, attempt+1, maxScanAttempts)
 						// Must be a goroutine: if all workers are retrying and the input buffer is full,
-					// a direct send blocks forever since no worker can drain input while blocked.
-					workWg.Add(1)
-					go func() { input <- principalArn }()
+						// a direct send blocks forever since no worker can drain input while blocked.
+						workWg.Add(1)
+						go func() { input <- principalArn }()
 					} else {
-						ctx.Error.Printf("%s: scanning %s: %s (giving up after %d attempts)", plugin.Name(), principalArn, err, attempt)
+						logErr.Printf("%s: scanning %s: %s (giving up after %d attempts)", plugin.Name(), principalArn, err, attempt)
 					}
 					workWg.Done()
 					continue
 				}
 
+				if credentialsFailing {
+					ctx.Info.Printf("%s: credentials are working again", plugin.Name())
+					credentialsFailing = false
+				}
 				if exists {
 					ctx.Debug.Printf("found: %s", principalArn)
 				} else {
@@ -203,9 +429,10 @@ func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principal
 				}
 				atomic.AddInt64(&processed, 1)
 
-				results <- Result{Arn: principalArn, Exists: exists}
+				results <- Result{Arn: principalArn, Exists: exists, Plugin: plugin.Name()}
 				workWg.Done()
 			}
+			balance.stop(plugin)
 			ctx.Debug.Printf("%s: finished processing input", plugin.Name())
 
 			workerWg.Done()
@@ -224,6 +451,7 @@ func scanWithPlugins(ctx *utils.Context, scanPlugins []plugins.Plugin, principal
 
 		workWg.Wait()
 		close(input)
+		close(done)
 
 		workerWg.Wait()
 
@@ -247,7 +475,7 @@ func LogStats(ctx *utils.Context, processed *int64) context.CancelFunc {
 				n := atomic.LoadInt64(processed)
 				elapsed := time.Now().Sub(start)
 				perSecond := float64(n) / elapsed.Seconds()
-				ctx.Info.Printf("processed %d in %.1f seconds: %.1f/second", n, elapsed.Seconds(), perSecond)
+				ctx.Info.Log("processed", "count", n, "seconds", math.Round(elapsed.Seconds()*10)/10, "per_second", math.Round(perSecond*10)/10)
 			}
 		}
 	}()
@@ -261,7 +489,8 @@ func RootArnMap(ctx *utils.Context, principalArns []string) map[string][]string
 	for _, principalArn := range principalArns {
 		parsed, err := arn.Parse(principalArn)
 		if err != nil {
-			ctx.Error.Fatalf("parsing arn: %s", err)
+			ctx.Error.Printf("skipping %s, parsing arn: %s", principalArn, err)
+			continue
 		}
 
 		rootArn := utils.GetRootArn(parsed.AccountID)
@@ -2,8 +2,11 @@ package scanner
 
 import (
 	"context"
+	"fmt"
 	"github.com/google/go-cmp/cmp"
+	"github.com/ryanjarv/roles/pkg/plugins"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"slices"
 	"testing"
 	"time"
 )
@@ -70,6 +73,21 @@ func TestRootArnMap(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "TestRootArnMap_SkipsMalformed",
+			args: args{
+				ctx: utils.NewContext(context.Background()),
+				principalArns: []string{
+					"arn:aws:iam::123456789012:role/a",
+					"not-an-arn",
+				},
+			},
+			want: map[string][]string{
+				"arn:aws:iam::123456789012:root": {
+					"arn:aws:iam::123456789012:role/a",
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -94,7 +112,7 @@ func TestRateLimiter_NoMock(t *testing.T) {
 	rateLimit := 5
 
 	// 4. Invoke the rateLimiter function under test.
-	bucket, bucketCancel := rateLimiter(rateLimitCtx, rateLimit)
+	bucket, bucketCancel := rateLimiter(rateLimitCtx, float64(rateLimit), 0)
 
 	// Give the background goroutine a moment to fill the bucket.
 	time.Sleep(1200 * time.Millisecond)
@@ -172,3 +190,243 @@ readLoop3:
 		t.Errorf("expected 0 tokens after cancel, got %d", tokensAfterCancel)
 	}
 }
+
+// TestRateLimiter_Fractional verifies rates under one a second and a burst larger than the rate.
+func TestRateLimiter_Fractional(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	// 4 per second with a burst of 3, the bucket starts with the burst and then gets a token every 250ms.
+	bucket, cancel := rateLimiter(ctx, 4, 3)
+	if got := len(bucket); got != 3 {
+		t.Fatalf("expected the bucket to start with 3 tokens, got %d", got)
+	}
+	for range 3 {
+		<-bucket
+	}
+	start := time.Now()
+	<-bucket
+	if waited := time.Since(start); waited < 150*time.Millisecond || waited > 400*time.Millisecond {
+		t.Errorf("expected a token after about 250ms, waited %s", waited)
+	}
+	cancel()
+
+	// 0.5 per second rounds the burst up to one token, and the next one takes two seconds.
+	bucket, cancel = rateLimiter(ctx, 0.5, 0)
+	defer cancel()
+	if got := cap(bucket); got != 1 {
+		t.Fatalf("expected a burst of 1, got %d", got)
+	}
+	<-bucket
+	select {
+	case <-bucket:
+		t.Fatalf("expected no token within a second at 0.5 per second")
+	case <-time.After(time.Second):
+	}
+}
+
+// TestScanArns_SkipRootCheck verifies that root ARNs are never scanned when SkipRootCheck is set.
+func TestScanArns_SkipRootCheck(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	var scanned []string
+	plugin := &mockPlugin{
+		name: "test-plugin",
+		scanFunc: func(arn string) (bool, error) {
+			scanned = append(scanned, arn)
+			return true, nil
+		},
+	}
+
+	scan := NewScanner(&NewScannerInput{
+		Storage:       &FileStorage{data: results{}},
+		Plugins:       [][]plugins.Plugin{{plugin}},
+		RateLimit:     50,
+		SkipRootCheck: true,
+	})
+
+	got := map[string]bool{}
+	for principalArn, info := range scan.ScanArns(ctx, map[string]utils.Info{
+		"arn:aws:iam::123456789012:root":   {},
+		"arn:aws:iam::123456789012:role/a": {},
+	}) {
+		got[principalArn] = info.Exists
+	}
+
+	if diff := cmp.Diff([]string{"arn:aws:iam::123456789012:role/a"}, scanned); diff != "" {
+		t.Errorf("scanned mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]bool{"arn:aws:iam::123456789012:role/a": true}, got); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestScanArns_RootOrder verifies root ARNs are scanned by account ID unless ShuffleRoots is set.
+func TestScanArns_RootOrder(t *testing.T) {
+	candidates := map[string]utils.Info{}
+	var want []string
+	for i := range 20 {
+		root := utils.GetRootArn(fmt.Sprintf("%012d", i))
+		candidates[root] = utils.Info{}
+		want = append(want, root)
+	}
+
+	scanOrder := func(shuffle bool) []string {
+		ctx := utils.NewContext(context.Background())
+
+		var scanned []string
+		scan := NewScanner(&NewScannerInput{
+			Storage: &FileStorage{data: results{}},
+			Plugins: [][]plugins.Plugin{{&mockPlugin{
+				name: "test-plugin",
+				scanFunc: func(arn string) (bool, error) {
+					scanned = append(scanned, arn)
+					return false, nil
+				},
+			}}},
+			RateLimit:    50,
+			ShuffleRoots: shuffle,
+		})
+		for range scan.ScanArns(ctx, candidates) {
+		}
+		return scanned
+	}
+
+	if diff := cmp.Diff(want, scanOrder(false)); diff != "" {
+		t.Errorf("scan order mismatch (-want +got):\n%s", diff)
+	}
+
+	shuffled := scanOrder(true)
+	slices.Sort(shuffled)
+	if diff := cmp.Diff(want, shuffled); diff != "" {
+		t.Errorf("shuffled roots mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestScanArns_LikelihoodOrder(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	var scanned []string
+	scan := NewScanner(&NewScannerInput{
+		Storage: &FileStorage{data: results{}},
+		Plugins: [][]plugins.Plugin{{&mockPlugin{
+			name: "test-plugin",
+			scanFunc: func(arn string) (bool, error) {
+				scanned = append(scanned, arn)
+				return false, nil
+			},
+		}}},
+		RateLimit:     50,
+		SkipRootCheck: true,
+	})
+	for range scan.ScanArns(ctx, map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/a": {},
+		"arn:aws:iam::123456789012:role/b": {Likelihood: 0.2},
+		"arn:aws:iam::123456789012:role/c": {Likelihood: 0.9},
+		"arn:aws:iam::123456789013:role/d": {Likelihood: 0.5},
+		"arn:aws:iam::123456789012:role/e": {},
+	}) {
+	}
+
+	want := []string{
+		"arn:aws:iam::123456789012:role/c",
+		"arn:aws:iam::123456789013:role/d",
+		"arn:aws:iam::123456789012:role/b",
+		"arn:aws:iam::123456789012:role/a",
+		"arn:aws:iam::123456789012:role/e",
+	}
+	if diff := cmp.Diff(want, scanned); diff != "" {
+		t.Errorf("scan order mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestScanArns_RecordsTimestampsAndPlugin verifies stored entries carry the plugin, keep FirstSeen across rescans, and
+// keep the previous verdict in their history when it changes.
+func TestScanArns_RecordsTimestampsAndPlugin(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	firstSeen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage := &FileStorage{data: mustGroup(map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/a": {Exists: true, FirstSeen: firstSeen, LastChecked: firstSeen},
+		"arn:aws:iam::123456789012:role/c": {Exists: false, FirstSeen: firstSeen, LastChecked: firstSeen},
+	})}
+
+	scan := NewScanner(&NewScannerInput{
+		Storage:       storage,
+		Plugins:       [][]plugins.Plugin{{&mockPlugin{name: "test-plugin"}}},
+		RateLimit:     50,
+		Force:         true,
+		SkipRootCheck: true,
+	})
+
+	for range scan.ScanArns(ctx, map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/a": {Comment: "a"},
+		"arn:aws:iam::123456789012:role/b": {Comment: "b", Tags: []string{"ci"}, Likelihood: 0.5},
+		"arn:aws:iam::123456789012:role/c": {},
+	}) {
+	}
+
+	a, _, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.FirstSeen.Equal(firstSeen) || !a.LastChecked.After(firstSeen) || a.Plugin != "test-plugin" || a.Comment != "a" {
+		t.Errorf("unexpected entry for role/a: %+v", a)
+	}
+
+	b, status, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != PrincipalExists || b.FirstSeen.IsZero() || !b.FirstSeen.Equal(b.LastChecked) || b.Plugin != "test-plugin" ||
+		!cmp.Equal(b.Tags, []string{"ci"}) || b.Likelihood != 0.5 {
+		t.Errorf("unexpected entry for role/b: %+v", b)
+	}
+
+	c, _, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHistory := []utils.StatusChange{{Exists: false, FirstSeen: firstSeen, LastChecked: firstSeen}}
+	if !c.Exists || !c.FirstSeen.After(firstSeen) || !cmp.Equal(c.History, wantHistory) {
+		t.Errorf("unexpected entry for role/c: %+v", c)
+	}
+	if exists, known := c.At(firstSeen); !known || exists {
+		t.Errorf("expected role/c to not exist as of %s", firstSeen)
+	}
+	if a.History != nil || b.History != nil {
+		t.Errorf("expected no history for unchanged verdicts")
+	}
+}
+
+// TestScanArns_DryRun verifies a dry run isn't rate limited and doesn't store results.
+func TestScanArns_DryRun(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	storage := &FileStorage{data: results{}}
+
+	candidates := map[string]utils.Info{"arn:aws:iam::123456789012:root": {}}
+	for i := range 20 {
+		candidates[fmt.Sprintf("arn:aws:iam::123456789012:role/r%d", i)] = utils.Info{}
+	}
+
+	scan := NewScanner(&NewScannerInput{
+		Storage:   storage,
+		Plugins:   [][]plugins.Plugin{{&mockPlugin{name: "test-plugin"}}},
+		RateLimit: 1,
+		DryRun:    true,
+	})
+
+	start := time.Now()
+	n := 0
+	for range scan.ScanArns(ctx, candidates) {
+		n++
+	}
+	if n != 21 {
+		t.Errorf("got %d results, want 21", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dry run took %s, it shouldn't be rate limited", elapsed)
+	}
+	if len(storage.data) != 0 {
+		t.Errorf("dry run stored %d accounts", len(storage.data))
+	}
+}
@@ -0,0 +1,146 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Metadata is stored alongside a scan's results.
+type Metadata struct {
+	// Engagement describes the authorized engagement the scan is part of, scans are refused outside of its window.
+	Engagement *Engagement `json:"engagement,omitempty"`
+	// Runs records each scan of this name, oldest first.
+	Runs []Run `json:"runs,omitempty"`
+}
+
+// Run records a single scan, End is zero if the scan is still running or didn't finish.
+type Run struct {
+	ID      int        `json:"id"`
+	Start   time.Time  `json:"start"`
+	End     time.Time  `json:"end"`
+	Options RunOptions `json:"options"`
+	// Candidates is how many principal ARNs were generated from the inputs.
+	Candidates int `json:"candidates"`
+	// Findings is how many principals were found to exist, including ones already stored.
+	Findings int `json:"findings"`
+}
+
+// RunOptions are the inputs a scan was run with.
+type RunOptions struct {
+	Accounts           string              `json:"accounts,omitempty"`
+	AccountsPath       string              `json:"accounts_path,omitempty"`
+	AccountRange       string              `json:"account_range,omitempty"`
+	AccountStride      int                 `json:"account_stride,omitempty"`
+	RolesPath          string              `json:"roles_path,omitempty"`
+	PrincipalPath      string              `json:"principals_path,omitempty"`
+	Wordlists          string              `json:"wordlists,omitempty"`
+	Packs              string              `json:"packs,omitempty"`
+	FromTerraform      string              `json:"from_terraform,omitempty"`
+	FromCloudFormation string              `json:"from_cloudformation,omitempty"`
+	CDKQualifiers      string              `json:"cdk_qualifiers,omitempty"`
+	SSOPermissionSets  string              `json:"sso_permission_sets,omitempty"`
+	SSOSuffixes        string              `json:"sso_suffixes,omitempty"`
+	Permute            bool                `json:"permute,omitempty"`
+	ExcludeRoles       string              `json:"exclude_roles,omitempty"`
+	ExcludeAccounts    string              `json:"exclude_accounts,omitempty"`
+	TryPaths           string              `json:"try_paths,omitempty"`
+	Vars               map[string][]string `json:"vars,omitempty"`
+	VarFile            string              `json:"var_file,omitempty"`
+	Force              bool                `json:"force,omitempty"`
+	SkipRootCheck      bool                `json:"skip_root_check,omitempty"`
+	RateLimit          float64             `json:"rate_limit,omitempty"`
+	RateBurst          int                 `json:"rate_burst,omitempty"`
+}
+
+// StartRun appends run with the next run ID and returns the ID.
+func (md *Metadata) StartRun(run Run) int {
+	run.ID = 1
+	if n := len(md.Runs); n > 0 {
+		run.ID = md.Runs[n-1].ID + 1
+	}
+	md.Runs = append(md.Runs, run)
+	return run.ID
+}
+
+// Run returns the run with the given ID, or nil if there isn't one.
+func (md *Metadata) Run(id int) *Run {
+	for i := range md.Runs {
+		if md.Runs[i].ID == id {
+			return &md.Runs[i]
+		}
+	}
+	return nil
+}
+
+// Engagement records who a scan is for and when it is authorized, so results stay separated and defensible.
+type Engagement struct {
+	Client string `json:"client,omitempty"`
+	// Authorization is a reference to the document authorizing the testing, like a statement of work or ticket.
+	Authorization string `json:"authorization,omitempty"`
+	// Start and End are the authorized window, a zero value leaves that side of the window open.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Active returns an error if now is outside the engagement window.
+func (e Engagement) Active(now time.Time) error {
+	if !e.Start.IsZero() && now.Before(e.Start) {
+		return fmt.Errorf("engagement starts on %s", e.Start.Format(time.DateTime))
+	}
+	if !e.End.IsZero() && now.After(e.End) {
+		return fmt.Errorf("engagement ended on %s", e.End.Format(time.DateTime))
+	}
+	return nil
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)*$`)
+
+// ValidateName checks a scan name is safe to use in file paths and object keys. Names can be split into namespaces
+// with slashes, for example client/engagement.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid scan name %q: use letters, numbers, '.', '_' and '-', with '/' between namespaces", name)
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "." || segment == ".." {
+			return fmt.Errorf("invalid scan name %q: namespaces can't be . or ..", name)
+		}
+	}
+	return nil
+}
+
+// MarshalMetadata encodes metadata, it is encrypted along with the results but isn't compressed.
+func (c *codec) MarshalMetadata(md Metadata) ([]byte, error) {
+	data, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshalling metadata: %s", err)
+	}
+
+	if !c.encrypted() {
+		return data, nil
+	}
+	if data, err = c.encrypt(data); err != nil {
+		return nil, fmt.Errorf("encrypting metadata: %s", err)
+	}
+	return data, nil
+}
+
+func (c *codec) UnmarshalMetadata(data []byte) (Metadata, error) {
+	md := Metadata{}
+
+	if strings.HasPrefix(string(data), string(encryptionMagic)) {
+		plaintext, _, err := c.decrypt(data)
+		if err != nil {
+			return md, fmt.Errorf("decrypting metadata: %s", err)
+		}
+		data = plaintext
+	}
+
+	if err := json.Unmarshal(data, &md); err != nil {
+		return md, fmt.Errorf("unmarshalling metadata: %s", err)
+	}
+	return md, nil
+}
@@ -0,0 +1,105 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngagement_Active(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		engagement Engagement
+		now        time.Time
+		wantErr    string
+	}{
+		{name: "open", engagement: Engagement{}, now: start},
+		{name: "within", engagement: Engagement{Start: start, End: end}, now: start.AddDate(0, 0, 10)},
+		{name: "before start", engagement: Engagement{Start: start, End: end}, now: start.Add(-time.Second), wantErr: "engagement starts on 2024-01-01 00:00:00"},
+		{name: "after end", engagement: Engagement{Start: start, End: end}, now: end.Add(time.Second), wantErr: "engagement ended on 2024-01-31 00:00:00"},
+		{name: "no start", engagement: Engagement{End: end}, now: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.engagement.Active(tt.now)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"default", "acme/2024-q1", "a.b_c-d/e"} {
+		assert.NoError(t, ValidateName(name), name)
+	}
+	for _, name := range []string{"", "/abs", "trailing/", "a//b", "../escape", "a/./b", "spaces here"} {
+		assert.Error(t, ValidateName(name), name)
+	}
+}
+
+func TestFileStorage_Metadata(t *testing.T) {
+	tests := []struct {
+		name string
+		opts StorageOptions
+	}{
+		{name: "plaintext"},
+		{name: "encrypted", opts: StorageOptions{Encryption: EncryptionPassphrase, passphrase: func() (string, error) { return "hunter2", nil }}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := utils.NewContext(context.Background())
+			dir := t.TempDir()
+
+			storage, err := NewFileStorage(ctx, dir, "acme/q1", tt.opts)
+			require.NoError(t, err)
+			defer storage.Close()
+
+			md, err := storage.Metadata()
+			require.NoError(t, err)
+			assert.Nil(t, md.Engagement)
+
+			end := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+			require.NoError(t, storage.UpdateMetadata(func(md *Metadata) error {
+				md.Engagement = &Engagement{Client: "acme", Authorization: "SOW-42", End: end}
+				return nil
+			}))
+
+			other, err := NewFileStorage(ctx, dir, "acme/q1", tt.opts)
+			require.NoError(t, err)
+			defer other.Close()
+
+			md, err = other.Metadata()
+			require.NoError(t, err)
+			assert.Equal(t, &Engagement{Client: "acme", Authorization: "SOW-42", End: end}, md.Engagement)
+
+			data, err := os.ReadFile(other.metadataPath)
+			require.NoError(t, err)
+			if tt.opts.Encryption != "" {
+				assert.NotContains(t, string(data), "acme")
+			} else {
+				assert.Contains(t, string(data), "SOW-42")
+			}
+		})
+	}
+}
+
+func TestMetadata_Runs(t *testing.T) {
+	md := Metadata{}
+	assert.Equal(t, 1, md.StartRun(Run{Candidates: 10}))
+	assert.Equal(t, 2, md.StartRun(Run{Candidates: 20}))
+
+	md.Run(1).Findings = 3
+	assert.Equal(t, []Run{{ID: 1, Candidates: 10, Findings: 3}, {ID: 2, Candidates: 20}}, md.Runs)
+	assert.Nil(t, md.Run(3))
+}
@@ -0,0 +1,125 @@
+package scanner
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"sync"
+)
+
+// PluginStats counts the calls a plugin instance made during a scan.
+type PluginStats struct {
+	Scanned int64
+	Found   int64
+	Errors  int64
+	// Throttled is the number of errors that were throttling, after the SDK's own retries gave up.
+	Throttled int64
+}
+
+// Monitor collects per plugin stats while a scan runs and can pause it, for showing a scan's progress live like
+// roles -tui does. It's safe for concurrent use.
+type Monitor struct {
+	mux    sync.Mutex
+	stats  map[string]*PluginStats
+	paused bool
+	resume chan struct{}
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{stats: map[string]*PluginStats{}}
+}
+
+// Stats returns a copy of the stats of each plugin instance that has been called, by instance name.
+func (m *Monitor) Stats() map[string]PluginStats {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	result := make(map[string]PluginStats, len(m.stats))
+	for name, stats := range m.stats {
+		result[name] = *stats
+	}
+	return result
+}
+
+// Pause stops plugins from starting new calls until Resume is called, calls already in flight finish.
+func (m *Monitor) Pause() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if !m.paused {
+		m.paused = true
+		m.resume = make(chan struct{})
+	}
+}
+
+func (m *Monitor) Resume() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if m.paused {
+		m.paused = false
+		close(m.resume)
+	}
+}
+
+func (m *Monitor) Paused() bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.paused
+}
+
+// wait blocks while the scan is paused, it returns false if ctx is done first.
+func (m *Monitor) wait(ctx *utils.Context) bool {
+	m.mux.Lock()
+	paused, resume := m.paused, m.resume
+	m.mux.Unlock()
+
+	if !paused {
+		return true
+	}
+	select {
+	case <-resume:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (m *Monitor) record(name string, exists bool, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	stats, ok := m.stats[name]
+	if !ok {
+		stats = &PluginStats{}
+		m.stats[name] = stats
+	}
+
+	switch {
+	case err == nil:
+		stats.Scanned++
+		if exists {
+			stats.Found++
+		}
+	case retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary:
+		stats.Errors++
+		stats.Throttled++
+	default:
+		stats.Errors++
+	}
+}
+
+// monitoredPlugin records the calls of the plugin it wraps and holds them while the scan is paused.
+type monitoredPlugin struct {
+	wrappedPlugin
+	monitor *Monitor
+}
+
+func (p *monitoredPlugin) ScanArn(ctx *utils.Context, principalArn string) (bool, error) {
+	if !p.monitor.wait(ctx) {
+		return false, ctx.Err()
+	}
+	exists, err := p.Plugin.ScanArn(ctx, principalArn)
+	p.monitor.record(p.Name(), exists, err)
+	return exists, err
+}
@@ -0,0 +1,101 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitor_RecordsPerPluginStats(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	monitor := NewMonitor()
+
+	calls := map[string]int{}
+	plugin := &mockPlugin{
+		name: "test-plugin",
+		scanFunc: func(arn string) (bool, error) {
+			calls[arn]++
+			switch arn {
+			case "arn:aws:iam::111111111111:role/Throttled":
+				if calls[arn] == 1 {
+					return false, &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+				}
+			case "arn:aws:iam::111111111111:role/Error":
+				if calls[arn] == 1 {
+					return false, fmt.Errorf("simulated transient AWS error")
+				}
+			case "arn:aws:iam::111111111111:role/Missing":
+				return false, nil
+			}
+			return true, nil
+		},
+	}
+
+	scan := NewScanner(&NewScannerInput{Plugins: [][]plugins.Plugin{{plugin}}, Monitor: monitor})
+	arns := []string{
+		"arn:aws:iam::111111111111:role/Found",
+		"arn:aws:iam::111111111111:role/Missing",
+		"arn:aws:iam::111111111111:role/Throttled",
+		"arn:aws:iam::111111111111:role/Error",
+	}
+	for range scanWithPlugins(ctx, scan.Plugins, arns, unlimitedBucket(), nil) {
+	}
+
+	assert.Equal(t, map[string]PluginStats{
+		"test-plugin": {Scanned: 4, Found: 3, Errors: 2, Throttled: 1},
+	}, monitor.Stats())
+}
+
+func TestMonitor_PauseHoldsScans(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	monitor := NewMonitor()
+	monitor.Pause()
+	assert.True(t, monitor.Paused())
+
+	plugin := &monitoredPlugin{wrappedPlugin: wrappedPlugin{&mockPlugin{name: "test-plugin"}}, monitor: monitor}
+	done := make(chan bool)
+	go func() {
+		exists, _ := plugin.ScanArn(ctx, "arn:aws:iam::111111111111:role/a")
+		done <- exists
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("scan finished while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	monitor.Resume()
+	select {
+	case exists := <-done:
+		assert.True(t, exists)
+	case <-time.After(time.Second):
+		t.Fatal("scan didn't finish after resuming")
+	}
+	assert.False(t, monitor.Paused())
+}
+
+func TestMonitor_PausedScanEndsWithContext(t *testing.T) {
+	ctx, cancel := utils.NewContext(context.Background()).WithCancel()
+	monitor := NewMonitor()
+	monitor.Pause()
+
+	plugin := &monitoredPlugin{wrappedPlugin: wrappedPlugin{&mockPlugin{name: "test-plugin"}}, monitor: monitor}
+	cancel()
+	_, err := plugin.ScanArn(ctx, "arn:aws:iam::111111111111:role/a")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, monitor.Stats())
+}
+
+func TestMonitor_KeepsPrincipalTypes(t *testing.T) {
+	federated := &federatedPlugin{mockPlugin{name: "federated"}}
+	plugin := &monitoredPlugin{wrappedPlugin: wrappedPlugin{federated}, monitor: NewMonitor()}
+	assert.Equal(t, plugins.PrincipalTypes(federated), plugins.PrincipalTypes(plugin))
+	assert.True(t, plugins.Supports(plugin, "arn:aws:iam::111111111111:saml-provider/Okta"))
+}
@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
+	"strings"
+	"sync"
+)
+
+// Key identifies a stored result, results are grouped by the account the principal belongs to.
+type Key struct {
+	AccountID string
+	Arn       string
+}
+
+// NewKey returns the key for principalArn, the account ID is taken from the ARN.
+func NewKey(principalArn string) (Key, error) {
+	parsed, err := arn.Parse(principalArn)
+	if err != nil {
+		return Key{}, fmt.Errorf("parsing %s: %w", principalArn, err)
+	}
+	if parsed.AccountID == "" {
+		return Key{}, fmt.Errorf("parsing %s: missing account ID", principalArn)
+	}
+	return Key{AccountID: parsed.AccountID, Arn: principalArn}, nil
+}
+
+func (k Key) String() string {
+	return k.Arn
+}
+
+// results holds stored results by account ID and then principal ARN, this is also the layout of the JSON storage
+// files.
+type results map[string]map[string]utils.Info
+
+// groupByAccount converts results keyed by ARN only, as older storage files were, to results grouped by account.
+func groupByAccount(flat map[string]utils.Info) (results, error) {
+	grouped := results{}
+	for principalArn, info := range flat {
+		key, err := NewKey(principalArn)
+		if err != nil {
+			return nil, err
+		}
+		grouped.set(key, info)
+	}
+	return grouped, nil
+}
+
+// get looks up the result for key, callers must hold the backend's lock.
+func (r results) get(key Key) (utils.Info, PrincipalStatus) {
+	if info, ok := r[key.AccountID][key.Arn]; !ok {
+		return utils.Info{}, PrincipalUnknown
+	} else if info.Exists {
+		return info, PrincipalExists
+	} else {
+		return info, PrincipalDoesNotExist
+	}
+}
+
+func (r results) set(key Key, info utils.Info) {
+	account, ok := r[key.AccountID]
+	if !ok {
+		account = map[string]utils.Info{}
+		r[key.AccountID] = account
+	}
+	account[key.Arn] = info
+}
+
+// setIfNewer stores info unless the result already stored for key was checked more recently.
+func (r results) setIfNewer(key Key, info utils.Info) bool {
+	if current, ok := r[key.AccountID][key.Arn]; ok && current.LastChecked.After(info.LastChecked) {
+		return false
+	}
+	r.set(key, info)
+	return true
+}
+
+// delete removes the result for key, an account without results is removed too.
+func (r results) delete(key Key) {
+	delete(r[key.AccountID], key.Arn)
+	if len(r[key.AccountID]) == 0 {
+		delete(r, key.AccountID)
+	}
+}
+
+// deleteIfNotNewer removes the result for key unless it was checked more recently than info, so deleting a result
+// doesn't discard a newer check saved by another process.
+func (r results) deleteIfNotNewer(key Key, info utils.Info) bool {
+	if current, ok := r[key.AccountID][key.Arn]; !ok || current.LastChecked.After(info.LastChecked) {
+		return false
+	}
+	r.delete(key)
+	return true
+}
+
+func (r results) len() int {
+	n := 0
+	for _, account := range r {
+		n += len(account)
+	}
+	return n
+}
+
+func (r results) all() iter.Seq2[Key, utils.Info] {
+	return func(yield func(Key, utils.Info) bool) {
+		for accountID, account := range r {
+			for principalArn, info := range account {
+				if !yield(Key{AccountID: accountID, Arn: principalArn}, info) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r results) clone() results {
+	clone := make(results, len(r))
+	for key, info := range r.all() {
+		clone.set(key, info)
+	}
+	return clone
+}
+
+// UnmarshalJSON also accepts the older layout keyed by ARN only, which is regrouped by account.
+func (r *results) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	legacy := false
+	for key := range raw {
+		legacy = strings.HasPrefix(key, "arn:")
+		break
+	}
+
+	if legacy {
+		var flat map[string]utils.Info
+		if err := json.Unmarshal(data, &flat); err != nil {
+			return err
+		}
+		grouped, err := groupByAccount(flat)
+		if err != nil {
+			return err
+		}
+		*r = grouped
+		return nil
+	}
+
+	grouped := map[string]map[string]utils.Info{}
+	if err := json.Unmarshal(data, &grouped); err != nil {
+		return err
+	}
+	for accountID, account := range grouped {
+		for principalArn := range account {
+			if key, err := NewKey(principalArn); err != nil {
+				return err
+			} else if key.AccountID != accountID {
+				return fmt.Errorf("%s is stored under account %s", principalArn, accountID)
+			}
+		}
+	}
+	*r = grouped
+	return nil
+}
+
+// snapshot copies a backend's results under its lock so callers can iterate them while the backend is in use.
+func snapshot(mux *sync.Mutex, data results) iter.Seq2[Key, utils.Info] {
+	mux.Lock()
+	clone := data.clone()
+	mux.Unlock()
+
+	return clone.all()
+}
@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKey(t *testing.T) {
+	tests := []struct {
+		arn     string
+		want    Key
+		wantErr bool
+	}{
+		{arn: "arn:aws:iam::123456789012:role/a", want: Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:role/a"}},
+		{arn: "arn:aws:iam::123456789012:root", want: Key{AccountID: "123456789012", Arn: "arn:aws:iam::123456789012:root"}},
+		{arn: "arn:aws:s3:::bucket", wantErr: true},
+		{arn: "role/a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			got, err := NewKey(tt.arn)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResults_RoundTrip(t *testing.T) {
+	data := results{}
+	data.set(mustKey("arn:aws:iam::123456789012:root"), utils.Info{Exists: true})
+	data.set(mustKey("arn:aws:iam::123456789012:role/a"), utils.Info{Exists: true})
+	data.set(mustKey("arn:aws:iam::210987654321:role/a"), utils.Info{Exists: false})
+
+	// Roles with the same name in different accounts are separate results.
+	info, status := data.get(mustKey("arn:aws:iam::210987654321:role/a"))
+	assert.Equal(t, PrincipalDoesNotExist, status)
+	assert.Equal(t, utils.Info{Exists: false}, info)
+	_, status = data.get(mustKey("arn:aws:iam::123456789012:role/b"))
+	assert.Equal(t, PrincipalUnknown, status)
+	assert.Equal(t, 3, data.len())
+
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	var raw map[string]map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(encoded, &raw))
+	assert.Contains(t, raw["123456789012"], "arn:aws:iam::123456789012:role/a")
+	assert.Contains(t, raw["210987654321"], "arn:aws:iam::210987654321:role/a")
+
+	decoded := results{}
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, data, decoded)
+}
+
+func TestResults_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    results
+		wantErr bool
+	}{
+		{
+			name: "legacy flat layout",
+			data: `{"arn:aws:iam::123456789012:role/a": true, "arn:aws:iam::210987654321:role/b": {"exists": false}}`,
+			want: results{
+				"123456789012": {"arn:aws:iam::123456789012:role/a": {Exists: true}},
+				"210987654321": {"arn:aws:iam::210987654321:role/b": {Exists: false}},
+			},
+		},
+		{
+			name: "grouped by account",
+			data: `{"123456789012": {"arn:aws:iam::123456789012:role/a": {"exists": true}}}`,
+			want: results{"123456789012": {"arn:aws:iam::123456789012:role/a": {Exists: true}}},
+		},
+		{
+			name:    "wrong account",
+			data:    `{"210987654321": {"arn:aws:iam::123456789012:role/a": {"exists": true}}}`,
+			wantErr: true,
+		},
+		{
+			name: "empty",
+			data: `{}`,
+			want: results{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := results{}
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
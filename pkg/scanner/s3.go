@@ -0,0 +1,291 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"io"
+	"iter"
+	"path"
+	"sync"
+)
+
+// maxSaveAttempts is how many times Save retries after losing a race with another writer.
+const maxSaveAttempts = 5
+
+type IS3Client interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// NewS3Storage stores results in one object per scan name at s3://<bucket>/<prefix>/<name>.json.
+//
+// Concurrent writers are detected with the object's ETag, when Save loses a race the object is reloaded and the
+// results set since the last save are applied on top of it before trying again.
+func NewS3Storage(ctx *utils.Context, cfg aws.Config, bucket string, prefix string, name string, opts StorageOptions) (*S3Storage, error) {
+	storage := &S3Storage{
+		ctx:     ctx,
+		client:  s3.NewFromConfig(cfg),
+		bucket:  bucket,
+		key:     path.Join(prefix, name+".json"),
+		metaKey: path.Join(prefix, name+".meta.json"),
+		data:    results{},
+		pending: results{},
+		codec:   newCodec(ctx, opts),
+	}
+
+	utils.RunOnSigterm(ctx, func(ctx *utils.Context) {
+		if err := storage.Save(); err != nil {
+			ctx.Error.Printf("saving data: %s", err)
+		}
+
+		ctx.Info.Printf("saved data")
+	})
+
+	if err := storage.Load(ctx); err != nil {
+		return nil, fmt.Errorf("loading storage: %s", err)
+	}
+
+	return storage, nil
+}
+
+type S3Storage struct {
+	ctx     *utils.Context
+	client  IS3Client
+	bucket  string
+	key     string
+	metaKey string
+
+	mux     sync.Mutex
+	data    results
+	pending results
+	deleted results
+	etag    *string
+	codec   *codec
+}
+
+// Load reads the scan's object from S3, a missing object is treated as an empty scan.
+func (s *S3Storage) Load(ctx *utils.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+
+	// Get the encryption key up front so a passphrase isn't prompted for after the scan.
+	if s.codec.encrypted() {
+		return s.codec.ensureKey()
+	}
+	return nil
+}
+
+// load replaces the in-memory results with the current contents of the object, callers must hold s.mux.
+func (s *S3Storage) load(ctx *utils.Context) error {
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &s.key,
+	})
+
+	var noSuchKey *s3Types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		ctx.Debug.Printf("s3://%s/%s does not exist yet", s.bucket, s.key)
+		s.data = results{}
+		s.etag = nil
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+
+	data, err := s.codec.Unmarshal(body)
+	if err != nil {
+		return err
+	}
+
+	s.data = data
+	s.etag = resp.ETag
+	return nil
+}
+
+func (s *S3Storage) Get(key Key) (utils.Info, PrincipalStatus, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	info, status := s.data.get(key)
+	return info, status, nil
+}
+
+func (s *S3Storage) All() iter.Seq2[Key, utils.Info] {
+	return snapshot(&s.mux, s.data)
+}
+
+func (s *S3Storage) Set(key Key, info utils.Info) {
+	s.mux.Lock()
+	s.data.set(key, info)
+	s.pending.set(key, info)
+	s.mux.Unlock()
+}
+
+// Delete removes the result from memory, it is removed from the object on the next save.
+func (s *S3Storage) Delete(key Key) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	info, status := s.data.get(key)
+	if status == PrincipalUnknown {
+		return nil
+	}
+
+	s.data.delete(key)
+	s.pending.delete(key)
+	if s.deleted == nil {
+		s.deleted = results{}
+	}
+	s.deleted.set(key, info)
+	return nil
+}
+
+// Save uploads the results, only overwriting the object if it hasn't changed since it was last read.
+func (s *S3Storage) Save() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		data, err := s.codec.Marshal(s.data)
+		if err != nil {
+			return err
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket: &s.bucket,
+			Key:    &s.key,
+			Body:   bytes.NewReader(data),
+		}
+		if s.etag != nil {
+			input.IfMatch = s.etag
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+
+		resp, err := s.client.PutObject(s.ctx, input)
+		if s3WriteConflict(err) {
+			s.ctx.Debug.Printf("s3://%s/%s changed since it was loaded, merging (attempt %d/%d)", s.bucket, s.key, attempt, maxSaveAttempts)
+
+			if err := s.load(s.ctx); err != nil {
+				return fmt.Errorf("reloading after conflict: %s", err)
+			}
+			// Like the other backends, a result another scanner checked more recently is kept.
+			for key, info := range s.pending.all() {
+				s.data.setIfNewer(key, info)
+			}
+			for key, info := range s.deleted.all() {
+				s.data.deleteIfNotNewer(key, info)
+			}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("putting s3://%s/%s: %w", s.bucket, s.key, err)
+		}
+
+		s.etag = resp.ETag
+		s.pending = results{}
+		s.deleted = results{}
+		return nil
+	}
+
+	return fmt.Errorf("putting s3://%s/%s: gave up after %d conflicting writes", s.bucket, s.key, maxSaveAttempts)
+}
+
+func (s *S3Storage) Metadata() (Metadata, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	md, _, err := s.getMetadata()
+	return md, err
+}
+
+// UpdateMetadata updates s3://<bucket>/<prefix>/<name>.meta.json, retrying if another writer updates it first.
+func (s *S3Storage) UpdateMetadata(update func(md *Metadata) error) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for attempt := 1; attempt <= maxSaveAttempts; attempt++ {
+		md, etag, err := s.getMetadata()
+		if err != nil {
+			return err
+		}
+		if err := update(&md); err != nil {
+			return err
+		}
+
+		data, err := s.codec.MarshalMetadata(md)
+		if err != nil {
+			return err
+		}
+
+		input := &s3.PutObjectInput{
+			Bucket: &s.bucket,
+			Key:    &s.metaKey,
+			Body:   bytes.NewReader(data),
+		}
+		if etag != nil {
+			input.IfMatch = etag
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+
+		if _, err := s.client.PutObject(s.ctx, input); s3WriteConflict(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("putting s3://%s/%s: %w", s.bucket, s.metaKey, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("putting s3://%s/%s: gave up after %d conflicting writes", s.bucket, s.metaKey, maxSaveAttempts)
+}
+
+// getMetadata reads the metadata object and its ETag, a missing object is empty metadata.
+func (s *S3Storage) getMetadata() (Metadata, *string, error) {
+	resp, err := s.client.GetObject(s.ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &s.metaKey,
+	})
+
+	var noSuchKey *s3Types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return Metadata{}, nil, nil
+	} else if err != nil {
+		return Metadata{}, nil, fmt.Errorf("getting s3://%s/%s: %w", s.bucket, s.metaKey, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("reading s3://%s/%s: %w", s.bucket, s.metaKey, err)
+	}
+
+	md, err := s.codec.UnmarshalMetadata(body)
+	return md, resp.ETag, err
+}
+
+func (s *S3Storage) Close() error {
+	return nil
+}
+
+// s3WriteConflict checks if a conditional write failed because another writer updated the object first.
+func s3WriteConflict(err error) bool {
+	var oe smithy.APIError
+	return errors.As(err, &oe) && (oe.ErrorCode() == "PreconditionFailed" || oe.ErrorCode() == "ConditionalRequestConflict")
+}
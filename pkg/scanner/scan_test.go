@@ -1,8 +1,10 @@
 package scanner
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 
@@ -64,7 +66,7 @@ func TestScanWithPlugins_RetriesErroredArn(t *testing.T) {
 		},
 	}
 
-	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, arns, unlimitedBucket())
+	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, arns, unlimitedBucket(), nil)
 
 	got := map[string]bool{}
 	for r := range results {
@@ -102,7 +104,7 @@ func TestScanWithPlugins_PersistentErrorsAreNotEmitted(t *testing.T) {
 		},
 	}
 
-	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, []string{arn}, unlimitedBucket())
+	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, []string{arn}, unlimitedBucket(), nil)
 
 	got := []Result{}
 	for r := range results {
@@ -113,6 +115,45 @@ func TestScanWithPlugins_PersistentErrorsAreNotEmitted(t *testing.T) {
 	assert.Empty(t, got, "persistent errors should not emit a false-negative result")
 }
 
+// TestScanWithPlugins_CredentialsErrorsLoggedOnce verifies that once a plugin's
+// credentials stop working only the first credentials error is logged instead
+// of one per ARN, and that the ARNs are still retried.
+func TestScanWithPlugins_CredentialsErrorsLoggedOnce(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	var logs bytes.Buffer
+	ctx.SetLoggingLevel(utils.ErrorLogLevel)
+	ctx.SetLogOutput(&logs)
+
+	arns := []string{
+		"arn:aws:iam::111111111111:role/RoleA",
+		"arn:aws:iam::111111111111:role/RoleB",
+		"arn:aws:iam::111111111111:role/RoleC",
+	}
+
+	calls := 0
+	plugin := &mockPlugin{
+		name: "test-plugin",
+		scanFunc: func(arn string) (bool, error) {
+			calls++
+			if calls <= len(arns) {
+				return false, &utils.CredentialsRefreshError{RoleArn: "arn:aws:iam::222222222222:role/Scanner", Err: fmt.Errorf("ExpiredToken")}
+			}
+			return true, nil
+		},
+	}
+
+	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, arns, unlimitedBucket(), nil)
+
+	got := map[string]bool{}
+	for r := range results {
+		got[r.Arn] = r.Exists
+	}
+
+	assert.Len(t, got, len(arns), "ARNs failing on credentials are retried")
+	assert.Equal(t, 1, strings.Count(logs.String(), "\n"), "expected a single error, got:\n%s", logs.String())
+	assert.Contains(t, logs.String(), "credentials stopped working")
+}
+
 // TestScanWithPlugins_DeadlockUnderRetryPressure exercises the scenario where
 // more retries than channel buffer slots are in-flight simultaneously. Previously
 // workers would block on input<-arn with a full buffer, causing a deadlock.
@@ -145,7 +186,7 @@ func TestScanWithPlugins_DeadlockUnderRetryPressure(t *testing.T) {
 		},
 	}
 
-	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, arns, unlimitedBucket())
+	results := scanWithPlugins(ctx, []plugins.Plugin{plugin}, arns, unlimitedBucket(), nil)
 
 	got := map[string]bool{}
 	for r := range results {
@@ -170,7 +211,7 @@ func TestScanWithPlugins_AllResultsReturned(t *testing.T) {
 	p1 := &mockPlugin{name: "plugin-1", scanFunc: func(arn string) (bool, error) { return true, nil }}
 	p2 := &mockPlugin{name: "plugin-2", scanFunc: func(arn string) (bool, error) { return false, nil }}
 
-	results := scanWithPlugins(ctx, []plugins.Plugin{p1, p2}, arns, unlimitedBucket())
+	results := scanWithPlugins(ctx, []plugins.Plugin{p1, p2}, arns, unlimitedBucket(), nil)
 
 	got := map[string]bool{}
 	for r := range results {
@@ -183,3 +224,55 @@ func TestScanWithPlugins_AllResultsReturned(t *testing.T) {
 		assert.True(t, ok, "ARN %q missing from results", arn)
 	}
 }
+
+// federatedPlugin is a mockPlugin that advertises support for SAML providers.
+type federatedPlugin struct {
+	mockPlugin
+}
+
+func (f *federatedPlugin) PrincipalTypes() []string {
+	return []string{plugins.PrincipalRoot, plugins.PrincipalRole, plugins.PrincipalSAMLProvider}
+}
+
+// TestScanByPrincipalType_OnlyUsesSupportingPlugins verifies federation provider ARNs are only sent to plugins that
+// advertise them, and skipped when no plugin does.
+func TestScanByPrincipalType_OnlyUsesSupportingPlugins(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	var mu sync.Mutex
+	scanned := map[string][]string{}
+	scanFunc := func(name string) func(arn string) (bool, error) {
+		return func(arn string) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			scanned[name] = append(scanned[name], arn)
+			return true, nil
+		}
+	}
+
+	basic := &mockPlugin{name: "basic", scanFunc: scanFunc("basic")}
+	federated := &federatedPlugin{mockPlugin{name: "federated", scanFunc: scanFunc("federated")}}
+
+	got := map[string]string{}
+	for r := range scanByPrincipalType(ctx, []plugins.Plugin{basic, federated}, []string{
+		"arn:aws:iam::111111111111:saml-provider/Okta",
+		"arn:aws:iam::111111111111:oidc-provider/token.actions.githubusercontent.com",
+	}, unlimitedBucket(), nil) {
+		got[r.Arn] = r.Plugin
+	}
+
+	assert.Equal(t, map[string]string{"arn:aws:iam::111111111111:saml-provider/Okta": "federated"}, got)
+	assert.Empty(t, scanned["basic"])
+}
+
+func TestSupports(t *testing.T) {
+	basic := &mockPlugin{name: "basic"}
+	federated := &federatedPlugin{mockPlugin{name: "federated"}}
+
+	assert.True(t, plugins.Supports(basic, "arn:aws:iam::111111111111:root"))
+	assert.True(t, plugins.Supports(basic, "arn:aws:iam::111111111111:role/path/Admin"))
+	assert.False(t, plugins.Supports(basic, "arn:aws:iam::111111111111:saml-provider/Okta"))
+	assert.True(t, plugins.Supports(federated, "arn:aws:iam::111111111111:saml-provider/Okta"))
+	assert.False(t, plugins.Supports(federated, "arn:aws:iam::111111111111:oidc-provider/example.com"))
+	assert.False(t, plugins.Supports(federated, "not an arn"))
+}
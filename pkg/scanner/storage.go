@@ -1,12 +1,14 @@
 package scanner
 
 import (
-	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/ryanjarv/roles/pkg/utils"
+	"iter"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -18,17 +20,111 @@ const (
 	PrincipalDoesNotExist
 )
 
-func NewStorage(ctx *utils.Context, name string) (*Storage, error) {
-	dataPath, err := utils.ExpandPath(fmt.Sprintf("~/.roles/%s.json", name))
+// Storage caches scan results so principals aren't rescanned.
+type Storage interface {
+	// Get returns the stored result for key, status is PrincipalUnknown if it hasn't been scanned.
+	Get(key Key) (utils.Info, PrincipalStatus, error)
+	// Set stores the result for key as-is.
+	Set(key Key, info utils.Info)
+	// Delete removes the stored result for key, unless another process has checked it again since it was loaded.
+	Delete(key Key) error
+	// All yields a snapshot of every stored result.
+	All() iter.Seq2[Key, utils.Info]
+	// Metadata returns the latest metadata stored for the scan.
+	Metadata() (Metadata, error)
+	// UpdateMetadata applies update to the latest stored metadata and saves it, update may be called more than once
+	// if another process updates the metadata at the same time.
+	UpdateMetadata(update func(md *Metadata) error) error
+	Save() error
+	Close() error
+}
+
+// NewStorage opens the storage backend for the scan with the given name.
+//
+// The backend is selected by URI scheme: an empty string or file:// uses local JSON files (in ~/.roles by default),
+// dynamodb://table-name uses a DynamoDB table shared between hosts, and s3://bucket/prefix stores one object per scan
+// name in S3. Query parameters on the URI are parsed as StorageOptions.
+func NewStorage(ctx *utils.Context, cfg aws.Config, backend string, name string) (Storage, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	scheme, location, _ := strings.Cut(backend, "://")
+	location, query, _ := strings.Cut(location, "?")
+
+	opts, err := parseStorageOptions(query)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := newBackend(ctx, cfg, scheme, location, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Hash || isHashed(storage) {
+		hashed, err := NewHashedStorage(storage, opts.hashKey)
+		if err != nil {
+			storage.Close()
+			return nil, err
+		}
+		return hashed, nil
+	}
+	return storage, nil
+}
+
+func newBackend(ctx *utils.Context, cfg aws.Config, scheme, location, name string, opts StorageOptions) (Storage, error) {
+	switch scheme {
+	case "", "file":
+		opts.kms = kms.NewFromConfig(cfg)
+		return NewFileStorage(ctx, location, name, opts)
+	case "dynamodb":
+		if location == "" {
+			return nil, fmt.Errorf("dynamodb storage requires a table name: dynamodb://table-name")
+		}
+		if opts.Compression != "" || opts.Encryption != "" {
+			return nil, fmt.Errorf("dynamodb storage does not support compression or encryption")
+		}
+		return NewDynamoDBStorage(ctx, cfg, location, name)
+	case "s3":
+		bucket, prefix, _ := strings.Cut(location, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("s3 storage requires a bucket: s3://bucket/prefix")
+		}
+		opts.kms = kms.NewFromConfig(cfg)
+		return NewS3Storage(ctx, cfg, bucket, prefix, name, opts)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", scheme)
+	}
+}
+
+// NewFileStorage stores results in <dir>/<name>.json grouped by account ID, dir defaults to ~/.roles.
+//
+// Several processes can share the same scan. The file is only locked while it is being read or written, and saving
+// merges this process's results into whatever other processes have saved since it was loaded, the most recent check
+// wins. Results are also appended to a per-process <dir>/<name>.journal.* file as they are set, so a crash before
+// saving doesn't lose them. Compressed and encrypted files are detected when loading.
+func NewFileStorage(ctx *utils.Context, dir string, name string, opts StorageOptions) (*FileStorage, error) {
+	if dir == "" {
+		dir = "~/.roles"
+	}
+
+	dataPath, err := utils.ExpandPath(filepath.Join(dir, name+".json"))
 	if err != nil {
 		return nil, fmt.Errorf("expanding path: %s", err)
 	}
 
-	storage := &Storage{
-		mux:      sync.Mutex{},
-		data:     map[string]bool{},
-		dataPath: dataPath,
-		lockPath: dataPath + ".lock",
+	base := strings.TrimSuffix(dataPath, ".json")
+	storage := &FileStorage{
+		ctx:           ctx,
+		mux:           sync.Mutex{},
+		data:          results{},
+		pending:       results{},
+		dataPath:      dataPath,
+		lockPath:      base + ".lock",
+		journalPrefix: base + ".journal",
+		metadataPath:  base + ".meta.json",
+		codec:         newCodec(ctx, opts),
 	}
 
 	utils.RunOnSigterm(ctx, func(ctx *utils.Context) {
@@ -50,93 +146,313 @@ func NewStorage(ctx *utils.Context, name string) (*Storage, error) {
 	return storage, nil
 }
 
-type Storage struct {
-	mux      sync.Mutex
-	data     map[string]bool
-	dataPath string
-	lockPath string
+type FileStorage struct {
+	ctx  *utils.Context
+	mux  sync.Mutex
+	data results
+	// pending are the results set since the last save, they are merged into the file when saving.
+	pending results
+	// deleted are the results deleted since the last save, they are removed from the file when saving.
+	deleted       results
+	dataPath      string
+	lockPath      string
+	journalPrefix string
+	metadataPath  string
+	journal       *journal
+	codec         *codec
 }
 
-func (s *Storage) Load(ctx *utils.Context) error {
+func (s *FileStorage) Load(ctx *utils.Context) error {
 	if err := os.MkdirAll(filepath.Dir(s.dataPath), 0o700); err != nil {
 		return err
 	}
-	if _, stat := os.Stat(s.dataPath); os.IsNotExist(stat) {
-		if err := os.WriteFile(s.dataPath, []byte("{}"), 0o600); err != nil {
-			return err
-		}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	var data []byte
+	s.mux.Lock()
+	defer s.mux.Unlock()
 
-	// Ensure other processes don't try to read/write the file at the same time
-	if err := s.lockDataFile(ctx); err != nil {
-		return fmt.Errorf("global lock: %s", err)
+	if s.data, err = s.read(); err != nil {
+		return err
 	}
 
-	s.mux.Lock()
-	data, err := os.ReadFile(s.dataPath)
+	// Pick up results other processes haven't saved yet, and recover them from processes that crashed.
+	paths, err := journalPaths(s.journalPrefix)
 	if err != nil {
-		return fmt.Errorf("reading data: %s", err)
+		return fmt.Errorf("listing journals: %s", err)
 	}
 
-	if err := json.Unmarshal(data, &s.data); err != nil {
-		return fmt.Errorf("unmarshalling data: %s", err)
+	var orphans []string
+	for _, path := range paths {
+		entries, orphaned, err := readJournal(path, s.codec)
+		if err != nil {
+			return fmt.Errorf("replaying journal %s: %s", path, err)
+		}
+
+		for key, info := range entries.all() {
+			s.data.setIfNewer(key, info)
+			if orphaned {
+				s.pending.setIfNewer(key, info)
+			}
+		}
+
+		if orphaned {
+			orphans = append(orphans, path)
+			if n := entries.len(); n > 0 {
+				ctx.Info.Printf("recovered %d unsaved results from %s", n, path)
+			}
+		} else {
+			ctx.Debug.Printf("loaded %d unsaved results from running process journal %s", entries.len(), path)
+		}
+	}
+
+	// Save recovered results before removing their journals. A new encryption key is also saved before anything is
+	// journaled with it, so the next run can read the file.
+	newKey := s.codec.encrypted() && (s.codec.key == nil || s.codec.key.mode != s.codec.encryption)
+	if newKey {
+		if err := s.codec.ensureKey(); err != nil {
+			return err
+		}
+	}
+	if newKey || len(orphans) > 0 {
+		if err := s.save(); err != nil {
+			return err
+		}
+	}
+	for _, path := range orphans {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing journal: %s", err)
+		}
+	}
+
+	if s.journal, err = openJournal(s.journalPrefix, s.codec); err != nil {
+		return err
 	}
-	s.mux.Unlock()
 
 	return nil
 }
 
-func (s *Storage) Save() error {
+// lock takes the lock other processes sharing the scan use while reading and writing the file.
+func (s *FileStorage) lock() (func(), error) {
+	file, err := os.OpenFile(s.lockPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file: %s", err)
+	}
+
+	if _, err := lockFile(file, true); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("locking %s: %s", s.lockPath, err)
+	}
+
+	return func() { file.Close() }, nil
+}
+
+// read loads the results currently saved in the file, callers must hold the file lock.
+func (s *FileStorage) read() (results, error) {
+	data, err := os.ReadFile(s.dataPath)
+	if os.IsNotExist(err) {
+		return results{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading data: %s", err)
+	}
+
+	return s.codec.Unmarshal(data)
+}
+
+// Save merges the results set since the last save into the file and truncates the journal.
+//
+// The file is written to a temporary path and renamed into place so a crash mid-write can't corrupt it.
+func (s *FileStorage) Save() error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	data, err := json.MarshalIndent(s.data, "", "  ")
+	unlock, err := s.lock()
 	if err != nil {
-		return fmt.Errorf("marshalling data: %s", err)
+		return err
 	}
+	defer unlock()
+
+	return s.save()
+}
 
-	if err := os.WriteFile(s.dataPath, data, 0o600); err != nil {
+// save writes the results, callers must hold s.mux and the file lock.
+func (s *FileStorage) save() error {
+	saved, err := s.read()
+	if err != nil {
+		return fmt.Errorf("reloading data: %s", err)
+	}
+	for key, info := range s.pending.all() {
+		saved.setIfNewer(key, info)
+	}
+	for key, info := range s.deleted.all() {
+		saved.deleteIfNotNewer(key, info)
+	}
+
+	data, err := s.codec.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.dataPath, data); err != nil {
 		return fmt.Errorf("writing data: %s", err)
 	}
 
+	s.data = saved
+	s.pending = results{}
+	s.deleted = results{}
+
+	if s.journal != nil {
+		if err := s.journal.Truncate(); err != nil {
+			return fmt.Errorf("truncating journal: %s", err)
+		}
+	}
+
 	return nil
 }
 
-func (s *Storage) Set(principalArn string, exists bool) {
+// writeFileAtomic writes data to a temporary file and renames it to path, so readers never see a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStorage) Metadata() (Metadata, error) {
 	s.mux.Lock()
-	s.data[principalArn] = exists
-	s.mux.Unlock()
+	defer s.mux.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer unlock()
+
+	return s.readMetadata()
 }
 
-func (s *Storage) GetStatus(principalArn string) (PrincipalStatus, error) {
+// UpdateMetadata updates <dir>/<name>.meta.json while holding the file lock.
+func (s *FileStorage) UpdateMetadata(update func(md *Metadata) error) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	if exists, ok := s.data[principalArn]; !ok {
-		return PrincipalUnknown, nil
-	} else if exists {
-		return PrincipalExists, nil
-	} else {
-		return PrincipalDoesNotExist, nil
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	md, err := s.readMetadata()
+	if err != nil {
+		return err
+	}
+	if err := update(&md); err != nil {
+		return err
 	}
+
+	data, err := s.codec.MarshalMetadata(md)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.metadataPath, data); err != nil {
+		return fmt.Errorf("writing metadata: %s", err)
+	}
+	return nil
 }
 
-func (s *Storage) lockDataFile(ctx *utils.Context) error {
-	if contents, err := os.ReadFile(s.lockPath); os.IsNotExist(err) {
-		ctx.Debug.Printf("lock file does not exist: %s", s.lockPath)
+// readMetadata reads the metadata file, callers must hold the file lock.
+func (s *FileStorage) readMetadata() (Metadata, error) {
+	data, err := os.ReadFile(s.metadataPath)
+	if os.IsNotExist(err) {
+		return Metadata{}, nil
 	} else if err != nil {
-		// Some other error occurred
-		return fmt.Errorf("reading lock file: %s", err)
-	} else {
-		// Some other process is holding the lock
-		return fmt.Errorf("lock for %s held by %s", s.lockPath, string(contents))
+		return Metadata{}, fmt.Errorf("reading metadata: %s", err)
+	}
+	return s.codec.UnmarshalMetadata(data)
+}
+
+func (s *FileStorage) Set(key Key, info utils.Info) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.data.set(key, info)
+	if s.pending == nil {
+		s.pending = results{}
+	}
+	s.pending.set(key, info)
+
+	if s.journal != nil {
+		if err := s.journal.Append(key.Arn, info); err != nil {
+			s.ctx.Error.Printf("journaling %s: %s", key, err)
+		}
+	}
+}
+
+// Delete removes the result from memory, it is removed from the file on the next save. Deletes aren't journaled, a
+// crash before saving leaves the result in place.
+func (s *FileStorage) Delete(key Key) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	info, status := s.data.get(key)
+	if status == PrincipalUnknown {
+		return nil
+	}
+
+	s.data.delete(key)
+	s.pending.delete(key)
+	if s.deleted == nil {
+		s.deleted = results{}
 	}
+	s.deleted.set(key, info)
+	return nil
+}
+
+func (s *FileStorage) Get(key Key) (utils.Info, PrincipalStatus, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
 
-	return os.WriteFile(s.lockPath, []byte(strconv.Itoa(os.Getpid())), 0o600)
+	info, status := s.data.get(key)
+	return info, status, nil
 }
 
-func (s *Storage) Close() error {
-	return os.Remove(s.lockPath)
+func (s *FileStorage) All() iter.Seq2[Key, utils.Info] {
+	return snapshot(&s.mux, s.data)
+}
+
+// Close removes the journal once everything in it has been saved, otherwise it is left to be recovered by the next
+// process that loads the scan.
+func (s *FileStorage) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+
+	var err error
+	if s.pending.len() == 0 && s.deleted.len() == 0 {
+		err = s.journal.Remove()
+	} else {
+		err = s.journal.Close()
+	}
+	s.journal = nil
+
+	if err != nil {
+		return fmt.Errorf("closing journal: %s", err)
+	}
+	return nil
 }
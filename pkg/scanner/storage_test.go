@@ -0,0 +1,520 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustKey(principalArn string) Key {
+	key, err := NewKey(principalArn)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func mustGroup(flat map[string]utils.Info) results {
+	grouped, err := groupByAccount(flat)
+	if err != nil {
+		panic(err)
+	}
+	return grouped
+}
+
+// mockDynamoDBClient implements the methods used by DynamoDBStorage.
+type mockDynamoDBClient struct {
+	Items       []map[string]types.AttributeValue
+	PutItems    []*dynamodb.PutItemInput
+	DeleteItems []*dynamodb.DeleteItemInput
+
+	PutItemError error
+}
+
+func (m *mockDynamoDBClient) Query(
+	_ context.Context,
+	_ *dynamodb.QueryInput,
+	_ ...func(*dynamodb.Options),
+) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{Items: m.Items}, nil
+}
+
+func (m *mockDynamoDBClient) PutItem(
+	_ context.Context,
+	params *dynamodb.PutItemInput,
+	_ ...func(*dynamodb.Options),
+) (*dynamodb.PutItemOutput, error) {
+	m.PutItems = append(m.PutItems, params)
+	return &dynamodb.PutItemOutput{}, m.PutItemError
+}
+
+func (m *mockDynamoDBClient) DeleteItem(
+	_ context.Context,
+	params *dynamodb.DeleteItemInput,
+	_ ...func(*dynamodb.Options),
+) (*dynamodb.DeleteItemOutput, error) {
+	m.DeleteItems = append(m.DeleteItems, params)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (m *mockDynamoDBClient) GetItem(
+	_ context.Context,
+	_ *dynamodb.GetItemInput,
+	_ ...func(*dynamodb.Options),
+) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+// mockS3Client is an in-memory object store that honors IfMatch/IfNoneMatch like S3 does.
+type mockS3Client struct {
+	Body []byte
+	ETag string
+
+	// BeforePut runs before each PutObject, it can be used to simulate a concurrent writer.
+	BeforePut func(m *mockS3Client)
+	PutCount  int
+}
+
+func (m *mockS3Client) GetObject(
+	_ context.Context,
+	_ *s3.GetObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.GetObjectOutput, error) {
+	if m.ETag == "" {
+		return nil, &s3Types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(m.Body)), ETag: aws.String(m.ETag)}, nil
+}
+
+func (m *mockS3Client) PutObject(
+	_ context.Context,
+	params *s3.PutObjectInput,
+	_ ...func(*s3.Options),
+) (*s3.PutObjectOutput, error) {
+	if m.BeforePut != nil {
+		m.BeforePut(m)
+	}
+	m.PutCount++
+
+	if (params.IfMatch != nil && *params.IfMatch != m.ETag) || (params.IfNoneMatch != nil && m.ETag != "") {
+		return nil, &smithy.GenericAPIError{Code: "PreconditionFailed"}
+	}
+
+	body, _ := io.ReadAll(params.Body)
+	m.Body = body
+	m.ETag = fmt.Sprintf("etag-%d", m.PutCount)
+	return &s3.PutObjectOutput{ETag: aws.String(m.ETag)}, nil
+}
+
+func TestNewStorage_UnknownBackend(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+
+	_, err := NewStorage(ctx, aws.Config{}, "redis://localhost", "default")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown storage backend")
+
+	_, err = NewStorage(ctx, aws.Config{}, "dynamodb://", "default")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a table name")
+}
+
+func TestFileStorage_RoundTrip(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+
+	storage, err := NewStorage(ctx, aws.Config{}, "file://"+dir, "test")
+	require.NoError(t, err)
+
+	checked := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/a"), utils.Info{Exists: true, Plugin: "sns", FirstSeen: checked, LastChecked: checked})
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/b"), utils.Info{Exists: false})
+	require.NoError(t, storage.Save())
+	require.NoError(t, storage.Close())
+
+	storage, err = NewStorage(ctx, aws.Config{}, "file://"+dir, "test")
+	require.NoError(t, err)
+	defer storage.Close()
+
+	for principalArn, want := range map[string]PrincipalStatus{
+		"arn:aws:iam::123456789012:role/a": PrincipalExists,
+		"arn:aws:iam::123456789012:role/b": PrincipalDoesNotExist,
+		"arn:aws:iam::123456789012:role/c": PrincipalUnknown,
+	} {
+		_, got, err := storage.Get(mustKey(principalArn))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, principalArn)
+	}
+
+	info, _, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+	require.NoError(t, err)
+	assert.Equal(t, utils.Info{Exists: true, Plugin: "sns", FirstSeen: checked, LastChecked: checked}, info)
+}
+
+func TestFileStorage_LoadsLegacyFormat(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "legacy.json"), []byte(`{"arn:aws:iam::123456789012:role/a": true}`), 0o600))
+
+	storage, err := NewFileStorage(ctx, dir, "legacy", StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	info, status, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalExists, status)
+	assert.Equal(t, utils.Info{Exists: true}, info)
+}
+
+func TestDynamoDBStorage(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockDynamoDBClient{
+		Items: []map[string]types.AttributeValue{
+			{
+				"name":   &types.AttributeValueMemberS{Value: "test"},
+				"arn":    &types.AttributeValueMemberS{Value: "arn:aws:iam::123456789012:role/a"},
+				"exists": &types.AttributeValueMemberBOOL{Value: true},
+				"plugin": &types.AttributeValueMemberS{Value: "sns"},
+			},
+		},
+	}
+
+	storage := &DynamoDBStorage{ctx: ctx, client: client, table: "roles", name: "test", data: results{}}
+	require.NoError(t, storage.Load(ctx))
+
+	info, status, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalExists, status)
+	assert.Equal(t, "sns", info.Plugin)
+
+	// A concurrent writer storing a newer result isn't an error.
+	client.PutItemError = &types.ConditionalCheckFailedException{Message: aws.String("condition failed")}
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/b"), utils.Info{Exists: false, LastChecked: time.Now()})
+
+	require.Len(t, client.PutItems, 1)
+	assert.NotNil(t, client.PutItems[0].ConditionExpression)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "arn:aws:iam::123456789012:role/b"}, client.PutItems[0].Item["arn"])
+
+	_, status, err = storage.Get(mustKey("arn:aws:iam::123456789012:role/b"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalDoesNotExist, status)
+
+	require.NoError(t, storage.Delete(mustKey("arn:aws:iam::123456789012:role/a")))
+	require.Len(t, client.DeleteItems, 1)
+	assert.NotNil(t, client.DeleteItems[0].ConditionExpression)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "arn:aws:iam::123456789012:role/a"}, client.DeleteItems[0].Key["arn"])
+
+	_, status, err = storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalUnknown, status)
+}
+
+func TestS3Storage_MergesConcurrentWrites(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	client := &mockS3Client{}
+
+	storage := &S3Storage{ctx: ctx, client: client, bucket: "bucket", key: "test.json", pending: results{}, codec: newCodec(ctx, StorageOptions{})}
+	require.NoError(t, storage.Load(ctx))
+
+	// Another host saves a result after we loaded the object.
+	client.BeforePut = func(m *mockS3Client) {
+		m.BeforePut = nil
+		m.Body = []byte(`{
+			"arn:aws:iam::123456789012:role/other": true,
+			"arn:aws:iam::123456789012:role/both": {"exists": true, "last_checked": "2024-01-01T01:00:00Z"}
+		}`)
+		m.ETag = "other-writer"
+	}
+
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/ours"), utils.Info{Exists: false})
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/both"), utils.Info{Exists: false, LastChecked: older})
+	require.NoError(t, storage.Save())
+	assert.Equal(t, 2, client.PutCount, "expected the first conditional write to fail and be retried")
+
+	// The other host's check of role/both is newer, so it's kept over ours.
+	reloaded := &S3Storage{ctx: ctx, client: client, bucket: "bucket", key: "test.json", pending: results{}, codec: newCodec(ctx, StorageOptions{})}
+	require.NoError(t, reloaded.Load(ctx))
+	assert.Equal(t, mustGroup(map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/other": {Exists: true},
+		"arn:aws:iam::123456789012:role/ours":  {Exists: false},
+		"arn:aws:iam::123456789012:role/both":  {Exists: true, LastChecked: older.Add(time.Hour)},
+	}), reloaded.data)
+}
+
+func TestFileStorage_RecoversJournalAfterCrash(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+
+	storage, err := NewFileStorage(ctx, dir, "crash", StorageOptions{})
+	require.NoError(t, err)
+
+	// Simulate a crash: results are set but never saved, and the last journal write is torn.
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/a"), utils.Info{Exists: true})
+	storage.Set(mustKey("arn:aws:iam::123456789012:role/b"), utils.Info{Exists: false})
+	_, err = storage.journal.file.WriteString(`{"arn":"arn:aws:iam::123456789012:role/c","inf`)
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	storage, err = NewFileStorage(ctx, dir, "crash", StorageOptions{})
+	require.NoError(t, err)
+
+	_, status, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalExists, status)
+	_, status, err = storage.Get(mustKey("arn:aws:iam::123456789012:role/b"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalDoesNotExist, status)
+	_, status, err = storage.Get(mustKey("arn:aws:iam::123456789012:role/c"))
+	require.NoError(t, err)
+	assert.Equal(t, PrincipalUnknown, status)
+
+	// The recovered journal is saved into the main file and removed, and closing after a save removes ours.
+	require.NoError(t, storage.Close())
+
+	journals, err := filepath.Glob(filepath.Join(dir, "crash.journal*"))
+	require.NoError(t, err)
+	assert.Empty(t, journals)
+}
+
+func TestDynamoDBItem_RoundTrip(t *testing.T) {
+	checked := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	info := utils.Info{
+		Exists:      true,
+		Comment:     "a",
+		Plugin:      "sns",
+		FirstSeen:   checked,
+		LastChecked: checked,
+		History:     []utils.StatusChange{{Exists: false, FirstSeen: checked.AddDate(0, -1, 0), LastChecked: checked.AddDate(0, 0, -1)}},
+		Tags:        []string{"ci", "vendor"},
+		Likelihood:  0.25,
+	}
+
+	assert.Equal(t, info, dynamoDBInfo(dynamoDBItem("test", mustKey("arn:aws:iam::123456789012:role/a"), info)))
+}
+
+func TestFileStorage_Compression(t *testing.T) {
+	checked := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := utils.Info{Exists: true, Plugin: "sns", FirstSeen: checked, LastChecked: checked}
+
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			ctx := utils.NewContext(context.Background())
+			dir := t.TempDir()
+
+			storage, err := NewStorage(ctx, aws.Config{}, "file://"+dir+"?compression="+compression, "test")
+			require.NoError(t, err)
+			storage.Set(mustKey("arn:aws:iam::123456789012:role/a"), want)
+			require.NoError(t, storage.Save())
+			require.NoError(t, storage.Close())
+
+			data, err := os.ReadFile(filepath.Join(dir, "test.json"))
+			require.NoError(t, err)
+			assert.Equal(t, compression, detectCompression(data))
+
+			// The compression is detected on load and kept when saving without the option.
+			storage, err = NewStorage(ctx, aws.Config{}, "file://"+dir, "test")
+			require.NoError(t, err)
+			storage.Set(mustKey("arn:aws:iam::123456789012:role/b"), utils.Info{Exists: false})
+			require.NoError(t, storage.Save())
+
+			info, status, err := storage.Get(mustKey("arn:aws:iam::123456789012:role/a"))
+			require.NoError(t, err)
+			assert.Equal(t, PrincipalExists, status)
+			assert.Equal(t, want, info)
+			require.NoError(t, storage.Close())
+
+			data, err = os.ReadFile(filepath.Join(dir, "test.json"))
+			require.NoError(t, err)
+			assert.Equal(t, compression, detectCompression(data))
+		})
+	}
+
+	_, err := NewStorage(utils.NewContext(context.Background()), aws.Config{}, "file://"+t.TempDir()+"?compression=lz4", "test")
+	assert.Error(t, err)
+}
+
+// mockKMSClient hands out a single data key, the "encrypted" key blob is the key ID it was generated for.
+type mockKMSClient struct {
+	Key []byte
+}
+
+func (m *mockKMSClient) GenerateDataKey(
+	_ context.Context,
+	params *kms.GenerateDataKeyInput,
+	_ ...func(*kms.Options),
+) (*kms.GenerateDataKeyOutput, error) {
+	return &kms.GenerateDataKeyOutput{Plaintext: m.Key, CiphertextBlob: []byte(*params.KeyId), KeyId: params.KeyId}, nil
+}
+
+func (m *mockKMSClient) Decrypt(
+	_ context.Context,
+	params *kms.DecryptInput,
+	_ ...func(*kms.Options),
+) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: m.Key, KeyId: aws.String(string(params.CiphertextBlob))}, nil
+}
+
+func TestFileStorage_Encryption(t *testing.T) {
+	passphrase := func(p string) func() (string, error) {
+		return func() (string, error) { return p, nil }
+	}
+
+	tests := []struct {
+		name string
+		opts StorageOptions
+		// reopen is used to load the file again, without the encryption options.
+		reopen StorageOptions
+	}{
+		{
+			name:   "passphrase",
+			opts:   StorageOptions{Encryption: EncryptionPassphrase, passphrase: passphrase("hunter2")},
+			reopen: StorageOptions{passphrase: passphrase("hunter2")},
+		},
+		{
+			name:   "kms",
+			opts:   StorageOptions{Encryption: EncryptionKMS, KMSKeyID: "alias/roles", kms: &mockKMSClient{Key: bytes.Repeat([]byte{1}, 32)}},
+			reopen: StorageOptions{kms: &mockKMSClient{Key: bytes.Repeat([]byte{1}, 32)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := utils.NewContext(context.Background())
+			dir := t.TempDir()
+
+			storage, err := NewFileStorage(ctx, dir, "test", tt.opts)
+			require.NoError(t, err)
+			storage.Set(mustKey("arn:aws:iam::123456789012:role/saved"), utils.Info{Exists: true})
+			require.NoError(t, storage.Save())
+
+			// Simulate a crash after another result was journaled.
+			storage.Set(mustKey("arn:aws:iam::123456789012:role/journaled"), utils.Info{Exists: true})
+			journalPath := storage.journal.path
+			require.NoError(t, storage.Close())
+
+			for _, path := range []string{storage.dataPath, journalPath} {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				assert.NotContains(t, string(data), "role/", "%s should be encrypted", path)
+			}
+
+			storage, err = NewFileStorage(ctx, dir, "test", tt.reopen)
+			require.NoError(t, err)
+			for _, principalArn := range []string{"arn:aws:iam::123456789012:role/saved", "arn:aws:iam::123456789012:role/journaled"} {
+				_, status, err := storage.Get(mustKey(principalArn))
+				require.NoError(t, err)
+				assert.Equal(t, PrincipalExists, status, principalArn)
+			}
+			require.NoError(t, storage.Save())
+			require.NoError(t, storage.Close())
+
+			data, err := os.ReadFile(filepath.Join(dir, "test.json"))
+			require.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(data, encryptionMagic), "expected the encryption to be kept")
+		})
+	}
+}
+
+func TestFileStorage_WrongPassphrase(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+
+	storage, err := NewFileStorage(ctx, dir, "test", StorageOptions{
+		Encryption: EncryptionPassphrase,
+		passphrase: func() (string, error) { return "hunter2", nil },
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Save())
+	require.NoError(t, storage.Close())
+
+	_, err = NewFileStorage(ctx, dir, "test", StorageOptions{
+		passphrase: func() (string, error) { return "hunter3", nil },
+	})
+	assert.ErrorContains(t, err, "wrong passphrase")
+}
+
+func TestFileStorage_SharedBetweenProcesses(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	// Each storage stands in for a separate process, file locks are per open file so they conflict the same way.
+	a, err := NewFileStorage(ctx, dir, "shared", StorageOptions{})
+	require.NoError(t, err)
+	b, err := NewFileStorage(ctx, dir, "shared", StorageOptions{})
+	require.NoError(t, err)
+
+	a.Set(mustKey("arn:aws:iam::123456789012:role/a"), utils.Info{Exists: true, LastChecked: older})
+	b.Set(mustKey("arn:aws:iam::123456789012:role/both"), utils.Info{Exists: true, LastChecked: newer})
+	require.NoError(t, b.Save())
+	a.Set(mustKey("arn:aws:iam::123456789012:role/both"), utils.Info{Exists: false, LastChecked: older})
+	require.NoError(t, a.Save())
+
+	// Results another process hasn't saved yet are visible without taking over its journal.
+	b.Set(mustKey("arn:aws:iam::123456789012:role/unsaved"), utils.Info{Exists: true, LastChecked: newer})
+
+	c, err := NewFileStorage(ctx, dir, "shared", StorageOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, mustGroup(map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/a":       {Exists: true, LastChecked: older},
+		"arn:aws:iam::123456789012:role/both":    {Exists: true, LastChecked: newer},
+		"arn:aws:iam::123456789012:role/unsaved": {Exists: true, LastChecked: newer},
+	}), c.data)
+	assert.FileExists(t, b.journal.path)
+
+	for _, storage := range []*FileStorage{a, b, c} {
+		require.NoError(t, storage.Save())
+		require.NoError(t, storage.Close())
+	}
+}
+
+func TestFileStorage_Delete(t *testing.T) {
+	ctx := utils.NewContext(context.Background())
+	dir := t.TempDir()
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	a, err := NewFileStorage(ctx, dir, "shared", StorageOptions{})
+	require.NoError(t, err)
+	a.Set(mustKey("arn:aws:iam::123456789012:role/deleted"), utils.Info{Exists: false, LastChecked: older})
+	a.Set(mustKey("arn:aws:iam::123456789012:role/rechecked"), utils.Info{Exists: false, LastChecked: older})
+	a.Set(mustKey("arn:aws:iam::210987654321:role/kept"), utils.Info{Exists: true, LastChecked: older})
+	require.NoError(t, a.Save())
+
+	// Another process checks one of the results again before the delete is saved.
+	b, err := NewFileStorage(ctx, dir, "shared", StorageOptions{})
+	require.NoError(t, err)
+	b.Set(mustKey("arn:aws:iam::123456789012:role/rechecked"), utils.Info{Exists: true, LastChecked: newer})
+	require.NoError(t, b.Save())
+	require.NoError(t, b.Close())
+
+	require.NoError(t, a.Delete(mustKey("arn:aws:iam::123456789012:role/deleted")))
+	require.NoError(t, a.Delete(mustKey("arn:aws:iam::123456789012:role/rechecked")))
+	require.NoError(t, a.Delete(mustKey("arn:aws:iam::123456789012:role/unknown")))
+	require.NoError(t, a.Save())
+	require.NoError(t, a.Close())
+
+	c, err := NewFileStorage(ctx, dir, "shared", StorageOptions{})
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, mustGroup(map[string]utils.Info{
+		"arn:aws:iam::123456789012:role/rechecked": {Exists: true, LastChecked: newer},
+		"arn:aws:iam::210987654321:role/kept":      {Exists: true, LastChecked: older},
+	}), c.data)
+}
@@ -1,6 +1,31 @@
 package scanner
 
+import "github.com/ryanjarv/roles/pkg/plugins"
+
 type Result struct {
 	Arn    string
 	Exists bool
+	Plugin string
+}
+
+// wrappedPlugin is embedded by the types that wrap a plugin to change how it scans. The optional interfaces of the
+// plugin it wraps aren't part of the embedded Plugin interface, so they're passed through here for every wrapper
+// rather than being lost when the plugin is wrapped.
+type wrappedPlugin struct {
+	plugins.Plugin
+}
+
+// PrincipalTypes passes through Capabilities, the principal types are used to pick the plugins that scan each ARN.
+func (p wrappedPlugin) PrincipalTypes() []string {
+	return plugins.PrincipalTypes(p.Plugin)
+}
+
+// Location passes through Located, it's used to balance requests across the scanning accounts.
+func (p wrappedPlugin) Location() (string, string) {
+	return plugins.Location(p.Plugin)
+}
+
+// ResourceArns passes through Inventoried.
+func (p wrappedPlugin) ResourceArns() []string {
+	return plugins.ResourceArns(p.Plugin)
 }
@@ -0,0 +1,43 @@
+package scanner
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ryanjarv/roles/pkg/plugins"
+	"github.com/ryanjarv/roles/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inventoriedPlugin validates federation providers, has a location, and knows the ARNs of its resources.
+type inventoriedPlugin struct {
+	federatedPlugin
+	utils.ThreadConfig
+}
+
+func (p *inventoriedPlugin) ResourceArns() []string {
+	return []string{"arn:aws:sns:us-east-1:111111111111:role-fh9283f-topic"}
+}
+
+func TestWrappedPlugin(t *testing.T) {
+	inner := &inventoriedPlugin{
+		federatedPlugin: federatedPlugin{mockPlugin{name: "sns-0"}},
+		ThreadConfig:    utils.ThreadConfig{AccountId: "111111111111", Region: "us-east-1"},
+	}
+	scan := NewScanner(&NewScannerInput{
+		Plugins: [][]plugins.Plugin{{inner}},
+		Monitor: NewMonitor(),
+		Errors:  NewErrorLog(io.Discard),
+	})
+	require.Len(t, scan.Plugins, 1)
+
+	// The optional interfaces of a plugin are kept however many times it's wrapped.
+	plugin := scan.Plugins[0]
+	assert.IsType(t, &monitoredPlugin{}, plugin)
+	assert.Equal(t, plugins.PrincipalTypes(inner), plugins.PrincipalTypes(plugin))
+	accountId, region := plugins.Location(plugin)
+	assert.Equal(t, "111111111111", accountId)
+	assert.Equal(t, "us-east-1", region)
+	assert.Equal(t, inner.ResourceArns(), plugins.ResourceArns(plugin))
+}
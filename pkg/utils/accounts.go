@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -9,9 +10,35 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/organizations"
 	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"regexp"
+	"slices"
 	"sync"
+	"time"
 )
 
+// DefaultAccountRole is the role assumed in role scanning accounts without an AccountRoleTag unless
+// AssumeRoleOptions.RoleName changes it, it's the role Organizations creates in new accounts by default.
+const DefaultAccountRole = "OrganizationAccountAccessRole"
+
+// AccountTagKey and AccountTagValue are the tag of the accounts -setup -org creates, and the tag LoadAccounts selects
+// role scanning accounts with unless AccountSelection changes it.
+const (
+	AccountTagKey   = "role-scanning-account"
+	AccountTagValue = "true"
+)
+
+// AccountRoleTag is the tag on a role scanning account with the name of the role to assume in it, for organizations
+// that vend accounts with a different role.
+const AccountRoleTag = "role-scanning-account-role"
+
+var roleNamePattern = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+// IsValidRoleName reports whether name can be the name of an IAM role.
+func IsValidRoleName(name string) bool {
+	return roleNamePattern.MatchString(name)
+}
+
 type Svc struct {
 	Organizations *organizations.Client
 	STS           *sts.Client
@@ -26,7 +53,11 @@ type Account struct {
 	Svc         Svc
 }
 
-func LoadAccounts(ctx *Context, cfg aws.Config) (map[string]Account, error) {
+// LoadAccounts returns the current account and the role scanning accounts of the organization, or of opts.Accounts if
+// there are any, with the role of each assumed with opts. The accounts can be listed from the management account or
+// from a delegated administrator of Organizations. From a delegated administrator the management account is skipped
+// even if it's selected, so its credentials are never used.
+func LoadAccounts(ctx *Context, cfg aws.Config, opts AssumeRoleOptions) (map[string]Account, error) {
 	svc := organizations.NewFromConfig(cfg)
 
 	info, err := GetCallerInfo(ctx, cfg)
@@ -36,34 +67,56 @@ func LoadAccounts(ctx *Context, cfg aws.Config) (map[string]Account, error) {
 
 	accounts := map[string]Account{
 		// Always add the current account, it won't have tags set and may not be an organization account.
-		"default": {
-			AccountId:   *info.Account,
-			AccountName: "default",
-			Config:      cfg,
-			Svc: Svc{
-				Organizations: organizations.NewFromConfig(cfg),
-				STS:           sts.NewFromConfig(cfg),
-				Account:       account.NewFromConfig(cfg),
-			},
-		},
+		"default": newAccount(cfg, *info.Account, "default", ""),
+	}
+	// The roles of the accounts are assumed from the bastion role if there is one, the organization's accounts are
+	// still listed with the caller's credentials.
+	base, err := bastionConfig(ctx, cfg, opts.BastionRoleArn)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Accounts) != 0 {
+		return addStaticAccounts(ctx, base, accounts, opts), nil
+	}
+
+	var accessDenied *types.AccessDeniedException
+	var notInUse *types.AWSOrganizationsNotInUseException
+	org, err := svc.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if errors.As(err, &notInUse) || errors.As(err, &accessDenied) {
+		ctx.Debug.Printf("Not in an organization, will use non-org mode.")
+		return accounts, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("describing organization: %s", err)
+	}
+	managementId := aws.ToString(org.Organization.MasterAccountId)
+	delegated := managementId != *info.Account
+	if delegated {
+		ctx.Info.Printf("Listing the organization's accounts from %s as a delegated administrator, the management account %s is never scanned from", *info.Account, managementId)
 	}
 
 	paginator := organizations.NewListAccountsPaginator(svc, &organizations.ListAccountsInput{})
 	wg := sync.WaitGroup{}
 	mut := &sync.Mutex{}
-	errs := make(chan error)
+	errs := make(chan error, 1)
 
 	for paginator.HasMorePages() {
-		var accessDenied *types.AccessDeniedException
 		resp, err := paginator.NextPage(ctx)
 		if errors.As(err, &accessDenied) {
-			ctx.Debug.Printf("Access denied listing accounts, will use non-org mode.")
+			if delegated {
+				ctx.Debug.Printf("Access denied listing accounts, will use non-org mode. To list them from %s, register it as a delegated administrator with a policy allowing organizations:ListAccounts and organizations:ListTagsForResource.", *info.Account)
+			} else {
+				ctx.Debug.Printf("Access denied listing accounts, will use non-org mode.")
+			}
 			return accounts, nil
 		} else if err != nil {
 			return nil, fmt.Errorf("listing accounts: %s", err)
 		}
 
 		for _, accnt := range resp.Accounts {
+			if delegated && *accnt.Id == managementId {
+				ctx.Debug.Printf("skipping the management account %s", managementId)
+				continue
+			}
 			wg.Add(1)
 
 			go func() {
@@ -73,27 +126,21 @@ func LoadAccounts(ctx *Context, cfg aws.Config) (map[string]Account, error) {
 					ResourceId: accnt.Id,
 				})
 				if err != nil {
-					errs <- fmt.Errorf("listing tags: %s", err)
+					select {
+					case errs <- fmt.Errorf("listing tags: %s", err):
+					default:
+					}
+					return
 				}
-				if !HasTag(resp.Tags, "role-scanning-account", "true") {
+				if !opts.Selection.Selects(*accnt.Id, resp.Tags) {
 					return
 				}
 
-				roleArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", *accnt.Id, "OrganizationAccountAccessRole")
+				roleArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", *accnt.Id, opts.AccountRole(resp.Tags))
 
-				cfg := AssumeRoleConfig(cfg, roleArn)
+				cfg := AssumeRoleConfig(ctx, base, roleArn, opts)
 				mut.Lock()
-				accounts[*accnt.Id] = Account{
-					RoleArn:     roleArn,
-					AccountId:   *accnt.Id,
-					AccountName: *accnt.Name,
-					Config:      cfg,
-					Svc: Svc{
-						Organizations: organizations.NewFromConfig(cfg),
-						STS:           sts.NewFromConfig(cfg),
-						Account:       account.NewFromConfig(cfg),
-					},
-				}
+				accounts[*accnt.Id] = newAccount(cfg, *accnt.Id, *accnt.Name, roleArn)
 				mut.Unlock()
 
 				ctx.Info.Printf("Found account %s", *accnt.Name)
@@ -109,17 +156,243 @@ func LoadAccounts(ctx *Context, cfg aws.Config) (map[string]Account, error) {
 	return accounts, nil
 }
 
-func AssumeRoleConfig(cfg aws.Config, roleArn string) aws.Config {
+// bastionConfig returns the config the role of each account is assumed with, cfg or a session of roleArn assumed with
+// it. The bastion role is assumed right away so a role that can't be assumed fails before any account is loaded, the
+// accounts' sessions retry refreshing it when it expires.
+func bastionConfig(ctx *Context, cfg aws.Config, roleArn string) (aws.Config, error) {
+	if roleArn == "" {
+		return cfg, nil
+	}
+
+	bastion := cfg.Copy()
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "role-scanner-bastion"
+	})
+	bastion.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = sessionExpiryWindow
+	})
+	if _, err := bastion.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("assuming bastion role %s: %s", roleArn, err)
+	}
+	ctx.Info.Printf("Assuming the role of each account from the bastion role %s", roleArn)
+	return bastion, nil
+}
+
+// StaticAccount is a role scanning account from a scanning accounts file, for users that can't list the accounts of
+// their organization or don't use Organizations.
+type StaticAccount struct {
+	AccountId string `yaml:"account_id"`
+	// Name is the name logged for the account, the account ID if it's empty.
+	Name    string `yaml:"name"`
+	RoleArn string `yaml:"role_arn"`
+	// ExternalID is the sts:ExternalId of the role, AssumeRoleOptions.ExternalID is used if it's empty.
+	ExternalID string `yaml:"external_id"`
+}
+
+// addStaticAccounts adds opts.Accounts to accounts, assuming each account's role with opts, and returns accounts. An
+// entry for the current account is skipped, it's already the default account.
+func addStaticAccounts(ctx *Context, cfg aws.Config, accounts map[string]Account, opts AssumeRoleOptions) map[string]Account {
+	for _, s := range opts.Accounts {
+		if s.AccountId == accounts["default"].AccountId {
+			ctx.Debug.Printf("skipping scanning account %s, it's the current account", s.AccountId)
+			continue
+		}
+
+		accountOpts := opts
+		if s.ExternalID != "" {
+			accountOpts.ExternalID = s.ExternalID
+		}
+		name := s.Name
+		if name == "" {
+			name = s.AccountId
+		}
+		accounts[s.AccountId] = newAccount(AssumeRoleConfig(ctx, cfg, s.RoleArn, accountOpts), s.AccountId, name, s.RoleArn)
+		ctx.Info.Printf("Found account %s", name)
+	}
+	return accounts
+}
+
+func newAccount(cfg aws.Config, accountId string, name string, roleArn string) Account {
+	return Account{
+		RoleArn:     roleArn,
+		AccountId:   accountId,
+		AccountName: name,
+		Config:      cfg,
+		Svc: Svc{
+			Organizations: organizations.NewFromConfig(cfg),
+			STS:           sts.NewFromConfig(cfg),
+			Account:       account.NewFromConfig(cfg),
+		},
+	}
+}
+
+// AssumeRoleOptions are the options for assuming the role in each role scanning account.
+type AssumeRoleOptions struct {
+	// ExternalID is the sts:ExternalId the role's trust policy requires, if any.
+	ExternalID string
+	// Duration is how long the role's credentials last, the SDK default of 15 minutes if zero.
+	Duration time.Duration
+	// Policy is a session policy document the role's permissions are limited to, if it isn't empty.
+	Policy string
+	// Accounts are the role scanning accounts of a scanning accounts file, LoadAccounts uses them instead of the tagged
+	// accounts of the organization if there are any.
+	Accounts []StaticAccount
+	// Selection is which accounts of the organization LoadAccounts uses.
+	Selection AccountSelection
+	// RoleName is the role assumed in the accounts without an AccountRoleTag and the scanning accounts file entries
+	// without a role ARN, DefaultAccountRole if it's empty. The role Organizations creates only trusts the management
+	// account, so discovery from a delegated administrator needs a role that trusts it instead.
+	RoleName string
+	// BastionRoleArn is a role assumed first, the role of each account is assumed from its session instead of from
+	// the caller's credentials if it isn't empty.
+	BastionRoleArn string
+}
+
+// AccountRole returns the name of the role to assume in an account of the organization with tags.
+func (o AssumeRoleOptions) AccountRole(tags []types.Tag) string {
+	if name, ok := GetTag(tags, AccountRoleTag); ok && name != "" {
+		return name
+	}
+	if o.RoleName != "" {
+		return o.RoleName
+	}
+	return DefaultAccountRole
+}
+
+// AccountSelection selects the role scanning accounts of an organization. Accounts are selected by their ID if
+// AccountIds isn't empty, otherwise by having the tag TagKey with the value TagValue, AccountTagKey and AccountTagValue
+// if TagKey is empty.
+type AccountSelection struct {
+	TagKey     string
+	TagValue   string
+	AccountIds []string
+}
+
+// Selects reports whether the account with accountId and tags is a role scanning account.
+func (s AccountSelection) Selects(accountId string, tags []types.Tag) bool {
+	if len(s.AccountIds) != 0 {
+		return slices.Contains(s.AccountIds, accountId)
+	}
+	if s.TagKey == "" {
+		return HasTag(tags, AccountTagKey, AccountTagValue)
+	}
+	return HasTag(tags, s.TagKey, s.TagValue)
+}
+
+// IsDefault reports whether s selects the accounts -setup -org creates.
+func (s AccountSelection) IsDefault() bool {
+	return len(s.AccountIds) == 0 && (s.TagKey == "" || s.TagKey == AccountTagKey && s.TagValue == AccountTagValue)
+}
+
+// Assumed role sessions are refreshed sessionExpiryWindow before they expire, with up to half of that as jitter so the
+// sessions of every account aren't refreshed at once. A refresh that fails is retried refreshAttempts times,
+// refreshRetryDelay apart, before the requests waiting on it fail.
+const (
+	sessionExpiryWindow = 5 * time.Minute
+	refreshAttempts     = 3
+	refreshRetryDelay   = 30 * time.Second
+)
+
+// AssumeRoleConfig returns a copy of cfg that assumes roleArn with opts, the credentials are refreshed before they
+// expire so scans can outlast the session duration, failing refreshes are logged to ctx.Error and retried.
+func AssumeRoleConfig(ctx *Context, cfg aws.Config, roleArn string, opts AssumeRoleOptions) aws.Config {
 	newCfg := cfg.Copy()
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "role-scanner"
+		if opts.ExternalID != "" {
+			o.ExternalID = aws.String(opts.ExternalID)
+		}
+		if opts.Duration != 0 {
+			o.Duration = opts.Duration
+		}
+		if opts.Policy != "" {
+			o.Policy = aws.String(opts.Policy)
+		}
+	})
 	newCfg.Credentials = aws.NewCredentialsCache(
-		stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
-			o.RoleSessionName = "role-scanner"
-			//o.Duration = 3600
-		}),
+		&refreshingProvider{ctx: ctx, roleArn: roleArn, provider: provider, attempts: refreshAttempts, delay: refreshRetryDelay},
+		func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = sessionExpiryWindow
+			o.ExpiryWindowJitterFrac = 0.5
+		},
 	)
 	return newCfg
 }
 
+// CredentialsRefreshError is returned by requests made with the config from AssumeRoleConfig when the session of the
+// role couldn't be refreshed.
+type CredentialsRefreshError struct {
+	RoleArn string
+	Err     error
+}
+
+func (e *CredentialsRefreshError) Error() string {
+	return fmt.Sprintf("refreshing credentials for %s: %s", e.RoleArn, e.Err)
+}
+
+func (e *CredentialsRefreshError) Unwrap() error {
+	return e.Err
+}
+
+// expiredCredentialsCodes are the error codes AWS returns for requests signed with expired or invalid credentials.
+var expiredCredentialsCodes = []string{"ExpiredToken", "ExpiredTokenException", "RequestExpired", "InvalidClientTokenId"}
+
+// IsCredentialsError reports whether err is from credentials that expired or couldn't be refreshed, including an
+// expired SSO session, rather than from the request itself, retrying these right away fails the same way.
+func IsCredentialsError(err error) bool {
+	var refreshErr *CredentialsRefreshError
+	var ssoErr *SSOSessionError
+	if errors.As(err, &refreshErr) || errors.As(err, &ssoErr) {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && slices.Contains(expiredCredentialsCodes, apiErr.ErrorCode())
+}
+
+// refreshingProvider retries failing refreshes of the session of roleArn. The credentials cache only calls Retrieve
+// once at a time, requests made while it's retrying wait for it, so a failing refresh is logged once per attempt
+// instead of once per request.
+type refreshingProvider struct {
+	ctx      *Context
+	roleArn  string
+	provider aws.CredentialsProvider
+	attempts int
+	delay    time.Duration
+}
+
+func (p *refreshingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var creds aws.Credentials
+		if creds, err = p.provider.Retrieve(ctx); err == nil {
+			if attempt > 1 {
+				p.ctx.Info.Printf("refreshed credentials for %s", p.roleArn)
+			}
+			return creds, nil
+		}
+		if attempt >= p.attempts || ctx.Err() != nil {
+			break
+		}
+		p.ctx.Error.Printf("refreshing credentials for %s: %s (retrying in %s, %d/%d)", p.roleArn, err, p.delay, attempt+1, p.attempts)
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.delay):
+		}
+	}
+	p.ctx.Error.Printf("refreshing credentials for %s: %s (giving up after %d attempts, requests using it fail until a refresh succeeds)", p.roleArn, err, p.attempts)
+	return aws.Credentials{}, &CredentialsRefreshError{RoleArn: p.roleArn, Err: err}
+}
+
+// GetTag returns the value of the tag with key.
+func GetTag(tags []types.Tag, key string) (string, bool) {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key {
+			return aws.ToString(tag.Value), true
+		}
+	}
+	return "", false
+}
+
 func HasTag(tags []types.Tag, key string, value string) bool {
 	for _, tag := range tags {
 		if *tag.Key == key && *tag.Value == value {
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTag(t *testing.T) {
+	tags := []types.Tag{
+		{Key: aws.String("role-scanning-account"), Value: aws.String("true")},
+		{Key: aws.String(AccountRoleTag), Value: aws.String("AWSControlTowerExecution")},
+	}
+
+	value, ok := GetTag(tags, AccountRoleTag)
+	assert.True(t, ok)
+	assert.Equal(t, "AWSControlTowerExecution", value)
+
+	_, ok = GetTag(tags, "role-scanning-account-number")
+	assert.False(t, ok)
+}
+
+func TestAssumeRoleOptions_AccountRole(t *testing.T) {
+	tagged := []types.Tag{{Key: aws.String(AccountRoleTag), Value: aws.String("AWSControlTowerExecution")}}
+
+	assert.Equal(t, DefaultAccountRole, AssumeRoleOptions{}.AccountRole(nil))
+	assert.Equal(t, "RoleScanning", AssumeRoleOptions{RoleName: "RoleScanning"}.AccountRole(nil))
+	assert.Equal(t, "AWSControlTowerExecution", AssumeRoleOptions{RoleName: "RoleScanning"}.AccountRole(tagged))
+}
+
+func TestIsValidRoleName(t *testing.T) {
+	for _, name := range []string{DefaultAccountRole, "AWSControlTowerExecution", "vend+acct=1,2.3@x-y_z"} {
+		assert.True(t, IsValidRoleName(name), name)
+	}
+	for _, name := range []string{"", "role/Admin", "has space", strings.Repeat("a", 65)} {
+		assert.False(t, IsValidRoleName(name), name)
+	}
+}
+
+// flakyProvider fails the first failures calls to Retrieve.
+type flakyProvider struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return aws.Credentials{}, errors.New("ExpiredToken: The security token included in the request is expired")
+	}
+	return aws.Credentials{AccessKeyID: "ASIAROLE", SecretAccessKey: "secret"}, nil
+}
+
+func TestRefreshingProvider(t *testing.T) {
+	ctx := NewContext(context.Background())
+	var logs bytes.Buffer
+	ctx.SetLoggingLevel(ErrorLogLevel)
+	ctx.SetLogOutput(&logs)
+	roleArn := "arn:aws:iam::111111111111:role/OrganizationAccountAccessRole"
+
+	inner := &flakyProvider{failures: 2}
+	p := &refreshingProvider{ctx: ctx, roleArn: roleArn, provider: inner, attempts: 3, delay: time.Millisecond}
+	creds, err := p.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ASIAROLE", creds.AccessKeyID)
+	assert.Equal(t, 3, inner.calls)
+	assert.Equal(t, 2, strings.Count(logs.String(), "retrying in"))
+
+	inner = &flakyProvider{failures: 3}
+	p = &refreshingProvider{ctx: ctx, roleArn: roleArn, provider: inner, attempts: 3, delay: time.Millisecond}
+	_, err = p.Retrieve(ctx)
+	var refreshErr *CredentialsRefreshError
+	require.ErrorAs(t, err, &refreshErr)
+	assert.Equal(t, roleArn, refreshErr.RoleArn)
+	assert.Contains(t, logs.String(), "giving up after 3 attempts")
+
+	// Errors from the credentials cache wrap the refresh error.
+	assert.True(t, IsCredentialsError(fmt.Errorf("failed to refresh cached credentials, %w", err)))
+}
+
+func TestIsCredentialsError(t *testing.T) {
+	assert.True(t, IsCredentialsError(fmt.Errorf("publishing: %w", &smithy.GenericAPIError{Code: "ExpiredToken"})))
+	assert.True(t, IsCredentialsError(&smithy.GenericAPIError{Code: "InvalidClientTokenId"}))
+	assert.False(t, IsCredentialsError(&smithy.GenericAPIError{Code: "AccessDenied"}))
+	assert.False(t, IsCredentialsError(errors.New("ExpiredToken")))
+}
+
+func TestAddStaticAccounts(t *testing.T) {
+	ctx := NewContext(context.Background())
+	cfg := aws.Config{Region: "us-east-1"}
+
+	accounts := addStaticAccounts(ctx, cfg, map[string]Account{"default": newAccount(cfg, "111111111111", "default", "")}, AssumeRoleOptions{Accounts: []StaticAccount{
+		{AccountId: "111111111111", RoleArn: "arn:aws:iam::111111111111:role/scanning"},
+		{AccountId: "222222222222", RoleArn: "arn:aws:iam::222222222222:role/scanning", ExternalID: "engagement-1234", Name: "scanning-2"},
+		{AccountId: "333333333333", RoleArn: "arn:aws:iam::333333333333:role/scanning"},
+	}})
+
+	// The current account is only scanned with its own credentials.
+	require.Len(t, accounts, 3)
+	assert.Equal(t, "", accounts["default"].RoleArn)
+	assert.Equal(t, "arn:aws:iam::222222222222:role/scanning", accounts["222222222222"].RoleArn)
+	assert.Equal(t, "scanning-2", accounts["222222222222"].AccountName)
+	assert.Equal(t, "333333333333", accounts["333333333333"].AccountName, "named after the account ID without a name")
+	assert.NotNil(t, accounts["333333333333"].Config.Credentials)
+	assert.NotNil(t, accounts["333333333333"].Svc.STS)
+}
+
+func TestAccountSelection(t *testing.T) {
+	tagged := []types.Tag{{Key: aws.String(AccountTagKey), Value: aws.String(AccountTagValue)}}
+	sandbox := []types.Tag{{Key: aws.String("environment"), Value: aws.String("sandbox")}}
+
+	var selection AccountSelection
+	assert.True(t, selection.IsDefault())
+	assert.True(t, selection.Selects("111111111111", tagged))
+	assert.False(t, selection.Selects("111111111111", sandbox))
+
+	selection = AccountSelection{TagKey: "environment", TagValue: "sandbox"}
+	assert.False(t, selection.IsDefault())
+	assert.False(t, selection.Selects("111111111111", tagged))
+	assert.True(t, selection.Selects("111111111111", sandbox))
+
+	// Listed accounts are selected whatever their tags are.
+	selection = AccountSelection{AccountIds: []string{"222222222222"}}
+	assert.False(t, selection.IsDefault())
+	assert.False(t, selection.Selects("111111111111", tagged))
+	assert.True(t, selection.Selects("222222222222", nil))
+
+	assert.True(t, AccountSelection{TagKey: AccountTagKey, TagValue: AccountTagValue}.IsDefault())
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/account"
 	"github.com/aws/aws-sdk-go-v2/service/account/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -17,7 +18,36 @@ type ThreadConfig struct {
 	Region    string
 }
 
-func LoadConfigs(ctx *Context, accounts map[string]Account) (map[string]ThreadConfig, error) {
+// Location returns the scanning account and region of the config, plugins embedding a ThreadConfig make their calls
+// there.
+func (c ThreadConfig) Location() (accountId string, region string) {
+	return c.AccountId, c.Region
+}
+
+// RegionSelection is which regions of each scanning account setup and scans use, every enabled region if it's the zero
+// value.
+type RegionSelection struct {
+	// Regions are the only regions used if it isn't empty.
+	Regions []string
+	// DefaultOnly only uses the regions enabled by default, opt-in regions aren't used or enabled.
+	DefaultOnly bool
+}
+
+// All reports whether s selects every region.
+func (s RegionSelection) All() bool {
+	return len(s.Regions) == 0 && !s.DefaultOnly
+}
+
+// Selects reports whether region is one of the selected regions.
+func (s RegionSelection) Selects(region types.Region) bool {
+	if s.DefaultOnly && region.RegionOptStatus != types.RegionOptStatusEnabledByDefault {
+		return false
+	}
+	return len(s.Regions) == 0 || slices.Contains(s.Regions, aws.ToString(region.RegionName))
+}
+
+// LoadConfigs returns a config for each enabled region of accounts that selection selects, by account ID and region.
+func LoadConfigs(ctx *Context, accounts map[string]Account, selection RegionSelection) (map[string]ThreadConfig, error) {
 	cfgs := map[string]ThreadConfig{}
 	m := &sync.Mutex{}
 
@@ -35,6 +65,7 @@ func LoadConfigs(ctx *Context, accounts map[string]Account) (map[string]ThreadCo
 				return
 			}
 
+			regions = slices.DeleteFunc(regions, func(region types.Region) bool { return !selection.Selects(region) })
 			for _, region := range regions {
 				newCfg := v.Config.Copy()
 				newCfg.Region = *region.RegionName
@@ -80,6 +111,25 @@ func GetAllEnabledRegions(ctx *Context, svc *account.Client) ([]types.Region, er
 	return regions, nil
 }
 
+// GetDisabledRegions returns the regions that aren't enabled, which EnableAllRegions opts in to.
+func GetDisabledRegions(ctx *Context, svc *account.Client) ([]types.Region, error) {
+	var regions []types.Region
+	paginator := account.NewListRegionsPaginator(svc, &account.ListRegionsInput{
+		MaxResults: aws.Int32(50),
+		RegionOptStatusContains: []types.RegionOptStatus{
+			types.RegionOptStatusDisabled,
+		},
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("describing regions: %s", err)
+		}
+		regions = append(regions, resp.Regions...)
+	}
+	return regions, nil
+}
+
 func GetCallerInfo(ctx *Context, cfg aws.Config) (*sts.GetCallerIdentityOutput, error) {
 	resp, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
@@ -88,12 +138,14 @@ func GetCallerInfo(ctx *Context, cfg aws.Config) (*sts.GetCallerIdentityOutput,
 	return resp, nil
 }
 
-func EnableAllRegions(ctx *Context, svc *account.Client) error {
+// EnableAllRegions opts in to each disabled region selection selects and waits for them to be enabled.
+func EnableAllRegions(ctx *Context, svc *account.Client, selection RegionSelection) error {
 	paginator := account.NewListRegionsPaginator(svc, &account.ListRegionsInput{
 		RegionOptStatusContains: []types.RegionOptStatus{
 			types.RegionOptStatusDisabled,
 		},
 	})
+	enabling := 0
 	for paginator.HasMorePages() {
 		resp, err := paginator.NextPage(ctx)
 		if err != nil {
@@ -101,6 +153,10 @@ func EnableAllRegions(ctx *Context, svc *account.Client) error {
 		}
 
 		for _, region := range resp.Regions {
+			if !selection.Selects(region) {
+				continue
+			}
+			enabling++
 			ctx.Info.Printf("Opting in to region %s", *region.RegionName)
 			if _, err := svc.EnableRegion(ctx, &account.EnableRegionInput{
 				RegionName: region.RegionName,
@@ -109,6 +165,9 @@ func EnableAllRegions(ctx *Context, svc *account.Client) error {
 			}
 		}
 	}
+	if enabling == 0 {
+		return nil
+	}
 
 	for {
 		time.Sleep(2 * time.Second)
@@ -1,6 +1,12 @@
 package utils
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/account/types"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestGenerateSubAccountEmail(t *testing.T) {
 	type args struct {
@@ -46,3 +52,23 @@ func TestGenerateSubAccountEmail(t *testing.T) {
 		})
 	}
 }
+
+func TestRegionSelection(t *testing.T) {
+	usEast1 := types.Region{RegionName: aws.String("us-east-1"), RegionOptStatus: types.RegionOptStatusEnabledByDefault}
+	afSouth1 := types.Region{RegionName: aws.String("af-south-1"), RegionOptStatus: types.RegionOptStatusEnabled}
+
+	var all RegionSelection
+	assert.True(t, all.All())
+	assert.True(t, all.Selects(usEast1))
+	assert.True(t, all.Selects(afSouth1))
+
+	defaults := RegionSelection{DefaultOnly: true}
+	assert.False(t, defaults.All())
+	assert.True(t, defaults.Selects(usEast1))
+	assert.False(t, defaults.Selects(afSouth1), "opt-in regions aren't used even if they're enabled")
+
+	subset := RegionSelection{Regions: []string{"af-south-1"}}
+	assert.False(t, subset.All())
+	assert.False(t, subset.Selects(usEast1))
+	assert.True(t, subset.Selects(afSouth1))
+}
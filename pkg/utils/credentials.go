@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CredentialsCacheDir is where the sessions of MFA protected profiles are cached, so the MFA token is only asked for
+// once per session instead of on every run.
+var CredentialsCacheDir = "~/.roles/cache"
+
+// mfaSessionDuration is how long the session of an MFA protected profile with a role_arn lasts when the profile doesn't
+// set duration_seconds.
+const mfaSessionDuration = time.Hour
+
+// credentialsExpiryWindow is how long before they expire cached credentials stop being used.
+const credentialsExpiryWindow = 5 * time.Minute
+
+// MFATokenInput is where the MFA token code is read from when a profile needs one, it's asked for on stderr.
+var MFATokenInput io.Reader = os.Stdin
+
+// LoadConfig loads the AWS config of profile like config.LoadDefaultConfig. If the profile has an mfa_serial the MFA
+// token code is asked for on stderr and read from stdin: with a role_arn it's used to assume the role, otherwise it's
+// used to get a session token for the profile's credentials. The session is cached in CredentialsCacheDir until it
+// expires. Credentials from IAM Identity Center, a credential_process, or a web identity token are retrieved right
+// away, so a problem with them is an error from LoadConfig saying where they came from instead of from the first
+// request, an expired SSO session is an SSOSessionError. Without a profile, a GitHub Actions job can assume
+// AWS_ROLE_ARN with its OIDC token, see githubActionsTokenRetriever.
+func LoadConfig(ctx *Context, profile string, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	optFns = append([]func(*config.LoadOptions) error{config.WithSharedConfigProfile(profile)}, optFns...)
+
+	name := profile
+	if name == "" {
+		name = os.Getenv("AWS_PROFILE")
+	}
+	// Like the SDK, a profile that's set takes precedence over credentials in the environment.
+	explicit := name != ""
+	if name == "" {
+		name = "default"
+	}
+	env, err := config.NewEnvConfig()
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("loading environment config: %s", err)
+	}
+	if !explicit && usesGitHubActionsOIDC(env) {
+		return loadGitHubActionsConfig(ctx, env, optFns...)
+	}
+
+	shared, err := config.LoadSharedConfigProfile(ctx, name, func(o *config.LoadSharedConfigOptions) {
+		if env.SharedConfigFile != "" {
+			o.ConfigFiles = []string{env.SharedConfigFile}
+		}
+		if env.SharedCredentialsFile != "" {
+			o.CredentialsFiles = []string{env.SharedCredentialsFile}
+		}
+	})
+	var notExist config.SharedConfigProfileNotExistError
+	if errors.As(err, &notExist) {
+		shared = config.SharedConfig{}
+	} else if err != nil {
+		return aws.Config{}, fmt.Errorf("loading profile %s: %s", name, err)
+	}
+	if shared.MFASerial == "" {
+		return loadCheckedConfig(ctx, name, explicit, shared, env, optFns...)
+	}
+
+	token := mfaTokenProvider(shared.MFASerial)
+	if shared.RoleARN != "" {
+		optFns = append(optFns, config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = token
+			if o.Duration == stscreds.DefaultDuration {
+				o.Duration = mfaSessionDuration
+			}
+		}))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	var provider aws.CredentialsProvider = cfg.Credentials
+	if shared.RoleARN == "" {
+		provider = &sessionTokenProvider{client: sts.NewFromConfig(cfg), serial: shared.MFASerial, token: token}
+	}
+	cfg.Credentials = aws.NewCredentialsCache(&fileCredentialsProvider{
+		path:     filepath.Join(CredentialsCacheDir, credentialsCacheName(name)+".json"),
+		provider: provider,
+	})
+	return cfg, nil
+}
+
+// loadCheckedConfig loads the config of profile, which doesn't have an mfa_serial, and retrieves its credentials if
+// they aren't static keys. The SDK picks the credentials of the environment over the default profile's unless
+// explicit is set, the same ones are checked. The SDK refreshes the SSO token of a profile with an sso_session until
+// the session ends, after that its credentials fail with an SSOSessionError.
+func loadCheckedConfig(ctx *Context, profile string, explicit bool, shared config.SharedConfig, env config.EnvConfig, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	var source string
+	switch {
+	case !explicit && env.Credentials.HasKeys():
+		return cfg, nil
+	case !explicit && env.WebIdentityTokenFilePath != "":
+		source = "the web identity token in AWS_WEB_IDENTITY_TOKEN_FILE"
+	case usesSSO(shared):
+		cfg.Credentials = aws.NewCredentialsCache(&ssoSessionProvider{profile: profile, provider: cfg.Credentials})
+		if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+			return aws.Config{}, err
+		}
+		return cfg, nil
+	default:
+		if source = credentialSource(shared); source == "" {
+			return cfg, nil
+		}
+	}
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("getting credentials from %s: %w", source, err)
+	}
+	return cfg, nil
+}
+
+// usesSSO reports whether profile or a source_profile it chains from gets its credentials from IAM Identity Center.
+func usesSSO(profile config.SharedConfig) bool {
+	for p := &profile; p != nil; p = p.Source {
+		if p.SSOSession != nil || p.SSOStartURL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// credentialSource describes the credential_process or web_identity_token_file profile or a source_profile it chains
+// from gets its credentials with, or returns an empty string if it uses neither.
+func credentialSource(profile config.SharedConfig) string {
+	for p := &profile; p != nil; p = p.Source {
+		if p.CredentialProcess != "" {
+			return "the credential_process of profile " + p.Profile
+		} else if p.WebIdentityTokenFile != "" {
+			return "the web_identity_token_file of profile " + p.Profile
+		}
+	}
+	return ""
+}
+
+// SSOSessionError is returned for the credentials of a profile using IAM Identity Center when its SSO session has
+// expired or was never started, the only fix is logging in again.
+type SSOSessionError struct {
+	Profile string
+	Err     error
+}
+
+func (e *SSOSessionError) Error() string {
+	return fmt.Sprintf("the SSO session of profile %s has expired or isn't logged in, run aws sso login --profile %s and try again: %s", e.Profile, e.Profile, e.Err)
+}
+
+func (e *SSOSessionError) Unwrap() error {
+	return e.Err
+}
+
+// ssoSessionErrorCodes are the error codes IAM Identity Center returns for an SSO token that expired or was revoked.
+var ssoSessionErrorCodes = []string{"UnauthorizedException", "InvalidGrantException", "ExpiredTokenException"}
+
+// isSSOSessionError reports whether err is from an SSO session that has to be logged in again. The SDK doesn't have
+// an error type for a missing or unrefreshable token, those are matched by their message.
+func isSSOSessionError(err error) bool {
+	var invalid *ssocreds.InvalidTokenError
+	var apiErr smithy.APIError
+	return errors.As(err, &invalid) ||
+		errors.As(err, &apiErr) && slices.Contains(ssoSessionErrorCodes, apiErr.ErrorCode()) ||
+		strings.Contains(err.Error(), "cached SSO token")
+}
+
+// ssoSessionProvider returns the errors from an expired SSO session of profile as an SSOSessionError.
+type ssoSessionProvider struct {
+	profile  string
+	provider aws.CredentialsProvider
+}
+
+func (p *ssoSessionProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil && isSSOSessionError(err) {
+		return aws.Credentials{}, &SSOSessionError{Profile: p.profile, Err: err}
+	}
+	return creds, err
+}
+
+var unsafeCacheName = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// credentialsCacheName returns the name of the cache file for profile.
+func credentialsCacheName(profile string) string {
+	return "mfa-" + unsafeCacheName.ReplaceAllString(profile, "_")
+}
+
+// mfaTokenProvider returns a function asking for the token code of the MFA device serial on stderr.
+func mfaTokenProvider(serial string) func() (string, error) {
+	return func() (string, error) {
+		fmt.Fprintf(os.Stderr, "MFA token code for %s: ", serial)
+		code, err := bufio.NewReader(MFATokenInput).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && code != "") {
+			return "", fmt.Errorf("reading MFA token code: %s", err)
+		}
+		return strings.TrimSpace(code), nil
+	}
+}
+
+type ISTSSessionTokenGetter interface {
+	GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error)
+}
+
+// sessionTokenProvider gets an MFA authenticated session for the credentials of a profile without a role_arn.
+type sessionTokenProvider struct {
+	client ISTSSessionTokenGetter
+	serial string
+	token  func() (string, error)
+}
+
+func (p *sessionTokenProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	code, err := p.token()
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	resp, err := p.client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		SerialNumber: aws.String(p.serial),
+		TokenCode:    aws.String(code),
+	})
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("getting session token: %w", err)
+	}
+	return aws.Credentials{
+		AccessKeyID:     aws.ToString(resp.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(resp.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(resp.Credentials.SessionToken),
+		Source:          "SessionTokenProvider",
+		CanExpire:       true,
+		Expires:         aws.ToTime(resp.Credentials.Expiration),
+	}, nil
+}
+
+// fileCredentialsProvider caches the credentials of provider in a file at path, they're used from the file until
+// they're about to expire.
+type fileCredentialsProvider struct {
+	path     string
+	provider aws.CredentialsProvider
+}
+
+func (p *fileCredentialsProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	if creds, ok := p.load(); ok {
+		return creds, nil
+	}
+
+	creds, err := p.provider.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	if err := p.save(creds); err != nil {
+		// The session still works, it's only asked for again on the next run.
+		fmt.Fprintf(os.Stderr, "caching credentials: %s\n", err)
+	}
+	return creds, nil
+}
+
+// load returns the cached credentials if they exist and don't expire soon.
+func (p *fileCredentialsProvider) load() (aws.Credentials, bool) {
+	path, err := ExpandPath(p.path)
+	if err != nil {
+		return aws.Credentials{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return aws.Credentials{}, false
+	}
+	var creds aws.Credentials
+	if err := json.Unmarshal(data, &creds); err != nil || !creds.HasKeys() {
+		return aws.Credentials{}, false
+	}
+	if creds.CanExpire && time.Until(creds.Expires) < credentialsExpiryWindow {
+		return aws.Credentials{}, false
+	}
+	return creds, true
+}
+
+func (p *fileCredentialsProvider) save(creds aws.Credentials) error {
+	dir, err := ExpandPath(filepath.Dir(p.path))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// Temporary files are only readable by their owner.
+	f, err := CreateAtomic(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if err := json.NewEncoder(f).Encode(creds); err != nil {
+		return err
+	}
+	return f.Commit()
+}
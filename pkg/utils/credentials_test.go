@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSTSSessionTokenGetter returns a session, recording the request for it.
+type mockSTSSessionTokenGetter struct {
+	input *sts.GetSessionTokenInput
+}
+
+func (m *mockSTSSessionTokenGetter) GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
+	m.input = params
+	return &sts.GetSessionTokenOutput{Credentials: &types.Credentials{
+		AccessKeyId:     aws.String("ASIASESSION"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(12 * time.Hour)),
+	}}, nil
+}
+
+// countingProvider returns creds, counting calls to Retrieve.
+type countingProvider struct {
+	creds aws.Credentials
+	calls int
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.calls++
+	return p.creds, nil
+}
+
+func TestSessionTokenProvider(t *testing.T) {
+	client := &mockSTSSessionTokenGetter{}
+	p := &sessionTokenProvider{
+		client: client,
+		serial: "arn:aws:iam::111111111111:mfa/alice",
+		token:  func() (string, error) { return "123456", nil },
+	}
+
+	creds, err := p.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIASESSION", creds.AccessKeyID)
+	assert.True(t, creds.CanExpire)
+	assert.Equal(t, "arn:aws:iam::111111111111:mfa/alice", aws.ToString(client.input.SerialNumber))
+	assert.Equal(t, "123456", aws.ToString(client.input.TokenCode))
+}
+
+func TestMFATokenProvider(t *testing.T) {
+	defer func(input io.Reader) { MFATokenInput = input }(MFATokenInput)
+
+	MFATokenInput = strings.NewReader(" 123456\n")
+	code, err := mfaTokenProvider("arn:aws:iam::111111111111:mfa/alice")()
+	require.NoError(t, err)
+	assert.Equal(t, "123456", code)
+
+	MFATokenInput = strings.NewReader("")
+	_, err = mfaTokenProvider("arn:aws:iam::111111111111:mfa/alice")()
+	assert.ErrorContains(t, err, "reading MFA token code")
+}
+
+func TestFileCredentialsProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "mfa-scanner.json")
+	inner := &countingProvider{creds: aws.Credentials{
+		AccessKeyID: "ASIA1", SecretAccessKey: "secret", CanExpire: true, Expires: time.Now().Add(time.Hour),
+	}}
+	p := &fileCredentialsProvider{path: path, provider: inner}
+
+	creds, err := p.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIA1", creds.AccessKeyID)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "cached credentials are only readable by their owner")
+
+	// Another run uses the cached session instead of asking for a new one.
+	again := &fileCredentialsProvider{path: path, provider: inner}
+	creds, err = again.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "ASIA1", creds.AccessKeyID)
+	assert.Equal(t, 1, inner.calls)
+
+	// Sessions about to expire aren't used.
+	inner.creds.Expires = time.Now().Add(time.Minute)
+	require.NoError(t, p.save(inner.creds))
+	_, err = again.Retrieve(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestLoadConfig_MFA(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`[profile plain]
+aws_access_key_id = AKIAPLAIN
+aws_secret_access_key = secret
+
+[profile mfa]
+aws_access_key_id = AKIAMFA
+aws_secret_access_key = secret
+mfa_serial = arn:aws:iam::111111111111:mfa/alice
+`), 0600))
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	defer func(dir string) { CredentialsCacheDir = dir }(CredentialsCacheDir)
+	CredentialsCacheDir = filepath.Join(dir, "cache")
+	ctx := NewContext(context.Background())
+
+	cfg, err := LoadConfig(ctx, "plain")
+	require.NoError(t, err)
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "AKIAPLAIN", creds.AccessKeyID, "profiles without mfa_serial are loaded as usual")
+
+	// A cached session of the MFA profile is used without asking for the token code.
+	cached, err := json.Marshal(aws.Credentials{AccessKeyID: "ASIACACHED", SecretAccessKey: "secret", SessionToken: "token", CanExpire: true, Expires: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(CredentialsCacheDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(CredentialsCacheDir, "mfa-mfa.json"), cached, 0600))
+
+	cfg, err = LoadConfig(ctx, "mfa")
+	require.NoError(t, err)
+	creds, err = cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ASIACACHED", creds.AccessKeyID)
+}
+
+func TestSSOSessionProvider(t *testing.T) {
+	var err error
+	p := &ssoSessionProvider{profile: "sso", provider: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, err
+	})}
+
+	for _, err = range []error{
+		&ssocreds.InvalidTokenError{},
+		errors.New("refresh cached SSO token failed, unable to refresh SSO token, InvalidGrantException"),
+	} {
+		_, retrieveErr := p.Retrieve(context.Background())
+		var ssoErr *SSOSessionError
+		require.ErrorAs(t, retrieveErr, &ssoErr)
+		assert.ErrorContains(t, retrieveErr, "run aws sso login --profile sso")
+		assert.True(t, IsCredentialsError(retrieveErr))
+	}
+
+	err = errors.New("dial tcp: i/o timeout")
+	_, retrieveErr := p.Retrieve(context.Background())
+	assert.Equal(t, err, retrieveErr, "other errors are returned as is")
+}
+
+func TestLoadConfig_SSO(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`[profile sso]
+sso_session = corp
+sso_account_id = 111111111111
+sso_role_name = Scanner
+region = us-east-1
+
+[profile chained]
+role_arn = arn:aws:iam::222222222222:role/Scanner
+source_profile = sso
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-1
+`), 0600))
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	// No cached SSO token, like before aws sso login.
+	t.Setenv("HOME", dir)
+	ctx := NewContext(context.Background())
+
+	for _, profile := range []string{"sso", "chained"} {
+		_, err := LoadConfig(ctx, profile)
+		var ssoErr *SSOSessionError
+		require.ErrorAs(t, err, &ssoErr, profile)
+		assert.Equal(t, profile, ssoErr.Profile)
+	}
+}
+
+func TestLoadConfig_CredentialProcess(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`[profile process]
+credential_process = echo '{"Version": 1, "AccessKeyId": "AKIAPROCESS", "SecretAccessKey": "secret"}'
+
+[profile failing]
+credential_process = false
+
+[profile chained]
+role_arn = arn:aws:iam::222222222222:role/Scanner
+source_profile = failing
+`), 0600))
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	ctx := NewContext(context.Background())
+
+	cfg, err := LoadConfig(ctx, "process")
+	require.NoError(t, err)
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "AKIAPROCESS", creds.AccessKeyID)
+
+	_, err = LoadConfig(ctx, "failing")
+	assert.ErrorContains(t, err, "getting credentials from the credential_process of profile failing")
+	_, err = LoadConfig(ctx, "chained")
+	assert.ErrorContains(t, err, "getting credentials from the credential_process of profile failing")
+
+	// A web identity token file in the environment is used without a profile.
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::111111111111:role/ci")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", filepath.Join(dir, "missing-token"))
+	_, err = LoadConfig(ctx, "")
+	assert.ErrorContains(t, err, "getting credentials from the web identity token in AWS_WEB_IDENTITY_TOKEN_FILE")
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCron_Next(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2024, 1, 3, 10, 30, 15, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 3, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 3, 10, 45, 0, 0, time.UTC)},
+		{"0 6 * * *", time.Date(2024, 1, 4, 6, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 6 * * 1", time.Date(2024, 1, 8, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * 1-5", time.Date(2024, 1, 4, 6, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 */3 *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 10,12 3 1 *", time.Date(2024, 1, 3, 12, 30, 0, 0, time.UTC)},
+		// With both day fields set, either matching is enough.
+		{"0 0 15 * 5", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, c.Next(from))
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@often"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables flags can be set with.
+const EnvPrefix = "ROLES_"
+
+// FlagEnv returns the environment variable that sets the flag with the given name, like ROLES_RATE_LIMIT for
+// -rate-limit.
+func FlagEnv(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// SetFlagsFromEnv sets every flag in fs that has its FlagEnv variable set, it's called before fs.Parse so the command
+// line takes precedence. Repeatable flags like -var get the environment variable's value first.
+func SetFlagsFromEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(FlagEnv(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %s", value, FlagEnv(f.Name), setErr)
+		}
+	})
+	return err
+}
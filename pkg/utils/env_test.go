@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	t.Setenv("ROLES_RATE_LIMIT", "20")
+	t.Setenv("ROLES_PROFILE", "scanner")
+	t.Setenv("ROLES_JSON", "true")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	rateLimit := fs.Int("rate-limit", 5, "")
+	profile := fs.String("profile", "", "")
+	json := fs.Bool("json", false, "")
+	storage := fs.String("storage", "", "")
+
+	require.NoError(t, SetFlagsFromEnv(fs))
+	require.NoError(t, fs.Parse([]string{"-profile", "other"}))
+
+	// The command line overrides the environment.
+	assert.Equal(t, 20, *rateLimit)
+	assert.Equal(t, "other", *profile)
+	assert.True(t, *json)
+	assert.Equal(t, "", *storage)
+}
+
+func TestSetFlagsFromEnv_Invalid(t *testing.T) {
+	t.Setenv("ROLES_RATE_LIMIT", "fast")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("rate-limit", 5, "")
+	err := SetFlagsFromEnv(fs)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ROLES_RATE_LIMIT")
+}
+
+func TestFlagEnv(t *testing.T) {
+	assert.Equal(t, "ROLES_SSO_PERMISSION_SETS", FlagEnv("sso-permission-sets"))
+}
@@ -1 +1,56 @@
 package utils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AtomicFile is written to a temporary file next to its path, which only replaces the path once it's committed, so
+// readers never see a partial file.
+type AtomicFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// CreateAtomic creates a temporary file that replaces path when committed, path may start with ~/.
+func CreateAtomic(path string) (*AtomicFile, error) {
+	path, err := ExpandPath(path)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicFile{File: tmp, path: path}, nil
+}
+
+// Commit closes the file and renames it to its path.
+func (f *AtomicFile) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort closes and removes the file without touching its path, it does nothing after Commit so it can be deferred.
+func (f *AtomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+
+	f.File.Close()
+	os.Remove(f.Name())
+}
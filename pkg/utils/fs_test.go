@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.json")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0o600))
+
+	f, err := CreateAtomic(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("new\n")
+	require.NoError(t, err)
+
+	// The path isn't replaced until the file is committed.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old\n", string(data))
+
+	require.NoError(t, f.Commit())
+	f.Abort()
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestAtomicFile_Abort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.json")
+
+	f, err := CreateAtomic(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("partial\n")
+	require.NoError(t, err)
+	f.Abort()
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
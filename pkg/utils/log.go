@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// logLevels are the slog level of each log level.
+var logLevels = map[LogLevel]slog.Level{
+	ErrorLogLevel: slog.LevelError,
+	InfoLogLevel:  slog.LevelInfo,
+	DebugLogLevel: slog.LevelDebug,
+}
+
+// levelPrefixes are the prefixes of each level's text logs.
+var levelPrefixes = map[slog.Level]string{
+	slog.LevelError: Red.Color("[ERROR] "),
+	slog.LevelInfo:  Green.Color("[INFO] "),
+	slog.LevelDebug: Gray.Color("[DEBUG] "),
+}
+
+// logSink is where the logs of a context and every context derived from it go, it's shared so setting the level,
+// format, or output of one sets them for all of them.
+type logSink struct {
+	mux sync.Mutex
+	out io.Writer
+	// format is text or json, see SetLogFormat.
+	format string
+	level  slog.LevelVar
+}
+
+// SetLoggingLevel sets the most verbose level that's logged, for ctx and the contexts sharing its logs.
+func (ctx *Context) SetLoggingLevel(level LogLevel) Context {
+	ctx.logs.level.Set(logLevels[level])
+	return *ctx
+}
+
+// SetLogFormat switches the logs to text, the default colored [LEVEL] lines, or json, one slog JSON object per line
+// for centralized logging.
+func (ctx *Context) SetLogFormat(format string) error {
+	switch format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown log format %q: must be text or json", format)
+	}
+	ctx.logs.mux.Lock()
+	defer ctx.logs.mux.Unlock()
+	ctx.logs.format = format
+	return nil
+}
+
+// SetLogOutput sends the logs of every level to w and returns where they went before, they go to stderr by default so
+// only results are written to stdout.
+func (ctx *Context) SetLogOutput(w io.Writer) io.Writer {
+	ctx.logs.mux.Lock()
+	defer ctx.logs.mux.Unlock()
+	prev := ctx.logs.out
+	ctx.logs.out = w
+	return prev
+}
+
+// Module returns a copy of ctx whose logs have a module field of name, so the logs of parts of a run that log at the
+// same time can be told apart and filtered.
+func (ctx *Context) Module(name string) *Context {
+	newCtx := *ctx
+	newCtx.setLogger(ctx.Logger.With("module", name))
+	return &newCtx
+}
+
+// setLogger sets ctx's structured logger and the Error, Info, and Debug loggers that log through it.
+func (ctx *Context) setLogger(logger *slog.Logger) {
+	ctx.Logger = logger
+	ctx.Error = &Logger{logger: logger, level: slog.LevelError}
+	ctx.Info = &Logger{logger: logger, level: slog.LevelInfo}
+	ctx.Debug = &Logger{logger: logger, level: slog.LevelDebug}
+}
+
+// Logger logs at one level through a slog.Logger. Printf and Println format the message like the log package does, Log
+// takes a message and structured key-value fields like slog.
+type Logger struct {
+	logger *slog.Logger
+	level  slog.Level
+}
+
+// Enabled reports whether the logger's level is logged.
+func (l *Logger) Enabled() bool {
+	return l != nil && l.logger.Enabled(context.Background(), l.level)
+}
+
+// Log logs msg with the key-value pairs or slog.Attrs in args.
+func (l *Logger) Log(msg string, args ...any) {
+	if l.Enabled() {
+		l.logger.Log(context.Background(), l.level, msg, args...)
+	}
+}
+
+// With returns a logger at the same level whose logs have the key-value pairs or slog.Attrs in args.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{logger: l.logger.With(args...), level: l.level}
+}
+
+func (l *Logger) Printf(format string, v ...any) {
+	if l.Enabled() {
+		l.Log(strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+	}
+}
+
+func (l *Logger) Println(v ...any) {
+	if l.Enabled() {
+		l.Log(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+	}
+}
+
+// Fatalf logs the message like Printf and exits with status 1.
+func (l *Logger) Fatalf(format string, v ...any) {
+	l.Printf(format, v...)
+	os.Exit(1)
+}
+
+// logHandler is the slog.Handler of a context's logs, it writes each record to the sink in its current format.
+type logHandler struct {
+	sink *logSink
+	// attrs are the fields of WithAttrs for text logs, with the keys prefixed by their groups.
+	attrs []slog.Attr
+	// group is the prefix of the keys of the record's fields in text logs.
+	group string
+	// with replays the WithAttrs and WithGroup calls on the JSON handler, which nests groups itself.
+	with []func(slog.Handler) slog.Handler
+}
+
+func newLogHandler(sink *logSink) *logHandler {
+	return &logHandler{sink: sink}
+}
+
+func (h *logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.sink.level.Level()
+}
+
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.mux.Lock()
+	defer h.sink.mux.Unlock()
+
+	if h.sink.format == "json" {
+		var handler slog.Handler = slog.NewJSONHandler(h.sink.out, &slog.HandlerOptions{Level: slog.LevelDebug})
+		for _, with := range h.with {
+			handler = with(handler)
+		}
+		return handler.Handle(ctx, r)
+	}
+
+	var b strings.Builder
+	b.WriteString(levelPrefixes[r.Level])
+	b.WriteString(r.Message)
+	for _, attr := range h.attrs {
+		writeTextAttr(&b, "", attr)
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		writeTextAttr(&b, h.group, attr)
+		return true
+	})
+	b.WriteByte('\n')
+	_, err := io.WriteString(h.sink.out, b.String())
+	return err
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append(next.attrs[:len(next.attrs):len(next.attrs)], prefixAttrs(h.group, attrs)...)
+	next.with = append(next.with[:len(next.with):len(next.with)], func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+	return &next
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.group = h.group + name + "."
+	next.with = append(next.with[:len(next.with):len(next.with)], func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+	return &next
+}
+
+// prefixAttrs returns attrs with group prefixed to their keys.
+func prefixAttrs(group string, attrs []slog.Attr) []slog.Attr {
+	result := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		result[i] = slog.Attr{Key: group + attr.Key, Value: attr.Value}
+	}
+	return result
+}
+
+// writeTextAttr writes attr as key=value, with the fields of a group written as group.key=value and values with
+// spaces or quotes quoted.
+func writeTextAttr(b *strings.Builder, group string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		prefix := group
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range value.Group() {
+			writeTextAttr(b, prefix, member)
+		}
+		return
+	}
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	s := value.String()
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		s = strconv.Quote(s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", group, attr.Key, s)
+}
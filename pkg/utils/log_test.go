@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_JSON(t *testing.T) {
+	ctx := NewContext(context.Background())
+	var buf bytes.Buffer
+	ctx.SetLogOutput(&buf)
+	require.NoError(t, ctx.SetLogFormat("json"))
+
+	ctx.Info.Printf("scanned %d of %d", 1, 2)
+	ctx.Error.Println("failed")
+	ctx.Module("scanner").Info.Log("processed", "count", 3)
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]any
+		require.NoError(t, dec.Decode(&line))
+		assert.NotEmpty(t, line["time"])
+		delete(line, "time")
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []map[string]any{
+		{"level": "INFO", "msg": "scanned 1 of 2"},
+		{"level": "ERROR", "msg": "failed"},
+		{"level": "INFO", "msg": "processed", "module": "scanner", "count": float64(3)},
+	}, lines)
+}
+
+func TestLogger_Text(t *testing.T) {
+	ctx := NewContext(context.Background())
+	var buf bytes.Buffer
+	ctx.SetLogOutput(&buf)
+	ctx.SetLoggingLevel(DebugLogLevel)
+
+	ctx.Debug.Printf("checking %s", "a")
+	ctx.Info.With("account", "111111111111").Log("requests", "regions", "us-east-1=2 us-west-2=1", "total", 3)
+	assert.Equal(t, Gray.Color("[DEBUG] ")+"checking a\n"+
+		Green.Color("[INFO] ")+"requests account=111111111111 regions=\"us-east-1=2 us-west-2=1\" total=3\n", buf.String())
+}
+
+func TestSetLoggingLevel(t *testing.T) {
+	ctx := NewContext(context.Background())
+	var buf bytes.Buffer
+	ctx.SetLogOutput(&buf)
+
+	// Contexts derived before the level changes use the new level too.
+	derived, cancel := ctx.WithCancel()
+	defer cancel()
+	module := ctx.Module("setup")
+
+	ctx.Debug.Printf("hidden")
+	ctx.SetLoggingLevel(ErrorLogLevel)
+	derived.Info.Printf("hidden")
+	module.Info.Printf("hidden")
+	assert.Empty(t, buf.String())
+	assert.False(t, derived.Info.Enabled())
+
+	ctx.SetLoggingLevel(DebugLogLevel)
+	derived.Debug.Printf("shown")
+	module.Error.Printf("shown")
+	assert.Equal(t, Gray.Color("[DEBUG] ")+"shown\n"+Red.Color("[ERROR] ")+"shown module=setup\n", buf.String())
+}
+
+func TestSetLogFormat(t *testing.T) {
+	ctx := NewContext(context.Background())
+	assert.Error(t, ctx.SetLogFormat("xml"))
+	require.NoError(t, ctx.SetLogFormat("json"))
+	assert.Equal(t, "json", ctx.logs.format)
+}
+
+func TestNewContext_Stderr(t *testing.T) {
+	// Only results go to stdout, so they can be piped while the logs stay on the terminal.
+	ctx := NewContext(context.Background())
+	ctx.SetLoggingLevel(DebugLogLevel)
+	var buf bytes.Buffer
+	assert.Equal(t, os.Stderr, ctx.SetLogOutput(&buf))
+
+	// Every level goes to the same output.
+	ctx.Error.Printf("error")
+	ctx.Info.Printf("info")
+	ctx.Debug.Printf("debug")
+	assert.Equal(t, Red.Color("[ERROR] ")+"error\n"+Green.Color("[INFO] ")+"info\n"+Gray.Color("[DEBUG] ")+"debug\n", buf.String())
+}
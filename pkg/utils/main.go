@@ -3,13 +3,14 @@ package utils
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"github.com/dlsniper/debugger"
-	"io"
-	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -38,59 +39,40 @@ func (c Color) Color(s ...string) string {
 
 type LogLevel int
 
+// NewContext returns a context that logs text at the info level to stderr.
 func NewContext(parentCtx context.Context) *Context {
 	ctx := Context{
 		Context: parentCtx,
-		Error:   log.New(os.Stderr, Red.Color("[ERROR] "), 0),
-		Info:    log.New(os.Stdout, Green.Color("[INFO] "), 0),
-		Debug:   log.New(os.Stdout, Gray.Color("[DEBUG] "), 0),
+		logs:    &logSink{out: os.Stderr, format: "text"},
 	}
-
+	ctx.setLogger(slog.New(newLogHandler(ctx.logs)))
 	ctx.SetLoggingLevel(InfoLogLevel)
 	return &ctx
 }
 
 type Context struct {
 	context.Context
-	LogLevel LogLevel
-	Error    *log.Logger
-	Info     *log.Logger
-	Debug    *log.Logger
-}
-
-func (ctx *Context) SetLoggingLevel(level LogLevel) Context {
-	ctx.LogLevel = level
-
-	if int(level) >= int(ErrorLogLevel) {
-		ctx.Error = log.New(os.Stderr, Red.Color("[ERROR] "), 0)
-	} else {
-		ctx.Error.SetOutput(io.Discard)
-	}
-
-	if int(level) >= int(InfoLogLevel) {
-		ctx.Info = log.New(os.Stderr, Green.Color("[INFO] "), 0)
-	} else {
-		ctx.Info.SetOutput(io.Discard)
-	}
-
-	if int(level) >= int(DebugLogLevel) {
-		ctx.Debug = log.New(os.Stderr, Gray.Color("[DEBUG] "), 0)
-	} else {
-		ctx.Debug.SetOutput(io.Discard)
-	}
-	return *ctx
+	// Logger is the structured logger of the context, Error, Info, and Debug log through it at their level.
+	Logger *slog.Logger
+	Error  *Logger
+	Info   *Logger
+	Debug  *Logger
+	// logs is the level, format, and output shared with the contexts derived from this one.
+	logs *logSink
 }
 
+// WithCancel returns a cancellable copy of ctx that shares its loggers, so redirecting the output of ctx's loggers
+// also redirects the logs of contexts derived from it.
 func (ctx *Context) WithCancel() (*Context, context.CancelFunc) {
 	var cancel context.CancelFunc
 	newCtx := &Context{
-		Info:  ctx.Info,
-		Debug: ctx.Debug,
-		Error: ctx.Error,
+		Logger: ctx.Logger,
+		Info:   ctx.Info,
+		Debug:  ctx.Debug,
+		Error:  ctx.Error,
+		logs:   ctx.logs,
 	}
 	newCtx.Context, cancel = context.WithCancel(ctx.Context)
-
-	newCtx.SetLoggingLevel(ctx.LogLevel)
 	return newCtx, cancel
 }
 
@@ -136,17 +118,43 @@ func ExpandPath(path string) (string, error) {
 	return filepath.Abs(path)
 }
 
+// ParseSince parses a point in time given either as a duration before now (36h, 30d) or as an RFC 3339 timestamp or
+// date (2024-01-02).
+func ParseSince(value string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a duration like 30d or 36h, or a date like 2024-01-02", value)
+}
+
 func SigTermChan() chan os.Signal {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	return sigs
 }
 
-// GetInput reads the contents of each file in the given directory.
+// GetInput reads the contents of each file in the given directory. Paths can also be http:// or https:// URLs, see
+// fetchList.
 func GetInput(paths ...string) (map[string]Info, error) {
 	var files []string
 
 	for _, path := range paths {
+		if IsURL(path) {
+			files = append(files, path)
+			continue
+		}
+
 		path, err := ExpandPath(path)
 		if err != nil {
 			return nil, err
@@ -177,7 +185,7 @@ func GetInput(paths ...string) (map[string]Info, error) {
 	results := map[string]Info{}
 
 	for _, p := range files {
-		data, err := os.ReadFile(p)
+		data, err := ReadList(p)
 		if err != nil {
 			return nil, err
 		}
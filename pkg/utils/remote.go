@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxRemoteListSize is the largest list fetched over HTTP(S).
+const maxRemoteListSize = 64 << 20
+
+// listClient fetches lists given as http:// or https:// URLs.
+var listClient = &http.Client{Timeout: 30 * time.Second}
+
+// listCacheDir is where fetched lists are cached, <user cache dir>/roles/lists if empty.
+var listCacheDir = ""
+
+// IsURL returns true if path is an http:// or https:// URL rather than a local file.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// ReadList returns the contents of a list file or http(s) URL, paths are expected to already be expanded.
+func ReadList(path string) ([]byte, error) {
+	if IsURL(path) {
+		return fetchList(path)
+	}
+	return os.ReadFile(path)
+}
+
+// fetchList returns the list at rawURL.
+//
+// A #sha256=<hex> fragment pins the list's checksum. A pinned list is read from the cache when a copy with that
+// checksum is there, otherwise it is fetched and rejected if the checksum doesn't match. Lists without a pinned
+// checksum are fetched every time, but only downloaded again when the server's ETag has changed.
+func fetchList(rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing list url: %s", err)
+	}
+
+	var pinned string
+	if u.Fragment != "" {
+		var ok bool
+		if pinned, ok = strings.CutPrefix(u.Fragment, "sha256="); !ok || len(pinned) != sha256.Size*2 {
+			return nil, fmt.Errorf("invalid checksum %q in %s: expected #sha256=<hex>", u.Fragment, rawURL)
+		}
+		pinned = strings.ToLower(pinned)
+	}
+	u.Fragment = ""
+
+	dir, err := cacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	key := sha256.Sum256([]byte(u.String()))
+	cachePath := filepath.Join(dir, hex.EncodeToString(key[:])+".list")
+	etagPath := strings.TrimSuffix(cachePath, ".list") + ".etag"
+	if pinned != "" {
+		cachePath = filepath.Join(dir, pinned+".list")
+	}
+
+	cached, cacheErr := os.ReadFile(cachePath)
+	if pinned != "" && cacheErr == nil && checksum(cached) == pinned {
+		return cached, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", u, err)
+	}
+	if etag, err := os.ReadFile(etagPath); err == nil && cacheErr == nil && pinned == "" {
+		req.Header.Set("If-None-Match", string(etag))
+	}
+
+	resp, err := listClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cacheErr == nil {
+		return cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", u, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteListSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", u, err)
+	}
+	if len(data) > maxRemoteListSize {
+		return nil, fmt.Errorf("fetching %s: larger than %d bytes", u, maxRemoteListSize)
+	}
+	if pinned != "" && checksum(data) != pinned {
+		return nil, fmt.Errorf("checksum mismatch for %s: got sha256=%s, want sha256=%s", u, checksum(data), pinned)
+	}
+
+	if err := os.WriteFile(cachePath, data, 0o600); err != nil {
+		return nil, fmt.Errorf("caching %s: %s", u, err)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && pinned == "" {
+		if err := os.WriteFile(etagPath, []byte(etag), 0o600); err != nil {
+			return nil, fmt.Errorf("caching %s: %s", u, err)
+		}
+	}
+	return data, nil
+}
+
+func cacheDir() (string, error) {
+	dir := listCacheDir
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("finding cache dir: %s", err)
+		}
+		dir = filepath.Join(userDir, "roles", "lists")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("creating cache dir: %s", err)
+	}
+	return dir, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetInput_URL(t *testing.T) {
+	listCacheDir = t.TempDir()
+	t.Cleanup(func() { listCacheDir = "" })
+
+	list := "deployer # Central list\nci-role\n"
+	requests, downloads := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/roles.list" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(list))
+	}))
+	defer server.Close()
+
+	want := map[string]Info{"deployer": {Comment: " Central list"}, "ci-role": {}}
+
+	got, err := GetInput(server.URL + "/roles.list")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Unchanged lists are revalidated with the ETag rather than downloaded again.
+	got, err = GetInput(server.URL + "/roles.list")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, downloads)
+
+	_, err = GetInput(server.URL + "/missing.list")
+	assert.ErrorContains(t, err, "404 Not Found")
+}
+
+func TestGetInput_PinnedURL(t *testing.T) {
+	listCacheDir = t.TempDir()
+	t.Cleanup(func() { listCacheDir = "" })
+
+	list := "deployer\n"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(list))
+	}))
+	defer server.Close()
+
+	pinned := server.URL + "/roles.list#sha256=" + checksum([]byte(list))
+	for range 2 {
+		got, err := GetInput(pinned)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]Info{"deployer": {}}, got)
+	}
+	// The second read is served from the cache.
+	assert.Equal(t, 1, requests)
+
+	_, err := GetInput(server.URL + "/other.list#sha256=" + checksum([]byte("something else")))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	_, err = GetInput(server.URL + "/roles.list#md5=abc")
+	assert.ErrorContains(t, err, "invalid checksum")
+}
@@ -1,5 +1,11 @@
 package utils
 
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
 type PolicyDocument struct {
 	Version   string            `json:"Version"`
 	Statement []PolicyStatement `json:"Statement"`
@@ -17,7 +23,61 @@ type PolicyPrincipal struct {
 	AWS string `json:"AWS"`
 }
 
+// Info describes a scan candidate, and once scanned, the stored result for it.
 type Info struct {
-	Comment string
-	Exists  bool
+	Comment string `json:"comment,omitempty"`
+	Exists  bool   `json:"exists"`
+
+	// Plugin is the name of the plugin instance that produced the verdict.
+	Plugin string `json:"plugin,omitempty"`
+	// FirstSeen is when the current verdict was first recorded, it is reset when the verdict changes.
+	FirstSeen time.Time `json:"first_seen"`
+	// LastChecked is when the principal was last scanned.
+	LastChecked time.Time `json:"last_checked"`
+
+	// History holds the verdicts this one replaced, oldest first.
+	History []StatusChange `json:"history,omitempty"`
+
+	// Tags and Likelihood are set by structured input files and carried through to the stored result.
+	Tags       []string `json:"tags,omitempty"`
+	Likelihood float64  `json:"likelihood,omitempty"`
+	// Regions limits the regions a candidate's template is executed for, it's only used while generating candidates.
+	Regions []string `json:"-"`
+	// Vars are variable lists only this candidate's template is executed with, like a wordlist pack's, variables set
+	// by the user take precedence.
+	Vars map[string][]string `json:"-"`
+}
+
+// StatusChange is a previous verdict for a principal and the period it was observed.
+type StatusChange struct {
+	Exists      bool      `json:"exists"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastChecked time.Time `json:"last_checked"`
+}
+
+// At returns the verdict that was current at t, known is false if the principal hadn't been scanned yet.
+//
+// Results stored before timestamps were recorded are treated as having always been current.
+func (i Info) At(t time.Time) (exists bool, known bool) {
+	if !i.FirstSeen.After(t) {
+		return i.Exists, true
+	}
+	for _, change := range slices.Backward(i.History) {
+		if !change.FirstSeen.After(t) {
+			return change.Exists, true
+		}
+	}
+	return false, false
+}
+
+// UnmarshalJSON also accepts the bare true/false values older versions of the storage file used.
+func (i *Info) UnmarshalJSON(data []byte) error {
+	var exists bool
+	if err := json.Unmarshal(data, &exists); err == nil {
+		*i = Info{Exists: exists}
+		return nil
+	}
+
+	type info Info
+	return json.Unmarshal(data, (*info)(i))
 }
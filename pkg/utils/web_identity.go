@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// GitHub Actions sets these in jobs with the id-token: write permission, a request to the URL with the token returns
+// the job's OIDC token.
+const (
+	githubActionsTokenURLEnv     = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	githubActionsTokenRequestEnv = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+)
+
+// githubActionsAudience is the audience of the OIDC token, the one the IAM OIDC provider for GitHub Actions expects.
+const githubActionsAudience = "sts.amazonaws.com"
+
+// usesGitHubActionsOIDC reports whether the environment is a GitHub Actions job that can get an OIDC token and has an
+// AWS_ROLE_ARN to assume with it, without other credentials or a token file in the environment.
+func usesGitHubActionsOIDC(env config.EnvConfig) bool {
+	return env.RoleARN != "" && env.WebIdentityTokenFilePath == "" && !env.Credentials.HasKeys() &&
+		os.Getenv(githubActionsTokenURLEnv) != "" && os.Getenv(githubActionsTokenRequestEnv) != ""
+}
+
+// loadGitHubActionsConfig loads the config with credentials from assuming env.RoleARN with the OIDC token of the
+// GitHub Actions job, a new token is requested each time the session is refreshed. The session name is
+// AWS_ROLE_SESSION_NAME if it's set.
+func loadGitHubActionsConfig(ctx *Context, env config.EnvConfig, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	retriever := &githubActionsTokenRetriever{
+		client: &http.Client{Timeout: 30 * time.Second},
+		url:    os.Getenv(githubActionsTokenURLEnv),
+		token:  os.Getenv(githubActionsTokenRequestEnv),
+	}
+	cfg.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(sts.NewFromConfig(cfg), env.RoleARN, retriever, func(o *stscreds.WebIdentityRoleOptions) {
+		o.RoleSessionName = "role-scanner"
+		if env.RoleSessionName != "" {
+			o.RoleSessionName = env.RoleSessionName
+		}
+	}))
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("getting credentials from the GitHub Actions OIDC token: %w", err)
+	}
+	return cfg, nil
+}
+
+// githubActionsTokenRetriever gets the OIDC token of a GitHub Actions job for AssumeRoleWithWebIdentity, so CI can
+// assume a role without static keys or writing the token to a file.
+type githubActionsTokenRetriever struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func (r *githubActionsTokenRetriever) GetIdentityToken() ([]byte, error) {
+	u, err := url.Parse(r.url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", githubActionsTokenURLEnv, err)
+	}
+	query := u.Query()
+	query.Set("audience", githubActionsAudience)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting GitHub Actions OIDC token: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requesting GitHub Actions OIDC token: %s, does the job have the id-token: write permission?", resp.Status)
+	}
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("parsing GitHub Actions OIDC token: %s", err)
+	}
+	if body.Value == "" {
+		return nil, fmt.Errorf("GitHub Actions returned an empty OIDC token")
+	}
+	return []byte(body.Value), nil
+}
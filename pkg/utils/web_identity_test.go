@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubActionsTokenRetriever(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert.Equal(t, "sts.amazonaws.com", r.URL.Query().Get("audience"))
+		assert.Equal(t, "1", r.URL.Query().Get("api-version"), "the URL's own query is kept")
+		w.Write([]byte(`{"count": 1, "value": "eyJhbGciOi.oidc.token"}`))
+	}))
+	defer server.Close()
+
+	r := &githubActionsTokenRetriever{client: server.Client(), url: server.URL + "/token?api-version=1", token: "request-token"}
+	token, err := r.GetIdentityToken()
+	require.NoError(t, err)
+	assert.Equal(t, "eyJhbGciOi.oidc.token", string(token))
+
+	r.token = "wrong"
+	_, err = r.GetIdentityToken()
+	assert.ErrorContains(t, err, "id-token: write")
+}
+
+func TestUsesGitHubActionsOIDC(t *testing.T) {
+	t.Setenv(githubActionsTokenURLEnv, "https://token.actions.githubusercontent.com/token")
+	t.Setenv(githubActionsTokenRequestEnv, "request-token")
+
+	env := config.EnvConfig{RoleARN: "arn:aws:iam::111111111111:role/ci"}
+	assert.True(t, usesGitHubActionsOIDC(env))
+
+	env.WebIdentityTokenFilePath = "/tmp/token"
+	assert.False(t, usesGitHubActionsOIDC(env), "the SDK uses the token file")
+
+	t.Setenv(githubActionsTokenRequestEnv, "")
+	assert.False(t, usesGitHubActionsOIDC(config.EnvConfig{RoleARN: "arn:aws:iam::111111111111:role/ci"}), "the job doesn't have id-token: write")
+}